@@ -30,6 +30,8 @@ type (
 		Scope            string                       `json:"scope"`
 		Title            string                       `json:"title,omitempty"`
 		Entrypoint       bool                         `json:"entrypoint,omitempty"`
+		Memoize          *bool                        `json:"memoize,omitempty"`
+		Cache            *bool                        `json:"cache,omitempty"`
 		Description      string                       `json:"description,omitempty"`
 		Organizations    []string                     `json:"organizations,omitempty"`
 		RelatedResources []*RelatedResourceAnnotation `json:"related_resources,omitempty"`
@@ -142,6 +144,14 @@ func (a *Annotations) Compare(other *Annotations) int {
 		return -1
 	}
 
+	if cmp := compareOptionalBools(a.Memoize, other.Memoize); cmp != 0 {
+		return cmp
+	}
+
+	if cmp := compareOptionalBools(a.Cache, other.Cache); cmp != 0 {
+		return cmp
+	}
+
 	if cmp := util.Compare(a.Custom, other.Custom); cmp != 0 {
 		return cmp
 	}
@@ -287,10 +297,37 @@ func compareStringLists(a, b []string) int {
 	return 0
 }
 
+func compareOptionalBools(a, b *bool) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	case *a == *b:
+		return 0
+	case *a:
+		return 1
+	default:
+		return -1
+	}
+}
+
 // Copy returns a deep copy of s.
 func (a *Annotations) Copy(node Node) *Annotations {
 	cpy := *a
 
+	if a.Memoize != nil {
+		memoize := *a.Memoize
+		cpy.Memoize = &memoize
+	}
+
+	if a.Cache != nil {
+		c := *a.Cache
+		cpy.Cache = &c
+	}
+
 	cpy.Organizations = make([]string, len(a.Organizations))
 	copy(cpy.Organizations, a.Organizations)
 
@@ -336,6 +373,14 @@ func (a *Annotations) toObject() (*Object, *Error) {
 		obj.Insert(StringTerm("entrypoint"), BooleanTerm(true))
 	}
 
+	if a.Memoize != nil {
+		obj.Insert(StringTerm("memoize"), BooleanTerm(*a.Memoize))
+	}
+
+	if a.Cache != nil {
+		obj.Insert(StringTerm("cache"), BooleanTerm(*a.Cache))
+	}
+
 	if len(a.Description) > 0 {
 		obj.Insert(StringTerm("description"), StringTerm(a.Description))
 	}
@@ -157,6 +157,11 @@ var DefaultBuiltins = [...]*Builtin{
 	YAMLIsValid,
 	HexEncode,
 	HexDecode,
+	CBOREncode,
+	CBORDecode,
+	MessagePackEncode,
+	MessagePackDecode,
+	ProtobufDecode,
 
 	// Object Manipulation
 	ObjectUnion,
@@ -171,6 +176,11 @@ var DefaultBuiltins = [...]*Builtin{
 	JSONFilter,
 	JSONRemove,
 	JSONPatch,
+	JSONDiff,
+	JSONMergePatch,
+	JSONVerifySchema,
+	JSONMatchSchema,
+	JSONPathQuery,
 
 	// Tokens
 	JWTDecode,
@@ -183,12 +193,16 @@ var DefaultBuiltins = [...]*Builtin{
 	JWTVerifyES256,
 	JWTVerifyES384,
 	JWTVerifyES512,
+	JWTVerifyEdDSA,
 	JWTVerifyHS256,
 	JWTVerifyHS384,
 	JWTVerifyHS512,
 	JWTDecodeVerify,
+	JWTDecodeVerifyDetails,
 	JWTEncodeSignRaw,
 	JWTEncodeSign,
+	JWEDecrypt,
+	JWEEncrypt,
 
 	// Time
 	NowNanos,
@@ -201,15 +215,25 @@ var DefaultBuiltins = [...]*Builtin{
 	Weekday,
 	AddDate,
 	Diff,
+	TimeTruncate,
+	TimeRound,
+	TimeStartOf,
+	TimeEndOf,
+	TimeParseISO8601Duration,
+	TimeParseISO8601Interval,
+	TimeCronMatch,
+	TimeCronNext,
 
 	// Crypto
 	CryptoX509ParseCertificates,
 	CryptoX509ParseAndVerifyCertificates,
+	CryptoX509ParseAndVerifyCertificatesWithOptions,
 	CryptoMd5,
 	CryptoSha1,
 	CryptoSha256,
 	CryptoX509ParseCertificateRequest,
 	CryptoX509ParseRSAPrivateKey,
+	CryptoParseKeys,
 	CryptoHmacMd5,
 	CryptoHmacSha1,
 	CryptoHmacSha256,
@@ -219,6 +243,10 @@ var DefaultBuiltins = [...]*Builtin{
 	WalkBuiltin,
 	ReachableBuiltin,
 	ReachablePathsBuiltin,
+	GraphTopologicalSort,
+	GraphStronglyConnectedComponents,
+	GraphShortestPath,
+	GraphTransitiveReduction,
 
 	// Sort
 	Sort,
@@ -267,6 +295,9 @@ var DefaultBuiltins = [...]*Builtin{
 	NetCIDRMerge,
 	NetLookupIPAddr,
 	NetCIDRIsValid,
+	NetURLParse,
+	NetURLNormalize,
+	NetURLBuild,
 
 	// Glob
 	GlobMatch,
@@ -282,6 +313,9 @@ var DefaultBuiltins = [...]*Builtin{
 	// SemVers
 	SemVerIsValid,
 	SemVerCompare,
+	SemVerParse,
+	SemVerSatisfies,
+	SemVerMaxSatisfying,
 
 	// Printing
 	Print,
@@ -1500,6 +1534,118 @@ var JSONPatch = &Builtin{
 	Categories: objectCat,
 }
 
+var JSONDiff = &Builtin{
+	Name: "json.diff",
+	Description: "Returns a JSON-Patch (RFC6902) that turns one document into another, such that `json.patch(a, json.diff(a, b))` is `b`. " +
+		"For example: `json.diff({\"a\": 1, \"b\": [1, 2]}, {\"b\": [1, 3]})` results in `[{\"op\": \"remove\", \"path\": \"/a\"}, {\"op\": \"replace\", \"path\": \"/b/1\", \"value\": 3}]`. " +
+		"Objects and arrays are compared recursively, keeping as many array elements in place as possible. " +
+		"Sets, and objects with non-string keys, are replaced as a whole.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("a", types.A).Description("the original document"),
+			types.Named("b", types.A).Description("the updated document"),
+		),
+		types.Named("output", types.NewArray(
+			nil,
+			types.NewObject(
+				[]*types.StaticProperty{
+					{Key: "op", Value: types.S},
+					{Key: "path", Value: types.S},
+				},
+				types.NewDynamicProperty(types.A, types.A),
+			),
+		)).Description("patch operations with JSON pointer paths that turn `a` into `b`"),
+	),
+	Categories: objectCat,
+}
+
+var JSONMergePatch = &Builtin{
+	Name: "json.merge_patch",
+	Description: "Applies a JSON Merge Patch (RFC7386) to a document. " +
+		"Objects in the patch are merged into the document recursively, and `null` values remove keys. " +
+		"For example: `json.merge_patch({\"a\": {\"b\": 1, \"c\": 2}}, {\"a\": {\"b\": null, \"d\": 3}})` results in `{\"a\": {\"c\": 2, \"d\": 3}}`. " +
+		"Any other patch replaces the document.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("doc", types.A).Description("the document to patch"),
+			types.Named("patch", types.A).Description("the merge patch"),
+		),
+		types.Named("output", types.A).Description("result of applying `patch` to `doc`"),
+	),
+	Categories: objectCat,
+}
+
+var JSONVerifySchema = &Builtin{
+	Name: "json.verify_schema",
+	Description: "Checks that the input is a valid JSON schema object. " +
+		"The schema can be either a JSON string or a JSON object. " +
+		"Remote references (`$ref`) are not loaded.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("schema", types.NewAny(types.S, types.NewObject(nil, types.NewDynamicProperty(types.A, types.A)))).
+				Description("the schema to verify"),
+		),
+		types.Named("output", types.NewArray([]types.Type{
+			types.B,
+			types.NewAny(types.S, types.NewNull()),
+		}, nil)).
+			Description("`output` is of the form `[valid, error]`. If the schema is valid, then `valid` is `true`, and `error` is `null`. Otherwise, `valid` is `false` and `error` is a string describing the error."),
+	),
+	Categories: objectCat,
+}
+
+var JSONMatchSchema = &Builtin{
+	Name: "json.match_schema",
+	Description: "Checks that the document matches the JSON schema. " +
+		"Recently used compiled schemas are cached across evaluations.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("document", types.A).
+				Description("document to verify by schema"),
+			types.Named("schema", types.NewAny(types.S, types.NewObject(nil, types.NewDynamicProperty(types.A, types.A)))).
+				Description("schema to verify document by"),
+		),
+		types.Named("output", types.NewArray([]types.Type{
+			types.B,
+			types.NewArray(
+				nil, types.NewObject(
+					[]*types.StaticProperty{
+						{Key: "error", Value: types.S},
+						{Key: "type", Value: types.S},
+						{Key: "field", Value: types.S},
+						{Key: "desc", Value: types.S},
+					},
+					nil,
+				),
+			),
+		}, nil)).
+			Description("`output` is of the form `[match, errors]`. If the document is valid given the schema, then `match` is `true`, and `errors` is an empty array. Otherwise, `match` is `false` and `errors` is an array of objects describing the error(s): the `error` message, its `type`, the JSON pointer of the `field` it was found at, and its `desc`ription."),
+	),
+	Categories: objectCat,
+}
+
+var JSONPathQuery = &Builtin{
+	Name: "jsonpath.query",
+	Description: "Selects the values of a document matched by a JSONPath query, as described in RFC 9535. " +
+		"Compiled queries are cached across evaluations.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("document", types.A).Description("document to query"),
+			types.Named("query", types.S).Description("JSONPath query, e.g. `$.store.book[?@.price < 10].title`"),
+		),
+		types.Named("output", types.NewArray(
+			nil, types.NewObject(
+				[]*types.StaticProperty{
+					{Key: "path", Value: types.NewArray(nil, types.A)},
+					{Key: "value", Value: types.A},
+				},
+				nil,
+			),
+		)).Description("the matches in document order, as objects with the `path` of the matched `value` in `document`"),
+	),
+	Categories: objectCat,
+}
+
 var ObjectSubset = &Builtin{
 	Name: "object.subset",
 	Description: "Determines if an object `sub` is a subset of another object `super`." +
@@ -1865,6 +2011,68 @@ var HexDecode = &Builtin{
 	Categories: encoding,
 }
 
+var CBOREncode = &Builtin{
+	Name:        "cbor.encode",
+	Description: "Serializes the input term to CBOR, with the deterministic encoding of RFC 8949. Sets are encoded as arrays.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.A).Description("the term to serialize"),
+		),
+		types.Named("y", types.S).Description("the base64 encoded CBOR representation of `x`"),
+	),
+	Categories: encoding,
+}
+
+var CBORDecode = &Builtin{
+	Name:        "cbor.decode",
+	Description: "Deserializes a CBOR data item. Byte strings become base64url encoded strings without padding, or as hinted by tags 22 and 23.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.S).Description("base64 encoded CBOR data item"),
+		),
+		types.Named("y", types.A).Description("the term deserialized from `x`"),
+	),
+	Categories: encoding,
+}
+
+var MessagePackEncode = &Builtin{
+	Name:        "msgpack.encode",
+	Description: "Serializes the input term to MessagePack. Sets are encoded as arrays, and map keys are sorted.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.A).Description("the term to serialize"),
+		),
+		types.Named("y", types.S).Description("the base64 encoded MessagePack representation of `x`"),
+	),
+	Categories: encoding,
+}
+
+var MessagePackDecode = &Builtin{
+	Name:        "msgpack.decode",
+	Description: "Deserializes a MessagePack object. Binary data becomes base64url encoded strings without padding, and timestamps become RFC 3339 strings.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.S).Description("base64 encoded MessagePack object"),
+		),
+		types.Named("y", types.A).Description("the term deserialized from `x`"),
+	),
+	Categories: encoding,
+}
+
+var ProtobufDecode = &Builtin{
+	Name:        "protobuf.decode",
+	Description: "Deserializes a protobuf message, described by a `FileDescriptorSet` including its imports, e.g. from `protoc --include_imports --descriptor_set_out`. The message is returned in the proto3 JSON mapping.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("descriptors", types.S).Description("base64 encoded `FileDescriptorSet`"),
+			types.Named("message_type", types.S).Description("full name of the message type, e.g. `acme.v1.Device`"),
+			types.Named("x", types.S).Description("base64 encoded message"),
+		),
+		types.Named("y", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("the message deserialized from `x`"),
+	),
+	Categories: encoding,
+}
+
 /**
  * Tokens
  */
@@ -2003,6 +2211,19 @@ var JWTVerifyES512 = &Builtin{
 	Categories: tokensCat,
 }
 
+var JWTVerifyEdDSA = &Builtin{
+	Name:        "io.jwt.verify_eddsa",
+	Description: "Verifies if an EdDSA (Ed25519) JWT signature is valid.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("jwt", types.S).Description("JWT token whose signature is to be verified"),
+			types.Named("certificate", types.S).Description("PEM encoded certificate, PEM encoded public key, or the JWK key (set) used to verify the signature"),
+		),
+		types.Named("result", types.B).Description("`true` if the signature is valid, `false` otherwise"),
+	),
+	Categories: tokensCat,
+}
+
 var JWTVerifyHS256 = &Builtin{
 	Name:        "io.jwt.verify_hs256",
 	Description: "Verifies if a HS256 (secret) JWT signature is valid.",
@@ -2046,7 +2267,7 @@ var JWTVerifyHS512 = &Builtin{
 var JWTDecodeVerify = &Builtin{
 	Name: "io.jwt.decode_verify",
 	Description: `Verifies a JWT signature under parameterized constraints and decodes the claims if it is valid.
-Supports the following algorithms: HS256, HS384, HS512, RS256, RS384, RS512, ES256, ES384, ES512, PS256, PS384 and PS512.`,
+Supports the following algorithms: HS256, HS384, HS512, RS256, RS384, RS512, ES256, ES384, ES512, PS256, PS384, PS512 and EdDSA.`,
 	Decl: types.NewFunction(
 		types.Args(
 			types.Named("jwt", types.S).Description("JWT token whose signature is to be verified and whose claims are to be checked"),
@@ -2062,6 +2283,21 @@ Supports the following algorithms: HS256, HS384, HS512, RS256, RS384, RS512, ES2
 	Nondeterministic: true,
 }
 
+var JWTDecodeVerifyDetails = &Builtin{
+	Name: "io.jwt.decode_verify_details",
+	Description: `Verifies a JWT signature under parameterized constraints like ` + "`io.jwt.decode_verify`" + `, and tells why verification failed.
+The ` + "`reason`" + ` of a failed verification is one of ` + "`header`" + `, ` + "`algorithm`" + `, ` + "`key`" + `, ` + "`signature`" + `, ` + "`issuer`" + `, ` + "`audience`" + `, ` + "`expired`" + ` and ` + "`not_before`" + `.`,
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("jwt", types.S).Description("JWT token whose signature is to be verified and whose claims are to be checked"),
+			types.Named("constraints", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("claim verification constraints"),
+		),
+		types.Named("output", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("object with the boolean `valid`, the `header` and, if the signature is valid, the `payload` of the token; if `valid` is `false`, the `reason` and a `message` saying why"),
+	),
+	Categories:       tokensCat,
+	Nondeterministic: true,
+}
+
 var tokenSign = category("tokensign")
 
 // Marked non-deterministic because it relies on RNG internally.
@@ -2096,6 +2332,38 @@ var JWTEncodeSign = &Builtin{
 	Nondeterministic: true,
 }
 
+// Marked non-deterministic because it relies on RNG internally.
+var JWEEncrypt = &Builtin{
+	Name:        "io.jwe.encrypt",
+	Description: "Encrypts a payload as a JSON Web Encryption (JWE) in compact serialization.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("headers", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("JWE Protected Header, which must name the key management algorithm (`alg`) and the content encryption algorithm (`enc`)"),
+			types.Named("payload", types.S).Description("plaintext to encrypt, e.g., a signed JWT"),
+			types.Named("key", types.NewAny(types.S, types.NewObject(nil, types.NewDynamicProperty(types.S, types.A)))).Description("JSON Web Key (RFC7517) of the recipient, as object or JSON encoded string"),
+		),
+		types.Named("output", types.S).Description("encrypted JWE"),
+	),
+	Categories:       tokenSign,
+	Nondeterministic: true,
+}
+
+var JWEDecrypt = &Builtin{
+	Name:        "io.jwe.decrypt",
+	Description: "Decrypts a JSON Web Encryption (JWE) in compact serialization.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("jwe", types.S).Description("JWE to decrypt"),
+			types.Named("keys", types.NewAny(types.S, types.NewObject(nil, types.NewDynamicProperty(types.S, types.A)))).Description("JSON Web Key (set) used to decrypt the JWE, as object or JSON encoded string; if the JWE header has a `kid`, only the key with that ID is used"),
+		),
+		types.Named("output", types.NewArray([]types.Type{
+			types.NewObject(nil, types.NewDynamicProperty(types.S, types.A)),
+			types.S,
+		}, nil)).Description("`[header, payload]`: the JWE Protected Header and the decrypted payload"),
+	),
+	Categories: tokensCat,
+}
+
 /**
  * Time
  */
@@ -2235,6 +2503,128 @@ var Diff = &Builtin{
 	),
 }
 
+var TimeTruncate = &Builtin{
+	Name:        "time.truncate",
+	Description: "Returns the nanoseconds since epoch of the time rounded down to a multiple of a duration on the wall clock of its timezone. Durations that divide a day are aligned to midnight, and durations that divide a week to Monday midnight. `undefined` if the result would be outside the valid time range that can fit within an `int64`.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.NewAny(
+				types.N,
+				types.NewArray([]types.Type{types.N, types.S}, nil),
+			)).Description("a number representing the nanoseconds since the epoch (UTC); or a two-element array of the nanoseconds, and a timezone string"),
+			types.Named("duration", types.S).Description("a positive duration like \"15m\"; see the [Go `time` package documentation](https://golang.org/pkg/time/#ParseDuration) for more details"),
+		),
+		types.Named("ns", types.N).Description("nanoseconds since the epoch of `x` truncated to a multiple of `duration`"),
+	),
+}
+
+var TimeRound = &Builtin{
+	Name:        "time.round",
+	Description: "Returns the nanoseconds since epoch of the time rounded to the nearest multiple of a duration on the wall clock of its timezone, rounding halfway values up. Durations that divide a day are aligned to midnight, and durations that divide a week to Monday midnight. `undefined` if the result would be outside the valid time range that can fit within an `int64`.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.NewAny(
+				types.N,
+				types.NewArray([]types.Type{types.N, types.S}, nil),
+			)).Description("a number representing the nanoseconds since the epoch (UTC); or a two-element array of the nanoseconds, and a timezone string"),
+			types.Named("duration", types.S).Description("a positive duration like \"1h\"; see the [Go `time` package documentation](https://golang.org/pkg/time/#ParseDuration) for more details"),
+		),
+		types.Named("ns", types.N).Description("nanoseconds since the epoch of `x` rounded to a multiple of `duration`"),
+	),
+}
+
+var TimeStartOf = &Builtin{
+	Name:        "time.start_of",
+	Description: "Returns the nanoseconds since epoch of the start of the second, minute, hour, day, week, month or year of the time in its timezone. Weeks start on Monday. `undefined` if the result would be outside the valid time range that can fit within an `int64`.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.NewAny(
+				types.N,
+				types.NewArray([]types.Type{types.N, types.S}, nil),
+			)).Description("a number representing the nanoseconds since the epoch (UTC); or a two-element array of the nanoseconds, and a timezone string"),
+			types.Named("unit", types.S).Description("one of `second`, `minute`, `hour`, `day`, `week`, `month` or `year`"),
+		),
+		types.Named("ns", types.N).Description("nanoseconds since the epoch of the first instant of the `unit` containing `x`"),
+	),
+}
+
+var TimeEndOf = &Builtin{
+	Name:        "time.end_of",
+	Description: "Returns the nanoseconds since epoch of the end of the second, minute, hour, day, week, month or year of the time in its timezone. Weeks start on Monday. `undefined` if the result would be outside the valid time range that can fit within an `int64`.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.NewAny(
+				types.N,
+				types.NewArray([]types.Type{types.N, types.S}, nil),
+			)).Description("a number representing the nanoseconds since the epoch (UTC); or a two-element array of the nanoseconds, and a timezone string"),
+			types.Named("unit", types.S).Description("one of `second`, `minute`, `hour`, `day`, `week`, `month` or `year`"),
+		),
+		types.Named("ns", types.N).Description("nanoseconds since the epoch of the last nanosecond of the `unit` containing `x`"),
+	),
+}
+
+var TimeParseISO8601Duration = &Builtin{
+	Name:        "time.parse_iso8601_duration",
+	Description: "Returns the components of an ISO 8601 duration like `P1Y2M10DT2H30M` or `P2W`. Weeks are returned as days, and durations may be negated with a leading `-`. Only seconds may have a fraction.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("duration", types.S).Description("ISO 8601 duration"),
+		),
+		types.Named("components", types.NewObject(
+			[]*types.StaticProperty{
+				types.NewStaticProperty("years", types.N),
+				types.NewStaticProperty("months", types.N),
+				types.NewStaticProperty("days", types.N),
+				types.NewStaticProperty("hours", types.N),
+				types.NewStaticProperty("minutes", types.N),
+				types.NewStaticProperty("seconds", types.N),
+			},
+			nil,
+		)).Description("object of the `years`, `months`, `days`, `hours`, `minutes` and `seconds` of `duration`"),
+	),
+}
+
+var TimeParseISO8601Interval = &Builtin{
+	Name:        "time.parse_iso8601_interval",
+	Description: "Returns the start and end of an ISO 8601 time interval of the form `start/end`, `start/duration` or `duration/end`. Times are RFC 3339 timestamps, or dates standing for midnight UTC. Years, months and days of durations are added to the date in the UTC offset of the time. `undefined` if the result would be outside the valid time range that can fit within an `int64`.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("interval", types.S).Description("ISO 8601 time interval"),
+		),
+		types.Named("output", types.NewArray([]types.Type{types.N, types.N}, nil)).Description("`[start, end]` of `interval` in nanoseconds since the epoch"),
+	),
+}
+
+var TimeCronMatch = &Builtin{
+	Name:        "time.cron_match",
+	Description: "Returns true if a cron expression fires at the minute of the time, on the wall clock of its timezone.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.NewAny(
+				types.N,
+				types.NewArray([]types.Type{types.N, types.S}, nil),
+			)).Description("a number representing the nanoseconds since the epoch (UTC); or a two-element array of the nanoseconds, and a timezone string"),
+			types.Named("expr", types.S).Description("cron expression with five fields, like `*/15 9-17 * * MON-FRI`, or a descriptor like `@daily`"),
+		),
+		types.Named("result", types.B).Description("`true` if `expr` fires at `x`"),
+	),
+}
+
+var TimeCronNext = &Builtin{
+	Name:        "time.cron_next",
+	Description: "Returns the nanoseconds since epoch of the next time after the time that a cron expression fires, on the wall clock of its timezone. `undefined` if the expression never fires, or if the result would be outside the valid time range that can fit within an `int64`.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.NewAny(
+				types.N,
+				types.NewArray([]types.Type{types.N, types.S}, nil),
+			)).Description("a number representing the nanoseconds since the epoch (UTC); or a two-element array of the nanoseconds, and a timezone string"),
+			types.Named("expr", types.S).Description("cron expression with five fields, like `0 9 * * MON-FRI`, or a descriptor like `@daily`"),
+		),
+		types.Named("ns", types.N).Description("nanoseconds since the epoch of the first time after `x` that `expr` fires"),
+	),
+}
+
 /**
  * Crypto.
  */
@@ -2269,6 +2659,28 @@ with all others being treated as intermediates.`,
 	),
 }
 
+var CryptoX509ParseAndVerifyCertificatesWithOptions = &Builtin{
+	Name: "crypto.x509.parse_and_verify_certificates_with_options",
+	Description: `Returns one or more certificates from the given string containing PEM
+or base64 encoded DER certificates after verifying the supplied certificates form a complete
+certificate chain back to a trusted root, using the given verification options.
+
+If the ` + "`roots`" + ` option is set, the last certificate is treated as the leaf and all others
+as intermediates. Otherwise, the first certificate is treated as the root, like in
+` + "`crypto.x509.parse_and_verify_certificates`" + `.`,
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("certs", types.S).Description("base64 encoded DER or PEM data containing one or more certificates where the last is a leaf certificate"),
+			types.Named("options", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("object with the optional `roots` and `intermediates` certificates, the required `key_usages`, the `dns_name` and `uri` the leaf must be valid for, the verification `time` in nanoseconds since epoch, and the `crls` to check revocation against"),
+		),
+		types.Named("output", types.NewArray([]types.Type{
+			types.B,
+			types.NewArray(nil, types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))),
+		}, nil)).Description("array of `[valid, certs]`: if the input certificate chain could be verified then `valid` is `true` and `certs` is an array of X.509 certificates represented as objects; if the input certificate chain could not be verified then `valid` is `false` and `certs` is `[]`"),
+	),
+	Nondeterministic: true,
+}
+
 var CryptoX509ParseCertificateRequest = &Builtin{
 	Name:        "crypto.x509.parse_certificate_request",
 	Description: "Returns a PKCS #10 certificate signing request from the given PEM-encoded PKCS#10 certificate signing request.",
@@ -2291,6 +2703,17 @@ var CryptoX509ParseRSAPrivateKey = &Builtin{
 	),
 }
 
+var CryptoParseKeys = &Builtin{
+	Name:        "crypto.parse_keys",
+	Description: "Returns JWKs from the given PEM encoded keys, or JWK (set). RSA, EC and Ed25519 public and private keys are supported.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("keys", types.S).Description("PEM string or base64 encoded PEM string containing one or more keys or certificates, or a JSON encoded JWK (set)"),
+		),
+		types.Named("output", types.NewArray(nil, types.NewObject(nil, types.NewDynamicProperty(types.S, types.A)))).Description("JWKs as objects"),
+	),
+}
+
 var CryptoMd5 = &Builtin{
 	Name:        "crypto.md5",
 	Description: "Returns a string representing the input string hashed with the MD5 function",
@@ -2436,6 +2859,68 @@ var ReachablePathsBuiltin = &Builtin{
 	),
 }
 
+// graphType is the adjacency object accepted by the graph builtins.
+var graphType = types.NewObject(
+	nil,
+	types.NewDynamicProperty(
+		types.A,
+		types.NewAny(
+			types.NewSet(types.A),
+			types.NewArray(nil, types.A)),
+	))
+
+var GraphTopologicalSort = &Builtin{
+	Name:        "graph.topological_sort",
+	Description: "Sorts the vertices of a directed graph so that every vertex comes before its neighbors, and reports the cycles that prevent this. Vertices that are not ordered by the edges are sorted by value.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("graph", graphType).Description("object containing a set or array of neighboring vertices"),
+		),
+		types.Named("output", types.NewObject(
+			[]*types.StaticProperty{
+				types.NewStaticProperty("order", types.NewArray(nil, types.A)),
+				types.NewStaticProperty("cycles", types.NewArray(nil, types.NewArray(nil, types.A))),
+			},
+			nil,
+		)).Description("object with the sorted vertices in `order`, and in `cycles` one cycle for each group of vertices left out of `order` by cycles"),
+	),
+}
+
+var GraphStronglyConnectedComponents = &Builtin{
+	Name:        "graph.strongly_connected_components",
+	Description: "Computes the strongly connected components of a directed graph: the groups of vertices that can all reach each other.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("graph", graphType).Description("object containing a set or array of neighboring vertices"),
+		),
+		types.Named("output", types.NewArray(nil, types.NewArray(nil, types.A))).Description("the sorted vertices of each component, with edges between components leading to later components"),
+	),
+}
+
+var GraphShortestPath = &Builtin{
+	Name:        "graph.shortest_path",
+	Description: "Finds a path with the fewest edges between two vertices of a directed graph. The result is undefined if there is no such path.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("graph", graphType).Description("object containing a set or array of neighboring vertices"),
+			types.Named("from", types.A).Description("first vertex of the path"),
+			types.Named("to", types.A).Description("last vertex of the path"),
+		),
+		types.Named("output", types.NewArray(nil, types.A)).Description("vertices of the path from `from` to `to`"),
+	),
+}
+
+var GraphTransitiveReduction = &Builtin{
+	Name:        "graph.transitive_reduction",
+	Description: "Removes the edges of a directed acyclic graph that are implied by other paths, keeping the same reachability. An error is raised if the graph contains a cycle.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("graph", graphType).Description("object containing a set or array of neighboring vertices"),
+		),
+		types.Named("output", types.NewObject(nil, types.NewDynamicProperty(types.A, types.NewSet(types.A)))).Description("object mapping every vertex to the set of its remaining neighbors"),
+	),
+}
+
 /**
  * Type
  */
@@ -2882,6 +3367,58 @@ var NetLookupIPAddr = &Builtin{
 	Nondeterministic: true,
 }
 
+var netURLComponentsType = types.NewObject(
+	[]*types.StaticProperty{
+		types.NewStaticProperty("scheme", types.S),
+		types.NewStaticProperty("opaque", types.S),
+		types.NewStaticProperty("username", types.S),
+		types.NewStaticProperty("password", types.S),
+		types.NewStaticProperty("host", types.S),
+		types.NewStaticProperty("port", types.S),
+		types.NewStaticProperty("path", types.S),
+		types.NewStaticProperty("query", types.S),
+		types.NewStaticProperty("fragment", types.S),
+	},
+	nil,
+)
+
+var NetURLParse = &Builtin{
+	Name: "net.url.parse",
+	Description: "Parses a URL (or relative reference) into its components. " +
+		"Absent components are empty strings. `path`, `query`, `fragment`, `username` and `password` are percent-encoded as in the URL, e.g., the path of `https://example.com/a%20b` is `/a%20b`, not `/a b`; `host` is given without brackets for IPv6 addresses.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("url", types.S).Description("URL to parse"),
+		),
+		types.Named("components", netURLComponentsType).Description("the components of `url`"),
+	),
+}
+
+var NetURLNormalize = &Builtin{
+	Name: "net.url.normalize",
+	Description: "Normalizes a URL as described in RFC 3986 section 6.2.2: the scheme and host are lowercased, percent-encoding is normalized and dot-segments are removed from the path. " +
+		"Default ports are removed and empty paths are replaced by `/` for the `http`, `https`, `ws`, `wss` and `ftp` schemes.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("url", types.S).Description("URL to normalize"),
+		),
+		types.Named("output", types.S).Description("the normalized URL"),
+	),
+}
+
+var NetURLBuild = &Builtin{
+	Name: "net.url.build",
+	Description: "Builds a URL from its components, as returned by `net.url.parse`. Absent components are omitted. " +
+		"Components are strings, percent-encoded like the components returned by `net.url.parse`. " +
+		"The `port` can also be given as a number, and the `query` as an object mapping parameter names to strings or arrays of strings, like the objects returned by `urlquery.decode_object`.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("components", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("the components of the URL"),
+		),
+		types.Named("url", types.S).Description("the URL"),
+	),
+}
+
 /**
  * Semantic Versions
  */
@@ -2909,6 +3446,53 @@ var SemVerCompare = &Builtin{
 	),
 }
 
+var SemVerParse = &Builtin{
+	Name:        "semver.parse",
+	Description: "Parses a valid SemVer string into its parts.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("vsn", types.S),
+		),
+		types.Named("parts", types.NewObject(
+			[]*types.StaticProperty{
+				types.NewStaticProperty("major", types.N),
+				types.NewStaticProperty("minor", types.N),
+				types.NewStaticProperty("patch", types.N),
+				types.NewStaticProperty("prerelease", types.S),
+				types.NewStaticProperty("metadata", types.S),
+			},
+			nil,
+		)).Description("`major`, `minor` and `patch` versions, and `prerelease` and `metadata` (empty strings if absent) of `vsn`"),
+	),
+}
+
+var SemVerSatisfies = &Builtin{
+	Name:        "semver.satisfies",
+	Description: "Checks if a valid SemVer string satisfies a constraint, such as `>=1.2, <2.0 || ~3.1`.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("vsn", types.S),
+			types.Named("constraint", types.S),
+		),
+		types.Named("result", types.B).Description("`true` if `vsn` satisfies `constraint`; `false` otherwise"),
+	),
+}
+
+var SemVerMaxSatisfying = &Builtin{
+	Name:        "semver.max_satisfying",
+	Description: "Returns the highest version of a collection of valid SemVer strings that satisfies a constraint.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("vsns", types.NewAny(
+				types.NewArray(nil, types.S),
+				types.NewSet(types.S),
+			)),
+			types.Named("constraint", types.S),
+		),
+		types.Named("output", types.NewAny(types.S, types.NewNull())).Description("the highest version in `vsns` that satisfies `constraint`, or `null` if there is none"),
+	),
+}
+
 /**
  * Printing
  */
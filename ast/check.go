@@ -137,7 +137,12 @@ func (tc *typeChecker) CheckBody(env *TypeEnv, body Body) (*TypeEnv, Errors) {
 // are found. The resulting TypeEnv wraps the provided one. The resulting
 // TypeEnv will be able to resolve types of refs that refer to rules.
 func (tc *typeChecker) CheckTypes(env *TypeEnv, sorted []util.T, as *AnnotationSet) (*TypeEnv, Errors) {
-	env = tc.newEnv(env)
+	return tc.checkRules(tc.newEnv(env), sorted, as)
+}
+
+// checkRules runs type checking on the rules and records their types in the
+// provided TypeEnv.
+func (tc *typeChecker) checkRules(env *TypeEnv, sorted []util.T, as *AnnotationSet) (*TypeEnv, Errors) {
 	for _, s := range sorted {
 		tc.checkRule(env, as, s.(*Rule))
 	}
@@ -1008,6 +1013,15 @@ func newRefErrInvalid(loc *Location, ref Ref, idx int, have, want types.Type, on
 		Want:  want,
 		OneOf: oneOf,
 	}
+	if key, ok := ref[idx].Value.(String); ok {
+		candidates := make([]string, 0, len(oneOf))
+		for _, v := range oneOf {
+			if s, ok := v.(String); ok {
+				candidates = append(candidates, string(s))
+			}
+		}
+		err.Hints = suggest(string(key), candidates)
+	}
 	return err
 }
 
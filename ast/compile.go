@@ -123,6 +123,10 @@ type Compiler struct {
 	keepModules             bool                          // whether to keep the unprocessed, parse modules (below)
 	parsedModules           map[string]*Module            // parsed, but otherwise unprocessed modules, kept track of when keepModules is true
 	useTypeCheckAnnotations bool                          // whether to provide annotated information (schemas) to the type checker
+	metadataParsed          bool                          // indicates if metadata blocks have been parsed for all modules
+	prev                    *Compiler                     // previous compilation to reuse results from (see WithIncremental)
+	incremental             *incrementalState             // state of the current incremental compilation, if any
+	constantEvaluator       ConstantEvaluator             // evaluates closed terms if constant folding is enabled (see WithConstantFolding)
 }
 
 // CompilerStage defines the interface for stages in the compiler.
@@ -307,6 +311,7 @@ func NewCompiler() *Compiler {
 		{"CheckTypes", "compile_stage_check_types", c.checkTypes}, // must be run after CheckRecursion
 		{"CheckUnsafeBuiltins", "compile_state_check_unsafe_builtins", c.checkUnsafeBuiltins},
 		{"CheckDeprecatedBuiltins", "compile_state_check_deprecated_builtins", c.checkDeprecatedBuiltins},
+		{"FoldConstants", "compile_stage_fold_constants", c.foldConstants},
 		{"BuildRuleIndices", "compile_stage_rebuild_indices", c.buildRuleIndices},
 		{"BuildComprehensionIndices", "compile_stage_rebuild_comprehension_indices", c.buildComprehensionIndices},
 	}
@@ -434,9 +439,24 @@ func (c *Compiler) QueryCompiler() QueryCompiler {
 func (c *Compiler) Compile(modules map[string]*Module) {
 
 	c.init()
+	c.initIncremental(modules)
+
+	if c.incremental != nil {
+		// The indices and rewritten vars of the reused modules are carried
+		// over from the previous compilation by the respective stages.
+		c.RewrittenVars = map[Var]Var{}
+		c.ruleIndices = util.NewHashMap(func(a, b util.T) bool {
+			r1, r2 := a.(Ref), b.(Ref)
+			return r1.Equal(r2)
+		}, func(x util.T) int {
+			return x.(Ref).Hash()
+		})
+		c.comprehensionIndices = map[*Term]*ComprehensionIndex{}
+	}
 
 	c.Modules = make(map[string]*Module, len(modules))
 	c.sorted = make([]string, 0, len(modules))
+	c.metadataParsed = false
 
 	if c.keepModules {
 		c.parsedModules = make(map[string]*Module, len(modules))
@@ -445,7 +465,11 @@ func (c *Compiler) Compile(modules map[string]*Module) {
 	}
 
 	for k, v := range modules {
-		c.Modules[k] = v.Copy()
+		if c.isReused(k) {
+			c.Modules[k] = c.incremental.prevModules[k]
+		} else {
+			c.Modules[k] = v.Copy()
+		}
 		c.sorted = append(c.sorted, k)
 		if c.parsedModules != nil {
 			c.parsedModules[k] = v
@@ -804,6 +828,11 @@ func (c *Compiler) buildRuleIndices() {
 			}
 		}
 
+		if index, ok := c.reusedRuleIndex(rules); ok {
+			c.ruleIndices.Put(rules[0].Ref().GroundPrefix(), index)
+			return hasNonGroundKey
+		}
+
 		index := newBaseDocEqIndex(func(ref Ref) bool {
 			return isVirtual(c.RuleTree, ref.GroundPrefix())
 		})
@@ -816,7 +845,7 @@ func (c *Compiler) buildRuleIndices() {
 }
 
 func (c *Compiler) buildComprehensionIndices() {
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		WalkRules(c.Modules[name], func(r *Rule) bool {
 			candidates := r.Head.Args.Vars()
 			candidates.Update(ReservedVars)
@@ -825,6 +854,7 @@ func (c *Compiler) buildComprehensionIndices() {
 			return false
 		})
 	}
+	c.reuseComprehensionIndices()
 }
 
 // checkRecursion ensures that there are no recursive definitions, i.e., there are
@@ -912,13 +942,30 @@ func (c *Compiler) checkRuleConflicts() {
 
 		switch {
 		case singleValueConflicts != nil:
-			c.err(NewError(TypeErr, node.Values[0].(*Rule).Loc(), "single-value rule %v conflicts with %v", name, singleValueConflicts))
+			err := NewError(TypeErr, node.Values[0].(*Rule).Loc(), "single-value rule %v conflicts with %v", name, singleValueConflicts)
+			for _, sub := range node.Children {
+				sub.DepthFirst(func(x *TreeNode) bool {
+					err.Related = append(err.Related, ruleLocations(x.Values)...)
+					return false
+				})
+			}
+			c.err(err)
 
 		case len(kinds) > 1 || len(arities) > 1:
-			c.err(NewError(TypeErr, node.Values[0].(*Rule).Loc(), "conflicting rules %v found", name))
+			err := NewError(TypeErr, node.Values[0].(*Rule).Loc(), "conflicting rules %v found", name)
+			err.Related = ruleLocations(node.Values[1:])
+			c.err(err)
 
 		case defaultRules > 1:
-			c.err(NewError(TypeErr, node.Values[0].(*Rule).Loc(), "multiple default rules %s found", name))
+			var defaults []util.T
+			for _, rule := range node.Values {
+				if rule.(*Rule).Default {
+					defaults = append(defaults, rule)
+				}
+			}
+			err := NewError(TypeErr, defaults[0].(*Rule).Loc(), "multiple default rules %s found", name)
+			err.Related = ruleLocations(defaults[1:])
+			c.err(err)
 		}
 
 		return false
@@ -943,7 +990,9 @@ func (c *Compiler) checkRuleConflicts() {
 						}
 						if len(tail) == 0 {
 							msg := fmt.Sprintf("%v conflicts with rule %v defined at %v", childMod.Package, rule.Head.Ref(), rule.Loc())
-							c.err(NewError(TypeErr, mod.Package.Loc(), msg))
+							err := NewError(TypeErr, mod.Package.Loc(), msg)
+							err.Related = []*Location{rule.Loc()}
+							c.err(err)
 						}
 					}
 				}
@@ -954,15 +1003,46 @@ func (c *Compiler) checkRuleConflicts() {
 }
 
 func (c *Compiler) checkUndefinedFuncs() {
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		m := c.Modules[name]
-		for _, err := range checkUndefinedFuncs(c.TypeEnv, m, c.GetArity, c.RewrittenVars) {
+		suggest := func(ref Ref) []string {
+			return c.suggestFunctions(m.Package, ref)
+		}
+		for _, err := range checkUndefinedFuncs(c.TypeEnv, m, c.GetArity, c.RewrittenVars, suggest) {
 			c.err(err)
 		}
 	}
 }
 
-func checkUndefinedFuncs(env *TypeEnv, x interface{}, arity func(Ref) int, rwVars map[Var]Var) Errors {
+// suggestFunctions returns hints for a call to the undefined function ref
+// from the package pkg (which may be nil.) Built-in functions and functions
+// defined by rules are considered.
+func (c *Compiler) suggestFunctions(pkg *Package, ref Ref) []string {
+
+	candidates := make([]string, 0, len(c.builtins))
+	for name := range c.builtins {
+		if !strings.HasPrefix(name, "internal.") {
+			candidates = append(candidates, name)
+		}
+	}
+
+	for _, name := range c.sorted {
+		mod := c.Modules[name]
+		for _, rule := range mod.Rules {
+			if len(rule.Head.Args) == 0 {
+				continue
+			}
+			candidates = append(candidates, rule.Path().String())
+			if pkg != nil && mod.Package.Path.Equal(pkg.Path) {
+				candidates = append(candidates, rule.Head.Ref().String())
+			}
+		}
+	}
+
+	return suggest(ref.String(), candidates)
+}
+
+func checkUndefinedFuncs(env *TypeEnv, x interface{}, arity func(Ref) int, rwVars map[Var]Var, suggest func(Ref) []string) Errors {
 
 	var errs Errors
 
@@ -989,7 +1069,9 @@ func checkUndefinedFuncs(env *TypeEnv, x interface{}, arity func(Ref) int, rwVar
 			return false
 		}
 		ref = rewriteVarsInRef(rwVars)(ref)
-		errs = append(errs, NewError(TypeErr, expr.Loc(), "undefined function %v", ref))
+		err := NewError(TypeErr, expr.Loc(), "undefined function %v", ref)
+		err.Hints = suggest(ref)
+		errs = append(errs, err)
 		return true
 	})
 
@@ -1014,20 +1096,23 @@ func arityMismatchError(env *TypeEnv, f Ref, expr *Expr, exp, act int) *Error {
 // positions of built-in expressions will be bound when evaluating the rule from left
 // to right, re-ordering as necessary.
 func (c *Compiler) checkSafetyRuleBodies() {
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		m := c.Modules[name]
 		WalkRules(m, func(r *Rule) bool {
 			safe := ReservedVars.Copy()
 			safe.Update(r.Head.Args.Vars())
-			r.Body = c.checkBodySafety(safe, r.Body)
+			r.Body = c.checkBodySafety(m.Package, safe, r.Body)
 			return false
 		})
 	}
 }
 
-func (c *Compiler) checkBodySafety(safe VarSet, b Body) Body {
+func (c *Compiler) checkBodySafety(pkg *Package, safe VarSet, b Body) Body {
 	reordered, unsafe := reorderBodyForSafety(c.builtins, c.GetArity, safe, b)
-	if errs := safetyErrorSlice(unsafe, c.RewrittenVars); len(errs) > 0 {
+	suggest := func(v Var) []string {
+		return c.suggestRules(pkg, v)
+	}
+	if errs := safetyErrorSlice(unsafe, c.RewrittenVars, suggest); len(errs) > 0 {
 		for _, err := range errs {
 			c.err(err)
 		}
@@ -1036,6 +1121,29 @@ func (c *Compiler) checkBodySafety(safe VarSet, b Body) Body {
 	return reordered
 }
 
+// suggestRules returns hints for the unsafe var v if it is similar to the
+// name of a rule defined in the package pkg.
+func (c *Compiler) suggestRules(pkg *Package, v Var) []string {
+
+	node := c.RuleTree
+	for i := 0; node != nil && i < len(pkg.Path); i++ {
+		node = node.Child(pkg.Path[i].Value)
+	}
+
+	if node == nil {
+		return nil
+	}
+
+	candidates := make([]string, 0, len(node.Children))
+	for key := range node.Children {
+		if s, ok := key.(String); ok {
+			candidates = append(candidates, string(s))
+		}
+	}
+
+	return suggest(string(v), candidates)
+}
+
 // SafetyCheckVisitorParams defines the AST visitor parameters to use for collecting
 // variables during the safety check. This has to be exported because it's relied on
 // by the copy propagation implementation in topdown.
@@ -1048,7 +1156,7 @@ var SafetyCheckVisitorParams = VarVisitorParams{
 // rule also appear in the body.
 func (c *Compiler) checkSafetyRuleHeads() {
 
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		m := c.Modules[name]
 		WalkRules(m, func(r *Rule) bool {
 			safe := r.Body.Vars(SafetyCheckVisitorParams)
@@ -1316,7 +1424,14 @@ func (c *Compiler) checkTypes() {
 	if c.useTypeCheckAnnotations {
 		as = c.annotationSet
 	}
-	env, errs := checker.CheckTypes(c.TypeEnv, sorted, as)
+	var env *TypeEnv
+	var errs Errors
+	if c.incremental != nil {
+		env, sorted = c.incrementalTypeEnv(checker, sorted)
+		env, errs = checker.checkRules(env, sorted, as)
+	} else {
+		env, errs = checker.CheckTypes(c.TypeEnv, sorted, as)
+	}
 	for _, err := range errs {
 		c.err(err)
 	}
@@ -1324,7 +1439,7 @@ func (c *Compiler) checkTypes() {
 }
 
 func (c *Compiler) checkUnsafeBuiltins() {
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		errs := checkUnsafeBuiltins(c.unsafeBuiltinsMap, c.Modules[name])
 		for _, err := range errs {
 			c.err(err)
@@ -1333,7 +1448,7 @@ func (c *Compiler) checkUnsafeBuiltins() {
 }
 
 func (c *Compiler) checkDeprecatedBuiltins() {
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		errs := checkDeprecatedBuiltins(c.deprecatedBuiltinsMap, c.Modules[name], c.strict)
 		for _, err := range errs {
 			c.err(err)
@@ -1472,7 +1587,7 @@ func (c *Compiler) checkDuplicateImports() {
 		return
 	}
 
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 		processedImports := map[Var]*Import{}
 
@@ -1489,7 +1604,7 @@ func (c *Compiler) checkDuplicateImports() {
 }
 
 func (c *Compiler) checkKeywordOverrides() {
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 		errs := checkKeywordOverrides(mod, c.strict)
 		for _, err := range errs {
@@ -1548,7 +1663,7 @@ func (c *Compiler) resolveAllRefs() {
 
 	rules := c.getExports()
 
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 
 		var ruleExports []Ref
@@ -1608,25 +1723,26 @@ func (c *Compiler) resolveAllRefs() {
 }
 
 func (c *Compiler) removeImports() {
-	for name := range c.Modules {
+	for _, name := range c.modulesToCompile() {
 		c.Modules[name].Imports = nil
 	}
 }
 
 func (c *Compiler) initLocalVarGen() {
 	c.localvargen = newLocalVarGeneratorForModuleSet(c.sorted, c.Modules)
+	c.initIncrementalRewrittenVars(c.localvargen.exclude)
 }
 
 func (c *Compiler) rewriteComprehensionTerms() {
 	f := newEqualityFactory(c.localvargen)
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 		_, _ = rewriteComprehensionTerms(f, mod) // ignore error
 	}
 }
 
 func (c *Compiler) rewriteExprTerms() {
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 		WalkRules(mod, func(rule *Rule) bool {
 			rewriteExprTermsInHead(c.localvargen, rule)
@@ -1638,7 +1754,7 @@ func (c *Compiler) rewriteExprTerms() {
 
 func (c *Compiler) rewriteRuleHeadRefs() {
 	f := newEqualityFactory(c.localvargen)
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		WalkRules(c.Modules[name], func(rule *Rule) bool {
 
 			ref := rule.Head.Ref()
@@ -1696,7 +1812,7 @@ func (c *Compiler) rewriteRuleHeadRefs() {
 }
 
 func (c *Compiler) checkVoidCalls() {
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 		for _, err := range checkVoidCalls(c.TypeEnv, mod) {
 			c.err(err)
@@ -1706,12 +1822,12 @@ func (c *Compiler) checkVoidCalls() {
 
 func (c *Compiler) rewritePrintCalls() {
 	if !c.enablePrintStatements {
-		for _, name := range c.sorted {
+		for _, name := range c.modulesToCompile() {
 			erasePrintCalls(c.Modules[name])
 		}
 		return
 	}
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 		WalkRules(mod, func(r *Rule) bool {
 			safe := r.Head.Args.Vars()
@@ -1905,7 +2021,7 @@ func isPrintCall(x *Expr) bool {
 // p[__local0__] { i < 100; __local0__ = {"foo": data.foo[i]} }
 func (c *Compiler) rewriteRefsInHead() {
 	f := newEqualityFactory(c.localvargen)
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 		WalkRules(mod, func(rule *Rule) bool {
 			if requiresEval(rule.Head.Key) {
@@ -1931,7 +2047,7 @@ func (c *Compiler) rewriteRefsInHead() {
 }
 
 func (c *Compiler) rewriteEquals() {
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 		rewriteEquals(mod)
 	}
@@ -1939,7 +2055,7 @@ func (c *Compiler) rewriteEquals() {
 
 func (c *Compiler) rewriteDynamicTerms() {
 	f := newEqualityFactory(c.localvargen)
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 		WalkRules(mod, func(rule *Rule) bool {
 			rule.Body = rewriteDynamics(f, rule.Body)
@@ -1949,9 +2065,10 @@ func (c *Compiler) rewriteDynamicTerms() {
 }
 
 func (c *Compiler) parseMetadataBlocks() {
-	// Only parse annotations if rego.metadata built-ins are called
-	regoMetadataCalled := false
-	for _, name := range c.sorted {
+	// Only parse annotations if rego.metadata built-ins are called, or if they
+	// were parsed for the modules reused from a previous compilation.
+	regoMetadataCalled := c.incremental != nil && c.incremental.prevMetadataParsed
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 		WalkExprs(mod, func(expr *Expr) bool {
 			if isRegoMetadataChainCall(expr) || isRegoMetadataRuleCall(expr) {
@@ -1967,7 +2084,8 @@ func (c *Compiler) parseMetadataBlocks() {
 
 	if regoMetadataCalled {
 		// NOTE: Possible optimization: only parse annotations for modules on the path of rego.metadata-calling module
-		for _, name := range c.sorted {
+		c.metadataParsed = true
+		for _, name := range c.modulesToCompile() {
 			mod := c.Modules[name]
 
 			if len(mod.Annotations) == 0 {
@@ -1988,7 +2106,7 @@ func (c *Compiler) rewriteRegoMetadataCalls() {
 	_, chainFuncAllowed := c.builtins[RegoMetadataChain.Name]
 	_, ruleFuncAllowed := c.builtins[RegoMetadataRule.Name]
 
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 
 		WalkRules(mod, func(rule *Rule) bool {
@@ -2166,7 +2284,7 @@ func createMetadataChain(chain []*AnnotationsRef) (*Term, *Error) {
 
 func (c *Compiler) rewriteLocalVars() {
 
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 		gen := c.localvargen
 
@@ -2382,7 +2500,7 @@ func (vis *ruleArgLocalRewriter) Visit(x interface{}) Visitor {
 
 func (c *Compiler) rewriteWithModifiers() {
 	f := newEqualityFactory(c.localvargen)
-	for _, name := range c.sorted {
+	for _, name := range c.modulesToCompile() {
 		mod := c.Modules[name]
 		t := NewGenericTransformer(func(x interface{}) (interface{}, error) {
 			body, ok := x.(Body)
@@ -2643,7 +2761,10 @@ func (qc *queryCompiler) checkVoidCalls(_ *QueryContext, body Body) (Body, error
 }
 
 func (qc *queryCompiler) checkUndefinedFuncs(_ *QueryContext, body Body) (Body, error) {
-	if errs := checkUndefinedFuncs(qc.compiler.TypeEnv, body, qc.compiler.GetArity, qc.rewritten); len(errs) > 0 {
+	suggest := func(ref Ref) []string {
+		return qc.compiler.suggestFunctions(nil, ref)
+	}
+	if errs := checkUndefinedFuncs(qc.compiler.TypeEnv, body, qc.compiler.GetArity, qc.rewritten, suggest); len(errs) > 0 {
 		return nil, errs
 	}
 	return body, nil
@@ -2652,7 +2773,7 @@ func (qc *queryCompiler) checkUndefinedFuncs(_ *QueryContext, body Body) (Body,
 func (qc *queryCompiler) checkSafety(_ *QueryContext, body Body) (Body, error) {
 	safe := ReservedVars.Copy()
 	reordered, unsafe := reorderBodyForSafety(qc.compiler.builtins, qc.compiler.GetArity, safe, body)
-	if errs := safetyErrorSlice(unsafe, qc.RewrittenVars()); len(errs) > 0 {
+	if errs := safetyErrorSlice(unsafe, qc.RewrittenVars(), nil); len(errs) > 0 {
 		return nil, errs
 	}
 	return reordered, nil
@@ -3216,6 +3337,15 @@ func (n *TreeNode) flattenChildren() []Ref {
 	return ret.s
 }
 
+// ruleLocations returns the locations of the rules in values.
+func ruleLocations(values []util.T) []*Location {
+	locs := make([]*Location, 0, len(values))
+	for _, x := range values {
+		locs = append(locs, x.(*Rule).Loc())
+	}
+	return locs
+}
+
 // Graph represents the graph of dependencies between rules.
 type Graph struct {
 	adj    map[util.T]map[util.T]struct{}
@@ -5289,7 +5419,7 @@ func isVirtual(node *TreeNode, ref Ref) bool {
 	return true
 }
 
-func safetyErrorSlice(unsafe unsafeVars, rewritten map[Var]Var) (result Errors) {
+func safetyErrorSlice(unsafe unsafeVars, rewritten map[Var]Var, suggest func(Var) []string) (result Errors) {
 
 	if len(unsafe) == 0 {
 		return
@@ -5306,7 +5436,11 @@ func safetyErrorSlice(unsafe unsafeVars, rewritten map[Var]Var) (result Errors)
 					"var %[1]v is unsafe (hint: `import future.keywords.%[1]v` to import a future keyword)", v))
 				continue
 			}
-			result = append(result, NewError(UnsafeVarErr, pair.Loc, "var %v is unsafe", v))
+			err := NewError(UnsafeVarErr, pair.Loc, "var %v is unsafe", v)
+			if suggest != nil {
+				err.Hints = suggest(v)
+			}
+			result = append(result, err)
 		}
 	}
 
@@ -8633,6 +8633,189 @@ func TestKeepModules(t *testing.T) {
 	})
 }
 
+func TestCompilerIncremental(t *testing.T) {
+
+	parse := func(mods map[string]string) map[string]*Module {
+		parsed := map[string]*Module{}
+		for id, input := range mods {
+			parsed[id] = MustParseModule(input)
+		}
+		return parsed
+	}
+
+	initial := map[string]string{
+		"a.rego": `package a
+f(x) = y { y := x + 1 }
+p = f(1)`,
+		"b.rego": `package b
+import data.a
+q = z { z := a.p; [x | x := a.f(z)] }`,
+		"c.rego": `package c
+r[x] { x := input.xs[_]; x.y == "z" }`,
+		"d.rego": `package a.d
+s := 7`,
+	}
+
+	prev := NewCompiler().WithIncremental(nil)
+	prev.Compile(parse(initial))
+	assertNotFailed(t, prev)
+
+	t.Run("unchanged", func(t *testing.T) {
+		m := metrics.New()
+		c := NewCompiler().WithIncremental(prev).WithMetrics(m)
+		c.Compile(parse(initial))
+		assertNotFailed(t, c)
+
+		for name := range initial {
+			if c.Modules[name] != prev.Modules[name] {
+				t.Errorf("expected module %v to be reused", name)
+			}
+		}
+
+		if exp, act := uint64(len(initial)), m.Counter(compileIncrementalModulesReused).Value().(uint64); exp != act {
+			t.Errorf("expected %d reused modules, got %d", exp, act)
+		}
+
+		if c.RuleIndex(MustParseRef("data.c.r")) == nil {
+			t.Error("expected rule index to be reused")
+		}
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		changed := map[string]string{}
+		for k, v := range initial {
+			changed[k] = v
+		}
+		changed["a.rego"] = `package a
+f(x) = y { y := concat(",", [x, "1"]) }
+p = f("x")`
+
+		c := NewCompiler().WithIncremental(prev)
+		c.Compile(parse(changed))
+		assertNotFailed(t, c)
+
+		for name, exp := range map[string]bool{"a.rego": false, "b.rego": false, "c.rego": true, "d.rego": false} {
+			if act := c.Modules[name] == prev.Modules[name]; act != exp {
+				t.Errorf("expected reuse of module %v to be %v", name, exp)
+			}
+		}
+
+		full := NewCompiler()
+		full.Compile(parse(changed))
+		assertNotFailed(t, full)
+
+		for name := range changed {
+			if !c.Modules[name].Equal(full.Modules[name]) {
+				t.Errorf("expected module %v to equal fully compiled module:\n\nExpected:\n\n%v\n\nGot:\n\n%v", name, full.Modules[name], c.Modules[name])
+			}
+		}
+
+		for _, ref := range []string{"data.a.f", "data.a.p", "data.b.q", "data.c.r", "data.a.d.s"} {
+			exp, act := full.TypeEnv.Get(MustParseRef(ref)), c.TypeEnv.Get(MustParseRef(ref))
+			if types.Compare(exp, act) != 0 {
+				t.Errorf("expected type of %v to be %v but got %v", ref, exp, act)
+			}
+		}
+	})
+
+	t.Run("location changed", func(t *testing.T) {
+		changed := map[string]string{}
+		for k, v := range initial {
+			changed[k] = v
+		}
+		changed["c.rego"] = "\n" + changed["c.rego"]
+
+		c := NewCompiler().WithIncremental(prev)
+		c.Compile(parse(changed))
+		assertNotFailed(t, c)
+
+		if c.Modules["c.rego"] == prev.Modules["c.rego"] {
+			t.Fatal("expected module to be recompiled")
+		}
+
+		if exp, act := 3, c.Modules["c.rego"].Rules[0].Location.Row; exp != act {
+			t.Errorf("expected rule on row %d but got %d", exp, act)
+		}
+	})
+
+	t.Run("errors in dependents", func(t *testing.T) {
+		changed := map[string]string{}
+		for k, v := range initial {
+			changed[k] = v
+		}
+		changed["a.rego"] = `package a
+f(x, y) = z { z := x + y }
+p = f(1, 2)`
+
+		c := NewCompiler().WithIncremental(prev)
+		c.Compile(parse(changed))
+
+		if !c.Failed() {
+			t.Fatal("expected compilation to fail")
+		}
+
+		if !strings.Contains(c.Errors.Error(), "function data.a.f has arity 2, got 1 argument") {
+			t.Errorf("unexpected errors: %v", c.Errors)
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		changed := map[string]string{}
+		for k, v := range initial {
+			changed[k] = v
+		}
+		delete(changed, "a.rego")
+
+		c := NewCompiler().WithIncremental(prev)
+		c.Compile(parse(changed))
+
+		if !c.Failed() {
+			t.Fatal("expected compilation to fail")
+		}
+
+		if !strings.Contains(c.Errors.Error(), "undefined function data.a.f") {
+			t.Errorf("unexpected errors: %v", c.Errors)
+		}
+	})
+
+	t.Run("recompile same compiler", func(t *testing.T) {
+		c := NewCompiler().WithIncremental(nil)
+		c.Compile(parse(initial))
+		assertNotFailed(t, c)
+
+		before := c.Modules["c.rego"]
+		modules := map[string]*Module{}
+		for name, mod := range c.Modules {
+			modules[name] = mod
+		}
+		modules["e.rego"] = MustParseModule(`package e
+t { data.c.r[_] }`)
+
+		c.WithIncremental(c).Compile(modules)
+		assertNotFailed(t, c)
+
+		if c.Modules["c.rego"] != before {
+			t.Error("expected module to be reused")
+		}
+
+		if c.GetRulesExact(MustParseRef("data.e.t")) == nil {
+			t.Error("expected new rule to be compiled")
+		}
+	})
+
+	t.Run("different settings", func(t *testing.T) {
+		c := NewCompiler().WithIncremental(prev).WithEnablePrintStatements(true)
+		c.Compile(parse(initial))
+		assertNotFailed(t, c)
+
+		for name := range initial {
+			if c.Modules[name] == prev.Modules[name] {
+				t.Errorf("expected module %v to be recompiled", name)
+			}
+		}
+	})
+}
+
 // see https://github.com/open-policy-agent/opa/issues/5166
 func TestCompilerWithRecursiveSchema(t *testing.T) {
 
@@ -8880,3 +9063,245 @@ deny {
 		t.Fatal(c.Errors)
 	}
 }
+
+func TestCompilerFoldConstants(t *testing.T) {
+
+	// The evaluator folds calls to plus and replaces comprehensions with a
+	// marker value so that closed comprehensions can be identified.
+	eval := func(term *Term) (*Term, error) {
+		switch x := term.Value.(type) {
+		case Call:
+			if x[0].Value.Compare(Plus.Ref()) != 0 {
+				return nil, fmt.Errorf("unsupported call: %v", term)
+			}
+			a, _ := x[1].Value.(Number).Int()
+			b, _ := x[2].Value.(Number).Int()
+			return IntNumberTerm(a + b), nil
+		case *ArrayComprehension:
+			return ArrayTerm(StringTerm("folded")), nil
+		}
+		return nil, fmt.Errorf("unsupported term: %v", term)
+	}
+
+	tests := []struct {
+		note     string
+		module   string
+		rule     string
+		expected string
+	}{
+		{
+			note:     "call",
+			module:   `p := 1 + 2`,
+			expected: `p := 3 { true }`,
+		},
+		{
+			note:     "chained calls",
+			module:   `p := y { x := 1 + 2; y := x + 3 }`,
+			expected: `p := 6 { true }`,
+		},
+		{
+			note:     "non-constant operands",
+			module:   `p := x + 1 { x := input.x }`,
+			expected: `p := __local1__ { __local0__ = input.x; plus(__local0__, 1, __local1__) }`,
+		},
+		{
+			note:     "unsupported call",
+			module:   `p := 2 - 1`,
+			expected: `p := __local0__ { true; minus(2, 1, __local0__) }`,
+		},
+		{
+			note: "inline constant rule",
+			module: `p := x + 1 { x := q }
+q := 1`,
+			expected: `p := 2 { true }`,
+		},
+		{
+			note: "inline constant rule with ground remainder",
+			module: `p := q.a[1]
+q := {"a": [1, 2]}`,
+			expected: `p := 2 { true }`,
+		},
+		{
+			note: "inline constant rule with non-ground remainder",
+			module: `p[x] { x := q[_] }
+q := [1, 2]`,
+			expected: `p[__local0__] { __local1__ = [1, 2]; __local0__ = __local1__[_] }`,
+		},
+		{
+			note: "inline folded rule",
+			module: `p := q + 1
+q := 1 + 2`,
+			expected: `p := 4 { true }`,
+		},
+		{
+			note: "no inlining of incremental rules",
+			module: `p := x { x := q }
+q := 1 { input.x }
+q := 2 { input.y }`,
+			expected: `p := __local0__ { __local0__ = data.test.q }`,
+		},
+		{
+			note: "no inlining of mocked rules",
+			module: `p := x { x := q }
+q := 1
+r { p with data.test.q as 2 }`,
+			expected: `p := __local0__ { __local0__ = data.test.q }`,
+		},
+		{
+			note: "no folding of mocked functions",
+			module: `p := 1 + 2
+r { p with plus as minus }`,
+			expected: `p := __local0__ { true; plus(1, 2, __local0__) }`,
+		},
+		{
+			note:     "function arguments",
+			module:   `f(x) := y { y := x + 1; x = 2 + 3 }`,
+			rule:     "f",
+			expected: `f(__local0__) := __local1__ { plus(__local0__, 1, __local2__); __local1__ = __local2__; __local0__ = 5 }`,
+		},
+		{
+			note:     "closed comprehension",
+			module:   `p := [x | x := [1, 2][_]]`,
+			expected: `p := ["folded"] { true }`,
+		},
+		{
+			note:     "comprehension referring to input",
+			module:   `p := [x | x := input.xs[_]; y := 1 + 2; x > y]`,
+			expected: `p := __local3__ { true; __local3__ = [__local0__ | __local0__ = input.xs[_]; gt(__local0__, 3)] }`,
+		},
+		{
+			note:     "comprehension referring to outer var",
+			module:   `p := xs { y := input.y; xs := [x | x := [1, 2][_]; x > y] }`,
+			expected: `p := __local2__ { __local0__ = input.y; __local2__ = [__local1__ | __local3__ = [1, 2]; __local1__ = __local3__[_]; gt(__local1__, __local0__)] }`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			m := metrics.New()
+			c := NewCompiler().WithConstantFolding(eval).WithMetrics(m)
+			c.Compile(map[string]*Module{"test": MustParseModule("package test\n" + tc.module)})
+			assertNotFailed(t, c)
+
+			rule := tc.rule
+			if rule == "" {
+				rule = "p"
+			}
+
+			expected := MustParseRule(tc.expected)
+			rules := c.GetRulesExact(MustParseRef("data.test." + rule))
+			if len(rules) != 1 {
+				t.Fatalf("expected exactly one rule but got: %v", rules)
+			}
+
+			if !rules[0].Head.Equal(expected.Head) || !rules[0].Body.Equal(expected.Body) {
+				t.Fatalf("expected:\n\n%v\n\ngot:\n\n%v", expected, rules[0])
+			}
+		})
+	}
+}
+
+func TestCompilerFoldConstantsDisabled(t *testing.T) {
+	c := NewCompiler()
+	c.Compile(map[string]*Module{"test": MustParseModule(`package test
+p := 1 + 2`)})
+	assertNotFailed(t, c)
+
+	expected := MustParseBody(`true; plus(1, 2, __local0__)`)
+	if result := c.Modules["test"].Rules[0].Body; !result.Equal(expected) {
+		t.Fatalf("expected %v but got %v", expected, result)
+	}
+}
+
+func TestCompilerErrorHints(t *testing.T) {
+
+	tests := []struct {
+		note     string
+		module   string
+		expected []string
+	}{
+		{
+			note: "undefined built-in function",
+			module: `package test
+			p { contans("abc", "a") }`,
+			expected: []string{"did you mean contains?"},
+		},
+		{
+			note: "undefined user-defined function",
+			module: `package test
+			is_admin(x) { x == "admin" }
+			p { is_admn("bob") }`,
+			expected: []string{"did you mean is_admin?"},
+		},
+		{
+			note: "unsafe var similar to rule",
+			module: `package test
+			allowed { true }
+			p { allowd }`,
+			expected: []string{"did you mean allowed?"},
+		},
+		{
+			note: "unsafe var without hint",
+			module: `package test
+			p { x }`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			c := NewCompiler()
+			c.Compile(map[string]*Module{"test.rego": MustParseModule(tc.module)})
+			if !c.Failed() {
+				t.Fatal("Expected compile error")
+			}
+			if len(c.Errors) != 1 {
+				t.Fatalf("Expected exactly one error but got: %v", c.Errors)
+			}
+			if !reflect.DeepEqual(c.Errors[0].Hints, tc.expected) {
+				t.Fatalf("Expected hints %v but got %v", tc.expected, c.Errors[0].Hints)
+			}
+		})
+	}
+}
+
+func TestCompilerErrorRelatedLocations(t *testing.T) {
+
+	tests := []struct {
+		note     string
+		module   string
+		expected []int
+	}{
+		{
+			note: "conflicting rules",
+			module: `package test
+p[x] { x = 1 }
+p = 2 { true }`,
+			expected: []int{3},
+		},
+		{
+			note: "multiple default rules",
+			module: `package test
+default p = 1
+default p = 2
+default p = 3`,
+			expected: []int{3, 4},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			c := NewCompiler()
+			c.Compile(map[string]*Module{"test.rego": MustParseModule(tc.module)})
+			if len(c.Errors) != 1 {
+				t.Fatalf("Expected exactly one error but got: %v", c.Errors)
+			}
+			var rows []int
+			for _, loc := range c.Errors[0].Related {
+				rows = append(rows, loc.Row)
+			}
+			if !reflect.DeepEqual(rows, tc.expected) {
+				t.Fatalf("Expected related rows %v but got %v (error: %v)", tc.expected, rows, c.Errors[0])
+			}
+		})
+	}
+}
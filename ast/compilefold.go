@@ -0,0 +1,556 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+// ConstantEvaluator evaluates a closed term at compile-time. The term is either
+// a call to a built-in function with ground operands or a comprehension that
+// does not refer to any documents or variables defined outside of it. If the
+// term is undefined, the result is nil. If the term cannot be evaluated (e.g.,
+// because the built-in function implementation is not available or returns an
+// error), an error is returned and the term is left unchanged.
+type ConstantEvaluator func(term *Term) (*Term, error)
+
+// constantFoldingUnsafeBuiltins contains the names of built-in functions that
+// are not marked as non-deterministic but depend on the evaluation context
+// (e.g., the current time) or have side-effects.
+var constantFoldingUnsafeBuiltins = map[string]struct{}{
+	Trace.Name:           {},
+	JWTDecodeVerify.Name: {},
+	CryptoX509ParseAndVerifyCertificates.Name: {},
+}
+
+// maxConstantFoldingPasses bounds the number of times a body is folded. Each
+// pass may enable further folding, e.g., by binding the output of a call that
+// is the input to another.
+const maxConstantFoldingPasses = 16
+
+// WithConstantFolding enables the constant folding stage. When enabled, the
+// compiler evaluates calls to deterministic built-in functions with constant
+// operands, inlines references to rules that define constant values, and
+// precomputes comprehensions that do not depend on input, data or variables
+// defined outside of them. The evaluator is used to compute the values of
+// these terms.
+//
+// Constant folding assumes that the inlined rules and folded built-in
+// functions are not replaced at evaluation time. Rules and built-in functions
+// that are targeted by with modifiers in the compiled modules are left
+// untouched; with modifiers contained in ad-hoc queries are not accounted for.
+func (c *Compiler) WithConstantFolding(eval ConstantEvaluator) *Compiler {
+	c.constantEvaluator = eval
+	return c
+}
+
+// foldConstants runs constant folding on the rules of the compiled modules.
+// Rules are processed in dependency order so that rules which become constant
+// as a result of folding can be inlined into their dependents.
+func (c *Compiler) foldConstants() {
+
+	if c.constantEvaluator == nil {
+		return
+	}
+
+	fold := map[*Rule]struct{}{}
+	for _, name := range c.modulesToCompile() {
+		WalkRules(c.Modules[name], func(r *Rule) bool {
+			fold[r] = struct{}{}
+			return false
+		})
+	}
+
+	f := newConstantFolder(c)
+
+	// Recursion is caught in earlier step, so this cannot fail.
+	sorted, _ := c.Graph.Sort()
+	for _, x := range sorted {
+		rule := x.(*Rule)
+		if _, ok := fold[rule]; ok {
+			f.foldRule(rule)
+		}
+	}
+
+	c.counterAdd(compileStageFoldConstantsCalls, f.calls)
+	c.counterAdd(compileStageFoldConstantsRefs, f.refs)
+	c.counterAdd(compileStageFoldConstantsComprehensions, f.comprehensions)
+}
+
+type constantFolder struct {
+	c        *Compiler
+	mocked   []Ref         // targets of with modifiers found in the module set
+	rule     *Rule         // rule being folded
+	ruleVars VarSet        // vars appearing as ref heads in the rule being folded
+	inlined  map[Var]*Term // generated vars bound to the values of inlined rules
+	gen      *localVarGenerator
+
+	calls          uint64
+	refs           uint64
+	comprehensions uint64
+}
+
+func newConstantFolder(c *Compiler) *constantFolder {
+	f := &constantFolder{c: c, gen: c.localvargen, inlined: map[Var]*Term{}}
+	for _, name := range c.sorted {
+		WalkExprs(c.Modules[name], func(expr *Expr) bool {
+			for _, w := range expr.With {
+				if ref, ok := w.Target.Value.(Ref); ok {
+					f.mocked = append(f.mocked, ref)
+				}
+			}
+			return false
+		})
+	}
+	return f
+}
+
+func (f *constantFolder) foldRule(rule *Rule) {
+
+	f.rule = rule
+
+	bindings := map[Var]*Term{}
+	rule.Body = f.foldBody(rule.Body, rule.Head.Args.Vars(), bindings)
+
+	if len(bindings) > 0 {
+		xform := constantBindingTransformer(bindings)
+		if rule.Head.Key != nil {
+			rule.Head.Key, _ = transformTerm(xform, rule.Head.Key)
+		}
+		if rule.Head.Value != nil {
+			rule.Head.Value, _ = transformTerm(xform, rule.Head.Value)
+		}
+		for i := 1; i < len(rule.Head.Reference); i++ {
+			rule.Head.Reference[i], _ = transformTerm(xform, rule.Head.Reference[i])
+		}
+	}
+}
+
+// foldBody folds the expressions in body until no further changes can be
+// made. Vars that are bound to constants (and not contained in protected) are
+// substituted and recorded in bindings so that the caller can substitute them
+// in the enclosing head or comprehension.
+func (f *constantFolder) foldBody(body Body, protected VarSet, bindings map[Var]*Term) Body {
+
+	for pass := 0; pass < maxConstantFoldingPasses; pass++ {
+
+		f.ruleVars = refHeadVars(f.rule)
+
+		changed := false
+		result := make(Body, 0, len(body))
+		local := map[Var]*Term{}
+
+		for _, expr := range body {
+
+			if len(expr.With) > 0 {
+				result.Append(expr)
+				continue
+			}
+
+			var prefix []*Expr
+			prefix, expr, changed = f.inlineRefs(expr, changed)
+			for _, p := range prefix {
+				result.Append(p)
+			}
+
+			changed = f.foldClosures(expr, protected) || changed
+
+			if folded := f.foldCall(expr); folded != nil {
+				expr = folded
+				changed = true
+			}
+
+			if v, value, ok := f.binding(expr, protected); ok {
+				if _, ok := local[v]; !ok {
+					local[v] = value
+					changed = true
+					continue
+				}
+			}
+
+			result.Append(expr)
+		}
+
+		if len(local) > 0 {
+			xform := constantBindingTransformer(local)
+			for i := range result {
+				if x, err := Transform(xform, result[i]); err == nil {
+					result[i] = x.(*Expr)
+				}
+			}
+			for v, value := range local {
+				bindings[v] = value
+			}
+		}
+
+		if len(result) == 0 {
+			result = NewBody(NewExpr(BooleanTerm(true)).SetLocation(body.Loc()))
+		}
+
+		body = result
+
+		if !changed {
+			break
+		}
+	}
+
+	return body
+}
+
+// inlineRefs replaces references to constant rules in the expression with their
+// values. If the remainder of a reference is not ground, the value is bound to
+// a generated var by an expression that is returned as a prefix.
+func (f *constantFolder) inlineRefs(expr *Expr, changed bool) ([]*Expr, *Expr, bool) {
+
+	var prefix []*Expr
+
+	vis := NewGenericVisitor(func(x interface{}) bool {
+		term, ok := x.(*Term)
+		if !ok {
+			return false
+		}
+		switch ref := term.Value.(type) {
+		case *ArrayComprehension, *SetComprehension, *ObjectComprehension:
+			return true // handled by foldClosures
+		case Ref:
+			value, tail, ok := f.constantRef(ref)
+			if !ok {
+				return false
+			}
+			if len(tail) == 0 {
+				term.Value = value.Value
+			} else if termSliceIsGround(tail) {
+				found, err := value.Value.Find(tail)
+				if err != nil {
+					return false
+				}
+				term.Value = found
+			} else {
+				v := NewTerm(f.gen.Generate()).SetLocation(term.Loc())
+				f.ruleVars.Add(v.Value.(Var))
+				f.inlined[v.Value.(Var)] = value
+				bind := Equality.Expr(v, value).SetLocation(expr.Loc())
+				bind.Generated = true
+				prefix = append(prefix, bind)
+				term.Value = append(Ref{v}, tail...)
+			}
+			f.refs++
+			changed = true
+			return true
+		}
+		return false
+	})
+
+	f.walkOperands(vis, expr)
+
+	return prefix, expr, changed
+}
+
+// walkOperands walks the terms of the expression, excluding the operator of
+// calls (which refers to a function and is never constant) and with
+// modifiers.
+func (f *constantFolder) walkOperands(vis *GenericVisitor, expr *Expr) {
+	switch terms := expr.Terms.(type) {
+	case *Term:
+		vis.Walk(terms)
+	case []*Term:
+		for _, t := range terms[1:] {
+			vis.Walk(t)
+		}
+	}
+}
+
+// constantRef returns the value of the constant rule that a prefix of ref
+// refers to, along with the remainder of ref. Refs to the values of inlined
+// rules are resolved once their remainder is ground (e.g., after the vars in
+// the remainder have been substituted.)
+func (f *constantFolder) constantRef(ref Ref) (*Term, Ref, bool) {
+
+	if v, ok := ref[0].Value.(Var); ok {
+		if value, ok := f.inlined[v]; ok && termSliceIsGround(ref[1:]) {
+			return value.Copy(), ref[1:], true
+		}
+	}
+
+	if !ref.HasPrefix(DefaultRootRef) {
+		return nil, nil, false
+	}
+
+	for i := 2; i <= len(ref) && ref[i-1].IsGround(); i++ {
+		prefix := ref[:i]
+		rules := f.c.GetRulesExact(prefix)
+		if len(rules) == 0 {
+			continue
+		}
+		if len(rules) > 1 || !isConstantRule(rules[0]) || f.isMocked(prefix) {
+			return nil, nil, false
+		}
+		return rules[0].Head.Value.Copy(), ref[i:], true
+	}
+
+	return nil, nil, false
+}
+
+func isConstantRule(rule *Rule) bool {
+
+	if rule.Else != nil ||
+		len(rule.Head.Args) > 0 ||
+		rule.Head.RuleKind() != SingleValue ||
+		!rule.Ref().IsGround() ||
+		!IsConstant(rule.Head.Value.Value) ||
+		len(rule.Body) != 1 ||
+		len(rule.Body[0].With) > 0 ||
+		rule.Body[0].Negated {
+		return false
+	}
+
+	term, ok := rule.Body[0].Terms.(*Term)
+	return ok && BooleanTerm(true).Equal(term)
+}
+
+func (f *constantFolder) isMocked(ref Ref) bool {
+	for _, target := range f.mocked {
+		if target.HasPrefix(ref) || ref.HasPrefix(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// foldClosures folds the bodies of comprehensions in the expression and
+// replaces closed comprehensions with their values.
+func (f *constantFolder) foldClosures(expr *Expr, protected VarSet) bool {
+
+	changed := false
+
+	vis := NewGenericVisitor(func(x interface{}) bool {
+
+		term, ok := x.(*Term)
+		if !ok {
+			return false
+		}
+
+		var body *Body
+		var heads []**Term
+
+		switch x := term.Value.(type) {
+		case *ArrayComprehension:
+			body, heads = &x.Body, []**Term{&x.Term}
+		case *SetComprehension:
+			body, heads = &x.Body, []**Term{&x.Term}
+		case *ObjectComprehension:
+			body, heads = &x.Body, []**Term{&x.Key, &x.Value}
+		default:
+			return false
+		}
+
+		// Only vars that are not referred to outside of the comprehension may
+		// be substituted inside of it.
+		outer := f.outerVars(term.Value)
+		outer.Update(protected)
+
+		bindings := map[Var]*Term{}
+		before := len(*body)
+		*body = f.foldBody(*body, outer, bindings)
+		changed = changed || len(*body) != before || len(bindings) > 0
+
+		if len(bindings) > 0 {
+			xform := constantBindingTransformer(bindings)
+			for _, h := range heads {
+				*h, _ = transformTerm(xform, *h)
+			}
+		}
+
+		if f.isClosed(term.Value, outer) {
+			if value, err := f.c.constantEvaluator(term); err == nil && value != nil && IsConstant(value.Value) {
+				term.Value = value.Value
+				f.comprehensions++
+				changed = true
+			}
+		}
+
+		return true
+	})
+
+	f.walkOperands(vis, expr)
+
+	return changed
+}
+
+// outerVars returns the vars in the rule being folded that occur outside of
+// the comprehension x.
+func (f *constantFolder) outerVars(x Value) VarSet {
+	result := NewVarSet()
+	walkNonCallVars(f.rule, func(y interface{}) bool {
+		if term, ok := y.(*Term); ok && term.Value == x {
+			return true
+		}
+		if v, ok := y.(Var); ok {
+			result.Add(v)
+		}
+		return false
+	})
+	return result
+}
+
+// isClosed returns true if the comprehension can be evaluated at compile-time,
+// i.e., it does not refer to documents or outer vars, and only calls built-in
+// functions that can be folded.
+func (f *constantFolder) isClosed(x Value, outer VarSet) bool {
+
+	closed := true
+
+	walkNonCallVars(x, func(y interface{}) bool {
+		if v, ok := y.(Var); ok && (outer.Contains(v) || ReservedVars.Contains(v)) {
+			closed = false
+		}
+		return !closed
+	})
+
+	WalkExprs(x, func(expr *Expr) bool {
+		switch {
+		case !closed:
+		case len(expr.With) > 0:
+			closed = false
+		case expr.IsCall() && !expr.IsEquality() && !expr.IsAssignment():
+			_, _, ok := f.foldableBuiltin(expr)
+			closed = ok
+		}
+		return !closed
+	})
+
+	return closed
+}
+
+// walkNonCallVars walks x with the function f, skipping the operators of
+// calls.
+func walkNonCallVars(x interface{}, f func(interface{}) bool) {
+	var vis *GenericVisitor
+	vis = NewGenericVisitor(func(y interface{}) bool {
+		if f(y) {
+			return true
+		}
+		if expr, ok := y.(*Expr); ok && expr.IsCall() {
+			for _, t := range expr.Operands() {
+				vis.Walk(t)
+			}
+			for _, w := range expr.With {
+				vis.Walk(w)
+			}
+			return true
+		}
+		return false
+	})
+	vis.Walk(x)
+}
+
+// foldCall evaluates the expression if it calls a foldable built-in function
+// with constant operands. The result is either an equality expression that
+// binds the output operand or an expression with the value of the call.
+func (f *constantFolder) foldCall(expr *Expr) *Expr {
+
+	if !expr.IsCall() || expr.Negated || expr.IsEquality() || expr.IsAssignment() {
+		return nil
+	}
+
+	bi, inputs, ok := f.foldableBuiltin(expr)
+	if !ok {
+		return nil
+	}
+
+	for _, t := range inputs {
+		if !IsConstant(t.Value) {
+			return nil
+		}
+	}
+
+	call := append([]*Term{expr.OperatorTerm()}, inputs...)
+	value, err := f.c.constantEvaluator(CallTerm(call...))
+	if err != nil || value == nil || !IsConstant(value.Value) {
+		return nil
+	}
+
+	f.calls++
+
+	var result *Expr
+	if operands := expr.Operands(); len(operands) > len(bi.Decl.Args()) {
+		result = Equality.Expr(operands[len(operands)-1], value)
+	} else {
+		result = NewExpr(value)
+	}
+
+	result.SetLocation(expr.Loc())
+	result.Index = expr.Index
+	result.Generated = expr.Generated
+
+	return result
+}
+
+// foldableBuiltin returns the built-in function called by the expression and
+// the input operands if the built-in function can be evaluated at
+// compile-time.
+func (f *constantFolder) foldableBuiltin(expr *Expr) (*Builtin, []*Term, bool) {
+
+	op := expr.Operator()
+	name := op.String()
+
+	bi, ok := f.c.builtins[name]
+	if !ok || bi.Nondeterministic || bi.Relation || bi.Decl.Result() == nil || bi.Decl.FuncArgs().Variadic != nil {
+		return nil, nil, false
+	}
+
+	if _, ok := constantFoldingUnsafeBuiltins[name]; ok || f.isMocked(op) {
+		return nil, nil, false
+	}
+
+	operands := expr.Operands()
+	arity := len(bi.Decl.Args())
+	if len(operands) != arity && len(operands) != arity+1 {
+		return nil, nil, false
+	}
+
+	return bi, operands[:arity], true
+}
+
+// binding returns the var and value if the expression binds a var that may
+// be substituted to a constant.
+func (f *constantFolder) binding(expr *Expr, protected VarSet) (Var, *Term, bool) {
+
+	if !expr.IsEquality() || expr.Negated {
+		return "", nil, false
+	}
+
+	a, b := expr.Operand(0), expr.Operand(1)
+	if _, ok := a.Value.(Var); !ok {
+		a, b = b, a
+	}
+
+	v, ok := a.Value.(Var)
+	if !ok || !IsConstant(b.Value) || v.IsWildcard() || ReservedVars.Contains(v) || protected.Contains(v) || f.ruleVars.Contains(v) {
+		return "", nil, false
+	}
+
+	return v, b, true
+}
+
+// refHeadVars returns the vars that occur as ref heads in the rule. These vars
+// must not be substituted with constants because ref heads must be vars.
+func refHeadVars(rule *Rule) VarSet {
+	result := NewVarSet()
+	WalkRefs(rule, func(ref Ref) bool {
+		if v, ok := ref[0].Value.(Var); ok {
+			result.Add(v)
+		}
+		return false
+	})
+	return result
+}
+
+func constantBindingTransformer(bindings map[Var]*Term) Transformer {
+	return NewGenericTransformer(func(x interface{}) (interface{}, error) {
+		if v, ok := x.(Var); ok {
+			if value, ok := bindings[v]; ok {
+				return value.Value, nil
+			}
+		}
+		return x, nil
+	})
+}
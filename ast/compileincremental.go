@@ -0,0 +1,435 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"sort"
+
+	"github.com/open-policy-agent/opa/types"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// incrementalState holds the results of a previous compilation that the
+// compiler may reuse, and the set of modules that must be recompiled.
+type incrementalState struct {
+	prevModules              map[string]*Module            // compiled modules from the previous compilation
+	prevParsed               map[string]*Module            // parsed modules from the previous compilation
+	prevRuleTree             *TreeNode                     // rule tree from the previous compilation
+	prevRuleIndices          *util.HashMap                 // rule indices from the previous compilation
+	prevTypeEnv              *TypeEnv                      // type environment from the previous compilation
+	prevComprehensionIndices map[*Term]*ComprehensionIndex // comprehension indices from the previous compilation
+	prevRewrittenVars        map[Var]Var                   // rewritten vars from the previous compilation
+	prevMetadataParsed       bool                          // indicates if the previous compilation parsed metadata blocks
+
+	dirty  []string            // sorted names of modules that must be (re)compiled
+	reused map[string]struct{} // names of modules reused from the previous compilation
+}
+
+// WithIncremental enables incremental compilation against a previous
+// compilation. When Compile is called, modules that are unchanged since prev
+// was compiled (and that do not depend on changed modules) are not processed
+// again: their compiled form, rule indices, comprehension indices and inferred
+// types are reused. The remaining compiler stages (e.g., rule tree
+// construction, conflict and recursion checks) still run over the full module
+// set.
+//
+// A module is considered unchanged if it is the compiled module found on prev
+// or if it is identical (including locations and comments) to the parsed
+// module prev was given. Since the latter requires prev to retain the parsed
+// modules, WithIncremental also enables WithKeepModules on the compiler.
+//
+// If prev is nil, failed, or was configured differently (e.g., capabilities,
+// strict mode, print statements, schemas, custom stages or module loaders), or
+// if constant folding is enabled, the compiler falls back to a full
+// compilation. Modules compiled by prev are shared with the compiler and must
+// not be modified by the caller.
+func (c *Compiler) WithIncremental(prev *Compiler) *Compiler {
+	c.prev = prev
+	c.keepModules = true
+	return c
+}
+
+// modulesToCompile returns the sorted names of the modules that per-module
+// compiler stages must process.
+func (c *Compiler) modulesToCompile() []string {
+	if c.incremental == nil {
+		return c.sorted
+	}
+	return c.incremental.dirty
+}
+
+// isReused returns true if the named module has been reused from a previous
+// compilation.
+func (c *Compiler) isReused(name string) bool {
+	if c.incremental == nil {
+		return false
+	}
+	_, ok := c.incremental.reused[name]
+	return ok
+}
+
+// initIncremental sets up incremental compilation for the given input modules.
+// It must be called after the compiler has been initialized but before the
+// compiler's state from a previous compilation is reset.
+func (c *Compiler) initIncremental(modules map[string]*Module) {
+
+	c.incremental = nil
+
+	prev := c.prev
+	if prev == nil || !c.canCompileIncrementally(prev) {
+		return
+	}
+
+	state := &incrementalState{
+		prevModules:              prev.Modules,
+		prevParsed:               prev.parsedModules,
+		prevRuleTree:             prev.RuleTree,
+		prevRuleIndices:          prev.ruleIndices,
+		prevTypeEnv:              prev.TypeEnv,
+		prevComprehensionIndices: prev.comprehensionIndices,
+		prevRewrittenVars:        prev.RewrittenVars,
+		prevMetadataParsed:       prev.metadataParsed,
+		reused:                   map[string]struct{}{},
+	}
+
+	// Determine the modules that changed. The package paths of changed (and
+	// removed) modules are recorded so that dependent modules can be found.
+	var changedPkgs []Ref
+	changed := map[string]struct{}{}
+
+	for name, mod := range modules {
+		compiled, ok := state.prevModules[name]
+		if ok && (mod == compiled || modulesIdentical(mod, state.prevParsed[name])) {
+			continue
+		}
+		changed[name] = struct{}{}
+		changedPkgs = append(changedPkgs, mod.Package.Path)
+		if ok {
+			changedPkgs = append(changedPkgs, compiled.Package.Path)
+		}
+	}
+
+	for name, compiled := range state.prevModules {
+		if _, ok := modules[name]; !ok {
+			changedPkgs = append(changedPkgs, compiled.Package.Path)
+		}
+	}
+
+	// Metadata blocks are parsed lazily. If they were not parsed on the
+	// previous compilation but a changed module calls the rego.metadata
+	// built-ins, the unchanged modules would have to be updated in-place.
+	if !state.prevMetadataParsed {
+		for name := range changed {
+			if callsRegoMetadata(modules[name]) {
+				return
+			}
+		}
+	}
+
+	for name := range modules {
+		if _, ok := changed[name]; ok {
+			state.dirty = append(state.dirty, name)
+		} else if dependsOnPackages(state.prevModules[name], changedPkgs) {
+			state.dirty = append(state.dirty, name)
+		} else {
+			state.reused[name] = struct{}{}
+		}
+	}
+
+	sort.Strings(state.dirty)
+
+	c.incremental = state
+	c.counterAdd(compileIncrementalModulesReused, uint64(len(state.reused)))
+	c.counterAdd(compileIncrementalModulesCompiled, uint64(len(state.dirty)))
+}
+
+// canCompileIncrementally returns true if the results of the previous
+// compilation can be reused by this compiler.
+func (c *Compiler) canCompileIncrementally(prev *Compiler) bool {
+
+	if prev.Failed() || !prev.initialized || prev.TypeEnv == nil {
+		return false
+	}
+
+	if c.moduleLoader != nil || prev.moduleLoader != nil || len(c.after) > 0 || len(prev.after) > 0 {
+		return false
+	}
+
+	// Constant folding inlines references to other rules, so dependencies
+	// between modules cannot be determined from the compiled modules.
+	if c.constantEvaluator != nil || prev.constantEvaluator != nil {
+		return false
+	}
+
+	if c.strict != prev.strict ||
+		c.enablePrintStatements != prev.enablePrintStatements ||
+		c.useTypeCheckAnnotations != prev.useTypeCheckAnnotations ||
+		c.schemaSet != prev.schemaSet {
+		return false
+	}
+
+	if !sameStringSet(c.unsafeBuiltinsMap, prev.unsafeBuiltinsMap) ||
+		!sameStringSet(c.deprecatedBuiltinsMap, prev.deprecatedBuiltinsMap) {
+		return false
+	}
+
+	if len(c.builtins) != len(prev.builtins) {
+		return false
+	}
+
+	for name, bi := range c.builtins {
+		if other, ok := prev.builtins[name]; !ok || types.Compare(bi.Decl, other.Decl) != 0 {
+			return false
+		}
+	}
+
+	if len(c.capabilities.Features) != len(prev.capabilities.Features) {
+		return false
+	}
+
+	for i := range c.capabilities.Features {
+		if c.capabilities.Features[i] != prev.capabilities.Features[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// initIncrementalRewrittenVars carries over the rewritten vars of the reused
+// modules. The exclude set must contain all vars in the module set.
+func (c *Compiler) initIncrementalRewrittenVars(exclude VarSet) {
+	if c.incremental == nil {
+		return
+	}
+	for k, v := range c.incremental.prevRewrittenVars {
+		if exclude.Contains(k) {
+			c.RewrittenVars[k] = v
+		}
+	}
+}
+
+// reusedRuleIndex returns the rule index built by the previous compilation
+// for the given rules, if the previous compilation indexed exactly the same
+// rules at the same node of the rule tree.
+func (c *Compiler) reusedRuleIndex(rules []*Rule) (RuleIndex, bool) {
+	if c.incremental == nil || c.incremental.prevRuleTree == nil {
+		return nil, false
+	}
+
+	path := rules[0].Ref().GroundPrefix()
+	node := c.incremental.prevRuleTree.Find(path)
+	if node == nil {
+		return nil, false
+	}
+
+	prevRules := extractRules(node.Values)
+	for _, child := range node.Children {
+		prevRules = append(prevRules, extractRules(child.Values)...)
+	}
+
+	if len(prevRules) != len(rules) {
+		return nil, false
+	}
+
+	for i := range rules {
+		if rules[i] != prevRules[i] {
+			return nil, false
+		}
+	}
+
+	index, ok := c.incremental.prevRuleIndices.Get(path)
+	if !ok {
+		return nil, false
+	}
+
+	return index.(RuleIndex), true
+}
+
+// reuseComprehensionIndices copies the comprehension indices of the reused
+// modules from the previous compilation.
+func (c *Compiler) reuseComprehensionIndices() {
+	if c.incremental == nil {
+		return
+	}
+	for _, name := range c.sorted {
+		if !c.isReused(name) {
+			continue
+		}
+		WalkTerms(c.Modules[name], func(x *Term) bool {
+			if index, ok := c.incremental.prevComprehensionIndices[x]; ok {
+				c.comprehensionIndices[x] = index
+			}
+			return false
+		})
+	}
+}
+
+// incrementalTypeEnv returns a type environment seeded with the types of rules
+// that do not need to be checked again, along with the subset of sorted rules
+// that must be checked. Rules must be checked if they are defined in a
+// recompiled module, if they (transitively) depend on such rules, or if they
+// share a path with a rule that must be checked (since their types are
+// unioned.)
+func (c *Compiler) incrementalTypeEnv(checker *typeChecker, sorted []util.T) (*TypeEnv, []util.T) {
+
+	// NOTE: The environment is built from scratch because the compiler's own
+	// environment may still contain types from the previous compilation.
+	env := checker.newEnv(checker.Env(c.builtins))
+
+	recheck := map[*Rule]struct{}{}
+	queue := []util.T{}
+
+	for _, name := range c.incremental.dirty {
+		WalkRules(c.Modules[name], func(r *Rule) bool {
+			recheck[r] = struct{}{}
+			queue = append(queue, r)
+			return false
+		})
+	}
+
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		for dep := range c.Graph.Dependents(next) {
+			if _, ok := recheck[dep.(*Rule)]; !ok {
+				recheck[dep.(*Rule)] = struct{}{}
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	groups := NewValueMap()
+	for _, x := range sorted {
+		path := typeEnvPath(x.(*Rule))
+		if _, ok := recheck[x.(*Rule)]; ok {
+			groups.Put(path, Boolean(true))
+		} else if groups.Get(path) == nil {
+			groups.Put(path, Boolean(false))
+		}
+	}
+
+	result := make([]util.T, 0, len(recheck))
+	for _, x := range sorted {
+		path := typeEnvPath(x.(*Rule))
+		if groups.Get(path).(Boolean) {
+			result = append(result, x)
+			continue
+		}
+		if env.tree.Get(path) == nil {
+			if tpe := c.incremental.prevTypeEnv.tree.Get(path); tpe != nil {
+				env.tree.Put(path, tpe)
+			}
+		}
+	}
+
+	return env, result
+}
+
+// typeEnvPath returns the path that the type checker records the rule's type
+// under.
+func typeEnvPath(rule *Rule) Ref {
+	path := rule.Ref()
+	if len(rule.Head.Args) == 0 && rule.Head.RuleKind() == SingleValue {
+		if last := path[len(path)-1]; !last.IsGround() {
+			return path.GroundPrefix()
+		}
+	}
+	return path
+}
+
+// dependsOnPackages returns true if the module is contained in, contains, or
+// refers to documents under any of the given package paths.
+func dependsOnPackages(mod *Module, pkgs []Ref) bool {
+	for _, pkg := range pkgs {
+		if mod.Package.Path.HasPrefix(pkg) || pkg.HasPrefix(mod.Package.Path) {
+			return true
+		}
+	}
+
+	found := false
+	WalkRefs(mod, func(ref Ref) bool {
+		if found || !ref.HasPrefix(DefaultRootRef) {
+			return found
+		}
+		prefix := ref.GroundPrefix()
+		for _, pkg := range pkgs {
+			if prefix.HasPrefix(pkg) || pkg.HasPrefix(prefix) {
+				found = true
+				break
+			}
+		}
+		return found
+	})
+
+	return found
+}
+
+func callsRegoMetadata(mod *Module) bool {
+	found := false
+	WalkExprs(mod, func(expr *Expr) bool {
+		found = found || isRegoMetadataChainCall(expr) || isRegoMetadataRuleCall(expr)
+		return found
+	})
+	return found
+}
+
+// modulesIdentical returns true if the modules are equal, including the
+// locations of all nodes and the comments they contain.
+func modulesIdentical(a, b *Module) bool {
+	if a == b {
+		return true
+	}
+
+	if a == nil || b == nil || !a.Equal(b) || len(a.Comments) != len(b.Comments) {
+		return false
+	}
+
+	for i := range a.Comments {
+		if !a.Comments[i].Equal(b.Comments[i]) {
+			return false
+		}
+	}
+
+	locsA, locsB := nodeLocations(a), nodeLocations(b)
+	if len(locsA) != len(locsB) {
+		return false
+	}
+
+	for i := range locsA {
+		if locsA[i] == nil || locsB[i] == nil {
+			if locsA[i] != locsB[i] {
+				return false
+			}
+		} else if !locsA[i].Equal(locsB[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func nodeLocations(x interface{}) []*Location {
+	var locs []*Location
+	NewGenericVisitor(func(x interface{}) bool {
+		if node, ok := x.(Node); ok {
+			locs = append(locs, node.Loc())
+		}
+		return false
+	}).Walk(x)
+	return locs
+}
+
+func sameStringSet(a, b map[string]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -5,5 +5,10 @@
 package ast
 
 const (
-	compileStageComprehensionIndexBuild = "compile_stage_comprehension_index_build"
+	compileStageComprehensionIndexBuild     = "compile_stage_comprehension_index_build"
+	compileIncrementalModulesReused         = "compile_incremental_modules_reused"
+	compileIncrementalModulesCompiled       = "compile_incremental_modules_compiled"
+	compileStageFoldConstantsCalls          = "compile_stage_fold_constants_calls"
+	compileStageFoldConstantsRefs           = "compile_stage_fold_constants_refs"
+	compileStageFoldConstantsComprehensions = "compile_stage_fold_constants_comprehensions"
 )
@@ -5,9 +5,12 @@
 package ast
 
 import (
+	"bytes"
 	"fmt"
 	"sort"
 	"strings"
+
+	"github.com/agnivade/levenshtein"
 )
 
 // Errors represents a series of errors encountered during parsing, compiling,
@@ -79,10 +82,13 @@ type ErrorDetails interface {
 
 // Error represents a single error caught during parsing, compiling, etc.
 type Error struct {
-	Code     string       `json:"code"`
-	Message  string       `json:"message"`
-	Location *Location    `json:"location,omitempty"`
-	Details  ErrorDetails `json:"details,omitempty"`
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Location  *Location    `json:"location,omitempty"`
+	Details   ErrorDetails `json:"details,omitempty"`
+	Hints     []string     `json:"hints,omitempty"`             // suggestions for fixing the error (e.g., "did you mean ...?")
+	Related   []*Location  `json:"related_locations,omitempty"` // other locations involved in the error (e.g., conflicting rules)
+	CodeFrame string       `json:"code_frame,omitempty"`        // source code at the error location (see SetCodeFrame)
 }
 
 func (e *Error) Error() string {
@@ -113,6 +119,125 @@ func (e *Error) Error() string {
 	return msg
 }
 
+// SetCodeFrame sets the code frame of the error to the line of src that the
+// error location refers to, with the text at the location highlighted. The
+// code frame is not set if the location does not refer to text in src (e.g.,
+// because the source has changed since it was parsed.)
+func (e *Error) SetCodeFrame(src []byte) {
+
+	if e.Location == nil || e.Location.Row < 1 || e.Location.Col < 1 {
+		return
+	}
+
+	lines := bytes.Split(src, []byte("\n"))
+	if e.Location.Row > len(lines) {
+		return
+	}
+
+	line := bytes.TrimSuffix(lines[e.Location.Row-1], []byte("\r"))
+	if e.Location.Col > len(line) {
+		return
+	}
+
+	text := e.Location.Text
+	if i := bytes.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+
+	if !bytes.HasPrefix(line[e.Location.Col-1:], text) {
+		return
+	}
+
+	width := len(text)
+	if width == 0 {
+		width = 1
+	}
+
+	// Preserve tabs so that the carets line up with the text regardless of
+	// the tab width used to display the frame.
+	pad := bytes.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, line[:e.Location.Col-1])
+
+	row := fmt.Sprint(e.Location.Row)
+	e.CodeFrame = fmt.Sprintf("%v | %s\n%v | %s%v",
+		row, line,
+		strings.Repeat(" ", len(row)), pad, strings.Repeat("^", width))
+}
+
+// SetCodeFrames sets the code frames of the errors that refer to the files
+// contained in sources (keyed by file name.) Parse errors are skipped because
+// their details already contain the offending line.
+func (e Errors) SetCodeFrames(sources map[string][]byte) {
+	for _, err := range e {
+		if err.Code == ParseErr || err.Location == nil {
+			continue
+		}
+		if src, ok := sources[err.Location.File]; ok {
+			err.SetCodeFrame(src)
+		}
+	}
+}
+
+// maxSuggestions is the maximum number of "did you mean" hints added to an
+// error.
+const maxSuggestions = 3
+
+// suggest returns "did you mean" hints for the candidates that are similar
+// to name. Candidates are similar if their edit distance to name is small
+// relative to the length of name. No hints are returned for very short names
+// since almost any other short name would be similar.
+func suggest(name string, candidates []string) []string {
+
+	type match struct {
+		name string
+		dist int
+	}
+
+	limit := len(name) / 3
+	if limit == 0 {
+		return nil
+	}
+
+	var matches []match
+	seen := map[string]struct{}{}
+
+	for _, c := range candidates {
+		if _, ok := seen[c]; ok || c == name {
+			continue
+		}
+		seen[c] = struct{}{}
+		if d := levenshtein.ComputeDistance(name, c); d <= limit {
+			matches = append(matches, match{name: c, dist: d})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].dist != matches[j].dist {
+			return matches[i].dist < matches[j].dist
+		}
+		return matches[i].name < matches[j].name
+	})
+
+	if len(matches) == 0 {
+		return nil
+	}
+
+	if len(matches) > maxSuggestions {
+		matches = matches[:maxSuggestions]
+	}
+
+	hints := make([]string, len(matches))
+	for i := range matches {
+		hints[i] = fmt.Sprintf("did you mean %v?", matches[i].name)
+	}
+
+	return hints
+}
+
 // NewError returns a new Error object.
 func NewError(code string, loc *Location, f string, a ...interface{}) *Error {
 	return &Error{
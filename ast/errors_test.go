@@ -39,3 +39,134 @@ foo.rego:100: rego_parse_error: blarg`
 	}
 
 }
+
+func TestErrorSetCodeFrame(t *testing.T) {
+
+	src := []byte("package test\n\np {\n\tx := fooo(1)\n}\n")
+
+	tests := []struct {
+		note     string
+		loc      *Location
+		expected string
+	}{
+		{
+			note:     "highlight text",
+			loc:      &Location{Text: []byte("fooo(1)"), File: "test.rego", Row: 4, Col: 7},
+			expected: "4 | \tx := fooo(1)\n  | \t     ^^^^^^^",
+		},
+		{
+			note:     "no text",
+			loc:      &Location{File: "test.rego", Row: 4, Col: 2},
+			expected: "4 | \tx := fooo(1)\n  | \t^",
+		},
+		{
+			note: "text mismatch",
+			loc:  &Location{Text: []byte("bar"), File: "test.rego", Row: 4, Col: 7},
+		},
+		{
+			note: "row out of range",
+			loc:  &Location{Text: []byte("x"), File: "test.rego", Row: 100, Col: 1},
+		},
+		{
+			note: "col out of range",
+			loc:  &Location{Text: []byte("x"), File: "test.rego", Row: 1, Col: 100},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			err := NewError(CompileErr, tc.loc, "blah")
+			err.SetCodeFrame(src)
+			if err.CodeFrame != tc.expected {
+				t.Fatalf("Expected:\n%v\n\nGot:\n%v", tc.expected, err.CodeFrame)
+			}
+		})
+	}
+}
+
+func TestErrorsStringWithoutHintsAndRelated(t *testing.T) {
+
+	err := NewError(CompileErr, &Location{Text: []byte("p"), File: "a.rego", Row: 3, Col: 1}, "conflicting rules found")
+	err.CodeFrame = "3 | p = 1\n  | ^"
+	err.Hints = []string{"did you mean q?"}
+	err.Related = []*Location{{File: "b.rego", Row: 7, Col: 1}}
+
+	expected := "a.rego:3: rego_compile_error: conflicting rules found"
+
+	if result := err.Error(); result != expected {
+		t.Fatalf("Expected:\n%v\n\nGot:\n%v", expected, result)
+	}
+}
+
+func TestErrorsSetCodeFramesSkipsParseErrors(t *testing.T) {
+
+	sources := map[string][]byte{"a.rego": []byte("package a\np { q }")}
+
+	errs := Errors{
+		NewError(ParseErr, &Location{Text: []byte("q"), File: "a.rego", Row: 2, Col: 5}, "blah"),
+		NewError(CompileErr, &Location{Text: []byte("q"), File: "a.rego", Row: 2, Col: 5}, "blah"),
+		NewError(CompileErr, &Location{Text: []byte("q"), File: "b.rego", Row: 2, Col: 5}, "blah"),
+	}
+
+	errs.SetCodeFrames(sources)
+
+	if errs[0].CodeFrame != "" || errs[2].CodeFrame != "" {
+		t.Fatalf("Expected no code frame but got: %q, %q", errs[0].CodeFrame, errs[2].CodeFrame)
+	}
+
+	if exp := "2 | p { q }\n  |     ^"; errs[1].CodeFrame != exp {
+		t.Fatalf("Expected:\n%v\n\nGot:\n%v", exp, errs[1].CodeFrame)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+
+	tests := []struct {
+		note       string
+		name       string
+		candidates []string
+		expected   []string
+	}{
+		{
+			note:       "close match",
+			name:       "contans",
+			candidates: []string{"contains", "concat", "count"},
+			expected:   []string{"did you mean contains?"},
+		},
+		{
+			note:       "ordered by distance",
+			name:       "allowed",
+			candidates: []string{"allow", "allowe", "deny"},
+			expected:   []string{"did you mean allowe?", "did you mean allow?"},
+		},
+		{
+			note:       "short name",
+			name:       "x",
+			candidates: []string{"y", "z"},
+		},
+		{
+			note:       "no match",
+			name:       "completely",
+			candidates: []string{"different"},
+		},
+		{
+			note:       "exact match excluded",
+			name:       "allow",
+			candidates: []string{"allow"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			result := suggest(tc.name, tc.candidates)
+			if len(result) != len(tc.expected) {
+				t.Fatalf("Expected %v but got %v", tc.expected, result)
+			}
+			for i := range result {
+				if result[i] != tc.expected[i] {
+					t.Fatalf("Expected %v but got %v", tc.expected, result)
+				}
+			}
+		})
+	}
+}
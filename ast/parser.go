@@ -2133,6 +2133,8 @@ type rawAnnotation struct {
 	Scope            string                 `yaml:"scope"`
 	Title            string                 `yaml:"title"`
 	Entrypoint       bool                   `yaml:"entrypoint"`
+	Memoize          *bool                  `yaml:"memoize"`
+	Cache            *bool                  `yaml:"cache"`
 	Description      string                 `yaml:"description"`
 	Organizations    []string               `yaml:"organizations"`
 	RelatedResources []interface{}          `yaml:"related_resources"`
@@ -2190,6 +2192,8 @@ func (b *metadataParser) Parse() (*Annotations, error) {
 	var result Annotations
 	result.Scope = raw.Scope
 	result.Entrypoint = raw.Entrypoint
+	result.Memoize = raw.Memoize
+	result.Cache = raw.Cache
 	result.Title = raw.Title
 	result.Description = raw.Description
 	result.Organizations = raw.Organizations
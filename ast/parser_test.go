@@ -4548,6 +4548,36 @@ p { input = "str" }`,
 				},
 			},
 		},
+		{
+			note: "Memoize",
+			module: `package test
+
+# METADATA
+# memoize: false
+f(x) = x`,
+			expNumComments: 2,
+			expAnnotations: []*Annotations{
+				{
+					Scope:   annotationScopeRule,
+					Memoize: &[]bool{false}[0],
+				},
+			},
+		},
+		{
+			note: "Cache",
+			module: `package test
+
+# METADATA
+# cache: true
+p := input.tenant`,
+			expNumComments: 2,
+			expAnnotations: []*Annotations{
+				{
+					Scope: annotationScopeRule,
+					Cache: &[]bool{true}[0],
+				},
+			},
+		},
 	}
 
 	for _, tc := range tests {
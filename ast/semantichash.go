@@ -0,0 +1,222 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// SemanticHashRule returns a hash of the rule that is insensitive to
+// formatting, comments, locations and the names of local variables. Two rules
+// that only differ in those respects have the same hash. The else chain of
+// the rule is included in the hash.
+//
+// The rule should be taken from a compiled module so that references to
+// other rules, imports and built-in functions are resolved. Otherwise, two
+// rules referring to different rules of the same package through their short
+// names may have the same hash.
+func SemanticHashRule(rule *Rule) string {
+	return hashStrings([]string{semanticRuleString(rule)})
+}
+
+// SemanticHashModule returns a hash of the module that is insensitive to
+// formatting, comments, locations, the names of local variables and the order
+// of rules. Imports and annotations are not included in the hash. See
+// SemanticHashRule for details.
+func SemanticHashModule(mod *Module) string {
+	hashes := make([]string, 0, len(mod.Rules)+1)
+	for _, rule := range mod.Rules {
+		hashes = append(hashes, SemanticHashRule(rule))
+	}
+	sort.Strings(hashes)
+	return hashStrings(append([]string{mod.Package.Path.String()}, hashes...))
+}
+
+// RuleDiffOp describes how the rules producing a document differ between two
+// sets of modules.
+type RuleDiffOp string
+
+const (
+	// RuleAdded indicates that the document is only produced by the new modules.
+	RuleAdded RuleDiffOp = "added"
+
+	// RuleRemoved indicates that the document is only produced by the old modules.
+	RuleRemoved RuleDiffOp = "removed"
+
+	// RuleModified indicates that the rules producing the document differ
+	// semantically between the old and new modules.
+	RuleModified RuleDiffOp = "modified"
+)
+
+// RuleDiff represents a semantic change to the rules producing the document
+// at Path.
+type RuleDiff struct {
+	Path Ref
+	Op   RuleDiffOp
+}
+
+func (d *RuleDiff) String() string {
+	return fmt.Sprintf("%v %v", d.Op, d.Path)
+}
+
+// DiffModules returns the semantic changes between the rules of the old and
+// new modules, sorted by path. Rules are grouped by the (ground prefix of the)
+// path of the document they produce, so adding another definition of an
+// incremental rule or function is reported as a modification of that
+// document. Changes that do not affect the semantic hash of any rule (e.g.,
+// formatting, comments, renamed local variables, moved rules) are not
+// reported. Like SemanticHashRule, DiffModules should be given compiled
+// modules.
+func DiffModules(old, new map[string]*Module) []*RuleDiff {
+
+	oldHashes := hashRulesByPath(old)
+	newHashes := hashRulesByPath(new)
+
+	var result []*RuleDiff
+
+	for key, x := range oldHashes {
+		if y, ok := newHashes[key]; !ok {
+			result = append(result, &RuleDiff{Path: x.path, Op: RuleRemoved})
+		} else if x.hash() != y.hash() {
+			result = append(result, &RuleDiff{Path: x.path, Op: RuleModified})
+		}
+	}
+
+	for key, y := range newHashes {
+		if _, ok := oldHashes[key]; !ok {
+			result = append(result, &RuleDiff{Path: y.path, Op: RuleAdded})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path.Compare(result[j].Path) < 0
+	})
+
+	return result
+}
+
+type pathHashes struct {
+	path   Ref
+	hashes []string
+}
+
+func (p *pathHashes) hash() string {
+	sort.Strings(p.hashes)
+	return hashStrings(p.hashes)
+}
+
+func hashRulesByPath(modules map[string]*Module) map[string]*pathHashes {
+	result := map[string]*pathHashes{}
+	for _, mod := range modules {
+		for _, rule := range mod.Rules {
+			path := mod.Package.Path.Extend(rule.Head.Ref().GroundPrefix())
+			key := path.String()
+			p, ok := result[key]
+			if !ok {
+				p = &pathHashes{path: path}
+				result[key] = p
+			}
+			p.hashes = append(p.hashes, SemanticHashRule(rule))
+		}
+	}
+	return result
+}
+
+func hashStrings(strs []string) string {
+	h := sha256.New()
+	for _, s := range strs {
+		// Length-prefix each string so that different splits of the same
+		// text do not collide.
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// semanticRuleString returns the string representation of a copy of rule
+// where local variables are renamed in order of appearance and head
+// assignment operators are normalized.
+func semanticRuleString(rule *Rule) string {
+
+	keep := semanticGlobalVars(rule)
+	names := map[Var]Var{}
+
+	cpy := rule.Copy()
+	name, ref := cpy.Head.Name, cpy.Head.Reference.Copy()
+
+	x, err := TransformVars(cpy, func(v Var) (Value, error) {
+		if keep.Contains(v) {
+			return v, nil
+		}
+		if n, ok := names[v]; ok {
+			return n, nil
+		}
+		n := Var(fmt.Sprintf("$%d", len(names)))
+		names[v] = n
+		return n, nil
+	})
+	if err != nil {
+		// Renaming vars cannot fail.
+		panic(err)
+	}
+
+	cpy = x.(*Rule)
+
+	// The rule name is not a local variable: restore it on the rule and its
+	// else chain.
+	for r := cpy; r != nil; r = r.Else {
+		r.Head.Name = name
+		if len(ref) > 0 && len(r.Head.Reference) > 0 {
+			r.Head.Reference[0] = ref[0]
+		}
+		r.Head.Assign = false
+	}
+
+	return cpy.String()
+}
+
+// semanticGlobalVars returns the vars in rule that must not be renamed: the
+// root documents and the names of called (or mocked) functions.
+func semanticGlobalVars(rule *Rule) VarSet {
+
+	result := NewVarSet()
+
+	RootDocumentNames.Foreach(func(x *Term) {
+		result.Add(x.Value.(Var))
+	})
+
+	addHead := func(ref Ref) {
+		if len(ref) > 0 {
+			if v, ok := ref[0].Value.(Var); ok {
+				result.Add(v)
+			}
+		}
+	}
+
+	WalkExprs(rule, func(expr *Expr) bool {
+		if expr.IsCall() {
+			addHead(expr.Operator())
+		}
+		for _, w := range expr.With {
+			if ref, ok := w.Target.Value.(Ref); ok {
+				addHead(ref)
+			}
+		}
+		return false
+	})
+
+	WalkTerms(rule, func(term *Term) bool {
+		if call, ok := term.Value.(Call); ok {
+			if ref, ok := call[0].Value.(Ref); ok {
+				addHead(ref)
+			}
+		}
+		return false
+	})
+
+	return result
+}
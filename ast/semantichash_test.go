@@ -0,0 +1,210 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSemanticHashModule(t *testing.T) {
+
+	tests := []struct {
+		note  string
+		a     string
+		b     string
+		equal bool
+	}{
+		{
+			note: "formatting and comments",
+			a: `package test
+			p { input.x == 1; input.y == 2 }`,
+			b: `package test
+
+			# allow if x and y match
+			p {
+				input.x == 1  # x
+				input.y == 2
+			}`,
+			equal: true,
+		},
+		{
+			note: "renamed local vars",
+			a: `package test
+			p[x] { x := input.xs[i]; i > 0 }`,
+			b: `package test
+			p[y] { y := input.xs[j]; j > 0 }`,
+			equal: true,
+		},
+		{
+			note: "renamed function args",
+			a: `package test
+			f(x) = y { y := x + 1 }`,
+			b: `package test
+			f(a) = b { b := a + 1 }`,
+			equal: true,
+		},
+		{
+			note: "rule order",
+			a: `package test
+			p = 1
+			q = 2`,
+			b: `package test
+			q = 2
+			p = 1`,
+			equal: true,
+		},
+		{
+			note: "assignment operator in head",
+			a: `package test
+			p := 1`,
+			b: `package test
+			p = 1`,
+			equal: true,
+		},
+		{
+			note: "unused import",
+			a: `package test
+			import data.foo
+			p = 1`,
+			b: `package test
+			p = 1`,
+			equal: true,
+		},
+		{
+			note: "different value",
+			a: `package test
+			p = 1`,
+			b: `package test
+			p = 2`,
+		},
+		{
+			note: "different rule name",
+			a: `package test
+			p = 1`,
+			b: `package test
+			q = 1`,
+		},
+		{
+			note: "different built-in function",
+			a: `package test
+			p { startswith(input.x, "a") }`,
+			b: `package test
+			p { endswith(input.x, "a") }`,
+		},
+		{
+			note: "different rule reference",
+			a: `package test
+			p { q }
+			q { true }
+			r { true }`,
+			b: `package test
+			p { r }
+			q { true }
+			r { true }`,
+		},
+		{
+			note: "different else",
+			a: `package test
+			p = 1 { input.x } else = 2`,
+			b: `package test
+			p = 1 { input.x } else = 3`,
+		},
+		{
+			note: "different package",
+			a: `package a
+			p = 1`,
+			b: `package b
+			p = 1`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			a := compileSemanticHashModules(t, map[string]string{"test.rego": tc.a})["test.rego"]
+			b := compileSemanticHashModules(t, map[string]string{"test.rego": tc.b})["test.rego"]
+			ha, hb := SemanticHashModule(a), SemanticHashModule(b)
+			if tc.equal && ha != hb {
+				t.Fatalf("Expected equal hashes for:\n%v\n\nand:\n%v", a, b)
+			} else if !tc.equal && ha == hb {
+				t.Fatalf("Expected different hashes for:\n%v\n\nand:\n%v", a, b)
+			}
+		})
+	}
+}
+
+func TestDiffModules(t *testing.T) {
+
+	old := compileSemanticHashModules(t, map[string]string{
+		"a.rego": `package a
+		allow { input.user == "admin" }
+		deny[msg] { msg := "x"; input.x }
+		removed = 1`,
+		"b.rego": `package b
+		f(x) = y { y := x + 1 }
+		unchanged = true`,
+	})
+
+	new := compileSemanticHashModules(t, map[string]string{
+		"a.rego": `package a
+
+		# Formatting and variable names do not matter.
+		allow {
+			input.user == "admin"
+		}
+
+		deny[reason] {
+			reason := "x"
+			input.x
+		}
+
+		deny[reason] {
+			reason := "y"
+			input.y
+		}`,
+		"b.rego": `package b
+		f(a) = b { b := a + 2 }
+		unchanged = true
+		added = 1`,
+	})
+
+	result := DiffModules(old, new)
+
+	expected := []*RuleDiff{
+		{Path: MustParseRef("data.a.deny"), Op: RuleModified},
+		{Path: MustParseRef("data.a.removed"), Op: RuleRemoved},
+		{Path: MustParseRef("data.b.added"), Op: RuleAdded},
+		{Path: MustParseRef("data.b.f"), Op: RuleModified},
+	}
+
+	if !reflect.DeepEqual(diffStrings(result), diffStrings(expected)) {
+		t.Fatalf("Expected %v but got %v", diffStrings(expected), diffStrings(result))
+	}
+
+	if result := DiffModules(old, old); len(result) != 0 {
+		t.Fatalf("Expected no changes but got %v", diffStrings(result))
+	}
+}
+
+func diffStrings(diffs []*RuleDiff) []string {
+	result := make([]string, len(diffs))
+	for i := range diffs {
+		result[i] = diffs[i].String()
+	}
+	return result
+}
+
+func compileSemanticHashModules(t *testing.T, modules map[string]string) map[string]*Module {
+	t.Helper()
+	parsed := make(map[string]*Module, len(modules))
+	for name, module := range modules {
+		parsed[name] = MustParseModule(module)
+	}
+	c := NewCompiler()
+	if c.Compile(parsed); c.Failed() {
+		t.Fatal(c.Errors)
+	}
+	return c.Modules
+}
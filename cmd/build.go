@@ -102,7 +102,9 @@ The -O flag controls the optimization level. By default, optimization is disable
 When optimization is enabled the 'build' command generates a bundle that is semantically
 equivalent to the input files however the structure of the files in the bundle may have
 been changed by rewriting, inlining, pruning, etc. Higher optimization levels may result
-in longer build times.
+in longer build times. When optimization is enabled, calls to deterministic built-in
+functions with constant arguments, references to rules that define constant values, and
+comprehensions that do not depend on input or data are evaluated at build time.
 
 The 'build' command supports targets (specified by -t):
 
@@ -126,6 +126,10 @@ func outputErrors(format string, err error) {
 			fmt.Fprintln(os.Stderr, err.Error())
 		}
 	default:
+		if errs, ok := err.(ast.Errors); ok {
+			// print the code frames, hints and related locations too
+			err = pr.OutputErrors(pr.NewOutputErrors(errs))
+		}
 		fmt.Fprintln(out, err)
 	}
 }
@@ -151,6 +155,7 @@ func init() {
 
 		Run: func(_ *cobra.Command, args []string) {
 			if err := checkModules(checkParams, args); err != nil {
+				pr.SetCodeFrames(err, os.ReadFile)
 				outputErrors(checkParams.format.String(), err)
 				os.Exit(1)
 			}
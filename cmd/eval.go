@@ -446,6 +446,13 @@ func evalOnce(ctx context.Context, ectx *evalContext) pr.Output {
 		}
 	}
 
+	pr.SetCodeFrames(resultErr, func(file string) ([]byte, error) {
+		if file == "" {
+			return []byte(ectx.query), nil
+		}
+		return os.ReadFile(file)
+	})
+
 	result.Errors = pr.NewOutputErrors(resultErr)
 	if ectx.builtInErrorList != nil {
 		for _, err := range *(ectx.builtInErrorList) {
@@ -491,6 +498,7 @@ func evalOnce(ctx context.Context, ectx *evalContext) pr.Output {
 
 type evalContext struct {
 	params           evalCommandParams
+	query            string
 	metrics          metrics.Metrics
 	profiler         *resettableProfiler
 	cover            *cover.Cover
@@ -660,6 +668,7 @@ func setupEval(args []string, params evalCommandParams) (*evalContext, error) {
 
 	evalCtx := &evalContext{
 		params:           params,
+		query:            query,
 		metrics:          m,
 		profiler:         &rp,
 		cover:            c,
@@ -29,6 +29,7 @@ const pageWidth = 80
 type inspectCommandParams struct {
 	outputFormat    *util.EnumFlag
 	listAnnotations bool
+	diff            bool
 }
 
 func newInspectCommandParams() inspectCommandParams {
@@ -46,7 +47,7 @@ func init() {
 	params := newInspectCommandParams()
 
 	var inspectCommand = &cobra.Command{
-		Use:   "inspect <path> [<path> [...]]",
+		Use:   "inspect <path> [<path>]",
 		Short: "Inspect OPA bundle(s)",
 		Long: `Inspect OPA bundle(s).
 
@@ -69,12 +70,29 @@ Example:
 
 You can provide exactly one OPA bundle or path to the 'inspect' command on the command-line. If you provide a path
 referring to a directory, the 'inspect' command will load that path as a bundle and summarize its structure and contents.
+
+Diff
+----
+
+With the --diff flag, the 'inspect' command compiles the policies of two bundles and lists the rules
+that were added, removed or modified between them:
+
+    $ opa inspect --diff old.tar.gz new.tar.gz
+
+Rules are compared semantically: changes to formatting, comments, the names of local variables or
+the order of rules are not reported.
 `,
 		PreRunE: func(_ *cobra.Command, args []string) error {
 			return validateInspectParams(&params, args)
 		},
 		Run: func(_ *cobra.Command, args []string) {
-			if err := doInspect(params, args[0], os.Stdout); err != nil {
+			var err error
+			if params.diff {
+				err = doInspectDiff(params, args[0], args[1], os.Stdout)
+			} else {
+				err = doInspect(params, args[0], os.Stdout)
+			}
+			if err != nil {
 				fmt.Fprintln(os.Stderr, "error:", err)
 				os.Exit(1)
 			}
@@ -83,6 +101,7 @@ referring to a directory, the 'inspect' command will load that path as a bundle
 
 	addOutputFormat(inspectCommand.Flags(), params.outputFormat)
 	addListAnnotations(inspectCommand.Flags(), &params.listAnnotations)
+	inspectCommand.Flags().BoolVar(&params.diff, "diff", false, "list the rules that changed semantically between two bundles")
 	RootCommand.AddCommand(inspectCommand)
 }
 
@@ -119,8 +138,27 @@ func doInspect(params inspectCommandParams, path string, out io.Writer) error {
 	}
 }
 
+func doInspectDiff(params inspectCommandParams, oldPath, newPath string, out io.Writer) error {
+	info, err := ib.Diff(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	switch params.outputFormat.String() {
+	case evalJSONOutput:
+		return pr.JSON(out, info)
+
+	default:
+		return populateDiff(out, info.Rules)
+	}
+}
+
 func validateInspectParams(p *inspectCommandParams, args []string) error {
-	if len(args) != 1 {
+	if p.diff {
+		if len(args) != 2 {
+			return fmt.Errorf("specify exactly two OPA bundles or paths to diff")
+		}
+	} else if len(args) != 1 {
 		return fmt.Errorf("specify exactly one OPA bundle or path")
 	}
 
@@ -198,6 +236,23 @@ func populateNamespaces(out io.Writer, n map[string][]string) error {
 	return nil
 }
 
+func populateDiff(out io.Writer, rules []ib.RuleDiff) error {
+	t := generateTableWithKeys(out, "rule", "change")
+	var lines [][]string
+
+	for _, r := range rules {
+		lines = append(lines, []string{truncateTableStr(r.Path), r.Change})
+	}
+
+	t.AppendBulk(lines)
+	if t.NumLines() > 0 {
+		fmt.Fprintln(out, "RULES:")
+		t.Render()
+	}
+
+	return nil
+}
+
 func populateAnnotations(out io.Writer, refs []*ast.AnnotationsRef) error {
 	if len(refs) > 0 {
 		fmt.Fprintln(out, "ANNOTATIONS:")
@@ -361,3 +361,81 @@ Custom:
 
 	})
 }
+
+func TestDoInspectDiff(t *testing.T) {
+	files := map[string]string{
+		"old/a.rego": `package a
+allow { input.user == "admin" }
+deny[msg] { msg := "x"; input.x }
+removed = 1`,
+		"new/a.rego": `package a
+
+# Formatting, comments and variable names do not matter.
+allow {
+	input.user == "admin"
+}
+
+deny[reason] {
+	reason := "y"
+	input.x
+}
+
+added = 1`,
+	}
+
+	test.WithTempFS(files, func(rootDir string) {
+		oldPath, newPath := filepath.Join(rootDir, "old"), filepath.Join(rootDir, "new")
+
+		var out bytes.Buffer
+		params := newInspectCommandParams()
+		if err := params.outputFormat.Set(evalJSONOutput); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if err := doInspectDiff(params, oldPath, newPath, &out); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		exp := util.MustUnmarshalJSON([]byte(`{"rules": [
+			{"path": "data.a.added", "change": "added"},
+			{"path": "data.a.deny", "change": "modified"},
+			{"path": "data.a.removed", "change": "removed"}
+		]}`))
+		result := util.MustUnmarshalJSON(out.Bytes())
+		if !reflect.DeepEqual(exp, result) {
+			t.Fatalf("expected inspect output to be %v, got %v", exp, result)
+		}
+
+		out.Reset()
+		params = newInspectCommandParams()
+		if err := doInspectDiff(params, oldPath, newPath, &out); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		expected := `RULES:
++----------------+----------+
+|      RULE      |  CHANGE  |
++----------------+----------+
+| data.a.added   | added    |
+| data.a.deny    | modified |
+| data.a.removed | removed  |
++----------------+----------+
+`
+		if out.String() != expected {
+			t.Fatalf("expected:\n%v\n\ngot:\n%v", expected, out.String())
+		}
+	})
+}
+
+func TestInspectDiffParams(t *testing.T) {
+	params := newInspectCommandParams()
+	params.diff = true
+
+	if err := validateInspectParams(&params, []string{"a.tar.gz"}); err == nil {
+		t.Fatal("expected error for a single path")
+	}
+
+	if err := validateInspectParams(&params, []string{"a.tar.gz", "b.tar.gz"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/open-policy-agent/opa/internal/lsp"
+)
+
+func init() {
+
+	var lspCommand = &cobra.Command{
+		Use:   "lsp",
+		Short: "Start a language server for Rego",
+		Long: `Start a language server for Rego.
+
+The 'lsp' command starts a server speaking the Language Server Protocol over
+stdin and stdout. Editors can use the server to provide the following features
+for Rego files:
+
+* diagnostics for parse and compile errors
+* go to definition
+* find references
+* hover information for built-in functions and rules (including annotations)
+* completion for built-in functions, rules and imports
+* document formatting
+
+The server loads the Rego files in the workspace folder passed by the editor
+on initialization. Hidden directories (e.g., .git) are skipped.`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return fmt.Errorf("unexpected arguments")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := lsp.New(os.Stdin, os.Stdout).Serve(context.Background()); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	RootCommand.AddCommand(lspCommand)
+}
@@ -0,0 +1,445 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/merge"
+	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+	"github.com/open-policy-agent/opa/util"
+)
+
+type replayCommandParams struct {
+	dataPaths    repeatedStringFlag
+	bundlePaths  repeatedStringFlag
+	ignore       []string
+	outputFormat *util.EnumFlag
+	verbose      bool
+}
+
+const (
+	replayFormatPretty = "pretty"
+	replayFormatJSON   = "json"
+
+	replayUnchanged = "unchanged"
+	replayChanged   = "changed"
+	replaySkipped   = "skipped"
+)
+
+// errReplayChanged is returned if the result of a replayed decision changed.
+var errReplayChanged = errors.New("decisions changed")
+
+func init() {
+
+	var params replayCommandParams
+
+	params.outputFormat = util.NewEnumFlag(replayFormatPretty, []string{
+		replayFormatPretty, replayFormatJSON,
+	})
+
+	replayCommand := &cobra.Command{
+		Use:   "replay <decision log file> [file [...]]",
+		Short: "Replay decisions from decision logs",
+		Long: `Replay decisions from decision logs against policies and report changed results.
+
+The 'replay' command reads decision log events from the given files ("-" reads
+from stdin), re-evaluates each decision with the logged input against the
+policies and data loaded with --bundle and --data, and compares the result with
+the logged result. Events can be given as JSON arrays, as uploaded by the
+decision log plugin, or as newline-delimited JSON, as printed by the console
+decision logger.
+
+Non-deterministic built-in functions like http.send and time.now_ns return the
+values captured in the 'nd_builtin_cache' of the events, so that the decisions
+are replayed deterministically. Enable the 'decision_logs.nd_builtin_cache'
+option to capture these values; otherwise, the functions are called again.
+Events whose input or result was erased or masked are skipped.
+
+Example:
+
+	$ opa replay --bundle ./new-policy decisions.ndjson
+	9a6c2f3e-5e8b-4b5a-9d7e-2f5d1d0c1a7b: CHANGED
+	  query:    data.authz.allow
+	  logged:   true
+	  replayed: false
+	--------------------------------------------------------------------------------
+	REPLAYED: 120, CHANGED: 1, SKIPPED: 0
+
+The command exits with status 2 if the result of any decision changed.
+`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("specify at least one decision log file")
+			}
+			if len(params.dataPaths.v) == 0 && len(params.bundlePaths.v) == 0 {
+				return errors.New("specify policies with --bundle or --data")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := replay(cmd.Context(), args, params, os.Stdout); err != nil {
+				if err == errReplayChanged {
+					os.Exit(2)
+				}
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addIgnoreFlag(replayCommand.Flags(), &params.ignore)
+	addDataFlag(replayCommand.Flags(), &params.dataPaths)
+	addBundleFlag(replayCommand.Flags(), &params.bundlePaths)
+	addOutputFormat(replayCommand.Flags(), params.outputFormat)
+	replayCommand.Flags().BoolVarP(&params.verbose, "verbose", "v", false, "report unchanged decisions too")
+
+	RootCommand.AddCommand(replayCommand)
+}
+
+// replayEvent holds the fields of a decision log event used for replaying the
+// decision.
+type replayEvent struct {
+	DecisionID     string            `json:"decision_id"`
+	Path           string            `json:"path,omitempty"`
+	Query          string            `json:"query,omitempty"`
+	Input          *interface{}      `json:"input,omitempty"`
+	Result         *interface{}      `json:"result,omitempty"`
+	NDBuiltinCache builtins.NDBCache `json:"nd_builtin_cache,omitempty"`
+	Erased         []string          `json:"erased,omitempty"`
+	Masked         []string          `json:"masked,omitempty"`
+	Error          *interface{}      `json:"error,omitempty"`
+	Timestamp      time.Time         `json:"timestamp"`
+}
+
+// replayResult is the outcome of replaying a decision.
+type replayResult struct {
+	DecisionID     string       `json:"decision_id"`
+	Query          string       `json:"query,omitempty"`
+	Status         string       `json:"status"`
+	Reason         string       `json:"reason,omitempty"`
+	LoggedResult   *interface{} `json:"logged_result,omitempty"`
+	LoggedError    *interface{} `json:"logged_error,omitempty"`
+	ReplayedResult *interface{} `json:"replayed_result,omitempty"`
+	ReplayedError  string       `json:"replayed_error,omitempty"`
+}
+
+func replay(ctx context.Context, args []string, params replayCommandParams, w io.Writer) error {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	compiler, store, err := loadReplayPolicies(params)
+	if err != nil {
+		return err
+	}
+
+	var results []replayResult
+
+	for _, path := range args {
+		err := readReplayEvents(path, func(event *replayEvent) error {
+			results = append(results, replayDecision(ctx, compiler, store, event))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var changed, skipped int
+	for _, r := range results {
+		switch r.Status {
+		case replayChanged:
+			changed++
+		case replaySkipped:
+			skipped++
+		}
+	}
+
+	switch params.outputFormat.String() {
+	case replayFormatJSON:
+		if results == nil {
+			results = []replayResult{}
+		}
+		if err := presentReplayJSON(w, results); err != nil {
+			return err
+		}
+	default:
+		for _, r := range results {
+			if r.Status == replayUnchanged && !params.verbose {
+				continue
+			}
+			presentReplayResult(w, r)
+		}
+		fmt.Fprintln(w, strings.Repeat("-", 80))
+		fmt.Fprintf(w, "REPLAYED: %d, CHANGED: %d, SKIPPED: %d\n", len(results)-skipped, changed, skipped)
+	}
+
+	if changed > 0 {
+		return errReplayChanged
+	}
+
+	return nil
+}
+
+func loadReplayPolicies(params replayCommandParams) (*ast.Compiler, storage.Store, error) {
+
+	modules := map[string]*ast.Module{}
+	data := map[string]interface{}{}
+
+	mergeData := func(docs map[string]interface{}) error {
+		merged, ok := merge.InterfaceMaps(data, docs)
+		if !ok {
+			return errors.New("conflicting data documents")
+		}
+		data = merged
+		return nil
+	}
+
+	if len(params.dataPaths.v) > 0 {
+		f := loaderFilter{
+			Ignore: params.ignore,
+		}
+
+		result, err := loader.NewFileLoader().Filtered(params.dataPaths.v, f.Apply)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, m := range result.Modules {
+			modules[m.Name] = m.Parsed
+		}
+
+		if err := mergeData(result.Documents); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, path := range params.bundlePaths.v {
+		b, err := loader.NewFileLoader().
+			WithSkipBundleVerification(true).
+			WithFilter(buildCommandLoaderFilter(true, params.ignore)).
+			AsBundle(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for name, mod := range b.ParsedModules(path) {
+			modules[name] = mod
+		}
+
+		if err := mergeData(b.Data); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	compiler := ast.NewCompiler()
+	if compiler.Compile(modules); compiler.Failed() {
+		return nil, nil, compiler.Errors
+	}
+
+	return compiler, inmem.NewFromObject(data), nil
+}
+
+// readReplayEvents calls f for each decision log event in the file at path.
+// The file may contain JSON arrays of events or newline-delimited events.
+func readReplayEvents(path string, f func(*replayEvent) error) error {
+
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	decoder := util.NewJSONDecoder(r)
+
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+
+		var events []*replayEvent
+		if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+			if err := util.UnmarshalJSON(raw, &events); err != nil {
+				return fmt.Errorf("%v: %w", path, err)
+			}
+		} else {
+			var event replayEvent
+			if err := util.UnmarshalJSON(raw, &event); err != nil {
+				return fmt.Errorf("%v: %w", path, err)
+			}
+			events = append(events, &event)
+		}
+
+		for _, event := range events {
+			if err := f(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func replayDecision(ctx context.Context, compiler *ast.Compiler, store storage.Store, event *replayEvent) replayResult {
+
+	result := replayResult{
+		DecisionID:   event.DecisionID,
+		Query:        event.Query,
+		LoggedResult: event.Result,
+		LoggedError:  event.Error,
+	}
+
+	var query ast.Body
+	var err error
+
+	switch {
+	case len(event.Erased) > 0 || len(event.Masked) > 0:
+		result.Status, result.Reason = replaySkipped, "event was erased or masked"
+		return result
+	case event.Query != "":
+		query, err = ast.ParseBody(event.Query)
+	case event.Path != "":
+		ref := ast.Ref{ast.DefaultRootDocument}
+		for _, s := range strings.Split(strings.Trim(event.Path, "/"), "/") {
+			if s != "" {
+				ref = append(ref, ast.StringTerm(s))
+			}
+		}
+		query = ast.NewBody(ast.NewExpr(ast.NewTerm(ref)))
+		result.Query = ref.String()
+	default:
+		result.Status, result.Reason = replaySkipped, "event has no path or query"
+		return result
+	}
+	if err != nil {
+		result.Status, result.Reason = replaySkipped, err.Error()
+		return result
+	}
+
+	ndbCache := event.NDBuiltinCache
+	if ndbCache == nil {
+		ndbCache = builtins.NDBCache{}
+	}
+
+	opts := []func(*rego.Rego){
+		rego.Compiler(compiler),
+		rego.Store(store),
+		rego.ParsedQuery(query),
+		rego.NDBuiltinCache(ndbCache),
+	}
+	if event.Input != nil {
+		opts = append(opts, rego.Input(*event.Input))
+	}
+	if !event.Timestamp.IsZero() {
+		opts = append(opts, rego.Time(event.Timestamp))
+	}
+
+	rs, err := rego.New(opts...).Eval(ctx)
+	if err != nil {
+		result.ReplayedError = err.Error()
+		result.Status = replayChanged
+		if event.Error != nil {
+			result.Status = replayUnchanged
+		}
+		return result
+	}
+
+	if event.Query != "" {
+		if len(rs) > 0 {
+			bindings := make([]rego.Vars, len(rs))
+			for i := range rs {
+				bindings[i] = rs[i].Bindings.WithoutWildcards()
+			}
+			var x interface{} = bindings
+			result.ReplayedResult = &x
+		}
+	} else if len(rs) > 0 {
+		result.ReplayedResult = &rs[0].Expressions[0].Value
+	}
+
+	result.Status = replayUnchanged
+	if event.Error != nil || !replayResultsEqual(event.Result, result.ReplayedResult) {
+		result.Status = replayChanged
+	}
+
+	return result
+}
+
+func replayResultsEqual(a, b *interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	va, err := ast.InterfaceToValue(*a)
+	if err != nil {
+		return false
+	}
+	vb, err := ast.InterfaceToValue(*b)
+	if err != nil {
+		return false
+	}
+	return va.Compare(vb) == 0
+}
+
+func presentReplayResult(w io.Writer, r replayResult) {
+	fmt.Fprintf(w, "%v: %v\n", r.DecisionID, strings.ToUpper(r.Status))
+	if r.Query != "" {
+		fmt.Fprintf(w, "  query:    %v\n", r.Query)
+	}
+	if r.Status == replaySkipped {
+		fmt.Fprintf(w, "  reason:   %v\n", r.Reason)
+		return
+	}
+	if r.LoggedError != nil {
+		fmt.Fprintf(w, "  logged:   error: %v\n", replayJSONString(r.LoggedError))
+	} else {
+		fmt.Fprintf(w, "  logged:   %v\n", replayJSONString(r.LoggedResult))
+	}
+	if r.ReplayedError != "" {
+		fmt.Fprintf(w, "  replayed: error: %v\n", r.ReplayedError)
+	} else {
+		fmt.Fprintf(w, "  replayed: %v\n", replayJSONString(r.ReplayedResult))
+	}
+}
+
+func replayJSONString(x *interface{}) string {
+	if x == nil {
+		return "undefined"
+	}
+	bs, err := json.Marshal(*x)
+	if err != nil {
+		return fmt.Sprint(*x)
+	}
+	return string(bs)
+}
+
+func presentReplayJSON(w io.Writer, results []replayResult) error {
+	bs, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(bs))
+	return err
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/util"
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestReplay(t *testing.T) {
+
+	files := map[string]string{
+		"policy/test.rego": `package test
+
+		default allow := false
+
+		allow { input.user == "alice" }
+
+		nd := {"t": time.now_ns(), "status": http.send({"method": "get", "url": "http://localhost:1/x"}).status_code}
+
+		err = 1 { true }
+		err = 2 { input.n == 0 }`,
+		"policy/data.json": `{"test": {"users": ["alice"]}}`,
+		"decisions.ndjson": `{"decision_id": "d1", "path": "test/allow", "input": {"user": "alice"}, "result": true}
+{"decision_id": "d2", "path": "test/allow", "input": {"user": "bob"}, "result": true}
+{"decision_id": "d3", "path": "/test/nd", "result": {"t": 5, "status": 200}, "nd_builtin_cache": {"time.now_ns": {"[]": 5}, "http.send": {"[{\"method\":\"get\",\"url\":\"http://localhost:1/x\"}]": {"status_code": 200}}}}`,
+		"decisions.json": `[
+			{"decision_id": "d4", "query": "data.test.allow = x", "input": {"user": "alice"}, "result": [{"x": true}]},
+			{"decision_id": "d5", "path": "test/allow", "input": {"user": "alice"}, "erased": ["/input/password"], "result": true},
+			{"decision_id": "d6", "path": "test/err", "input": {"n": 0}, "error": {"code": "internal_error"}},
+			{"decision_id": "d7", "path": "test/users", "result": ["alice"]}
+		]`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		params := replayCommandParams{
+			outputFormat: util.NewEnumFlag(replayFormatJSON, []string{replayFormatPretty, replayFormatJSON}),
+		}
+		params.dataPaths.v = []string{filepath.Join(root, "policy")}
+
+		args := []string{filepath.Join(root, "decisions.ndjson"), filepath.Join(root, "decisions.json")}
+
+		var buf bytes.Buffer
+		err := replay(context.Background(), args, params, &buf)
+		if err != errReplayChanged {
+			t.Fatalf("expected changed decisions but got: %v", err)
+		}
+
+		var results []replayResult
+		if err := json.Unmarshal(buf.Bytes(), &results); err != nil {
+			t.Fatal(err)
+		}
+
+		exp := map[string]string{
+			"d1": replayUnchanged,
+			"d2": replayChanged,
+			"d3": replayUnchanged,
+			"d4": replayUnchanged,
+			"d5": replaySkipped,
+			"d6": replayUnchanged,
+			"d7": replayUnchanged,
+		}
+		act := map[string]string{}
+		for _, r := range results {
+			act[r.DecisionID] = r.Status
+		}
+		if !reflect.DeepEqual(exp, act) {
+			t.Fatalf("expected %v but got %v", exp, act)
+		}
+
+		buf.Reset()
+		params.outputFormat.Set(replayFormatPretty)
+		if err := replay(context.Background(), args, params, &buf); err != errReplayChanged {
+			t.Fatalf("expected changed decisions but got: %v", err)
+		}
+
+		for _, s := range []string{
+			"d2: CHANGED\n  query:    data.test.allow\n  logged:   true\n  replayed: false\n",
+			"d5: SKIPPED\n",
+			"REPLAYED: 6, CHANGED: 1, SKIPPED: 1\n",
+		} {
+			if !strings.Contains(buf.String(), s) {
+				t.Fatalf("expected output to contain %q but got:\n%v", s, buf.String())
+			}
+		}
+		if strings.Contains(buf.String(), "d1:") {
+			t.Fatalf("expected unchanged decisions to be omitted but got:\n%v", buf.String())
+		}
+
+		// Without changed decisions, no error is returned.
+		buf.Reset()
+		if err := replay(context.Background(), args[1:], params, &buf); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/open-policy-agent/opa/storage/inmem"
 	"github.com/open-policy-agent/opa/tester"
 	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/topdown/httpfixtures"
 	"github.com/open-policy-agent/opa/topdown/lineage"
 	"github.com/open-policy-agent/opa/util"
 )
@@ -47,6 +48,9 @@ type testCommandParams struct {
 	target       *util.EnumFlag
 	skipExitZero bool
 	capabilities *capabilitiesFlag
+	httpFixtures string
+	record       bool
+	recordHeader []string
 }
 
 func newTestCommandParams() *testCommandParams {
@@ -129,6 +133,25 @@ Example benchmark run:
 	$ opa test --bench ./example/
 
 The optional "gobench" output format conforms to the Go Benchmark Data Format.
+
+If the '--http-fixtures' option is specified, http.send serves the recorded
+request/response pairs in the given directory instead of sending requests over
+the network. Requests without a matching fixture fail. With the '--record'
+option, http.send sends requests over the network and records the responses
+into the directory, replacing earlier recordings of the same requests.
+
+Recorded fixtures contain all request headers except User-Agent and headers
+carrying credentials, like Authorization and Cookie. The '--record-header'
+option records only the given headers instead. Credentials recorded this way
+are stored as SHA-256 digests, which match requests with the same header value.
+
+Example recording and replaying http.send fixtures:
+
+	$ opa test --http-fixtures ./fixtures --record ./example/
+	$ opa test --http-fixtures ./fixtures ./example/
+
+Keep the fixture directory outside of the paths containing the tests, or
+exclude it with '--ignore', so that the fixtures are not loaded as data.
 `,
 	PreRunE: func(Cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
@@ -140,6 +163,13 @@ The optional "gobench" output format conforms to the Go Benchmark Data Format.
 			testParams.verbose = true
 		}
 
+		if testParams.record && testParams.httpFixtures == "" {
+			return fmt.Errorf("--record requires --http-fixtures")
+		}
+		if len(testParams.recordHeader) > 0 && !testParams.record {
+			return fmt.Errorf("--record-header requires --record")
+		}
+
 		return nil
 	},
 
@@ -237,6 +267,19 @@ func opaTest(args []string) int {
 		}
 	}
 
+	var fixtures *httpfixtures.Store
+	if testParams.httpFixtures != "" {
+		if testParams.record {
+			fixtures, err = httpfixtures.NewRecorder(testParams.httpFixtures, testParams.recordHeader)
+		} else {
+			fixtures, err = httpfixtures.Load(testParams.httpFixtures)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
 	runner := tester.NewRunner().
 		SetCompiler(compiler).
 		SetStore(store).
@@ -248,7 +291,8 @@ func opaTest(args []string) int {
 		SetBundles(bundles).
 		SetTimeout(timeout).
 		Filter(testParams.runRegex).
-		Target(testParams.target.String())
+		Target(testParams.target.String()).
+		SetHTTPSendFixtures(fixtures)
 
 	var reporter tester.Reporter
 
@@ -378,6 +422,9 @@ func init() {
 	testCommand.Flags().Float64VarP(&testParams.threshold, "threshold", "", 0, "set coverage threshold and exit with non-zero status if coverage is less than threshold %")
 	testCommand.Flags().BoolVar(&testParams.benchmark, "bench", false, "benchmark the unit tests")
 	testCommand.Flags().StringVarP(&testParams.runRegex, "run", "r", "", "run only test cases matching the regular expression.")
+	testCommand.Flags().StringVar(&testParams.httpFixtures, "http-fixtures", "", "serve http.send requests from the recorded request/response pairs in the directory")
+	testCommand.Flags().BoolVar(&testParams.record, "record", false, "record the responses to http.send requests into the --http-fixtures directory")
+	testCommand.Flags().StringSliceVar(&testParams.recordHeader, "record-header", nil, "record only the given request headers with --record (default all headers except User-Agent and credentials)")
 	addBundleModeFlag(testCommand.Flags(), &testParams.bundleMode, false)
 	addBenchmemFlag(testCommand.Flags(), &testParams.benchMem, true)
 	addCountFlag(testCommand.Flags(), &testParams.count, "test")
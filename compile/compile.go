@@ -30,6 +30,7 @@ import (
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/topdown"
 )
 
 const (
@@ -472,7 +473,7 @@ func (c *Compiler) optimize(ctx context.Context) error {
 
 	if c.optimizationLevel <= 0 {
 		var err error
-		c.compiler, err = compile(c.capabilities, c.bundle, c.debug, c.enablePrintStatements)
+		c.compiler, err = compile(ctx, c.capabilities, c.bundle, c.debug, c.enablePrintStatements, false)
 		return err
 	}
 
@@ -480,6 +481,7 @@ func (c *Compiler) optimize(ctx context.Context) error {
 		WithEntrypoints(c.entrypointrefs).
 		WithDebug(c.debug.Writer()).
 		WithShallowInlining(c.optimizationLevel <= 1).
+		WithConstantFolding(true).
 		WithEnablePrintStatements(c.enablePrintStatements)
 
 	err := o.Do(ctx)
@@ -492,13 +494,13 @@ func (c *Compiler) optimize(ctx context.Context) error {
 	return nil
 }
 
-func (c *Compiler) compilePlan(context.Context) error {
+func (c *Compiler) compilePlan(ctx context.Context) error {
 
 	// Lazily compile the modules if needed. If optimizations were run, the
 	// AST compiler will not be set because the default target does not require it.
 	if c.compiler == nil {
 		var err error
-		c.compiler, err = compile(c.capabilities, c.bundle, c.debug, c.enablePrintStatements)
+		c.compiler, err = compile(ctx, c.capabilities, c.bundle, c.debug, c.enablePrintStatements, c.optimizationLevel > 0)
 		if err != nil {
 			return err
 		}
@@ -734,6 +736,7 @@ type optimizer struct {
 	shallow               bool
 	debug                 debug.Debug
 	enablePrintStatements bool
+	foldConstants         bool
 }
 
 func newOptimizer(c *ast.Capabilities, b *bundle.Bundle) *optimizer {
@@ -769,6 +772,11 @@ func (o *optimizer) WithShallowInlining(yes bool) *optimizer {
 	return o
 }
 
+func (o *optimizer) WithConstantFolding(yes bool) *optimizer {
+	o.foldConstants = yes
+	return o
+}
+
 func (o *optimizer) Do(ctx context.Context) error {
 
 	// NOTE(tsandall): if there are multiple entrypoints, copy the bundle because
@@ -798,7 +806,7 @@ func (o *optimizer) Do(ctx context.Context) error {
 	for i, e := range o.entrypoints {
 
 		var err error
-		o.compiler, err = compile(o.capabilities, o.bundle, o.debug, o.enablePrintStatements)
+		o.compiler, err = compile(ctx, o.capabilities, o.bundle, o.debug, o.enablePrintStatements, o.foldConstants)
 		if err != nil {
 			return err
 		}
@@ -1060,7 +1068,7 @@ func (o *optimizer) getSupportModuleFilename(used map[string]int, module *ast.Mo
 
 var safePathPattern = regexp.MustCompile(`^[\w-_/]+$`)
 
-func compile(c *ast.Capabilities, b *bundle.Bundle, dbg debug.Debug, enablePrintStatements bool) (*ast.Compiler, error) {
+func compile(ctx context.Context, c *ast.Capabilities, b *bundle.Bundle, dbg debug.Debug, enablePrintStatements bool, foldConstants bool) (*ast.Compiler, error) {
 
 	modules := map[string]*ast.Module{}
 
@@ -1073,6 +1081,11 @@ func compile(c *ast.Capabilities, b *bundle.Bundle, dbg debug.Debug, enablePrint
 	}
 
 	compiler := ast.NewCompiler().WithCapabilities(c).WithDebug(dbg.Writer()).WithEnablePrintStatements(enablePrintStatements)
+
+	if foldConstants {
+		compiler.WithConstantFolding(topdown.NewConstantEvaluator(ctx, nil))
+	}
+
 	compiler.Compile(modules)
 
 	if compiler.Failed() {
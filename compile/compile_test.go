@@ -445,6 +445,46 @@ func TestCompilerOptimizationL1(t *testing.T) {
 	})
 }
 
+func TestCompilerOptimizationConstantFolding(t *testing.T) {
+
+	files := map[string]string{
+		"test.rego": `
+			package test
+			p = x { x := concat("/", [input.x, q]) }
+			q = upper(r)
+			r = "a"`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+
+		compiler := New().
+			WithPaths(root).
+			WithOptimizationLevel(1).
+			WithEntrypoints("test/p")
+
+		err := compiler.Build(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		optimizedExp := ast.MustParseModule(`
+			package test
+
+			p = __local0__1 { __local3__1 = input.x; concat("/", [__local3__1, "A"], __local1__1); __local0__1 = __local1__1 }
+		`)
+
+		// The call to upper() is folded into the value of the entrypoint
+		// before partial evaluation.
+		if len(compiler.bundle.Modules) != 2 {
+			t.Fatalf("expected 2 modules but got: %v", compiler.bundle.Modules)
+		}
+
+		if !compiler.bundle.Modules[1].Parsed.Equal(optimizedExp) {
+			t.Fatalf("expected optimized module to be:\n\n%v\n\ngot:\n\n%v", optimizedExp, compiler.bundle.Modules[1])
+		}
+	})
+}
+
 func TestCompilerOptimizationL2(t *testing.T) {
 
 	files := map[string]string{
@@ -38,6 +38,10 @@ type Config struct {
 	Storage                      *struct {
 		Disk json.RawMessage `json:"disk,omitempty"`
 	} `json:"storage,omitempty"`
+	Server *struct {
+		EvaluationLimits json.RawMessage `json:"evaluation_limits,omitempty"`
+		Streaming        json.RawMessage `json:"streaming,omitempty"`
+	} `json:"server,omitempty"`
 }
 
 // ParseConfig returns a valid Config object with defaults injected. The id
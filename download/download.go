@@ -64,6 +64,7 @@ type Downloader struct {
 	persist            bool
 	longPollingEnabled bool
 	lazyLoadingMode    bool
+	processAnnotations bool
 	bundleName         string
 }
 
@@ -128,6 +129,13 @@ func (d *Downloader) WithLazyLoadingMode(yes bool) *Downloader {
 	return d
 }
 
+// WithProcessAnnotations specifies whether the annotations of the policies
+// in the downloaded bundle are processed.
+func (d *Downloader) WithProcessAnnotations(yes bool) *Downloader {
+	d.processAnnotations = yes
+	return d
+}
+
 // WithBundleName specifies the name of the downloaded bundle.
 func (d *Downloader) WithBundleName(bundleName string) *Downloader {
 	d.bundleName = bundleName
@@ -321,6 +329,7 @@ func (d *Downloader) download(ctx context.Context, m metrics.Metrics) (*download
 
 			reader := bundle.NewCustomReader(loader).
 				WithMetrics(m).
+				WithProcessAnnotations(d.processAnnotations).
 				WithBundleVerificationConfig(d.bvc).
 				WithBundleEtag(etag).
 				WithLazyLoadingMode(d.lazyLoadingMode).
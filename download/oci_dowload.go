@@ -44,6 +44,7 @@ type OCIDownloader struct {
 	persist        bool
 	store          *content.OCI
 	etag           string
+	annotations    bool // process the annotations of the policies in the bundle
 }
 
 // New returns a new Downloader that can be started.
@@ -95,6 +96,13 @@ func (d *OCIDownloader) WithBundlePersistence(persist bool) *OCIDownloader {
 	return d
 }
 
+// WithProcessAnnotations specifies whether the annotations of the policies
+// in the downloaded bundle are processed.
+func (d *OCIDownloader) WithProcessAnnotations(yes bool) *OCIDownloader {
+	d.annotations = yes
+	return d
+}
+
 // TODO: remove method ClearCache is deprecated. Use SetCache instead.
 func (d *OCIDownloader) ClearCache() {
 }
@@ -269,6 +277,7 @@ func (d *OCIDownloader) download(ctx context.Context, m metrics.Metrics) (*downl
 	loader := bundle.NewTarballLoaderWithBaseURL(fileReader, d.localStorePath)
 	reader := bundle.NewCustomReader(loader).WithBaseDir(d.localStorePath).
 		WithMetrics(m).
+		WithProcessAnnotations(d.annotations).
 		WithBundleVerificationConfig(d.bvc).
 		WithBundleEtag(etag)
 	bundleInfo, err := reader.Read()
@@ -80,6 +80,64 @@ func File(path string, includeAnnotations bool) (*Info, error) {
 	return bi, nil
 }
 
+// DiffInfo represents the semantic differences between the rules of two
+// bundles.
+type DiffInfo struct {
+	Rules []RuleDiff `json:"rules"`
+}
+
+// RuleDiff represents a change to the rules producing the document at Path.
+type RuleDiff struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+}
+
+// Diff compiles the policies of the bundles at oldPath and newPath and
+// returns the rules that were added, removed or modified. Changes that do not
+// affect the behaviour of the policies (e.g., formatting, comments or renamed
+// local variables) are not reported.
+func Diff(oldPath, newPath string) (*DiffInfo, error) {
+	oldModules, err := compileBundle(oldPath)
+	if err != nil {
+		return nil, err
+	}
+
+	newModules, err := compileBundle(newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := ast.DiffModules(oldModules, newModules)
+
+	di := &DiffInfo{Rules: make([]RuleDiff, 0, len(diffs))}
+	for _, d := range diffs {
+		di.Rules = append(di.Rules, RuleDiff{Path: d.Path.String(), Change: string(d.Op)})
+	}
+
+	return di, nil
+}
+
+func compileBundle(path string) (map[string]*ast.Module, error) {
+	b, err := loader.NewFileLoader().
+		WithSkipBundleVerification(true).
+		AsBundle(path)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := make(map[string]*ast.Module, len(b.Modules))
+	for _, m := range b.Modules {
+		modules[m.Path] = m.Parsed
+	}
+
+	c := ast.NewCompiler().WithEnablePrintStatements(true)
+	if c.Compile(modules); c.Failed() {
+		return nil, c.Errors
+	}
+
+	return c.Modules, nil
+}
+
 func (bi *Info) getBundleDataWasmAndSignatures(name string) error {
 
 	load, err := initload.WalkPaths([]string{name}, nil, true)
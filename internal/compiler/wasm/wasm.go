@@ -170,6 +170,10 @@ var builtinsFunctions = map[string]string{
 	ast.RegexMatchDeprecated.Name:       "opa_regex_match",
 	ast.RegexFindAllStringSubmatch.Name: "opa_regex_find_all_string_submatch",
 	ast.JSONRemove.Name:                 "builtin_json_remove",
+	ast.JSONDiff.Name:                   "builtin_json_diff",
+	ast.JSONMergePatch.Name:             "builtin_json_merge_patch",
+	ast.JSONVerifySchema.Name:           "builtin_json_verify_schema",
+	ast.JSONMatchSchema.Name:            "builtin_json_match_schema",
 	ast.JSONFilter.Name:                 "builtin_json_filter",
 	ast.Member.Name:                     "builtin_member",
 	ast.MemberWithKey.Name:              "builtin_member3",
@@ -0,0 +1,212 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package cron implements schedules in the five-field crontab format:
+// https://man7.org/linux/man-pages/man5/crontab.5.html
+//
+// The fields are minute (0-59), hour (0-23), day of month (1-31), month (1-12
+// or JAN-DEC) and day of week (0-7 or SUN-SAT, where 0 and 7 are Sunday). Each
+// field is a comma-separated list of "*", values, ranges ("a-b") and steps
+// ("*/n", "a-b/n" or "a/n"). "?" is accepted as "*" in the day fields. As with
+// Vixie cron, if both the day of month and the day of week are restricted (do
+// not start with "*" or "?"), a day matches if either of them matches.
+//
+// The descriptors @yearly, @annually, @monthly, @weekly, @daily, @midnight and
+// @hourly are accepted in place of the five fields.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are true if the day fields are unrestricted.
+	domStar, dowStar bool
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type bounds struct {
+	name     string
+	min, max int
+	names    []string
+	// anyDay is true if "?" is accepted as "*".
+	anyDay bool
+}
+
+var (
+	minutes  = bounds{name: "minute", min: 0, max: 59}
+	hours    = bounds{name: "hour", min: 0, max: 23}
+	doms     = bounds{name: "day of month", min: 1, max: 31, anyDay: true}
+	months   = bounds{name: "month", min: 1, max: 12, names: []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}}
+	weekdays = bounds{name: "day of week", min: 0, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}, anyDay: true}
+)
+
+// Parse parses a cron expression.
+func Parse(expr string) (*Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "@") {
+		s, ok := descriptors[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("invalid cron expression %q: unknown descriptor", expr)
+		}
+		spec = s
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	var s Schedule
+	var err error
+	targets := []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, b := range []bounds{minutes, hours, doms, months, weekdays} {
+		if *targets[i], err = parseField(fields[i], b); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+	}
+
+	// Sunday is both 0 and 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[2], "?")
+	s.dowStar = strings.HasPrefix(fields[4], "*") || strings.HasPrefix(fields[4], "?")
+	return &s, nil
+}
+
+// parseField returns the set of values of a field as a bit set.
+func parseField(field string, b bounds) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		lo, hi, step := b.min, b.max, 1
+
+		rng := item
+		if i := strings.Index(item, "/"); i >= 0 {
+			rng = item[:i]
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field %q", b.name, item)
+			}
+			step = n
+		}
+
+		switch {
+		case rng == "*" || (rng == "?" && b.anyDay):
+		case strings.Contains(rng, "-"):
+			i := strings.Index(rng, "-")
+			var err error
+			if lo, err = b.value(rng[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = b.value(rng[i+1:]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range in %s field %q", b.name, item)
+			}
+		default:
+			var err error
+			if lo, err = b.value(rng); err != nil {
+				return 0, err
+			}
+			// A single value is a range up to the maximum if it has a step.
+			if rng == item {
+				hi = lo
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// value parses a number or name of the field.
+func (b bounds) value(s string) (int, error) {
+	for i, name := range b.names {
+		if strings.EqualFold(s, name) {
+			return b.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < b.min || v > b.max {
+		return 0, fmt.Errorf("invalid %s %q", b.name, s)
+	}
+	return v, nil
+}
+
+func has(set uint64, v int) bool {
+	return set&(1<<uint(v)) != 0
+}
+
+// Match returns true if the schedule fires at the minute of t, in the
+// location of t.
+func (s *Schedule) Match(t time.Time) bool {
+	return has(s.minute, t.Minute()) && has(s.hour, t.Hour()) && has(s.month, int(t.Month())) && s.matchDay(t)
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// searchYears bounds the search for the next time a schedule fires. Every
+// schedule that can fire at all does so within eight years, the longest gap
+// between leap years.
+const searchYears = 8
+
+// Next returns the first time after t at which the schedule fires, in the
+// location of t. It returns false if the schedule never fires, like on the
+// 30th of February.
+//
+// On days with daylight saving time transitions, skipped wall clock times do
+// not fire, and repeated wall clock times fire on both occurrences.
+func (s *Schedule) Next(t time.Time) (time.Time, bool) {
+	loc := t.Location()
+	// The next whole minute. Adding to the absolute time rather than the
+	// wall clock makes progress across daylight saving time transitions.
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.Year() + searchYears
+
+	for t.Year() <= limit {
+		var next time.Time
+		switch {
+		case !has(s.month, int(t.Month())):
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchDay(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !has(s.hour, t.Hour()):
+			next = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case !has(s.minute, t.Minute()):
+			next = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}, false
+}
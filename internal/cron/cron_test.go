@@ -0,0 +1,139 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleMatch(t *testing.T) {
+	tests := []struct {
+		expr     string
+		matches  []string
+		nonMatch []string
+	}{
+		{"* * * * *", []string{"2022-01-01T00:00:00Z", "2022-06-15T13:37:59Z"}, nil},
+		{"30 9 * * *", []string{"2022-03-01T09:30:00Z", "2022-03-01T09:30:45Z"}, []string{"2022-03-01T09:31:00Z", "2022-03-01T10:30:00Z"}},
+		{"*/15 * * * *", []string{"2022-03-01T09:00:00Z", "2022-03-01T09:45:00Z"}, []string{"2022-03-01T09:10:00Z"}},
+		{"5/20 * * * *", []string{"2022-03-01T09:05:00Z", "2022-03-01T09:45:00Z"}, []string{"2022-03-01T09:00:00Z"}},
+		{"0 9-17/2 * * *", []string{"2022-03-01T09:00:00Z", "2022-03-01T17:00:00Z"}, []string{"2022-03-01T10:00:00Z", "2022-03-01T19:00:00Z"}},
+		{"* 9-16 * * MON-FRI", []string{"2022-03-04T16:59:00Z"}, []string{"2022-03-05T10:00:00Z", "2022-03-04T17:00:00Z"}},
+		{"0 0 * * 7", []string{"2022-03-06T00:00:00Z"}, []string{"2022-03-07T00:00:00Z"}},
+		{"0 0 * JAN,jul *", []string{"2022-01-10T00:00:00Z", "2022-07-10T00:00:00Z"}, []string{"2022-02-10T00:00:00Z"}},
+		{"0 0 1,15 * MON", []string{"2022-03-01T00:00:00Z", "2022-03-07T00:00:00Z", "2022-03-15T00:00:00Z"}, []string{"2022-03-02T00:00:00Z"}},
+		{"0 0 1 * ?", []string{"2022-03-01T00:00:00Z"}, []string{"2022-03-07T00:00:00Z"}},
+		{"@hourly", []string{"2022-03-01T05:00:00Z"}, []string{"2022-03-01T05:01:00Z"}},
+		{"@WEEKLY", []string{"2022-03-06T00:00:00Z"}, []string{"2022-03-07T00:00:00Z"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			s, err := Parse(tc.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ts := range tc.matches {
+				if !s.Match(mustTime(t, ts)) {
+					t.Errorf("expected %s to match %s", tc.expr, ts)
+				}
+			}
+			for _, ts := range tc.nonMatch {
+				if s.Match(mustTime(t, ts)) {
+					t.Errorf("expected %s not to match %s", tc.expr, ts)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		expr string
+		from time.Time
+		exp  []string
+	}{
+		{"*/15 * * * *", time.Date(2022, 3, 1, 9, 7, 30, 0, time.UTC), []string{"2022-03-01T09:15:00Z", "2022-03-01T09:30:00Z"}},
+		{"0 0 * * *", time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC), []string{"2023-01-01T00:00:00Z", "2023-01-02T00:00:00Z"}},
+		{"0 0 29 2 *", time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), []string{"2024-02-29T00:00:00Z", "2028-02-29T00:00:00Z"}},
+		{"0 0 29 2 *", time.Date(2097, 3, 1, 0, 0, 0, 0, time.UTC), []string{"2104-02-29T00:00:00Z"}},
+		{"0 9 * * MON-FRI", time.Date(2022, 3, 4, 9, 0, 0, 0, time.UTC), []string{"2022-03-07T09:00:00Z", "2022-03-08T09:00:00Z"}},
+		// 2:30 does not exist on 2022-03-13 in New York.
+		{"30 2 * * *", time.Date(2022, 3, 12, 3, 0, 0, 0, newYork), []string{"2022-03-14T02:30:00-04:00"}},
+		// 1:30 occurs twice on 2022-11-06 in New York.
+		{"30 1 * * *", time.Date(2022, 11, 6, 0, 0, 0, 0, newYork), []string{"2022-11-06T01:30:00-04:00", "2022-11-06T01:30:00-05:00", "2022-11-07T01:30:00-05:00"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			s, err := Parse(tc.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := tc.from
+			for _, exp := range tc.exp {
+				var ok bool
+				next, ok = s.Next(next)
+				if !ok {
+					t.Fatalf("expected %s after %v", exp, tc.from)
+				}
+				if !next.Equal(mustTime(t, exp)) {
+					t.Fatalf("expected %s but got %v", exp, next)
+				}
+				if next.Location() != tc.from.Location() {
+					t.Fatalf("expected location %v but got %v", tc.from.Location(), next.Location())
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleNextNever(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, ok := s.Next(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Fatalf("expected no next time but got %v", next)
+	}
+}
+
+func TestParseBadInput(t *testing.T) {
+	bad := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"1,,2 * * * *",
+		"? * * * *",
+		"* * * * MON-",
+		"@every",
+	}
+	for _, expr := range bad {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
+
+func mustTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	ts, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
@@ -24,7 +24,10 @@
 
 package gojsonschema
 
-import "bytes"
+import (
+	"bytes"
+	"strings"
+)
 
 // JSONContext implements a persistent linked-list of strings
 type JSONContext struct {
@@ -71,3 +74,14 @@ func (c *JSONContext) writeStringToBuffer(buf *bytes.Buffer, del []string) {
 
 	buf.WriteString(c.head)
 }
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// Pointer returns the context as a JSON pointer (RFC 6901), relative to the
+// root of the document.
+func (c *JSONContext) Pointer() string {
+	if c.tail == nil {
+		return ""
+	}
+	return c.tail.Pointer() + "/" + pointerEscaper.Replace(c.head)
+}
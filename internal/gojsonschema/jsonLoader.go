@@ -336,6 +336,66 @@ func (l *jsonGoLoader) LoadJSON() (interface{}, error) {
 
 }
 
+// NOTE: Schemas given to policies must neither read files nor make HTTP
+// requests, so their references are resolved by a loader factory that
+// refuses to load anything but the hardcoded metaschemas.
+
+type jsonGoOfflineLoader struct {
+	jsonGoLoader
+}
+
+func (l *jsonGoOfflineLoader) LoaderFactory() JSONLoaderFactory {
+	return &OfflineJSONLoaderFactory{}
+}
+
+// NewGoLoaderOffline creates a new JSONLoader from a given Go struct, whose
+// remote references are not loaded.
+func NewGoLoaderOffline(source interface{}) JSONLoader {
+	return &jsonGoOfflineLoader{jsonGoLoader{source: source}}
+}
+
+// OfflineJSONLoaderFactory is a JSON loader factory whose loaders refuse to
+// load remote references.
+type OfflineJSONLoaderFactory struct {
+}
+
+// New creates a new JSON loader for the given source
+func (f OfflineJSONLoaderFactory) New(source string) JSONLoader {
+	return &jsonOfflineReferenceLoader{source: source}
+}
+
+type jsonOfflineReferenceLoader struct {
+	source string
+}
+
+func (l *jsonOfflineReferenceLoader) JSONSource() interface{} {
+	return l.source
+}
+
+func (l *jsonOfflineReferenceLoader) JSONReference() (gojsonreference.JsonReference, error) {
+	return gojsonreference.NewJsonReference(l.source)
+}
+
+func (l *jsonOfflineReferenceLoader) LoaderFactory() JSONLoaderFactory {
+	return &OfflineJSONLoaderFactory{}
+}
+
+func (l *jsonOfflineReferenceLoader) LoadJSON() (interface{}, error) {
+	reference, err := gojsonreference.NewJsonReference(l.source)
+	if err != nil {
+		return nil, err
+	}
+
+	refToURL := reference
+	refToURL.GetUrl().Fragment = ""
+
+	if metaSchema := drafts.GetMetaSchema(refToURL.String()); metaSchema != "" {
+		return decodeJSONUsingNumber(strings.NewReader(metaSchema))
+	}
+
+	return nil, fmt.Errorf("remote reference loading disabled: %s", reference.String())
+}
+
 type jsonIOLoader struct {
 	buf *bytes.Buffer
 }
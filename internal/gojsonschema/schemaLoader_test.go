@@ -175,3 +175,24 @@ func TestParseSchemaURL_NotMap(t *testing.T) {
 	require.Error(t, err)
 	assert.EqualError(t, err, "schema is invalid")
 }
+
+func TestOfflineLoader(t *testing.T) {
+	_, err := NewSchemaLoader().Compile(NewGoLoaderOffline(map[string]interface{}{
+		"$ref": "http://localhost:1234/test1.json",
+	}))
+	require.EqualError(t, err, "remote reference loading disabled: http://localhost:1234/test1.json")
+
+	schema, err := NewSchemaLoader().Compile(NewGoLoaderOffline(map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"a/b": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+	}))
+	require.NoError(t, err)
+
+	result, err := schema.Validate(NewGoLoader(map[string]interface{}{"a/b": []interface{}{"x", 1}}))
+	require.NoError(t, err)
+	require.Len(t, result.Errors(), 1)
+	assert.Equal(t, "/a~1b/1", result.Errors()[0].Context().Pointer())
+}
@@ -0,0 +1,464 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package jsonpath implements JSONPath queries as described in RFC 9535:
+// https://www.rfc-editor.org/rfc/rfc9535
+//
+// Queries are evaluated against Rego values. Objects with non-string keys can
+// only be traversed with wildcards and filters, and sets are not traversed.
+package jsonpath
+
+import (
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// Query is a parsed JSONPath query.
+type Query struct {
+	source   string
+	segments []segment
+}
+
+// Match is a node selected by a query: a value and its location.
+type Match struct {
+	// Path holds the keys and array indices leading from the queried value
+	// to Value.
+	Path  []*ast.Term
+	Value ast.Value
+}
+
+// String returns the source of the query.
+func (q *Query) String() string {
+	return q.source
+}
+
+// Eval returns the nodes of root selected by the query.
+func (q *Query) Eval(root ast.Value) []Match {
+	nodes := evalSegments(q.segments, root, []Match{{Value: root}})
+	if nodes == nil {
+		return []Match{}
+	}
+	return nodes
+}
+
+type segment struct {
+	descendant bool
+	selectors  []selector
+}
+
+func evalSegments(segments []segment, root ast.Value, nodes []Match) []Match {
+	for _, seg := range segments {
+		var out []Match
+		for _, n := range nodes {
+			if seg.descendant {
+				out = descend(seg.selectors, root, n, out)
+			} else {
+				out = selectAll(seg.selectors, root, n, out)
+			}
+		}
+		nodes = out
+	}
+	return nodes
+}
+
+// descend applies the selectors to n and its descendants, parents before
+// their children.
+func descend(selectors []selector, root ast.Value, n Match, out []Match) []Match {
+	out = selectAll(selectors, root, n, out)
+	for _, child := range children(n) {
+		out = descend(selectors, root, child, out)
+	}
+	return out
+}
+
+func selectAll(selectors []selector, root ast.Value, n Match, out []Match) []Match {
+	for _, s := range selectors {
+		out = s.apply(root, n, out)
+	}
+	return out
+}
+
+// child returns the node of value at key below n.
+func child(n Match, key *ast.Term, value ast.Value) Match {
+	path := make([]*ast.Term, len(n.Path), len(n.Path)+1)
+	copy(path, n.Path)
+	return Match{Path: append(path, key), Value: value}
+}
+
+// children returns the array elements, or object members, of n in order.
+func children(n Match) []Match {
+	switch v := n.Value.(type) {
+	case *ast.Array:
+		out := make([]Match, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out = append(out, child(n, ast.IntNumberTerm(i), v.Elem(i).Value))
+		}
+		return out
+	case ast.Object:
+		out := make([]Match, 0, v.Len())
+		v.Foreach(func(k, x *ast.Term) {
+			out = append(out, child(n, k, x.Value))
+		})
+		return out
+	}
+	return nil
+}
+
+type selector interface {
+	// apply appends the children of n selected to out.
+	apply(root ast.Value, n Match, out []Match) []Match
+}
+
+type nameSelector string
+
+func (s nameSelector) apply(_ ast.Value, n Match, out []Match) []Match {
+	if obj, ok := n.Value.(ast.Object); ok {
+		key := ast.StringTerm(string(s))
+		if v := obj.Get(key); v != nil {
+			out = append(out, child(n, key, v.Value))
+		}
+	}
+	return out
+}
+
+type wildcardSelector struct{}
+
+func (wildcardSelector) apply(_ ast.Value, n Match, out []Match) []Match {
+	return append(out, children(n)...)
+}
+
+type indexSelector int
+
+func (s indexSelector) apply(_ ast.Value, n Match, out []Match) []Match {
+	if arr, ok := n.Value.(*ast.Array); ok {
+		i := int(s)
+		if i < 0 {
+			i += arr.Len()
+		}
+		if i >= 0 && i < arr.Len() {
+			out = append(out, child(n, ast.IntNumberTerm(i), arr.Elem(i).Value))
+		}
+	}
+	return out
+}
+
+type sliceSelector struct {
+	start, end *int
+	step       int
+}
+
+func (s sliceSelector) apply(_ ast.Value, n Match, out []Match) []Match {
+	arr, ok := n.Value.(*ast.Array)
+	if !ok || s.step == 0 {
+		return out
+	}
+
+	length := arr.Len()
+	bound := func(i *int, def int) int {
+		if i == nil {
+			return def
+		}
+		if *i < 0 {
+			return *i + length
+		}
+		return *i
+	}
+	clamp := func(i, lo, hi int) int {
+		if i < lo {
+			return lo
+		}
+		if i > hi {
+			return hi
+		}
+		return i
+	}
+
+	if s.step > 0 {
+		lower := clamp(bound(s.start, 0), 0, length)
+		upper := clamp(bound(s.end, length), 0, length)
+		for i := lower; i < upper; i += s.step {
+			out = append(out, child(n, ast.IntNumberTerm(i), arr.Elem(i).Value))
+		}
+	} else {
+		upper := clamp(bound(s.start, length-1), -1, length-1)
+		lower := clamp(bound(s.end, -length-1), -1, length-1)
+		for i := upper; lower < i; i += s.step {
+			out = append(out, child(n, ast.IntNumberTerm(i), arr.Elem(i).Value))
+		}
+	}
+	return out
+}
+
+type filterSelector struct {
+	expr logicalExpr
+}
+
+func (s filterSelector) apply(root ast.Value, n Match, out []Match) []Match {
+	for _, c := range children(n) {
+		if s.expr.test(root, c.Value) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// logicalExpr is an expression of a filter selector.
+type logicalExpr interface {
+	test(root, current ast.Value) bool
+}
+
+type orExpr []logicalExpr
+
+func (e orExpr) test(root, current ast.Value) bool {
+	for _, x := range e {
+		if x.test(root, current) {
+			return true
+		}
+	}
+	return false
+}
+
+type andExpr []logicalExpr
+
+func (e andExpr) test(root, current ast.Value) bool {
+	for _, x := range e {
+		if !x.test(root, current) {
+			return false
+		}
+	}
+	return true
+}
+
+type notExpr struct {
+	expr logicalExpr
+}
+
+func (e notExpr) test(root, current ast.Value) bool {
+	return !e.expr.test(root, current)
+}
+
+// existenceExpr tests that a query selects at least one node.
+type existenceExpr struct {
+	query *filterQuery
+}
+
+func (e existenceExpr) test(root, current ast.Value) bool {
+	return len(e.query.eval(root, current)) > 0
+}
+
+// functionTestExpr tests the result of a function returning a logical value.
+type functionTestExpr struct {
+	fn *functionExpr
+}
+
+func (e functionTestExpr) test(root, current ast.Value) bool {
+	return e.fn.logical(root, current)
+}
+
+type comparisonExpr struct {
+	op          string
+	left, right operand
+}
+
+func (e comparisonExpr) test(root, current ast.Value) bool {
+	a, aok := e.left.value(root, current)
+	b, bok := e.right.value(root, current)
+
+	switch e.op {
+	case "==":
+		return equal(a, aok, b, bok)
+	case "!=":
+		return !equal(a, aok, b, bok)
+	case "<":
+		return less(a, aok, b, bok)
+	case "<=":
+		return less(a, aok, b, bok) || equal(a, aok, b, bok)
+	case ">":
+		return less(b, bok, a, aok)
+	default: // ">="
+		return less(b, bok, a, aok) || equal(a, aok, b, bok)
+	}
+}
+
+// equal compares two values, where absent values (Nothing) are equal to each
+// other only.
+func equal(a ast.Value, aok bool, b ast.Value, bok bool) bool {
+	if !aok || !bok {
+		return aok == bok
+	}
+	return ast.Compare(a, b) == 0
+}
+
+// less orders numbers and strings. Other values are not ordered.
+func less(a ast.Value, aok bool, b ast.Value, bok bool) bool {
+	if !aok || !bok {
+		return false
+	}
+	switch a.(type) {
+	case ast.Number:
+		if _, ok := b.(ast.Number); ok {
+			return ast.Compare(a, b) < 0
+		}
+	case ast.String:
+		if _, ok := b.(ast.String); ok {
+			return ast.Compare(a, b) < 0
+		}
+	}
+	return false
+}
+
+// operand is an operand of a comparison, or a function argument of value
+// type. It returns false if it has no value.
+type operand interface {
+	value(root, current ast.Value) (ast.Value, bool)
+}
+
+type literal struct {
+	v ast.Value
+}
+
+func (l literal) value(ast.Value, ast.Value) (ast.Value, bool) {
+	return l.v, true
+}
+
+// filterQuery is a query in a filter, relative to the current node ("@") or
+// to the root ("$").
+type filterQuery struct {
+	relative bool
+	segments []segment
+}
+
+func (q *filterQuery) eval(root, current ast.Value) []Match {
+	start := root
+	if q.relative {
+		start = current
+	}
+	return evalSegments(q.segments, root, []Match{{Value: start}})
+}
+
+// singular returns true if the query selects at most one node.
+func (q *filterQuery) singular() bool {
+	for _, seg := range q.segments {
+		if seg.descendant || len(seg.selectors) != 1 {
+			return false
+		}
+		switch seg.selectors[0].(type) {
+		case nameSelector, indexSelector:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (q *filterQuery) value(root, current ast.Value) (ast.Value, bool) {
+	nodes := q.eval(root, current)
+	if len(nodes) != 1 {
+		return nil, false
+	}
+	return nodes[0].Value, true
+}
+
+// The types of function parameters and results, see
+// https://www.rfc-editor.org/rfc/rfc9535#section-2.4.1
+type functionType int
+
+const (
+	valueType functionType = iota
+	logicalType
+	nodesType
+)
+
+type function struct {
+	params []functionType
+	result functionType
+}
+
+var functions = map[string]function{
+	"length": {params: []functionType{valueType}, result: valueType},
+	"count":  {params: []functionType{nodesType}, result: valueType},
+	"match":  {params: []functionType{valueType, valueType}, result: logicalType},
+	"search": {params: []functionType{valueType, valueType}, result: logicalType},
+	"value":  {params: []functionType{nodesType}, result: valueType},
+}
+
+// functionArg is an argument of a function: a filter query for parameters of
+// nodes type, or an operand for parameters of value type.
+type functionArg struct {
+	query *filterQuery
+	value operand
+}
+
+type functionExpr struct {
+	name string
+	args []functionArg
+	// re is the regular expression of match and search if it is a literal.
+	re *regexp.Regexp
+}
+
+func (f *functionExpr) value(root, current ast.Value) (ast.Value, bool) {
+	switch f.name {
+	case "length":
+		v, ok := f.args[0].value.value(root, current)
+		if !ok {
+			return nil, false
+		}
+		switch v := v.(type) {
+		case ast.String:
+			return ast.IntNumberTerm(utf8.RuneCountInString(string(v))).Value, true
+		case *ast.Array:
+			return ast.IntNumberTerm(v.Len()).Value, true
+		case ast.Object:
+			return ast.IntNumberTerm(v.Len()).Value, true
+		}
+		return nil, false
+	case "count":
+		return ast.IntNumberTerm(len(f.args[0].query.eval(root, current))).Value, true
+	default: // "value"
+		nodes := f.args[0].query.eval(root, current)
+		if len(nodes) != 1 {
+			return nil, false
+		}
+		return nodes[0].Value, true
+	}
+}
+
+func (f *functionExpr) logical(root, current ast.Value) bool {
+	v, ok := f.args[0].value.value(root, current)
+	if !ok {
+		return false
+	}
+	s, ok := v.(ast.String)
+	if !ok {
+		return false
+	}
+
+	re := f.re
+	if re == nil {
+		pattern, ok := f.args[1].value.value(root, current)
+		if !ok {
+			return false
+		}
+		p, ok := pattern.(ast.String)
+		if !ok {
+			return false
+		}
+		var err error
+		if re, err = compileRegexp(f.name, string(p)); err != nil {
+			return false
+		}
+	}
+	return re.MatchString(string(s))
+}
+
+// compileRegexp compiles the pattern of match, which must match the whole
+// string, or search.
+func compileRegexp(name, pattern string) (*regexp.Regexp, error) {
+	if name == "match" {
+		pattern = `^(?:` + pattern + `)$`
+	}
+	return regexp.Compile(pattern)
+}
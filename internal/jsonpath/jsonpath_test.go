@@ -0,0 +1,432 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package jsonpath
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// The example document of RFC 9535, section 1.5.
+const store = `{ "store": {
+    "book": [
+      { "category": "reference",
+        "author": "Nigel Rees",
+        "title": "Sayings of the Century",
+        "price": 8.95
+      },
+      { "category": "fiction",
+        "author": "Evelyn Waugh",
+        "title": "Sword of Honour",
+        "price": 12.99
+      },
+      { "category": "fiction",
+        "author": "Herman Melville",
+        "title": "Moby Dick",
+        "isbn": "0-553-21311-3",
+        "price": 8.99
+      },
+      { "category": "fiction",
+        "author": "J. R. R. Tolkien",
+        "title": "The Lord of the Rings",
+        "isbn": "0-395-19395-8",
+        "price": 22.99
+      }
+    ],
+    "bicycle": {
+      "color": "red",
+      "price": 399
+    }
+  }
+}`
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		note     string
+		document string
+		query    string
+		values   string
+		paths    string
+	}{
+		{
+			note:     "root",
+			document: `{"a": 1}`,
+			query:    `$`,
+			values:   `[{"a": 1}]`,
+			paths:    `[[]]`,
+		},
+		{
+			note:     "authors",
+			document: store,
+			query:    `$.store.book[*].author`,
+			values:   `["Nigel Rees", "Evelyn Waugh", "Herman Melville", "J. R. R. Tolkien"]`,
+			paths:    `[["store", "book", 0, "author"], ["store", "book", 1, "author"], ["store", "book", 2, "author"], ["store", "book", 3, "author"]]`,
+		},
+		{
+			note:     "descendant authors",
+			document: store,
+			query:    `$..author`,
+			values:   `["Nigel Rees", "Evelyn Waugh", "Herman Melville", "J. R. R. Tolkien"]`,
+		},
+		{
+			note:     "store prices",
+			document: store,
+			query:    `$.store..price`,
+			values:   `[399, 8.95, 12.99, 8.99, 22.99]`,
+		},
+		{
+			note:     "third book",
+			document: store,
+			query:    `$..book[2].title`,
+			values:   `["Moby Dick"]`,
+			paths:    `[["store", "book", 2, "title"]]`,
+		},
+		{
+			note:     "last book",
+			document: store,
+			query:    `$..book[-1].title`,
+			values:   `["The Lord of the Rings"]`,
+		},
+		{
+			note:     "first two books",
+			document: store,
+			query:    `$..book[0,1].title`,
+			values:   `["Sayings of the Century", "Sword of Honour"]`,
+		},
+		{
+			note:     "books with isbn",
+			document: store,
+			query:    `$..book[?@.isbn].title`,
+			values:   `["Moby Dick", "The Lord of the Rings"]`,
+		},
+		{
+			note:     "cheap books",
+			document: store,
+			query:    `$..book[?@.price<10].title`,
+			values:   `["Sayings of the Century", "Moby Dick"]`,
+		},
+		{
+			note:     "bracket notation",
+			document: `{"o": {"j j": {"k.k": 3}}, "'": {"@": 2}}`,
+			query:    `$["o"]['j j']["k.k"]`,
+			values:   `[3]`,
+		},
+		{
+			note:     "escapes",
+			document: `{"'": {"@": 2}, "☺": 1}`,
+			query:    `$['\'']["@"]`,
+			values:   `[2]`,
+		},
+		{
+			note:     "unicode escape",
+			document: `{"☺": 1, "😀": 2}`,
+			query:    `$["\u263A", "\uD83D\uDE00"]`,
+			values:   `[1, 2]`,
+		},
+		{
+			note:     "non-ascii shorthand",
+			document: `{"☺": 1}`,
+			query:    `$.☺`,
+			values:   `[1]`,
+		},
+		{
+			note:     "whitespace",
+			document: `{"a": [1, 2, 3]}`,
+			query:    `$ .a [ 0 , 2 ]`,
+			values:   `[1, 3]`,
+		},
+		{
+			note:     "wildcard object",
+			document: `{"o": {"j": 1, "k": 2}, "a": [5, 3]}`,
+			query:    `$.o.*`,
+			values:   `[1, 2]`,
+			paths:    `[["o", "j"], ["o", "k"]]`,
+		},
+		{
+			note:     "wildcard array",
+			document: `{"o": {"j": 1, "k": 2}, "a": [5, 3]}`,
+			query:    `$.a[*]`,
+			values:   `[5, 3]`,
+		},
+		{
+			note:     "index out of bounds",
+			document: `["a", "b"]`,
+			query:    `$[2]`,
+			values:   `[]`,
+		},
+		{
+			note:     "slice",
+			document: `["a", "b", "c", "d", "e", "f", "g"]`,
+			query:    `$[1:3]`,
+			values:   `["b", "c"]`,
+		},
+		{
+			note:     "slice from",
+			document: `["a", "b", "c", "d", "e", "f", "g"]`,
+			query:    `$[5:]`,
+			values:   `["f", "g"]`,
+		},
+		{
+			note:     "slice step",
+			document: `["a", "b", "c", "d", "e", "f", "g"]`,
+			query:    `$[1:5:2]`,
+			values:   `["b", "d"]`,
+		},
+		{
+			note:     "slice negative step",
+			document: `["a", "b", "c", "d", "e", "f", "g"]`,
+			query:    `$[5:1:-2]`,
+			values:   `["f", "d"]`,
+			paths:    `[[5], [3]]`,
+		},
+		{
+			note:     "slice reverse",
+			document: `["a", "b", "c", "d", "e", "f", "g"]`,
+			query:    `$[::-1]`,
+			values:   `["g", "f", "e", "d", "c", "b", "a"]`,
+		},
+		{
+			note:     "slice zero step",
+			document: `["a", "b", "c"]`,
+			query:    `$[::0]`,
+			values:   `[]`,
+		},
+		{
+			note:     "slice out of bounds",
+			document: `["a", "b", "c"]`,
+			query:    `$[-10:10]`,
+			values:   `["a", "b", "c"]`,
+		},
+		{
+			note:     "filter equal",
+			document: `{"a": [3, 5, 1, 2, 4, 6, {"b": "j"}, {"b": "k"}, {"b": {}}, {"b": "kilo"}]}`,
+			query:    `$.a[?@.b == 'kilo']`,
+			values:   `[{"b": "kilo"}]`,
+			paths:    `[["a", 9]]`,
+		},
+		{
+			note:     "filter parentheses",
+			document: `{"a": [3, 5, 1, 2, 4, 6, {"b": "j"}, {"b": "k"}, {"b": {}}, {"b": "kilo"}]}`,
+			query:    `$.a[?(@.b == 'kilo')]`,
+			values:   `[{"b": "kilo"}]`,
+		},
+		{
+			note:     "filter current",
+			document: `{"a": [3, 5, 1, 2, 4, 6, {"b": "j"}, {"b": "k"}, {"b": {}}, {"b": "kilo"}]}`,
+			query:    `$.a[?@>3.5]`,
+			values:   `[5, 4, 6]`,
+		},
+		{
+			note:     "filter existence",
+			document: `{"a": [3, 5, 1, 2, 4, 6, {"b": "j"}, {"b": "k"}, {"b": {}}, {"b": "kilo"}]}`,
+			query:    `$.a[?@.b]`,
+			values:   `[{"b": "j"}, {"b": "k"}, {"b": {}}, {"b": "kilo"}]`,
+		},
+		{
+			note:     "filter root",
+			document: `{"a": [1, 2, 3], "max": 2}`,
+			query:    `$.a[?@ <= $.max]`,
+			values:   `[1, 2]`,
+		},
+		{
+			note:     "filter or",
+			document: `{"a": [3, 5, 1, 2, 4, 6]}`,
+			query:    `$.a[?@<2 || @>5]`,
+			values:   `[1, 6]`,
+		},
+		{
+			note:     "filter and not",
+			document: `{"a": [3, 5, 1, 2, 4, 6]}`,
+			query:    `$.a[?@>1 && !(@ == 3 || @ >= 5)]`,
+			values:   `[2, 4]`,
+		},
+		{
+			note:     "filter absent equals absent",
+			document: `{"a": [{"x": 1}, {"y": 1}], "b": {}}`,
+			query:    `$.a[?@.x == $.b.x]`,
+			values:   `[{"y": 1}]`,
+		},
+		{
+			note:     "filter structured equality",
+			document: `{"a": [{"x": [1, {"y": 2.0}]}, {"x": [1]}]}`,
+			query:    `$.a[?@.x == $.a[0].x]`,
+			values:   `[{"x": [1, {"y": 2.0}]}]`,
+		},
+		{
+			note:     "filter number equality",
+			document: `[1, 1.0, 1e0, 2]`,
+			query:    `$[?@ == 1]`,
+			values:   `[1, 1.0, 1e0]`,
+		},
+		{
+			note:     "filter literals",
+			document: `[true, false, null, "true"]`,
+			query:    `$[?@ == true || @ == null]`,
+			values:   `[true, null]`,
+		},
+		{
+			note:     "filter strings are not ordered with numbers",
+			document: `[1, "1", "a"]`,
+			query:    `$[?@ < "b"]`,
+			values:   `["1", "a"]`,
+		},
+		{
+			note:     "filter on object members",
+			document: `{"x": {"a": 1}, "y": {"a": 2}}`,
+			query:    `$[?@.a > 1]`,
+			values:   `[{"a": 2}]`,
+			paths:    `[["y"]]`,
+		},
+		{
+			note:     "descendant wildcard",
+			document: `{"o": {"j": 1, "k": 2}, "a": [5, 3, [{"j": 4}, {"k": 6}]]}`,
+			query:    `$..*`,
+			values:   `[[5, 3, [{"j": 4}, {"k": 6}]], {"j": 1, "k": 2}, 5, 3, [{"j": 4}, {"k": 6}], {"j": 4}, {"k": 6}, 4, 6, 1, 2]`,
+		},
+		{
+			note:     "descendant index",
+			document: `{"o": {"j": 1, "k": 2}, "a": [5, 3, [{"j": 4}, {"k": 6}]]}`,
+			query:    `$..[0]`,
+			values:   `[5, {"j": 4}]`,
+		},
+		{
+			note:     "descendant filter",
+			document: `{"a": {"b": [{"c": 1}, {"c": 2}]}}`,
+			query:    `$..[?@.c == 2]`,
+			values:   `[{"c": 2}]`,
+			paths:    `[["a", "b", 1]]`,
+		},
+		{
+			note:     "function length",
+			document: `["", "ab", "☺☺☺", [1, 2, 3], {"a": 1, "b": 2, "c": 3}, 3]`,
+			query:    `$[?length(@) == 3]`,
+			values:   `["☺☺☺", [1, 2, 3], {"a": 1, "b": 2, "c": 3}]`,
+		},
+		{
+			note:     "function count",
+			document: `[{"a": [1, 2]}, {"a": [1]}, {}]`,
+			query:    `$[?count(@.a[*]) >= 2]`,
+			values:   `[{"a": [1, 2]}]`,
+		},
+		{
+			note:     "function match",
+			document: `["1974-05-01", "1974-05-10", "x1974-05-01"]`,
+			query:    `$[?match(@, '1974-05-..')]`,
+			values:   `["1974-05-01", "1974-05-10"]`,
+		},
+		{
+			note:     "function search",
+			document: `["1974-05-01", "1974-05-10", "x1974-05-01"]`,
+			query:    `$[?search(@, '05-0')]`,
+			values:   `["1974-05-01", "x1974-05-01"]`,
+		},
+		{
+			note:     "function match with query pattern",
+			document: `{"p": "a.*", "a": ["abc", "bcd"]}`,
+			query:    `$.a[?match(@, $.p)]`,
+			values:   `["abc"]`,
+		},
+		{
+			note:     "function match invalid pattern",
+			document: `["a", "("]`,
+			query:    `$[?match(@, '(')]`,
+			values:   `[]`,
+		},
+		{
+			note:     "function match not",
+			document: `["a", "b"]`,
+			query:    `$[?!match(@, 'a')]`,
+			values:   `["b"]`,
+		},
+		{
+			note:     "function value",
+			document: `[{"a": {"b": 1}}, {"a": {"b": 2}}]`,
+			query:    `$[?value(@..b) == 2]`,
+			values:   `[{"a": {"b": 2}}]`,
+		},
+		{
+			note:     "non-string keys",
+			document: `{1: "a", [2]: "b", "c": "d"}`,
+			query:    `$.*`,
+			values:   `["a", "d", "b"]`,
+			paths:    `[[1], ["c"], [[2]]]`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			q, err := Parse(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doc := ast.MustParseTerm(tc.document).Value
+			matches := q.Eval(doc)
+
+			values := make([]*ast.Term, len(matches))
+			paths := make([]*ast.Term, len(matches))
+			for i, m := range matches {
+				values[i] = ast.NewTerm(m.Value)
+				paths[i] = ast.ArrayTerm(m.Path...)
+			}
+
+			if exp, act := ast.MustParseTerm(tc.values), ast.ArrayTerm(values...); !exp.Equal(act) {
+				t.Errorf("expected values %v, got %v", exp, act)
+			}
+			if tc.paths == "" {
+				return
+			}
+			if exp, act := ast.MustParseTerm(tc.paths), ast.ArrayTerm(paths...); !exp.Equal(act) {
+				t.Errorf("expected paths %v, got %v", exp, act)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{``, `query must start with "$" at offset 0`},
+		{`a.b`, `query must start with "$" at offset 0`},
+		{`$ `, `unexpected " " at offset 1`},
+		{`$.`, `expected member name or "*" at offset 2`},
+		{`$.1a`, `expected member name or "*" at offset 4`},
+		{`$[`, `expected selector at offset 2`},
+		{`$[1`, `expected "," or "]" at offset 3`},
+		{`$[01]`, `invalid integer "01" at offset 2`},
+		{`$[-0]`, `invalid integer "-0" at offset 2`},
+		{`$[9007199254740992]`, `integer 9007199254740992 out of range at offset 2`},
+		{`$['a`, `unterminated string at offset 4`},
+		{`$['\a']`, `invalid escape at offset 4`},
+		{`$["\uD800"]`, `invalid surrogate pair at offset 9`},
+		{`$[?@.a == 1 &&]`, `expected expression at offset 14`},
+		{`$[?@.* == 1]`, `query must be singular at offset 3`},
+		{`$[?@..a == 1]`, `query must be singular at offset 3`},
+		{`$[?1]`, `expected comparison operator at offset 4`},
+		{`$[?length(@)]`, `result of function length() must be compared at offset 3`},
+		{`$[?match(@, 'a') == true]`, `result of function match() cannot be compared at offset 3`},
+		{`$[?count(1) == 1]`, `argument 1 of function count() must be a query at offset 9`},
+		{`$[?length(@.*) == 1]`, `query must be singular at offset 10`},
+		{`$[?foo(@) == 1]`, `unknown function "foo" at offset 3`},
+		{`$[?match(@) == 1]`, `function match() expects 2 arguments at offset 10`},
+		{`$[?(@.a == 1]`, `expected ")" at offset 12`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			_, err := Parse(tc.query)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error %q, got %q", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,655 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// Integers must be within the interoperable range of I-JSON.
+const maxInt = 1<<53 - 1
+
+// Parse parses a JSONPath query.
+func Parse(query string) (*Query, error) {
+	p := parser{s: query}
+	if !p.consume("$") {
+		return nil, p.errorf("query must start with \"$\"")
+	}
+	segments, err := p.segments()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.rest())
+	}
+	return &Query{source: query, segments: segments}, nil
+}
+
+// Error is returned for invalid queries.
+type Error struct {
+	Offset  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Message, e.Offset)
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) errorf(f string, a ...interface{}) error {
+	return &Error{Offset: p.pos, Message: fmt.Sprintf(f, a...)}
+}
+
+func (p *parser) rest() string {
+	return p.s[p.pos:]
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) consume(prefix string) bool {
+	if strings.HasPrefix(p.rest(), prefix) {
+		p.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\n\r", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *parser) segments() ([]segment, error) {
+	var segments []segment
+	for {
+		// Segments may be separated by whitespace, which otherwise belongs to
+		// the enclosing expression.
+		start := p.pos
+		p.skipSpace()
+		if c := p.peek(); c != '.' && c != '[' {
+			p.pos = start
+			return segments, nil
+		}
+		seg, err := p.segment()
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, seg)
+	}
+}
+
+func (p *parser) segment() (segment, error) {
+	var seg segment
+	switch {
+	case p.consume(".."):
+		seg.descendant = true
+		if p.peek() == '[' {
+			break
+		}
+		s, err := p.shorthand()
+		if err != nil {
+			return seg, err
+		}
+		seg.selectors = []selector{s}
+		return seg, nil
+	case p.consume("."):
+		s, err := p.shorthand()
+		if err != nil {
+			return seg, err
+		}
+		seg.selectors = []selector{s}
+		return seg, nil
+	}
+
+	p.pos++ // "["
+	for {
+		p.skipSpace()
+		s, err := p.selector()
+		if err != nil {
+			return seg, err
+		}
+		seg.selectors = append(seg.selectors, s)
+		p.skipSpace()
+		if p.consume("]") {
+			return seg, nil
+		}
+		if !p.consume(",") {
+			return seg, p.errorf("expected \",\" or \"]\"")
+		}
+	}
+}
+
+// shorthand parses the wildcard or member name following a dot.
+func (p *parser) shorthand() (selector, error) {
+	if p.consume("*") {
+		return wildcardSelector{}, nil
+	}
+	name := p.name()
+	if name == "" || isDigit(name[0]) {
+		return nil, p.errorf("expected member name or \"*\"")
+	}
+	return nameSelector(name), nil
+}
+
+// name parses a member name, or function name, made of letters, digits,
+// "_" and non-ASCII characters.
+func (p *parser) name() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		r, size := utf8.DecodeRuneInString(p.rest())
+		if !(r == '_' || isDigit(byte(r)) && r < utf8.RuneSelf || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' ||
+			r >= utf8.RuneSelf && r != utf8.RuneError) {
+			break
+		}
+		p.pos += size
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *parser) selector() (selector, error) {
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+		s, err := p.stringLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return nameSelector(s), nil
+	case c == '*':
+		p.pos++
+		return wildcardSelector{}, nil
+	case c == '?':
+		p.pos++
+		p.skipSpace()
+		expr, err := p.logicalExpr()
+		if err != nil {
+			return nil, err
+		}
+		return filterSelector{expr: expr}, nil
+	case c == ':' || c == '-' || isDigit(c):
+		return p.indexOrSlice()
+	}
+	return nil, p.errorf("expected selector")
+}
+
+func (p *parser) indexOrSlice() (selector, error) {
+	var bounds [3]*int
+	for i := range bounds {
+		if i > 0 {
+			p.skipSpace()
+			if !p.consume(":") {
+				if i == 1 {
+					return indexSelector(*bounds[0]), nil
+				}
+				break
+			}
+			p.skipSpace()
+		}
+		if c := p.peek(); c == '-' || isDigit(c) {
+			n, err := p.integer()
+			if err != nil {
+				return nil, err
+			}
+			bounds[i] = &n
+		} else if i == 0 && c != ':' {
+			return nil, p.errorf("expected index or slice")
+		}
+	}
+
+	s := sliceSelector{start: bounds[0], end: bounds[1], step: 1}
+	if bounds[2] != nil {
+		s.step = *bounds[2]
+	}
+	return s, nil
+}
+
+func (p *parser) integer() (int, error) {
+	start := p.pos
+	p.consume("-")
+	for isDigit(p.peek()) {
+		p.pos++
+	}
+	s := p.s[start:p.pos]
+	if s == "-" || s == "-0" || len(strings.TrimPrefix(s, "-")) > 1 && strings.TrimPrefix(s, "-")[0] == '0' {
+		p.pos = start
+		return 0, p.errorf("invalid integer %q", s)
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n > maxInt || n < -maxInt {
+		p.pos = start
+		return 0, p.errorf("integer %s out of range", s)
+	}
+	return n, nil
+}
+
+func (p *parser) stringLiteral() (string, error) {
+	quote := p.s[p.pos]
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.s) {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.s[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String(), nil
+		case c == '\\':
+			r, err := p.escape(quote)
+			if err != nil {
+				return "", err
+			}
+			b.WriteRune(r)
+		case c < 0x20:
+			return "", p.errorf("invalid character in string")
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+func (p *parser) escape(quote byte) (rune, error) {
+	p.pos++ // "\"
+	c := p.peek()
+	p.pos++
+	switch c {
+	case quote, '\\', '/':
+		return rune(c), nil
+	case 'b':
+		return '\b', nil
+	case 'f':
+		return '\f', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'u':
+		r, err := p.hex4()
+		if err != nil {
+			return 0, err
+		}
+		if utf16.IsSurrogate(r) {
+			if r >= 0xDC00 || !p.consume("\\u") {
+				return 0, p.errorf("invalid surrogate pair")
+			}
+			low, err := p.hex4()
+			if err != nil {
+				return 0, err
+			}
+			if r = utf16.DecodeRune(r, low); r == utf8.RuneError {
+				return 0, p.errorf("invalid surrogate pair")
+			}
+		}
+		return r, nil
+	}
+	p.pos--
+	return 0, p.errorf("invalid escape")
+}
+
+func (p *parser) hex4() (rune, error) {
+	if p.pos+4 > len(p.s) {
+		return 0, p.errorf("invalid unicode escape")
+	}
+	n, err := strconv.ParseUint(p.s[p.pos:p.pos+4], 16, 32)
+	if err != nil {
+		return 0, p.errorf("invalid unicode escape")
+	}
+	p.pos += 4
+	return rune(n), nil
+}
+
+func (p *parser) logicalExpr() (logicalExpr, error) {
+	var or orExpr
+	for {
+		var and andExpr
+		for {
+			e, err := p.basicExpr()
+			if err != nil {
+				return nil, err
+			}
+			and = append(and, e)
+			p.skipSpace()
+			if !p.consume("&&") {
+				break
+			}
+			p.skipSpace()
+		}
+		if len(and) == 1 {
+			or = append(or, and[0])
+		} else {
+			or = append(or, and)
+		}
+		if !p.consume("||") {
+			break
+		}
+		p.skipSpace()
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *parser) basicExpr() (logicalExpr, error) {
+	if p.consume("!") {
+		p.skipSpace()
+		var e logicalExpr
+		var err error
+		if p.peek() == '(' {
+			e, err = p.parenExpr()
+		} else {
+			e, err = p.testExpr()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr: e}, nil
+	}
+	if p.peek() == '(' {
+		return p.parenExpr()
+	}
+
+	// Queries and functions are tests, unless they are compared.
+	start := p.pos
+	var left operand
+	switch c := p.peek(); {
+	case c == '@' || c == '$':
+		q, err := p.filterQuery()
+		if err != nil {
+			return nil, err
+		}
+		if !p.comparisonFollows() {
+			return existenceExpr{query: q}, nil
+		}
+		if !q.singular() {
+			p.pos = start
+			return nil, p.errorf("query must be singular")
+		}
+		left = q
+	case isFunctionName(c):
+		if !p.isKeyword() {
+			fn, err := p.functionExpr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.comparisonFollows() {
+				if functions[fn.name].result != logicalType {
+					p.pos = start
+					return nil, p.errorf("result of function %s() must be compared", fn.name)
+				}
+				return functionTestExpr{fn: fn}, nil
+			}
+			if functions[fn.name].result != valueType {
+				p.pos = start
+				return nil, p.errorf("result of function %s() cannot be compared", fn.name)
+			}
+			left = fn
+			break
+		}
+		fallthrough
+	default:
+		var err error
+		if left, err = p.operand(); err != nil {
+			return nil, err
+		}
+	}
+
+	p.skipSpace()
+	op := p.comparisonOp()
+	if op == "" {
+		return nil, p.errorf("expected comparison operator")
+	}
+	p.skipSpace()
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	return comparisonExpr{op: op, left: left, right: right}, nil
+}
+
+// comparisonFollows returns true if a comparison operator follows.
+func (p *parser) comparisonFollows() bool {
+	start := p.pos
+	p.skipSpace()
+	op := p.comparisonOp()
+	p.pos = start
+	return op != ""
+}
+
+func (p *parser) parenExpr() (logicalExpr, error) {
+	p.pos++ // "("
+	p.skipSpace()
+	e, err := p.logicalExpr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.consume(")") {
+		return nil, p.errorf("expected \")\"")
+	}
+	return e, nil
+}
+
+// testExpr parses an existence test, or a function returning a logical value.
+func (p *parser) testExpr() (logicalExpr, error) {
+	if c := p.peek(); c == '@' || c == '$' {
+		q, err := p.filterQuery()
+		if err != nil {
+			return nil, err
+		}
+		return existenceExpr{query: q}, nil
+	}
+	start := p.pos
+	fn, err := p.functionExpr()
+	if err != nil {
+		return nil, err
+	}
+	if functions[fn.name].result != logicalType {
+		p.pos = start
+		return nil, p.errorf("result of function %s() must be compared", fn.name)
+	}
+	return functionTestExpr{fn: fn}, nil
+}
+
+func (p *parser) comparisonOp() string {
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.consume(op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// operand parses a literal, a singular query, or a function returning a
+// value.
+func (p *parser) operand() (operand, error) {
+	start := p.pos
+	switch c := p.peek(); {
+	case c == '@' || c == '$':
+		q, err := p.filterQuery()
+		if err != nil {
+			return nil, err
+		}
+		if !q.singular() {
+			p.pos = start
+			return nil, p.errorf("query must be singular")
+		}
+		return q, nil
+	case c == '\'' || c == '"':
+		s, err := p.stringLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return literal{ast.String(s)}, nil
+	case c == '-' || isDigit(c):
+		return p.number()
+	}
+
+	if v, ok := p.keyword(); ok {
+		return literal{v}, nil
+	}
+
+	fn, err := p.functionExpr()
+	if err != nil {
+		return nil, err
+	}
+	if functions[fn.name].result != valueType {
+		p.pos = start
+		return nil, p.errorf("result of function %s() cannot be compared", fn.name)
+	}
+	return fn, nil
+}
+
+var keywords = map[string]ast.Value{
+	"true":  ast.Boolean(true),
+	"false": ast.Boolean(false),
+	"null":  ast.Null{},
+}
+
+// keyword parses the literals true, false and null.
+func (p *parser) keyword() (ast.Value, bool) {
+	start := p.pos
+	v, ok := keywords[p.name()]
+	if !ok {
+		p.pos = start
+	}
+	return v, ok
+}
+
+func (p *parser) isKeyword() bool {
+	start := p.pos
+	_, ok := p.keyword()
+	p.pos = start
+	return ok
+}
+
+func (p *parser) number() (operand, error) {
+	start := p.pos
+	p.consume("-")
+	digits := p.pos
+	for isDigit(p.peek()) {
+		p.pos++
+	}
+	if p.pos == digits || p.pos-digits > 1 && p.s[digits] == '0' {
+		p.pos = start
+		return nil, p.errorf("invalid number")
+	}
+	if p.consume(".") {
+		frac := p.pos
+		for isDigit(p.peek()) {
+			p.pos++
+		}
+		if p.pos == frac {
+			return nil, p.errorf("invalid number")
+		}
+	}
+	if p.consume("e") || p.consume("E") {
+		if !p.consume("-") {
+			p.consume("+")
+		}
+		exp := p.pos
+		for isDigit(p.peek()) {
+			p.pos++
+		}
+		if p.pos == exp {
+			return nil, p.errorf("invalid number")
+		}
+	}
+	return literal{ast.Number(p.s[start:p.pos])}, nil
+}
+
+func (p *parser) filterQuery() (*filterQuery, error) {
+	q := filterQuery{relative: p.peek() == '@'}
+	p.pos++
+	segments, err := p.segments()
+	if err != nil {
+		return nil, err
+	}
+	q.segments = segments
+	return &q, nil
+}
+
+func (p *parser) functionExpr() (*functionExpr, error) {
+	start := p.pos
+	name := p.name()
+	f, ok := functions[name]
+	if !ok || !p.consume("(") {
+		p.pos = start
+		if name == "" {
+			return nil, p.errorf("expected expression")
+		}
+		return nil, p.errorf("unknown function %q", name)
+	}
+
+	fn := functionExpr{name: name}
+	for i, param := range f.params {
+		p.skipSpace()
+		if i > 0 && !p.consume(",") {
+			return nil, p.errorf("function %s() expects %d arguments", name, len(f.params))
+		}
+		p.skipSpace()
+
+		var arg functionArg
+		if param == nodesType {
+			if c := p.peek(); c != '@' && c != '$' {
+				return nil, p.errorf("argument %d of function %s() must be a query", i+1, name)
+			}
+			q, err := p.filterQuery()
+			if err != nil {
+				return nil, err
+			}
+			arg.query = q
+		} else {
+			v, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			arg.value = v
+		}
+		fn.args = append(fn.args, arg)
+	}
+	p.skipSpace()
+	if !p.consume(")") {
+		return nil, p.errorf("function %s() expects %d arguments", name, len(f.params))
+	}
+
+	// Regular expressions are compiled once if they are literals.
+	if name == "match" || name == "search" {
+		if l, ok := fn.args[1].value.(literal); ok {
+			if s, ok := l.v.(ast.String); ok {
+				// Invalid regular expressions match nothing.
+				fn.re, _ = compileRegexp(name, string(s))
+			}
+		}
+	}
+	return &fn, nil
+}
+
+func isFunctionName(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -5,7 +5,8 @@ type EllipticCurveAlgorithm string
 
 // Supported values for EllipticCurveAlgorithm
 const (
-	P256 EllipticCurveAlgorithm = "P-256"
-	P384 EllipticCurveAlgorithm = "P-384"
-	P521 EllipticCurveAlgorithm = "P-521"
+	Ed25519 EllipticCurveAlgorithm = "Ed25519"
+	P256    EllipticCurveAlgorithm = "P-256"
+	P384    EllipticCurveAlgorithm = "P-384"
+	P521    EllipticCurveAlgorithm = "P-521"
 )
@@ -9,13 +9,14 @@ import (
 // KeyType represents the key type ("kty") that are supported
 type KeyType string
 
-var keyTypeAlg = map[string]struct{}{"EC": {}, "oct": {}, "RSA": {}}
+var keyTypeAlg = map[string]struct{}{"EC": {}, "oct": {}, "OKP": {}, "RSA": {}}
 
 // Supported values for KeyType
 const (
 	EC             KeyType = "EC"  // Elliptic Curve
 	InvalidKeyType KeyType = ""    // Invalid KeyType
 	OctetSeq       KeyType = "oct" // Octet sequence (used to represent symmetric keys)
+	OKP            KeyType = "OKP" // Octet key pair (used to represent Ed25519 keys)
 	RSA            KeyType = "RSA" // RSA
 )
 
@@ -9,10 +9,11 @@ import (
 // SignatureAlgorithm represents the various signature algorithms as described in https://tools.ietf.org/html/rfc7518#section-3.1
 type SignatureAlgorithm string
 
-var signatureAlg = map[string]struct{}{"ES256": {}, "ES384": {}, "ES512": {}, "HS256": {}, "HS384": {}, "HS512": {}, "PS256": {}, "PS384": {}, "PS512": {}, "RS256": {}, "RS384": {}, "RS512": {}, "none": {}}
+var signatureAlg = map[string]struct{}{"EdDSA": {}, "ES256": {}, "ES384": {}, "ES512": {}, "HS256": {}, "HS384": {}, "HS512": {}, "PS256": {}, "PS384": {}, "PS512": {}, "RS256": {}, "RS384": {}, "RS512": {}, "none": {}}
 
 // Supported values for SignatureAlgorithm
 const (
+	EdDSA       SignatureAlgorithm = "EdDSA" // EdDSA using Ed25519
 	ES256       SignatureAlgorithm = "ES256" // ECDSA using P-256 and SHA-256
 	ES384       SignatureAlgorithm = "ES384" // ECDSA using P-384 and SHA-384
 	ES512       SignatureAlgorithm = "ES512" // ECDSA using P-521 and SHA-512
@@ -0,0 +1,173 @@
+package jwe
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
+)
+
+// contentCipher encrypts and decrypts the plaintext of a JWE with the
+// content encryption key (CEK).
+type contentCipher interface {
+	keySize() int
+	ivSize() int
+	encrypt(cek, iv, plaintext, aad []byte) (ciphertext, tag []byte, err error)
+	decrypt(cek, iv, ciphertext, tag, aad []byte) ([]byte, error)
+}
+
+// errDecryption is returned when a ciphertext cannot be decrypted. It does not
+// tell why, so that it cannot be used as an oracle.
+var errDecryption = errors.New("failed to decrypt content")
+
+func newContentCipher(enc jwa.ContentEncryptionAlgorithm) (contentCipher, error) {
+	switch enc {
+	case jwa.A128GCM:
+		return gcmCipher{size: 16}, nil
+	case jwa.A192GCM:
+		return gcmCipher{size: 24}, nil
+	case jwa.A256GCM:
+		return gcmCipher{size: 32}, nil
+	case jwa.A128CBCHS256:
+		return cbcHMACCipher{size: 16, hash: sha256.New}, nil
+	case jwa.A192CBCHS384:
+		return cbcHMACCipher{size: 24, hash: sha512.New384}, nil
+	case jwa.A256CBCHS512:
+		return cbcHMACCipher{size: 32, hash: sha512.New}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encryption algorithm: %s", enc)
+	}
+}
+
+// gcmCipher implements AES GCM, see https://tools.ietf.org/html/rfc7518#section-5.3
+type gcmCipher struct {
+	size int
+}
+
+func (c gcmCipher) keySize() int {
+	return c.size
+}
+
+func (c gcmCipher) ivSize() int {
+	return 12
+}
+
+func (c gcmCipher) aead(cek []byte) (cipher.AEAD, error) {
+	if len(cek) != c.size {
+		return nil, fmt.Errorf("content encryption key must be %d bytes long", c.size)
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c gcmCipher) encrypt(cek, iv, plaintext, aad []byte) ([]byte, []byte, error) {
+	aead, err := c.aead(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := aead.Seal(nil, iv, plaintext, aad)
+	n := len(out) - aead.Overhead()
+	return out[:n], out[n:], nil
+}
+
+func (c gcmCipher) decrypt(cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	aead, err := c.aead(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aead.NonceSize() || len(tag) != aead.Overhead() {
+		return nil, errDecryption
+	}
+	plaintext, err := aead.Open(nil, iv, append(append([]byte{}, ciphertext...), tag...), aad)
+	if err != nil {
+		return nil, errDecryption
+	}
+	return plaintext, nil
+}
+
+// cbcHMACCipher implements AES CBC with HMAC SHA-2, see https://tools.ietf.org/html/rfc7518#section-5.2
+type cbcHMACCipher struct {
+	size int
+	hash func() hash.Hash
+}
+
+// keySize returns the size of the CEK, which holds the MAC key followed by
+// the encryption key.
+func (c cbcHMACCipher) keySize() int {
+	return 2 * c.size
+}
+
+func (c cbcHMACCipher) ivSize() int {
+	return aes.BlockSize
+}
+
+func (c cbcHMACCipher) tag(macKey, iv, ciphertext, aad []byte) []byte {
+	al := make([]byte, 8)
+	binary.BigEndian.PutUint64(al, uint64(len(aad))*8)
+	mac := hmac.New(c.hash, macKey)
+	mac.Write(aad)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	mac.Write(al)
+	return mac.Sum(nil)[:c.size]
+}
+
+func (c cbcHMACCipher) encrypt(cek, iv, plaintext, aad []byte) ([]byte, []byte, error) {
+	if len(cek) != c.keySize() {
+		return nil, nil, fmt.Errorf("content encryption key must be %d bytes long", c.keySize())
+	}
+	block, err := aes.NewCipher(cek[c.size:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	ciphertext := make([]byte, len(plaintext)+padding)
+	copy(ciphertext, plaintext)
+	copy(ciphertext[len(plaintext):], bytes.Repeat([]byte{byte(padding)}, padding))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	return ciphertext, c.tag(cek[:c.size], iv, ciphertext, aad), nil
+}
+
+func (c cbcHMACCipher) decrypt(cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	if len(cek) != c.keySize() {
+		return nil, fmt.Errorf("content encryption key must be %d bytes long", c.keySize())
+	}
+	if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errDecryption
+	}
+	if subtle.ConstantTimeCompare(tag, c.tag(cek[:c.size], iv, ciphertext, aad)) != 1 {
+		return nil, errDecryption
+	}
+	block, err := aes.NewCipher(cek[c.size:])
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errDecryption
+	}
+	for _, b := range plaintext[len(plaintext)-padding:] {
+		if int(b) != padding {
+			return nil, errDecryption
+		}
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
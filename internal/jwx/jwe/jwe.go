@@ -0,0 +1,220 @@
+// Package jwe implements the encryption of JSON based data structures as
+// described in https://tools.ietf.org/html/rfc7516
+//
+// Only the compact serialization is supported. To encrypt, use `jwe.Encrypt`
+// with the JSON encoded protected header and the key of the key management
+// algorithm named in the header. To decrypt, use `jwe.Parse` and call
+// `Decrypt` on the returned message with the key.
+package jwe
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/open-policy-agent/opa/internal/jwx/buffer"
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
+	"github.com/open-policy-agent/opa/internal/jwx/jwk"
+)
+
+// compressionDeflate is the "zip" header value for DEFLATE compressed
+// plaintexts.
+const compressionDeflate = "DEF"
+
+// maxPlaintextSize limits the size of decompressed plaintexts.
+const maxPlaintextSize = 1 << 24
+
+// StandardHeaders stores the JWE header parameters used for encryption and
+// decryption, see https://tools.ietf.org/html/rfc7516#section-4.1
+type StandardHeaders struct {
+	Algorithm           jwa.KeyEncryptionAlgorithm     `json:"alg"`
+	Encryption          jwa.ContentEncryptionAlgorithm `json:"enc"`
+	Compression         string                         `json:"zip,omitempty"`
+	KeyID               string                         `json:"kid,omitempty"`
+	Type                string                         `json:"typ,omitempty"`
+	ContentType         string                         `json:"cty,omitempty"`
+	Critical            []string                       `json:"crit,omitempty"`
+	EphemeralPublicKey  *jwk.RawKeyJSON                `json:"epk,omitempty"`
+	AgreementPartyUInfo buffer.Buffer                  `json:"apu,omitempty"`
+	AgreementPartyVInfo buffer.Buffer                  `json:"apv,omitempty"`
+}
+
+// validate checks that the algorithms are supported, and that there are no
+// critical parameters, since no extensions are supported.
+func (h *StandardHeaders) validate() error {
+	if err := h.Algorithm.Accept(string(h.Algorithm)); err != nil {
+		return fmt.Errorf("invalid alg header: %w", err)
+	}
+	if err := h.Encryption.Accept(string(h.Encryption)); err != nil {
+		return fmt.Errorf("invalid enc header: %w", err)
+	}
+	if h.Compression != "" && h.Compression != compressionDeflate {
+		return fmt.Errorf("unsupported zip header: %s", h.Compression)
+	}
+	if len(h.Critical) > 0 {
+		return fmt.Errorf("unsupported critical header parameters: %v", h.Critical)
+	}
+	return nil
+}
+
+// Message is a JWE in compact serialization.
+type Message struct {
+	Headers         StandardHeaders
+	protectedHeader string // encoded, used as additional authenticated data
+	encryptedKey    []byte
+	iv              []byte
+	ciphertext      []byte
+	tag             []byte
+}
+
+// Parse parses a JWE in compact serialization. The header is validated, but
+// the content is not decrypted.
+func Parse(compact string) (*Message, error) {
+	parts := strings.Split(compact, ".")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("encoded JWE must have 5 sections, found %d", len(parts))
+	}
+
+	decoded := make([][]byte, len(parts))
+	for i, part := range parts {
+		b, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode JWE section %d: %w", i+1, err)
+		}
+		decoded[i] = b
+	}
+
+	msg := Message{
+		protectedHeader: parts[0],
+		encryptedKey:    decoded[1],
+		iv:              decoded[2],
+		ciphertext:      decoded[3],
+		tag:             decoded[4],
+	}
+	if err := json.Unmarshal(decoded[0], &msg.Headers); err != nil {
+		return nil, fmt.Errorf("failed to parse JWE header: %w", err)
+	}
+	if err := msg.Headers.validate(); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// ProtectedHeader returns the JSON encoded protected header.
+func (m *Message) ProtectedHeader() []byte {
+	b, _ := base64.RawURLEncoding.DecodeString(m.protectedHeader)
+	return b
+}
+
+// Decrypt decrypts the content of the message with key, which must be the
+// key of the key management algorithm of the message: a []byte for "dir" and
+// AES Key Wrap, an *rsa.PrivateKey for RSA-OAEP, and an *ecdsa.PrivateKey
+// for ECDH-ES.
+func (m *Message) Decrypt(key interface{}) ([]byte, error) {
+	cipher, err := newContentCipher(m.Headers.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	cek, err := decryptKey(&m.Headers, key, m.encryptedKey, cipher.keySize())
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := cipher.decrypt(cek, m.iv, m.ciphertext, m.tag, []byte(m.protectedHeader))
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Headers.Compression == compressionDeflate {
+		r := flate.NewReader(bytes.NewReader(plaintext))
+		defer r.Close()
+		plaintext, err = io.ReadAll(io.LimitReader(r, maxPlaintextSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress content: %w", err)
+		}
+		if len(plaintext) > maxPlaintextSize {
+			return nil, errors.New("decompressed content too large")
+		}
+	}
+
+	return plaintext, nil
+}
+
+// Encrypt encrypts payload with key, and serializes it in compact
+// serialization format. The algorithms are taken from the JSON encoded
+// protected header in hdrBuf. The key must be the key of the key management
+// algorithm: a []byte for "dir" and AES Key Wrap, an *rsa.PublicKey for
+// RSA-OAEP, and an *ecdsa.PublicKey for ECDH-ES. Keys, initialization vectors
+// and ephemeral keys are generated using rnd.
+func Encrypt(payload []byte, hdrBuf []byte, key interface{}, rnd io.Reader) ([]byte, error) {
+	var headers StandardHeaders
+	if err := json.Unmarshal(hdrBuf, &headers); err != nil {
+		return nil, fmt.Errorf("failed to parse JWE header: %w", err)
+	}
+	if err := headers.validate(); err != nil {
+		return nil, err
+	}
+
+	cipher, err := newContentCipher(headers.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	cek, encryptedKey, err := encryptKey(&headers, key, cipher.keySize(), rnd)
+	if err != nil {
+		return nil, err
+	}
+
+	// ECDH-ES adds the ephemeral public key to the header.
+	if headers.EphemeralPublicKey != nil {
+		var hdr map[string]interface{}
+		if err := json.Unmarshal(hdrBuf, &hdr); err != nil {
+			return nil, fmt.Errorf("failed to parse JWE header: %w", err)
+		}
+		hdr["epk"] = headers.EphemeralPublicKey
+		hdrBuf, err = json.Marshal(hdr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal JWE header: %w", err)
+		}
+	}
+
+	if headers.Compression == compressionDeflate {
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(payload); err != nil {
+			return nil, fmt.Errorf("failed to compress content: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress content: %w", err)
+		}
+		payload = buf.Bytes()
+	}
+
+	iv := make([]byte, cipher.ivSize())
+	if _, err := io.ReadFull(rnd, iv); err != nil {
+		return nil, fmt.Errorf("failed to generate initialization vector: %w", err)
+	}
+
+	protectedHeader := base64.RawURLEncoding.EncodeToString(hdrBuf)
+	ciphertext, tag, err := cipher.encrypt(cek, iv, payload, []byte(protectedHeader))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt content: %w", err)
+	}
+
+	return []byte(strings.Join([]string{
+		protectedHeader,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, ".")), nil
+}
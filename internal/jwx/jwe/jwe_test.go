@@ -0,0 +1,201 @@
+package jwe_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/internal/jwx/jwe"
+	"github.com/open-policy-agent/opa/internal/jwx/jwk"
+)
+
+func TestDecryptRFC7516(t *testing.T) {
+	// https://tools.ietf.org/html/rfc7516#appendix-A.3
+	const compact = `eyJhbGciOiJBMTI4S1ciLCJlbmMiOiJBMTI4Q0JDLUhTMjU2In0.6KB707dM9YTIgHtLvtgWQ8mKwboJW3of9locizkDTHzBC2IlrT1oOQ.AxY8DCtDaGlsbGljb3RoZQ.KDlTtXchhZTGufMYmOYGS4HffxPSUrfmqCHXaI9wOGY.U0m_YmjN04DJvceFICbCVQ`
+
+	keys, err := jwk.ParseString(`{"kty":"oct","k":"GawgguFyGrWKav7AX4VKUg"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := keys.Keys[0].Materialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := jwe.Parse(compact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := msg.Decrypt(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "Live long and prosper."; string(plaintext) != exp {
+		t.Fatalf("expected %q but got %q", exp, plaintext)
+	}
+
+	// Any change to the protected header, the key or the content is detected.
+	parts := strings.Split(compact, ".")
+	for i := range parts {
+		b, _ := base64.RawURLEncoding.DecodeString(parts[i])
+		b[len(b)-1] ^= 1
+		tampered := append(append(append([]string{}, parts[:i]...), base64.RawURLEncoding.EncodeToString(b)), parts[i+1:]...)
+		msg, err := jwe.Parse(strings.Join(tampered, "."))
+		if err != nil {
+			continue
+		}
+		if _, err := msg.Decrypt(key); err == nil {
+			t.Fatalf("expected error decrypting JWE with section %d modified", i+1)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherECKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	symmetric := func(n int) []byte {
+		b := make([]byte, n)
+		_, _ = rand.Read(b)
+		return b
+	}
+
+	tests := []struct {
+		alg        string
+		encryptKey interface{}
+		decryptKey interface{}
+		wrongKey   interface{}
+	}{
+		{"RSA-OAEP", &rsaKey.PublicKey, rsaKey, symmetric(16)},
+		{"RSA-OAEP-256", &rsaKey.PublicKey, rsaKey, ecKey},
+		{"A128KW", symmetric(16), nil, symmetric(16)},
+		{"A192KW", symmetric(24), nil, symmetric(24)},
+		{"A256KW", symmetric(32), nil, symmetric(32)},
+		{"ECDH-ES", &ecKey.PublicKey, ecKey, otherECKey},
+		{"ECDH-ES+A128KW", &ecKey.PublicKey, ecKey, otherECKey},
+		{"ECDH-ES+A256KW", &ecKey.PublicKey, ecKey, otherECKey},
+	}
+
+	encs := []string{"A128GCM", "A192GCM", "A256GCM", "A128CBC-HS256", "A192CBC-HS384", "A256CBC-HS512"}
+
+	for _, tc := range tests {
+		for _, enc := range encs {
+			for _, zip := range []string{"", `,"zip":"DEF"`} {
+				t.Run(fmt.Sprintf("%s/%s%s", tc.alg, enc, zip), func(t *testing.T) {
+					hdr := fmt.Sprintf(`{"alg":%q,"enc":%q,"cty":"JWT"%s}`, tc.alg, enc, zip)
+					payload := []byte(strings.Repeat("secret payload ", 10))
+
+					compact, err := jwe.Encrypt(payload, []byte(hdr), tc.encryptKey, rand.Reader)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					msg, err := jwe.Parse(string(compact))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if msg.Headers.ContentType != "JWT" {
+						t.Fatalf("expected cty header to be kept but got %v", msg.Headers)
+					}
+
+					decryptKey := tc.decryptKey
+					if decryptKey == nil {
+						decryptKey = tc.encryptKey
+					}
+					plaintext, err := msg.Decrypt(decryptKey)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if string(plaintext) != string(payload) {
+						t.Fatalf("expected %q but got %q", payload, plaintext)
+					}
+
+					if _, err := msg.Decrypt(tc.wrongKey); err == nil {
+						t.Fatal("expected error decrypting with wrong key")
+					}
+				})
+			}
+		}
+	}
+
+	t.Run("dir", func(t *testing.T) {
+		key := symmetric(32)
+		compact, err := jwe.Encrypt([]byte("payload"), []byte(`{"alg":"dir","enc":"A128CBC-HS256"}`), key, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := jwe.Parse(string(compact))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, err := msg.Decrypt(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plaintext) != "payload" {
+			t.Fatalf("expected payload but got %q", plaintext)
+		}
+
+		if _, err := jwe.Encrypt([]byte("payload"), []byte(`{"alg":"dir","enc":"A256GCM"}`), key[:16], rand.Reader); err == nil {
+			t.Fatal("expected error for key of wrong size")
+		}
+	})
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		note    string
+		compact string
+		exp     string
+	}{
+		{
+			note:    "sections",
+			compact: "a.b.c",
+			exp:     "encoded JWE must have 5 sections, found 3",
+		},
+		{
+			note:    "encoding",
+			compact: "%.b.c.d.e",
+			exp:     "failed to decode JWE section 1",
+		},
+		{
+			note:    "algorithm",
+			compact: base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RSA1_5","enc":"A128GCM"}`)) + "....",
+			exp:     "invalid alg header: unknown key encryption algorithm",
+		},
+		{
+			note:    "content encryption algorithm",
+			compact: base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"dir","enc":"A128CTR"}`)) + "....",
+			exp:     "invalid enc header: unknown content encryption algorithm",
+		},
+		{
+			note:    "critical",
+			compact: base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"dir","enc":"A128GCM","crit":["exp"]}`)) + "....",
+			exp:     "unsupported critical header parameters: [exp]",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			_, err := jwe.Parse(tc.compact)
+			if err == nil || !strings.Contains(err.Error(), tc.exp) {
+				t.Fatalf("expected error %q but got %v", tc.exp, err)
+			}
+		})
+	}
+}
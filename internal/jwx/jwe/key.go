@@ -0,0 +1,279 @@
+package jwe
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/open-policy-agent/opa/internal/jwx/buffer"
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
+	"github.com/open-policy-agent/opa/internal/jwx/jwk"
+)
+
+// encryptKey determines the content encryption key (CEK) of size cekSize for
+// the key management algorithm in headers, and returns it with its
+// encrypted form. For ECDH-ES, the ephemeral public key is set in headers.
+func encryptKey(headers *StandardHeaders, key interface{}, cekSize int, rnd io.Reader) (cek, encryptedKey []byte, err error) {
+	alg := headers.Algorithm
+
+	if alg == jwa.Direct {
+		k, ok := key.([]byte)
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid key type %T. []byte is required", key)
+		}
+		if len(k) != cekSize {
+			return nil, nil, fmt.Errorf("key must be %d bytes long for %s", cekSize, headers.Encryption)
+		}
+		return k, nil, nil
+	}
+
+	if alg == jwa.ECDHES {
+		kek, epk, err := ecdhEncrypt(headers, key, cekSize, string(headers.Encryption), rnd)
+		if err != nil {
+			return nil, nil, err
+		}
+		headers.EphemeralPublicKey = epk
+		return kek, nil, nil
+	}
+
+	cek = make([]byte, cekSize)
+	if _, err := io.ReadFull(rnd, cek); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate content encryption key: %w", err)
+	}
+
+	switch alg {
+	case jwa.RSAOAEP, jwa.RSAOAEP256:
+		var pub *rsa.PublicKey
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			pub = k
+		case *rsa.PrivateKey:
+			pub = &k.PublicKey
+		default:
+			return nil, nil, fmt.Errorf("invalid key type %T. *rsa.PublicKey is required", key)
+		}
+		encryptedKey, err = rsa.EncryptOAEP(oaepHash(alg), rnd, pub, cek, nil)
+	case jwa.A128KW, jwa.A192KW, jwa.A256KW:
+		var kek []byte
+		kek, err = keyWrapKey(alg, key)
+		if err == nil {
+			encryptedKey, err = keyWrap(kek, cek)
+		}
+	case jwa.ECDHESA128KW, jwa.ECDHESA192KW, jwa.ECDHESA256KW:
+		var kek []byte
+		var epk *jwk.RawKeyJSON
+		kek, epk, err = ecdhEncrypt(headers, key, keyWrapSize(alg), string(alg), rnd)
+		if err == nil {
+			headers.EphemeralPublicKey = epk
+			encryptedKey, err = keyWrap(kek, cek)
+		}
+	default:
+		err = fmt.Errorf("unsupported key encryption algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return cek, encryptedKey, nil
+}
+
+// decryptKey returns the content encryption key (CEK) of size cekSize
+// determined by the key management algorithm in headers.
+func decryptKey(headers *StandardHeaders, key interface{}, encryptedKey []byte, cekSize int) ([]byte, error) {
+	var cek []byte
+	var err error
+
+	switch alg := headers.Algorithm; alg {
+	case jwa.Direct:
+		k, ok := key.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("invalid key type %T. []byte is required", key)
+		}
+		if len(encryptedKey) != 0 {
+			return nil, errors.New("encrypted key must be empty for direct encryption")
+		}
+		cek = k
+	case jwa.RSAOAEP, jwa.RSAOAEP256:
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("invalid key type %T. *rsa.PrivateKey is required", key)
+		}
+		cek, err = rsa.DecryptOAEP(oaepHash(alg), nil, priv, encryptedKey, nil)
+		if err != nil {
+			return nil, errDecryption
+		}
+	case jwa.A128KW, jwa.A192KW, jwa.A256KW:
+		var kek []byte
+		kek, err = keyWrapKey(alg, key)
+		if err == nil {
+			cek, err = keyUnwrap(kek, encryptedKey)
+		}
+	case jwa.ECDHES:
+		if len(encryptedKey) != 0 {
+			return nil, errors.New("encrypted key must be empty for ECDH-ES")
+		}
+		cek, err = ecdhDecrypt(headers, key, cekSize, string(headers.Encryption))
+	case jwa.ECDHESA128KW, jwa.ECDHESA192KW, jwa.ECDHESA256KW:
+		var kek []byte
+		kek, err = ecdhDecrypt(headers, key, keyWrapSize(alg), string(alg))
+		if err == nil {
+			cek, err = keyUnwrap(kek, encryptedKey)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key encryption algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cek) != cekSize {
+		return nil, errDecryption
+	}
+	return cek, nil
+}
+
+func oaepHash(alg jwa.KeyEncryptionAlgorithm) hash.Hash {
+	if alg == jwa.RSAOAEP256 {
+		return sha256.New()
+	}
+	// RSA-OAEP is defined with SHA-1.
+	return sha1.New()
+}
+
+func keyWrapSize(alg jwa.KeyEncryptionAlgorithm) int {
+	switch alg {
+	case jwa.A128KW, jwa.ECDHESA128KW:
+		return 16
+	case jwa.A192KW, jwa.ECDHESA192KW:
+		return 24
+	default:
+		return 32
+	}
+}
+
+func keyWrapKey(alg jwa.KeyEncryptionAlgorithm, key interface{}) ([]byte, error) {
+	kek, ok := key.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("invalid key type %T. []byte is required", key)
+	}
+	if len(kek) != keyWrapSize(alg) {
+		return nil, fmt.Errorf("key must be %d bytes long for %s", keyWrapSize(alg), alg)
+	}
+	return kek, nil
+}
+
+// ecdhEncrypt generates an ephemeral key pair on the curve of key, and
+// derives a key of size keySize from the agreed secret. The ephemeral public
+// key is returned as a JWK.
+func ecdhEncrypt(headers *StandardHeaders, key interface{}, keySize int, algID string, rnd io.Reader) ([]byte, *jwk.RawKeyJSON, error) {
+	var pub *ecdsa.PublicKey
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		pub = k
+	case *ecdsa.PrivateKey:
+		pub = &k.PublicKey
+	default:
+		return nil, nil, fmt.Errorf("invalid key type %T. *ecdsa.PublicKey is required", key)
+	}
+
+	eph, err := ecdsa.GenerateKey(pub.Curve, rnd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+
+	crv, err := curveName(pub.Curve)
+	if err != nil {
+		return nil, nil, err
+	}
+	size := curveSize(pub.Curve)
+	epk := &jwk.RawKeyJSON{}
+	epk.KeyType = jwa.EC
+	epk.Crv = crv
+	epk.X = buffer.Buffer(padLeft(eph.X.Bytes(), size))
+	epk.Y = buffer.Buffer(padLeft(eph.Y.Bytes(), size))
+
+	z, _ := pub.Curve.ScalarMult(pub.X, pub.Y, eph.D.Bytes())
+	return concatKDF(padLeft(z.Bytes(), size), algID, headers.AgreementPartyUInfo, headers.AgreementPartyVInfo, keySize), epk, nil
+}
+
+// ecdhDecrypt derives a key of size keySize from the secret agreed with the
+// ephemeral public key in headers.
+func ecdhDecrypt(headers *StandardHeaders, key interface{}, keySize int, algID string) ([]byte, error) {
+	priv, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid key type %T. *ecdsa.PrivateKey is required", key)
+	}
+	if headers.EphemeralPublicKey == nil {
+		return nil, errors.New("missing ephemeral public key")
+	}
+
+	epk, err := headers.EphemeralPublicKey.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral public key: %w", err)
+	}
+	k, err := epk.Materialize()
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral public key: %w", err)
+	}
+	pub, ok := k.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != priv.Curve || !priv.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("invalid ephemeral public key")
+	}
+
+	z, _ := priv.Curve.ScalarMult(pub.X, pub.Y, priv.D.Bytes())
+	return concatKDF(padLeft(z.Bytes(), curveSize(priv.Curve)), algID, headers.AgreementPartyUInfo, headers.AgreementPartyVInfo, keySize), nil
+}
+
+// concatKDF derives a key of size keySize from the shared secret z as
+// described in https://tools.ietf.org/html/rfc7518#section-4.6.2
+func concatKDF(z []byte, algID string, apu, apv []byte, keySize int) []byte {
+	var otherInfo bytes.Buffer
+	for _, field := range [][]byte{[]byte(algID), apu, apv} {
+		_ = binary.Write(&otherInfo, binary.BigEndian, uint32(len(field)))
+		otherInfo.Write(field)
+	}
+	_ = binary.Write(&otherInfo, binary.BigEndian, uint32(keySize*8))
+
+	var out []byte
+	for counter := uint32(1); len(out) < keySize; counter++ {
+		h := sha256.New()
+		_ = binary.Write(h, binary.BigEndian, counter)
+		h.Write(z)
+		h.Write(otherInfo.Bytes())
+		out = h.Sum(out)
+	}
+	return out[:keySize]
+}
+
+func curveName(curve elliptic.Curve) (jwa.EllipticCurveAlgorithm, error) {
+	switch curve {
+	case elliptic.P256():
+		return jwa.P256, nil
+	case elliptic.P384():
+		return jwa.P384, nil
+	case elliptic.P521():
+		return jwa.P521, nil
+	default:
+		return "", fmt.Errorf("unsupported curve %s", curve.Params().Name)
+	}
+}
+
+func curveSize(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
+
+func padLeft(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
@@ -0,0 +1,75 @@
+package jwe
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// keyWrapIV is the default initial value of the AES Key Wrap algorithm, see
+// https://tools.ietf.org/html/rfc3394#section-2.2.3.1
+var keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// keyWrap wraps cek with kek as described in https://tools.ietf.org/html/rfc3394#section-2.2.1
+func keyWrap(kek, cek []byte) ([]byte, error) {
+	if len(cek)%8 != 0 || len(cek) < 16 {
+		return nil, fmt.Errorf("key to wrap must be a multiple of 8 bytes and at least 16 bytes long")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key wrap cipher: %w", err)
+	}
+
+	n := len(cek) / 8
+	r := make([]byte, 8+len(cek))
+	copy(r, keyWrapIV)
+	copy(r[8:], cek)
+
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b, r[:8])
+			copy(b[8:], r[i*8:(i+1)*8])
+			block.Encrypt(b, b)
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(r[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(r[i*8:(i+1)*8], b[8:])
+		}
+	}
+
+	return r, nil
+}
+
+// keyUnwrap unwraps a key wrapped with kek as described in https://tools.ietf.org/html/rfc3394#section-2.2.2
+func keyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, fmt.Errorf("wrapped key must be a multiple of 8 bytes and at least 24 bytes long")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key wrap cipher: %w", err)
+	}
+
+	n := len(wrapped)/8 - 1
+	r := make([]byte, len(wrapped))
+	copy(r, wrapped)
+
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(r[:8])^t)
+			copy(b[8:], r[i*8:(i+1)*8])
+			block.Decrypt(b, b)
+			copy(r[:8], b[:8])
+			copy(r[i*8:(i+1)*8], b[8:])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(r[:8], keyWrapIV) != 1 {
+		return nil, errors.New("failed to unwrap key")
+	}
+	return r[8:], nil
+}
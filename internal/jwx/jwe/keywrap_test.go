@@ -0,0 +1,62 @@
+package jwe
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestKeyWrap(t *testing.T) {
+	// https://tools.ietf.org/html/rfc3394#section-4
+	tests := []struct {
+		kek, key, wrapped string
+	}{
+		{
+			kek:     "000102030405060708090A0B0C0D0E0F",
+			key:     "00112233445566778899AABBCCDDEEFF",
+			wrapped: "1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5",
+		},
+		{
+			kek:     "000102030405060708090A0B0C0D0E0F1011121314151617",
+			key:     "00112233445566778899AABBCCDDEEFF0001020304050607",
+			wrapped: "031D33264E15D33268F24EC260743EDCE1C6C7DDEE725A936BA814915C6762D2",
+		},
+	}
+
+	for _, tc := range tests {
+		kek, _ := hex.DecodeString(tc.kek)
+		key, _ := hex.DecodeString(tc.key)
+		exp, _ := hex.DecodeString(tc.wrapped)
+
+		wrapped, err := keyWrap(kek, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wrapped, exp) {
+			t.Fatalf("expected %X but got %X", exp, wrapped)
+		}
+
+		unwrapped, err := keyUnwrap(kek, wrapped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(unwrapped, key) {
+			t.Fatalf("expected %X but got %X", key, unwrapped)
+		}
+
+		wrapped[len(wrapped)-1] ^= 1
+		if _, err := keyUnwrap(kek, wrapped); err == nil {
+			t.Fatal("expected error unwrapping modified key")
+		}
+	}
+}
+
+func TestConcatKDF(t *testing.T) {
+	// https://tools.ietf.org/html/rfc7518#appendix-C
+	z := []byte{158, 86, 217, 29, 129, 113, 53, 211, 114, 131, 66, 131, 191, 132, 38, 156, 251, 49, 110, 163, 218, 128, 106, 72, 246, 218, 167, 121, 140, 254, 144, 196}
+	exp := []byte{86, 170, 141, 234, 248, 35, 109, 32, 92, 34, 40, 205, 113, 167, 16, 26}
+
+	if key := concatKDF(z, "A128GCM", []byte("Alice"), []byte("Bob"), 16); !bytes.Equal(key, exp) {
+		t.Fatalf("expected %v but got %v", exp, key)
+	}
+}
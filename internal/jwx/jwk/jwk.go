@@ -3,6 +3,7 @@ package jwk
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"encoding/json"
 	"errors"
@@ -29,6 +30,8 @@ func GetPublicKey(key interface{}) (interface{}, error) {
 		return v.Public(), nil
 	case *ecdsa.PrivateKey:
 		return v.Public(), nil
+	case ed25519.PrivateKey:
+		return v.Public(), nil
 	case []byte:
 		return v, nil
 	default:
@@ -44,6 +47,8 @@ func GetKeyTypeFromKey(key interface{}) jwa.KeyType {
 		return jwa.RSA
 	case *ecdsa.PrivateKey, *ecdsa.PublicKey:
 		return jwa.EC
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return jwa.OKP
 	case []byte:
 		return jwa.OctetSeq
 	default:
@@ -66,6 +71,10 @@ func New(key interface{}) (Key, error) {
 		return newECDSAPrivateKey(v)
 	case *ecdsa.PublicKey:
 		return newECDSAPublicKey(v)
+	case ed25519.PrivateKey:
+		return newOKPPrivateKey(v)
+	case ed25519.PublicKey:
+		return newOKPPublicKey(v)
 	case []byte:
 		return newSymmetricKey(v)
 	default:
@@ -73,7 +82,7 @@ func New(key interface{}) (Key, error) {
 	}
 }
 
-func parse(jwkSrc string) (*Set, error) {
+func parse(jwkSrc string, skipUnsupported bool) (*Set, error) {
 
 	var jwkKeySet Set
 	var jwkKey Key
@@ -99,7 +108,7 @@ func parse(jwkSrc string) (*Set, error) {
 	} else {
 		for i := range rawKeySetJSON.Keys {
 			rawKeyJSON := rawKeySetJSON.Keys[i]
-			if rawKeyJSON.Algorithm != nil && *rawKeyJSON.Algorithm == jwa.Unsupported {
+			if skipUnsupported && rawKeyJSON.Algorithm != nil && *rawKeyJSON.Algorithm == jwa.Unsupported {
 				continue
 			}
 			jwkKey, err = rawKeyJSON.GenerateKey()
@@ -114,12 +123,19 @@ func parse(jwkSrc string) (*Set, error) {
 
 // ParseBytes parses JWK from the incoming byte buffer.
 func ParseBytes(buf []byte) (*Set, error) {
-	return parse(string(buf[:]))
+	return parse(string(buf[:]), true)
 }
 
 // ParseString parses JWK from the incoming string.
 func ParseString(s string) (*Set, error) {
-	return parse(s)
+	return parse(s, true)
+}
+
+// ParseStringAll parses JWK from the incoming string. Unlike ParseString, it
+// keeps the keys of a set whose algorithm is not a signature algorithm, such
+// as keys used for key encryption.
+func ParseStringAll(s string) (*Set, error) {
+	return parse(s, false)
 }
 
 // GenerateKey creates an internal representation of a key from a raw JWK JSON
@@ -140,6 +156,12 @@ func (r *RawKeyJSON) GenerateKey() (Key, error) {
 		} else {
 			key = &ECDSAPublicKey{}
 		}
+	case jwa.OKP:
+		if r.D != nil {
+			key = &OKPPrivateKey{}
+		} else {
+			key = &OKPPublicKey{}
+		}
 	case jwa.OctetSeq:
 		key = &SymmetricKey{}
 	default:
@@ -0,0 +1,99 @@
+package jwk
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
+)
+
+func newOKPPublicKey(key ed25519.PublicKey) (*OKPPublicKey, error) {
+
+	var hdr StandardHeaders
+	err := hdr.Set(KeyTypeKey, jwa.OKP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set Key Type: %w", err)
+	}
+
+	return &OKPPublicKey{
+		StandardHeaders: &hdr,
+		key:             key,
+	}, nil
+}
+
+func newOKPPrivateKey(key ed25519.PrivateKey) (*OKPPrivateKey, error) {
+
+	var hdr StandardHeaders
+	err := hdr.Set(KeyTypeKey, jwa.OKP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set Key Type: %w", err)
+	}
+
+	return &OKPPrivateKey{
+		StandardHeaders: &hdr,
+		key:             key,
+	}, nil
+}
+
+// Materialize returns the Ed25519 public key represented by this JWK
+func (k OKPPublicKey) Materialize() (interface{}, error) {
+	return k.key, nil
+}
+
+// Materialize returns the Ed25519 private key represented by this JWK
+func (k OKPPrivateKey) Materialize() (interface{}, error) {
+	return k.key, nil
+}
+
+// GenerateKey creates a OKPPublicKey from JWK format
+func (k *OKPPublicKey) GenerateKey(keyJSON *RawKeyJSON) error {
+
+	if keyJSON.X == nil || keyJSON.Crv == "" {
+		return errors.New("missing mandatory key parameters X or Crv")
+	}
+
+	if keyJSON.Crv != jwa.Ed25519 {
+		return fmt.Errorf("invalid curve name %s", keyJSON.Crv)
+	}
+
+	x := keyJSON.X.Bytes()
+	if len(x) != ed25519.PublicKeySize {
+		return errors.New("failed to generate public key. Incorrect X value")
+	}
+
+	*k = OKPPublicKey{
+		StandardHeaders: &keyJSON.StandardHeaders,
+		key:             ed25519.PublicKey(x),
+	}
+	return nil
+}
+
+// GenerateKey creates a OKPPrivateKey from JWK format
+func (k *OKPPrivateKey) GenerateKey(keyJSON *RawKeyJSON) error {
+
+	if keyJSON.D == nil {
+		return errors.New("missing mandatory key parameter D")
+	}
+	okpPublicKey := &OKPPublicKey{}
+	err := okpPublicKey.GenerateKey(keyJSON)
+	if err != nil {
+		return fmt.Errorf("failed to generate public key: %w", err)
+	}
+
+	// For Ed25519, D holds the seed the private key is derived from.
+	d := keyJSON.D.Bytes()
+	if len(d) != ed25519.SeedSize {
+		return errors.New("failed to generate private key. Incorrect D value")
+	}
+	privateKey := ed25519.NewKeyFromSeed(d)
+	if !bytes.Equal(privateKey.Public().(ed25519.PublicKey), okpPublicKey.key) {
+		return errors.New("failed to generate private key. D does not match X")
+	}
+
+	k.key = privateKey
+	k.StandardHeaders = &keyJSON.StandardHeaders
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package jwk_test
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
+	"github.com/open-policy-agent/opa/internal/jwx/jwk"
+)
+
+func TestOKP(t *testing.T) {
+	// https://tools.ietf.org/html/rfc8037#appendix-A.1
+	const privateJWK = `{"kty":"OKP","crv":"Ed25519","d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`
+	const publicJWK = `{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`
+
+	t.Run("Private Key", func(t *testing.T) {
+		set, err := jwk.ParseString(privateJWK)
+		if err != nil {
+			t.Fatalf("Failed to parse JWK: %s", err.Error())
+		}
+		key, err := set.Keys[0].Materialize()
+		if err != nil {
+			t.Fatalf("Failed to materialize key: %s", err.Error())
+		}
+		if _, ok := key.(ed25519.PrivateKey); !ok {
+			t.Fatalf("Expected ed25519.PrivateKey, got %T", key)
+		}
+		if kty := jwk.GetKeyTypeFromKey(key); kty != jwa.OKP {
+			t.Fatalf("Expected key type OKP, got %s", kty)
+		}
+	})
+
+	t.Run("Public Key", func(t *testing.T) {
+		set, err := jwk.ParseString(publicJWK)
+		if err != nil {
+			t.Fatalf("Failed to parse JWK: %s", err.Error())
+		}
+		key, err := set.Keys[0].Materialize()
+		if err != nil {
+			t.Fatalf("Failed to materialize key: %s", err.Error())
+		}
+		if _, ok := key.(ed25519.PublicKey); !ok {
+			t.Fatalf("Expected ed25519.PublicKey, got %T", key)
+		}
+	})
+
+	t.Run("Key Generation Errors", func(t *testing.T) {
+		for _, src := range []string{
+			`{"kty":"OKP","crv":"X25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`,
+			`{"kty":"OKP","crv":"Ed25519","x":"11qYAYKx"}`,
+			`{"kty":"OKP","crv":"Ed25519","d":"AAAAne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`,
+		} {
+			if _, err := jwk.ParseString(src); err == nil {
+				t.Fatalf("Expected error parsing %s", src)
+			}
+		}
+	})
+
+	t.Run("Set With Encryption Keys", func(t *testing.T) {
+		const src = `{"keys": [` + publicJWK + `, {"kty":"oct","alg":"A128KW","k":"GawgguFyGrWKav7AX4VKUg"}]}`
+		set, err := jwk.ParseString(src)
+		if err != nil {
+			t.Fatalf("Failed to parse JWK set: %s", err.Error())
+		}
+		if len(set.Keys) != 1 {
+			t.Fatalf("Expected key with unsupported signature algorithm to be skipped, got %d keys", len(set.Keys))
+		}
+		set, err = jwk.ParseStringAll(src)
+		if err != nil {
+			t.Fatalf("Failed to parse JWK set: %s", err.Error())
+		}
+		if len(set.Keys) != 2 {
+			t.Fatalf("Expected all keys, got %d keys", len(set.Keys))
+		}
+	})
+}
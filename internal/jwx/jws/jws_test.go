@@ -172,6 +172,40 @@ func TestRoundtrip_RSACompact(t *testing.T) {
 	}
 }
 
+func TestRoundtrip_EdDSACompact(t *testing.T) {
+	// https://tools.ietf.org/html/rfc8037#appendix-A.4
+	const exp = `eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc.hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg`
+	keys, err := jwk.ParseString(`{"kty":"OKP","crv":"Ed25519","d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`)
+	if err != nil {
+		t.Fatalf("Failed to parse key: %s", err.Error())
+	}
+	key, err := keys.Keys[0].Materialize()
+	if err != nil {
+		t.Fatalf("Failed to materialize key: %s", err.Error())
+	}
+	publicKey, err := jwk.GetPublicKey(key)
+	if err != nil {
+		t.Fatalf("Failed to get public key: %s", err.Error())
+	}
+
+	payload := []byte("Example of Ed25519 signing")
+	buf, err := jws.SignLiteral(payload, jwa.EdDSA, key, []byte(`{"alg":"EdDSA"}`), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to sign message: %s", err.Error())
+	}
+	if string(buf) != exp {
+		t.Fatalf("Mismatched signature (%s):(%s)", exp, buf)
+	}
+
+	verified, err := jws.Verify(buf, jwa.EdDSA, publicKey)
+	if err != nil {
+		t.Fatalf("Failed to verify signature: %s", err.Error())
+	}
+	if !bytes.Equal(payload, verified) {
+		t.Fatalf("Mismatched payloads (%s):(%s)", payload, verified)
+	}
+}
+
 func TestEncode(t *testing.T) {
 	// HS256Compact tests that https://tools.ietf.org/html/rfc7515#appendix-A.1 works
 	t.Run("HS256Compact", func(t *testing.T) {
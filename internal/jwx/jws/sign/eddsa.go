@@ -0,0 +1,31 @@
+package sign
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
+)
+
+func newEdDSA() *EdDSASigner {
+	return &EdDSASigner{}
+}
+
+// Algorithm returns the signer algorithm
+func (s EdDSASigner) Algorithm() jwa.SignatureAlgorithm {
+	return jwa.EdDSA
+}
+
+// Sign signs payload with an Ed25519 private key
+func (s EdDSASigner) Sign(payload []byte, key interface{}) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("missing private key while signing payload")
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid key type %T. ed25519.PrivateKey is required", key)
+	}
+	return ed25519.Sign(privateKey, payload), nil
+}
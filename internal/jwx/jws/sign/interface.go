@@ -39,6 +39,9 @@ type ECDSASigner struct {
 
 type hmacSignFunc func([]byte, []byte) ([]byte, error)
 
+// EdDSASigner uses crypto/ed25519 to sign the payloads.
+type EdDSASigner struct{}
+
 // HMACSigner uses crypto/hmac to sign the payloads.
 type HMACSigner struct {
 	alg  jwa.SignatureAlgorithm
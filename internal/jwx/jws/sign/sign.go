@@ -15,6 +15,8 @@ func New(alg jwa.SignatureAlgorithm) (Signer, error) {
 		return newRSA(alg)
 	case jwa.ES256, jwa.ES384, jwa.ES512:
 		return newECDSA(alg)
+	case jwa.EdDSA:
+		return newEdDSA(), nil
 	case jwa.HS256, jwa.HS384, jwa.HS512:
 		return newHMAC(alg)
 	default:
@@ -23,7 +25,8 @@ func New(alg jwa.SignatureAlgorithm) (Signer, error) {
 }
 
 // GetSigningKey returns a *rsa.PrivateKey or *ecdsa.PrivateKey typically encoded in PEM blocks of type "RSA PRIVATE KEY"
-// or "EC PRIVATE KEY" for RSA and ECDSA family of algorithms.
+// or "EC PRIVATE KEY" for RSA and ECDSA family of algorithms, and an ed25519.PrivateKey encoded in a PEM block of type
+// "PRIVATE KEY" for EdDSA.
 // For HMAC family, it return a []byte value
 func GetSigningKey(key string, alg jwa.SignatureAlgorithm) (interface{}, error) {
 	switch alg {
@@ -57,6 +60,13 @@ func GetSigningKey(key string, alg jwa.SignatureAlgorithm) (interface{}, error)
 			return pkcs8priv, nil
 		}
 		return priv, nil
+	case jwa.EdDSA:
+		block, _ := pem.Decode([]byte(key))
+		if block == nil {
+			return nil, fmt.Errorf("failed to parse PEM block containing the key")
+		}
+
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
 	case jwa.HS256, jwa.HS384, jwa.HS512:
 		return []byte(key), nil
 	default:
@@ -0,0 +1,27 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+func newEdDSA() *EdDSAVerifier {
+	return &EdDSAVerifier{}
+}
+
+// Verify checks whether the signature for a given input and key is correct
+func (v EdDSAVerifier) Verify(payload []byte, signature []byte, key interface{}) error {
+	if key == nil {
+		return errors.New(`missing public key while verifying payload`)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf(`invalid key type %T. ed25519.PublicKey is required`, key)
+	}
+
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return errors.New(`failed to verify signature using ed25519`)
+	}
+	return nil
+}
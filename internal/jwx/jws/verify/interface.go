@@ -33,6 +33,9 @@ type ECDSAVerifier struct {
 	verify ecdsaVerifyFunc
 }
 
+// EdDSAVerifier implements the Verifier interface
+type EdDSAVerifier struct{}
+
 // HMACVerifier implements the Verifier interface
 type HMACVerifier struct {
 	signer sign.Signer
@@ -0,0 +1,762 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package lsp implements a Language Server Protocol server for Rego.
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/format"
+	"github.com/open-policy-agent/opa/internal/oracle"
+	"github.com/open-policy-agent/opa/version"
+)
+
+// ErrExitWithoutShutdown is returned by Serve if the client sends the exit
+// notification without requesting a shutdown first.
+var ErrExitWithoutShutdown = errors.New("exit without shutdown")
+
+// document represents a Rego file in the workspace or opened by the client.
+type document struct {
+	uri    string
+	text   string
+	module *ast.Module // parsed module (nil if the text could not be parsed)
+	errs   ast.Errors  // parse errors
+	open   bool        // indicates if the document is open in the client
+}
+
+// Server implements a Language Server Protocol server for Rego that
+// communicates over a pair of streams (e.g., stdin and stdout.) The server
+// supports diagnostics (parse and compile errors), go to definition, find
+// references, hover, completion and formatting.
+type Server struct {
+	in          *bufio.Reader
+	out         io.Writer
+	oracle      *oracle.Oracle
+	docs        map[string]*document // documents keyed by file name
+	initialized bool
+	shutdown    bool
+}
+
+// New returns a new Server that reads messages from in and writes messages
+// to out.
+func New(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:     bufio.NewReader(in),
+		out:    out,
+		oracle: oracle.New(),
+		docs:   map[string]*document{},
+	}
+}
+
+// Serve processes messages until the client sends the exit notification or
+// the input stream is closed.
+func (s *Server) Serve(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		bs, err := readMessage(s.in)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(bs, &req); err != nil {
+			if err := s.reply(nil, nil, newRPCError(codeParseError, "invalid message: %v", err)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return ErrExitWithoutShutdown
+			}
+			return nil
+		}
+
+		result, err := s.handle(req)
+
+		// Notifications are not answered.
+		if req.ID == nil {
+			continue
+		}
+
+		if err := s.reply(req.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(req request) (interface{}, error) {
+
+	if req.Method == "" {
+		return nil, newRPCError(codeInvalidRequest, "missing method")
+	}
+
+	if !s.initialized && req.Method != "initialize" {
+		return nil, newRPCError(codeServerNotInitialized, "server not initialized")
+	}
+
+	switch req.Method {
+	case "initialize":
+		var params InitializeParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.initialize(params)
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		s.setDocument(params.TextDocument.URI, params.TextDocument.Text, true)
+		return nil, s.publishDiagnostics()
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.setDocument(params.TextDocument.URI, params.ContentChanges[n-1].Text, true)
+		}
+		return nil, s.publishDiagnostics()
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if err := s.closeDocument(params.TextDocument.URI); err != nil {
+			return nil, err
+		}
+		return nil, s.publishDiagnostics()
+	case "textDocument/didSave":
+		return nil, nil
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(params)
+	case "textDocument/references":
+		var params ReferenceParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.references(params)
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(params)
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(params)
+	case "textDocument/formatting":
+		var params DocumentFormattingParams
+		if err := unmarshalParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.formatting(params)
+	}
+
+	if req.ID == nil || strings.HasPrefix(req.Method, "$/") {
+		// Unsupported notifications are ignored.
+		return nil, nil
+	}
+
+	return nil, newRPCError(codeMethodNotFound, "method not supported: %v", req.Method)
+}
+
+func (s *Server) initialize(params InitializeParams) (*InitializeResult, error) {
+
+	root := params.RootPath
+	if params.RootURI != "" {
+		root = uriToPath(params.RootURI)
+	}
+
+	if root != "" {
+		if err := s.loadWorkspace(root); err != nil {
+			return nil, newRPCError(codeInternalError, "failed to load workspace: %v", err)
+		}
+	}
+
+	s.initialized = true
+
+	return &InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync:           textDocumentSyncFull,
+			HoverProvider:              true,
+			DefinitionProvider:         true,
+			ReferencesProvider:         true,
+			DocumentFormattingProvider: true,
+			CompletionProvider: &CompletionOptions{
+				TriggerCharacters: []string{"."},
+			},
+		},
+		ServerInfo: &ServerInfo{
+			Name:    "opa",
+			Version: version.Version,
+		},
+	}, nil
+}
+
+// loadWorkspace reads the Rego files under root. Hidden directories (e.g.,
+// .git) are skipped.
+func (s *Server) loadWorkspace(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".rego" {
+			return nil
+		}
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		s.setDocument(pathToURI(path), string(bs), false)
+		return nil
+	})
+}
+
+func (s *Server) setDocument(uri string, text string, open bool) {
+
+	name := uriToPath(uri)
+	doc := &document{uri: uri, text: text, open: open}
+
+	module, err := ast.ParseModuleWithOpts(name, text, ast.ParserOptions{ProcessAnnotation: true})
+	if err != nil {
+		var errs ast.Errors
+		if errors.As(err, &errs) {
+			doc.errs = errs
+		} else {
+			doc.errs = ast.Errors{ast.NewError(ast.ParseErr, nil, err.Error())}
+		}
+	} else {
+		doc.module = module
+	}
+
+	s.docs[name] = doc
+}
+
+// closeDocument marks the document as closed and clears its diagnostics in
+// the client since diagnostics are only published for open documents. The
+// document is reloaded from disk since the client may have discarded changes.
+func (s *Server) closeDocument(uri string) error {
+	name := uriToPath(uri)
+	if bs, err := os.ReadFile(name); err == nil {
+		s.setDocument(uri, string(bs), false)
+	} else {
+		delete(s.docs, name)
+	}
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: []Diagnostic{},
+	})
+}
+
+// modules returns the parsed modules of all documents.
+func (s *Server) modules() map[string]*ast.Module {
+	result := make(map[string]*ast.Module, len(s.docs))
+	for name, doc := range s.docs {
+		if doc.module != nil {
+			result[name] = doc.module
+		}
+	}
+	return result
+}
+
+// publishDiagnostics compiles the workspace and publishes the parse and
+// compile errors of the open documents.
+func (s *Server) publishDiagnostics() error {
+
+	errs := map[string]ast.Errors{}
+
+	for name, doc := range s.docs {
+		errs[name] = append(errs[name], doc.errs...)
+	}
+
+	c := ast.NewCompiler().SetErrorLimit(0)
+	c.Compile(s.modules())
+
+	for _, err := range c.Errors {
+		if err.Location != nil {
+			errs[err.Location.File] = append(errs[err.Location.File], err)
+		}
+	}
+
+	for _, name := range s.sortedDocuments() {
+		doc := s.docs[name]
+		if !doc.open {
+			continue
+		}
+		diagnostics := make([]Diagnostic, 0, len(errs[name]))
+		for _, err := range errs[name] {
+			diagnostics = append(diagnostics, Diagnostic{
+				Range:    doc.locationRange(err.Location),
+				Severity: SeverityError,
+				Code:     err.Code,
+				Source:   "opa",
+				Message:  err.Message,
+			})
+		}
+		if err := s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI:         doc.uri,
+			Diagnostics: diagnostics,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) definition(params TextDocumentPositionParams) (*Location, error) {
+
+	name, doc, ok := s.parsedDocument(params.TextDocument.URI)
+	if !ok {
+		return nil, nil
+	}
+
+	result, err := s.oracle.FindDefinition(oracle.DefinitionQuery{
+		Filename: name,
+		Pos:      doc.offset(params.Position),
+		Modules:  s.modules(),
+	})
+	if err != nil || result.Result == nil {
+		return nil, nil
+	}
+
+	loc := s.location(result.Result)
+	return &loc, nil
+}
+
+func (s *Server) references(params ReferenceParams) ([]Location, error) {
+
+	name, doc, ok := s.parsedDocument(params.TextDocument.URI)
+	if !ok {
+		return nil, nil
+	}
+
+	result, err := s.oracle.FindReferences(oracle.ReferencesQuery{
+		Filename: name,
+		Pos:      doc.offset(params.Position),
+		Modules:  s.modules(),
+	})
+	if err != nil {
+		return nil, nil
+	}
+
+	locs := result.Result
+	if params.Context.IncludeDeclaration {
+		locs = append(result.Definitions, locs...)
+	}
+
+	refs := make([]Location, 0, len(locs))
+	for _, loc := range locs {
+		refs = append(refs, s.location(loc))
+	}
+
+	return refs, nil
+}
+
+func (s *Server) hover(params TextDocumentPositionParams) (*Hover, error) {
+
+	name, doc, ok := s.parsedDocument(params.TextDocument.URI)
+	if !ok {
+		return nil, nil
+	}
+
+	result, err := s.oracle.FindSymbol(oracle.SymbolQuery{
+		Filename: name,
+		Pos:      doc.offset(params.Position),
+		Modules:  s.modules(),
+	})
+	if err != nil {
+		return nil, nil
+	}
+
+	var buf strings.Builder
+
+	switch {
+	case result.Builtin != nil:
+		fmt.Fprintf(&buf, "```rego\n%v\n```\n", builtinSignature(result.Builtin))
+		if desc := strings.TrimSpace(result.Builtin.Description); desc != "" {
+			fmt.Fprintf(&buf, "\n%v\n", desc)
+		}
+	case len(result.Rules) > 0:
+		rule := result.Rules[0]
+		fmt.Fprintf(&buf, "```rego\n%v\n```\n", rule.Module.Package.Path.Extend(rule.Head.Ref().GroundPrefix()))
+		for _, ref := range result.Annotations {
+			a := ref.Annotations
+			if title := strings.TrimSpace(a.Title); title != "" {
+				fmt.Fprintf(&buf, "\n**%v**\n", title)
+			}
+			if desc := strings.TrimSpace(a.Description); desc != "" {
+				fmt.Fprintf(&buf, "\n%v\n", desc)
+			}
+		}
+	default:
+		return nil, nil
+	}
+
+	r := doc.locationRange(result.Term.Location)
+
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: buf.String()},
+		Range:    &r,
+	}, nil
+}
+
+func builtinSignature(bi *ast.Builtin) string {
+	if bi.Decl == nil {
+		return bi.Name
+	}
+	args := bi.Decl.NamedFuncArgs().Args
+	strs := make([]string, len(args))
+	for i := range args {
+		strs[i] = args[i].String()
+	}
+	sig := fmt.Sprintf("%v(%v)", bi.Name, strings.Join(strs, ", "))
+	if result := bi.Decl.NamedResult(); result != nil {
+		sig += " => " + result.String()
+	}
+	return sig
+}
+
+func (s *Server) completion(params TextDocumentPositionParams) (*CompletionList, error) {
+
+	name := uriToPath(params.TextDocument.URI)
+	doc, ok := s.docs[name]
+	if !ok {
+		return nil, nil
+	}
+
+	offset := doc.offset(params.Position)
+	lineStart := strings.LastIndexByte(doc.text[:offset], '\n') + 1
+	line := doc.text[lineStart:offset]
+
+	wordStart := len(line)
+	for wordStart > 0 && isRefChar(line[wordStart-1]) {
+		wordStart--
+	}
+
+	word := line[wordStart:]
+	replace := Range{
+		Start: doc.position(lineStart + wordStart),
+		End:   params.Position,
+	}
+
+	var candidates []CompletionItem
+
+	if strings.HasPrefix(strings.TrimSpace(line), "import ") {
+		candidates = s.importCompletions()
+	} else {
+		candidates = s.refCompletions(doc)
+	}
+
+	result := &CompletionList{Items: []CompletionItem{}}
+	seen := map[string]struct{}{}
+
+	for _, item := range candidates {
+		if _, ok := seen[item.Label]; ok || !strings.HasPrefix(item.Label, word) {
+			continue
+		}
+		seen[item.Label] = struct{}{}
+		item.TextEdit = &TextEdit{Range: replace, NewText: item.Label}
+		result.Items = append(result.Items, item)
+	}
+
+	sort.Slice(result.Items, func(i, j int) bool {
+		return result.Items[i].Label < result.Items[j].Label
+	})
+
+	return result, nil
+}
+
+func isRefChar(b byte) bool {
+	return b == '.' || b == '_' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
+
+// importCompletions returns the packages in the workspace and the imports
+// that are not backed by packages.
+func (s *Server) importCompletions() []CompletionItem {
+
+	result := []CompletionItem{
+		{Label: "future.keywords", Kind: CompletionItemKindModule},
+		{Label: "input", Kind: CompletionItemKindModule},
+		{Label: "rego.v1", Kind: CompletionItemKindModule},
+	}
+
+	for _, mod := range s.modules() {
+		result = append(result, CompletionItem{
+			Label: mod.Package.Path.String(),
+			Kind:  CompletionItemKindModule,
+		})
+	}
+
+	return result
+}
+
+// refCompletions returns the built-in functions, the rules in the workspace
+// and the imports of doc.
+func (s *Server) refCompletions(doc *document) []CompletionItem {
+
+	var result []CompletionItem
+
+	for _, bi := range ast.Builtins {
+		if bi.Infix != "" || strings.HasPrefix(bi.Name, "internal.") {
+			continue
+		}
+		item := CompletionItem{
+			Label:  bi.Name,
+			Kind:   CompletionItemKindFunction,
+			Detail: builtinSignature(bi),
+		}
+		if bi.Description != "" {
+			item.Documentation = &MarkupContent{Kind: "markdown", Value: bi.Description}
+		}
+		result = append(result, item)
+	}
+
+	for _, mod := range s.modules() {
+		local := doc.module != nil && mod.Package.Path.Equal(doc.module.Package.Path)
+		for _, rule := range mod.Rules {
+			kind := CompletionItemKindVariable
+			if len(rule.Head.Args) > 0 {
+				kind = CompletionItemKindFunction
+			}
+			ref := rule.Head.Ref().GroundPrefix()
+			path := mod.Package.Path.Extend(ref)
+			result = append(result, CompletionItem{Label: path.String(), Kind: kind})
+			if local {
+				result = append(result, CompletionItem{Label: ref.String(), Kind: kind, Detail: path.String()})
+			}
+		}
+	}
+
+	if doc.module != nil {
+		for _, imp := range doc.module.Imports {
+			result = append(result, CompletionItem{
+				Label:  imp.Name().String(),
+				Kind:   CompletionItemKindModule,
+				Detail: imp.Path.String(),
+			})
+		}
+	}
+
+	return result
+}
+
+func (s *Server) formatting(params DocumentFormattingParams) ([]TextEdit, error) {
+
+	name := uriToPath(params.TextDocument.URI)
+	doc, ok := s.docs[name]
+	if !ok || doc.module == nil {
+		return nil, nil
+	}
+
+	bs, err := format.Source(name, []byte(doc.text))
+	if err != nil {
+		return nil, nil
+	}
+
+	if string(bs) == doc.text {
+		return []TextEdit{}, nil
+	}
+
+	return []TextEdit{{
+		Range: Range{
+			Start: Position{},
+			End:   doc.position(len(doc.text)),
+		},
+		NewText: string(bs),
+	}}, nil
+}
+
+// parsedDocument returns the document identified by uri if its current text
+// could be parsed. Positions in documents that could not be parsed cannot be
+// mapped to the AST.
+func (s *Server) parsedDocument(uri string) (string, *document, bool) {
+	name := uriToPath(uri)
+	doc, ok := s.docs[name]
+	if !ok || doc.module == nil {
+		return "", nil, false
+	}
+	return name, doc, true
+}
+
+func (s *Server) sortedDocuments() []string {
+	names := make([]string, 0, len(s.docs))
+	for name := range s.docs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// location returns the LSP location of loc.
+func (s *Server) location(loc *ast.Location) Location {
+	if doc, ok := s.docs[loc.File]; ok {
+		return Location{URI: doc.uri, Range: doc.locationRange(loc)}
+	}
+	return Location{URI: pathToURI(loc.File), Range: (&document{}).locationRange(loc)}
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, err error) error {
+	if err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = newRPCError(codeInternalError, err.Error())
+		}
+		return writeMessage(s.out, errorResponse{JSONRPC: "2.0", ID: id, Error: rpcErr})
+	}
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func unmarshalParams(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return newRPCError(codeInvalidParams, "invalid params: %v", err)
+	}
+	return nil
+}
+
+// offset returns the byte offset of pos in the document.
+func (doc *document) offset(pos Position) int {
+
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(doc.text[offset:], '\n')
+		if i < 0 {
+			return len(doc.text)
+		}
+		offset += i + 1
+	}
+
+	// Characters are measured in UTF-16 code units.
+	units := 0
+	for i, r := range doc.text[offset:] {
+		if units >= pos.Character || r == '\n' {
+			return offset + i
+		}
+		units += utf16Len(r)
+	}
+
+	return len(doc.text)
+}
+
+// position returns the position of the byte offset in the document.
+func (doc *document) position(offset int) Position {
+
+	if offset > len(doc.text) {
+		offset = len(doc.text)
+	}
+
+	prefix := doc.text[:offset]
+	line := strings.Count(prefix, "\n")
+	lineStart := strings.LastIndexByte(prefix, '\n') + 1
+
+	units := 0
+	for _, r := range prefix[lineStart:] {
+		units += utf16Len(r)
+	}
+
+	return Position{Line: line, Character: units}
+}
+
+// locationRange returns the range of the text at loc. If the document text is
+// not known (or does not match the location), the range is approximated from
+// the row and column of the location.
+func (doc *document) locationRange(loc *ast.Location) Range {
+
+	if loc == nil {
+		return Range{}
+	}
+
+	if loc.Offset >= 0 && loc.Offset+len(loc.Text) <= len(doc.text) && strings.HasPrefix(doc.text[loc.Offset:], string(loc.Text)) {
+		return Range{
+			Start: doc.position(loc.Offset),
+			End:   doc.position(loc.Offset + len(loc.Text)),
+		}
+	}
+
+	start := Position{}
+	if loc.Row > 0 {
+		start.Line = loc.Row - 1
+	}
+	if loc.Col > 0 {
+		start.Character = loc.Col - 1
+	}
+
+	width := len(loc.Text)
+	if i := strings.IndexByte(string(loc.Text), '\n'); i >= 0 {
+		width = i
+	}
+
+	return Range{Start: start, End: Position{Line: start.Line, Character: start.Character + width}}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// uriToPath returns the file name of the document identified by uri. URIs
+// that do not use the file scheme are used as file names as-is.
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
@@ -0,0 +1,390 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+type testClient struct {
+	t             *testing.T
+	in            io.Writer
+	out           chan testResponse
+	nextID        int
+	notifications []notification
+}
+
+// newTestClient returns a client that writes messages to in and reads the
+// messages from out in the background so that the server does not block
+// when it sends notifications.
+func newTestClient(t *testing.T, in io.Writer, out io.Reader) *testClient {
+	c := &testClient{t: t, in: in, out: make(chan testResponse, 100)}
+	go func() {
+		defer close(c.out)
+		r := bufio.NewReader(out)
+		for {
+			bs, err := readMessage(r)
+			if err != nil {
+				return
+			}
+			var resp testResponse
+			if err := json.Unmarshal(bs, &resp); err != nil {
+				return
+			}
+			c.out <- resp
+		}
+	}()
+	return c
+}
+
+type testResponse struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// call sends a request and returns the response, recording the notifications
+// sent by the server in the meantime.
+func (c *testClient) call(method string, params interface{}, result interface{}) *rpcError {
+	c.t.Helper()
+	c.nextID++
+	id := json.RawMessage(itoa(c.nextID))
+	if err := writeMessage(c.in, struct {
+		JSONRPC string           `json:"jsonrpc"`
+		ID      *json.RawMessage `json:"id"`
+		Method  string           `json:"method"`
+		Params  interface{}      `json:"params"`
+	}{"2.0", &id, method, params}); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		resp := c.read()
+		if resp.ID == nil {
+			c.notifications = append(c.notifications, notification{Method: resp.Method, Params: resp.Params})
+			continue
+		}
+		if *resp.ID != c.nextID {
+			c.t.Fatalf("unexpected response id %d", *resp.ID)
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(resp.Result, result); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return nil
+	}
+}
+
+func (c *testClient) notify(method string, params interface{}) {
+	c.t.Helper()
+	if err := writeMessage(c.in, notification{JSONRPC: "2.0", Method: method, Params: params}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// diagnostics returns the diagnostics published for uri by the server since
+// the last call. The client must make a call first so that the notifications
+// have been received.
+func (c *testClient) diagnostics(uri string) (result []Diagnostic, found bool) {
+	c.t.Helper()
+	for _, n := range c.notifications {
+		if n.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+		var params PublishDiagnosticsParams
+		if err := json.Unmarshal(n.Params.(json.RawMessage), &params); err != nil {
+			c.t.Fatal(err)
+		}
+		if params.URI == uri {
+			result, found = params.Diagnostics, true
+		}
+	}
+	c.notifications = nil
+	return result, found
+}
+
+func (c *testClient) read() testResponse {
+	c.t.Helper()
+	resp, ok := <-c.out
+	if !ok {
+		c.t.Fatal("unexpected end of messages")
+	}
+	return resp
+}
+
+func itoa(i int) string {
+	bs, _ := json.Marshal(i)
+	return string(bs)
+}
+
+func TestServer(t *testing.T) {
+
+	files := map[string]string{
+		"a.rego": `package a
+
+# METADATA
+# title: Admins
+# description: The set of admin users.
+admins := {"alice", "bob"}
+`,
+		"b.rego": `package b
+
+import data.a
+
+allow {
+	a.admins[input.user]
+	count(input.roles) > 0
+}
+`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+
+		clientIn, serverIn := io.Pipe()
+		serverOut, clientOut := io.Pipe()
+
+		done := make(chan error)
+		go func() {
+			done <- New(clientIn, clientOut).Serve(context.Background())
+		}()
+
+		c := newTestClient(t, serverIn, serverOut)
+
+		if err := c.call("textDocument/hover", nil, nil); err == nil || err.Code != codeServerNotInitialized {
+			t.Fatalf("expected not initialized error but got: %v", err)
+		}
+
+		var init InitializeResult
+		if err := c.call("initialize", InitializeParams{RootURI: pathToURI(root)}, &init); err != nil {
+			t.Fatal(err)
+		}
+
+		if !init.Capabilities.DefinitionProvider || init.Capabilities.TextDocumentSync != textDocumentSyncFull {
+			t.Fatalf("unexpected capabilities: %+v", init.Capabilities)
+		}
+
+		c.notify("initialized", struct{}{})
+
+		aURI := pathToURI(filepath.Join(root, "a.rego"))
+		bURI := pathToURI(filepath.Join(root, "b.rego"))
+
+		// Diagnostics
+
+		c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{
+			URI:        bURI,
+			LanguageID: "rego",
+			Text:       files["b.rego"] + "\ndeny { x }\n",
+		}})
+
+		var hover *Hover
+		if err := c.call("textDocument/hover", TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: bURI},
+			Position:     Position{Line: 6, Character: 2},
+		}, &hover); err != nil {
+			t.Fatal(err)
+		}
+
+		diags, ok := c.diagnostics(bURI)
+		if !ok || len(diags) != 1 || diags[0].Message != "var x is unsafe" || diags[0].Range.Start != (Position{Line: 9, Character: 7}) {
+			t.Fatalf("unexpected diagnostics: %+v", diags)
+		}
+
+		// Hover (built-in function)
+
+		if hover == nil || !strings.Contains(hover.Contents.Value, "count(collection: ") || !strings.Contains(hover.Contents.Value, "Count takes a collection") {
+			t.Fatalf("unexpected hover: %+v", hover)
+		}
+
+		c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+			TextDocument:   VersionedTextDocumentIdentifier{URI: bURI, Version: 2},
+			ContentChanges: []TextDocumentContentChangeEvent{{Text: files["b.rego"]}},
+		})
+
+		// Hover (rule with annotations)
+
+		if err := c.call("textDocument/hover", TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: bURI},
+			Position:     Position{Line: 5, Character: 4},
+		}, &hover); err != nil {
+			t.Fatal(err)
+		}
+
+		if hover == nil || !strings.Contains(hover.Contents.Value, "data.a.admins") || !strings.Contains(hover.Contents.Value, "The set of admin users.") {
+			t.Fatalf("unexpected hover: %+v", hover)
+		}
+
+		diags, ok = c.diagnostics(bURI)
+		if !ok || len(diags) != 0 {
+			t.Fatalf("expected diagnostics to be cleared but got: %+v", diags)
+		}
+
+		// Definition
+
+		var def *Location
+		if err := c.call("textDocument/definition", TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: bURI},
+			Position:     Position{Line: 5, Character: 4},
+		}, &def); err != nil {
+			t.Fatal(err)
+		}
+
+		if def == nil || def.URI != aURI || def.Range.Start != (Position{Line: 5, Character: 0}) {
+			t.Fatalf("unexpected definition: %+v", def)
+		}
+
+		// References
+
+		var refs []Location
+		if err := c.call("textDocument/references", ReferenceParams{
+			TextDocumentPositionParams: TextDocumentPositionParams{
+				TextDocument: TextDocumentIdentifier{URI: aURI},
+				Position:     Position{Line: 5, Character: 1},
+			},
+			Context: ReferenceContext{IncludeDeclaration: true},
+		}, &refs); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(refs) != 2 || refs[0].URI != aURI || refs[1].URI != bURI || refs[1].Range != (Range{Start: Position{Line: 5, Character: 1}, End: Position{Line: 5, Character: 21}}) {
+			t.Fatalf("unexpected references: %+v", refs)
+		}
+
+		// Completion
+
+		var completions CompletionList
+		if err := c.call("textDocument/completion", TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: bURI},
+			Position:     Position{Line: 6, Character: 3},
+		}, &completions); err != nil {
+			t.Fatal(err)
+		}
+
+		labels := map[string]CompletionItem{}
+		for _, item := range completions.Items {
+			labels[item.Label] = item
+		}
+
+		if item, ok := labels["count"]; !ok || item.TextEdit.Range.Start != (Position{Line: 6, Character: 1}) {
+			t.Fatalf("expected count completion but got: %+v", completions.Items)
+		}
+
+		if _, ok := labels["concat"]; !ok {
+			t.Fatalf("expected concat completion but got: %+v", completions.Items)
+		}
+
+		if _, ok := labels["startswith"]; ok {
+			t.Fatalf("unexpected startswith completion")
+		}
+
+		// Formatting
+
+		c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+			TextDocument:   VersionedTextDocumentIdentifier{URI: aURI, Version: 2},
+			ContentChanges: []TextDocumentContentChangeEvent{{Text: "package a\nadmins := {\"alice\",\"bob\"}"}},
+		})
+
+		var edits []TextEdit
+		if err := c.call("textDocument/formatting", DocumentFormattingParams{
+			TextDocument: TextDocumentIdentifier{URI: aURI},
+		}, &edits); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(edits) != 1 || edits[0].NewText != "package a\n\nadmins := {\"alice\", \"bob\"}\n" || edits[0].Range.End != (Position{Line: 1, Character: 25}) {
+			t.Fatalf("unexpected edits: %+v", edits)
+		}
+
+		// Diagnostics (cleared on close)
+
+		c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+			TextDocument:   VersionedTextDocumentIdentifier{URI: bURI, Version: 3},
+			ContentChanges: []TextDocumentContentChangeEvent{{Text: files["b.rego"] + "\ndeny { x }\n"}},
+		})
+		c.notify("textDocument/didClose", DidCloseTextDocumentParams{
+			TextDocument: TextDocumentIdentifier{URI: bURI},
+		})
+
+		if err := c.call("textDocument/formatting", DocumentFormattingParams{
+			TextDocument: TextDocumentIdentifier{URI: aURI},
+		}, &edits); err != nil {
+			t.Fatal(err)
+		}
+
+		diags, ok = c.diagnostics(bURI)
+		if !ok || diags == nil || len(diags) != 0 {
+			t.Fatalf("expected diagnostics to be cleared on close but got: %+v", diags)
+		}
+
+		// Unknown methods
+
+		if err := c.call("workspace/symbol", struct{}{}, nil); err == nil || err.Code != codeMethodNotFound {
+			t.Fatalf("expected method not found error but got: %v", err)
+		}
+
+		if err := c.call("shutdown", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		c.notify("exit", nil)
+
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestServerExitWithoutShutdown(t *testing.T) {
+
+	var in strings.Builder
+	if err := writeMessage(&in, notification{JSONRPC: "2.0", Method: "exit"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := New(strings.NewReader(in.String()), io.Discard).Serve(context.Background())
+	if err != ErrExitWithoutShutdown {
+		t.Fatalf("expected exit without shutdown error but got: %v", err)
+	}
+}
+
+func TestDocumentPositions(t *testing.T) {
+
+	doc := &document{text: "package x\n\np { \"😀\" == x }\n"}
+
+	tests := []struct {
+		pos    Position
+		offset int
+	}{
+		{Position{0, 0}, 0},
+		{Position{0, 3}, 3},
+		{Position{2, 5}, 16},   // the emoji
+		{Position{2, 7}, 20},   // after the emoji: 4 bytes, 2 UTF-16 code units
+		{Position{2, 9}, 22},   // the '=' following the emoji
+		{Position{2, 100}, 28}, // clamped to the end of the line
+		{Position{100, 0}, 29}, // clamped to the end of the document
+	}
+
+	for _, tc := range tests {
+		if offset := doc.offset(tc.pos); offset != tc.offset {
+			t.Errorf("expected offset %d for %v but got %d", tc.offset, tc.pos, offset)
+		}
+	}
+
+	if pos := doc.position(22); pos != (Position{2, 9}) {
+		t.Errorf("unexpected position: %v", pos)
+	}
+}
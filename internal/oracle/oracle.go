@@ -2,6 +2,7 @@ package oracle
 
 import (
 	"errors"
+	"sort"
 
 	"github.com/open-policy-agent/opa/ast"
 )
@@ -111,6 +112,216 @@ func (o *Oracle) FindDefinition(q DefinitionQuery) (*DefinitionQueryResult, erro
 	return nil, ErrNoDefinitionFound
 }
 
+// ReferencesQuery defines a Rego find references query.
+type ReferencesQuery struct {
+	Filename string                 // name of file to search for position inside of
+	Pos      int                    // position to search for
+	Modules  map[string]*ast.Module // workspace modules; buffer may shadow a file inside the workspace
+	Buffer   []byte                 // buffer that overrides module with filename
+}
+
+// ReferencesQueryResult defines output of a references query.
+type ReferencesQueryResult struct {
+	Result      []*ast.Location `json:"result"`
+	Definitions []*ast.Location `json:"definitions,omitempty"` // heads of the rules referred to, if any
+}
+
+// FindReferences returns the locations of the references to the rule or
+// variable referred to by the symbol at the position in q. References to
+// rules are searched for in all modules. References to variables are
+// searched for in the enclosing rule.
+func (o *Oracle) FindReferences(q ReferencesQuery) (*ReferencesQueryResult, error) {
+
+	compiler, _, err := compileUpto("SetRuleTree", q.Modules, q.Buffer, q.Filename)
+	if err != nil {
+		return nil, err
+	}
+	mod, ok := compiler.Modules[q.Filename]
+	if !ok {
+		return nil, ErrNoMatchFound
+	}
+	stack := findContainingNodeStack(mod, q.Pos)
+	if len(stack) == 0 {
+		return nil, ErrNoMatchFound
+	}
+
+	if path := findRulePath(compiler, mod, stack); path != nil {
+		result := &ReferencesQueryResult{}
+		for _, rule := range compiler.GetRulesExact(path) {
+			result.Definitions = append(result.Definitions, rule.Head.Loc())
+		}
+		for _, name := range sortedModuleNames(compiler.Modules) {
+			ast.WalkTerms(compiler.Modules[name], func(term *ast.Term) bool {
+				if ref, ok := term.Value.(ast.Ref); ok && ref.HasPrefix(path) && term.Location != nil {
+					result.Result = append(result.Result, term.Location)
+				}
+				return false
+			})
+		}
+		return result, nil
+	}
+
+	top := stack[len(stack)-1]
+	if term, ok := top.(*ast.Term); ok {
+		if name, ok := term.Value.(ast.Var); ok {
+			for i := 0; i < len(stack); i++ {
+				if rule, ok := stack[i].(*ast.Rule); ok {
+					return &ReferencesQueryResult{Result: walkToAllOccurrences(rule, name)}, nil
+				}
+			}
+		}
+	}
+
+	return nil, ErrNoDefinitionFound
+}
+
+// SymbolQuery defines a query for the symbol at a position.
+type SymbolQuery struct {
+	Filename string                 // name of file to search for position inside of
+	Pos      int                    // position to search for
+	Modules  map[string]*ast.Module // workspace modules; buffer may shadow a file inside the workspace
+	Buffer   []byte                 // buffer that overrides module with filename
+}
+
+// SymbolQueryResult defines output of a symbol query.
+type SymbolQueryResult struct {
+	Term    *ast.Term    // innermost ref (or term) at the position; refs to rules are fully qualified
+	Builtin *ast.Builtin // built-in function called at the position, if any
+	Rules   []*ast.Rule  // rules referred to at the position, if any
+
+	// Annotations that apply to the rules referred to at the position,
+	// ordered from the closest to the farthest (see ast.AnnotationSet.Chain).
+	Annotations []*ast.AnnotationsRef
+}
+
+// FindSymbol returns the symbol at the position in q along with the built-in
+// function or rules that it refers to.
+func (o *Oracle) FindSymbol(q SymbolQuery) (*SymbolQueryResult, error) {
+
+	compiler, _, err := compileUpto("SetRuleTree", q.Modules, q.Buffer, q.Filename)
+	if err != nil {
+		return nil, err
+	}
+	mod, ok := compiler.Modules[q.Filename]
+	if !ok {
+		return nil, ErrNoMatchFound
+	}
+	stack := findContainingNodeStack(mod, q.Pos)
+	if len(stack) == 0 {
+		return nil, ErrNoMatchFound
+	}
+
+	result := &SymbolQueryResult{}
+
+	if path := findRulePath(compiler, mod, stack); path != nil {
+		result.Rules = compiler.GetRulesExact(path)
+		if len(result.Rules) > 0 {
+			result.Annotations = findAnnotations(compiler, result.Rules[0])
+		}
+	}
+
+	for i := len(stack) - 1; i >= 0; i-- {
+		term, ok := stack[i].(*ast.Term)
+		if !ok {
+			continue
+		}
+		if result.Term == nil {
+			result.Term = term
+		}
+		if ref, ok := term.Value.(ast.Ref); ok {
+			result.Term = term
+			if bi, ok := ast.BuiltinMap[ref.String()]; ok {
+				result.Builtin = bi
+			}
+			break
+		}
+	}
+
+	if result.Term == nil {
+		return nil, ErrNoDefinitionFound
+	}
+
+	return result, nil
+}
+
+// findRulePath returns the path of the rules referred to by the innermost ref
+// in stack, or the path of the rule whose head is at the top of the stack. If
+// the stack does not refer to a rule, nil is returned.
+func findRulePath(compiler *ast.Compiler, mod *ast.Module, stack []ast.Node) ast.Ref {
+
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch node := stack[i].(type) {
+		case *ast.Term:
+			if ref, ok := node.Value.(ast.Ref); ok {
+				prefix := ref.ConstantPrefix()
+				for j := len(prefix); j > 0; j-- {
+					if rules := compiler.GetRulesExact(prefix[:j]); len(rules) > 0 {
+						return prefix[:j]
+					}
+				}
+			}
+		case *ast.Head:
+			// NOTE: The visitor doesn't traverse into the head ref, so the
+			// head is the innermost node if the position refers to the rule
+			// name.
+			if i == len(stack)-1 {
+				return mod.Package.Path.Extend(node.Ref().GroundPrefix())
+			}
+		}
+	}
+
+	return nil
+}
+
+// findAnnotations returns the annotations that apply to rule. Annotations
+// are not set on the compiler in the stages run by the oracle, so the set is
+// built from the modules.
+func findAnnotations(compiler *ast.Compiler, rule *ast.Rule) []*ast.AnnotationsRef {
+	modules := make([]*ast.Module, 0, len(compiler.Modules))
+	for _, name := range sortedModuleNames(compiler.Modules) {
+		modules = append(modules, compiler.Modules[name])
+	}
+	as, errs := ast.BuildAnnotationSet(modules)
+	if len(errs) > 0 {
+		return nil
+	}
+	var result []*ast.AnnotationsRef
+	for _, ref := range as.Chain(rule) {
+		if ref.Annotations != nil {
+			result = append(result, ref)
+		}
+	}
+	return result
+}
+
+func walkToAllOccurrences(node ast.Node, needle ast.Var) (matches []*ast.Location) {
+	ast.WalkNodes(node, func(x ast.Node) bool {
+		switch x := x.(type) {
+		case *ast.SomeDecl:
+			for i := range x.Symbols {
+				if x.Symbols[i].Value.Compare(needle) == 0 {
+					matches = append(matches, x.Symbols[i].Location)
+				}
+			}
+		case *ast.Term:
+			if x.Value.Compare(needle) == 0 && x.Location != nil {
+				matches = append(matches, x.Location)
+			}
+		}
+		return false
+	})
+	return matches
+}
+
+func sortedModuleNames(modules map[string]*ast.Module) []string {
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func walkToFirstOccurrence(node ast.Node, needle ast.Var) (match *ast.Term) {
 	ast.WalkNodes(node, func(x ast.Node) bool {
 		if match == nil {
@@ -2,6 +2,7 @@ package oracle
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
 
@@ -520,3 +521,138 @@ func TestCompileUptoBadStageName(t *testing.T) {
 		t.Fatal("expected halt error but got:", err)
 	}
 }
+
+func TestOracleFindReferences(t *testing.T) {
+
+	const aBufferModule = `package test
+
+p {
+	q
+	x := 1
+	x > 0
+}
+
+q = true
+
+r {
+	q
+}`
+
+	const otherModule = `package other
+
+s {
+	data.test.q
+}`
+
+	cases := []struct {
+		note string
+		pos  int
+		exp  []string
+		defs []string
+	}{
+		{
+			note: "rule reference",
+			pos:  19, // this points at 'q' in the body of 'p'
+			exp:  []string{"buffer.rego:4:2", "buffer.rego:12:2", "other.rego:4:2"},
+			defs: []string{"buffer.rego:9:1"},
+		},
+		{
+			note: "rule head",
+			pos:  39, // this points at the rule 'q'
+			exp:  []string{"buffer.rego:4:2", "buffer.rego:12:2", "other.rego:4:2"},
+			defs: []string{"buffer.rego:9:1"},
+		},
+		{
+			note: "local var",
+			pos:  22, // this points at 'x'
+			exp:  []string{"buffer.rego:5:2", "buffer.rego:6:2"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.note, func(t *testing.T) {
+			other, err := ast.ParseModule("other.rego", otherModule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := New().FindReferences(ReferencesQuery{
+				Modules: map[string]*ast.Module{
+					"other.rego": other,
+				},
+				Buffer:   []byte(aBufferModule),
+				Filename: "buffer.rego",
+				Pos:      tc.pos,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			locStrings := func(locs []*ast.Location) (result []string) {
+				for _, loc := range locs {
+					result = append(result, fmt.Sprintf("%v:%v:%v", loc.File, loc.Row, loc.Col))
+				}
+				return result
+			}
+			if got := locStrings(result.Result); strings.Join(got, ",") != strings.Join(tc.exp, ",") {
+				t.Fatalf("expected %v but got %v", tc.exp, got)
+			}
+			if got := locStrings(result.Definitions); strings.Join(got, ",") != strings.Join(tc.defs, ",") {
+				t.Fatalf("expected definitions %v but got %v", tc.defs, got)
+			}
+		})
+	}
+}
+
+func TestOracleFindSymbol(t *testing.T) {
+
+	const aBufferModule = `package test
+
+p {
+	count(input.xs) > 1
+	q
+}
+
+# METADATA
+# description: q is always true
+q = true`
+
+	modules := map[string]*ast.Module{}
+	module, err := ast.ParseModuleWithOpts("buffer.rego", aBufferModule, ast.ParserOptions{ProcessAnnotation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modules["buffer.rego"] = module
+
+	result, err := New().FindSymbol(SymbolQuery{
+		Modules:  modules,
+		Filename: "buffer.rego",
+		Pos:      19, // this points at 'count'
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Builtin == nil || result.Builtin.Name != "count" {
+		t.Fatalf("expected count built-in but got: %v", result.Builtin)
+	}
+
+	result, err = New().FindSymbol(SymbolQuery{
+		Modules:  modules,
+		Filename: "buffer.rego",
+		Pos:      40, // this points at 'q'
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Term.String() != "data.test.q" {
+		t.Fatalf("expected data.test.q but got: %v", result.Term)
+	}
+
+	if len(result.Rules) != 1 {
+		t.Fatalf("expected exactly one rule but got: %v", result.Rules)
+	}
+
+	if len(result.Annotations) != 1 || result.Annotations[0].Annotations.Description != "q is always true" {
+		t.Fatalf("expected annotations but got: %v", result.Annotations)
+	}
+}
@@ -143,10 +143,13 @@ func NewOutputErrors(err error) []OutputError {
 		switch typedErr := err.(type) {
 		case *ast.Error:
 			oe := OutputError{
-				Code:    typedErr.Code,
-				Message: typedErr.Message,
-				Details: typedErr.Details,
-				err:     typedErr,
+				Code:      typedErr.Code,
+				Message:   typedErr.Message,
+				Details:   typedErr.Details,
+				Hints:     typedErr.Hints,
+				Related:   typedErr.Related,
+				CodeFrame: typedErr.CodeFrame,
+				err:       typedErr,
 			}
 
 			// TODO(patrick-east): Why does the JSON marshaller marshal
@@ -207,6 +210,50 @@ func NewOutputErrors(err error) []OutputError {
 	return errs
 }
 
+// SetCodeFrames sets the code frames of the AST errors contained in err. The
+// source files that the errors refer to are read with the read function and
+// files that cannot be read are skipped. Errors in ad-hoc queries refer to the
+// file with the empty name.
+func SetCodeFrames(err error, read func(string) ([]byte, error)) {
+
+	sources := map[string][]byte{}
+
+	var walk func(error)
+	walk = func(err error) {
+		switch typedErr := err.(type) {
+		case *ast.Error:
+			if typedErr.Location == nil {
+				return
+			}
+			file := typedErr.Location.File
+			if _, ok := sources[file]; !ok {
+				if bs, err := read(file); err == nil {
+					sources[file] = bs
+				} else {
+					sources[file] = nil
+				}
+			}
+			if src := sources[file]; src != nil {
+				ast.Errors{typedErr}.SetCodeFrames(map[string][]byte{file: src})
+			}
+		case ast.Errors:
+			for _, e := range typedErr {
+				walk(e)
+			}
+		case rego.Errors:
+			for _, e := range typedErr {
+				walk(e)
+			}
+		case loader.Errors:
+			for _, e := range typedErr {
+				walk(e)
+			}
+		}
+	}
+
+	walk(err)
+}
+
 // OutputErrors is a list of errors encountered
 // which are to presented.
 type OutputErrors []OutputError
@@ -239,15 +286,36 @@ func (e OutputErrors) Error() string {
 // library errors so that the JSON output given by the
 // presentation package is consistent and parsable.
 type OutputError struct {
-	Message  string      `json:"message"`
-	Code     string      `json:"code,omitempty"`
-	Location interface{} `json:"location,omitempty"`
-	Details  interface{} `json:"details,omitempty"`
-	err      error
-}
-
+	Message   string          `json:"message"`
+	Code      string          `json:"code,omitempty"`
+	Location  interface{}     `json:"location,omitempty"`
+	Details   interface{}     `json:"details,omitempty"`
+	Hints     []string        `json:"hints,omitempty"`
+	Related   []*ast.Location `json:"related_locations,omitempty"`
+	CodeFrame string          `json:"code_frame,omitempty"`
+	err       error
+}
+
+// Error returns the message of the error followed by its code frame, hints
+// and related locations, if any.
 func (j OutputError) Error() string {
-	return j.err.Error()
+	msg := j.err.Error()
+
+	if len(j.CodeFrame) > 0 {
+		for _, line := range strings.Split(j.CodeFrame, "\n") {
+			msg += "\n\t" + line
+		}
+	}
+
+	for _, hint := range j.Hints {
+		msg += "\n\thint: " + hint
+	}
+
+	for _, loc := range j.Related {
+		msg += "\n\tsee: " + loc.String()
+	}
+
+	return msg
 }
 
 // JSON writes x to w with indentation.
@@ -124,6 +124,85 @@ func TestOutputJSONErrorStructuredASTErr(t *testing.T) {
 	validateJSONOutput(t, err, expected)
 }
 
+func TestOutputJSONErrorStructuredASTErrWithHints(t *testing.T) {
+	err := &ast.Error{
+		Code:      "1",
+		Message:   "error message",
+		Hints:     []string{"did you mean foo?"},
+		Related:   []*ast.Location{{File: "b.rego", Row: 2, Col: 1}},
+		CodeFrame: "1 | fooo",
+	}
+	expected := `{
+  "errors": [
+    {
+      "message": "error message",
+      "code": "1",
+      "hints": [
+        "did you mean foo?"
+      ],
+      "related_locations": [
+        {
+          "file": "b.rego",
+          "row": 2,
+          "col": 1
+        }
+      ],
+      "code_frame": "1 | fooo"
+    }
+  ]
+}
+`
+
+	validateJSONOutput(t, err, expected)
+}
+
+func TestOutputErrorStringWithHints(t *testing.T) {
+
+	err := ast.NewError(ast.CompileErr, &ast.Location{Text: []byte("p"), File: "a.rego", Row: 3, Col: 1}, "conflicting rules found")
+	err.CodeFrame = "3 | p = 1\n  | ^"
+	err.Hints = []string{"did you mean q?"}
+	err.Related = []*ast.Location{{File: "b.rego", Row: 7, Col: 1}}
+
+	expected := "1 error occurred: a.rego:3: rego_compile_error: conflicting rules found\n\t3 | p = 1\n\t  | ^\n\thint: did you mean q?\n\tsee: b.rego:7"
+
+	if result := OutputErrors(NewOutputErrors(err)).Error(); result != expected {
+		t.Fatalf("Expected:\n%v\n\nGot:\n%v", expected, result)
+	}
+}
+
+func TestSetCodeFrames(t *testing.T) {
+
+	files := map[string]string{
+		"":       "x := fooo(1)",
+		"a.rego": "package a\n\np { fooo(1) }",
+	}
+
+	read := func(name string) ([]byte, error) {
+		if s, ok := files[name]; ok {
+			return []byte(s), nil
+		}
+		return nil, fmt.Errorf("not found")
+	}
+
+	query := ast.NewError(ast.TypeErr, &ast.Location{Text: []byte("fooo(1)"), Row: 1, Col: 6}, "undefined function fooo")
+	module := ast.NewError(ast.TypeErr, &ast.Location{Text: []byte("fooo(1)"), File: "a.rego", Row: 3, Col: 5}, "undefined function fooo")
+	missing := ast.NewError(ast.TypeErr, &ast.Location{Text: []byte("fooo(1)"), File: "b.rego", Row: 3, Col: 5}, "undefined function fooo")
+
+	SetCodeFrames(rego.Errors{ast.Errors{query, module, missing}}, read)
+
+	if exp := "1 | x := fooo(1)\n  |      ^^^^^^^"; query.CodeFrame != exp {
+		t.Errorf("Expected:\n%v\n\nGot:\n%v", exp, query.CodeFrame)
+	}
+
+	if exp := "3 | p { fooo(1) }\n  |     ^^^^^^^"; module.CodeFrame != exp {
+		t.Errorf("Expected:\n%v\n\nGot:\n%v", exp, module.CodeFrame)
+	}
+
+	if missing.CodeFrame != "" {
+		t.Errorf("Expected no code frame but got:\n%v", missing.CodeFrame)
+	}
+}
+
 func TestOutputJSONErrorStructuredStorageErr(t *testing.T) {
 	store := inmem.New()
 	txn := storage.NewTransactionOrDie(context.Background(), store)
@@ -0,0 +1,284 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Constraint is a set of version ranges, as used by npm:
+// https://github.com/npm/node-semver#ranges
+//
+// A constraint is a disjunction of comparator sets separated by "||". A
+// comparator set is a conjunction of comparators separated by whitespace or
+// commas. Comparators are versions with an optional operator ("=", ">", ">=",
+// "<", "<=", "~" or "^"). Versions may be partial, or have "x", "X" or "*"
+// wildcards in place of their parts. Hyphen ranges ("1.2.3 - 2.3.4") are
+// inclusive.
+type Constraint struct {
+	sets [][]comparator
+}
+
+type comparator struct {
+	op      string
+	version Version
+}
+
+func (c comparator) check(v Version) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	default:
+		return cmp == 0
+	}
+}
+
+// NewConstraint parses a constraint.
+func NewConstraint(constraint string) (*Constraint, error) {
+	var c Constraint
+	for _, set := range strings.Split(constraint, "||") {
+		comparators, err := parseComparatorSet(set)
+		if err != nil {
+			return nil, fmt.Errorf("invalid constraint %q: %v", constraint, err)
+		}
+		c.sets = append(c.sets, comparators)
+	}
+	return &c, nil
+}
+
+// Check returns true if v satisfies the constraint. A pre-release version
+// only satisfies a comparator set if one of its comparators has a
+// pre-release of the same major, minor and patch version.
+func (c *Constraint) Check(v Version) bool {
+	for _, set := range c.sets {
+		if checkSet(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkSet(set []comparator, v Version) bool {
+	for _, c := range set {
+		if !c.check(v) {
+			return false
+		}
+	}
+	if v.PreRelease == "" {
+		return true
+	}
+	for _, c := range set {
+		if c.version.PreRelease != "" && recursiveCompare(c.version.Slice(), v.Slice()) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+var operators = map[string]bool{"=": true, "<": true, "<=": true, ">": true, ">=": true, "~": true, "^": true}
+
+func parseComparatorSet(set string) ([]comparator, error) {
+	// Operators may be separated from their versions by whitespace.
+	var terms []string
+	for _, field := range strings.Fields(strings.ReplaceAll(set, ",", " ")) {
+		if n := len(terms); n > 0 && operators[terms[n-1]] {
+			terms[n-1] += field
+		} else {
+			terms = append(terms, field)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("empty range")
+	}
+
+	comparators := []comparator{}
+	for i := 0; i < len(terms); i++ {
+		if i+1 < len(terms) && terms[i+1] == "-" {
+			if i+2 >= len(terms) {
+				return nil, fmt.Errorf("incomplete hyphen range")
+			}
+			cs, err := hyphenRange(terms[i], terms[i+2])
+			if err != nil {
+				return nil, err
+			}
+			comparators = append(comparators, cs...)
+			i += 2
+			continue
+		}
+		cs, err := parseComparator(terms[i])
+		if err != nil {
+			return nil, err
+		}
+		comparators = append(comparators, cs...)
+	}
+	return comparators, nil
+}
+
+// parseComparator parses a comparator, and desugars it into primitive
+// comparators.
+func parseComparator(s string) ([]comparator, error) {
+	op := s[:len(s)-len(strings.TrimLeft(s, "=<>~^"))]
+	if !operators[op] && op != "" {
+		return nil, fmt.Errorf("invalid operator %q", op)
+	}
+	p, err := parsePartial(s[len(op):])
+	if err != nil {
+		return nil, err
+	}
+
+	if p.parts == 0 {
+		if op == "<" || op == ">" {
+			// Nothing is lower than 0.0.0-0, or higher than any version.
+			return []comparator{{"<", Version{PreRelease: "0"}}}, nil
+		}
+		return nil, nil
+	}
+
+	lower := p.version
+	switch op {
+	case "", "=":
+		if p.parts == 3 {
+			return []comparator{{"=", lower}}, nil
+		}
+		return []comparator{{">=", lower}, {"<", p.bump(p.parts)}}, nil
+	case "~":
+		// Patch level changes are allowed, or minor level changes if only the
+		// major version is given.
+		parts := p.parts
+		if parts > 2 {
+			parts = 2
+		}
+		return []comparator{{">=", lower}, {"<", p.bump(parts)}}, nil
+	case "^":
+		// The first non-zero part, or the last given part, must not change.
+		parts := p.parts
+		if lower.Major > 0 || parts == 1 {
+			parts = 1
+		} else if lower.Minor > 0 || parts == 2 {
+			parts = 2
+		}
+		return []comparator{{">=", lower}, {"<", p.bump(parts)}}, nil
+	case ">":
+		if p.parts == 3 {
+			return []comparator{{">", lower}}, nil
+		}
+		return []comparator{{">=", p.bump(p.parts).release()}}, nil
+	case ">=":
+		return []comparator{{">=", lower}}, nil
+	case "<":
+		if p.parts == 3 {
+			return []comparator{{"<", lower}}, nil
+		}
+		return []comparator{{"<", lower.withPreRelease("0")}}, nil
+	default: // "<="
+		if p.parts == 3 {
+			return []comparator{{"<=", lower}}, nil
+		}
+		return []comparator{{"<", p.bump(p.parts)}}, nil
+	}
+}
+
+// hyphenRange returns the comparators of the inclusive range from a to b.
+func hyphenRange(a, b string) ([]comparator, error) {
+	from, err := parsePartial(a)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parsePartial(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var comparators []comparator
+	if from.parts > 0 {
+		comparators = append(comparators, comparator{">=", from.version})
+	}
+	switch {
+	case to.parts == 3:
+		comparators = append(comparators, comparator{"<=", to.version})
+	case to.parts > 0:
+		comparators = append(comparators, comparator{"<", to.bump(to.parts)})
+	}
+	return comparators, nil
+}
+
+// partial is a version of which only the first parts are given. The other
+// parts are zero.
+type partial struct {
+	version Version
+	parts   int
+}
+
+func parsePartial(s string) (partial, error) {
+	var p partial
+	version := s
+	metadata := splitOff(&version, "+")
+	preRelease := splitOff(&version, "-")
+	if err := validateIdentifier(preRelease); err != nil {
+		return p, err
+	}
+	if err := validateIdentifier(metadata); err != nil {
+		return p, err
+	}
+
+	dotParts := strings.Split(version, ".")
+	if len(dotParts) > 3 {
+		return p, fmt.Errorf("%s is not a valid version", s)
+	}
+	values := []*int64{&p.version.Major, &p.version.Minor, &p.version.Patch}
+	for i, part := range dotParts {
+		if part == "x" || part == "X" || part == "*" {
+			break
+		}
+		val, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || val < 0 {
+			return p, fmt.Errorf("%s is not a valid version", s)
+		}
+		*values[i] = val
+		p.parts++
+	}
+
+	if preRelease != "" && p.parts < 3 {
+		return p, fmt.Errorf("%s is not a valid version: pre-release requires a patch version", s)
+	}
+	p.version.PreRelease = PreRelease(preRelease)
+	p.version.Metadata = metadata
+	return p, nil
+}
+
+// bump returns the lowest pre-release of the version following p, when
+// incrementing the given part (1 for major, 2 for minor, 3 for patch).
+func (p partial) bump(part int) Version {
+	v := Version{Major: p.version.Major}
+	switch part {
+	case 1:
+		v.Major++
+	case 2:
+		v.Minor = p.version.Minor + 1
+	default:
+		v.Minor = p.version.Minor
+		v.Patch = p.version.Patch + 1
+	}
+	return v.withPreRelease("0")
+}
+
+func (v Version) withPreRelease(preRelease PreRelease) Version {
+	v.PreRelease = preRelease
+	v.Metadata = ""
+	return v
+}
+
+func (v Version) release() Version {
+	return v.withPreRelease("")
+}
@@ -0,0 +1,92 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package semver
+
+import (
+	"testing"
+)
+
+func TestConstraintCheck(t *testing.T) {
+	tests := []struct {
+		constraint  string
+		satisfied   []string
+		unsatisfied []string
+	}{
+		{"1.2.3", []string{"1.2.3", "1.2.3+build"}, []string{"1.2.4", "1.2.3-beta"}},
+		{"=1.2.3", []string{"1.2.3"}, []string{"1.2.2"}},
+		{">1.2.3", []string{"1.2.4", "2.0.0"}, []string{"1.2.3", "1.3.0-beta"}},
+		{">= 1.2, < 2.0", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0", "2.0.0-0"}},
+		{">=1.2 <2.0 || ~3.1", []string{"1.5.0", "3.1.0", "3.1.9"}, []string{"2.5.0", "3.2.0", "3.0.9"}},
+		{"<=1.2", []string{"1.2.9", "0.0.0"}, []string{"1.3.0"}},
+		{"<1.2", []string{"1.1.9"}, []string{"1.2.0"}},
+		{">1", []string{"2.0.0"}, []string{"1.9.9"}},
+		{"~1", []string{"1.0.0", "1.9.9"}, []string{"2.0.0", "0.9.9"}},
+		{"~1.2", []string{"1.2.0", "1.2.9"}, []string{"1.3.0"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.2.2", "1.3.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0", "2.0.0-beta"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"^0.0", []string{"0.0.0", "0.0.9"}, []string{"0.1.0"}},
+		{"^0", []string{"0.0.0", "0.9.0"}, []string{"1.0.0"}},
+		{"^1.x", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"1.2.x", []string{"1.2.0", "1.2.9"}, []string{"1.3.0"}},
+		{"1.X", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"*", []string{"0.0.0", "99.0.0"}, []string{"1.0.0-beta"}},
+		{"<*", nil, []string{"0.0.0", "1.0.0"}},
+		{"1.2.3 - 2.3.4", []string{"1.2.3", "2.3.4"}, []string{"1.2.2", "2.3.5"}},
+		{"1.2 - 2.3", []string{"1.2.0", "2.3.9"}, []string{"1.1.9", "2.4.0"}},
+		{"* - 2", []string{"0.0.0", "2.9.9"}, []string{"3.0.0"}},
+		{">=1.2.3-beta.2 <1.3", []string{"1.2.3-beta.2", "1.2.3-rc.1", "1.2.3", "1.2.9"}, []string{"1.2.3-beta.1", "1.2.4-rc.1"}},
+		{"^1.2.3-beta", []string{"1.2.3-beta", "1.2.3", "1.5.0"}, []string{"1.5.0-beta"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.constraint, func(t *testing.T) {
+			c, err := NewConstraint(tc.constraint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tc.satisfied {
+				if !c.Check(*mustVersion(t, s)) {
+					t.Errorf("expected %s to satisfy %s", s, tc.constraint)
+				}
+			}
+			for _, s := range tc.unsatisfied {
+				if c.Check(*mustVersion(t, s)) {
+					t.Errorf("expected %s not to satisfy %s", s, tc.constraint)
+				}
+			}
+		})
+	}
+}
+
+func TestConstraintBadInput(t *testing.T) {
+	bad := []string{
+		"",
+		"1.2.3 ||",
+		">=",
+		"=>1.2.3",
+		"~>1.2",
+		"1.2.3.4",
+		"1.2-beta",
+		"a.b.c",
+		"1.2.3 -",
+		"1.2.3-beta..1",
+	}
+	for _, s := range bad {
+		if _, err := NewConstraint(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func mustVersion(t *testing.T, s string) *Version {
+	t.Helper()
+	v, err := NewVersion(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
@@ -352,7 +352,7 @@ func (p *Plugin) loadAndActivateBundlesFromDisk(ctx context.Context) {
 
 	for name, src := range p.config.Bundles {
 		if p.persistBundle(name) {
-			b, err := loadBundleFromDisk(p.bundlePersistPath, name, src)
+			b, err := loadBundleFromDisk(p.bundlePersistPath, name, src, p.manager.ParserOptions().ProcessAnnotation)
 			if err != nil {
 				p.log(name).Error("Failed to load bundle from disk: %v", err)
 				p.status[name].SetError(err)
@@ -406,11 +406,12 @@ func (p *Plugin) newDownloader(name string, source *Source) Loader {
 		switch u.Scheme {
 		case "file":
 			return &fileLoader{
-				name:           name,
-				path:           u.Path,
-				bvc:            source.Signing,
-				sizeLimitBytes: source.SizeLimitBytes,
-				f:              p.oneShot,
+				name:               name,
+				path:               u.Path,
+				bvc:                source.Signing,
+				sizeLimitBytes:     source.SizeLimitBytes,
+				processAnnotations: p.manager.ParserOptions().ProcessAnnotation,
+				f:                  p.oneShot,
 			}
 		}
 	}
@@ -431,13 +432,15 @@ func (p *Plugin) newDownloader(name string, source *Source) Loader {
 			WithCallback(callback).
 			WithBundleVerificationConfig(source.Signing).
 			WithSizeLimitBytes(source.SizeLimitBytes).
-			WithBundlePersistence(p.persistBundle(name))
+			WithBundlePersistence(p.persistBundle(name)).
+			WithProcessAnnotations(p.manager.ParserOptions().ProcessAnnotation)
 	}
 	return download.New(conf, client, path).
 		WithCallback(callback).
 		WithBundleVerificationConfig(source.Signing).
 		WithSizeLimitBytes(source.SizeLimitBytes).
 		WithBundlePersistence(p.persistBundle(name)).
+		WithProcessAnnotations(p.manager.ParserOptions().ProcessAnnotation).
 		WithLazyLoadingMode(true).WithBundleName(name)
 }
 
@@ -580,7 +583,7 @@ func (p *Plugin) activate(ctx context.Context, name string, b *bundle.Bundle) er
 		}
 
 		if compiler == nil {
-			compiler = ast.NewCompiler()
+			compiler = ast.NewCompiler().WithIncremental(p.manager.GetCompiler())
 		}
 
 		compiler = compiler.WithPathConflictsCheck(storage.NonEmpty(ctx, p.manager.Store, txn)).
@@ -696,7 +699,7 @@ func saveCurrentBundleToDisk(path string, raw io.Reader) (string, error) {
 	return dest.Name(), err
 }
 
-func loadBundleFromDisk(path, name string, src *Source) (*bundle.Bundle, error) {
+func loadBundleFromDisk(path, name string, src *Source, processAnnotations bool) (*bundle.Bundle, error) {
 	bundlePath := filepath.Join(path, name, "bundle.tar.gz")
 
 	if _, err := os.Stat(bundlePath); err == nil {
@@ -706,7 +709,7 @@ func loadBundleFromDisk(path, name string, src *Source) (*bundle.Bundle, error)
 		}
 		defer f.Close()
 
-		r := bundle.NewReader(f)
+		r := bundle.NewReader(f).WithProcessAnnotations(processAnnotations)
 
 		if src != nil {
 			r = r.WithBundleVerificationConfig(src.Signing)
@@ -741,11 +744,12 @@ func (p *Plugin) getBundlePersistPath() (string, error) {
 }
 
 type fileLoader struct {
-	name           string
-	path           string
-	bvc            *bundle.VerificationConfig
-	sizeLimitBytes int64
-	f              func(context.Context, string, download.Update)
+	name               string
+	path               string
+	bvc                *bundle.VerificationConfig
+	sizeLimitBytes     int64
+	processAnnotations bool
+	f                  func(context.Context, string, download.Update)
 }
 
 func (fl *fileLoader) Start(ctx context.Context) {
@@ -799,6 +803,7 @@ func (fl *fileLoader) oneShot(ctx context.Context) {
 
 	b, err := reader.
 		WithMetrics(u.Metrics).
+		WithProcessAnnotations(fl.processAnnotations).
 		WithBundleVerificationConfig(fl.bvc).
 		WithSizeLimitBytes(fl.sizeLimitBytes).Read()
 	u.Error = err
@@ -590,7 +590,7 @@ func TestPluginOneShotBundlePersistence(t *testing.T) {
 
 	ensurePluginState(t, plugin, plugins.StateOK)
 
-	result, err := loadBundleFromDisk(plugin.bundlePersistPath, bundleName, nil)
+	result, err := loadBundleFromDisk(plugin.bundlePersistPath, bundleName, nil, false)
 	if err != nil {
 		t.Fatal("unexpected error:", err)
 	}
@@ -692,7 +692,7 @@ func TestPluginOneShotSignedBundlePersistence(t *testing.T) {
 	ensurePluginState(t, plugin, plugins.StateOK)
 
 	// load signed bundle from disk
-	result, err := loadBundleFromDisk(plugin.bundlePersistPath, bundleName, bundles[bundleName])
+	result, err := loadBundleFromDisk(plugin.bundlePersistPath, bundleName, bundles[bundleName], false)
 	if err != nil {
 		t.Fatal("unexpected error:", err)
 	}
@@ -2485,7 +2485,7 @@ func TestSaveBundleToDiskOverWrite(t *testing.T) {
 		t.Fatalf("unexpected error %v", err)
 	}
 
-	actual, err := loadBundleFromDisk(plugin.bundlePersistPath, "foo", nil)
+	actual, err := loadBundleFromDisk(plugin.bundlePersistPath, "foo", nil, false)
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
@@ -2521,7 +2521,7 @@ func TestSaveCurrentBundleToDisk(t *testing.T) {
 func TestLoadBundleFromDisk(t *testing.T) {
 
 	// no bundle on disk
-	_, err := loadBundleFromDisk("foo", "bar", nil)
+	_, err := loadBundleFromDisk("foo", "bar", nil, false)
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
@@ -2539,7 +2539,7 @@ func TestLoadBundleFromDisk(t *testing.T) {
 
 	b := writeTestBundleToDisk(t, bundleDir, false)
 
-	result, err := loadBundleFromDisk(dir, bundleName, nil)
+	result, err := loadBundleFromDisk(dir, bundleName, nil, false)
 	if err != nil {
 		t.Fatal("unexpected error:", err)
 	}
@@ -2552,7 +2552,7 @@ func TestLoadBundleFromDisk(t *testing.T) {
 func TestLoadSignedBundleFromDisk(t *testing.T) {
 
 	// no bundle on disk
-	_, err := loadBundleFromDisk("foo", "bar", nil)
+	_, err := loadBundleFromDisk("foo", "bar", nil, false)
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
@@ -2574,7 +2574,7 @@ func TestLoadSignedBundleFromDisk(t *testing.T) {
 		Signing: bundle.NewVerificationConfig(map[string]*keys.Config{"foo": {Key: "secret", Algorithm: "HS256"}}, "foo", "", nil),
 	}
 
-	result, err := loadBundleFromDisk(dir, bundleName, &src)
+	result, err := loadBundleFromDisk(dir, bundleName, &src, false)
 	if err != nil {
 		t.Fatal("unexpected error:", err)
 	}
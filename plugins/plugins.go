@@ -485,6 +485,32 @@ func (m *Manager) InterQueryBuiltinCacheConfig() *cache.Config {
 	return m.interQueryBuiltinCacheConfig
 }
 
+// ParserOptions returns the options for parsing policies. Annotations are only
+// processed if the rule cache is enabled, which relies on them.
+func (m *Manager) ParserOptions() ast.ParserOptions {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return parserOptions(m.interQueryBuiltinCacheConfig)
+}
+
+// ParserOptionsFromConfig returns the options for parsing the policies of an
+// OPA instance with the configuration raw (see Manager.ParserOptions).
+func ParserOptionsFromConfig(raw []byte, id string) (ast.ParserOptions, error) {
+	parsedConfig, err := config.ParseConfig(raw, id)
+	if err != nil {
+		return ast.ParserOptions{}, err
+	}
+	c, err := cache.ParseCachingConfig(parsedConfig.Caching)
+	if err != nil {
+		return ast.ParserOptions{}, err
+	}
+	return parserOptions(c), nil
+}
+
+func parserOptions(c *cache.Config) ast.ParserOptions {
+	return ast.ParserOptions{ProcessAnnotation: c != nil && c.RuleCache.Enabled}
+}
+
 // Register adds a plugin to the manager. When the manager is started, all of
 // the plugins will be started.
 func (m *Manager) Register(name string, plugin Plugin) {
@@ -764,7 +790,7 @@ func (m *Manager) onCommit(ctx context.Context, txn storage.Transaction, event s
 	// compiler on the context but the server does not (nor would users
 	// implementing their own policy loading.)
 	if compiler == nil && event.PolicyChanged() {
-		compiler, _ = loadCompilerFromStore(ctx, m.Store, txn, m.enablePrintStatements)
+		compiler, _ = loadCompilerFromStore(ctx, m.Store, txn, m.ParserOptions(), m.enablePrintStatements, m.GetCompiler())
 	}
 
 	if compiler != nil {
@@ -796,7 +822,7 @@ func (m *Manager) onCommit(ctx context.Context, txn storage.Transaction, event s
 	}
 }
 
-func loadCompilerFromStore(ctx context.Context, store storage.Store, txn storage.Transaction, enablePrintStatements bool) (*ast.Compiler, error) {
+func loadCompilerFromStore(ctx context.Context, store storage.Store, txn storage.Transaction, parserOpts ast.ParserOptions, enablePrintStatements bool, prev *ast.Compiler) (*ast.Compiler, error) {
 	policies, err := store.ListPolicies(ctx, txn)
 	if err != nil {
 		return nil, err
@@ -808,14 +834,18 @@ func loadCompilerFromStore(ctx context.Context, store storage.Store, txn storage
 		if err != nil {
 			return nil, err
 		}
-		module, err := ast.ParseModule(policy, string(bs))
+		module, err := ast.ParseModuleWithOpts(policy, string(bs), parserOpts)
 		if err != nil {
 			return nil, err
 		}
 		modules[policy] = module
 	}
 
-	compiler := ast.NewCompiler().WithEnablePrintStatements(enablePrintStatements)
+	// Modules that have not changed since the previous compilation (and their
+	// dependents) do not have to be compiled again.
+	compiler := ast.NewCompiler().
+		WithEnablePrintStatements(enablePrintStatements).
+		WithIncremental(prev)
 	compiler.Compile(modules)
 	return compiler, nil
 }
@@ -16,6 +16,7 @@ import (
 	"github.com/open-policy-agent/opa/logging"
 	"github.com/open-policy-agent/opa/logging/test"
 	"github.com/open-policy-agent/opa/plugins/rest"
+	"github.com/open-policy-agent/opa/storage"
 	inmem "github.com/open-policy-agent/opa/storage/inmem/test"
 	"github.com/open-policy-agent/opa/topdown/cache"
 	prom "github.com/prometheus/client_golang/prometheus"
@@ -295,6 +296,32 @@ func TestManagerWithCachingConfig(t *testing.T) {
 	}
 }
 
+func TestManagerParserOptions(t *testing.T) {
+	for _, tc := range []struct {
+		config      string
+		annotations bool
+	}{
+		{config: ``},
+		{config: `{"caching": {"rule_cache": {"enabled": false}}}`},
+		{config: `{"caching": {"rule_cache": {"enabled": true}}}`, annotations: true},
+	} {
+		m, err := New([]byte(tc.config), "test", inmem.New())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act := m.ParserOptions().ProcessAnnotation; act != tc.annotations {
+			t.Errorf("%v: expected annotations to be processed: %v but got %v", tc.config, tc.annotations, act)
+		}
+		opts, err := ParserOptionsFromConfig([]byte(tc.config), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.ProcessAnnotation != tc.annotations {
+			t.Errorf("%v: expected annotations to be processed: %v but got %v", tc.config, tc.annotations, opts.ProcessAnnotation)
+		}
+	}
+}
+
 func TestManagerWithNDCachingConfig(t *testing.T) {
 	m, err := New([]byte(`{"nd_builtin_cache": true}`), "test", inmem.New())
 	if err != nil {
@@ -461,6 +488,45 @@ func TestPluginManagerServerInitialized(t *testing.T) {
 	}
 }
 
+func TestManagerIncrementalCompileOnCommit(t *testing.T) {
+	ctx := context.Background()
+	m, err := New([]byte{}, "test", inmem.New())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if err := m.Init(ctx); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	upsert := func(id, module string) {
+		t.Helper()
+		err := storage.Txn(ctx, m.Store, storage.WriteParams, func(txn storage.Transaction) error {
+			return m.Store.UpsertPolicy(ctx, txn, id, []byte(module))
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	upsert("a.rego", "package a\np := 1")
+	upsert("b.rego", "package b\nq := 2")
+
+	before := m.GetCompiler()
+
+	upsert("a.rego", "package a\np := 3")
+
+	after := m.GetCompiler()
+
+	if after.Modules["b.rego"] != before.Modules["b.rego"] {
+		t.Error("expected unchanged module to be reused")
+	}
+
+	if after.Modules["a.rego"] == before.Modules["a.rego"] {
+		t.Error("expected changed module to be recompiled")
+	}
+}
+
 type myAuthPluginMock struct{}
 
 func (m *myAuthPluginMock) NewClient(c rest.Config) (*http.Client, error) {
@@ -2,6 +2,9 @@ package status
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/topdown/cache"
 )
 
 var (
@@ -53,3 +56,26 @@ var (
 		Buckets: prometheus.ExponentialBuckets(1000, 2, 20),
 	}, []string{"name", "stage"})
 )
+
+// interQueryCacheCounters returns collectors exposing the inter-query builtin
+// cache counters recorded on m.
+func interQueryCacheCounters(m metrics.Metrics) []prometheus.Collector {
+	counters := []struct{ name, help string }{
+		{cache.InterQueryCacheHits, "Counter for the inter-query builtin cache hits."},
+		{cache.InterQueryCacheMisses, "Counter for the inter-query builtin cache misses."},
+		{cache.InterQueryCacheEvictions, "Counter for the inter-query builtin cache evictions."},
+		{cache.InterQueryCacheBackendErrors, "Counter for the failed inter-query builtin cache backend operations."},
+	}
+	cs := make([]prometheus.Collector, 0, len(counters))
+	for _, c := range counters {
+		name := c.name
+		cs = append(cs, prometheus.NewCounterFunc(
+			prometheus.CounterOpts{Name: name + "_counter", Help: c.help},
+			func() float64 {
+				v, _ := m.Counter(name).Value().(uint64)
+				return float64(v)
+			},
+		))
+	}
+	return cs
+}
@@ -236,6 +236,9 @@ func (p *Plugin) Start(ctx context.Context) error {
 		p.register(p.manager.PrometheusRegister(), pluginStatus, loaded, failLoad,
 			lastRequest, lastSuccessfulActivation, lastSuccessfulDownload,
 			lastSuccessfulRequest, bundleLoadDuration)
+		if p.metrics != nil {
+			p.register(p.manager.PrometheusRegister(), interQueryCacheCounters(p.metrics)...)
+		}
 	}
 
 	// Set the status plugin's status to OK now that everything is registered and
@@ -16,11 +16,14 @@ import (
 	"time"
 
 	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
+	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/plugins"
 	"github.com/open-policy-agent/opa/plugins/bundle"
 	inmem "github.com/open-policy-agent/opa/storage/inmem/test"
+	"github.com/open-policy-agent/opa/topdown/cache"
 	"github.com/open-policy-agent/opa/util"
 	"github.com/open-policy-agent/opa/version"
 )
@@ -588,6 +591,63 @@ func TestMetrics(t *testing.T) {
 	}
 }
 
+func TestPluginPrometheusInterQueryCache(t *testing.T) {
+	m := metrics.New()
+	fixture := newTestFixture(t, m, func(c *Config) {
+		c.Prometheus = true
+	})
+	fixture.server.ch = make(chan UpdateRequestV1)
+	defer fixture.server.stop()
+
+	ctx := context.Background()
+
+	err := fixture.plugin.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fixture.plugin.Stop(ctx)
+	<-fixture.server.ch
+
+	c := cache.NewInterQueryCache(nil, cache.WithMetrics(m))
+	c.Insert(ast.String("foo"), testCacheValue{})
+	c.Get(ast.String("foo"))
+	c.Get(ast.String("foo"))
+	c.Get(ast.String("bar"))
+
+	exp := map[string]float64{
+		cache.InterQueryCacheHits + "_counter":          2,
+		cache.InterQueryCacheMisses + "_counter":        1,
+		cache.InterQueryCacheEvictions + "_counter":     0,
+		cache.InterQueryCacheBackendErrors + "_counter": 0,
+	}
+
+	registerMock := fixture.manager.PrometheusRegister().(*prometheusRegisterMock)
+	found := 0
+	for collector := range registerMock.Collectors {
+		ch := make(chan *prom.Desc, 1)
+		collector.Describe(ch)
+		desc := (<-ch).String()
+		for name, value := range exp {
+			if strings.Contains(desc, `fqName: "`+name+`"`) {
+				found++
+				if v := testutil.ToFloat64(collector); v != value {
+					t.Errorf("Expected %v to be %v but got %v", name, value, v)
+				}
+			}
+		}
+	}
+
+	if found != len(exp) {
+		t.Fatalf("Expected %d inter-query cache collectors but found %d", len(exp), found)
+	}
+}
+
+type testCacheValue struct{}
+
+func (testCacheValue) SizeInBytes() int64 {
+	return 1
+}
+
 func TestParseConfigUseDefaultServiceNoConsole(t *testing.T) {
 	services := []string{
 		"s0",
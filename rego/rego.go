@@ -31,6 +31,7 @@ import (
 	"github.com/open-policy-agent/opa/topdown"
 	"github.com/open-policy-agent/opa/topdown/builtins"
 	"github.com/open-policy-agent/opa/topdown/cache"
+	"github.com/open-policy-agent/opa/topdown/httpfixtures"
 	"github.com/open-policy-agent/opa/topdown/print"
 	"github.com/open-policy-agent/opa/tracing"
 	"github.com/open-policy-agent/opa/types"
@@ -122,6 +123,7 @@ type EvalContext struct {
 	copyMaps               bool
 	printHook              print.Hook
 	capabilities           *ast.Capabilities
+	limits                 topdown.Limits
 }
 
 // EvalOption defines a function to set an option on an EvalConfig
@@ -264,6 +266,14 @@ func EvalNDBuiltinCache(c builtins.NDBCache) EvalOption {
 	}
 }
 
+// EvalLimits sets the limits on the resources used by this evaluation,
+// overriding the limits set with Limits.
+func EvalLimits(l topdown.Limits) EvalOption {
+	return func(e *EvalContext) {
+		e.limits = l
+	}
+}
+
 // EvalResolver sets a Resolver for a specified ref path for this evaluation.
 func EvalResolver(ref ast.Ref, r resolver.Resolver) EvalOption {
 	return func(e *EvalContext) {
@@ -331,6 +341,7 @@ func (pq preparedQuery) newEvalContext(ctx context.Context, options []EvalOption
 		resolvers:        pq.r.resolvers,
 		printHook:        pq.r.printHook,
 		capabilities:     pq.r.capabilities,
+		limits:           pq.r.limits,
 	}
 
 	for _, o := range options {
@@ -411,6 +422,23 @@ func (pq PreparedEvalQuery) Eval(ctx context.Context, options ...EvalOption) (Re
 	return pq.r.eval(ctx, ectx)
 }
 
+// Iter evaluates the prepared query like Eval, but calls iter
+// with each result as soon as it is produced instead of returning a ResultSet.
+// The evaluation is suspended while iter runs and stops if iter returns an
+// error, which is then returned by Iter. This allows callers to process large
+// numbers of results without holding all of them in memory.
+func (pq PreparedEvalQuery) Iter(ctx context.Context, iter func(Result) error, options ...EvalOption) error {
+	ectx, finish, err := pq.newEvalContext(ctx, options)
+	if err != nil {
+		return err
+	}
+	defer finish(ctx)
+
+	ectx.compiledQuery = pq.r.compiledQueries[evalQueryType]
+
+	return pq.r.iter(ctx, ectx, iter)
+}
+
 // PreparedPartialQuery holds the prepared Rego state that has been pre-processed
 // for partial evaluations.
 type PreparedPartialQuery struct {
@@ -538,6 +566,11 @@ type Rego struct {
 	enablePrintStatements  bool
 	distributedTacingOpts  tracing.Options
 	strict                 bool
+	constantFolding        bool
+	parallelism            int
+	limits                 topdown.Limits
+	httpSendFixtures       *httpfixtures.Store
+	ruleCache              *topdown.RuleCache
 }
 
 // Function represents a built-in function that is callable in Rego.
@@ -1063,6 +1096,42 @@ func BuiltinErrorList(list *[]topdown.Error) func(r *Rego) {
 	}
 }
 
+// Limits sets the limits on the resources used by evaluations, e.g., the
+// maximum number of evaluation steps. See topdown.Limits for details.
+func Limits(l topdown.Limits) func(r *Rego) {
+	return func(r *Rego) {
+		r.limits = l
+	}
+}
+
+// HTTPSendFixtures sets the recorded request/response pairs that http.send
+// serves instead of sending requests over the network.
+func HTTPSendFixtures(s *httpfixtures.Store) func(r *Rego) {
+	return func(r *Rego) {
+		r.httpSendFixtures = s
+	}
+}
+
+// RuleCache sets the cache holding the values of rules annotated with
+// 'cache: true' across evaluations. The cache must be registered as a
+// trigger on the store so that cached values are invalidated when data
+// changes (see topdown.RuleCache).
+func RuleCache(c *topdown.RuleCache) func(r *Rego) {
+	return func(r *Rego) {
+		r.ruleCache = c
+	}
+}
+
+// Parallelism sets the maximum number of goroutines that evaluate a query.
+// If n is greater than one, the definitions of partial rules and the
+// iterations of comprehensions are evaluated in parallel. Results do not
+// depend on n. See topdown.Query.WithParallelism for details.
+func Parallelism(n int) func(r *Rego) {
+	return func(r *Rego) {
+		r.parallelism = n
+	}
+}
+
 // Resolver sets a Resolver for a specified ref path.
 func Resolver(ref ast.Ref, r resolver.Resolver) func(r *Rego) {
 	return func(rego *Rego) {
@@ -1131,6 +1200,19 @@ func Strict(yes bool) func(r *Rego) {
 	}
 }
 
+// ConstantFolding enables or disables constant folding in the compiler. When
+// enabled, calls to deterministic built-in functions with constant operands,
+// references to rules that define constant values and comprehensions that do
+// not depend on input or data are evaluated at compile-time. This option only
+// applies to queries and policies that passed as raw strings, i.e., this
+// function will not have any affect if the caller supplies the ast.Compiler
+// instance.
+func ConstantFolding(yes bool) func(r *Rego) {
+	return func(r *Rego) {
+		r.constantFolding = yes
+	}
+}
+
 // New returns a new Rego object.
 func New(options ...func(r *Rego)) *Rego {
 
@@ -1157,6 +1239,10 @@ func New(options ...func(r *Rego)) *Rego {
 			WithEnablePrintStatements(r.enablePrintStatements).
 			WithStrict(r.strict).
 			WithUseTypeCheckAnnotations(r.schemaSet != nil)
+
+		if r.constantFolding {
+			r.compiler.WithConstantFolding(topdown.NewConstantEvaluator(context.Background(), r.builtinFuncs))
+		}
 	}
 
 	if r.store == nil {
@@ -1952,8 +2038,35 @@ func (r *Rego) compileQuery(query ast.Body, imports []*ast.Import, m metrics.Met
 }
 
 func (r *Rego) eval(ctx context.Context, ectx *EvalContext) (ResultSet, error) {
+	var rs ResultSet
+	err := r.iter(ctx, ectx, func(result Result) error {
+		rs = append(rs, result)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rs) == 0 {
+		return nil, nil
+	}
+
+	return rs, nil
+}
+
+func (r *Rego) iter(ctx context.Context, ectx *EvalContext, iter func(Result) error) error {
 	if r.opa != nil {
-		return r.evalWasm(ctx, ectx)
+		rs, err := r.evalWasm(ctx, ectx)
+		if err != nil {
+			return err
+		}
+		for _, result := range rs {
+			if err := iter(result); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 
 	q := topdown.NewQuery(ectx.compiledQuery.query).
@@ -1972,7 +2085,11 @@ func (r *Rego) eval(ctx context.Context, ectx *EvalContext) (ResultSet, error) {
 		WithBuiltinErrorList(r.builtinErrorList).
 		WithSeed(ectx.seed).
 		WithPrintHook(ectx.printHook).
-		WithDistributedTracingOpts(r.distributedTacingOpts)
+		WithDistributedTracingOpts(r.distributedTacingOpts).
+		WithParallelism(r.parallelism).
+		WithLimits(ectx.limits).
+		WithHTTPSendFixtures(r.httpSendFixtures).
+		WithRuleCache(r.ruleCache)
 
 	if !ectx.time.IsZero() {
 		q = q.WithTime(ectx.time)
@@ -2003,25 +2120,13 @@ func (r *Rego) eval(ctx context.Context, ectx *EvalContext) (ResultSet, error) {
 		c.Cancel()
 	})
 
-	var rs ResultSet
-	err := q.Iter(ctx, func(qr topdown.QueryResult) error {
+	return q.Iter(ctx, func(qr topdown.QueryResult) error {
 		result, err := r.generateResult(qr, ectx)
 		if err != nil {
 			return err
 		}
-		rs = append(rs, result)
-		return nil
+		return iter(result)
 	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	if len(rs) == 0 {
-		return nil, nil
-	}
-
-	return rs, nil
 }
 
 func (r *Rego) evalWasm(ctx context.Context, ectx *EvalContext) (ResultSet, error) {
@@ -2251,7 +2356,9 @@ func (r *Rego) partial(ctx context.Context, ectx *EvalContext) (*PartialQueries,
 		WithInterQueryBuiltinCache(ectx.interQueryBuiltinCache).
 		WithStrictBuiltinErrors(r.strictBuiltinErrors).
 		WithSeed(ectx.seed).
-		WithPrintHook(ectx.printHook)
+		WithPrintHook(ectx.printHook).
+		WithLimits(ectx.limits).
+		WithHTTPSendFixtures(r.httpSendFixtures)
 
 	if !ectx.time.IsZero() {
 		q = q.WithTime(ectx.time)
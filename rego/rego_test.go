@@ -737,6 +737,47 @@ func TestPrepareAndEvalNewInput(t *testing.T) {
 	}, "[[1]]")
 }
 
+func TestPrepareAndIter(t *testing.T) {
+	ctx := context.Background()
+
+	module := `
+	package test
+	s[x] { numbers.range(1, 5)[_] = x; x > input.min }
+	`
+
+	pq, err := New(
+		Query("data.test.s[x]"),
+		Module("", module),
+	).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	var xs []interface{}
+	err = pq.Iter(ctx, func(r Result) error {
+		xs = append(xs, r.Bindings["x"])
+		return nil
+	}, EvalInput(map[string]int{"min": 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := []interface{}{json.Number("3"), json.Number("4"), json.Number("5")}; !reflect.DeepEqual(xs, exp) {
+		t.Fatalf("expected %v but got %v", exp, xs)
+	}
+
+	// Returning an error from the iterator stops the evaluation.
+	stop := errors.New("stop")
+	calls := 0
+	err = pq.Iter(ctx, func(Result) error {
+		calls++
+		return stop
+	}, EvalInput(map[string]int{"min": 0}))
+	if err != stop || calls != 1 {
+		t.Fatalf("expected evaluation to stop after one result but got %d calls and error: %v", calls, err)
+	}
+}
+
 func TestPrepareAndEvalNewMetrics(t *testing.T) {
 	module := `
 	package test
@@ -2223,6 +2264,11 @@ func TestNDBCacheMarshalUnmarshalJSON(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Check that the cached value can be looked up after the roundtrip.
+	if _, ok := other.Get("time.now_ns", ast.NewArray()); !ok {
+		t.Fatalf("expected cached value to be found in %v", other)
+	}
+
 	jOther, err := json.Marshal(other)
 	if err != nil {
 		t.Fatal(err)
@@ -2253,6 +2299,114 @@ func TestStrictBuiltinErrors(t *testing.T) {
 	}
 }
 
+func TestParallelism(t *testing.T) {
+	ctx := context.Background()
+
+	module := `package test
+
+s[x] { x := [y | y := numbers.range(1, 5)[_]; y % 2 == 1] }
+s[x] { x := [y | y := numbers.range(1, 5)[_]; y % 2 == 0] }
+s[x] { x := count(input.xs) }`
+
+	var exp interface{}
+	for _, n := range []int{1, 4} {
+		rs, err := New(
+			Query("data.test.s"),
+			Module("test.rego", module),
+			Input(map[string]interface{}{"xs": []int{1, 2, 3}}),
+			Parallelism(n),
+		).Eval(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		act := rs[0].Expressions[0].Value
+		if n == 1 {
+			exp = act
+		} else if !reflect.DeepEqual(exp, act) {
+			t.Fatalf("parallelism %d: expected %v but got %v", n, exp, act)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+
+	pq, err := New(
+		Query("data.test.p"),
+		Module("test.rego", `package test
+
+p := [x | x := numbers.range(1, 100)[_]; x > 0]`),
+		Limits(topdown.Limits{MaxSteps: 50}),
+	).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pq.Eval(ctx)
+	var topdownErr *topdown.Error
+	if !errors.As(err, &topdownErr) || topdownErr.Code != topdown.StepLimitErr {
+		t.Fatalf("expected %v error but got: %v", topdown.StepLimitErr, err)
+	}
+
+	if _, err := pq.Eval(ctx, EvalLimits(topdown.Limits{MaxSteps: 1000})); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	ctx := context.Background()
+
+	module := `package test
+
+sep := "/"
+p := concat(sep, ["a", "b"])
+q := count([x | x := numbers.range(1, 10)[_]; x > 3])
+r := double(2)
+s := x { x := time.now_ns() }
+t := concat(sep, [p, input.x])`
+
+	r := New(
+		Query("data.test"),
+		Module("test.rego", module),
+		Input(map[string]interface{}{"x": "c"}),
+		ConstantFolding(true),
+		Function1(&Function{
+			Name: "double",
+			Decl: types.NewFunction(types.Args(types.N), types.N),
+		}, func(_ BuiltinContext, a *ast.Term) (*ast.Term, error) {
+			n, ok := a.Value.(ast.Number).Int()
+			if !ok {
+				return nil, fmt.Errorf("expected integer")
+			}
+			return ast.IntNumberTerm(n * 2), nil
+		}),
+	)
+
+	rs, err := r.Eval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := rs[0].Expressions[0].Value.(map[string]interface{})
+	for k, exp := range map[string]interface{}{"p": "a/b", "q": json.Number("7"), "r": json.Number("4"), "t": "a/b/c"} {
+		if result[k] != exp {
+			t.Errorf("expected %v for %v but got %v", exp, k, result[k])
+		}
+	}
+
+	for _, name := range []string{"p", "q", "r"} {
+		rules := r.compiler.GetRulesExact(ast.MustParseRef("data.test." + name))
+		if exp := ast.NewBody(ast.NewExpr(ast.BooleanTerm(true))); !rules[0].Body.Equal(exp) {
+			t.Errorf("expected %v to be folded but got: %v", name, rules[0])
+		}
+	}
+
+	rules := r.compiler.GetRulesExact(ast.MustParseRef("data.test.s"))
+	if ast.IsConstant(rules[0].Head.Value.Value) {
+		t.Errorf("expected non-deterministic call not to be folded but got: %v", rules[0])
+	}
+}
+
 func TestBuiltinErrorList(t *testing.T) {
 	var buf []topdown.Error
 
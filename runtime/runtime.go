@@ -49,6 +49,12 @@ import (
 	"github.com/open-policy-agent/opa/storage/disk"
 	"github.com/open-policy-agent/opa/storage/inmem"
 	"github.com/open-policy-agent/opa/tracing"
+
+	// Register the inter-query cache backends selectable in the caching
+	// configuration.
+	_ "github.com/open-policy-agent/opa/topdown/cache/disk"
+	_ "github.com/open-policy-agent/opa/topdown/cache/remote"
+
 	"github.com/open-policy-agent/opa/util"
 	"github.com/open-policy-agent/opa/version"
 )
@@ -298,7 +304,12 @@ func NewRuntime(ctx context.Context, params Params) (*Runtime, error) {
 		}
 	}
 
-	loaded, err := initload.LoadPaths(params.Paths, params.Filter, params.BundleMode, params.BundleVerificationConfig, params.SkipBundleVerification, false, nil)
+	parserOpts, err := plugins.ParserOptionsFromConfig(config, params.ID)
+	if err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+
+	loaded, err := initload.LoadPaths(params.Paths, params.Filter, params.BundleMode, params.BundleVerificationConfig, params.SkipBundleVerification, parserOpts.ProcessAnnotation, nil)
 	if err != nil {
 		return nil, fmt.Errorf("load error: %w", err)
 	}
@@ -716,7 +727,9 @@ func (rt *Runtime) readWatcher(ctx context.Context, watcher *fsnotify.Watcher, p
 
 func (rt *Runtime) processWatcherUpdate(ctx context.Context, paths []string, removed string) error {
 
-	loaded, err := initload.LoadPaths(paths, rt.Params.Filter, rt.Params.BundleMode, nil, true, false, nil)
+	parserOpts := rt.Manager.ParserOptions()
+
+	loaded, err := initload.LoadPaths(paths, rt.Params.Filter, rt.Params.BundleMode, nil, true, parserOpts.ProcessAnnotation, nil)
 	if err != nil {
 		return err
 	}
@@ -745,7 +758,7 @@ func (rt *Runtime) processWatcherUpdate(ctx context.Context, paths []string, rem
 					if err != nil {
 						return err
 					}
-					module, err := ast.ParseModule(id, string(bs))
+					module, err := ast.ParseModuleWithOpts(id, string(bs), parserOpts)
 					if err != nil {
 						return err
 					}
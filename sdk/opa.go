@@ -10,6 +10,7 @@ import (
 	"context"
 	"crypto/rand"
 	"fmt"
+	"reflect"
 	"sync"
 	"time"
 
@@ -50,6 +51,8 @@ type OPA struct {
 type state struct {
 	manager                *plugins.Manager
 	interQueryBuiltinCache cache.InterQueryCache
+	cacheBackend           *cache.BackendConfig
+	cancelCache            context.CancelFunc
 	queryCache             *queryCache
 }
 
@@ -188,6 +191,11 @@ func (opa *OPA) configure(ctx context.Context, bs []byte, ready chan struct{}, b
 	opa.mtx.Lock()
 	defer opa.mtx.Unlock()
 
+	if err := opa.reopenInterQueryBuiltinCache(manager.InterQueryBuiltinCacheConfig()); err != nil {
+		go manager.Stop(ctx)
+		return err
+	}
+
 	// NOTE(tsandall): there is no return value from Stop() and it could block
 	// on async operations (e.g., decision log uploading) so defer the call to
 	// another goroutine.
@@ -203,17 +211,52 @@ func (opa *OPA) configure(ctx context.Context, bs []byte, ready chan struct{}, b
 
 	opa.state.manager = manager
 	opa.state.queryCache.Clear()
-	opa.state.interQueryBuiltinCache = cache.NewInterQueryCache(manager.InterQueryBuiltinCacheConfig())
 	opa.config = bs
 
 	return nil
 }
 
+// reopenInterQueryBuiltinCache replaces the inter-query cache with a new one
+// for config. If the cache is stored in a backend whose configuration did not
+// change, the cache is kept because some backends (e.g., disk) cannot be
+// opened twice.
+func (opa *OPA) reopenInterQueryBuiltinCache(config *cache.Config) error {
+
+	var backend *cache.BackendConfig
+	if config != nil {
+		backend = config.InterQueryBuiltinCache.Backend
+	}
+
+	if opa.state.interQueryBuiltinCache != nil && backend != nil && reflect.DeepEqual(backend, opa.state.cacheBackend) {
+		opa.state.interQueryBuiltinCache.UpdateConfig(config)
+		return nil
+	}
+
+	if opa.state.cancelCache != nil {
+		opa.state.cancelCache()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := cache.OpenInterQueryCache(ctx, config)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	opa.state.interQueryBuiltinCache = c
+	opa.state.cacheBackend = backend
+	opa.state.cancelCache = cancel
+	return nil
+}
+
 // Stop closes the OPA. The OPA cannot be restarted.
 func (opa *OPA) Stop(ctx context.Context) {
 
 	opa.mtx.Lock()
 	mgr := opa.state.manager
+	if opa.state.cancelCache != nil {
+		opa.state.cancelCache()
+	}
 	opa.mtx.Unlock()
 
 	if mgr != nil {
@@ -33,6 +33,7 @@ import (
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/config"
 	"github.com/open-policy-agent/opa/internal/json/patch"
 	"github.com/open-policy-agent/opa/logging"
 	"github.com/open-policy-agent/opa/metrics"
@@ -135,9 +136,12 @@ type Server struct {
 	metrics                Metrics
 	defaultDecisionPath    string
 	interQueryBuiltinCache iCache.InterQueryCache
+	ruleCache              *topdown.RuleCache
 	allPluginsOkOnce       bool
 	distributedTracingOpts tracing.Options
 	ndbCacheEnabled        bool
+	evalLimits             topdown.Limits
+	streamTimeout          time.Duration
 }
 
 // Metrics defines the interface that the server requires for recording HTTP
@@ -179,10 +183,33 @@ func (s *Server) Init(ctx context.Context) (*Server, error) {
 	s.partials = map[string]rego.PartialResult{}
 	s.preparedEvalQueries = newCache(pqMaxCacheSize)
 	s.defaultDecisionPath = s.generateDefaultDecisionPath()
-	s.interQueryBuiltinCache = iCache.NewInterQueryCache(s.manager.InterQueryBuiltinCacheConfig())
+	var cacheOpts []iCache.Option
+	if m, ok := s.metrics.(metrics.Metrics); ok {
+		cacheOpts = append(cacheOpts, iCache.WithMetrics(m))
+	}
+	s.interQueryBuiltinCache, err = iCache.OpenInterQueryCache(ctx, s.manager.InterQueryBuiltinCacheConfig(), cacheOpts...)
+	if err != nil {
+		s.store.Abort(ctx, txn)
+		return nil, err
+	}
+	if c := s.manager.InterQueryBuiltinCacheConfig(); c != nil && c.RuleCache.Enabled {
+		s.ruleCache = topdown.NewRuleCache(s.interQueryBuiltinCache)
+	}
 	s.manager.RegisterCacheTrigger(s.updateCacheConfig)
 	s.manager.RegisterNDCacheTrigger(s.updateNDCache)
 
+	s.evalLimits, err = parseEvaluationLimits(s.manager.Config)
+	if err != nil {
+		s.store.Abort(ctx, txn)
+		return nil, err
+	}
+
+	s.streamTimeout, err = parseStreamTimeout(s.manager.Config)
+	if err != nil {
+		s.store.Abort(ctx, txn)
+		return nil, err
+	}
+
 	// authorizer, if configured, needs the iCache to be set up already
 	s.Handler = s.initHandlerAuth(s.Handler)
 	s.DiagnosticHandler = s.initHandlerAuth(s.DiagnosticHandler)
@@ -569,8 +596,9 @@ func (s *Server) getListenerForHTTPServer(u *url.URL, h http.Handler, t httpList
 		h = h2c.NewHandler(h, h2s)
 	}
 	h1s := http.Server{
-		Addr:    u.Host,
-		Handler: h,
+		Addr:        u.Host,
+		Handler:     h,
+		ConnContext: streamConnContext,
 	}
 
 	l := newHTTPListener(&h1s, t)
@@ -585,8 +613,9 @@ func (s *Server) getListenerForHTTPSServer(u *url.URL, h http.Handler, t httpLis
 	}
 
 	httpsServer := http.Server{
-		Addr:    u.Host,
-		Handler: h,
+		Addr:        u.Host,
+		Handler:     h,
+		ConnContext: streamConnContext,
 		TLSConfig: &tls.Config{
 			GetCertificate: s.getCertificate,
 			ClientCAs:      s.certPool,
@@ -620,7 +649,7 @@ func (s *Server) getListenerForUNIXSocket(u *url.URL, h http.Handler, t httpList
 		os.Remove(socketPath)
 	}
 
-	domainSocketServer := http.Server{Handler: h}
+	domainSocketServer := http.Server{Handler: h, ConnContext: streamConnContext}
 	unixListener, err := net.Listen("unix", socketPath)
 	if err != nil {
 		return nil, nil, err
@@ -799,6 +828,8 @@ func (s *Server) execQuery(ctx context.Context, r *http.Request, br bundleRevisi
 		rego.EnablePrintStatements(s.manager.EnablePrintStatements()),
 		rego.DistributedTracingOpts(s.distributedTracingOpts),
 		rego.NDBuiltinCache(ndbCache),
+		rego.Limits(s.evalLimits),
+		rego.RuleCache(s.ruleCache),
 	}
 
 	for _, r := range s.manager.GetWasmResolvers() {
@@ -885,7 +916,7 @@ func getRevisions(ctx context.Context, store storage.Store, txn storage.Transact
 	return br, nil
 }
 
-func (s *Server) reload(context.Context, storage.Transaction, storage.TriggerEvent) {
+func (s *Server) reload(ctx context.Context, txn storage.Transaction, event storage.TriggerEvent) {
 
 	// NOTE(tsandall): We currently rely on the storage txn to provide
 	// critical sections in the server.
@@ -898,6 +929,9 @@ func (s *Server) reload(context.Context, storage.Transaction, storage.TriggerEve
 	s.partials = map[string]rego.PartialResult{}
 	s.preparedEvalQueries = newCache(pqMaxCacheSize)
 	s.defaultDecisionPath = s.generateDefaultDecisionPath()
+	if s.ruleCache != nil {
+		s.ruleCache.OnCommit(ctx, txn, event)
+	}
 }
 
 func (s *Server) unversionedPost(w http.ResponseWriter, r *http.Request) {
@@ -998,6 +1032,7 @@ func (s *Server) v0QueryPath(w http.ResponseWriter, r *http.Request, urlPath str
 		rego.EvalMetrics(m),
 		rego.EvalInterQueryBuiltinCache(s.interQueryBuiltinCache),
 		rego.EvalNDBuiltinCache(ndbCache),
+		rego.EvalLimits(s.evalLimits),
 	}
 
 	rs, err := preparedQuery.Eval(
@@ -1360,6 +1395,22 @@ func (s *Server) v1DataGet(w http.ResponseWriter, r *http.Request) {
 
 	m.Timer(metrics.RegoInputParse).Stop()
 
+	if getBoolParam(r.URL, types.ParamStreamV1, true) {
+		s.stream(w, r, m, streamRequest{
+			decisionID:          decisionID,
+			path:                urlPath,
+			query:               streamDataQuery(urlPath),
+			input:               input,
+			goInput:             goInput,
+			explainMode:         explainMode,
+			includeMetrics:      includeMetrics,
+			instrument:          includeInstrumentation,
+			strictBuiltinErrors: strictBuiltinErrors,
+			result:              streamDataResult,
+		})
+		return
+	}
+
 	// Prepare for query.
 	c := storage.NewContext().WithMetrics(m)
 	txn, err := s.store.NewTransaction(ctx, storage.TransactionParams{Context: c})
@@ -1432,6 +1483,7 @@ func (s *Server) v1DataGet(w http.ResponseWriter, r *http.Request) {
 		rego.EvalInterQueryBuiltinCache(s.interQueryBuiltinCache),
 		rego.EvalInstrument(includeInstrumentation),
 		rego.EvalNDBuiltinCache(ndbCache),
+		rego.EvalLimits(s.evalLimits),
 	}
 
 	rs, err := preparedQuery.Eval(
@@ -1594,6 +1646,22 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 
 	m.Timer(metrics.RegoInputParse).Stop()
 
+	if getBoolParam(r.URL, types.ParamStreamV1, true) {
+		s.stream(w, r, m, streamRequest{
+			decisionID:          decisionID,
+			path:                urlPath,
+			query:               streamDataQuery(urlPath),
+			input:               input,
+			goInput:             goInput,
+			explainMode:         explainMode,
+			includeMetrics:      includeMetrics,
+			instrument:          includeInstrumentation,
+			strictBuiltinErrors: strictBuiltinErrors,
+			result:              streamDataResult,
+		})
+		return
+	}
+
 	txn, err := s.store.NewTransaction(ctx, storage.TransactionParams{Context: storage.NewContext().WithMetrics(m)})
 	if err != nil {
 		writer.ErrorAuto(w, err)
@@ -1669,6 +1737,7 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 		rego.EvalInterQueryBuiltinCache(s.interQueryBuiltinCache),
 		rego.EvalInstrument(includeInstrumentation),
 		rego.EvalNDBuiltinCache(ndbCache),
+		rego.EvalLimits(s.evalLimits),
 	}
 
 	rs, err := preparedQuery.Eval(
@@ -1903,7 +1972,9 @@ func (s *Server) v1PoliciesDelete(w http.ResponseWriter, r *http.Request) {
 
 	delete(modules, id)
 
-	c := ast.NewCompiler().SetErrorLimit(s.errLimit)
+	c := ast.NewCompiler().
+		SetErrorLimit(s.errLimit).
+		WithIncremental(s.getCompiler())
 
 	m.Timer(metrics.RegoModuleCompile).Start()
 
@@ -2070,7 +2141,7 @@ func (s *Server) v1PoliciesPut(w http.ResponseWriter, r *http.Request) {
 	}
 
 	m.Timer(metrics.RegoModuleParse).Start()
-	parsedMod, err := ast.ParseModule(id, string(buf))
+	parsedMod, err := ast.ParseModuleWithOpts(id, string(buf), s.manager.ParserOptions())
 	m.Timer(metrics.RegoModuleParse).Stop()
 
 	if err != nil {
@@ -2106,11 +2177,13 @@ func (s *Server) v1PoliciesPut(w http.ResponseWriter, r *http.Request) {
 	c := ast.NewCompiler().
 		SetErrorLimit(s.errLimit).
 		WithPathConflictsCheck(storage.NonEmpty(ctx, s.store, txn)).
-		WithEnablePrintStatements(s.manager.EnablePrintStatements())
+		WithEnablePrintStatements(s.manager.EnablePrintStatements()).
+		WithIncremental(s.getCompiler())
 
 	m.Timer(metrics.RegoModuleCompile).Start()
 
 	if c.Compile(modules); c.Failed() {
+		c.Errors.SetCodeFrames(map[string][]byte{id: buf})
 		s.abort(ctx, txn, func() {
 			writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, types.MsgCompileModuleError).WithASTErrors(c.Errors))
 		})
@@ -2171,6 +2244,20 @@ func (s *Server) v1QueryGet(w http.ResponseWriter, r *http.Request) {
 	includeMetrics := getBoolParam(r.URL, types.ParamMetricsV1, true)
 	includeInstrumentation := getBoolParam(r.URL, types.ParamInstrumentV1, true)
 
+	if getBoolParam(r.URL, types.ParamStreamV1, true) {
+		m.Timer(metrics.ServerHandler).Start()
+		s.stream(w, r, m, streamRequest{
+			decisionID:     decisionID,
+			query:          parsedQuery,
+			rawQuery:       qStr,
+			explainMode:    explainMode,
+			includeMetrics: includeMetrics,
+			instrument:     includeInstrumentation,
+			result:         streamQueryResult,
+		})
+		return
+	}
+
 	params := storage.TransactionParams{Context: storage.NewContext().WithMetrics(m)}
 	txn, err := s.store.NewTransaction(ctx, params)
 	if err != nil {
@@ -2190,6 +2277,7 @@ func (s *Server) v1QueryGet(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		switch err := err.(type) {
 		case ast.Errors:
+			err.SetCodeFrames(map[string][]byte{"": []byte(qStr)})
 			writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, types.MsgCompileQueryError).WithASTErrors(err))
 		default:
 			writer.ErrorAuto(w, err)
@@ -2242,6 +2330,21 @@ func (s *Server) v1QueryPost(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if getBoolParam(r.URL, types.ParamStreamV1, true) {
+		s.stream(w, r, m, streamRequest{
+			decisionID:     decisionID,
+			query:          parsedQuery,
+			rawQuery:       qStr,
+			input:          input,
+			goInput:        request.Input,
+			explainMode:    explainMode,
+			includeMetrics: includeMetrics,
+			instrument:     includeInstrumentation,
+			result:         streamQueryResult,
+		})
+		return
+	}
+
 	params := storage.TransactionParams{Context: storage.NewContext().WithMetrics(m)}
 	txn, err := s.store.NewTransaction(ctx, params)
 	if err != nil {
@@ -2261,6 +2364,7 @@ func (s *Server) v1QueryPost(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		switch err := err.(type) {
 		case ast.Errors:
+			err.SetCodeFrames(map[string][]byte{"": []byte(qStr)})
 			writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, types.MsgCompileQueryError).WithASTErrors(err))
 		default:
 			writer.ErrorAuto(w, err)
@@ -2314,7 +2418,7 @@ func (s *Server) checkPolicyIDScope(ctx context.Context, txn storage.Transaction
 		return err
 	}
 
-	module, err := ast.ParseModule(id, string(bs))
+	module, err := ast.ParseModuleWithOpts(id, string(bs), s.manager.ParserOptions())
 	if err != nil {
 		return err
 	}
@@ -2446,7 +2550,7 @@ func (s *Server) loadModules(ctx context.Context, txn storage.Transaction) (map[
 			return nil, err
 		}
 
-		parsed, err := ast.ParseModule(id, string(bs))
+		parsed, err := ast.ParseModuleWithOpts(id, string(bs), s.manager.ParserOptions())
 		if err != nil {
 			return nil, err
 		}
@@ -2487,6 +2591,7 @@ func (s *Server) makeRego(ctx context.Context,
 		rego.StrictBuiltinErrors(strictBuiltinErrors),
 		rego.PrintHook(s.manager.PrintHook()),
 		rego.DistributedTracingOpts(s.distributedTracingOpts),
+		rego.RuleCache(s.ruleCache),
 	)
 
 	if partial {
@@ -2612,6 +2717,41 @@ func isPathOwned(path, root []string) bool {
 	return true
 }
 
+// parseEvaluationLimits returns the limits on the resources used by the
+// evaluation of API requests configured under server.evaluation_limits.
+func parseEvaluationLimits(c *config.Config) (topdown.Limits, error) {
+	var limits topdown.Limits
+	if c == nil || c.Server == nil || c.Server.EvaluationLimits == nil {
+		return limits, nil
+	}
+	if err := util.Unmarshal(c.Server.EvaluationLimits, &limits); err != nil {
+		return limits, fmt.Errorf("server.evaluation_limits: %w", err)
+	}
+	if err := limits.Validate(); err != nil {
+		return limits, fmt.Errorf("server.evaluation_limits: %w", err)
+	}
+	return limits, nil
+}
+
+func parseStreamTimeout(c *config.Config) (time.Duration, error) {
+	if c == nil || c.Server == nil || c.Server.Streaming == nil {
+		return defaultStreamTimeout, nil
+	}
+	var streaming struct {
+		TimeoutSeconds *int64 `json:"timeout_seconds"`
+	}
+	if err := util.Unmarshal(c.Server.Streaming, &streaming); err != nil {
+		return 0, fmt.Errorf("server.streaming: %w", err)
+	}
+	if streaming.TimeoutSeconds == nil {
+		return defaultStreamTimeout, nil
+	}
+	if *streaming.TimeoutSeconds <= 0 {
+		return 0, fmt.Errorf("server.streaming.timeout_seconds must be positive")
+	}
+	return time.Duration(*streaming.TimeoutSeconds) * time.Second, nil
+}
+
 func (s *Server) updateCacheConfig(cacheConfig *iCache.Config) {
 	s.interQueryBuiltinCache.UpdateConfig(cacheConfig)
 }
@@ -8,6 +8,8 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -41,6 +43,7 @@ import (
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/disk"
 	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/topdown"
 	"github.com/open-policy-agent/opa/tracing"
 	"github.com/open-policy-agent/opa/util"
 	"github.com/open-policy-agent/opa/util/test"
@@ -1271,6 +1274,7 @@ p = true { false }`
                 {
                   "code": "rego_compile_error",
                   "message": "conflicting rule for data path x/y/p found",
+                  "code_frame": "2 | p = 1\n  | ^^^^^",
                   "location": {
                     "file": "testmod",
                     "row": 2,
@@ -3374,6 +3378,7 @@ func TestQueryV1UnsafeBuiltin(t *testing.T) {
     {
       "code": "rego_type_error",
       "message": "unsafe built-in function calls in expression: http.send",
+      "code_frame": "1 | http.send({\"method\": \"get\", \"url\": \"foo.com\"}, x)\n  | ^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^",
       "location": {
         "file": "",
         "row": 1,
@@ -3489,6 +3494,355 @@ func TestQueryV1Explain(t *testing.T) {
 	}
 }
 
+func TestEvaluationLimits(t *testing.T) {
+
+	ctx := context.Background()
+
+	newServer := func(config string) (*Server, error) {
+		store := inmem.New()
+		m, err := plugins.New([]byte(config), "test", store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		txn := storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+		if err := store.UpsertPolicy(ctx, txn, "test", []byte(`package test
+			xs := numbers.range(1, 100)
+			p := [x | x := xs[_]; x > 0]`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Commit(ctx, txn); err != nil {
+			t.Fatal(err)
+		}
+		return New().
+			WithAddresses([]string{"localhost:8182"}).
+			WithStore(store).
+			WithManager(m).
+			Init(ctx)
+	}
+
+	if _, err := newServer(`{"server": {"evaluation_limits": {"max_steps": -1}}}`); err == nil {
+		t.Fatal("expected error for invalid evaluation limits")
+	}
+
+	server, err := newServer(`{"server": {"evaluation_limits": {"max_steps": 50}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, req := range []*http.Request{
+		newReqV1(http.MethodGet, "/data/test/p", ""),
+		newReqV1(http.MethodPost, "/data/test/p", ""),
+		newReqV1(http.MethodGet, "/query?q=data.test.p=x", ""),
+	} {
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusInternalServerError {
+			t.Fatalf("%v: expected status %d but got %d: %v", req.URL, http.StatusInternalServerError, recorder.Code, recorder.Body)
+		}
+
+		var resp struct {
+			Errors []struct {
+				Code string `json:"code"`
+			} `json:"errors"`
+		}
+		if err := util.NewJSONDecoder(recorder.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Errors) != 1 || resp.Errors[0].Code != topdown.StepLimitErr {
+			t.Fatalf("%v: expected %v error but got: %v", req.URL, topdown.StepLimitErr, resp)
+		}
+	}
+
+	// Requests within the limits are not affected.
+	server, err = newServer(`{"server": {"evaluation_limits": {"max_steps": 1000}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.Handler.ServeHTTP(recorder, newReqV1(http.MethodGet, "/data/test/p", ""))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %v", http.StatusOK, recorder.Code, recorder.Body)
+	}
+}
+
+func TestRuleCache(t *testing.T) {
+	f := newFixtureWithConfig(t, `{"caching": {"rule_cache": {"enabled": true}}}`)
+
+	policy := `package test
+
+# METADATA
+# cache: true
+users := [u.name | u := data.users[_]; u.tenant == input.tenant]`
+
+	if err := f.v1(http.MethodPut, "/policies/test", policy, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodPut, "/data/users", `[{"name": "alice", "tenant": "a"}]`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(expResult string, expCounter string) {
+		t.Helper()
+		f.reset()
+		f.server.Handler.ServeHTTP(f.recorder, newReqV1(http.MethodPost, "/data/test/users?instrument", `{"input": {"tenant": "a"}}`))
+		if f.recorder.Code != http.StatusOK {
+			t.Fatalf("expected status %d but got %d: %v", http.StatusOK, f.recorder.Code, f.recorder.Body)
+		}
+		var resp types.DataResponseV1
+		if err := util.NewJSONDecoder(f.recorder.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Result == nil || util.Compare(*resp.Result, util.MustUnmarshalJSON([]byte(expResult))) != 0 {
+			t.Fatalf("expected result %v but got %v", expResult, resp.Result)
+		}
+		if _, ok := resp.Metrics["counter_"+expCounter]; !ok {
+			t.Fatalf("expected %v counter but got metrics: %v", expCounter, resp.Metrics)
+		}
+	}
+
+	query(`["alice"]`, "eval_op_rule_cache_miss")
+	query(`["alice"]`, "eval_op_rule_cache_hit")
+
+	if err := f.v1(http.MethodPatch, "/data/users", `[{"op": "add", "path": "-", "value": {"name": "bob", "tenant": "a"}}]`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	query(`["alice", "bob"]`, "eval_op_rule_cache_miss")
+}
+
+func TestRuleCacheDisabledByDefault(t *testing.T) {
+	f := newFixture(t)
+
+	policy := `package test
+
+# METADATA
+# cache: true
+users := [u.name | u := data.users[_]; u.tenant == input.tenant]`
+
+	if err := f.v1(http.MethodPut, "/policies/test", policy, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		f.reset()
+		f.server.Handler.ServeHTTP(f.recorder, newReqV1(http.MethodPost, "/data/test/users?instrument", `{"input": {"tenant": "a"}}`))
+		if f.recorder.Code != http.StatusOK {
+			t.Fatalf("expected status %d but got %d: %v", http.StatusOK, f.recorder.Code, f.recorder.Body)
+		}
+		var resp types.DataResponseV1
+		if err := util.NewJSONDecoder(f.recorder.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"counter_eval_op_rule_cache_hit", "counter_eval_op_rule_cache_miss"} {
+			if _, ok := resp.Metrics[name]; ok {
+				t.Fatalf("unexpected %v counter in metrics: %v", name, resp.Metrics)
+			}
+		}
+	}
+}
+
+func TestStreamResults(t *testing.T) {
+	f := newFixture(t)
+
+	policy := `package test
+
+s[x] {
+	x := input.xs[_]
+}
+
+o[k] = v {
+	k := input.xs[_]
+	v := k
+}
+
+o[2] = 3`
+
+	if err := f.v1(http.MethodPut, "/policies/test", policy, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		note   string
+		method string
+		path   string
+		body   string
+		code   int
+		exp    []string
+	}{
+		{
+			note:   "data get",
+			method: http.MethodGet,
+			path:   `/data/test/s?stream&input={"xs":[1,2]}`,
+			code:   200,
+			exp: []string{
+				`{"result": {"key": 1, "value": 1}}`,
+				`{"result": {"key": 2, "value": 2}}`,
+			},
+		},
+		{
+			note:   "data post",
+			method: http.MethodPost,
+			path:   "/data/test/s?stream",
+			body:   `{"input": {"xs": ["a"]}}`,
+			code:   200,
+			exp: []string{
+				`{"result": {"key": "a", "value": "a"}}`,
+			},
+		},
+		{
+			note:   "data undefined",
+			method: http.MethodGet,
+			path:   "/data/test/s?stream",
+			code:   200,
+		},
+		{
+			note:   "query get",
+			method: http.MethodGet,
+			path:   `/query?stream&q=x = [1, 2][_]`,
+			code:   200,
+			exp: []string{
+				`{"result": {"x": 1}}`,
+				`{"result": {"x": 2}}`,
+			},
+		},
+		{
+			note:   "query post",
+			method: http.MethodPost,
+			path:   "/query?stream",
+			body:   `{"query": "data.test.s[x]", "input": {"xs": [3]}}`,
+			code:   200,
+			exp: []string{
+				`{"result": {"x": 3}}`,
+			},
+		},
+		{
+			note:   "compile error",
+			method: http.MethodGet,
+			path:   "/query?stream&q=data.test.s[x]; y",
+			code:   400,
+		},
+		{
+			note:   "explain",
+			method: http.MethodGet,
+			path:   "/data/test/s?stream&explain=full",
+			code:   400,
+		},
+		{
+			note:   "evaluation error after results",
+			method: http.MethodPost,
+			path:   "/query?stream",
+			body:   `{"query": "x = [1, 2][_]; data.test.o[x] = y", "input": {"xs": [1, 2]}}`,
+			code:   200,
+			exp: []string{
+				`{"result": {"x": 1, "y": 1}}`,
+				`{"result": {"x": 2, "y": 2}}`,
+				`{"error": {"code": "internal_error", "message": "error(s) occurred while evaluating query", "errors": [{"code": "eval_conflict_error", "message": "object keys must be unique", "location": {"file": "test", "row": 12, "col": 1}}]}}`,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			f.reset()
+			f.server.Handler.ServeHTTP(f.recorder, newReqV1(tc.method, tc.path, tc.body))
+			if f.recorder.Code != tc.code {
+				t.Fatalf("expected status %d but got %d: %v", tc.code, f.recorder.Code, f.recorder.Body)
+			}
+			if tc.code != 200 {
+				return
+			}
+			if ct := f.recorder.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Fatalf("expected NDJSON content type but got %q", ct)
+			}
+			lines := strings.Split(strings.TrimSuffix(f.recorder.Body.String(), "\n"), "\n")
+			if len(lines) == 1 && lines[0] == "" {
+				lines = nil
+			}
+			if len(lines) != len(tc.exp) {
+				t.Fatalf("expected %d lines but got: %v", len(tc.exp), lines)
+			}
+			for i := range lines {
+				if util.Compare(util.MustUnmarshalJSON([]byte(lines[i])), util.MustUnmarshalJSON([]byte(tc.exp[i]))) != 0 {
+					t.Fatalf("expected line %d to be %v but got %v", i, tc.exp[i], lines[i])
+				}
+			}
+		})
+	}
+
+	// Metrics are sent on an additional last line.
+	f.reset()
+	f.server.Handler.ServeHTTP(f.recorder, newReqV1(http.MethodGet, `/data/test/s?stream&metrics&input={"xs":[1]}`, ""))
+	lines := strings.Split(strings.TrimSuffix(f.recorder.Body.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected result and metrics lines but got: %v", lines)
+	}
+	var last types.StreamLineV1
+	if err := util.UnmarshalJSON([]byte(lines[1]), &last); err != nil {
+		t.Fatal(err)
+	}
+	if last.Result != nil || last.Metrics["timer_server_handler_ns"] == nil {
+		t.Fatalf("expected metrics line but got: %v", lines[1])
+	}
+
+	// Decisions are logged with the number and digest of the results.
+	var infos []*Info
+	f.server = f.server.WithDecisionLoggerWithErr(func(_ context.Context, info *Info) error {
+		infos = append(infos, info)
+		return nil
+	})
+	f.reset()
+	f.server.Handler.ServeHTTP(f.recorder, newReqV1(http.MethodGet, `/data/test/s?stream&input={"xs":[1,2]}`, ""))
+	sum := sha256.Sum256(f.recorder.Body.Bytes())
+	exp := map[string]interface{}{"streamed_results": 2, "sha256": hex.EncodeToString(sum[:])}
+	if len(infos) != 1 || infos[0].Results == nil || !reflect.DeepEqual(*infos[0].Results, exp) {
+		t.Fatalf("expected decision logged with %v but got: %v", exp, infos)
+	}
+
+	// Streams exceeding the timeout are cancelled.
+	f.server.streamTimeout = time.Nanosecond
+	f.reset()
+	f.server.Handler.ServeHTTP(f.recorder, newReqV1(http.MethodGet, `/data/test/s?stream&input={"xs":[1,2]}`, ""))
+	if f.recorder.Code != http.StatusInternalServerError || !strings.Contains(f.recorder.Body.String(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("expected timeout error but got %d: %v", f.recorder.Code, f.recorder.Body)
+	}
+}
+
+func TestStreamTimeoutConfig(t *testing.T) {
+	tests := []struct {
+		config string
+		exp    time.Duration
+		err    bool
+	}{
+		{config: `{}`, exp: defaultStreamTimeout},
+		{config: `{"server": {"streaming": {}}}`, exp: defaultStreamTimeout},
+		{config: `{"server": {"streaming": {"timeout_seconds": 5}}}`, exp: 5 * time.Second},
+		{config: `{"server": {"streaming": {"timeout_seconds": 0}}}`, err: true},
+		{config: `{"server": {"streaming": {"timeout_seconds": "5s"}}}`, err: true},
+	}
+
+	for _, tc := range tests {
+		c, err := config.ParseConfig([]byte(tc.config), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		timeout, err := parseStreamTimeout(c)
+		if tc.err {
+			if err == nil {
+				t.Fatalf("%v: expected error", tc.config)
+			}
+			continue
+		}
+		if err != nil || timeout != tc.exp {
+			t.Fatalf("%v: expected %v but got %v (err: %v)", tc.config, tc.exp, timeout, err)
+		}
+	}
+}
+
 func TestAuthorization(t *testing.T) {
 
 	ctx := context.Background()
@@ -3897,6 +4251,10 @@ type fixture struct {
 }
 
 func newFixture(t *testing.T, opts ...func(*Server)) *fixture {
+	return newFixtureWithConfig(t, "", opts...)
+}
+
+func newFixtureWithConfig(t *testing.T, config string, opts ...func(*Server)) *fixture {
 	ctx := context.Background()
 	server := New().
 		WithAddresses([]string{"localhost:8182"}).
@@ -3905,7 +4263,7 @@ func newFixture(t *testing.T, opts ...func(*Server)) *fixture {
 		opt(server)
 	}
 
-	m, err := plugins.New([]byte{}, "test", server.store)
+	m, err := plugins.New([]byte(config), "test", server.store)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -4478,3 +4836,31 @@ func (m mockHTTPListener) Shutdown(context.Context) error {
 func (m mockHTTPListener) Type() httpListenerType {
 	return m.t
 }
+
+func TestQueryV1CompileErrorHints(t *testing.T) {
+	f := newFixture(t)
+
+	query := `/query?q=contans("abc", "a")`
+
+	expected := `{
+  "code": "invalid_parameter",
+  "message": "error(s) occurred while compiling query",
+  "errors": [
+    {
+      "code": "rego_type_error",
+      "message": "undefined function contans",
+      "hints": ["did you mean contains?"],
+      "code_frame": "1 | contans(\"abc\", \"a\")\n  | ^^^^^^^^^^^^^^^^^^^",
+      "location": {
+        "file": "",
+        "row": 1,
+        "col": 1
+      }
+    }
+  ]
+}`
+
+	if err := f.v1(http.MethodGet, query, "", 400, expected); err != nil {
+		t.Fatalf(`Expected %v but got: %v`, expected, f.recorder.Body.String())
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/server/types"
+	"github.com/open-policy-agent/opa/server/writer"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+// streamRequest holds the parameters of a Query or Data API request whose
+// results are streamed.
+type streamRequest struct {
+	decisionID          string
+	path                string // set for Data API requests
+	query               ast.Body
+	rawQuery            string // set for Query API requests
+	input               ast.Value
+	goInput             *interface{}
+	explainMode         types.ExplainModeV1
+	includeMetrics      bool
+	instrument          bool
+	strictBuiltinErrors bool
+	result              func(rego.Result) interface{}
+}
+
+// defaultStreamTimeout bounds the time taken by streamed responses, during
+// which the read transaction stays open, unless configured otherwise.
+const defaultStreamTimeout = 60 * time.Second
+
+type streamConnKey struct{}
+
+// streamConnContext stores the connection of a request in its context, so
+// that a write deadline can be set for streamed responses.
+func streamConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, streamConnKey{}, c)
+}
+
+// streamSummary is logged as the result of streamed decisions: the number of
+// results sent and the SHA-256 digest of the lines holding them.
+func streamSummary(stream *writer.Stream) *interface{} {
+	var summary interface{} = map[string]interface{}{
+		"streamed_results": stream.Results(),
+		"sha256":           stream.Digest(),
+	}
+	return &summary
+}
+
+// streamDataKey and streamDataValue are the variables bound to the elements
+// of the document streamed by the Data API.
+const (
+	streamDataKey   = "key"
+	streamDataValue = "value"
+)
+
+// streamDataQuery returns the query that iterates over the elements of the
+// document at urlPath.
+func streamDataQuery(urlPath string) ast.Body {
+	ref := append(stringPathToDataRef(urlPath), ast.VarTerm(streamDataKey))
+	return ast.NewBody(ast.Equality.Expr(ast.NewTerm(ref), ast.VarTerm(streamDataValue)))
+}
+
+// streamDataResult returns the element of the document bound by the query
+// returned by streamDataQuery.
+func streamDataResult(result rego.Result) interface{} {
+	return types.StreamDataResultV1{
+		Key:   result.Bindings[streamDataKey],
+		Value: result.Bindings[streamDataValue],
+	}
+}
+
+// streamQueryResult returns the bindings of a Query API result.
+func streamQueryResult(result rego.Result) interface{} {
+	return result.Bindings.WithoutWildcards()
+}
+
+// stream evaluates the query of req and writes each result to w as soon as it
+// is produced. The results are not held in memory, so the decision is logged
+// with a summary of them (see streamSummary). Evaluation and writing are
+// bounded by the stream timeout. The metrics.ServerHandler timer must have
+// been started.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, m metrics.Metrics, req streamRequest) {
+	if req.explainMode != types.ExplainOffV1 {
+		writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, "explain is not supported for streamed results"))
+		return
+	}
+
+	timeout := s.streamTimeout
+	if timeout == 0 {
+		timeout = defaultStreamTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	// Cancelling evaluation does not interrupt writes blocked by a client that
+	// does not read the response. HTTP/2 connections are shared by requests,
+	// so their write deadline cannot be set.
+	if c, ok := r.Context().Value(streamConnKey{}).(net.Conn); ok && r.ProtoMajor == 1 {
+		deadline, _ := ctx.Deadline()
+		if err := c.SetWriteDeadline(deadline); err == nil {
+			defer c.SetWriteDeadline(time.Time{})
+		}
+	}
+
+	txn, err := s.store.NewTransaction(ctx, storage.TransactionParams{Context: storage.NewContext().WithMetrics(m)})
+	if err != nil {
+		writer.ErrorAuto(w, err)
+		return
+	}
+
+	defer s.store.Abort(ctx, txn)
+
+	br, err := getRevisions(ctx, s.store, txn)
+	if err != nil {
+		writer.ErrorAuto(w, err)
+		return
+	}
+
+	logger := s.getDecisionLogger(br)
+
+	var ndbCache builtins.NDBCache
+	if s.ndbCacheEnabled {
+		ndbCache = builtins.NDBCache{}
+	}
+
+	opts := []func(*rego.Rego){
+		rego.Store(s.store),
+		rego.Transaction(txn),
+		rego.Compiler(s.getCompiler()),
+		rego.ParsedQuery(req.query),
+		rego.ParsedInput(req.input),
+		rego.Metrics(m),
+		rego.Instrument(req.instrument),
+		rego.Runtime(s.runtime),
+		rego.UnsafeBuiltins(unsafeBuiltinsMap),
+		rego.InterQueryBuiltinCache(s.interQueryBuiltinCache),
+		rego.PrintHook(s.manager.PrintHook()),
+		rego.EnablePrintStatements(s.manager.EnablePrintStatements()),
+		rego.DistributedTracingOpts(s.distributedTracingOpts),
+		rego.StrictBuiltinErrors(req.strictBuiltinErrors),
+		rego.NDBuiltinCache(ndbCache),
+		rego.Limits(s.evalLimits),
+		rego.RuleCache(s.ruleCache),
+	}
+
+	for _, r := range s.manager.GetWasmResolvers() {
+		for _, entrypoint := range r.Entrypoints() {
+			opts = append(opts, rego.Resolver(entrypoint, r))
+		}
+	}
+
+	pq, err := rego.New(opts...).PrepareForEval(ctx)
+	if err != nil {
+		_ = logger.Log(r.Context(), txn, req.decisionID, r.RemoteAddr, req.path, req.rawQuery, req.goInput, req.input, nil, ndbCache, err, m)
+		switch err := err.(type) {
+		case ast.Errors:
+			if req.rawQuery != "" {
+				err.SetCodeFrames(map[string][]byte{"": []byte(req.rawQuery)})
+			}
+			writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, types.MsgCompileQueryError).WithASTErrors(err))
+		default:
+			writer.ErrorAuto(w, err)
+		}
+		return
+	}
+
+	stream := writer.NewStream(w)
+
+	err = pq.Iter(ctx, func(result rego.Result) error {
+		// Evaluation is cancelled asynchronously, so check for the timeout
+		// here as well.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return stream.Result(req.result(result))
+	},
+		rego.EvalTransaction(txn),
+		rego.EvalParsedInput(req.input),
+		rego.EvalMetrics(m),
+		rego.EvalInstrument(req.instrument),
+		rego.EvalNDBuiltinCache(ndbCache),
+	)
+	if err != nil {
+		_ = logger.Log(r.Context(), txn, req.decisionID, r.RemoteAddr, req.path, req.rawQuery, req.goInput, req.input, streamSummary(stream), ndbCache, err, m)
+		stream.Error(err)
+		return
+	}
+
+	_ = logger.Log(r.Context(), txn, req.decisionID, r.RemoteAddr, req.path, req.rawQuery, req.goInput, req.input, streamSummary(stream), ndbCache, nil, m)
+
+	var metricsV1 types.MetricsV1
+	if req.includeMetrics || req.instrument {
+		m.Timer(metrics.ServerHandler).Stop()
+		metricsV1 = m.All()
+	}
+	stream.End(metricsV1)
+}
@@ -184,6 +184,24 @@ type QueryResponseV1 struct {
 // AdhocQueryResultSetV1 models the result of a Query API query.
 type AdhocQueryResultSetV1 []map[string]interface{}
 
+// StreamLineV1 models a line of a streamed Query or Data API response. Each
+// line holds one result. If metrics were requested, they are sent with the
+// last line. If evaluation fails after results have been sent, the last line
+// holds the error.
+type StreamLineV1 struct {
+	Result  interface{} `json:"result,omitempty"`
+	Metrics MetricsV1   `json:"metrics,omitempty"`
+	Error   *ErrorV1    `json:"error,omitempty"`
+}
+
+// StreamDataResultV1 models a result of a streamed Data API response: an
+// element of the document, with its key or index. For sets, the key is the
+// element itself.
+type StreamDataResultV1 struct {
+	Key   interface{} `json:"key"`
+	Value interface{} `json:"value"`
+}
+
 // ExplainModeV1 defines supported values for the "explain" query parameter.
 type ExplainModeV1 string
 
@@ -473,6 +491,11 @@ const (
 	// ParamStrictBuiltinErrors names the HTTP URL parameter that indicates the client
 	// wants built-in function errors to be treated as fatal.
 	ParamStrictBuiltinErrors = "strict-builtin-errors"
+
+	// ParamStreamV1 defines the name of the HTTP URL parameter that indicates
+	// the client wants to receive the results as newline-delimited JSON while
+	// they are produced.
+	ParamStreamV1 = "stream"
 )
 
 // BadRequestErr represents an error condition raised if the caller passes
@@ -0,0 +1,102 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package writer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"net/http"
+
+	"github.com/open-policy-agent/opa/server/types"
+)
+
+// Stream writes a response of newline-delimited JSON lines (see
+// types.StreamLineV1). Each line is flushed to the client when it is written,
+// so writes block when the client does not keep up.
+type Stream struct {
+	w       http.ResponseWriter
+	started bool
+	results int
+	digest  hash.Hash
+}
+
+// NewStream returns a Stream writing to w. Nothing is written before the first
+// result.
+func NewStream(w http.ResponseWriter) *Stream {
+	return &Stream{w: w, digest: sha256.New()}
+}
+
+// Result writes a line holding result. The error returned is the error of
+// the write, e.g., if the client went away.
+func (s *Stream) Result(result interface{}) error {
+	bs, err := s.write(types.StreamLineV1{Result: result})
+	if err != nil {
+		return err
+	}
+	s.results++
+	s.digest.Write(bs)
+	return nil
+}
+
+// Results returns the number of results written.
+func (s *Stream) Results() int {
+	return s.results
+}
+
+// Digest returns the hex encoded SHA-256 digest of the lines holding the
+// results written, as sent to the client.
+func (s *Stream) Digest() string {
+	return hex.EncodeToString(s.digest.Sum(nil))
+}
+
+// End completes the response. If metrics are set, they are written on the
+// last line. If no results were written, the response is empty.
+func (s *Stream) End(metrics types.MetricsV1) {
+	if metrics != nil {
+		_, _ = s.write(types.StreamLineV1{Metrics: metrics})
+		return
+	}
+	if !s.started {
+		s.start()
+	}
+}
+
+// Error completes the response with err. If no results were written, the
+// error is written like with ErrorAuto, otherwise on the last line.
+func (s *Stream) Error(err error) {
+	if !s.started {
+		ErrorAuto(s.w, err)
+		return
+	}
+	_, e := autoError(err)
+	_, _ = s.write(types.StreamLineV1{Error: e})
+}
+
+func (s *Stream) start() {
+	s.started = true
+	s.w.Header().Set("Content-Type", "application/x-ndjson")
+	s.w.WriteHeader(http.StatusOK)
+}
+
+// write writes line and returns it as written.
+func (s *Stream) write(line types.StreamLineV1) ([]byte, error) {
+	bs, err := json.Marshal(line)
+	if err != nil {
+		return nil, err
+	}
+	bs = append(bs, '\n')
+	if !s.started {
+		s.start()
+	}
+	if _, err := s.w.Write(bs); err != nil {
+		return nil, err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return bs, nil
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/inmem"
 	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/topdown/httpfixtures"
 )
 
 // TestPrefix declares the prefix for all test rules.
@@ -125,6 +126,7 @@ type Runner struct {
 	filter                string
 	target                string // target type (wasm, rego, etc.)
 	customBuiltins        []*Builtin
+	httpSendFixtures      *httpfixtures.Store
 }
 
 // NewRunner returns a new runner.
@@ -237,6 +239,13 @@ func (r *Runner) Target(target string) *Runner {
 	return r
 }
 
+// SetHTTPSendFixtures sets the recorded request/response pairs that http.send
+// serves to the test cases instead of sending requests over the network.
+func (r *Runner) SetHTTPSendFixtures(s *httpfixtures.Store) *Runner {
+	r.httpSendFixtures = s
+	return r
+}
+
 func getFailedAtFromTrace(bufFailureLineTracer *topdown.BufferTracer) *ast.Expr {
 	events := *bufFailureLineTracer
 	const SecondToLast = 2
@@ -471,6 +480,7 @@ func (r *Runner) runTest(ctx context.Context, txn storage.Transaction, mod *ast.
 		rego.Runtime(r.runtime),
 		rego.Target(r.target),
 		rego.PrintHook(topdown.NewPrintHook(printbuf)),
+		rego.HTTPSendFixtures(r.httpSendFixtures),
 	)
 
 	// Register custom builtins on rego instance
@@ -528,6 +538,7 @@ func (r *Runner) runBenchmark(ctx context.Context, txn storage.Transaction, mod
 			rego.Query(rule.Path().String()),
 			rego.Runtime(r.runtime),
 			rego.Target(r.target),
+			rego.HTTPSendFixtures(r.httpSendFixtures),
 		).PrepareForEval(ctx)
 
 		if err != nil {
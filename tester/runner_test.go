@@ -6,6 +6,9 @@ package tester_test
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 	"time"
@@ -16,6 +19,7 @@ import (
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/tester"
 	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/topdown/httpfixtures"
 	"github.com/open-policy-agent/opa/types"
 	"github.com/open-policy-agent/opa/util/test"
 )
@@ -609,3 +613,82 @@ func TestRunnerWithCustomBuiltin(t *testing.T) {
 		}
 	})
 }
+
+func TestRunnerHTTPSendFixtures(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"path": %q}`, r.URL.Path)
+	}))
+
+	files := map[string]string{
+		"/data.json": fmt.Sprintf(`{"url": %q}`, ts.URL),
+		"/test.rego": `package test
+
+		test_get {
+			resp := http.send({"method": "get", "url": concat("", [data.url, "/users/bob"])})
+			resp.body.path == "/users/bob"
+		}
+
+		test_post {
+			resp := http.send({"method": "post", "url": concat("", [data.url, "/users"]), "body": {"name": "alice"}})
+			resp.status_code == 200
+		}`,
+	}
+
+	fixturesDir := t.TempDir()
+	ctx := context.Background()
+
+	run := func(d string, fixtures *httpfixtures.Store) map[string]bool {
+		modules, store, err := tester.Load([]string{d}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txn := storage.NewTransactionOrDie(ctx, store)
+		defer store.Abort(ctx, txn)
+		ch, err := tester.NewRunner().SetStore(store).SetModules(modules).SetHTTPSendFixtures(fixtures).RunTests(ctx, txn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]bool{}
+		for r := range ch {
+			got[r.Name] = r.Pass()
+		}
+		return got
+	}
+
+	exp := map[string]bool{"test_get": true, "test_post": true}
+
+	test.WithTempFS(files, func(d string) {
+		recorder, err := httpfixtures.NewRecorder(fixturesDir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := run(d, recorder); !reflect.DeepEqual(exp, got) {
+			t.Fatal("expected:", exp, "got:", got)
+		}
+
+		// The recorded fixtures are served without the server.
+		ts.Close()
+
+		fixtures, err := httpfixtures.Load(fixturesDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(fixtures.Fixtures()); n != 2 {
+			t.Fatalf("expected 2 fixtures but got %d", n)
+		}
+		if got := run(d, fixtures); !reflect.DeepEqual(exp, got) {
+			t.Fatal("expected:", exp, "got:", got)
+		}
+
+		// Requests without fixtures fail.
+		empty, err := httpfixtures.Load(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, exp := run(d, empty), map[string]bool{"test_get": false, "test_post": false}; !reflect.DeepEqual(exp, got) {
+			t.Fatal("expected:", exp, "got:", got)
+		}
+	})
+}
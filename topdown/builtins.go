@@ -15,6 +15,7 @@ import (
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/topdown/builtins"
 	"github.com/open-policy-agent/opa/topdown/cache"
+	"github.com/open-policy-agent/opa/topdown/httpfixtures"
 	"github.com/open-policy-agent/opa/topdown/print"
 	"github.com/open-policy-agent/opa/tracing"
 )
@@ -54,6 +55,7 @@ type (
 		DistributedTracingOpts tracing.Options       // options to be used by distributed tracing.
 		rand                   *rand.Rand            // randomization source for non-security-sensitive operations
 		Capabilities           *ast.Capabilities
+		HTTPSendFixtures       *httpfixtures.Store // serves http.send requests from recorded fixtures
 	}
 
 	// BuiltinFunc defines an interface for implementing built-in functions.
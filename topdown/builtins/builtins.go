@@ -94,7 +94,7 @@ func (c *NDBCache) UnmarshalJSON(data []byte) error {
 	if source, ok := nestedObject.(ast.Object); ok {
 		err = source.Iter(func(k, v *ast.Term) error {
 			if obj, ok := v.Value.(ast.Object); ok {
-				out[string(k.Value.(ast.String))] = obj
+				out[string(k.Value.(ast.String))] = unmarshalOperandKeys(obj)
 				return nil
 			}
 			return fmt.Errorf("expected Object, got other Value type in conversion")
@@ -109,6 +109,25 @@ func (c *NDBCache) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// unmarshalOperandKeys returns obj with the keys of the cached values, which
+// are arrays of operands serialized as strings by JSON, parsed back into
+// arrays. Otherwise, the values could not be looked up after a roundtrip.
+func unmarshalOperandKeys(obj ast.Object) ast.Object {
+	out := ast.NewObject()
+	obj.Foreach(func(k, v *ast.Term) {
+		if s, ok := k.Value.(ast.String); ok && strings.HasPrefix(string(s), "[") {
+			var x interface{}
+			if err := util.UnmarshalJSON([]byte(s), &x); err == nil {
+				if arr, err := ast.InterfaceToValue(x); err == nil {
+					k = ast.NewTerm(arr)
+				}
+			}
+		}
+		out.Insert(k, v)
+	})
+	return out
+}
+
 // ErrOperand represents an invalid operand has been passed to a built-in
 // function. Built-ins should return ErrOperand to indicate a type error has
 // occurred.
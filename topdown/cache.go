@@ -11,6 +11,7 @@ import (
 
 type virtualCache struct {
 	stack []*virtualCacheElem
+	base  *virtualCache
 }
 
 type virtualCacheElem struct {
@@ -25,6 +26,15 @@ func newVirtualCache() *virtualCache {
 	return cache
 }
 
+// fork returns a cache that reads through to the values in c. Values put into
+// the returned cache are not visible in c. The returned cache may be used
+// concurrently with other forks as long as c is not modified.
+func (c *virtualCache) fork() *virtualCache {
+	cache := newVirtualCache()
+	cache.base = c
+	return cache
+}
+
 func (c *virtualCache) Push() {
 	c.stack = append(c.stack, newVirtualCacheElem())
 }
@@ -45,6 +55,11 @@ func (c *virtualCache) Get(ref ast.Ref) (*ast.Term, bool) {
 	for i := 0; i < len(ref); i++ {
 		x, ok := node.children.Get(ref[i])
 		if !ok {
+			// Values in the base cache are only valid if no 'with' modifiers
+			// have been applied since the fork.
+			if c.base != nil && len(c.stack) == 1 {
+				return c.base.Get(ref)
+			}
 			return nil, false
 		}
 		node = x.(*virtualCacheElem)
@@ -166,6 +181,10 @@ func (s *refStack) Push(refs []ast.Ref) {
 	s.sl = append(s.sl, refStackElem{refs: refs})
 }
 
+func (s *refStack) copy() *refStack {
+	return &refStack{sl: append([]refStackElem(nil), s.sl...)}
+}
+
 func (s *refStack) Pop() {
 	s.sl = s.sl[:len(s.sl)-1]
 }
@@ -276,6 +295,15 @@ func (s *functionMocksStack) Push() {
 	s.stack = append(s.stack, newFunctionMocksElem())
 }
 
+func (s *functionMocksStack) copy() *functionMocksStack {
+	cpy := &functionMocksStack{stack: make([]*functionMocksElem, len(s.stack))}
+	for i := range s.stack {
+		elem := append(functionMocksElem(nil), *s.stack[i]...)
+		cpy.stack[i] = &elem
+	}
+	return cpy
+}
+
 func (s *functionMocksStack) Pop() {
 	s.stack = s.stack[:len(s.stack)-1]
 }
@@ -307,3 +335,13 @@ func (s *functionMocksStack) Get(f ast.Ref) (*ast.Term, bool) {
 	}
 	return nil, false
 }
+
+// Empty returns true if no functions are currently replaced.
+func (s *functionMocksStack) Empty() bool {
+	for _, f := range *s.stack[len(s.stack)-1] {
+		if len(f) > 0 {
+			return false
+		}
+	}
+	return true
+}
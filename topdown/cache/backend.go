@@ -0,0 +1,274 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+)
+
+// MemoryBackendType is the type of the default backend that keeps the cache
+// entries in the memory of the OPA instance.
+const MemoryBackendType = "memory"
+
+// InterQueryCacheBackendErrors is the name of the counter of failed backend
+// operations maintained by inter-query caches that use a Backend.
+const InterQueryCacheBackendErrors = "inter_query_builtin_cache_backend_errors"
+
+// Backend defines the interface for stores of serialized inter-query cache
+// entries. Unlike the default in-memory cache, a Backend can be shared by
+// several OPA instances. Keys are SHA-256 hashes of the cache keys, which may
+// hold secrets like the headers of http.send requests. Values are stored as
+// is, i.e., unencrypted.
+type Backend interface {
+	// Get returns the value stored for key. Expired entries must not be
+	// returned.
+	Get(ctx context.Context, key string) (value []byte, found bool, err error)
+	// Set stores value for key. If expiresAt is not zero, the entry may be
+	// removed once expiresAt has passed.
+	Set(ctx context.Context, key string, value []byte, expiresAt time.Time) error
+	// Delete removes the value stored for key, if any.
+	Delete(ctx context.Context, key string) error
+	// Close releases the resources held by the backend.
+	Close() error
+}
+
+// BackendFactory creates a Backend from the backend-specific configuration,
+// i.e., the raw value of caching.inter_query_builtin_cache.backend.config.
+type BackendFactory func(ctx context.Context, config []byte) (Backend, error)
+
+var backends = struct {
+	sync.Mutex
+	m map[string]BackendFactory
+}{m: map[string]BackendFactory{}}
+
+// RegisterBackend registers a Backend factory for the backend type name. The
+// type can then be selected in the caching configuration. Backends are
+// usually registered in the init function of the package implementing them.
+func RegisterBackend(name string, f BackendFactory) {
+	backends.Lock()
+	defer backends.Unlock()
+	backends.m[name] = f
+}
+
+// RegisteredBackends returns the sorted names of the registered backend types.
+func RegisteredBackends() []string {
+	backends.Lock()
+	defer backends.Unlock()
+	names := make([]string, 0, len(backends.m)+1)
+	names = append(names, MemoryBackendType)
+	for name := range backends.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SerializableValue is implemented by inter-query cache values that can be
+// stored in a Backend. Values that do not implement it are dropped by caches
+// that use a Backend.
+type SerializableValue interface {
+	InterQueryCacheValue
+	Serialize() ([]byte, error)
+}
+
+// SerializedValue is a value read from a Backend. Data holds the bytes
+// returned by the Serialize method of the value that was inserted.
+type SerializedValue struct {
+	Data []byte
+}
+
+// SizeInBytes returns the size of the serialized value.
+func (v *SerializedValue) SizeInBytes() int64 {
+	return int64(len(v.Data))
+}
+
+// Serialize returns the serialized value.
+func (v *SerializedValue) Serialize() ([]byte, error) {
+	return v.Data, nil
+}
+
+// OpenInterQueryCache returns the inter-query cache selected by the backend
+// configuration. If no backend is configured, the cache is kept in memory (see
+// NewInterQueryCacheWithContext). The cache is closed when ctx is done.
+// Changes to the backend configuration passed to UpdateConfig only take effect
+// when the cache is opened again.
+func OpenInterQueryCache(ctx context.Context, config *Config, opts ...Option) (InterQueryCache, error) {
+
+	if config == nil || config.InterQueryBuiltinCache.Backend == nil || config.InterQueryBuiltinCache.Backend.Type == MemoryBackendType {
+		return NewInterQueryCacheWithContext(ctx, config, opts...), nil
+	}
+
+	bc := config.InterQueryBuiltinCache.Backend
+
+	backends.Lock()
+	f, ok := backends.m[bc.Type]
+	backends.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown inter-query cache backend type %q (registered types: %v)", bc.Type, RegisteredBackends())
+	}
+
+	backend, err := f(ctx, bc.Config)
+	if err != nil {
+		return nil, fmt.Errorf("inter-query cache backend %q: %w", bc.Type, err)
+	}
+
+	c := &backendCache{ctx: ctx, backend: backend, config: config, metrics: newOptions(opts).metrics}
+
+	go func() {
+		<-ctx.Done()
+		backend.Close()
+	}()
+
+	return c, nil
+}
+
+// backendCache is an inter-query cache that stores the serialized entries in
+// a Backend.
+type backendCache struct {
+	ctx     context.Context
+	backend Backend
+	config  *Config
+	metrics metrics.Metrics
+	mtx     sync.Mutex
+}
+
+func (c *backendCache) Get(k ast.Value) (InterQueryCacheValue, bool) {
+	bs, found, err := c.backend.Get(c.ctx, backendKey(k))
+	if err != nil {
+		c.incr(InterQueryCacheBackendErrors, 1)
+	}
+	if !found {
+		c.incr(InterQueryCacheMisses, 1)
+		return nil, false
+	}
+	c.incr(InterQueryCacheHits, 1)
+	return &SerializedValue{Data: bs}, true
+}
+
+func (c *backendCache) Insert(k ast.Value, v InterQueryCacheValue) int {
+	return c.InsertWithExpiry(k, v, time.Time{})
+}
+
+func (c *backendCache) InsertWithExpiry(k ast.Value, v InterQueryCacheValue, expiresAt time.Time) (dropped int) {
+	sv, ok := v.(SerializableValue)
+	if !ok {
+		return 1
+	}
+
+	if limit := c.maxSizeBytes(); limit > 0 && sv.SizeInBytes() > limit {
+		return 1
+	}
+
+	bs, err := sv.Serialize()
+	if err != nil {
+		return 1
+	}
+
+	if err := c.backend.Set(c.ctx, backendKey(k), bs, expiresAt); err != nil {
+		c.incr(InterQueryCacheBackendErrors, 1)
+		return 1
+	}
+
+	return 0
+}
+
+func (c *backendCache) Delete(k ast.Value) {
+	if err := c.backend.Delete(c.ctx, backendKey(k)); err != nil {
+		c.incr(InterQueryCacheBackendErrors, 1)
+	}
+}
+
+// backendKey returns the hex encoded SHA-256 hash of k, so that the keys
+// passed to backends do not reveal, e.g., credentials of http.send requests.
+func backendKey(k ast.Value) string {
+	sum := sha256.Sum256([]byte(k.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *backendCache) UpdateConfig(config *Config) {
+	if config == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.config = config
+}
+
+func (c *backendCache) incr(name string, n int) {
+	if c.metrics == nil || n == 0 {
+		return
+	}
+	c.metrics.Counter(name).Add(uint64(n))
+}
+
+// maxSizeBytes returns the limit on the size of a single entry. The total size
+// of the entries is managed by the backend.
+func (c *backendCache) maxSizeBytes() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.config == nil {
+		return defaultMaxSizeBytes
+	}
+	return *c.config.InterQueryBuiltinCache.MaxSizeBytes
+}
+
+// NewMemoryBackend returns a Backend that keeps the entries in memory. It is
+// mostly useful to stand in for a shared backend, e.g., when serving the
+// remote backend protocol locally.
+func NewMemoryBackend() Backend {
+	return &memoryBackend{entries: map[string]memoryEntry{}}
+}
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+type memoryBackend struct {
+	entries map[string]memoryEntry
+	mtx     sync.Mutex
+}
+
+func (b *memoryBackend) Get(_ context.Context, key string) ([]byte, bool, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	e, ok := b.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !e.expiresAt.IsZero() && !time.Now().Before(e.expiresAt) {
+		delete(b.entries, key)
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (b *memoryBackend) Set(_ context.Context, key string, value []byte, expiresAt time.Time) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.entries[key] = memoryEntry{value: value, expiresAt: expiresAt}
+	return nil
+}
+
+func (b *memoryBackend) Delete(_ context.Context, key string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	delete(b.entries, key)
+	return nil
+}
+
+func (b *memoryBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+)
+
+func TestParseCachingConfigBackend(t *testing.T) {
+	config, err := ParseCachingConfig([]byte(`{"inter_query_builtin_cache": {"backend": {"type": "remote", "config": {"url": "http://localhost:8282"}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := config.InterQueryBuiltinCache.Backend
+	if backend == nil || backend.Type != "remote" || string(backend.Config) != `{"url": "http://localhost:8282"}` {
+		t.Fatalf("unexpected backend config: %+v", backend)
+	}
+
+	_, err = ParseCachingConfig([]byte(`{"inter_query_builtin_cache": {"backend": {}}}`))
+	if err == nil || err.Error() != "missing inter-query cache backend type" {
+		t.Fatalf("expected missing type error but got: %v", err)
+	}
+}
+
+func TestOpenInterQueryCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := OpenInterQueryCache(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.(*cache); !ok {
+		t.Fatalf("expected in-memory cache but got %T", c)
+	}
+
+	config, err := ParseCachingConfig([]byte(`{"inter_query_builtin_cache": {"backend": {"type": "unknown"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenInterQueryCache(ctx, config)
+	if err == nil || !strings.Contains(err.Error(), `unknown inter-query cache backend type "unknown"`) {
+		t.Fatalf("expected unknown backend error but got: %v", err)
+	}
+}
+
+type testBackend struct {
+	Backend
+	closed chan struct{}
+}
+
+func (b *testBackend) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestBackendCache(t *testing.T) {
+	backend := &testBackend{Backend: NewMemoryBackend(), closed: make(chan struct{})}
+
+	RegisterBackend("test", func(_ context.Context, config []byte) (Backend, error) {
+		if string(config) != `{"x": 1}` {
+			t.Fatalf("unexpected backend config: %s", config)
+		}
+		return backend, nil
+	})
+
+	config, err := ParseCachingConfig([]byte(`{"inter_query_builtin_cache": {"max_size_bytes": 10, "backend": {"type": "test", "config": {"x": 1}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := metrics.New()
+
+	c, err := OpenInterQueryCache(ctx, config, WithMetrics(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dropped := c.Insert(ast.String("foo"), &SerializedValue{Data: []byte("bar")}); dropped != 0 {
+		t.Fatalf("expected nothing to be dropped but got %d", dropped)
+	}
+
+	// the backend only sees hashes of the keys
+	sum := sha256.Sum256([]byte(`"foo"`))
+	if _, found, _ := backend.Get(ctx, hex.EncodeToString(sum[:])); !found {
+		t.Fatal("expected hashed key in backend")
+	}
+	if _, found, _ := backend.Get(ctx, `"foo"`); found {
+		t.Fatal("unexpected plain key in backend")
+	}
+
+	// values that cannot be serialized or exceed the size limit are dropped
+	if dropped := c.Insert(ast.String("foo2"), newInterQueryCacheValue(ast.String("bar2"), 1)); dropped != 1 {
+		t.Fatalf("expected value to be dropped but got %d", dropped)
+	}
+
+	if dropped := c.Insert(ast.String("foo3"), &SerializedValue{Data: []byte("01234567890")}); dropped != 1 {
+		t.Fatalf("expected value to be dropped but got %d", dropped)
+	}
+
+	c.InsertWithExpiry(ast.String("expired"), &SerializedValue{Data: []byte("bar")}, time.Now().Add(-time.Second))
+
+	value, found := c.Get(ast.String("foo"))
+	if !found || string(value.(*SerializedValue).Data) != "bar" {
+		t.Fatalf("expected key \"foo\" in cache but got %v", value)
+	}
+
+	for _, key := range []string{"foo2", "foo3", "expired"} {
+		if _, found := c.Get(ast.String(key)); found {
+			t.Fatalf("unexpected key %q in cache", key)
+		}
+	}
+
+	c.Delete(ast.String("foo"))
+
+	if _, found := c.Get(ast.String("foo")); found {
+		t.Fatal("unexpected key \"foo\" in cache")
+	}
+
+	if hits, misses := m.Counter(InterQueryCacheHits).Value(), m.Counter(InterQueryCacheMisses).Value(); hits != uint64(1) || misses != uint64(4) {
+		t.Fatalf("expected 1 hit and 4 misses but got %v and %v", hits, misses)
+	}
+
+	cancel()
+
+	select {
+	case <-backend.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected backend to be closed")
+	}
+}
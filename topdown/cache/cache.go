@@ -7,8 +7,13 @@ package cache
 
 import (
 	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
 
 	"sync"
 
@@ -16,17 +21,47 @@ import (
 )
 
 const (
-	defaultMaxSizeBytes = int64(0) // unlimited
+	defaultMaxSizeBytes                    = int64(0) // unlimited
+	defaultStaleEntryEvictionPeriodSeconds = int64(0) // never
+)
+
+// Names of the counters maintained by the inter-query cache when a metrics
+// provider is set.
+const (
+	InterQueryCacheHits      = "inter_query_builtin_cache_hits"
+	InterQueryCacheMisses    = "inter_query_builtin_cache_misses"
+	InterQueryCacheEvictions = "inter_query_builtin_cache_evictions"
 )
 
 // Config represents the configuration of the inter-query cache.
 type Config struct {
 	InterQueryBuiltinCache InterQueryBuiltinCacheConfig `json:"inter_query_builtin_cache"`
+	RuleCache              RuleCacheConfig              `json:"rule_cache"`
 }
 
 // InterQueryBuiltinCacheConfig represents the configuration of the inter-query cache that built-in functions can utilize.
+// MaxSizeBytes - max capacity of cache in bytes
+// StaleEntryEvictionPeriodSeconds - period in seconds at which expired entries are removed from the cache (0 disables removal)
+// Backend - store holding the cache entries (defaults to the memory of the OPA instance)
 type InterQueryBuiltinCacheConfig struct {
-	MaxSizeBytes *int64 `json:"max_size_bytes,omitempty"`
+	MaxSizeBytes                    *int64         `json:"max_size_bytes,omitempty"`
+	StaleEntryEvictionPeriodSeconds *int64         `json:"stale_entry_eviction_period_seconds,omitempty"`
+	Backend                         *BackendConfig `json:"backend,omitempty"`
+}
+
+// RuleCacheConfig represents the configuration of the cache of rule values
+// held in the inter-query cache (see topdown.RuleCache).
+// Enabled - whether the values of rules annotated with 'cache: true' are cached across queries
+type RuleCacheConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// BackendConfig selects the Backend of the inter-query cache. Config holds the
+// backend-specific configuration passed to the BackendFactory registered for
+// Type.
+type BackendConfig struct {
+	Type   string          `json:"type"`
+	Config json.RawMessage `json:"config,omitempty"`
 }
 
 // ParseCachingConfig returns the config for the inter-query cache.
@@ -34,7 +69,9 @@ func ParseCachingConfig(raw []byte) (*Config, error) {
 	if raw == nil {
 		maxSize := new(int64)
 		*maxSize = defaultMaxSizeBytes
-		return &Config{InterQueryBuiltinCache: InterQueryBuiltinCacheConfig{MaxSizeBytes: maxSize}}, nil
+		period := new(int64)
+		*period = defaultStaleEntryEvictionPeriodSeconds
+		return &Config{InterQueryBuiltinCache: InterQueryBuiltinCacheConfig{MaxSizeBytes: maxSize, StaleEntryEvictionPeriodSeconds: period}}, nil
 	}
 
 	var config Config
@@ -56,6 +93,16 @@ func (c *Config) validateAndInjectDefaults() error {
 		*maxSize = defaultMaxSizeBytes
 		c.InterQueryBuiltinCache.MaxSizeBytes = maxSize
 	}
+	if c.InterQueryBuiltinCache.StaleEntryEvictionPeriodSeconds == nil {
+		period := new(int64)
+		*period = defaultStaleEntryEvictionPeriodSeconds
+		c.InterQueryBuiltinCache.StaleEntryEvictionPeriodSeconds = period
+	} else if *c.InterQueryBuiltinCache.StaleEntryEvictionPeriodSeconds < 0 {
+		return fmt.Errorf("invalid stale_entry_eviction_period_seconds %v", *c.InterQueryBuiltinCache.StaleEntryEvictionPeriodSeconds)
+	}
+	if c.InterQueryBuiltinCache.Backend != nil && c.InterQueryBuiltinCache.Backend.Type == "" {
+		return fmt.Errorf("missing inter-query cache backend type")
+	}
 	return nil
 }
 
@@ -68,49 +115,111 @@ type InterQueryCacheValue interface {
 type InterQueryCache interface {
 	Get(key ast.Value) (value InterQueryCacheValue, found bool)
 	Insert(key ast.Value, value InterQueryCacheValue) int
+	InsertWithExpiry(key ast.Value, value InterQueryCacheValue, expiresAt time.Time) int
 	Delete(key ast.Value)
 	UpdateConfig(config *Config)
 }
 
-// NewInterQueryCache returns a new inter-query cache.
-func NewInterQueryCache(config *Config) InterQueryCache {
+// Option configures an inter-query cache.
+type Option func(*options)
+
+type options struct {
+	metrics metrics.Metrics
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithMetrics sets the metrics provider on which the cache counts hits,
+// misses and evictions.
+func WithMetrics(m metrics.Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// NewInterQueryCache returns a new inter-query cache. Expired entries are only
+// removed from the cache when its size limit is reached.
+func NewInterQueryCache(config *Config, opts ...Option) InterQueryCache {
+	return newCache(config, opts...)
+}
+
+// NewInterQueryCacheWithContext returns a new inter-query cache that
+// periodically removes expired entries in the background until ctx is done.
+// The period is controlled by the stale_entry_eviction_period_seconds
+// configuration option.
+func NewInterQueryCacheWithContext(ctx context.Context, config *Config, opts ...Option) InterQueryCache {
+	c := newCache(config, opts...)
+	c.ctx = ctx
+	c.mtx.Lock()
+	c.unsafeStartStaleEntryEviction()
+	c.mtx.Unlock()
+	return c
+}
+
+func newCache(config *Config, opts ...Option) *cache {
 	return &cache{
-		items:  map[string]cacheItem{},
-		usage:  0,
-		config: config,
-		l:      list.New(),
+		items:         map[string]cacheItem{},
+		usage:         0,
+		config:        config,
+		l:             list.New(),
+		metrics:       newOptions(opts).metrics,
+		configChanged: make(chan struct{}, 1),
 	}
 }
 
 type cacheItem struct {
 	value      InterQueryCacheValue
+	expiresAt  time.Time
 	keyElement *list.Element
 }
 
+// cache is an LRU cache: c.l holds the keys ordered from the least to the
+// most recently used.
 type cache struct {
-	items  map[string]cacheItem
-	usage  int64
-	config *Config
-	l      *list.List
-	mtx    sync.Mutex
+	items         map[string]cacheItem
+	usage         int64
+	config        *Config
+	l             *list.List
+	mtx           sync.Mutex
+	metrics       metrics.Metrics
+	ctx           context.Context // set if stale entries are evicted in the background
+	evicting      bool
+	configChanged chan struct{}
 }
 
-// Insert inserts a key k into the cache with value v.
+// Insert inserts a key k into the cache with value v. The entry never expires.
 func (c *cache) Insert(k ast.Value, v InterQueryCacheValue) (dropped int) {
+	return c.InsertWithExpiry(k, v, time.Time{})
+}
+
+// InsertWithExpiry inserts a key k into the cache with value v. The entry is
+// removed by the background eviction once expiresAt has passed. If expiresAt
+// is zero the entry never expires.
+func (c *cache) InsertWithExpiry(k ast.Value, v InterQueryCacheValue, expiresAt time.Time) (dropped int) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
-	return c.unsafeInsert(k, v)
+	return c.unsafeInsert(k, v, expiresAt)
 }
 
-// Get returns the value in the cache for k.
+// Get returns the value in the cache for k and marks it as the most recently
+// used entry.
 func (c *cache) Get(k ast.Value) (InterQueryCacheValue, bool) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 	cacheItem, ok := c.unsafeGet(k)
 
 	if ok {
+		c.l.MoveToBack(cacheItem.keyElement)
+		c.incr(InterQueryCacheHits, 1)
 		return cacheItem.value, true
 	}
+	c.incr(InterQueryCacheMisses, 1)
 	return nil, false
 }
 
@@ -126,11 +235,18 @@ func (c *cache) UpdateConfig(config *Config) {
 		return
 	}
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
 	c.config = config
+	c.unsafeStartStaleEntryEviction()
+	c.mtx.Unlock()
+
+	// Wake up the background eviction so that it picks up the new period.
+	select {
+	case c.configChanged <- struct{}{}:
+	default:
+	}
 }
 
-func (c *cache) unsafeInsert(k ast.Value, v InterQueryCacheValue) (dropped int) {
+func (c *cache) unsafeInsert(k ast.Value, v InterQueryCacheValue, expiresAt time.Time) (dropped int) {
 	size := v.SizeInBytes()
 	limit := c.maxSizeBytes()
 
@@ -145,6 +261,7 @@ func (c *cache) unsafeInsert(k ast.Value, v InterQueryCacheValue) (dropped int)
 			c.unsafeDelete(dropKey)
 			dropped++
 		}
+		c.incr(InterQueryCacheEvictions, dropped)
 	}
 
 	// By deleting the old value, if it exists, we ensure the usage variable stays correct
@@ -152,6 +269,7 @@ func (c *cache) unsafeInsert(k ast.Value, v InterQueryCacheValue) (dropped int)
 
 	c.items[k.String()] = cacheItem{
 		value:      v,
+		expiresAt:  expiresAt,
 		keyElement: c.l.PushBack(k),
 	}
 	c.usage += size
@@ -174,9 +292,79 @@ func (c *cache) unsafeDelete(k ast.Value) {
 	c.l.Remove(cacheItem.keyElement)
 }
 
+// removeStaleEntries removes the entries that expired before now and returns
+// the number of entries removed.
+func (c *cache) removeStaleEntries(now time.Time) (removed int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for e := c.l.Front(); e != nil; {
+		next := e.Next()
+		key := e.Value.(ast.Value)
+		if item, ok := c.unsafeGet(key); ok && !item.expiresAt.IsZero() && item.expiresAt.Before(now) {
+			c.unsafeDelete(key)
+			removed++
+		}
+		e = next
+	}
+
+	c.incr(InterQueryCacheEvictions, removed)
+	return removed
+}
+
+// unsafeStartStaleEntryEviction starts the background eviction if it is
+// enabled and not running yet.
+func (c *cache) unsafeStartStaleEntryEviction() {
+	if c.ctx == nil || c.evicting || c.unsafeStaleEntryEvictionPeriod() <= 0 {
+		return
+	}
+	c.evicting = true
+	go c.evictStaleEntries(c.ctx)
+}
+
+// evictStaleEntries removes expired entries periodically until ctx is done or
+// the eviction is disabled by a configuration update.
+func (c *cache) evictStaleEntries(ctx context.Context) {
+	for {
+		c.mtx.Lock()
+		period := c.unsafeStaleEntryEvictionPeriod()
+		if period <= 0 || ctx.Err() != nil {
+			c.evicting = false
+			c.mtx.Unlock()
+			return
+		}
+		c.mtx.Unlock()
+
+		timer := time.NewTimer(period)
+
+		select {
+		case <-ctx.Done():
+		case <-c.configChanged:
+		case now := <-timer.C:
+			c.removeStaleEntries(now)
+		}
+
+		timer.Stop()
+	}
+}
+
+func (c *cache) incr(name string, n int) {
+	if c.metrics == nil || n == 0 {
+		return
+	}
+	c.metrics.Counter(name).Add(uint64(n))
+}
+
 func (c *cache) maxSizeBytes() int64 {
 	if c.config == nil {
 		return defaultMaxSizeBytes
 	}
 	return *c.config.InterQueryBuiltinCache.MaxSizeBytes
 }
+
+func (c *cache) unsafeStaleEntryEvictionPeriod() time.Duration {
+	if c.config == nil || c.config.InterQueryBuiltinCache.StaleEntryEvictionPeriodSeconds == nil {
+		return time.Duration(defaultStaleEntryEvictionPeriodSeconds) * time.Second
+	}
+	return time.Duration(*c.config.InterQueryBuiltinCache.StaleEntryEvictionPeriodSeconds) * time.Second
+}
@@ -5,17 +5,22 @@
 package cache
 
 import (
+	"context"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
 )
 
 func TestParseCachingConfig(t *testing.T) {
 	maxSize := new(int64)
 	*maxSize = defaultMaxSizeBytes
-	expected := &Config{InterQueryBuiltinCache: InterQueryBuiltinCacheConfig{MaxSizeBytes: maxSize}}
+	period := new(int64)
+	*period = defaultStaleEntryEvictionPeriodSeconds
+	expected := &Config{InterQueryBuiltinCache: InterQueryBuiltinCacheConfig{MaxSizeBytes: maxSize, StaleEntryEvictionPeriodSeconds: period}}
 
 	tests := map[string]struct {
 		input   []byte
@@ -33,6 +38,10 @@ func TestParseCachingConfig(t *testing.T) {
 			input:   []byte(`{"inter_query_builtin_cache": {"max_size_bytes": "100"},}`),
 			wantErr: true,
 		},
+		"bad_eviction_period": {
+			input:   []byte(`{"inter_query_builtin_cache": {"stale_entry_eviction_period_seconds": -1},}`),
+			wantErr: true,
+		},
 	}
 
 	for name, tc := range tests {
@@ -69,6 +78,20 @@ func TestParseCachingConfig(t *testing.T) {
 	if !reflect.DeepEqual(config, expected) {
 		t.Fatalf("want %v got %v", expected, config)
 	}
+
+	// eviction period specified
+	in = `{"inter_query_builtin_cache": {"max_size_bytes": 100, "stale_entry_eviction_period_seconds": 60},}`
+
+	config, err = ParseCachingConfig([]byte(in))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	*period = 60
+
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("want %v got %v", expected, config)
+	}
 }
 
 func TestInsert(t *testing.T) {
@@ -159,6 +182,118 @@ func TestInsert(t *testing.T) {
 	}
 }
 
+func TestInsertEvictsLeastRecentlyUsed(t *testing.T) {
+	in := `{"inter_query_builtin_cache": {"max_size_bytes": 20},}` // 20 byte limit for test purposes
+
+	config, err := ParseCachingConfig([]byte(in))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	m := metrics.New()
+	cache := NewInterQueryCache(config, WithMetrics(m))
+
+	cache.Insert(ast.String("foo"), newInterQueryCacheValue(ast.String("bar"), 10))
+	cache.Insert(ast.String("foo2"), newInterQueryCacheValue(ast.String("bar2"), 10))
+
+	// "foo" becomes the most recently used entry so "foo2" is evicted
+	if _, found := cache.Get(ast.String("foo")); !found {
+		t.Fatal("Expected key \"foo\" in cache")
+	}
+
+	dropped := cache.Insert(ast.String("foo3"), newInterQueryCacheValue(ast.String("bar3"), 10))
+	if dropped != 1 {
+		t.Fatal("Expected dropped to be one")
+	}
+
+	if _, found := cache.Get(ast.String("foo2")); found {
+		t.Fatal("Unexpected key \"foo2\" in cache")
+	}
+
+	if _, found := cache.Get(ast.String("foo")); !found {
+		t.Fatal("Expected key \"foo\" in cache")
+	}
+	verifyCacheList(t, cache)
+
+	exp := map[string]uint64{
+		InterQueryCacheHits:      2,
+		InterQueryCacheMisses:    1,
+		InterQueryCacheEvictions: 1,
+	}
+
+	for name, value := range exp {
+		if v := m.Counter(name).Value(); v != value {
+			t.Errorf("Expected %v to be %v but got %v", name, value, v)
+		}
+	}
+}
+
+func TestRemoveStaleEntries(t *testing.T) {
+	m := metrics.New()
+	c := newCache(nil, WithMetrics(m))
+
+	now := time.Now()
+
+	c.InsertWithExpiry(ast.String("expired"), newInterQueryCacheValue(ast.String("bar"), 10), now.Add(-time.Second))
+	c.InsertWithExpiry(ast.String("fresh"), newInterQueryCacheValue(ast.String("bar"), 10), now.Add(time.Hour))
+	c.Insert(ast.String("forever"), newInterQueryCacheValue(ast.String("bar"), 10))
+
+	if removed := c.removeStaleEntries(now); removed != 1 {
+		t.Fatalf("Expected one entry to be removed but got %d", removed)
+	}
+
+	if _, found := c.Get(ast.String("expired")); found {
+		t.Fatal("Unexpected key \"expired\" in cache")
+	}
+
+	for _, key := range []string{"fresh", "forever"} {
+		if _, found := c.Get(ast.String(key)); !found {
+			t.Fatalf("Expected key %q in cache", key)
+		}
+	}
+
+	if c.usage != 20 {
+		t.Fatalf("Expected usage to be 20 but got %d", c.usage)
+	}
+	verifyCacheList(t, c)
+
+	if v := m.Counter(InterQueryCacheEvictions).Value(); v != uint64(1) {
+		t.Fatalf("Expected one eviction but got %v", v)
+	}
+}
+
+func TestNewInterQueryCacheWithContext(t *testing.T) {
+	in := `{"inter_query_builtin_cache": {"stale_entry_eviction_period_seconds": 1},}`
+
+	config, err := ParseCachingConfig([]byte(in))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache := NewInterQueryCacheWithContext(ctx, config)
+
+	cache.InsertWithExpiry(ast.String("foo"), newInterQueryCacheValue(ast.String("bar"), 10), time.Now().Add(-time.Second))
+	cache.Insert(ast.String("foo2"), newInterQueryCacheValue(ast.String("bar2"), 10))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, found := cache.Get(ast.String("foo")); !found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected key \"foo\" to be removed from cache")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if _, found := cache.Get(ast.String("foo2")); !found {
+		t.Fatal("Expected key \"foo2\" in cache")
+	}
+}
+
 func TestConcurrentInsert(t *testing.T) {
 	in := `{"inter_query_builtin_cache": {"max_size_bytes": 20},}` // 20 byte limit for test purposes
 
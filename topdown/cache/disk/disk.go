@@ -0,0 +1,132 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package disk implements an inter-query cache backend that stores the cache
+// entries on disk using badger, the key-value store used by storage/disk. The
+// entries survive restarts of the OPA instance and can be shared by OPA
+// instances started one after another on the same host. A directory can only
+// be opened by one OPA instance at a time. The entries are not encrypted, so
+// the directory should only be readable by the OPA instance.
+package disk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+
+	"github.com/open-policy-agent/opa/topdown/cache"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// BackendType is the type that selects the disk backend in the caching
+// configuration.
+const BackendType = "disk"
+
+func init() {
+	cache.RegisterBackend(BackendType, func(_ context.Context, config []byte) (cache.Backend, error) {
+		c, err := ParseConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return Open(c)
+	})
+}
+
+// Config represents the configuration of the disk backend. Badger holds
+// badger-internal configurables in the same format as the storage/disk
+// configuration.
+type Config struct {
+	Directory string `json:"directory"`
+	Badger    string `json:"badger,omitempty"`
+}
+
+// ParseConfig validates the disk backend configuration.
+func ParseConfig(raw []byte) (*Config, error) {
+	var c Config
+
+	if raw != nil {
+		if err := util.Unmarshal(raw, &c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Directory == "" {
+		return nil, fmt.Errorf("missing directory")
+	}
+
+	return &c, nil
+}
+
+// Backend is a cache.Backend that stores the entries in a badger database.
+type Backend struct {
+	db *badger.DB
+}
+
+// Open opens the badger database in the configured directory, creating it if
+// needed.
+func Open(config *Config) (*Backend, error) {
+	opts := badger.DefaultOptions("").
+		FromSuperFlag(config.Badger).
+		WithDir(config.Directory).
+		WithValueDir(config.Directory).
+		WithLogger(nil)
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backend{db: db}, nil
+}
+
+// Get returns the entry stored for key.
+func (b *Backend) Get(_ context.Context, key string) (value []byte, found bool, err error) {
+	err = b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Set stores the entry for key. The entry is removed by badger once expiresAt
+// has passed.
+func (b *Backend) Set(ctx context.Context, key string, value []byte, expiresAt time.Time) error {
+	e := badger.NewEntry([]byte(key), value)
+
+	if !expiresAt.IsZero() {
+		ttl := time.Until(expiresAt)
+		if ttl <= 0 {
+			return b.Delete(ctx, key)
+		}
+		e = e.WithTTL(ttl)
+	}
+
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(e)
+	})
+}
+
+// Delete removes the entry for key.
+func (b *Backend) Delete(_ context.Context, key string) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(key))
+	})
+}
+
+// Close closes the badger database.
+func (b *Backend) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package disk
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/cache"
+)
+
+func TestParseConfig(t *testing.T) {
+	if _, err := ParseConfig([]byte(`{}`)); err == nil || err.Error() != "missing directory" {
+		t.Fatalf("expected missing directory error but got: %v", err)
+	}
+
+	c, err := ParseConfig([]byte(`{"directory": "/tmp/cache", "badger": "nummemtables=1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Directory != "/tmp/cache" || c.Badger != "nummemtables=1" {
+		t.Fatalf("unexpected config: %+v", c)
+	}
+}
+
+func TestBackend(t *testing.T) {
+	ctx := context.Background()
+
+	b, err := Open(&Config{Directory: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if _, found, err := b.Get(ctx, "foo"); err != nil || found {
+		t.Fatalf("expected no entry but got: %v, %v", found, err)
+	}
+
+	if err := b.Set(ctx, "foo", []byte("bar"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	value, found, err := b.Get(ctx, "foo")
+	if err != nil || !found || string(value) != "bar" {
+		t.Fatalf("expected entry but got: %q, %v, %v", value, found, err)
+	}
+
+	// setting an expired entry removes the existing one
+	if err := b.Set(ctx, "foo", []byte("bar"), time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found, err := b.Get(ctx, "foo"); err != nil || found {
+		t.Fatalf("expected no entry but got: %v, %v", found, err)
+	}
+
+	if err := b.Set(ctx, "foo", []byte("bar"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Delete(ctx, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found, err := b.Get(ctx, "foo"); err != nil || found {
+		t.Fatalf("expected no entry but got: %v, %v", found, err)
+	}
+}
+
+func TestOpenInterQueryCachePersists(t *testing.T) {
+	dir := t.TempDir()
+
+	config, err := cache.ParseCachingConfig([]byte(fmt.Sprintf(`{"inter_query_builtin_cache": {"backend": {"type": "disk", "config": {"directory": %q}}}}`, dir)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c, err := cache.OpenInterQueryCache(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Insert(ast.String("foo"), &cache.SerializedValue{Data: []byte("bar")})
+
+	cancel()
+
+	// wait for the database to be closed and open it again
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ctx, cancel = context.WithCancel(context.Background())
+		c, err = cache.OpenInterQueryCache(ctx, config)
+		if err == nil {
+			break
+		}
+		cancel()
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer cancel()
+
+	value, found := c.Get(ast.String("foo"))
+	if !found || string(value.(*cache.SerializedValue).Data) != "bar" {
+		t.Fatalf("expected key \"foo\" in cache but got %v", value)
+	}
+}
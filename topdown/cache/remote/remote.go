@@ -0,0 +1,256 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package remote implements an inter-query cache backend that stores the
+// cache entries on a server speaking a simple HTTP protocol. The protocol
+// allows OPA instances to share the entries of their inter-query caches:
+//
+//	GET    <url>/v1/entries/<key>  returns the entry (200) or 404 if missing
+//	PUT    <url>/v1/entries/<key>  stores the request body as the entry (204)
+//	DELETE <url>/v1/entries/<key>  removes the entry (204)
+//
+// Keys are encoded with unpadded base64url. If the entry expires, the PUT
+// request carries the expiry time in the Expires-At header (RFC 3339 format).
+// NewHandler serves the protocol for any cache.Backend and can be used to run
+// a local stand-in for the shared server.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/topdown/cache"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// BackendType is the type that selects the remote backend in the caching
+// configuration.
+const BackendType = "remote"
+
+const (
+	entriesPath     = "/v1/entries/"
+	expiresAtHeader = "Expires-At"
+
+	defaultTimeoutSeconds = 5
+)
+
+func init() {
+	cache.RegisterBackend(BackendType, func(_ context.Context, config []byte) (cache.Backend, error) {
+		c, err := ParseConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return New(c), nil
+	})
+}
+
+// Config represents the configuration of the remote backend.
+type Config struct {
+	URL            string            `json:"url"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	TimeoutSeconds *int64            `json:"timeout_seconds,omitempty"`
+}
+
+// ParseConfig validates the remote backend configuration and injects
+// defaults.
+func ParseConfig(raw []byte) (*Config, error) {
+	var c Config
+
+	if raw != nil {
+		if err := util.Unmarshal(raw, &c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.URL == "" {
+		return nil, fmt.Errorf("missing url")
+	}
+
+	if c.TimeoutSeconds == nil {
+		timeout := int64(defaultTimeoutSeconds)
+		c.TimeoutSeconds = &timeout
+	} else if *c.TimeoutSeconds <= 0 {
+		return nil, fmt.Errorf("invalid timeout_seconds %v", *c.TimeoutSeconds)
+	}
+
+	return &c, nil
+}
+
+// Backend is a cache.Backend client for a server speaking the remote backend
+// protocol.
+type Backend struct {
+	config *Config
+	client *http.Client
+}
+
+// New returns a new remote backend.
+func New(config *Config) *Backend {
+	return &Backend{
+		config: config,
+		client: &http.Client{Timeout: time.Duration(*config.TimeoutSeconds) * time.Second},
+	}
+}
+
+// Get returns the entry stored on the server for key.
+func (b *Backend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		bs, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, false, err
+		}
+		return bs, true, nil
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+}
+
+// Set stores the entry for key on the server.
+func (b *Backend) Set(ctx context.Context, key string, value []byte, expiresAt time.Time) error {
+	var headers map[string]string
+	if !expiresAt.IsZero() {
+		headers = map[string]string{expiresAtHeader: expiresAt.UTC().Format(time.RFC3339Nano)}
+	}
+	return b.doExpectNoContent(ctx, http.MethodPut, key, value, headers)
+}
+
+// Delete removes the entry for key from the server.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	return b.doExpectNoContent(ctx, http.MethodDelete, key, nil, nil)
+}
+
+// Close releases the idle connections to the server.
+func (b *Backend) Close() error {
+	b.client.CloseIdleConnections()
+	return nil
+}
+
+func (b *Backend) doExpectNoContent(ctx context.Context, method, key string, body []byte, headers map[string]string) error {
+	resp, err := b.do(ctx, method, key, body, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (b *Backend) do(ctx context.Context, method, key string, body []byte, headers map[string]string) (*http.Response, error) {
+	url := strings.TrimSuffix(b.config.URL, "/") + entriesPath + encodeKey(key)
+
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range b.config.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	return b.client.Do(req)
+}
+
+// NewHandler returns an http.Handler serving the remote backend protocol for
+// the entries stored in backend.
+func NewHandler(backend cache.Backend) http.Handler {
+	return &handler{backend: backend}
+}
+
+type handler struct {
+	backend cache.Backend
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if !strings.HasPrefix(r.URL.Path, entriesPath) {
+		http.NotFound(w, r)
+		return
+	}
+
+	key, err := decodeKey(strings.TrimPrefix(r.URL.Path, entriesPath))
+	if err != nil {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		value, found, err := h.backend.Get(r.Context(), key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(value)
+
+	case http.MethodPut:
+		var expiresAt time.Time
+		if s := r.Header.Get(expiresAtHeader); s != "" {
+			expiresAt, err = time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				http.Error(w, "invalid "+expiresAtHeader+" header", http.StatusBadRequest)
+				return
+			}
+		}
+		value, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.backend.Set(r.Context(), key, value, expiresAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		if err := h.backend.Delete(r.Context(), key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func encodeKey(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeKey(s string) (string, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	return string(bs), err
+}
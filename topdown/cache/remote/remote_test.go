@@ -0,0 +1,153 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/cache"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		note    string
+		config  string
+		wantErr string
+	}{
+		{note: "missing url", config: `{}`, wantErr: "missing url"},
+		{note: "bad timeout", config: `{"url": "http://localhost", "timeout_seconds": 0}`, wantErr: "invalid timeout_seconds 0"},
+		{note: "ok", config: `{"url": "http://localhost"}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			c, err := ParseConfig([]byte(tc.config))
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("expected error %q but got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *c.TimeoutSeconds != defaultTimeoutSeconds {
+				t.Fatalf("expected default timeout but got %v", *c.TimeoutSeconds)
+			}
+		})
+	}
+}
+
+func TestBackend(t *testing.T) {
+	var authorized bool
+	handler := NewHandler(cache.NewMemoryBackend())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorized = r.Header.Get("Authorization") == "Bearer secret"
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	config, err := ParseConfig([]byte(fmt.Sprintf(`{"url": %q, "headers": {"Authorization": "Bearer secret"}}`, ts.URL+"/")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	b := New(config)
+
+	// keys are arbitrary strings
+	key := ast.MustParseTerm(`{"url": "https://example.com/a?b=c", "method": "get"}`).String()
+
+	if _, found, err := b.Get(ctx, key); err != nil || found {
+		t.Fatalf("expected no entry but got: %v, %v", found, err)
+	}
+
+	if err := b.Set(ctx, key, []byte("value"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !authorized {
+		t.Fatal("expected configured headers to be sent")
+	}
+
+	value, found, err := b.Get(ctx, key)
+	if err != nil || !found || string(value) != "value" {
+		t.Fatalf("expected entry but got: %q, %v, %v", value, found, err)
+	}
+
+	if err := b.Set(ctx, "expired", []byte("value"), time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found, err := b.Get(ctx, "expired"); err != nil || found {
+		t.Fatalf("expected no entry but got: %v, %v", found, err)
+	}
+
+	if err := b.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found, err := b.Get(ctx, key); err != nil || found {
+		t.Fatalf("expected no entry but got: %v, %v", found, err)
+	}
+}
+
+func TestBackendErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	config, err := ParseConfig([]byte(fmt.Sprintf(`{"url": %q}`, ts.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(config)
+
+	if _, _, err := b.Get(context.Background(), "foo"); err == nil || err.Error() != "unexpected status code 500" {
+		t.Fatalf("expected error but got: %v", err)
+	}
+
+	if err := b.Set(context.Background(), "foo", nil, time.Time{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestOpenInterQueryCache(t *testing.T) {
+	ts := httptest.NewServer(NewHandler(cache.NewMemoryBackend()))
+	defer ts.Close()
+
+	config, err := cache.ParseCachingConfig([]byte(fmt.Sprintf(`{"inter_query_builtin_cache": {"backend": {"type": "remote", "config": {"url": %q}}}}`, ts.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c1, err := cache.OpenInterQueryCache(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c2, err := cache.OpenInterQueryCache(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c1.Insert(ast.String("foo"), &cache.SerializedValue{Data: []byte("bar")})
+
+	value, found := c2.Get(ast.String("foo"))
+	if !found || string(value.(*cache.SerializedValue).Data) != "bar" {
+		t.Fatalf("expected key \"foo\" to be shared but got %v", value)
+	}
+}
@@ -0,0 +1,554 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+// binaryMaxDepth bounds the nesting of arrays and maps in decoded CBOR and
+// MessagePack data.
+const binaryMaxDepth = 1000
+
+var errBinaryEOF = fmt.Errorf("unexpected end of data")
+
+// binaryOperand returns the bytes of a base64 encoded string operand.
+func binaryOperand(operand ast.Value, pos int) ([]byte, error) {
+	str, err := builtins.StringOperand(operand, pos)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(string(str))
+}
+
+// byteStringEncoding is how byte strings are represented as Rego strings.
+// By default, they are base64url encoded without padding, following
+// RFC 8949, section 6.1.
+type byteStringEncoding int
+
+const (
+	base64URLEncoding byteStringEncoding = iota
+	base64Encoding
+	base16Encoding
+)
+
+func (e byteStringEncoding) encode(bs []byte) *ast.Term {
+	switch e {
+	case base64Encoding:
+		return ast.StringTerm(base64.StdEncoding.EncodeToString(bs))
+	case base16Encoding:
+		return ast.StringTerm(hex.EncodeToString(bs))
+	default:
+		return ast.StringTerm(base64.RawURLEncoding.EncodeToString(bs))
+	}
+}
+
+// binaryNumber classifies a number for the binary formats: integers are
+// returned as big.Int, other numbers as float64.
+func binaryNumber(n ast.Number) (*big.Int, float64, error) {
+	if i, ok := new(big.Int).SetString(string(n), 10); ok {
+		return i, 0, nil
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("number %v cannot be represented", n)
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1<<64 {
+		i, _ := big.NewFloat(f).Int(nil)
+		return i, 0, nil
+	}
+	return nil, f, nil
+}
+
+// CBOR major types.
+const (
+	cborUnsigned byte = iota
+	cborNegative
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+const cborIndefinite = 31
+
+type cborDecoder struct {
+	buf      []byte
+	depth    int
+	encoding byteStringEncoding
+}
+
+// decodeCBOR decodes a single CBOR data item (RFC 8949). Maps become objects,
+// byte strings become strings as described by byteStringEncoding, and tags
+// other than bignums and expected conversions are ignored.
+func decodeCBOR(bs []byte) (*ast.Term, error) {
+	d := &cborDecoder{buf: bs}
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+	if len(d.buf) > 0 {
+		return nil, fmt.Errorf("unexpected data after CBOR data item")
+	}
+	return v, nil
+}
+
+// head reads the initial byte and argument of a data item. For indefinite
+// lengths, indefinite is true and the argument is zero.
+func (d *cborDecoder) head() (major, info byte, arg uint64, indefinite bool, err error) {
+	if len(d.buf) == 0 {
+		return 0, 0, 0, false, errBinaryEOF
+	}
+	major, info = d.buf[0]>>5, d.buf[0]&0x1f
+	d.buf = d.buf[1:]
+
+	var n int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), false, nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	case info == cborIndefinite:
+		return major, info, 0, true, nil
+	default:
+		return 0, 0, 0, false, fmt.Errorf("invalid additional information %d", info)
+	}
+
+	if len(d.buf) < n {
+		return 0, 0, 0, false, errBinaryEOF
+	}
+	for _, b := range d.buf[:n] {
+		arg = arg<<8 | uint64(b)
+	}
+	d.buf = d.buf[n:]
+	return major, info, arg, false, nil
+}
+
+// isBreak consumes the "break" stop code that ends indefinite length items.
+func (d *cborDecoder) isBreak() (bool, error) {
+	if len(d.buf) == 0 {
+		return false, errBinaryEOF
+	}
+	if d.buf[0] == 0xff {
+		d.buf = d.buf[1:]
+		return true, nil
+	}
+	return false, nil
+}
+
+func (d *cborDecoder) value() (*ast.Term, error) {
+	major, info, arg, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if indefinite && (major < cborBytes || major == cborTag) {
+		return nil, fmt.Errorf("invalid indefinite length for major type %d", major)
+	}
+
+	switch major {
+	case cborUnsigned:
+		return ast.UIntNumberTerm(arg), nil
+
+	case cborNegative:
+		n := new(big.Int).SetUint64(arg)
+		return ast.NewTerm(ast.Number(n.Neg(n.Add(n, big.NewInt(1))).String())), nil
+
+	case cborBytes, cborText:
+		bs, err := d.str(major, arg, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		if major == cborBytes {
+			return d.encoding.encode(bs), nil
+		}
+		if !utf8.Valid(bs) {
+			return nil, fmt.Errorf("invalid UTF-8 in text string")
+		}
+		return ast.StringTerm(string(bs)), nil
+
+	case cborArray:
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+
+		var elems []*ast.Term
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite {
+				if done, err := d.isBreak(); err != nil {
+					return nil, err
+				} else if done {
+					break
+				}
+			}
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, v)
+		}
+		return ast.ArrayTerm(elems...), nil
+
+	case cborMap:
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+
+		obj := ast.NewObject()
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite {
+				if done, err := d.isBreak(); err != nil {
+					return nil, err
+				} else if done {
+					break
+				}
+			}
+			k, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			if obj.Get(k) != nil {
+				return nil, fmt.Errorf("duplicate map key %v", k)
+			}
+			obj.Insert(k, v)
+		}
+		return ast.NewTerm(obj), nil
+
+	case cborTag:
+		return d.tagged(arg)
+
+	default:
+		return d.simple(info, arg, indefinite)
+	}
+}
+
+func (d *cborDecoder) enter() error {
+	d.depth++
+	if d.depth > binaryMaxDepth {
+		return fmt.Errorf("data nested deeper than %d levels", binaryMaxDepth)
+	}
+	return nil
+}
+
+func (d *cborDecoder) leave() {
+	d.depth--
+}
+
+// str reads the content of a byte or text string. Indefinite length strings
+// are concatenated from definite length chunks of the same major type.
+func (d *cborDecoder) str(major byte, n uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		if n > uint64(len(d.buf)) {
+			return nil, errBinaryEOF
+		}
+		bs := d.buf[:n]
+		d.buf = d.buf[n:]
+		return bs, nil
+	}
+
+	var buf bytes.Buffer
+	for {
+		if done, err := d.isBreak(); err != nil {
+			return nil, err
+		} else if done {
+			return buf.Bytes(), nil
+		}
+		chunkMajor, _, chunkLen, chunkIndefinite, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if chunkMajor != major || chunkIndefinite {
+			return nil, fmt.Errorf("invalid chunk in indefinite length string")
+		}
+		chunk, err := d.str(major, chunkLen, false)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(chunk)
+	}
+}
+
+func (d *cborDecoder) tagged(tag uint64) (*ast.Term, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	switch tag {
+	case 2, 3:
+		// Bignums.
+		major, _, n, indefinite, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if major != cborBytes {
+			return nil, fmt.Errorf("invalid content of bignum tag")
+		}
+		bs, err := d.str(major, n, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		i := new(big.Int).SetBytes(bs)
+		if tag == 3 {
+			i.Neg(i.Add(i, big.NewInt(1)))
+		}
+		return ast.NewTerm(ast.Number(i.String())), nil
+
+	case 21, 22, 23:
+		// Expected conversions of the byte strings in the tagged item.
+		outer := d.encoding
+		d.encoding = byteStringEncoding(tag - 21)
+		defer func() { d.encoding = outer }()
+	}
+	return d.value()
+}
+
+func (d *cborDecoder) simple(info byte, arg uint64, indefinite bool) (*ast.Term, error) {
+	var f float64
+	switch {
+	case indefinite:
+		return nil, fmt.Errorf("unexpected break")
+	case info == 20:
+		return ast.BooleanTerm(false), nil
+	case info == 21:
+		return ast.BooleanTerm(true), nil
+	case info == 22 || info == 23:
+		// Both null and undefined are null.
+		return ast.NullTerm(), nil
+	case info == 25:
+		f = float16ToFloat64(uint16(arg))
+	case info == 26:
+		f = float64(math.Float32frombits(uint32(arg)))
+	case info == 27:
+		f = math.Float64frombits(arg)
+	default:
+		return nil, fmt.Errorf("unsupported simple value %d", arg)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("unsupported floating-point value %v", f)
+	}
+	return ast.FloatNumberTerm(f), nil
+}
+
+func float16ToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
+
+// float16Bits returns the half-precision representation of f, if it is
+// exact.
+func float16Bits(f float32) (uint16, bool) {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23&0xff) - 127
+	mant := bits & 0x7fffff
+
+	switch {
+	case bits&0x7fffffff == 0:
+		return sign, true
+	case exp >= -14 && exp <= 15:
+		if mant&0x1fff != 0 {
+			return 0, false
+		}
+		return sign | uint16(exp+15)<<10 | uint16(mant>>13), true
+	case exp >= -24 && exp < -14:
+		// Subnormal: the significand including the implicit bit, in units of
+		// 2^-24.
+		shift := uint(-exp - 1)
+		full := mant | 1<<23
+		if full&(1<<shift-1) != 0 {
+			return 0, false
+		}
+		return sign | uint16(full>>shift), true
+	}
+	return 0, false
+}
+
+// encodeCBOR encodes a value with the core deterministic encoding of
+// RFC 8949, section 4.2.1. Sets are encoded as arrays.
+func encodeCBOR(buf []byte, v ast.Value) ([]byte, error) {
+	switch v := v.(type) {
+	case ast.Null:
+		return append(buf, 0xf6), nil
+	case ast.Boolean:
+		if v {
+			return append(buf, 0xf5), nil
+		}
+		return append(buf, 0xf4), nil
+	case ast.Number:
+		return encodeCBORNumber(buf, v)
+	case ast.String:
+		buf = cborHead(buf, cborText, uint64(len(v)))
+		return append(buf, v...), nil
+	case *ast.Array:
+		buf = cborHead(buf, cborArray, uint64(v.Len()))
+		var err error
+		for i := 0; i < v.Len() && err == nil; i++ {
+			buf, err = encodeCBOR(buf, v.Elem(i).Value)
+		}
+		return buf, err
+	case ast.Set:
+		return encodeCBOR(buf, v.Sorted())
+	case ast.Object:
+		type entry struct{ key, value []byte }
+		entries := make([]entry, 0, v.Len())
+		err := v.Iter(func(k, val *ast.Term) error {
+			kb, err := encodeCBOR(nil, k.Value)
+			if err != nil {
+				return err
+			}
+			vb, err := encodeCBOR(nil, val.Value)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{kb, vb})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		buf = cborHead(buf, cborMap, uint64(len(entries)))
+		for _, e := range entries {
+			buf = append(append(buf, e.key...), e.value...)
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("%v cannot be encoded", ast.TypeName(v))
+}
+
+func encodeCBORNumber(buf []byte, n ast.Number) ([]byte, error) {
+	i, f, err := binaryNumber(n)
+	if err != nil {
+		return nil, err
+	}
+
+	if i == nil {
+		if f32 := float32(f); float64(f32) == f {
+			if h, ok := float16Bits(f32); ok {
+				return cborHead16(buf, h), nil
+			}
+			buf = append(buf, cborSimple<<5|26)
+			return appendUint32(buf, math.Float32bits(f32)), nil
+		}
+		buf = append(buf, cborSimple<<5|27)
+		return appendUint64(buf, math.Float64bits(f)), nil
+	}
+
+	major, tag := cborUnsigned, uint64(2)
+	if i.Sign() < 0 {
+		// Negative integers are encoded as -1 - n.
+		major, tag = cborNegative, 3
+		i = new(big.Int).Sub(big.NewInt(-1), i)
+	}
+	if i.IsUint64() {
+		return cborHead(buf, major, i.Uint64()), nil
+	}
+	buf = cborHead(buf, cborTag, tag)
+	bs := i.Bytes()
+	buf = cborHead(buf, cborBytes, uint64(len(bs)))
+	return append(buf, bs...), nil
+}
+
+func cborHead16(buf []byte, h uint16) []byte {
+	return append(buf, cborSimple<<5|25, byte(h>>8), byte(h))
+}
+
+// cborHead appends the initial byte and argument of a data item, in the
+// shortest form.
+func cborHead(buf []byte, major byte, arg uint64) []byte {
+	m := major << 5
+	switch {
+	case arg < 24:
+		return append(buf, m|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(buf, m|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return append(buf, m|25, byte(arg>>8), byte(arg))
+	case arg <= math.MaxUint32:
+		buf = append(buf, m|26)
+		return appendUint32(buf, uint32(arg))
+	}
+	buf = append(buf, m|27)
+	return appendUint64(buf, arg)
+}
+
+// appendUint32 appends a big-endian uint32.
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendUint64 appends a big-endian uint64.
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v>>32)), uint32(v))
+}
+
+func builtinCBORDecode(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	bs, err := binaryOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+	v, err := decodeCBOR(bs)
+	if err != nil {
+		return fmt.Errorf("invalid CBOR: %v", err)
+	}
+	return iter(v)
+}
+
+func builtinCBOREncode(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	bs, err := encodeCBOR(nil, operands[0].Value)
+	if err != nil {
+		return err
+	}
+	return iter(ast.StringTerm(base64.StdEncoding.EncodeToString(bs)))
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.CBORDecode.Name, builtinCBORDecode)
+	RegisterBuiltinFunc(ast.CBOREncode.Name, builtinCBOREncode)
+}
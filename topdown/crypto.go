@@ -6,21 +6,29 @@ package topdown
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/md5"
+	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"hash"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/jwx/buffer"
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
 	"github.com/open-policy-agent/opa/internal/jwx/jwk"
 	"github.com/open-policy-agent/opa/topdown/builtins"
 	"github.com/open-policy-agent/opa/util"
@@ -39,6 +47,18 @@ const (
 	// blockTypeRSAPrivateKey indicates this PEM block contains a RSA private key.
 	// Exported for tests.
 	blockTypePrivateKey = "PRIVATE KEY"
+	// blockTypeECPrivateKey indicates this PEM block contains an EC private key.
+	blockTypeECPrivateKey = "EC PRIVATE KEY"
+	// blockTypeECParameters indicates this PEM block contains EC parameters,
+	// which precede EC private keys generated by OpenSSL.
+	blockTypeECParameters = "EC PARAMETERS"
+	// blockTypeRSAPublicKey indicates this PEM block contains a RSA public key.
+	blockTypeRSAPublicKey = "RSA PUBLIC KEY"
+	// blockTypePublicKey indicates this PEM block contains a PKIX public key.
+	blockTypePublicKey = "PUBLIC KEY"
+	// blockTypeCRL indicates this PEM block contains a certificate revocation
+	// list.
+	blockTypeCRL = "X509 CRL"
 )
 
 func builtinCryptoX509ParseCertificates(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
@@ -96,6 +116,51 @@ func builtinCryptoX509ParseAndVerifyCertificates(_ BuiltinContext, operands []*a
 	return iter(valid)
 }
 
+func builtinCryptoX509ParseAndVerifyCertificatesWithOptions(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+
+	input, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	obj, err := builtins.ObjectOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	options, err := parseX509VerifyOptions(obj, getCurrentTime(bctx))
+	if err != nil {
+		return err
+	}
+
+	invalid := ast.ArrayTerm(
+		ast.BooleanTerm(false),
+		ast.NewTerm(ast.NewArray()),
+	)
+
+	certs, err := getX509CertsFromString(string(input))
+	if err != nil {
+		return iter(invalid)
+	}
+
+	verified, err := options.verify(certs)
+	if err != nil {
+		return iter(invalid)
+	}
+
+	value, err := ast.InterfaceToValue(verified)
+	if err != nil {
+		return err
+	}
+
+	valid := ast.ArrayTerm(
+		ast.BooleanTerm(true),
+		ast.NewTerm(value),
+	)
+
+	return iter(valid)
+}
+
 func builtinCryptoX509ParseCertificateRequest(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
 	input, err := builtins.StringOperand(operands[0].Value, 1)
 	if err != nil {
@@ -181,6 +246,35 @@ func builtinCryptoX509ParseRSAPrivateKey(_ BuiltinContext, operands []*ast.Term,
 	return iter(ast.NewTerm(value))
 }
 
+func builtinCryptoParseKeys(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	input, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	keys, err := getJWKsFromString(string(input))
+	if err != nil {
+		return err
+	}
+
+	bs, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	var x interface{}
+	if err := util.UnmarshalJSON(bs, &x); err != nil {
+		return err
+	}
+
+	value, err := ast.InterfaceToValue(x)
+	if err != nil {
+		return err
+	}
+
+	return iter(ast.NewTerm(value))
+}
+
 func hashHelper(a ast.Value, h func(ast.String) string) (ast.Value, error) {
 	s, err := builtins.StringOperand(a, 1)
 	if err != nil {
@@ -252,11 +346,13 @@ func builtinCryptoHmacSha512(_ BuiltinContext, operands []*ast.Term, iter func(*
 func init() {
 	RegisterBuiltinFunc(ast.CryptoX509ParseCertificates.Name, builtinCryptoX509ParseCertificates)
 	RegisterBuiltinFunc(ast.CryptoX509ParseAndVerifyCertificates.Name, builtinCryptoX509ParseAndVerifyCertificates)
+	RegisterBuiltinFunc(ast.CryptoX509ParseAndVerifyCertificatesWithOptions.Name, builtinCryptoX509ParseAndVerifyCertificatesWithOptions)
 	RegisterBuiltinFunc(ast.CryptoMd5.Name, builtinCryptoMd5)
 	RegisterBuiltinFunc(ast.CryptoSha1.Name, builtinCryptoSha1)
 	RegisterBuiltinFunc(ast.CryptoSha256.Name, builtinCryptoSha256)
 	RegisterBuiltinFunc(ast.CryptoX509ParseCertificateRequest.Name, builtinCryptoX509ParseCertificateRequest)
 	RegisterBuiltinFunc(ast.CryptoX509ParseRSAPrivateKey.Name, builtinCryptoX509ParseRSAPrivateKey)
+	RegisterBuiltinFunc(ast.CryptoParseKeys.Name, builtinCryptoParseKeys)
 	RegisterBuiltinFunc(ast.CryptoHmacMd5.Name, builtinCryptoHmacMd5)
 	RegisterBuiltinFunc(ast.CryptoHmacSha1.Name, builtinCryptoHmacSha1)
 	RegisterBuiltinFunc(ast.CryptoHmacSha256.Name, builtinCryptoHmacSha256)
@@ -294,6 +390,263 @@ func verifyX509CertificateChain(certs []*x509.Certificate) ([]*x509.Certificate,
 	return chains[0], nil
 }
 
+// x509VerifyOptions holds decoded certificate chain verification options.
+type x509VerifyOptions struct {
+	// The trusted roots. If nil, the first certificate of the chain is the
+	// root.
+	roots []*x509.Certificate
+
+	// Additional intermediates to build the chain with.
+	intermediates []*x509.Certificate
+
+	// The extended key usages the leaf must be valid for. If empty, server
+	// authentication is required.
+	keyUsages []x509.ExtKeyUsage
+
+	// The DNS name the leaf must be valid for, if not "".
+	dnsName string
+
+	// The URI SAN the leaf must have, if not "".
+	uri string
+
+	// The time to verify the chain at.
+	time time.Time
+
+	// The revocation lists to check the chain against.
+	crls []*pkix.CertificateList
+}
+
+// x509VerifyOptionHandler is the handler type for certificate chain
+// verification options.
+type x509VerifyOptionHandler func(value ast.Value, options *x509VerifyOptions) error
+
+// x509VerifyOptionTypes maps known certificate chain verification options to
+// handlers.
+var x509VerifyOptionTypes = map[string]x509VerifyOptionHandler{
+	"roots": func(value ast.Value, options *x509VerifyOptions) (err error) {
+		options.roots, err = x509VerifyOptionCerts("roots", value)
+		return err
+	},
+	"intermediates": func(value ast.Value, options *x509VerifyOptions) (err error) {
+		options.intermediates, err = x509VerifyOptionCerts("intermediates", value)
+		return err
+	},
+	"key_usages": x509VerifyOptionKeyUsages,
+	"dns_name": func(value ast.Value, options *x509VerifyOptions) error {
+		return x509VerifyOptionString("dns_name", value, &options.dnsName)
+	},
+	"uri": func(value ast.Value, options *x509VerifyOptions) error {
+		return x509VerifyOptionString("uri", value, &options.uri)
+	},
+	"time": x509VerifyOptionTime,
+	"crls": x509VerifyOptionCRLs,
+}
+
+// x509ExtKeyUsages maps the names of extended key usages to their values.
+var x509ExtKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":                               x509.ExtKeyUsageAny,
+	"server_auth":                       x509.ExtKeyUsageServerAuth,
+	"client_auth":                       x509.ExtKeyUsageClientAuth,
+	"code_signing":                      x509.ExtKeyUsageCodeSigning,
+	"email_protection":                  x509.ExtKeyUsageEmailProtection,
+	"ipsec_end_system":                  x509.ExtKeyUsageIPSECEndSystem,
+	"ipsec_tunnel":                      x509.ExtKeyUsageIPSECTunnel,
+	"ipsec_user":                        x509.ExtKeyUsageIPSECUser,
+	"time_stamping":                     x509.ExtKeyUsageTimeStamping,
+	"ocsp_signing":                      x509.ExtKeyUsageOCSPSigning,
+	"microsoft_server_gated_crypto":     x509.ExtKeyUsageMicrosoftServerGatedCrypto,
+	"netscape_server_gated_crypto":      x509.ExtKeyUsageNetscapeServerGatedCrypto,
+	"microsoft_commercial_code_signing": x509.ExtKeyUsageMicrosoftCommercialCodeSigning,
+	"microsoft_kernel_code_signing":     x509.ExtKeyUsageMicrosoftKernelCodeSigning,
+}
+
+// parseX509VerifyOptions parses the options argument. Unknown options are
+// rejected.
+func parseX509VerifyOptions(o ast.Object, now time.Time) (*x509VerifyOptions, error) {
+	options := x509VerifyOptions{
+		time: now,
+	}
+	if err := o.Iter(func(k *ast.Term, v *ast.Term) error {
+		name, ok := k.Value.(ast.String)
+		if !ok {
+			return fmt.Errorf("certificate verification option keys must be strings")
+		}
+		handler, ok := x509VerifyOptionTypes[string(name)]
+		if !ok {
+			return fmt.Errorf("unknown certificate verification option: %s", string(name))
+		}
+		return handler(v.Value, &options)
+	}); err != nil {
+		return nil, err
+	}
+	return &options, nil
+}
+
+// x509VerifyOptionCerts handles certificate options.
+func x509VerifyOptionCerts(name string, value ast.Value) ([]*x509.Certificate, error) {
+	s, ok := value.(ast.String)
+	if !ok {
+		return nil, fmt.Errorf("%s option: must be a string", name)
+	}
+	certs, err := getX509CertsFromString(string(s))
+	if err != nil {
+		return nil, fmt.Errorf("%s option: %w", name, err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s option: must contain a certificate", name)
+	}
+	return certs, nil
+}
+
+// x509VerifyOptionKeyUsages handles the `key_usages` option.
+func x509VerifyOptionKeyUsages(value ast.Value, options *x509VerifyOptions) error {
+	arr, ok := value.(*ast.Array)
+	if !ok {
+		return fmt.Errorf("key_usages option: must be an array")
+	}
+	usages := make([]x509.ExtKeyUsage, 0, arr.Len())
+	err := arr.Iter(func(t *ast.Term) error {
+		s, ok := t.Value.(ast.String)
+		if !ok {
+			return fmt.Errorf("key_usages option: must be an array of strings")
+		}
+		usage, ok := x509ExtKeyUsages[string(s)]
+		if !ok {
+			return fmt.Errorf("key_usages option: unknown key usage: %s", s)
+		}
+		usages = append(usages, usage)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	options.keyUsages = usages
+	return nil
+}
+
+// x509VerifyOptionString handles string options.
+func x509VerifyOptionString(name string, value ast.Value, where *string) error {
+	s, ok := value.(ast.String)
+	if !ok {
+		return fmt.Errorf("%s option: must be a string", name)
+	}
+	*where = string(s)
+	return nil
+}
+
+// x509VerifyOptionTime handles the `time` option.
+func x509VerifyOptionTime(value ast.Value, options *x509VerifyOptions) error {
+	n, ok := value.(ast.Number)
+	if !ok {
+		return fmt.Errorf("time option: must be a number")
+	}
+	ns, ok := n.Int64()
+	if !ok {
+		return fmt.Errorf("time option: must be an integer")
+	}
+	options.time = time.Unix(0, ns).UTC()
+	return nil
+}
+
+// x509VerifyOptionCRLs handles the `crls` option.
+func x509VerifyOptionCRLs(value ast.Value, options *x509VerifyOptions) error {
+	s, ok := value.(ast.String)
+	if !ok {
+		return fmt.Errorf("crls option: must be a string")
+	}
+	crls, err := getCRLsFromString(string(s))
+	if err != nil {
+		return fmt.Errorf("crls option: %w", err)
+	}
+	options.crls = crls
+	return nil
+}
+
+// verify verifies the certificate chain, where the last certificate is the
+// leaf, and returns the verified chain from the leaf to the root.
+func (options *x509VerifyOptions) verify(certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	if options.roots != nil {
+		if len(certs) < 1 {
+			return nil, builtins.NewOperandErr(1, "must supply at least one certificate to be able to verify")
+		}
+		for _, cert := range options.roots {
+			roots.AddCert(cert)
+		}
+		for _, cert := range certs[:len(certs)-1] {
+			intermediates.AddCert(cert)
+		}
+	} else {
+		if len(certs) < 2 {
+			return nil, builtins.NewOperandErr(1, "must supply at least two certificates to be able to verify")
+		}
+		roots.AddCert(certs[0])
+		for _, cert := range certs[1 : len(certs)-1] {
+			intermediates.AddCert(cert)
+		}
+	}
+	for _, cert := range options.intermediates {
+		intermediates.AddCert(cert)
+	}
+
+	leaf := certs[len(certs)-1]
+
+	if options.uri != "" && !hasURI(leaf, options.uri) {
+		return nil, fmt.Errorf("certificate is not valid for URI %s", options.uri)
+	}
+
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       options.dnsName,
+		CurrentTime:   options.time,
+		KeyUsages:     options.keyUsages,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Any chain that has no revoked certificate will do.
+	for _, chain := range chains {
+		if err = options.checkRevocation(chain); err == nil {
+			return chain, nil
+		}
+	}
+	return nil, err
+}
+
+// checkRevocation checks the certificates of the chain against the CRLs
+// signed by their issuers. CRLs of other issuers are ignored.
+func (options *x509VerifyOptions) checkRevocation(chain []*x509.Certificate) error {
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		for _, crl := range options.crls {
+			if err := issuer.CheckCRLSignature(crl); err != nil { //nolint:staticcheck // SA1019, x509.RevocationList requires Go 1.19
+				continue
+			}
+			if crl.HasExpired(options.time) { //nolint:staticcheck // SA1019, x509.RevocationList requires Go 1.19
+				return fmt.Errorf("certificate revocation list of %s has expired", issuer.Subject)
+			}
+			for _, revoked := range crl.TBSCertList.RevokedCertificates {
+				if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf("certificate %s has been revoked", cert.Subject)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hasURI(cert *x509.Certificate, uri string) bool {
+	for _, u := range cert.URIs {
+		if u.String() == uri {
+			return true
+		}
+	}
+	return false
+}
+
 func getX509CertsFromString(certs string) ([]*x509.Certificate, error) {
 	// if the input is PEM handle that
 	if strings.HasPrefix(certs, "-----BEGIN") {
@@ -373,6 +726,224 @@ func getRSAPrivateKeyFromPEM(pemBlocks []byte) (interface{}, error) {
 
 }
 
+func getCRLsFromString(crls string) ([]*pkix.CertificateList, error) { //nolint:staticcheck // SA1019, x509.RevocationList requires Go 1.19
+	der := []byte(crls)
+
+	// assume input is base64 if not PEM
+	if !strings.HasPrefix(crls, "-----BEGIN") {
+		b64, err := base64.StdEncoding.DecodeString(crls)
+		if err != nil {
+			return nil, err
+		}
+		der = b64
+	}
+
+	if !bytes.HasPrefix(der, []byte("-----BEGIN")) {
+		crl, err := x509.ParseDERCRL(der) //nolint:staticcheck // SA1019, x509.ParseRevocationList requires Go 1.19
+		if err != nil {
+			return nil, err
+		}
+		return []*pkix.CertificateList{crl}, nil //nolint:staticcheck // SA1019, x509.RevocationList requires Go 1.19
+	}
+
+	var result []*pkix.CertificateList //nolint:staticcheck // SA1019, x509.RevocationList requires Go 1.19
+	for len(der) > 0 {
+		p, r := pem.Decode(der)
+		if p == nil {
+			break
+		}
+		if p.Type != blockTypeCRL {
+			return nil, fmt.Errorf("PEM block type is '%s', expected %s", p.Type, blockTypeCRL)
+		}
+		crl, err := x509.ParseDERCRL(p.Bytes) //nolint:staticcheck // SA1019, x509.ParseRevocationList requires Go 1.19
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, crl)
+		der = r
+	}
+	if len(result) == 0 {
+		return nil, errors.New("failed to parse PEM block containing the CRL")
+	}
+	return result, nil
+}
+
+// getJWKsFromString returns the JWKs of the keys in a PEM string, base64
+// encoded PEM string, or JSON encoded JWK (set).
+func getJWKsFromString(keys string) ([]*jwk.RawKeyJSON, error) {
+	if strings.HasPrefix(strings.TrimSpace(keys), "{") {
+		return getJWKsFromJWK(keys)
+	}
+
+	// if the input is PEM handle that
+	if strings.HasPrefix(keys, "-----BEGIN") {
+		return getJWKsFromPEM([]byte(keys))
+	}
+
+	// assume input is base64 if not PEM
+	b64, err := base64.StdEncoding.DecodeString(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return getJWKsFromPEM(b64)
+}
+
+func getJWKsFromJWK(keys string) ([]*jwk.RawKeyJSON, error) {
+	set, err := jwk.ParseStringAll(keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse a JWK key (set): %w", err)
+	}
+
+	result := make([]*jwk.RawKeyJSON, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.Materialize()
+		if err != nil {
+			return nil, err
+		}
+		raw, err := rawJWKFromKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if alg := k.GetAlgorithm(); alg != jwa.Unsupported && alg != jwa.NoValue {
+			raw.Algorithm = &alg
+		}
+		raw.KeyID = k.GetKeyID()
+		raw.KeyUsage = k.GetKeyUsage()
+		raw.KeyOps = k.GetKeyOps()
+		result = append(result, raw)
+	}
+	return result, nil
+}
+
+func getJWKsFromPEM(pemBlocks []byte) ([]*jwk.RawKeyJSON, error) {
+	var result []*jwk.RawKeyJSON
+	for len(pemBlocks) > 0 {
+		p, r := pem.Decode(pemBlocks)
+		if p == nil {
+			break
+		}
+		pemBlocks = r
+
+		var key interface{}
+		var err error
+		switch p.Type {
+		case blockTypeRSAPrivateKey:
+			key, err = x509.ParsePKCS1PrivateKey(p.Bytes)
+		case blockTypePrivateKey:
+			key, err = x509.ParsePKCS8PrivateKey(p.Bytes)
+		case blockTypeECPrivateKey:
+			key, err = x509.ParseECPrivateKey(p.Bytes)
+		case blockTypeRSAPublicKey:
+			key, err = x509.ParsePKCS1PublicKey(p.Bytes)
+		case blockTypePublicKey:
+			key, err = x509.ParsePKIXPublicKey(p.Bytes)
+		case blockTypeCertificate:
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(p.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		case blockTypeECParameters:
+			continue
+		default:
+			return nil, fmt.Errorf("PEM block type '%s' does not contain a key", p.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := rawJWKFromKey(key)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, raw)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("failed to parse PEM block containing the key")
+	}
+	return result, nil
+}
+
+// rawJWKFromKey returns the JWK of an RSA, EC, Ed25519 or symmetric key.
+func rawJWKFromKey(key interface{}) (*jwk.RawKeyJSON, error) {
+	raw := &jwk.RawKeyJSON{}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, fmt.Errorf("multi-prime RSA keys are not supported")
+		}
+		k.Precompute()
+		raw.KeyType = jwa.RSA
+		raw.N = k.N.Bytes()
+		raw.E = buffer.FromUint(uint64(k.E))
+		raw.D = k.D.Bytes()
+		raw.P = k.Primes[0].Bytes()
+		raw.Q = k.Primes[1].Bytes()
+		raw.Dp = k.Precomputed.Dp.Bytes()
+		raw.Dq = k.Precomputed.Dq.Bytes()
+		raw.Qi = k.Precomputed.Qinv.Bytes()
+	case *rsa.PublicKey:
+		raw.KeyType = jwa.RSA
+		raw.N = k.N.Bytes()
+		raw.E = buffer.FromUint(uint64(k.E))
+	case *ecdsa.PrivateKey:
+		if err := setECParameters(raw, &k.PublicKey); err != nil {
+			return nil, err
+		}
+		raw.D = padLeft(k.D.Bytes(), (k.Curve.Params().BitSize+7)/8)
+	case *ecdsa.PublicKey:
+		if err := setECParameters(raw, k); err != nil {
+			return nil, err
+		}
+	case ed25519.PrivateKey:
+		raw.KeyType = jwa.OKP
+		raw.Crv = jwa.Ed25519
+		raw.X = []byte(k.Public().(ed25519.PublicKey))
+		raw.D = k.Seed()
+	case ed25519.PublicKey:
+		raw.KeyType = jwa.OKP
+		raw.Crv = jwa.Ed25519
+		raw.X = []byte(k)
+	case []byte:
+		raw.KeyType = jwa.OctetSeq
+		raw.K = k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return raw, nil
+}
+
+func setECParameters(raw *jwk.RawKeyJSON, key *ecdsa.PublicKey) error {
+	var crv jwa.EllipticCurveAlgorithm
+	switch key.Curve.Params().Name {
+	case "P-256":
+		crv = jwa.P256
+	case "P-384":
+		crv = jwa.P384
+	case "P-521":
+		crv = jwa.P521
+	default:
+		return fmt.Errorf("unsupported curve %s", key.Curve.Params().Name)
+	}
+	size := (key.Curve.Params().BitSize + 7) / 8
+	raw.KeyType = jwa.EC
+	raw.Crv = crv
+	raw.X = padLeft(key.X.Bytes(), size)
+	raw.Y = padLeft(key.Y.Bytes(), size)
+	return nil
+}
+
+func padLeft(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
+
 // addCACertsFromFile adds CA certificates from filePath into the given pool.
 // If pool is nil, it creates a new x509.CertPool. pool is returned.
 func addCACertsFromFile(pool *x509.CertPool, filePath string) (*x509.CertPool, error) {
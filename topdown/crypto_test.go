@@ -1,10 +1,23 @@
 package topdown
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/internal/jwx/jwk"
 )
 
@@ -205,3 +218,302 @@ qPYXB5U6tdTrexzaYBKr4gQ=
 	})
 
 }
+
+func TestX509ParseAndVerifyWithOptions(t *testing.T) {
+	now := time.Now()
+	notAfter := now.Add(time.Hour)
+
+	newCert := func(template *x509.Certificate, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+		t.Helper()
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.NotBefore = now.Add(-time.Hour)
+		template.NotAfter = notAfter
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+
+	encode := func(certs ...*x509.Certificate) string {
+		var sb strings.Builder
+		for _, cert := range certs {
+			_ = pem.Encode(&sb, &pem.Block{Type: blockTypeCertificate, Bytes: cert.Raw})
+		}
+		return sb.String()
+	}
+
+	root, rootKey := newCert(&x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Root CA"},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+	intermediate, intermediateKey := newCert(&x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "Intermediate CA"},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, root, rootKey)
+	spiffeID, _ := url.Parse("spiffe://example.com/workload")
+	leaf, _ := newCert(&x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		URIs:         []*url.URL{spiffeID},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, intermediate, intermediateKey)
+
+	newCRL := func(revoked ...*x509.Certificate) string {
+		t.Helper()
+		template := &x509.RevocationList{
+			Number:     big.NewInt(1),
+			ThisUpdate: now.Add(-time.Hour),
+			NextUpdate: now.Add(30 * time.Minute),
+		}
+		for _, cert := range revoked {
+			template.RevokedCertificates = append(template.RevokedCertificates, pkix.RevokedCertificate{ //nolint:staticcheck // SA1019, RevokedCertificateEntries requires Go 1.21
+				SerialNumber:   cert.SerialNumber,
+				RevocationTime: now,
+			})
+		}
+		der, err := x509.CreateRevocationList(rand.Reader, template, intermediate, intermediateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: blockTypeCRL, Bytes: der}))
+	}
+
+	chain := encode(intermediate, leaf)
+	clientAuth := ast.ArrayTerm(ast.StringTerm("client_auth"))
+
+	tests := []struct {
+		note    string
+		certs   string
+		options [][2]*ast.Term
+		valid   bool
+	}{
+		{
+			note:    "roots",
+			certs:   chain,
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(root))}, {ast.StringTerm("key_usages"), clientAuth}},
+			valid:   true,
+		},
+		{
+			note:    "root in chain",
+			certs:   encode(root, intermediate, leaf),
+			options: [][2]*ast.Term{{ast.StringTerm("key_usages"), clientAuth}},
+			valid:   true,
+		},
+		{
+			note:    "intermediates",
+			certs:   encode(leaf),
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(root))}, {ast.StringTerm("intermediates"), ast.StringTerm(encode(intermediate))}, {ast.StringTerm("key_usages"), clientAuth}},
+			valid:   true,
+		},
+		{
+			note:    "intermediate as root",
+			certs:   chain,
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(intermediate))}, {ast.StringTerm("key_usages"), clientAuth}},
+			valid:   true,
+		},
+		{
+			note:    "missing intermediate",
+			certs:   encode(leaf),
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(root))}, {ast.StringTerm("key_usages"), clientAuth}},
+		},
+		{
+			note:    "default key usage",
+			certs:   chain,
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(root))}},
+		},
+		{
+			note:    "dns name",
+			certs:   chain,
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(root))}, {ast.StringTerm("key_usages"), clientAuth}, {ast.StringTerm("dns_name"), ast.StringTerm("example.com")}},
+			valid:   true,
+		},
+		{
+			note:    "wrong dns name",
+			certs:   chain,
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(root))}, {ast.StringTerm("key_usages"), clientAuth}, {ast.StringTerm("dns_name"), ast.StringTerm("example.org")}},
+		},
+		{
+			note:    "uri",
+			certs:   chain,
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(root))}, {ast.StringTerm("key_usages"), clientAuth}, {ast.StringTerm("uri"), ast.StringTerm("spiffe://example.com/workload")}},
+			valid:   true,
+		},
+		{
+			note:    "wrong uri",
+			certs:   chain,
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(root))}, {ast.StringTerm("key_usages"), clientAuth}, {ast.StringTerm("uri"), ast.StringTerm("spiffe://example.com/other")}},
+		},
+		{
+			note:    "expired",
+			certs:   chain,
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(root))}, {ast.StringTerm("key_usages"), clientAuth}, {ast.StringTerm("time"), ast.IntNumberTerm(int(notAfter.Add(time.Minute).UnixNano()))}},
+		},
+		{
+			note:    "crl",
+			certs:   chain,
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(root))}, {ast.StringTerm("key_usages"), clientAuth}, {ast.StringTerm("crls"), ast.StringTerm(newCRL())}},
+			valid:   true,
+		},
+		{
+			note:    "revoked",
+			certs:   chain,
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(root))}, {ast.StringTerm("key_usages"), clientAuth}, {ast.StringTerm("crls"), ast.StringTerm(newCRL(leaf))}},
+		},
+		{
+			note:    "expired crl",
+			certs:   chain,
+			options: [][2]*ast.Term{{ast.StringTerm("roots"), ast.StringTerm(encode(root))}, {ast.StringTerm("key_usages"), clientAuth}, {ast.StringTerm("crls"), ast.StringTerm(newCRL())}, {ast.StringTerm("time"), ast.IntNumberTerm(int(now.Add(45 * time.Minute).UnixNano()))}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			options, err := parseX509VerifyOptions(ast.NewObject(tc.options...), now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			certs, err := getX509CertsFromString(tc.certs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verified, err := options.verify(certs)
+			if tc.valid && err != nil {
+				t.Fatalf("expected valid chain, got %v", err)
+			} else if !tc.valid && err == nil {
+				t.Fatal("expected invalid chain")
+			}
+			if tc.valid && verified[0] != certs[len(certs)-1] {
+				t.Fatal("expected verified chain to start with the leaf")
+			}
+		})
+	}
+
+	t.Run("unknown option", func(t *testing.T) {
+		_, err := parseX509VerifyOptions(ast.NewObject([2]*ast.Term{ast.StringTerm("dns"), ast.StringTerm("example.com")}), now)
+		if err == nil || err.Error() != "unknown certificate verification option: dns" {
+			t.Fatalf("expected unknown option error, got %v", err)
+		}
+	})
+}
+
+func TestParseKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(typ string, der []byte, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}))
+	}
+	pkcs1 := encode(blockTypeRSAPrivateKey, x509.MarshalPKCS1PrivateKey(rsaKey), nil)
+	pkcs8 := func(key interface{}) string {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		return encode(blockTypePrivateKey, der, err)
+	}
+	pkix := func(key interface{}) string {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		return encode(blockTypePublicKey, der, err)
+	}
+	sec1 := func(key *ecdsa.PrivateKey) string {
+		der, err := x509.MarshalECPrivateKey(key)
+		return encode(blockTypeECPrivateKey, der, err)
+	}
+
+	tests := []struct {
+		note string
+		keys string
+		exp  []interface{}
+	}{
+		{note: "rsa pkcs1", keys: pkcs1, exp: []interface{}{rsaKey}},
+		{note: "rsa pkcs8", keys: pkcs8(rsaKey), exp: []interface{}{rsaKey}},
+		{note: "rsa public", keys: pkix(&rsaKey.PublicKey), exp: []interface{}{&rsaKey.PublicKey}},
+		{note: "rsa public pkcs1", keys: encode(blockTypeRSAPublicKey, x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey), nil), exp: []interface{}{&rsaKey.PublicKey}},
+		{note: "ec sec1", keys: sec1(ecKey), exp: []interface{}{ecKey}},
+		{note: "ec pkcs8", keys: pkcs8(ecKey), exp: []interface{}{ecKey}},
+		{note: "ec public", keys: pkix(&ecKey.PublicKey), exp: []interface{}{&ecKey.PublicKey}},
+		{note: "ed25519 pkcs8", keys: pkcs8(edKey), exp: []interface{}{edKey}},
+		{note: "ed25519 public", keys: pkix(edKey.Public()), exp: []interface{}{edKey.Public()}},
+		{note: "multiple", keys: pkcs1 + pkix(edKey.Public()), exp: []interface{}{rsaKey, edKey.Public()}},
+		{note: "base64", keys: base64.StdEncoding.EncodeToString([]byte(sec1(ecKey))), exp: []interface{}{ecKey}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			raws, err := getJWKsFromString(tc.keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(raws) != len(tc.exp) {
+				t.Fatalf("expected %d keys, got %d", len(tc.exp), len(raws))
+			}
+			for i, raw := range raws {
+				k, err := raw.GenerateKey()
+				if err != nil {
+					t.Fatal(err)
+				}
+				key, err := k.Materialize()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var equal bool
+				switch key := key.(type) {
+				case interface{ Equal(crypto.PrivateKey) bool }:
+					equal = key.Equal(tc.exp[i])
+				case interface{ Equal(crypto.PublicKey) bool }:
+					equal = key.Equal(tc.exp[i])
+				}
+				if !equal {
+					t.Fatalf("expected key %d to round trip", i)
+				}
+			}
+		})
+	}
+
+	t.Run("jwk", func(t *testing.T) {
+		raws, err := getJWKsFromString(`{"keys": [{"kty": "OKP", "crv": "Ed25519", "kid": "k1", "alg": "EdDSA", "use": "sig", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(raws) != 1 || raws[0].KeyID != "k1" || raws[0].KeyUsage != "sig" || raws[0].Algorithm == nil || *raws[0].Algorithm != "EdDSA" {
+			t.Fatalf("unexpected JWK: %+v", raws)
+		}
+	})
+
+	t.Run("certificate request", func(t *testing.T) {
+		_, err := getJWKsFromString(encode("CERTIFICATE REQUEST", []byte{}, nil))
+		if err == nil || err.Error() != "PEM block type 'CERTIFICATE REQUEST' does not contain a key" {
+			t.Fatalf("expected unsupported block error, got %v", err)
+		}
+	})
+}
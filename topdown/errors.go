@@ -56,6 +56,22 @@ const (
 
 	// WithMergeErr indicates that the real and replacement data could not be merged.
 	WithMergeErr string = "eval_with_merge_error"
+
+	// StepLimitErr indicates evaluation stopped because it exceeded the maximum
+	// number of evaluation steps.
+	StepLimitErr string = "eval_step_limit_error"
+
+	// MemoryLimitErr indicates evaluation stopped because the values it
+	// produced exceeded the maximum memory.
+	MemoryLimitErr string = "eval_memory_limit_error"
+
+	// ResultLimitErr indicates evaluation stopped because the query produced
+	// more than the maximum number of results.
+	ResultLimitErr string = "eval_result_limit_error"
+
+	// CallDepthLimitErr indicates evaluation stopped because nested function
+	// calls exceeded the maximum depth.
+	CallDepthLimitErr string = "eval_call_depth_limit_error"
 )
 
 // IsError returns true if the err is an Error.
@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/metrics"
@@ -14,6 +15,7 @@ import (
 	"github.com/open-policy-agent/opa/topdown/builtins"
 	"github.com/open-policy-agent/opa/topdown/cache"
 	"github.com/open-policy-agent/opa/topdown/copypropagation"
+	"github.com/open-policy-agent/opa/topdown/httpfixtures"
 	"github.com/open-policy-agent/opa/topdown/print"
 	"github.com/open-policy-agent/opa/tracing"
 	"github.com/open-policy-agent/opa/types"
@@ -29,9 +31,7 @@ type queryIDFactory struct {
 
 // Note: The first call to Next() returns 0.
 func (f *queryIDFactory) Next() uint64 {
-	curr := f.curr
-	f.curr++
-	return curr
+	return atomic.AddUint64(&f.curr, 1) - 1
 }
 
 type builtinErrors struct {
@@ -82,6 +82,7 @@ type eval struct {
 	ndBuiltinCache         builtins.NDBCache
 	functionMocks          *functionMocksStack
 	virtualCache           *virtualCache
+	functionMemo           *functionMemo
 	comprehensionCache     *comprehensionCache
 	interQueryBuiltinCache cache.InterQueryCache
 	saveSet                *saveSet
@@ -98,6 +99,12 @@ type eval struct {
 	tracingOpts            tracing.Options
 	findOne                bool
 	strictObjects          bool
+	workers                *workerPool
+	forkLocks              *forkLocks
+	limits                 *evalLimits
+	callDepth              int
+	httpSendFixtures       *httpfixtures.Store
+	ruleCache              *RuleCache
 }
 
 func (e *eval) Run(iter evalIterator) error {
@@ -332,6 +339,10 @@ func (e *eval) evalExpr(iter evalIterator) error {
 	}
 	expr := e.query[e.index]
 
+	if err := e.limits.step(expr.Location); err != nil {
+		return err
+	}
+
 	e.traceEval(expr)
 
 	if len(expr.With) > 0 {
@@ -811,6 +822,7 @@ func (e *eval) evalCall(terms []*ast.Term, iter unifyIterator) error {
 		PrintHook:              e.printHook,
 		DistributedTracingOpts: e.tracingOpts,
 		Capabilities:           capabilities,
+		HTTPSendFixtures:       e.httpSendFixtures,
 	}
 
 	eval := evalBuiltin{
@@ -1140,14 +1152,10 @@ func (e *eval) buildComprehensionCache(a *ast.Term) (*ast.Term, error) {
 }
 
 func (e *eval) buildComprehensionCacheArray(x *ast.ArrayComprehension, keys []*ast.Term) (*comprehensionCacheElem, error) {
-	child := e.child(x.Body)
 	node := newComprehensionCacheElem()
-	return node, child.Run(func(child *eval) error {
-		values := make([]*ast.Term, len(keys))
-		for i := range keys {
-			values[i] = child.bindings.Plug(keys[i])
-		}
-		head := child.bindings.Plug(x.Term)
+	terms := append(keys[:len(keys):len(keys)], x.Term)
+	return node, e.evalComprehension(x.Body, false, terms, func(plugged []*ast.Term) error {
+		values, head := plugged[:len(keys)], plugged[len(keys)]
 		cached := node.Get(values)
 		if cached != nil {
 			cached.Value = cached.Value.(*ast.Array).Append(head)
@@ -1159,14 +1167,10 @@ func (e *eval) buildComprehensionCacheArray(x *ast.ArrayComprehension, keys []*a
 }
 
 func (e *eval) buildComprehensionCacheSet(x *ast.SetComprehension, keys []*ast.Term) (*comprehensionCacheElem, error) {
-	child := e.child(x.Body)
 	node := newComprehensionCacheElem()
-	return node, child.Run(func(child *eval) error {
-		values := make([]*ast.Term, len(keys))
-		for i := range keys {
-			values[i] = child.bindings.Plug(keys[i])
-		}
-		head := child.bindings.Plug(x.Term)
+	terms := append(keys[:len(keys):len(keys)], x.Term)
+	return node, e.evalComprehension(x.Body, false, terms, func(plugged []*ast.Term) error {
+		values, head := plugged[:len(keys)], plugged[len(keys)]
 		cached := node.Get(values)
 		if cached != nil {
 			set := cached.Value.(ast.Set)
@@ -1179,15 +1183,10 @@ func (e *eval) buildComprehensionCacheSet(x *ast.SetComprehension, keys []*ast.T
 }
 
 func (e *eval) buildComprehensionCacheObject(x *ast.ObjectComprehension, keys []*ast.Term) (*comprehensionCacheElem, error) {
-	child := e.child(x.Body)
 	node := newComprehensionCacheElem()
-	return node, child.Run(func(child *eval) error {
-		values := make([]*ast.Term, len(keys))
-		for i := range keys {
-			values[i] = child.bindings.Plug(keys[i])
-		}
-		headKey := child.bindings.Plug(x.Key)
-		headValue := child.bindings.Plug(x.Value)
+	terms := append(keys[:len(keys):len(keys)], x.Key, x.Value)
+	return node, e.evalComprehension(x.Body, false, terms, func(plugged []*ast.Term) error {
+		values, headKey, headValue := plugged[:len(keys)], plugged[len(keys)], plugged[len(keys)+1]
 		cached := node.Get(values)
 		if cached != nil {
 			obj := cached.Value.(ast.Object)
@@ -1260,9 +1259,8 @@ func (e *eval) amendComprehension(a *ast.Term, b1 *bindings) (*ast.Term, error)
 
 func (e *eval) biunifyComprehensionArray(x *ast.ArrayComprehension, b *ast.Term, b1, b2 *bindings, iter unifyIterator) error {
 	result := ast.NewArray()
-	child := e.closure(x.Body)
-	err := child.Run(func(child *eval) error {
-		result = result.Append(child.bindings.Plug(x.Term))
+	err := e.evalComprehension(x.Body, true, []*ast.Term{x.Term}, func(values []*ast.Term) error {
+		result = result.Append(values[0])
 		return nil
 	})
 	if err != nil {
@@ -1273,9 +1271,8 @@ func (e *eval) biunifyComprehensionArray(x *ast.ArrayComprehension, b *ast.Term,
 
 func (e *eval) biunifyComprehensionSet(x *ast.SetComprehension, b *ast.Term, b1, b2 *bindings, iter unifyIterator) error {
 	result := ast.NewSet()
-	child := e.closure(x.Body)
-	err := child.Run(func(child *eval) error {
-		result.Add(child.bindings.Plug(x.Term))
+	err := e.evalComprehension(x.Body, true, []*ast.Term{x.Term}, func(values []*ast.Term) error {
+		result.Add(values[0])
 		return nil
 	})
 	if err != nil {
@@ -1286,10 +1283,8 @@ func (e *eval) biunifyComprehensionSet(x *ast.SetComprehension, b *ast.Term, b1,
 
 func (e *eval) biunifyComprehensionObject(x *ast.ObjectComprehension, b *ast.Term, b1, b2 *bindings, iter unifyIterator) error {
 	result := ast.NewObject()
-	child := e.closure(x.Body)
-	err := child.Run(func(child *eval) error {
-		key := child.bindings.Plug(x.Key)
-		value := child.bindings.Plug(x.Value)
+	err := e.evalComprehension(x.Body, true, []*ast.Term{x.Key, x.Value}, func(values []*ast.Term) error {
+		key, value := values[0], values[1]
 		exist := result.Get(key)
 		if exist != nil && !exist.Equal(value) {
 			return objectDocKeyConflictErr(x.Key.Location)
@@ -1564,7 +1559,7 @@ func (e *eval) resolveReadFromStorage(ref ast.Ref, a ast.Value) (ast.Value, erro
 			return a, nil
 		}
 
-		blob, err := e.store.Read(e.ctx, e.txn, path)
+		blob, err := e.readStore(path)
 		if err != nil {
 			if !storage.IsNotFound(err) {
 				return nil, err
@@ -1737,14 +1732,20 @@ func (e evalBuiltin) eval(iter unifyIterator) error {
 		e.e.instr.startTimer(evalOpBuiltinCall)
 	}
 
+	f := e.f
+	if e.e.serializeBuiltin(e.bi) {
+		f = e.e.forkLocks.serialize(f)
+	}
+
 	// Normal unification flow for builtins:
-	err = e.f(e.bctx, operands, func(output *ast.Term) error {
+	err = f(e.bctx, operands, func(output *ast.Term) error {
 
 		e.e.instr.stopTimer(evalOpBuiltinCall)
 
-		var err error
+		err := e.e.limits.alloc(e.bctx.Location, output)
 
 		switch {
+		case err != nil:
 		case e.bi.Decl.Result() == nil:
 			err = iter()
 		case len(operands) == numDeclArgs:
@@ -1828,11 +1829,15 @@ func (e evalFunc) evalValue(iter unifyIterator, argCount int, findOne bool) erro
 	var hit bool
 	var err error
 	if !e.e.partial() {
-		cacheKey, hit, err = e.evalCache(argCount, iter)
-		if err != nil {
-			return err
-		} else if hit {
-			return nil
+		if e.e.functionMemo.Memoize(e.ref) {
+			cacheKey, hit, err = e.evalCache(argCount, iter)
+			if err != nil {
+				return err
+			} else if hit {
+				return nil
+			}
+		} else {
+			e.e.instr.counterIncr(evalOpFunctionCacheSkip)
 		}
 	}
 
@@ -1894,6 +1899,11 @@ func (e evalFunc) evalOneRule(iter unifyIterator, rule *ast.Rule, cacheKey ast.R
 
 	child := e.e.child(rule.Body)
 	child.findOne = findOne
+	child.callDepth++
+
+	if err := e.e.limits.call(e.e.query[e.e.index].Location, child.callDepth); err != nil {
+		return nil, err
+	}
 
 	args := make([]*ast.Term, len(e.terms)-1)
 	copy(args, rule.Head.Args)
@@ -1919,6 +1929,9 @@ func (e evalFunc) evalOneRule(iter unifyIterator, rule *ast.Rule, cacheKey ast.R
 			}
 
 			result = child.bindings.Plug(rule.Head.Value)
+			if err := e.e.limits.alloc(rule.Head.Location, result); err != nil {
+				return err
+			}
 			if cacheKey != nil {
 				e.e.virtualCache.Put(cacheKey, result) // the redos confirm this, or the evaluation is aborted
 			}
@@ -2411,6 +2424,10 @@ func (e evalVirtualPartial) evalAllRules(iter unifyIterator, rules []*ast.Rule)
 }
 
 func (e evalVirtualPartial) evalAllRulesNoCache(rules []*ast.Rule) (*ast.Term, error) {
+	if len(rules) > 1 && e.e.parallel() {
+		return e.evalAllRulesParallel(rules)
+	}
+
 	result := e.empty
 
 	for _, rule := range rules {
@@ -2436,6 +2453,39 @@ func (e evalVirtualPartial) evalAllRulesNoCache(rules []*ast.Rule) (*ast.Term, e
 	return result, nil
 }
 
+// evalAllRulesParallel evaluates the rules in parallel. The results are
+// reduced in the order of the rules, so conflicts are reported like in
+// sequential evaluation.
+func (e evalVirtualPartial) evalAllRulesParallel(rules []*ast.Rule) (*ast.Term, error) {
+	queries := make([]ast.Body, len(rules))
+	for i := range rules {
+		queries[i] = rules[i].Body
+	}
+
+	heads := make([][][2]*ast.Term, len(rules))
+	err := e.e.evalParallel(queries, func(i int, child *eval) error {
+		return child.eval(func(*eval) error {
+			key, value := e.plugHead(rules[i].Head, child.bindings)
+			heads[i] = append(heads[i], [2]*ast.Term{key, value})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := e.empty
+	for i := range heads {
+		for _, head := range heads[i] {
+			if result, _, err = e.reducePlugged(rules[i].Head, head[0], head[1], result); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
 func (e evalVirtualPartial) evalOneRulePreUnify(iter unifyIterator, rule *ast.Rule, hint evalVirtualPartialCacheHint, result *ast.Term, unknown bool) error {
 
 	key := e.ref[e.pos+1]
@@ -2679,18 +2729,32 @@ func (e evalVirtualPartial) evalCache(iter unifyIterator) (evalVirtualPartialCac
 }
 
 func (e evalVirtualPartial) reduce(head *ast.Head, b *bindings, result *ast.Term) (*ast.Term, bool, error) {
+	key, value := e.plugHead(head, b)
+	return e.reducePlugged(head, key, value, result)
+}
+
+// plugHead returns the plugged key and value (nil for sets) of the head.
+func (e evalVirtualPartial) plugHead(head *ast.Head, b *bindings) (*ast.Term, *ast.Term) {
+	if _, ok := e.empty.Value.(ast.Set); ok { // MultiValue
+		return b.Plug(head.Key), nil
+	}
+	key := head.Reference[len(head.Reference)-1] // NOTE(sr): multiple vars in ref heads need to deal with this better
+	return b.Plug(key), b.Plug(head.Value)
+}
+
+func (e evalVirtualPartial) reducePlugged(head *ast.Head, key, value, result *ast.Term) (*ast.Term, bool, error) {
+
+	if err := e.e.limits.alloc(head.Location, key, value); err != nil {
+		return nil, false, err
+	}
 
 	var exists bool
 
 	switch v := result.Value.(type) {
 	case ast.Set: // MultiValue
-		key := b.Plug(head.Key)
 		exists = v.Contains(key)
 		v.Add(key)
 	case ast.Object: // SingleValue
-		key := head.Reference[len(head.Reference)-1] // NOTE(sr): multiple vars in ref heads need to deal with this better
-		key = b.Plug(key)
-		value := b.Plug(head.Value)
 		if curr := v.Get(key); curr != nil {
 			if !curr.Equal(value) {
 				return nil, false, objectDocKeyConflictErr(head.Location)
@@ -2764,6 +2828,42 @@ func (e evalVirtualComplete) evalValue(iter unifyIterator, findOne bool) error {
 
 	e.e.instr.counterIncr(evalOpVirtualCacheMiss)
 
+	if key, ok := e.e.ruleCacheKey(e.plugged[:e.pos+1]); ok {
+		return e.evalCachedValue(iter, key, findOne)
+	}
+
+	return e.evalValueRules(iter, findOne)
+}
+
+// evalCachedValue evaluates the value of the rule with the rule cache.
+func (e evalVirtualComplete) evalCachedValue(iter unifyIterator, key ast.Value, findOne bool) error {
+	ref := e.plugged[:e.pos+1]
+
+	cached, hit := e.e.ruleCache.Get(key)
+	if hit {
+		e.e.instr.counterIncr(evalOpRuleCacheHit)
+		e.e.virtualCache.Put(ref, cached)
+	} else {
+		e.e.instr.counterIncr(evalOpRuleCacheMiss)
+
+		// The value is evaluated before it is unified so that it is
+		// complete when it is cached.
+		if err := e.evalValueRules(func() error { return nil }, findOne); err != nil {
+			return err
+		}
+		cached, _ = e.e.virtualCache.Get(ref)
+		e.e.ruleCache.Put(key, cached)
+	}
+
+	if cached == nil {
+		return nil
+	}
+
+	return e.evalTerm(iter, cached, e.bindings)
+}
+
+func (e evalVirtualComplete) evalValueRules(iter unifyIterator, findOne bool) error {
+
 	var prev *ast.Term
 
 	for _, rule := range e.ir.Rules {
@@ -2809,6 +2909,9 @@ func (e evalVirtualComplete) evalValueRule(iter unifyIterator, rule *ast.Rule, p
 		child.traceExit(rule)
 
 		result = child.bindings.Plug(rule.Head.Value)
+		if err := e.e.limits.alloc(rule.Head.Location, result); err != nil {
+			return err
+		}
 
 		if prev != nil {
 			if ast.Compare(result, prev) != 0 {
@@ -443,3 +443,119 @@ func TestTopdownVirtualCache(t *testing.T) {
 		})
 	}
 }
+
+func TestTopdownFunctionMemoization(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		note      string
+		module    string
+		query     string
+		hit, miss uint64
+		skip      uint64
+	}{
+		{
+			note: "function",
+			module: `package p
+				f(x) = y { y := x + 1 }`,
+			query: `data.p.f(1, a); data.p.f(1, b); data.p.f(2, c)`,
+			hit:   1,
+			miss:  2,
+		},
+		{
+			note: "function calling other functions",
+			module: `package p
+				f(x) = y { y := g(x) + 1 }
+				g(x) = y { y := abs(x) }`,
+			query: `data.p.f(1, a); data.p.f(1, b)`,
+			hit:   1,
+			miss:  2, // f(1) + g(1)
+		},
+		{
+			note: "non-deterministic built-in function",
+			module: `package p
+				f(x) = y { y := rand.intn("seed", x) }`,
+			query: `data.p.f(1, a); data.p.f(1, b)`,
+			hit:   1,
+			miss:  1,
+		},
+		{
+			note: "non-deterministic built-in function in other function",
+			module: `package p
+				f(x) = y { y := g(x) }
+				g(x) = y { y := x } else = z { z := time.now_ns() }`,
+			query: `data.p.f(1, a); data.p.f(1, b)`,
+			hit:   1,
+			miss:  2, // f(1) + g(1)
+		},
+		{
+			note: "function replaced with 'with'",
+			module: `package p
+				f(x) = y { y := g(x) with h as abs }
+				g(x) = y { y := h(x) }
+				h(x) = x`,
+			query: `data.p.f(-1, a); data.p.f(-1, b)`,
+			hit:   1, // g(-1)
+			miss:  2, // g(-1) + h(-1)
+		},
+		{
+			note: "disabled by annotation",
+			module: `package p
+
+# METADATA
+# memoize: false
+f(x) = y { y := x + 1 }`,
+			query: `data.p.f(1, a); data.p.f(1, b)`,
+			skip:  2,
+		},
+		{
+			note: "disabled by package annotation, enabled by rule annotation",
+			module: `# METADATA
+# memoize: false
+package p
+
+f(x) = y { y := x + 1 }
+
+# METADATA
+# memoize: true
+g(x) = y { y := x + 1 }`,
+			query: `data.p.f(1, a); data.p.f(1, b); data.p.g(1, c); data.p.g(1, d)`,
+			hit:   1,
+			miss:  1,
+			skip:  2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			module := ast.MustParseModuleWithOpts(tc.module, ast.ParserOptions{ProcessAnnotation: true})
+			compiler := ast.NewCompiler()
+			if compiler.Compile(map[string]*ast.Module{"test.rego": module}); compiler.Failed() {
+				t.Fatal(compiler.Errors)
+			}
+
+			m := metrics.New()
+
+			qrs, err := NewQuery(ast.MustParseBody(tc.query)).
+				WithCompiler(compiler).
+				WithInstrumentation(NewInstrumentation(m)).
+				Run(ctx)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if exp, act := 1, len(qrs); exp != act {
+				t.Fatalf("expected %d query result, got %d query results: %+v", exp, act, qrs)
+			}
+
+			if exp, act := tc.hit, m.Counter(evalOpVirtualCacheHit).Value().(uint64); exp != act {
+				t.Errorf("expected %d cache hits, got %d", exp, act)
+			}
+			if exp, act := tc.miss, m.Counter(evalOpVirtualCacheMiss).Value().(uint64); exp != act {
+				t.Errorf("expected %d cache misses, got %d", exp, act)
+			}
+			if exp, act := tc.skip, m.Counter(evalOpFunctionCacheSkip).Value().(uint64); exp != act {
+				t.Errorf("expected %d cache skips, got %d", exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// NewConstantEvaluator returns an ast.ConstantEvaluator that evaluates closed
+// terms with the built-in function implementations registered with topdown
+// and the supplied custom built-in functions. Built-in function errors are
+// returned to the compiler, which leaves the term unchanged.
+func NewConstantEvaluator(ctx context.Context, builtins map[string]*Builtin) ast.ConstantEvaluator {
+
+	// Closed terms do not refer to rules, however, the evaluator requires a
+	// compiler to look up comprehension indices.
+	compiler := ast.NewCompiler()
+
+	return func(term *ast.Term) (*ast.Term, error) {
+
+		result := ast.VarTerm("__fold_result__")
+
+		var query ast.Body
+		if call, ok := term.Value.(ast.Call); ok {
+			query = ast.NewBody(ast.NewExpr(append([]*ast.Term(call), result)))
+		} else {
+			query = ast.NewBody(ast.Equality.Expr(result, term))
+		}
+
+		rs, err := NewQuery(query).
+			WithCompiler(compiler).
+			WithBuiltins(builtins).
+			WithStrictBuiltinErrors(true).
+			Run(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rs) == 0 {
+			return nil, nil
+		}
+
+		return rs[0][result.Value.(ast.Var)], nil
+	}
+}
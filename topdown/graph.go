@@ -0,0 +1,373 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+// Bounds on the size of the graphs accepted by the graph algorithms below.
+// Transitive reduction takes time proportional to the number of vertices
+// times the number of edges.
+const (
+	graphMaxVertices = 10000
+	graphMaxEdges    = 100000
+)
+
+// indexedGraph is a directed graph whose vertices are numbered in sorted
+// order.
+type indexedGraph struct {
+	vertices []*ast.Term
+	index    *ast.ValueMap
+	// edges holds the sorted, distinct neighbors of each vertex.
+	edges [][]int
+}
+
+// newIndexedGraph returns the graph of an adjacency object, mapping vertices
+// to sets or arrays of neighbors. Vertices that are only neighbors are part of
+// the graph as well.
+func newIndexedGraph(operand ast.Value, pos int) (*indexedGraph, error) {
+	obj, err := builtins.ObjectOperand(operand, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	all := ast.NewSet()
+	numEdges := 0
+	obj.Foreach(func(k, v *ast.Term) {
+		all.Add(k)
+		foreachVertex(v, func(n *ast.Term) {
+			all.Add(n)
+		})
+		numEdges += numberOfEdges(v)
+	})
+
+	if all.Len() > graphMaxVertices {
+		return nil, builtins.NewOperandErr(pos, "graph has %d vertices, more than the maximum of %d", all.Len(), graphMaxVertices)
+	}
+	if numEdges > graphMaxEdges {
+		return nil, builtins.NewOperandErr(pos, "graph has %d edges, more than the maximum of %d", numEdges, graphMaxEdges)
+	}
+
+	sorted := all.Sorted()
+	g := &indexedGraph{
+		vertices: make([]*ast.Term, sorted.Len()),
+		index:    ast.NewValueMap(),
+		edges:    make([][]int, sorted.Len()),
+	}
+	for i := 0; i < sorted.Len(); i++ {
+		g.vertices[i] = sorted.Elem(i)
+		g.index.Put(sorted.Elem(i).Value, ast.IntNumberTerm(i).Value)
+	}
+
+	obj.Foreach(func(k, v *ast.Term) {
+		from := g.indexOf(k)
+		seen := map[int]bool{}
+		foreachVertex(v, func(n *ast.Term) {
+			to := g.indexOf(n)
+			if !seen[to] {
+				seen[to] = true
+				g.edges[from] = append(g.edges[from], to)
+			}
+		})
+		sort.Ints(g.edges[from])
+	})
+
+	return g, nil
+}
+
+// indexOf returns the number of a vertex of the graph, or -1.
+func (g *indexedGraph) indexOf(v *ast.Term) int {
+	i := g.index.Get(v.Value)
+	if i == nil {
+		return -1
+	}
+	n, _ := i.(ast.Number).Int()
+	return n
+}
+
+func (g *indexedGraph) terms(vertices []int) []*ast.Term {
+	terms := make([]*ast.Term, len(vertices))
+	for i, v := range vertices {
+		terms[i] = g.vertices[v]
+	}
+	return terms
+}
+
+// components returns the strongly connected components of the graph, using
+// Tarjan's algorithm. Components are returned in topological order: edges
+// between components lead to later components.
+func (g *indexedGraph) components() [][]int {
+	n := len(g.vertices)
+	index := make([]int, n)
+	lowlink := make([]int, n)
+	onStack := make([]bool, n)
+	for i := range index {
+		index[i] = -1
+	}
+
+	var stack []int
+	var components [][]int
+	next := 0
+
+	var visit func(v int)
+	visit = func(v int) {
+		index[v], lowlink[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range g.edges[v] {
+			if index[w] < 0 {
+				visit(w)
+				if lowlink[w] < lowlink[v] {
+					lowlink[v] = lowlink[w]
+				}
+			} else if onStack[w] && index[w] < lowlink[v] {
+				lowlink[v] = index[w]
+			}
+		}
+
+		if lowlink[v] == index[v] {
+			var component []int
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, w)
+				if w == v {
+					break
+				}
+			}
+			sort.Ints(component)
+			components = append(components, component)
+		}
+	}
+
+	for v := 0; v < n; v++ {
+		if index[v] < 0 {
+			visit(v)
+		}
+	}
+
+	// Tarjan's algorithm finds components in reverse topological order.
+	for i, j := 0, len(components)-1; i < j; i, j = i+1, j-1 {
+		components[i], components[j] = components[j], components[i]
+	}
+	return components
+}
+
+// shortestPath returns a shortest path of at least one edge from one vertex
+// to another, passing only through vertices accepted by within, or nil. Ties
+// are broken by visiting neighbors in order, so the result is deterministic.
+func (g *indexedGraph) shortestPath(from, to int, within func(int) bool) []int {
+	prev := make([]int, len(g.vertices))
+	visited := make([]bool, len(g.vertices))
+	visited[from] = true
+
+	queue := []int{from}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, w := range g.edges[v] {
+			if w == to {
+				path := []int{to}
+				for u := v; u != from; u = prev[u] {
+					path = append(path, u)
+				}
+				path = append(path, from)
+				for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+					path[i], path[j] = path[j], path[i]
+				}
+				return path
+			}
+			if visited[w] || !within(w) {
+				continue
+			}
+			visited[w] = true
+			prev[w] = v
+			queue = append(queue, w)
+		}
+	}
+	return nil
+}
+
+// cycle returns a shortest cycle through the lowest vertex of a strongly
+// connected component, or nil if the component has no cycle.
+func (g *indexedGraph) cycle(component []int) []int {
+	members := make(map[int]bool, len(component))
+	for _, v := range component {
+		members[v] = true
+	}
+
+	v := component[0]
+	path := g.shortestPath(v, v, func(w int) bool { return members[w] })
+	if path == nil {
+		return nil
+	}
+	// The path ends where it started.
+	return path[:len(path)-1]
+}
+
+func builtinGraphTopologicalSort(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	g, err := newIndexedGraph(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	// Kahn's algorithm, taking the lowest vertex without incoming edges first.
+	incoming := make([]int, len(g.vertices))
+	for _, edges := range g.edges {
+		for _, w := range edges {
+			incoming[w]++
+		}
+	}
+	ready := &intHeap{}
+	for v, n := range incoming {
+		if n == 0 {
+			heap.Push(ready, v)
+		}
+	}
+
+	order := make([]*ast.Term, 0, len(g.vertices))
+	for ready.Len() > 0 {
+		v := heap.Pop(ready).(int)
+		order = append(order, g.vertices[v])
+		for _, w := range g.edges[v] {
+			incoming[w]--
+			if incoming[w] == 0 {
+				heap.Push(ready, w)
+			}
+		}
+	}
+
+	cycles := make([]*ast.Term, 0)
+	if len(order) < len(g.vertices) {
+		for _, component := range g.components() {
+			if c := g.cycle(component); c != nil {
+				cycles = append(cycles, ast.ArrayTerm(g.terms(c)...))
+			}
+		}
+		sort.Slice(cycles, func(i, j int) bool {
+			return cycles[i].Value.Compare(cycles[j].Value) < 0
+		})
+	}
+
+	return iter(ast.ObjectTerm(
+		ast.Item(ast.StringTerm("order"), ast.ArrayTerm(order...)),
+		ast.Item(ast.StringTerm("cycles"), ast.ArrayTerm(cycles...)),
+	))
+}
+
+func builtinGraphStronglyConnectedComponents(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	g, err := newIndexedGraph(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	components := g.components()
+	result := make([]*ast.Term, len(components))
+	for i, component := range components {
+		result[i] = ast.ArrayTerm(g.terms(component)...)
+	}
+	return iter(ast.ArrayTerm(result...))
+}
+
+func builtinGraphShortestPath(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	g, err := newIndexedGraph(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	from, to := g.indexOf(operands[1]), g.indexOf(operands[2])
+	if from < 0 || to < 0 {
+		return nil
+	}
+	if from == to {
+		return iter(ast.ArrayTerm(operands[1]))
+	}
+
+	path := g.shortestPath(from, to, func(int) bool { return true })
+	if path == nil {
+		return nil
+	}
+	return iter(ast.ArrayTerm(g.terms(path)...))
+}
+
+func builtinGraphTransitiveReduction(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	g, err := newIndexedGraph(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	components := g.components()
+	for _, component := range components {
+		if c := g.cycle(component); c != nil {
+			return fmt.Errorf("graph contains a cycle: %v", ast.NewArray(g.terms(c)...))
+		}
+	}
+
+	// Without cycles, every component is a single vertex, in topological
+	// order. Visiting them in reverse, reach[v] is the set of vertices
+	// reachable from v by a path of at least one edge.
+	n := len(g.vertices)
+	words := (n + 63) / 64
+	reach := make([][]uint64, n)
+	for i := n - 1; i >= 0; i-- {
+		v := components[i][0]
+		reach[v] = make([]uint64, words)
+		for _, w := range g.edges[v] {
+			reach[v][w/64] |= 1 << uint(w%64)
+			for k := range reach[w] {
+				reach[v][k] |= reach[w][k]
+			}
+		}
+	}
+
+	result := ast.NewObject()
+	for v, edges := range g.edges {
+		// An edge is redundant if its target is reachable through another
+		// neighbor.
+		indirect := make([]uint64, words)
+		for _, w := range edges {
+			for k := range reach[w] {
+				indirect[k] |= reach[w][k]
+			}
+		}
+		neighbors := ast.NewSet()
+		for _, w := range edges {
+			if indirect[w/64]&(1<<uint(w%64)) == 0 {
+				neighbors.Add(g.vertices[w])
+			}
+		}
+		result.Insert(g.vertices[v], ast.NewTerm(neighbors))
+	}
+	return iter(ast.NewTerm(result))
+}
+
+type intHeap []int
+
+func (h intHeap) Len() int            { return len(h) }
+func (h intHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h intHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *intHeap) Push(x interface{}) { *h = append(*h, x.(int)) }
+func (h *intHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.GraphTopologicalSort.Name, builtinGraphTopologicalSort)
+	RegisterBuiltinFunc(ast.GraphStronglyConnectedComponents.Name, builtinGraphStronglyConnectedComponents)
+	RegisterBuiltinFunc(ast.GraphShortestPath.Name, builtinGraphShortestPath)
+	RegisterBuiltinFunc(ast.GraphTransitiveReduction.Name, builtinGraphTransitiveReduction)
+}
@@ -67,6 +67,11 @@ var allowedKeyNames = [...]string{
 	"force_cache_duration_seconds",
 	"raise_error",
 	"caching_mode",
+	"max_retry_attempts",
+	"retry_status_codes",
+	"retry_backoff_delay",
+	"circuit_breaker_threshold",
+	"circuit_breaker_reset_timeout",
 }
 
 var (
@@ -443,7 +448,9 @@ func createHTTPRequest(bctx BuiltinContext, obj ast.Object) (*http.Request, *htt
 		case "cache", "caching_mode",
 			"force_cache", "force_cache_duration_seconds",
 			"force_json_decode", "force_yaml_decode",
-			"raise_error": // no-op
+			"raise_error",
+			"max_retry_attempts", "retry_status_codes", "retry_backoff_delay",
+			"circuit_breaker_threshold", "circuit_breaker_reset_timeout": // no-op
 		default:
 			return nil, nil, fmt.Errorf("invalid parameter %q", key)
 		}
@@ -629,10 +636,6 @@ func createHTTPRequest(bctx BuiltinContext, obj ast.Object) (*http.Request, *htt
 	return req, client, nil
 }
 
-func executeHTTPRequest(req *http.Request, client *http.Client) (*http.Response, error) {
-	return client.Do(req)
-}
-
 func isContentType(header http.Header, typ ...string) bool {
 	for _, t := range typ {
 		if strings.Contains(header.Get("Content-Type"), t) {
@@ -697,6 +700,14 @@ func (c *interQueryCache) checkHTTPSendInterQueryCache() (ast.Value, error) {
 		if err != nil {
 			return nil, err
 		}
+	case *cache.SerializedValue:
+		// read from a cache backend, so the value is serialized regardless of
+		// the caching mode
+		var err error
+		cachedRespData, err = (&interQueryCacheValue{Data: v.Data}).copyCacheData()
+		if err != nil {
+			return nil, err
+		}
 	case *interQueryCacheData:
 		cachedRespData = v
 	default:
@@ -760,7 +771,7 @@ func (c *interQueryCache) checkHTTPSendInterQueryCache() (ast.Value, error) {
 			pcv = cachedRespData
 		}
 
-		c.bctx.InterQueryBuiltinCache.Insert(c.key, pcv)
+		c.bctx.InterQueryBuiltinCache.InsertWithExpiry(c.key, pcv, cachedRespData.ExpiresAt)
 
 		return cachedRespData.formatToAST(c.forceJSONDecode, c.forceYAMLDecode)
 	}
@@ -795,19 +806,23 @@ func insertIntoHTTPSendInterQueryCache(bctx BuiltinContext, key ast.Value, resp
 		return err
 	}
 
+	data, err := newInterQueryCacheData(bctx, resp, respBody, cacheParams)
+	if err != nil {
+		return err
+	}
+
 	var pcv cache.InterQueryCacheValue
 
 	if cachingMode == defaultCachingMode {
-		pcv, err = newInterQueryCacheValue(bctx, resp, respBody, cacheParams)
+		pcv, err = data.toCacheValue()
+		if err != nil {
+			return err
+		}
 	} else {
-		pcv, err = newInterQueryCacheData(bctx, resp, respBody, cacheParams)
+		pcv = data
 	}
 
-	if err != nil {
-		return err
-	}
-
-	requestCache.Insert(key, pcv)
+	requestCache.InsertWithExpiry(key, pcv, data.ExpiresAt)
 	return nil
 }
 
@@ -896,6 +911,10 @@ func (cb interQueryCacheValue) SizeInBytes() int64 {
 	return int64(len(cb.Data))
 }
 
+func (cb *interQueryCacheValue) Serialize() ([]byte, error) {
+	return cb.Data, nil
+}
+
 func (cb *interQueryCacheValue) copyCacheData() (*interQueryCacheData, error) {
 	var res interQueryCacheData
 	err := util.UnmarshalJSON(cb.Data, &res)
@@ -975,6 +994,10 @@ func (c *interQueryCacheData) SizeInBytes() int64 {
 	return 0
 }
 
+func (c *interQueryCacheData) Serialize() ([]byte, error) {
+	return json.Marshal(c)
+}
+
 type responseHeaders struct {
 	date         time.Time         // origination date and time of response
 	cacheControl map[string]string // response cache-control header
@@ -1221,6 +1244,10 @@ func newHTTPRequestExecutor(bctx BuiltinContext, key ast.Object) (httpRequestExe
 		return nil, handleHTTPSendErr(bctx, err)
 	}
 
+	if bctx.HTTPSendFixtures != nil {
+		return newHTTPFixtureExecutor(bctx, key)
+	}
+
 	if useInterQueryCache && bctx.InterQueryBuiltinCache != nil {
 		return newInterQueryCache(bctx, key, forceCacheParams)
 	}
@@ -1288,7 +1315,7 @@ func (c *interQueryCache) ExecuteHTTPRequest() (*http.Response, error) {
 		return nil, handleHTTPSendErr(c.bctx, err)
 	}
 
-	return executeHTTPRequest(c.httpReq, c.httpClient)
+	return executeHTTPRequest(c.bctx, c.key, c.httpReq, c.httpClient)
 }
 
 type intraQueryCache struct {
@@ -1331,7 +1358,30 @@ func (c *intraQueryCache) ExecuteHTTPRequest() (*http.Response, error) {
 	if err != nil {
 		return nil, handleHTTPSendErr(c.bctx, err)
 	}
-	return executeHTTPRequest(httpReq, httpClient)
+	return executeHTTPRequest(c.bctx, c.key, httpReq, httpClient)
+}
+
+// httpFixtureExecutor serves requests from the fixtures set on the builtin
+// context instead of the network. Responses are only cached within the query.
+type httpFixtureExecutor struct {
+	*intraQueryCache
+}
+
+func newHTTPFixtureExecutor(bctx BuiltinContext, key ast.Object) (*httpFixtureExecutor, error) {
+	c, err := newIntraQueryCache(bctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &httpFixtureExecutor{intraQueryCache: c}, nil
+}
+
+// ExecuteHTTPRequest returns the response of the fixture matching the request
+func (c *httpFixtureExecutor) ExecuteHTTPRequest() (*http.Response, error) {
+	httpReq, httpClient, err := createHTTPRequest(c.bctx, c.key)
+	if err != nil {
+		return nil, handleHTTPSendErr(c.bctx, err)
+	}
+	return c.bctx.HTTPSendFixtures.Do(httpReq, httpClient)
 }
 
 func useInterQueryCache(req ast.Object) (bool, *forceCacheParams, error) {
@@ -0,0 +1,313 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+const (
+	defaultHTTPRetryBackoffDelay       = 100 * time.Millisecond
+	maxHTTPRetryBackoffDelay           = 30 * time.Second
+	defaultHTTPCircuitBreakerResetTime = 30 * time.Second
+)
+
+var (
+	defaultHTTPRetryStatusCodes = []int{
+		http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+
+	httpSendRetries            = httpSendLatencyMetricKey + "_retries"
+	httpSendCircuitBreakerOpen = httpSendLatencyMetricKey + "_circuit_breaker_open"
+
+	errHTTPSendCircuitOpen = errors.New("circuit breaker open")
+
+	// httpSendCircuitBreakers holds the circuit breakers of the hosts that
+	// http.send requests were sent to. It is shared by all queries.
+	httpSendCircuitBreakers = &circuitBreakers{breakers: map[string]*circuitBreaker{}}
+)
+
+// httpSendRetryParams holds the retry and circuit breaker settings of an
+// http.send request.
+type httpSendRetryParams struct {
+	maxAttempts       int
+	statusCodes       []int
+	backoffDelay      time.Duration
+	breakerThreshold  int
+	breakerResetAfter time.Duration
+}
+
+func newHTTPSendRetryParams(req ast.Object) (*httpSendRetryParams, error) {
+	params := &httpSendRetryParams{
+		statusCodes:       defaultHTTPRetryStatusCodes,
+		backoffDelay:      defaultHTTPRetryBackoffDelay,
+		breakerResetAfter: defaultHTTPCircuitBreakerResetTime,
+	}
+
+	var err error
+
+	if v := req.Get(ast.StringTerm("max_retry_attempts")); v != nil {
+		if params.maxAttempts, err = getNonNegativeInt(v, "max_retry_attempts"); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := req.Get(ast.StringTerm("retry_status_codes")); v != nil {
+		arr, ok := v.Value.(*ast.Array)
+		if !ok {
+			return nil, fmt.Errorf("invalid value for retry_status_codes field")
+		}
+		params.statusCodes = make([]int, 0, arr.Len())
+		for i := 0; i < arr.Len(); i++ {
+			code, err := getNonNegativeInt(arr.Elem(i), "retry_status_codes")
+			if err != nil {
+				return nil, err
+			}
+			params.statusCodes = append(params.statusCodes, code)
+		}
+	}
+
+	if v := req.Get(ast.StringTerm("retry_backoff_delay")); v != nil {
+		if params.backoffDelay, err = parseTimeout(v.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := req.Get(ast.StringTerm("circuit_breaker_threshold")); v != nil {
+		if params.breakerThreshold, err = getNonNegativeInt(v, "circuit_breaker_threshold"); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := req.Get(ast.StringTerm("circuit_breaker_reset_timeout")); v != nil {
+		if params.breakerResetAfter, err = parseTimeout(v.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	return params, nil
+}
+
+func getNonNegativeInt(term *ast.Term, field string) (int, error) {
+	if n, ok := term.Value.(ast.Number); ok {
+		if i, ok := n.Int(); ok && i >= 0 {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid value for %v field", field)
+}
+
+// retry returns true if a request that failed with err or returned resp
+// should be retried. These requests count as failures for the circuit
+// breaker.
+func (p *httpSendRetryParams) retry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	for _, code := range p.statusCodes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the retry following the given attempt:
+// the backoff delay doubled for each attempt, with jitter.
+func (p *httpSendRetryParams) backoff(attempt int) time.Duration {
+	delay := maxHTTPRetryBackoffDelay
+	if attempt < 32 && p.backoffDelay<<attempt < maxHTTPRetryBackoffDelay {
+		delay = p.backoffDelay << attempt
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// executeHTTPRequest sends req with client, retrying the request as set on the
+// request object key. Retries are not attempted if the backoff delay would
+// exceed the request timeout, and retried requests are cancelled once the
+// timeout has passed since the first attempt, so the timeout bounds the total
+// time of all attempts.
+func executeHTTPRequest(bctx BuiltinContext, key ast.Object, req *http.Request, client *http.Client) (*http.Response, error) {
+	params, err := newHTTPSendRetryParams(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var breaker *circuitBreaker
+	if params.breakerThreshold > 0 {
+		breaker = httpSendCircuitBreakers.get(req.URL.Host)
+	}
+
+	start := time.Now()
+
+	for attempt := 0; ; attempt++ {
+		r := req
+		cancel := context.CancelFunc(func() {})
+		if attempt > 0 {
+			ctx := req.Context()
+			if client.Timeout > 0 {
+				ctx, cancel = context.WithDeadline(ctx, start.Add(client.Timeout))
+			}
+			if r, err = cloneHTTPRequest(ctx, req); err != nil {
+				cancel()
+				return nil, err
+			}
+		}
+
+		if breaker != nil && !breaker.allow(time.Now()) {
+			cancel()
+			bctx.Metrics.Counter(httpSendCircuitBreakerOpen).Incr()
+			return nil, &url.Error{Op: urlErrorOp(req.Method), URL: req.URL.String(), Err: errHTTPSendCircuitOpen}
+		}
+
+		resp, err := client.Do(r)
+		failed := params.retry(resp, err)
+
+		if breaker != nil {
+			breaker.record(time.Now(), !failed, params.breakerThreshold, params.breakerResetAfter)
+		}
+
+		if !failed || attempt >= params.maxAttempts || bctx.Context.Err() != nil {
+			return withCancel(resp, cancel), err
+		}
+
+		delay := params.backoff(attempt)
+		if client.Timeout > 0 && time.Since(start)+delay >= client.Timeout {
+			return withCancel(resp, cancel), err
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		cancel()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-bctx.Context.Done():
+			timer.Stop()
+			return nil, &url.Error{Op: urlErrorOp(req.Method), URL: req.URL.String(), Err: bctx.Context.Err()}
+		}
+
+		bctx.Metrics.Counter(httpSendRetries).Incr()
+	}
+}
+
+// withCancel returns resp with a body that calls cancel when it is closed,
+// since the body can only be read until the context of the request is
+// cancelled. Cancel is called right away if there is no response.
+func withCancel(resp *http.Response, cancel context.CancelFunc) *http.Response {
+	if resp == nil {
+		cancel()
+		return nil
+	}
+	resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp
+}
+
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func cloneHTTPRequest(ctx context.Context, req *http.Request) (*http.Request, error) {
+	r := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+// urlErrorOp returns the operation reported in url.Errors for method, like
+// the http.Client does.
+func urlErrorOp(method string) string {
+	if method == "" {
+		return "Get"
+	}
+	return method[:1] + strings.ToLower(method[1:])
+}
+
+type circuitBreakers struct {
+	mtx      sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func (c *circuitBreakers) get(host string) *circuitBreaker {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	b, ok := c.breakers[host]
+	if !ok {
+		b = &circuitBreaker{}
+		c.breakers[host] = b
+	}
+	return b
+}
+
+// circuitBreaker rejects requests to a host after consecutive failures. Once
+// the reset timeout has passed, a single request is let through: if it
+// succeeds, the breaker closes again, otherwise it stays open for another
+// reset timeout.
+type circuitBreaker struct {
+	mtx       sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *circuitBreaker) record(now time.Time, success bool, threshold int, resetAfter time.Duration) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.probing = false
+	if success {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= threshold {
+		b.openUntil = now.Add(resetAfter)
+	}
+}
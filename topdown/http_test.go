@@ -23,6 +23,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -2676,6 +2677,280 @@ func TestHTTPSendMetrics(t *testing.T) {
 	})
 }
 
+func TestHTTPSendRetries(t *testing.T) {
+
+	tests := []struct {
+		note       string
+		failures   int32
+		status     int
+		request    string
+		expStatus  int
+		expRetries uint64
+	}{
+		{
+			note:      "no retries by default",
+			failures:  1,
+			status:    http.StatusServiceUnavailable,
+			request:   `{}`,
+			expStatus: http.StatusServiceUnavailable,
+		},
+		{
+			note:       "retried until success",
+			failures:   2,
+			status:     http.StatusServiceUnavailable,
+			request:    `{"max_retry_attempts": 3, "retry_backoff_delay": "1ms"}`,
+			expStatus:  http.StatusOK,
+			expRetries: 2,
+		},
+		{
+			note:       "retries exhausted",
+			failures:   3,
+			status:     http.StatusTooManyRequests,
+			request:    `{"max_retry_attempts": 2, "retry_backoff_delay": "1ms"}`,
+			expStatus:  http.StatusTooManyRequests,
+			expRetries: 2,
+		},
+		{
+			note:      "status code not retried",
+			failures:  1,
+			status:    http.StatusInternalServerError,
+			request:   `{"max_retry_attempts": 2, "retry_backoff_delay": "1ms"}`,
+			expStatus: http.StatusInternalServerError,
+		},
+		{
+			note:       "custom status codes",
+			failures:   1,
+			status:     http.StatusInternalServerError,
+			request:    `{"max_retry_attempts": 2, "retry_backoff_delay": "1ms", "retry_status_codes": [500]}`,
+			expStatus:  http.StatusOK,
+			expRetries: 1,
+		},
+		{
+			note:      "backoff bounded by timeout",
+			failures:  1,
+			status:    http.StatusServiceUnavailable,
+			request:   `{"max_retry_attempts": 2, "retry_backoff_delay": "10s", "timeout": "1s"}`,
+			expStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			var calls int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != `{"x":1}` {
+					t.Errorf("unexpected request body %q", body)
+				}
+				if atomic.AddInt32(&calls, 1) <= tc.failures {
+					w.WriteHeader(tc.status)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			req := ast.MustParseTerm(tc.request).Value.(ast.Object)
+			req.Insert(ast.StringTerm("method"), ast.StringTerm("post"))
+			req.Insert(ast.StringTerm("url"), ast.StringTerm(ts.URL))
+			req.Insert(ast.StringTerm("body"), ast.MustParseTerm(`{"x": 1}`))
+
+			m := metrics.New()
+			qrs, err := NewQuery(ast.MustParseBody(fmt.Sprintf(`http.send(%v, x)`, req))).
+				WithMetrics(m).
+				Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if act := qrs[0][ast.Var("x")].Get(ast.StringTerm("status_code")); !act.Equal(ast.IntNumberTerm(tc.expStatus)) {
+				t.Fatalf("expected status %d but got %v", tc.expStatus, act)
+			}
+			if act := m.Counter(httpSendRetries).Value(); act != tc.expRetries {
+				t.Fatalf("expected %d retries but got %d", tc.expRetries, act)
+			}
+		})
+	}
+}
+
+func TestHTTPSendRetriesBoundedByTimeout(t *testing.T) {
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(400 * time.Millisecond)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	start := time.Now()
+	_, err := NewQuery(ast.MustParseBody(fmt.Sprintf(`http.send({"method": "get", "url": %q, "timeout": "500ms", "max_retry_attempts": 1, "retry_backoff_delay": "1ms"}, x)`, ts.URL))).
+		WithStrictBuiltinErrors(true).
+		Run(context.Background())
+
+	// The retry is cancelled when the timeout has passed since the first
+	// attempt, not when it has passed since the retry was sent.
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Fatalf("expected the request to time out after 500ms but it took %v", elapsed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "request timed out") {
+		t.Fatalf("expected timeout error but got %v", err)
+	}
+}
+
+func TestHTTPSendCircuitBreaker(t *testing.T) {
+
+	var healthy int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	m := metrics.New()
+	send := func() (ast.Value, error) {
+		qrs, err := NewQuery(ast.MustParseBody(fmt.Sprintf(`http.send({"method": "get", "url": %q, "raise_error": false, "circuit_breaker_threshold": 2, "circuit_breaker_reset_timeout": "100ms"}, x)`, ts.URL))).
+			WithMetrics(m).
+			Run(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		return qrs[0][ast.Var("x")].Value, nil
+	}
+
+	statusCode := func(resp ast.Value) ast.Value {
+		return resp.(ast.Object).Get(ast.StringTerm("status_code")).Value
+	}
+
+	// Consecutive failures open the circuit breaker, which is shared by queries.
+	for i := 0; i < 2; i++ {
+		resp, err := send()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := ast.Number("503"); statusCode(resp).Compare(exp) != 0 {
+			t.Fatalf("expected status %v but got %v", exp, resp)
+		}
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+
+	resp, err := send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errObj := resp.(ast.Object).Get(ast.StringTerm("error"))
+	if errObj == nil || !strings.Contains(errObj.String(), "circuit breaker open") || !strings.Contains(errObj.String(), HTTPSendNetworkErr) {
+		t.Fatalf("expected circuit breaker error but got %v", resp)
+	}
+	if exp, act := uint64(1), m.Counter(httpSendCircuitBreakerOpen).Value(); exp != act {
+		t.Fatalf("expected %d rejected requests but got %d", exp, act)
+	}
+
+	// After the reset timeout, a successful request closes the breaker.
+	time.Sleep(150 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		resp, err := send()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := ast.Number("200"); statusCode(resp).Compare(exp) != 0 {
+			t.Fatalf("expected status %v but got %v", exp, resp)
+		}
+	}
+}
+
+func TestNewHTTPSendRetryParamsInvalid(t *testing.T) {
+	for _, req := range []string{
+		`{"max_retry_attempts": -1}`,
+		`{"max_retry_attempts": "1"}`,
+		`{"retry_status_codes": 503}`,
+		`{"retry_status_codes": ["503"]}`,
+		`{"retry_backoff_delay": "x"}`,
+		`{"circuit_breaker_threshold": 1.5}`,
+		`{"circuit_breaker_reset_timeout": true}`,
+	} {
+		if _, err := newHTTPSendRetryParams(ast.MustParseTerm(req).Value.(ast.Object)); err == nil {
+			t.Errorf("expected error for %v", req)
+		}
+	}
+}
+
+func TestHTTPSendInterQueryCacheBackend(t *testing.T) {
+
+	var requests int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"x": 1}`))
+	}))
+
+	defer ts.Close()
+
+	// All caches opened below share the entries stored in backend.
+	backend := iCache.NewMemoryBackend()
+	iCache.RegisterBackend("test-shared", func(context.Context, []byte) (iCache.Backend, error) {
+		return backend, nil
+	})
+
+	config, err := iCache.ParseCachingConfig([]byte(`{"inter_query_builtin_cache": {"backend": {"type": "test-shared"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []string{"serialized", "deserialized"} {
+		t.Run(mode, func(t *testing.T) {
+
+			atomic.StoreInt32(&requests, 0)
+
+			query := fmt.Sprintf(`http.send({"method": "get", "url": %q, "cache": true, "caching_mode": %q}, x)`, ts.URL+"/"+mode, mode)
+
+			for i := 0; i < 3; i++ {
+				// every query runs against a new cache, like the OPA instances
+				// sharing the backend would
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				c, err := iCache.OpenInterQueryCache(ctx, config)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				qrs, err := NewQuery(ast.MustParseBody(query)).WithInterQueryBuiltinCache(c).Run(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(qrs) != 1 {
+					t.Fatalf("expected one result but got %v", qrs)
+				}
+
+				body := qrs[0][ast.Var("x")].Get(ast.StringTerm("body"))
+				if body == nil || !body.Equal(ast.MustParseTerm(`{"x": 1}`)) {
+					t.Fatalf("unexpected response: %v", qrs[0][ast.Var("x")])
+				}
+			}
+
+			if n := atomic.LoadInt32(&requests); n != 1 {
+				t.Fatalf("expected one request but got %d", n)
+			}
+		})
+	}
+}
+
 func TestInitDefaults(t *testing.T) {
 	t.Setenv("HTTP_SEND_TIMEOUT", "300mss")
 
@@ -0,0 +1,368 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package httpfixtures implements recorded request/response pairs that
+// http.send serves instead of sending requests over the network, e.g., when
+// running policy tests. Each fixture is stored in a JSON file:
+//
+//	{
+//	  "request": {
+//	    "method": "GET",
+//	    "url": "https://example.com/users/bob",
+//	    "headers": {"Accept": "application/json"}
+//	  },
+//	  "response": {
+//	    "status_code": 200,
+//	    "headers": {"Content-Type": ["application/json"]},
+//	    "body": {"name": "bob"}
+//	  }
+//	}
+//
+// A request matches a fixture if the method, URL and body are equal and the
+// request carries all headers of the fixture. A header value of the form
+// "sha256:<hex digest>" matches request header values with that SHA-256
+// digest, so that fixtures need not contain credentials. JSON bodies are
+// compared by value and can be given with "body"; other bodies are given as
+// strings with "raw_body". Responses with a "body" have the application/json
+// content type unless the headers say otherwise.
+package httpfixtures
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/opa/util"
+)
+
+// Fixture is a recorded http.send request and its response.
+type Fixture struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is the request of a fixture.
+type Request struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+	RawBody string            `json:"raw_body,omitempty"`
+}
+
+// Response is the response of a fixture.
+type Response struct {
+	StatusCode int             `json:"status_code"`
+	Headers    http.Header     `json:"headers,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	RawBody    string          `json:"raw_body,omitempty"`
+}
+
+// Store holds the fixtures served to http.send.
+type Store struct {
+	dir      string
+	record   bool
+	headers  map[string]bool
+	mtx      sync.Mutex
+	fixtures []*Fixture
+}
+
+// Load returns a Store serving the fixtures in the JSON files in dir and its
+// subdirectories. Files are read in lexical order and the first fixture
+// matching a request is served.
+func Load(dir string) (*Store, error) {
+	s := &Store{dir: dir}
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var f Fixture
+		if err := util.UnmarshalJSON(bs, &f); err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+		if f.Request.Method == "" || f.Request.URL == "" {
+			return fmt.Errorf("%v: request method and url must be set", path)
+		}
+		s.fixtures = append(s.fixtures, &f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// NewRecorder returns a Store that sends requests over the network and
+// records the responses into fixture files in dir, creating dir if needed.
+// Fixtures recorded earlier for the same request are replaced.
+//
+// Only the request headers named in headers are recorded. If headers is
+// empty, all headers are recorded except User-Agent and headers carrying
+// credentials, like Authorization and Cookie. Credentials that are recorded
+// because they are named in headers are stored as SHA-256 digests. Response
+// headers carrying credentials, like Set-Cookie, are never recorded.
+func NewRecorder(dir string, headers []string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, record: true}
+	if len(headers) > 0 {
+		s.headers = make(map[string]bool, len(headers))
+		for _, name := range headers {
+			s.headers[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	return s, nil
+}
+
+// Recording returns true if the store records responses.
+func (s *Store) Recording() bool {
+	return s.record
+}
+
+// Fixtures returns the fixtures of the store.
+func (s *Store) Fixtures() []*Fixture {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]*Fixture(nil), s.fixtures...)
+}
+
+// Do returns the response of the fixture matching req. If the store records
+// responses, req is sent with client instead and the response is recorded.
+func (s *Store) Do(req *http.Request, client *http.Client) (*http.Response, error) {
+
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.record {
+		return s.recordResponse(req, body, client)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, f := range s.fixtures {
+		if f.Request.matches(req, body) {
+			return f.Response.httpResponse(req)
+		}
+	}
+
+	return nil, fmt.Errorf("no fixture matches request %v %v", req.Method, req.URL)
+}
+
+func (s *Store) recordResponse(req *http.Request, reqBody []byte, client *http.Client) (*http.Response, error) {
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Fixture{
+		Request: Request{
+			Method: strings.ToUpper(req.Method),
+			URL:    req.URL.String(),
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Headers:    responseHeaders(resp.Header),
+		},
+	}
+
+	for name := range req.Header {
+		if !s.recordsHeader(name) {
+			continue
+		}
+		if f.Request.Headers == nil {
+			f.Request.Headers = map[string]string{}
+		}
+		value := req.Header.Get(name)
+		if sensitiveHeader(name) {
+			value = digestPrefix + digest(value)
+		}
+		f.Request.Headers[name] = value
+	}
+
+	f.Request.Body, f.Request.RawBody = splitBody(reqBody)
+	f.Response.Body, f.Response.RawBody = splitBody(respBody)
+
+	bs, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(filepath.Join(s.dir, f.Request.fileName()), append(bs, '\n'), 0o644); err != nil {
+		return nil, err
+	}
+
+	s.mtx.Lock()
+	s.fixtures = append(s.fixtures, f)
+	s.mtx.Unlock()
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// recordsHeader returns true if the request header is recorded into fixtures.
+func (s *Store) recordsHeader(name string) bool {
+	if s.headers != nil {
+		return s.headers[name]
+	}
+	// The User-Agent header changes with the OPA version.
+	return name != "User-Agent" && !sensitiveHeader(name)
+}
+
+// responseHeaders returns the response headers that are recorded into
+// fixtures, i.e., the headers that do not carry credentials.
+func responseHeaders(header http.Header) http.Header {
+	result := make(http.Header, len(header))
+	for name, values := range header {
+		if !sensitiveHeader(name) {
+			result[name] = values
+		}
+	}
+	return result
+}
+
+// sensitiveHeader returns true if the request or response header likely
+// carries credentials.
+func sensitiveHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie":
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, s := range []string{"token", "secret", "password", "api-key", "apikey"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+const digestPrefix = "sha256:"
+
+func digest(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// fileName returns the name of the fixture file of r, which is the same for
+// equal requests.
+func (r Request) fileName() string {
+	bs, _ := json.Marshal(r) // cannot fail
+	sum := sha256.Sum256(bs)
+	return fmt.Sprintf("%s_%x.json", strings.ToLower(r.Method), sum[:8])
+}
+
+func (r Request) matches(req *http.Request, body []byte) bool {
+	if !strings.EqualFold(r.Method, req.Method) || r.URL != req.URL.String() {
+		return false
+	}
+
+	for name, value := range r.Headers {
+		actual := req.Header.Get(name)
+		if strings.HasPrefix(value, digestPrefix) {
+			if _, ok := req.Header[http.CanonicalHeaderKey(name)]; !ok || !strings.EqualFold(value[len(digestPrefix):], digest(actual)) {
+				return false
+			}
+		} else if actual != value {
+			return false
+		}
+	}
+
+	if r.Body == nil {
+		return r.RawBody == string(body)
+	}
+
+	var exp, act interface{}
+	if err := util.UnmarshalJSON(r.Body, &exp); err != nil {
+		return false
+	}
+	if err := util.UnmarshalJSON(body, &act); err != nil {
+		return false
+	}
+	return util.Compare(exp, act) == 0
+}
+
+func (r Response) httpResponse(req *http.Request) (*http.Response, error) {
+	body := []byte(r.RawBody)
+	if r.Body != nil {
+		body = r.Body
+	}
+
+	header := http.Header{}
+	for name, values := range r.Headers {
+		header[http.CanonicalHeaderKey(name)] = values
+	}
+	if r.Body != nil && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+	if req.Body == nil {
+		return nil, nil
+	}
+	bs, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(bs))
+	return bs, nil
+}
+
+// splitBody returns body as JSON if it is a JSON document, otherwise as a
+// string.
+func splitBody(body []byte) (json.RawMessage, string) {
+	if len(body) == 0 {
+		return nil, ""
+	}
+	var x interface{}
+	if err := util.UnmarshalJSON(body, &x); err == nil {
+		return json.RawMessage(body), ""
+	}
+	return nil, string(body)
+}
@@ -0,0 +1,277 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package httpfixtures
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestStoreDo(t *testing.T) {
+
+	files := map[string]string{
+		"a.json": `{
+			"request": {"method": "GET", "url": "https://example.com/a", "headers": {"Authorization": "Bearer x"}},
+			"response": {"status_code": 200, "body": {"a": 1}}
+		}`,
+		"b.json": `{
+			"request": {"method": "GET", "url": "https://example.com/a"},
+			"response": {"status_code": 401, "raw_body": "unauthorized", "headers": {"content-type": ["text/plain"]}}
+		}`,
+		"sub/c.json": `{
+			"request": {"method": "POST", "url": "https://example.com/c", "body": {"x": [1, 2]}},
+			"response": {"status_code": 201}
+		}`,
+		"sub/d.json": `{
+			"request": {"method": "POST", "url": "https://example.com/d", "raw_body": "hello"},
+			"response": {"status_code": 204}
+		}`,
+		"sub/e.json": `{
+			"request": {"method": "GET", "url": "https://example.com/e", "headers": {"Authorization": "sha256:bffde20413347b7a00e1363de3f97ca69e419dc0aea55f4a4a75018fab3a0e8e"}},
+			"response": {"status_code": 200}
+		}`,
+		"ignored.txt": `not a fixture`,
+	}
+
+	tests := []struct {
+		note        string
+		method      string
+		url         string
+		headers     map[string]string
+		body        string
+		status      int
+		contentType string
+		respBody    string
+		wantErr     bool
+	}{
+		{
+			note:        "headers matched",
+			method:      "get",
+			url:         "https://example.com/a",
+			headers:     map[string]string{"Authorization": "Bearer x", "X-Other": "y"},
+			status:      200,
+			contentType: "application/json",
+			respBody:    `{"a": 1}`,
+		},
+		{
+			note:        "headers not matched",
+			method:      "GET",
+			url:         "https://example.com/a",
+			headers:     map[string]string{"Authorization": "Bearer y"},
+			status:      401,
+			contentType: "text/plain",
+			respBody:    "unauthorized",
+		},
+		{
+			note:   "json body matched by value",
+			method: "POST",
+			url:    "https://example.com/c",
+			body:   `{"x":[1,2.0]}`,
+			status: 201,
+		},
+		{
+			note:    "json body not matched",
+			method:  "POST",
+			url:     "https://example.com/c",
+			body:    `{"x":[2,1]}`,
+			wantErr: true,
+		},
+		{
+			note:   "raw body matched",
+			method: "POST",
+			url:    "https://example.com/d",
+			body:   "hello",
+			status: 204,
+		},
+		{
+			note:    "method not matched",
+			method:  "PUT",
+			url:     "https://example.com/d",
+			body:    "hello",
+			wantErr: true,
+		},
+		{
+			note:    "header digest matched",
+			method:  "GET",
+			url:     "https://example.com/e",
+			headers: map[string]string{"Authorization": "Bearer secret"},
+			status:  200,
+		},
+		{
+			note:    "header digest not matched",
+			method:  "GET",
+			url:     "https://example.com/e",
+			headers: map[string]string{"Authorization": "Bearer other"},
+			wantErr: true,
+		},
+		{
+			note:    "header digest without header",
+			method:  "GET",
+			url:     "https://example.com/e",
+			wantErr: true,
+		},
+		{
+			note:    "url not matched",
+			method:  "GET",
+			url:     "https://example.com/a?x=1",
+			wantErr: true,
+		},
+	}
+
+	test.WithTempFS(files, func(dir string) {
+		s, err := Load(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Fixtures()) != 5 {
+			t.Fatalf("expected 5 fixtures but got %d", len(s.Fixtures()))
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.note, func(t *testing.T) {
+				req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for k, v := range tc.headers {
+					req.Header.Set(k, v)
+				}
+
+				resp, err := s.Do(req, nil)
+				if tc.wantErr {
+					if err == nil || !strings.Contains(err.Error(), "no fixture matches request") {
+						t.Fatalf("expected error but got: %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				body, _ := io.ReadAll(resp.Body)
+				if resp.StatusCode != tc.status || string(body) != tc.respBody || resp.Header.Get("Content-Type") != tc.contentType {
+					t.Fatalf("unexpected response: %d %v %q", resp.StatusCode, resp.Header, body)
+				}
+			})
+		}
+	})
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, content := range []string{
+		`{"request": {"method": "GET"}}`,
+		`{"request": `,
+	} {
+		test.WithTempFS(map[string]string{"x.json": content}, func(dir string) {
+			if _, err := Load(dir); err == nil {
+				t.Fatalf("expected error for %v", content)
+			}
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Echo", string(body))
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Auth-Token", "secret")
+		_, _ = w.Write([]byte("pong"))
+	}))
+	defer ts.Close()
+
+	dir := filepath.Join(t.TempDir(), "fixtures")
+	rec, err := NewRecorder(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(s *Store) *http.Response {
+		req, _ := http.NewRequest("POST", ts.URL+"/ping", strings.NewReader(`{"n": 1}`))
+		req.Header.Set("User-Agent", "test")
+		req.Header.Set("X-Custom", "c")
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("X-Api-Key", "k")
+		resp, err := s.Do(req, ts.Client())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != 200 || string(body) != "pong" || resp.Header.Get("X-Echo") != `{"n": 1}` {
+			t.Fatalf("unexpected response: %d %v %q", resp.StatusCode, resp.Header, body)
+		}
+		return resp
+	}
+
+	// Recording the same request twice replaces the fixture.
+	send(rec)
+	send(rec)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !strings.HasPrefix(entries[0].Name(), "post_") {
+		t.Fatalf("expected one fixture file but got %v", entries)
+	}
+
+	s, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := s.Fixtures()[0]
+	if len(f.Request.Headers) != 1 || f.Request.Headers["X-Custom"] != "c" {
+		t.Fatalf("unexpected recorded headers: %v", f.Request.Headers)
+	}
+	if f.Response.RawBody != "pong" || f.Request.Body == nil {
+		t.Fatalf("unexpected recorded fixture: %+v", f)
+	}
+	if f.Response.Headers.Get("X-Echo") == "" || f.Response.Headers.Get("Set-Cookie") != "" || f.Response.Headers.Get("X-Auth-Token") != "" {
+		t.Fatalf("unexpected recorded response headers: %v", f.Response.Headers)
+	}
+
+	// Named headers are recorded, credentials as digests.
+	dir = filepath.Join(t.TempDir(), "fixtures")
+	rec, err = NewRecorder(dir, []string{"authorization", "User-Agent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(rec)
+
+	s, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f = s.Fixtures()[0]
+	exp := map[string]string{
+		"Authorization": "sha256:bffde20413347b7a00e1363de3f97ca69e419dc0aea55f4a4a75018fab3a0e8e",
+		"User-Agent":    "test",
+	}
+	if !reflect.DeepEqual(f.Request.Headers, exp) {
+		t.Fatalf("expected recorded headers %v but got %v", exp, f.Request.Headers)
+	}
+
+	bs, err := os.ReadFile(filepath.Join(dir, f.Request.fileName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bs), "secret") {
+		t.Fatalf("credentials recorded: %s", bs)
+	}
+
+	ts.Close()
+	send(s)
+}
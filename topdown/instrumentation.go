@@ -13,6 +13,9 @@ const (
 	evalOpBuiltinCall             = "eval_op_builtin_call"
 	evalOpVirtualCacheHit         = "eval_op_virtual_cache_hit"
 	evalOpVirtualCacheMiss        = "eval_op_virtual_cache_miss"
+	evalOpFunctionCacheSkip       = "eval_op_function_cache_skip"
+	evalOpRuleCacheHit            = "eval_op_rule_cache_hit"
+	evalOpRuleCacheMiss           = "eval_op_rule_cache_miss"
 	evalOpBaseCacheHit            = "eval_op_base_cache_hit"
 	evalOpBaseCacheMiss           = "eval_op_base_cache_miss"
 	evalOpComprehensionCacheSkip  = "eval_op_comprehension_cache_skip"
@@ -622,8 +622,203 @@ func builtinJSONPatch(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Ter
 	return iter(target)
 }
 
+func builtinJSONDiff(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	patch := jsonDiff(nil, operands[0], operands[1], []*ast.Term{})
+	return iter(ast.ArrayTerm(patch...))
+}
+
+// jsonDiff appends the JSON-Patch operations that turn a into b to patch.
+// Objects and arrays are compared recursively. Sets, and objects with
+// non-string keys, cannot be addressed by JSON pointers, and are replaced as
+// a whole.
+func jsonDiff(path []string, a, b *ast.Term, patch []*ast.Term) []*ast.Term {
+	if a.Equal(b) {
+		return patch
+	}
+
+	switch av := a.Value.(type) {
+	case ast.Object:
+		if bv, ok := b.Value.(ast.Object); ok && hasStringKeys(av) && hasStringKeys(bv) {
+			return jsonDiffObjects(path, av, bv, patch)
+		}
+	case *ast.Array:
+		if bv, ok := b.Value.(*ast.Array); ok {
+			return jsonDiffArrays(path, av, bv, patch)
+		}
+	}
+
+	return append(patch, jsonPatchOp("replace", path, b))
+}
+
+func jsonDiffObjects(path []string, a, b ast.Object, patch []*ast.Term) []*ast.Term {
+	a.Foreach(func(k, v *ast.Term) {
+		key := string(k.Value.(ast.String))
+		if w := b.Get(k); w != nil {
+			patch = jsonDiff(appendPath(path, key), v, w, patch)
+		} else {
+			patch = append(patch, jsonPatchOp("remove", appendPath(path, key), nil))
+		}
+	})
+	b.Foreach(func(k, v *ast.Term) {
+		if a.Get(k) == nil {
+			patch = append(patch, jsonPatchOp("add", appendPath(path, string(k.Value.(ast.String))), v))
+		}
+	})
+	return patch
+}
+
+// jsonDiffMaxLCS bounds the size of the table of the longest common
+// subsequence of two arrays. Larger arrays are compared element by element.
+const jsonDiffMaxLCS = 1 << 20
+
+// jsonDiffArrays keeps the longest common subsequence of the elements of a
+// and b. The elements removed and added between two kept elements are
+// diffed pairwise, and the surplus ones are removed or added.
+func jsonDiffArrays(path []string, a, b *ast.Array, patch []*ast.Term) []*ast.Term {
+	// Common prefixes and suffixes are kept.
+	prefix := 0
+	for prefix < a.Len() && prefix < b.Len() && a.Elem(prefix).Equal(b.Elem(prefix)) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < a.Len()-prefix && suffix < b.Len()-prefix && a.Elem(a.Len()-1-suffix).Equal(b.Elem(b.Len()-1-suffix)) {
+		suffix++
+	}
+	n, m := a.Len()-prefix-suffix, b.Len()-prefix-suffix
+
+	var keep [][2]int // pairs of indices of kept elements, in order
+	if n*m <= jsonDiffMaxLCS {
+		// lcs[i][j] is the length of the longest common subsequence of the
+		// elements from i and j on.
+		lcs := make([][]int, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				switch {
+				case a.Elem(prefix + i).Equal(b.Elem(prefix + j)):
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				case lcs[i+1][j] >= lcs[i][j+1]:
+					lcs[i][j] = lcs[i+1][j]
+				default:
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		for i, j := 0, 0; i < n && j < m; {
+			switch {
+			case a.Elem(prefix + i).Equal(b.Elem(prefix + j)):
+				keep = append(keep, [2]int{i, j})
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				i++
+			default:
+				j++
+			}
+		}
+	}
+	keep = append(keep, [2]int{n, m})
+
+	// idx is the index in the patched array of the next element of a.
+	idx := prefix
+	i, j := 0, 0
+	for _, k := range keep {
+		for ; i < k[0] && j < k[1]; i, j = i+1, j+1 {
+			patch = jsonDiff(appendPath(path, strconv.Itoa(idx)), a.Elem(prefix+i), b.Elem(prefix+j), patch)
+			idx++
+		}
+		for ; i < k[0]; i++ {
+			patch = append(patch, jsonPatchOp("remove", appendPath(path, strconv.Itoa(idx)), nil))
+		}
+		for ; j < k[1]; j++ {
+			patch = append(patch, jsonPatchOp("add", appendPath(path, strconv.Itoa(idx)), b.Elem(prefix+j)))
+			idx++
+		}
+		// Skip the kept element.
+		i, j = i+1, j+1
+		idx++
+	}
+	return patch
+}
+
+func hasStringKeys(obj ast.Object) bool {
+	for _, k := range obj.Keys() {
+		if _, ok := k.Value.(ast.String); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func appendPath(path []string, key string) []string {
+	result := make([]string, len(path), len(path)+1)
+	copy(result, path)
+	return append(result, key)
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// jsonPatchOp returns a JSON-Patch operation. The value is omitted if nil.
+func jsonPatchOp(op string, path []string, value *ast.Term) *ast.Term {
+	var pointer strings.Builder
+	for _, key := range path {
+		pointer.WriteByte('/')
+		pointer.WriteString(jsonPointerEscaper.Replace(key))
+	}
+
+	obj := ast.NewObject(
+		ast.Item(ast.StringTerm("op"), ast.StringTerm(op)),
+		ast.Item(ast.StringTerm("path"), ast.StringTerm(pointer.String())),
+	)
+	if value != nil {
+		obj.Insert(ast.StringTerm("value"), value)
+	}
+	return ast.NewTerm(obj)
+}
+
+func builtinJSONMergePatch(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	return iter(jsonMergePatch(operands[0], operands[1]))
+}
+
+// jsonMergePatch applies a JSON Merge Patch (RFC 7386) to target: members of
+// patch objects are merged recursively into target objects, and null members
+// remove the target members. Other patches replace the target.
+func jsonMergePatch(target, patch *ast.Term) *ast.Term {
+	patchObj, ok := patch.Value.(ast.Object)
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.Value.(ast.Object)
+	if !ok {
+		targetObj = ast.NewObject()
+	}
+
+	result := ast.NewObject()
+	targetObj.Foreach(func(k, v *ast.Term) {
+		if patchObj.Get(k) == nil {
+			result.Insert(k, v)
+		}
+	})
+	patchObj.Foreach(func(k, v *ast.Term) {
+		if _, ok := v.Value.(ast.Null); ok {
+			return
+		}
+		existing := targetObj.Get(k)
+		if existing == nil {
+			existing = ast.NullTerm()
+		}
+		result.Insert(k, jsonMergePatch(existing, v))
+	})
+	return ast.NewTerm(result)
+}
+
 func init() {
 	RegisterBuiltinFunc(ast.JSONFilter.Name, builtinJSONFilter)
 	RegisterBuiltinFunc(ast.JSONRemove.Name, builtinJSONRemove)
 	RegisterBuiltinFunc(ast.JSONPatch.Name, builtinJSONPatch)
+	RegisterBuiltinFunc(ast.JSONDiff.Name, builtinJSONDiff)
+	RegisterBuiltinFunc(ast.JSONMergePatch.Name, builtinJSONMergePatch)
 }
@@ -0,0 +1,56 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/jsonpath"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+// jsonpathCacheMaxSize is the number of parsed queries kept in jsonpathCache.
+const jsonpathCacheMaxSize = 100
+
+var jsonpathCache = newLRUCache(jsonpathCacheMaxSize)
+
+func builtinJSONPathQuery(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	s, err := builtins.StringOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	query, err := getJSONPathQuery(string(s))
+	if err != nil {
+		return err
+	}
+
+	matches := query.Eval(operands[0].Value)
+	result := make([]*ast.Term, len(matches))
+	for i, m := range matches {
+		result[i] = ast.ObjectTerm(
+			ast.Item(ast.StringTerm("path"), ast.ArrayTerm(m.Path...)),
+			ast.Item(ast.StringTerm("value"), ast.NewTerm(m.Value)),
+		)
+	}
+	return iter(ast.ArrayTerm(result...))
+}
+
+func getJSONPathQuery(s string) (*jsonpath.Query, error) {
+	if query, ok := jsonpathCache.Get(s); ok {
+		return query.(*jsonpath.Query), nil
+	}
+	query, err := jsonpath.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSONPath query %q: %w", s, err)
+	}
+	jsonpathCache.Put(s, query)
+	return query, nil
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.JSONPathQuery.Name, builtinJSONPathQuery)
+}
@@ -0,0 +1,143 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/jwx/jwe"
+	"github.com/open-policy-agent/opa/internal/jwx/jwk"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+// Implements JWE decryption as described in RFC 7516 Section 5.2:
+// https://tools.ietf.org/html/rfc7516#section-5.2
+// Only the compact serialization is supported.
+func builtinJWEDecrypt(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	compact, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	src, err := jwkOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	msg, err := jwe.Parse(string(compact))
+	if err != nil {
+		return err
+	}
+
+	header, err := extractJSONObject(string(msg.ProtectedHeader()))
+	if err != nil {
+		return fmt.Errorf("bad JWE header: %v", err)
+	}
+
+	keys, err := jwk.ParseStringAll(src)
+	if err != nil {
+		return fmt.Errorf("failed to parse a JWK key (set): %w", err)
+	}
+
+	// If the JWE names its key, only that key is tried. Otherwise every key
+	// is tried until one decrypts the content.
+	kid := msg.Headers.KeyID
+	lastErr := fmt.Errorf("no key with ID %s", kid)
+	for _, k := range keys.Keys {
+		if kid != "" && k.GetKeyID() != kid {
+			continue
+		}
+		key, err := k.Materialize()
+		if err != nil {
+			return err
+		}
+		plaintext, err := msg.Decrypt(key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return iter(ast.ArrayTerm(ast.NewTerm(header), ast.StringTerm(string(plaintext))))
+	}
+
+	if kid == "" && len(keys.Keys) == 0 {
+		return fmt.Errorf("no key to decrypt JWE")
+	}
+	return fmt.Errorf("failed to decrypt JWE: %w", lastErr)
+}
+
+// Implements JWE encryption as described in RFC 7516 Section 5.1:
+// https://tools.ietf.org/html/rfc7516#section-5.1
+// The JWE is serialized in compact serialization.
+func builtinJWEEncrypt(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	headers, err := builtins.ObjectOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	payload, err := builtins.StringOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	src, err := jwkOperand(operands[2].Value, 3)
+	if err != nil {
+		return err
+	}
+
+	x, err := ast.JSON(headers)
+	if err != nil {
+		return err
+	}
+	hdrBuf, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+
+	keys, err := jwk.ParseStringAll(src)
+	if err != nil {
+		return fmt.Errorf("failed to parse a JWK key: %w", err)
+	}
+	if len(keys.Keys) != 1 {
+		return fmt.Errorf("key must be a single JWK, found %d keys", len(keys.Keys))
+	}
+	key, err := keys.Keys[0].Materialize()
+	if err != nil {
+		return err
+	}
+
+	compact, err := jwe.Encrypt([]byte(payload), hdrBuf, key, bctx.Seed)
+	if err != nil {
+		return err
+	}
+	return iter(ast.StringTerm(string(compact)))
+}
+
+// jwkOperand returns the JSON encoding of a JWK (set) operand, which may be
+// given as an object or as a JSON encoded string.
+func jwkOperand(value ast.Value, pos int) (string, error) {
+	switch v := value.(type) {
+	case ast.String:
+		return string(v), nil
+	case ast.Object:
+		x, err := ast.JSON(v)
+		if err != nil {
+			return "", err
+		}
+		bs, err := json.Marshal(x)
+		if err != nil {
+			return "", err
+		}
+		return string(bs), nil
+	default:
+		return "", builtins.NewOperandTypeErr(pos, value, "string", "object")
+	}
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.JWEDecrypt.Name, builtinJWEDecrypt)
+	RegisterBuiltinFunc(ast.JWEEncrypt.Name, builtinJWEEncrypt)
+}
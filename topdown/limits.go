@@ -0,0 +1,155 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// Limits bounds the resources used by a query evaluation. Zero values mean
+// no limit. Evaluation stops with an Error when a limit is exceeded; the
+// Error codes are StepLimitErr, MemoryLimitErr, ResultLimitErr and
+// CallDepthLimitErr respectively.
+type Limits struct {
+	// MaxSteps limits the number of expressions evaluated.
+	MaxSteps int64 `json:"max_steps,omitempty"`
+	// MaxMemoryBytes limits the approximate size of the values produced by
+	// built-in functions, comprehensions and rules.
+	MaxMemoryBytes int64 `json:"max_memory_bytes,omitempty"`
+	// MaxResults limits the number of results of the query.
+	MaxResults int `json:"max_results,omitempty"`
+	// MaxCallDepth limits the nesting depth of user-defined function calls.
+	MaxCallDepth int `json:"max_call_depth,omitempty"`
+}
+
+// Validate returns an error if any of the limits is negative.
+func (l Limits) Validate() error {
+	switch {
+	case l.MaxSteps < 0:
+		return fmt.Errorf("invalid max_steps %d", l.MaxSteps)
+	case l.MaxMemoryBytes < 0:
+		return fmt.Errorf("invalid max_memory_bytes %d", l.MaxMemoryBytes)
+	case l.MaxResults < 0:
+		return fmt.Errorf("invalid max_results %d", l.MaxResults)
+	case l.MaxCallDepth < 0:
+		return fmt.Errorf("invalid max_call_depth %d", l.MaxCallDepth)
+	}
+	return nil
+}
+
+// evalLimits tracks the resources used by an evaluation. It is shared by
+// the forks of parallel evaluation, so the counters are updated atomically.
+type evalLimits struct {
+	Limits
+	steps       int64
+	memoryBytes int64
+}
+
+func newEvalLimits(l Limits) *evalLimits {
+	if l.MaxSteps == 0 && l.MaxMemoryBytes == 0 && l.MaxCallDepth == 0 {
+		return nil
+	}
+	return &evalLimits{Limits: l}
+}
+
+func (l *evalLimits) step(loc *ast.Location) error {
+	if l == nil || l.MaxSteps == 0 {
+		return nil
+	}
+	if atomic.AddInt64(&l.steps, 1) > l.MaxSteps {
+		return &Error{
+			Code:     StepLimitErr,
+			Message:  fmt.Sprintf("evaluation exceeded the maximum number of steps (%d)", l.MaxSteps),
+			Location: loc,
+		}
+	}
+	return nil
+}
+
+// alloc accounts for the values produced at loc.
+func (l *evalLimits) alloc(loc *ast.Location, terms ...*ast.Term) error {
+	if l == nil || l.MaxMemoryBytes == 0 {
+		return nil
+	}
+	var n int64
+	for _, t := range terms {
+		n += termSizeBytes(t)
+	}
+	if atomic.AddInt64(&l.memoryBytes, n) > l.MaxMemoryBytes {
+		return &Error{
+			Code:     MemoryLimitErr,
+			Message:  fmt.Sprintf("evaluation exceeded the maximum memory (%d bytes)", l.MaxMemoryBytes),
+			Location: loc,
+		}
+	}
+	return nil
+}
+
+func (l *evalLimits) call(loc *ast.Location, depth int) error {
+	if l == nil || l.MaxCallDepth == 0 || depth <= l.MaxCallDepth {
+		return nil
+	}
+	return &Error{
+		Code:     CallDepthLimitErr,
+		Message:  fmt.Sprintf("function calls exceeded the maximum depth (%d)", l.MaxCallDepth),
+		Location: loc,
+	}
+}
+
+func resultLimitErr(max int) error {
+	return &Error{
+		Code:    ResultLimitErr,
+		Message: fmt.Sprintf("query produced more than the maximum number of results (%d)", max),
+	}
+}
+
+// Approximate sizes of the Go representations of AST values, not counting
+// the contents of strings and collections.
+const (
+	termOverheadBytes       = 32
+	scalarOverheadBytes     = 16
+	collectionOverheadBytes = 48
+)
+
+// termSizeBytes returns the approximate number of bytes used by t.
+func termSizeBytes(t *ast.Term) int64 {
+	if t == nil {
+		return 0
+	}
+	size := int64(termOverheadBytes)
+	switch v := t.Value.(type) {
+	case ast.String:
+		size += scalarOverheadBytes + int64(len(v))
+	case ast.Number:
+		size += scalarOverheadBytes + int64(len(v))
+	case ast.Var:
+		size += scalarOverheadBytes + int64(len(v))
+	case *ast.Array:
+		size += collectionOverheadBytes
+		for i := 0; i < v.Len(); i++ {
+			size += termSizeBytes(v.Elem(i))
+		}
+	case ast.Set:
+		size += collectionOverheadBytes
+		v.Foreach(func(x *ast.Term) {
+			size += termSizeBytes(x)
+		})
+	case ast.Object:
+		size += collectionOverheadBytes
+		v.Foreach(func(k, x *ast.Term) {
+			size += termSizeBytes(k) + termSizeBytes(x)
+		})
+	case ast.Ref:
+		for i := range v {
+			size += termSizeBytes(v[i])
+		}
+	default:
+		size += scalarOverheadBytes
+	}
+	return size
+}
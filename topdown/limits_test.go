@@ -0,0 +1,132 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestTopdownLimits(t *testing.T) {
+
+	module := `package p
+
+		count_to(n) = x { x := count([i | numbers.range(1, n)[i]; i >= 0]) }
+
+		big(n) = x { x := concat("", [s | numbers.range(1, n)[_]; s := "xxxxxxxxxx"]) }
+
+		f(x) = y { y := g(x) }
+		g(x) = y { y := h(x) }
+		h(x) = x
+
+		xs := [1, 2, 3]`
+
+	tests := []struct {
+		note    string
+		query   string
+		limits  Limits
+		expCode string
+	}{
+		{
+			note:   "no limits",
+			query:  `data.p.count_to(1000, x); data.p.big(1000, y); data.p.f(1, z); data.p.xs[i] = a`,
+			limits: Limits{},
+		},
+		{
+			note:   "steps within limit",
+			query:  `data.p.count_to(10, x)`,
+			limits: Limits{MaxSteps: 100},
+		},
+		{
+			note:    "steps exceeded",
+			query:   `data.p.count_to(1000, x)`,
+			limits:  Limits{MaxSteps: 100},
+			expCode: StepLimitErr,
+		},
+		{
+			note:   "memory within limit",
+			query:  `data.p.big(10, x)`,
+			limits: Limits{MaxMemoryBytes: 10000},
+		},
+		{
+			note:    "memory exceeded",
+			query:   `data.p.big(1000, x)`,
+			limits:  Limits{MaxMemoryBytes: 10000},
+			expCode: MemoryLimitErr,
+		},
+		{
+			note:   "results within limit",
+			query:  `data.p.xs[i] = x`,
+			limits: Limits{MaxResults: 3},
+		},
+		{
+			note:    "results exceeded",
+			query:   `data.p.xs[i] = x`,
+			limits:  Limits{MaxResults: 2},
+			expCode: ResultLimitErr,
+		},
+		{
+			note:   "call depth within limit",
+			query:  `data.p.f(1, x)`,
+			limits: Limits{MaxCallDepth: 3},
+		},
+		{
+			note:    "call depth exceeded",
+			query:   `data.p.f(1, x)`,
+			limits:  Limits{MaxCallDepth: 2},
+			expCode: CallDepthLimitErr,
+		},
+	}
+
+	compiler := ast.MustCompileModules(map[string]string{"test.rego": module})
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			for _, parallelism := range []int{1, 4} {
+				_, err := NewQuery(ast.MustParseBody(tc.query)).
+					WithCompiler(compiler).
+					WithLimits(tc.limits).
+					WithParallelism(parallelism).
+					Run(context.Background())
+
+				if tc.expCode == "" {
+					if err != nil {
+						t.Fatalf("parallelism %d: unexpected error: %v", parallelism, err)
+					}
+					continue
+				}
+
+				var topdownErr *Error
+				if !errors.As(err, &topdownErr) || topdownErr.Code != tc.expCode {
+					t.Fatalf("parallelism %d: expected %v error but got: %v", parallelism, tc.expCode, err)
+				}
+			}
+		})
+	}
+}
+
+func TestTopdownLimitsOptions(t *testing.T) {
+
+	q := NewQuery(ast.MustParseBody(`true`)).
+		WithMaxSteps(1).
+		WithMaxMemoryBytes(2).
+		WithMaxResults(3).
+		WithMaxCallDepth(4)
+
+	if exp := (Limits{MaxSteps: 1, MaxMemoryBytes: 2, MaxResults: 3, MaxCallDepth: 4}); q.limits != exp {
+		t.Fatalf("expected %+v but got %+v", exp, q.limits)
+	}
+
+	if err := (Limits{MaxSteps: -1}).Validate(); err == nil {
+		t.Fatal("expected error for negative limit")
+	}
+
+	if err := q.limits.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
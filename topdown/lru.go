@@ -0,0 +1,66 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache holds up to a fixed number of values, e.g., compiled schemas keyed
+// by their source, evicting the least recently used value first. It is safe
+// for concurrent use.
+type lruCache struct {
+	mtx     sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	l       *list.List // from the most to the least recently used
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		entries: map[string]*list.Element{},
+		l:       list.New(),
+	}
+}
+
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.l.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+func (c *lruCache) Put(key string, value interface{}) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*lruEntry).value = value
+		c.l.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.l.PushFront(&lruEntry{key: key, value: value})
+	if c.l.Len() > c.size {
+		e := c.l.Back()
+		c.l.Remove(e)
+		delete(c.entries, e.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lruCache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.l.Len()
+}
@@ -0,0 +1,33 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import "testing"
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	c.Put("a", 1)
+	c.Put("b", 2)
+
+	// Using "a" makes "b" the least recently used value.
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected 1 but got %v", v)
+	}
+	c.Put("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	for k, exp := range map[string]int{"a": 1, "c": 3} {
+		if v, ok := c.Get(k); !ok || v != exp {
+			t.Fatalf("expected %v for %v but got %v", exp, k, v)
+		}
+	}
+
+	c.Put("c", 4)
+	if v, _ := c.Get("c"); v != 4 || c.Len() != 2 {
+		t.Fatalf("expected 4 and 2 entries but got %v and %d", v, c.Len())
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// functionMemo decides which user-defined functions have their results
+// memoized during a query. Calls are memoized unless the memoization was
+// disabled with the 'memoize: false' annotation.
+type functionMemo struct {
+	compiler *ast.Compiler
+	memoize  map[string]bool // function path -> memoize
+}
+
+func newFunctionMemo(compiler *ast.Compiler) *functionMemo {
+	return &functionMemo{
+		compiler: compiler,
+		memoize:  map[string]bool{},
+	}
+}
+
+// fork returns a functionMemo for the same functions that can be used
+// concurrently with m.
+func (m *functionMemo) fork() *functionMemo {
+	if m == nil {
+		return nil
+	}
+	return newFunctionMemo(m.compiler)
+}
+
+// Memoize returns true if the results of calls to the function at path can be
+// memoized.
+func (m *functionMemo) Memoize(path ast.Ref) bool {
+	if m == nil || m.compiler == nil {
+		return true
+	}
+
+	key := path.String()
+	if result, ok := m.memoize[key]; ok {
+		return result
+	}
+
+	result := m.enabled(m.compiler.GetRulesExact(path))
+	m.memoize[key] = result
+	return result
+}
+
+// enabled returns false if memoization is disabled for any of the rules. The
+// annotation closest to a rule takes precedence.
+func (m *functionMemo) enabled(rules []*ast.Rule) bool {
+	as := m.compiler.GetAnnotationSet()
+	if as == nil {
+		return true
+	}
+
+	for _, rule := range rules {
+		for _, ref := range as.Chain(rule) {
+			if ref.Annotations != nil && ref.Annotations.Memoize != nil {
+				if !*ref.Annotations.Memoize {
+					return false
+				}
+				break
+			}
+		}
+	}
+
+	return true
+}
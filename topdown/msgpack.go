@@ -0,0 +1,405 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// msgpackTimestamp is the extension type of timestamps.
+const msgpackTimestamp = -1
+
+type msgpackDecoder struct {
+	buf   []byte
+	depth int
+}
+
+// decodeMessagePack decodes a single MessagePack object. Maps become objects,
+// binary data becomes base64url encoded strings without padding, like CBOR
+// byte strings, and timestamps become RFC 3339 strings. Other extension types
+// are not supported.
+func decodeMessagePack(bs []byte) (*ast.Term, error) {
+	d := &msgpackDecoder{buf: bs}
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+	if len(d.buf) > 0 {
+		return nil, fmt.Errorf("unexpected data after MessagePack object")
+	}
+	return v, nil
+}
+
+// next consumes n bytes.
+func (d *msgpackDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.buf)) {
+		return nil, errBinaryEOF
+	}
+	bs := d.buf[:n]
+	d.buf = d.buf[n:]
+	return bs, nil
+}
+
+// uint reads a big-endian unsigned integer of n bytes.
+func (d *msgpackDecoder) uint(n uint64) (uint64, error) {
+	bs, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, b := range bs {
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+func (d *msgpackDecoder) value() (*ast.Term, error) {
+	bs, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	b := bs[0]
+
+	switch {
+	case b <= 0x7f:
+		return ast.IntNumberTerm(int(b)), nil
+	case b >= 0xe0:
+		return ast.IntNumberTerm(int(int8(b))), nil
+	case b <= 0x8f:
+		return d.object(uint64(b & 0x0f))
+	case b <= 0x9f:
+		return d.array(uint64(b & 0x0f))
+	case b <= 0xbf:
+		return d.str(uint64(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return ast.NullTerm(), nil
+	case 0xc2:
+		return ast.BooleanTerm(false), nil
+	case 0xc3:
+		return ast.BooleanTerm(true), nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		bin, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return ast.StringTerm(base64.RawURLEncoding.EncodeToString(bin)), nil
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uint(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(n)
+	case 0xca:
+		v, err := d.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackFloat(float64(math.Float32frombits(uint32(v))))
+	case 0xcb:
+		v, err := d.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackFloat(math.Float64frombits(v))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.uint(1 << (b - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return ast.UIntNumberTerm(v), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := uint64(1) << (b - 0xd0)
+		v, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign extend.
+		shift := 64 - 8*size
+		return ast.NewTerm(ast.Number(fmt.Sprint(int64(v<<shift) >> shift))), nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(n)
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(n)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.object(n)
+	}
+	return nil, fmt.Errorf("invalid type 0x%02x", b)
+}
+
+func (d *msgpackDecoder) enter() error {
+	d.depth++
+	if d.depth > binaryMaxDepth {
+		return fmt.Errorf("data nested deeper than %d levels", binaryMaxDepth)
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) leave() {
+	d.depth--
+}
+
+func (d *msgpackDecoder) str(n uint64) (*ast.Term, error) {
+	bs, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(bs) {
+		return nil, fmt.Errorf("invalid UTF-8 in string")
+	}
+	return ast.StringTerm(string(bs)), nil
+}
+
+func (d *msgpackDecoder) array(n uint64) (*ast.Term, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	var elems []*ast.Term
+	for i := uint64(0); i < n; i++ {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, v)
+	}
+	return ast.ArrayTerm(elems...), nil
+}
+
+func (d *msgpackDecoder) object(n uint64) (*ast.Term, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	obj := ast.NewObject()
+	for i := uint64(0); i < n; i++ {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		if obj.Get(k) != nil {
+			return nil, fmt.Errorf("duplicate map key %v", k)
+		}
+		obj.Insert(k, v)
+	}
+	return ast.NewTerm(obj), nil
+}
+
+// ext reads an extension object with n bytes of data.
+func (d *msgpackDecoder) ext(n uint64) (*ast.Term, error) {
+	typ, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(typ[0]) != msgpackTimestamp {
+		return nil, fmt.Errorf("unsupported extension type %d", int8(typ[0]))
+	}
+
+	var sec int64
+	var nsec uint32
+	switch len(data) {
+	case 4:
+		sec = int64(binary.BigEndian.Uint32(data))
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		nsec, sec = uint32(v>>34), int64(v&(1<<34-1))
+	case 12:
+		nsec, sec = binary.BigEndian.Uint32(data), int64(binary.BigEndian.Uint64(data[4:]))
+	default:
+		return nil, fmt.Errorf("invalid timestamp length %d", len(data))
+	}
+	if nsec >= 1e9 {
+		return nil, fmt.Errorf("invalid timestamp nanoseconds %d", nsec)
+	}
+	return ast.StringTerm(time.Unix(sec, int64(nsec)).UTC().Format(time.RFC3339Nano)), nil
+}
+
+func msgpackFloat(f float64) (*ast.Term, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("unsupported floating-point value %v", f)
+	}
+	return ast.FloatNumberTerm(f), nil
+}
+
+// encodeMessagePack encodes a value in the shortest formats. Sets are encoded
+// as arrays, and map keys are sorted by their encoding, so the result is
+// deterministic.
+func encodeMessagePack(buf []byte, v ast.Value) ([]byte, error) {
+	switch v := v.(type) {
+	case ast.Null:
+		return append(buf, 0xc0), nil
+	case ast.Boolean:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case ast.Number:
+		return encodeMessagePackNumber(buf, v)
+	case ast.String:
+		buf = msgpackHead(buf, len(v), 0xa0, 32, 0xd9, true)
+		return append(buf, v...), nil
+	case *ast.Array:
+		buf = msgpackHead(buf, v.Len(), 0x90, 16, 0xdc, false)
+		var err error
+		for i := 0; i < v.Len() && err == nil; i++ {
+			buf, err = encodeMessagePack(buf, v.Elem(i).Value)
+		}
+		return buf, err
+	case ast.Set:
+		return encodeMessagePack(buf, v.Sorted())
+	case ast.Object:
+		type entry struct{ key, value []byte }
+		entries := make([]entry, 0, v.Len())
+		err := v.Iter(func(k, val *ast.Term) error {
+			kb, err := encodeMessagePack(nil, k.Value)
+			if err != nil {
+				return err
+			}
+			vb, err := encodeMessagePack(nil, val.Value)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{kb, vb})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		buf = msgpackHead(buf, len(entries), 0x80, 16, 0xde, false)
+		for _, e := range entries {
+			buf = append(append(buf, e.key...), e.value...)
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("%v cannot be encoded", ast.TypeName(v))
+}
+
+// msgpackHead appends the type and length of a string, array or map: the fix
+// format if n is less than fixLimit, or else the first format from first on
+// that fits. Only strings have a format with an 8-bit length.
+func msgpackHead(buf []byte, n int, fix byte, fixLimit int, first byte, has8 bool) []byte {
+	switch {
+	case n < fixLimit:
+		return append(buf, fix|byte(n))
+	case has8 && n <= math.MaxUint8:
+		return append(buf, first, byte(n))
+	}
+	if has8 {
+		first++
+	}
+	if n <= math.MaxUint16 {
+		return append(buf, first, byte(n>>8), byte(n))
+	}
+	return appendUint32(append(buf, first+1), uint32(n))
+}
+
+func encodeMessagePackNumber(buf []byte, n ast.Number) ([]byte, error) {
+	i, f, err := binaryNumber(n)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case i == nil:
+		if f32 := float32(f); float64(f32) == f {
+			return appendUint32(append(buf, 0xca), math.Float32bits(f32)), nil
+		}
+		return appendUint64(append(buf, 0xcb), math.Float64bits(f)), nil
+	case i.IsUint64():
+		u := i.Uint64()
+		switch {
+		case u <= 0x7f:
+			return append(buf, byte(u)), nil
+		case u <= math.MaxUint8:
+			return append(buf, 0xcc, byte(u)), nil
+		case u <= math.MaxUint16:
+			return append(buf, 0xcd, byte(u>>8), byte(u)), nil
+		case u <= math.MaxUint32:
+			return appendUint32(append(buf, 0xce), uint32(u)), nil
+		}
+		return appendUint64(append(buf, 0xcf), u), nil
+	case i.IsInt64():
+		s := i.Int64()
+		switch {
+		case s >= -32:
+			return append(buf, byte(s)), nil
+		case s >= math.MinInt8:
+			return append(buf, 0xd0, byte(s)), nil
+		case s >= math.MinInt16:
+			return append(buf, 0xd1, byte(s>>8), byte(s)), nil
+		case s >= math.MinInt32:
+			return appendUint32(append(buf, 0xd2), uint32(s)), nil
+		}
+		return appendUint64(append(buf, 0xd3), uint64(s)), nil
+	}
+	return nil, fmt.Errorf("number %v cannot be represented", n)
+}
+
+func builtinMessagePackDecode(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	bs, err := binaryOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+	v, err := decodeMessagePack(bs)
+	if err != nil {
+		return fmt.Errorf("invalid MessagePack: %v", err)
+	}
+	return iter(v)
+}
+
+func builtinMessagePackEncode(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	bs, err := encodeMessagePack(nil, operands[0].Value)
+	if err != nil {
+		return err
+	}
+	return iter(ast.StringTerm(base64.StdEncoding.EncodeToString(bs)))
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.MessagePackDecode.Name, builtinMessagePackDecode)
+	RegisterBuiltinFunc(ast.MessagePackEncode.Name, builtinMessagePackEncode)
+}
@@ -0,0 +1,327 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+	"github.com/open-policy-agent/opa/topdown/print"
+)
+
+// workerPool bounds the number of goroutines that evaluate rule definitions and
+// comprehension iterations in parallel (see Query.WithParallelism). The pool is
+// shared by all evaluations of a query, including nested ones. Tasks are run on
+// the calling goroutine when all workers are busy, so nested parallel
+// evaluation cannot deadlock.
+type workerPool struct {
+	tokens chan struct{}
+}
+
+// forkLocks serialize the access of the forks of an evaluation to state they
+// share that is not safe for concurrent use: the built-in function cache and
+// the storage transaction, e.g., of the disk store.
+type forkLocks struct {
+	builtins sync.Mutex
+	store    sync.Mutex
+}
+
+// stdlibBuiltins holds the names of the built-in functions that come with OPA.
+var stdlibBuiltins = func() map[string]struct{} {
+	names := make(map[string]struct{}, len(ast.DefaultBuiltins))
+	for _, bi := range ast.DefaultBuiltins {
+		names[bi.Name] = struct{}{}
+	}
+	return names
+}()
+
+func newWorkerPool(n int) *workerPool {
+	if n <= 1 {
+		return nil
+	}
+	// The calling goroutine is a worker too.
+	return &workerPool{tokens: make(chan struct{}, n-1)}
+}
+
+// run calls task for 0 <= i < n. Tasks are started in order and tasks after a
+// failed task are not started. The error returned is the error of the first
+// failed task in that order, i.e., the error that sequential evaluation
+// returns. Panics in tasks are propagated to the caller.
+func (p *workerPool) run(n int, task func(i int) error) error {
+
+	errs := make([]error, n)
+	panics := make([]interface{}, n)
+	var failed int32
+	var wg sync.WaitGroup
+
+	call := func(i int) {
+		defer func() {
+			if r := recover(); r != nil {
+				panics[i] = r
+				atomic.StoreInt32(&failed, 1)
+			}
+		}()
+		if errs[i] = task(i); errs[i] != nil {
+			atomic.StoreInt32(&failed, 1)
+		}
+	}
+
+	for i := 0; i < n && atomic.LoadInt32(&failed) == 0; i++ {
+		select {
+		case p.tokens <- struct{}{}:
+			wg.Add(1)
+			go func(i int) {
+				defer func() {
+					<-p.tokens
+					wg.Done()
+				}()
+				call(i)
+			}(i)
+		default:
+			call(i)
+		}
+	}
+
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if panics[i] != nil {
+			panic(panics[i])
+		}
+		if errs[i] != nil {
+			return errs[i]
+		}
+	}
+
+	return nil
+}
+
+// parallel returns true if queries evaluated by e may be split into tasks
+// that run in parallel. Parallel evaluation is disabled while tracing or
+// instrumenting the evaluation, which rely on the order of events, and during
+// partial evaluation.
+func (e *eval) parallel() bool {
+	return e.workers != nil && !e.traceEnabled && e.instr == nil && !e.partial()
+}
+
+// fork returns a child of e evaluating query that can be evaluated
+// concurrently with other forks of e. State that is modified during
+// evaluation is copied or replaced with empty state, except for the built-in
+// function cache, which the forks share with e so that, e.g., rand.intn and
+// http.send return the same values for the same arguments in all forks. Forks
+// must be joined into e after they have been evaluated.
+func (e *eval) fork(query ast.Body) *eval {
+	cpy := e.child(query)
+	cpy.virtualCache = e.virtualCache.fork()
+	cpy.comprehensionCache = newComprehensionCache()
+	cpy.baseCache = newBaseCache()
+	cpy.functionMemo = e.functionMemo.fork()
+	cpy.functionMocks = e.functionMocks.copy()
+	cpy.targetStack = e.targetStack.copy()
+	cpy.builtinErrors = &builtinErrors{}
+	if e.ndBuiltinCache != nil {
+		cpy.ndBuiltinCache = make(builtins.NDBCache, len(e.ndBuiltinCache))
+		for name, obj := range e.ndBuiltinCache {
+			cpy.ndBuiltinCache[name] = obj.Copy()
+		}
+	}
+	return cpy
+}
+
+// join merges the state of the evaluated fork f that outlives the evaluation
+// into e.
+func (e *eval) join(f *eval) {
+	e.builtinErrors.errs = append(e.builtinErrors.errs, f.builtinErrors.errs...)
+	for name, obj := range f.ndBuiltinCache {
+		obj.Foreach(func(k, v *ast.Term) {
+			e.ndBuiltinCache.Put(name, k.Value, v.Value)
+		})
+	}
+}
+
+// evalParallel evaluates the queries on forks of e. The fork for queries[i]
+// is passed to f, which must not modify state shared by the forks. The
+// forks are joined into e in order, up to the first fork that failed.
+func (e *eval) evalParallel(queries []ast.Body, f func(i int, child *eval) error) error {
+
+	children := make([]*eval, len(queries))
+	errs := make([]error, len(queries))
+
+	if e.forkLocks == nil {
+		e.forkLocks = &forkLocks{}
+	}
+
+	err := e.workers.run(len(queries), func(i int) error {
+		children[i] = e.fork(queries[i])
+		errs[i] = f(i, children[i])
+		return errs[i]
+	})
+
+	for i := range children {
+		if children[i] == nil {
+			break
+		}
+		e.join(children[i])
+		if errs[i] != nil {
+			break
+		}
+	}
+
+	return err
+}
+
+// evalComprehension calls iter with the plugged terms for each solution of
+// the comprehension body, in the order of sequential evaluation. The plugged
+// terms count towards the memory limit. If closure is
+// true, the body is evaluated with access to the bindings of e. If parallel
+// evaluation is enabled, the body is split after the first expression and the
+// remaining expressions are evaluated for the solutions of the first
+// expression in parallel.
+func (e *eval) evalComprehension(body ast.Body, closure bool, terms []*ast.Term, iter func(values []*ast.Term) error) error {
+
+	newEval := e.child
+	if closure {
+		newEval = e.closure
+	}
+
+	loc := e.query[e.index].Location
+	yield := func(values []*ast.Term) error {
+		if err := e.limits.alloc(loc, values...); err != nil {
+			return err
+		}
+		return iter(values)
+	}
+
+	if !e.parallel() || len(body) < 2 {
+		child := newEval(body)
+		return child.Run(func(child *eval) error {
+			return yield(plugTerms(child.bindings, terms))
+		})
+	}
+
+	rest := body[1:]
+
+	vis := ast.NewVarVisitor()
+	vis.Walk(rest)
+	for i := range terms {
+		vis.Walk(terms[i])
+	}
+	vars := vis.Vars().Sorted()
+
+	// The forks do not share the bindings of e, so the variables bound by
+	// the first expression or outside of the comprehension are captured
+	// with equality expressions prepended to the remaining expressions.
+	var queries []ast.Body
+
+	child := newEval(body[:1])
+	err := child.Run(func(child *eval) error {
+		query := make(ast.Body, 0, len(vars)+len(rest))
+		for _, v := range vars {
+			term := ast.NewTerm(v)
+			if value := child.bindings.Plug(term); !value.Equal(term) {
+				query = append(query, ast.Equality.Expr(term, value))
+			}
+		}
+		queries = append(queries, append(query, rest...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	results := make([][][]*ast.Term, len(queries))
+
+	err = e.evalParallel(queries, func(i int, child *eval) error {
+		return child.Run(func(child *eval) error {
+			results[i] = append(results[i], plugTerms(child.bindings, terms))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range results {
+		for _, values := range results[i] {
+			if err := yield(values); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func plugTerms(b *bindings, terms []*ast.Term) []*ast.Term {
+	values := make([]*ast.Term, len(terms))
+	for i := range terms {
+		values[i] = b.Plug(terms[i])
+	}
+	return values
+}
+
+// lockedPrintHook serializes the calls to a print.Hook that may not be safe
+// for concurrent use.
+type lockedPrintHook struct {
+	mtx  sync.Mutex
+	hook print.Hook
+}
+
+func (h *lockedPrintHook) Print(ctx print.Context, msg string) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.hook.Print(ctx, msg)
+}
+
+// serializeBuiltin returns true if calls to the built-in function must not
+// run concurrently with calls in other forks because they may access the
+// built-in function cache. This is the case for non-deterministic and custom
+// built-in functions.
+func (e *eval) serializeBuiltin(bi *ast.Builtin) bool {
+	if e.forkLocks == nil {
+		return false
+	}
+	if bi.Nondeterministic {
+		return true
+	}
+	_, ok := stdlibBuiltins[bi.Name]
+	return !ok
+}
+
+// serialize returns a built-in function that calls f while holding the lock
+// for built-in function calls. The lock is released while the result is
+// processed, which continues the evaluation.
+func (l *forkLocks) serialize(f BuiltinFunc) BuiltinFunc {
+	return func(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+		l.builtins.Lock()
+		locked := true
+		defer func() {
+			if locked {
+				l.builtins.Unlock()
+			}
+		}()
+		return f(bctx, operands, func(output *ast.Term) error {
+			l.builtins.Unlock()
+			locked = false
+			err := iter(output)
+			l.builtins.Lock()
+			locked = true
+			return err
+		})
+	}
+}
+
+// readStore reads the document at path from the store. Reads of forks are
+// serialized because transactions are not safe for concurrent use.
+func (e *eval) readStore(path storage.Path) (interface{}, error) {
+	if e.forkLocks != nil {
+		e.forkLocks.store.Lock()
+		defer e.forkLocks.store.Unlock()
+	}
+	return e.store.Read(e.ctx, e.txn, path)
+}
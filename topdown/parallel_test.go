@@ -0,0 +1,306 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+	"github.com/open-policy-agent/opa/util"
+)
+
+func TestTopdownParallelism(t *testing.T) {
+
+	tests := []struct {
+		note    string
+		module  string
+		query   string
+		input   string
+		wantErr string
+	}{
+		{
+			note: "partial set",
+			module: `package p
+				s[x] { x := data.xs[_] }
+				s[x] { x := data.xs[_] * 2 }
+				s[x] { x := "a" }`,
+			query: `x = data.p.s`,
+		},
+		{
+			note: "partial object",
+			module: `package p
+				o[k] = v { v := data.xs[k] }
+				o[k] = v { k := "a"; v := data.xs }
+				o[k] = v { v := data.xs[_]; k := sprintf("%d", [v]) }`,
+			query: `x = data.p.o`,
+		},
+		{
+			note: "partial object conflict",
+			module: `package p
+				o[k] = v { k := "a"; v := 1 }
+				o[k] = v { k := "b"; v := 2 }
+				o[k] = v { k := "a"; v := 3 }`,
+			query:   `x = data.p.o`,
+			wantErr: "object keys must be unique",
+		},
+		{
+			note: "partial set error in first rule",
+			module: `package p
+				s[x] { x := 1 / 0 }
+				s[x] { x := to_number("x") }`,
+			query:   `x = data.p.s`,
+			wantErr: "divide by zero",
+		},
+		{
+			note: "partial set error in later rule",
+			module: `package p
+				s[x] { x := 1 }
+				s[x] { x := to_number("x") }
+				s[x] { x := 1 / 0 }`,
+			query:   `x = data.p.s`,
+			wantErr: "invalid syntax",
+		},
+		{
+			note: "nested partial sets",
+			module: `package p
+				s[x] { x := count(t) }
+				s[x] { x := t[_] + 1 }
+				t[x] { x := data.xs[_] }
+				t[x] { x := input.ys[_] }`,
+			query: `x = data.p.s`,
+			input: `{"ys": [10, 20]}`,
+		},
+		{
+			note: "array comprehension order",
+			module: `package p
+				a := [y | x := data.xs[_]; y := [x, input.ys[_]]]`,
+			query: `x = data.p.a`,
+			input: `{"ys": [10, 20]}`,
+		},
+		{
+			note: "comprehension closing over outer variables",
+			module: `package p
+				f(n) = [z | y := data.xs[_]; z := [n, y, [w | w := data.xs[_]; w > y]]]`,
+			query: `x = data.p.f(7)`,
+		},
+		{
+			note: "object comprehension",
+			module: `package p
+				o := {k: v | v := data.xs[_]; k := sprintf("%d", [v])}`,
+			query: `x = data.p.o`,
+		},
+		{
+			note: "object comprehension conflict",
+			module: `package p
+				o := {k: v | v := data.xs[_]; k := "a"}`,
+			query:   `x = data.p.o`,
+			wantErr: "object keys must be unique",
+		},
+		{
+			note: "indexed comprehension",
+			module: `package p
+				c[x] = n { x := data.xs[_]; n := count([y | y := data.xs[_]; y == x]) }`,
+			query: `x = data.p.c`,
+		},
+		{
+			note: "with modifiers",
+			module: `package p
+				s[x] { x := g(1) with input.n as 2 }
+				s[x] { x := g(1) with h as 3 }
+				s[x] { x := g(1) }
+				g(x) = y { y := [x, h(x)] }
+				h(x) = input.n`,
+			query: `x = data.p.s`,
+			input: `{"n": 1}`,
+		},
+	}
+
+	data := util.MustUnmarshalJSON([]byte(`{"xs": [1, 2, 3, 4, 5, 6, 7, 8]}`)).(map[string]interface{})
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			ctx := context.Background()
+			compiler := ast.MustCompileModules(map[string]string{"test.rego": tc.module})
+			store := inmem.NewFromObject(data)
+
+			eval := func(parallelism int) ([]QueryResult, error) {
+				txn := storage.NewTransactionOrDie(ctx, store)
+				defer store.Abort(ctx, txn)
+				q := NewQuery(ast.MustParseBody(tc.query)).
+					WithCompiler(compiler).
+					WithStore(store).
+					WithTransaction(txn).
+					WithStrictBuiltinErrors(true).
+					WithParallelism(parallelism)
+				if tc.input != "" {
+					q = q.WithInput(ast.MustParseTerm(tc.input))
+				}
+				return q.Run(ctx)
+			}
+
+			exp, expErr := eval(1)
+			if (expErr != nil) != (tc.wantErr != "") {
+				t.Fatalf("unexpected result of sequential evaluation: %v", expErr)
+			}
+
+			for _, n := range []int{2, 4, 16} {
+				for i := 0; i < 10; i++ {
+					qrs, err := eval(n)
+					if tc.wantErr != "" {
+						if err == nil || !strings.Contains(err.Error(), tc.wantErr) || err.Error() != expErr.Error() {
+							t.Fatalf("parallelism %d: expected error %v but got: %v", n, expErr, err)
+						}
+						continue
+					}
+					if err != nil {
+						t.Fatalf("parallelism %d: unexpected error: %v", n, err)
+					}
+					if fmt.Sprint(qrs) != fmt.Sprint(exp) {
+						t.Fatalf("parallelism %d: expected %v but got %v", n, exp, qrs)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTopdownParallelismBuiltinErrorsAndCaches(t *testing.T) {
+
+	ctx := context.Background()
+	compiler := ast.MustCompileModules(map[string]string{"test.rego": `package p
+		s[x] { x := to_number("a") }
+		s[x] { x := rand.intn("x", 1000) }
+		s[x] { x := to_number("b") }
+		s[x] { x := [y | y := [1, 2, 3][_]; print(y)] }`})
+
+	eval := func(parallelism int) ([]Error, builtins.NDBCache, string, []QueryResult) {
+		var errs []Error
+		var buf bytes.Buffer
+		ndbc := builtins.NDBCache{}
+		qrs, err := NewQuery(ast.MustParseBody(`x = data.p.s`)).
+			WithCompiler(compiler).
+			WithBuiltinErrorList(&errs).
+			WithNDBuiltinCache(ndbc).
+			WithPrintHook(NewPrintHook(&buf)).
+			WithParallelism(parallelism).
+			Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return errs, ndbc, buf.String(), qrs
+	}
+
+	expErrs, expNDBC, expPrinted, _ := eval(1)
+	if len(expErrs) != 2 || len(expNDBC) != 1 {
+		t.Fatalf("unexpected sequential evaluation results: %v %v", expErrs, expNDBC)
+	}
+
+	for i := 0; i < 10; i++ {
+		errs, ndbc, printed, qrs := eval(4)
+		if fmt.Sprint(errs) != fmt.Sprint(expErrs) {
+			t.Fatalf("expected built-in errors %v but got %v", expErrs, errs)
+		}
+		if len(ndbc) != 1 || ndbc["rand.intn"].Len() != 1 {
+			t.Fatalf("unexpected non-deterministic built-in cache: %v", ndbc)
+		}
+		if printed != expPrinted {
+			t.Fatalf("expected printed %q but got %q", expPrinted, printed)
+		}
+		if len(qrs) != 1 || qrs[0]["x"].Value.(ast.Set).Len() != 2 {
+			t.Fatalf("unexpected result: %v", qrs)
+		}
+	}
+}
+
+func TestTopdownParallelismSharedBuiltinCache(t *testing.T) {
+
+	ctx := context.Background()
+	compiler := ast.MustCompileModules(map[string]string{"test.rego": `package p
+		s[x] { x := rand.intn("x", 1000000000) }
+		s[x] { x := rand.intn("x", 1000000000) }
+		s[x] { x := rand.intn("x", 1000000000) }
+		s[x] { x := rand.intn("x", 1000000000) }`})
+
+	for i := 0; i < 10; i++ {
+		qrs, err := NewQuery(ast.MustParseBody(`x = data.p.s`)).
+			WithCompiler(compiler).
+			WithParallelism(4).
+			Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(qrs) != 1 || qrs[0]["x"].Value.(ast.Set).Len() != 1 {
+			t.Fatalf("expected the same random number in all forks but got: %v", qrs)
+		}
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+
+	p := newWorkerPool(4)
+	if p == nil || newWorkerPool(1) != nil {
+		t.Fatal("expected pool for parallelism > 1 only")
+	}
+
+	errA, errB := errors.New("a"), errors.New("b")
+
+	// Tasks before the first failure complete and the first error in task
+	// order is returned even if a later task fails first.
+	started := make([]bool, 20)
+	release := make(chan struct{})
+	err := p.run(len(started), func(i int) error {
+		started[i] = true
+		switch i {
+		case 2:
+			<-release
+			return errA
+		case 3:
+			defer close(release)
+			return errB
+		}
+		return nil
+	})
+	if err != errA {
+		t.Fatalf("expected error %v but got %v", errA, err)
+	}
+	for i := 0; i <= 3; i++ {
+		if !started[i] {
+			t.Fatalf("expected task %d to be started", i)
+		}
+	}
+
+	// Nested runs do not deadlock when all workers are busy.
+	var count int32
+	err = p.run(8, func(int) error {
+		return p.run(8, func(int) error {
+			atomic.AddInt32(&count, 1)
+			return nil
+		})
+	})
+	if err != nil || count != 64 {
+		t.Fatalf("unexpected result of nested runs: %v, %d", err, count)
+	}
+
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Fatalf("expected panic to be propagated but got: %v", r)
+		}
+	}()
+	_ = p.run(4, func(i int) error {
+		if i == 1 {
+			panic("boom")
+		}
+		return nil
+	})
+}
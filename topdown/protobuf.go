@@ -0,0 +1,136 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// protobufTypesCacheMaxSize is the number of descriptor sets kept in
+// protobufTypesCache.
+const protobufTypesCacheMaxSize = 100
+
+var protobufTypesCache = newLRUCache(protobufTypesCacheMaxSize)
+
+// protobufTypes returns the message, enum and extension types of a base64
+// encoded FileDescriptorSet. The set must contain the imported files as well.
+func protobufTypes(operand ast.Value) (*protoregistry.Types, error) {
+	str, err := builtins.StringOperand(operand, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if t, ok := protobufTypesCache.Get(string(str)); ok {
+		return t.(*protoregistry.Types), nil
+	}
+
+	bs, err := binaryOperand(operand, 1)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(bs, &set); err != nil {
+		return nil, fmt.Errorf("invalid file descriptor set: %v", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file descriptor set: %v", err)
+	}
+
+	types := new(protoregistry.Types)
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		err = registerProtobufTypes(types, fd.Messages(), fd.Enums(), fd.Extensions())
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	protobufTypesCache.Put(string(str), types)
+	return types, nil
+}
+
+func registerProtobufTypes(types *protoregistry.Types, messages protoreflect.MessageDescriptors, enums protoreflect.EnumDescriptors, extensions protoreflect.ExtensionDescriptors) error {
+	for i := 0; i < enums.Len(); i++ {
+		if err := types.RegisterEnum(dynamicpb.NewEnumType(enums.Get(i))); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < extensions.Len(); i++ {
+		if err := types.RegisterExtension(dynamicpb.NewExtensionType(extensions.Get(i))); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		if err := types.RegisterMessage(dynamicpb.NewMessageType(md)); err != nil {
+			return err
+		}
+		if err := registerProtobufTypes(types, md.Messages(), md.Enums(), md.Extensions()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func builtinProtobufDecode(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	types, err := protobufTypes(operands[0].Value)
+	if err != nil {
+		return err
+	}
+
+	name, err := builtins.StringOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	bs, err := binaryOperand(operands[2].Value, 3)
+	if err != nil {
+		return err
+	}
+
+	mt, err := types.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return fmt.Errorf("message type %v not found", name)
+	}
+
+	msg := mt.New().Interface()
+	if err := (proto.UnmarshalOptions{Resolver: types}).Unmarshal(bs, msg); err != nil {
+		return fmt.Errorf("invalid %v message: %v", name, err)
+	}
+
+	// The proto3 JSON mapping, e.g. fields by their JSON name, 64-bit integers
+	// as strings and enums by name.
+	js, err := (protojson.MarshalOptions{Resolver: types}).Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	var x interface{}
+	if err := util.UnmarshalJSON(js, &x); err != nil {
+		return err
+	}
+	v, err := ast.InterfaceToValue(x)
+	if err != nil {
+		return err
+	}
+	return iter(ast.NewTerm(v))
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.ProtobufDecode.Name, builtinProtobufDecode)
+}
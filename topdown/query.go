@@ -14,6 +14,7 @@ import (
 	"github.com/open-policy-agent/opa/topdown/builtins"
 	"github.com/open-policy-agent/opa/topdown/cache"
 	"github.com/open-policy-agent/opa/topdown/copypropagation"
+	"github.com/open-policy-agent/opa/topdown/httpfixtures"
 	"github.com/open-policy-agent/opa/topdown/print"
 	"github.com/open-policy-agent/opa/tracing"
 )
@@ -58,6 +59,10 @@ type Query struct {
 	strictObjects          bool
 	printHook              print.Hook
 	tracingOpts            tracing.Options
+	parallelism            int
+	limits                 Limits
+	httpSendFixtures       *httpfixtures.Store
+	ruleCache              *RuleCache
 }
 
 // Builtin represents a built-in function that queries can call.
@@ -282,6 +287,20 @@ func (q *Query) WithDistributedTracingOpts(tr tracing.Options) *Query {
 	return q
 }
 
+// WithHTTPSendFixtures sets the fixtures that http.send serves instead of
+// sending requests over the network.
+func (q *Query) WithHTTPSendFixtures(s *httpfixtures.Store) *Query {
+	q.httpSendFixtures = s
+	return q
+}
+
+// WithRuleCache sets the cache holding the values of rules annotated with
+// 'cache: true' across queries.
+func (q *Query) WithRuleCache(c *RuleCache) *Query {
+	q.ruleCache = c
+	return q
+}
+
 // WithStrictObjects tells the evaluator to avoid the "lazy object" optimization
 // applied when reading objects from the store. It will result in higher memory
 // usage and should only be used temporarily while adjusting code that breaks
@@ -291,6 +310,59 @@ func (q *Query) WithStrictObjects(yes bool) *Query {
 	return q
 }
 
+// WithParallelism sets the maximum number of goroutines that evaluate the
+// query. If n is greater than one, the definitions of partial rules and the
+// iterations of comprehensions are evaluated in parallel. Results are
+// identical to sequential evaluation, including the order of array
+// comprehension elements and the errors returned. Parallel evaluation is
+// disabled during partial evaluation and when tracers or instrumentation are
+// configured. Print hooks and external resolvers may be called concurrently;
+// print hooks are serialized by the evaluator, resolvers must be safe for
+// concurrent use.
+func (q *Query) WithParallelism(n int) *Query {
+	q.parallelism = n
+	return q
+}
+
+// WithMaxSteps sets the maximum number of expressions that the evaluation may
+// evaluate. Evaluation fails with a StepLimitErr when the limit is exceeded.
+// Zero means no limit.
+func (q *Query) WithMaxSteps(n int64) *Query {
+	q.limits.MaxSteps = n
+	return q
+}
+
+// WithMaxMemoryBytes sets the maximum number of bytes that the values
+// produced by built-in functions, comprehensions and rules may occupy. The
+// size of the values is approximated. Evaluation fails with a MemoryLimitErr
+// when the limit is exceeded. Zero means no limit.
+func (q *Query) WithMaxMemoryBytes(n int64) *Query {
+	q.limits.MaxMemoryBytes = n
+	return q
+}
+
+// WithMaxResults sets the maximum number of results that the query may
+// produce. Evaluation fails with a ResultLimitErr when the limit is exceeded.
+// Zero means no limit.
+func (q *Query) WithMaxResults(n int) *Query {
+	q.limits.MaxResults = n
+	return q
+}
+
+// WithMaxCallDepth sets the maximum nesting depth of user-defined function
+// calls. Evaluation fails with a CallDepthLimitErr when the limit is
+// exceeded. Zero means no limit.
+func (q *Query) WithMaxCallDepth(n int) *Query {
+	q.limits.MaxCallDepth = n
+	return q
+}
+
+// WithLimits sets all resource limits of the evaluation at once.
+func (q *Query) WithLimits(l Limits) *Query {
+	q.limits = l
+	return q
+}
+
 // PartialRun executes partial evaluation on the query with respect to unknown
 // values. Partial evaluation attempts to evaluate as much of the query as
 // possible without requiring values for the unknowns set on the query. The
@@ -341,6 +413,7 @@ func (q *Query) PartialRun(ctx context.Context) (partials []ast.Body, support []
 		interQueryBuiltinCache: q.interQueryBuiltinCache,
 		ndBuiltinCache:         q.ndBuiltinCache,
 		virtualCache:           newVirtualCache(),
+		functionMemo:           newFunctionMemo(q.compiler),
 		comprehensionCache:     newComprehensionCache(),
 		saveSet:                newSaveSet(q.unknowns, b, q.instr),
 		saveStack:              newSaveStack(),
@@ -350,13 +423,15 @@ func (q *Query) PartialRun(ctx context.Context) (partials []ast.Body, support []
 		inliningControl: &inliningControl{
 			shallow: q.shallowInlining,
 		},
-		genvarprefix:  q.genvarprefix,
-		runtime:       q.runtime,
-		indexing:      q.indexing,
-		earlyExit:     q.earlyExit,
-		builtinErrors: &builtinErrors{},
-		printHook:     q.printHook,
-		strictObjects: q.strictObjects,
+		genvarprefix:     q.genvarprefix,
+		runtime:          q.runtime,
+		indexing:         q.indexing,
+		earlyExit:        q.earlyExit,
+		builtinErrors:    &builtinErrors{},
+		printHook:        q.printHook,
+		strictObjects:    q.strictObjects,
+		limits:           newEvalLimits(q.limits),
+		httpSendFixtures: q.httpSendFixtures,
 	}
 
 	if len(q.disableInlining) > 0 {
@@ -480,6 +555,11 @@ func (q *Query) Iter(ctx context.Context, iter func(QueryResult) error) error {
 	if q.metrics == nil {
 		q.metrics = metrics.New()
 	}
+	workers := newWorkerPool(q.parallelism)
+	printHook := q.printHook
+	if workers != nil && printHook != nil {
+		printHook = &lockedPrintHook{hook: printHook}
+	}
 	f := &queryIDFactory{}
 	e := &eval{
 		ctx:                    ctx,
@@ -509,19 +589,28 @@ func (q *Query) Iter(ctx context.Context, iter func(QueryResult) error) error {
 		interQueryBuiltinCache: q.interQueryBuiltinCache,
 		ndBuiltinCache:         q.ndBuiltinCache,
 		virtualCache:           newVirtualCache(),
+		functionMemo:           newFunctionMemo(q.compiler),
 		comprehensionCache:     newComprehensionCache(),
 		genvarprefix:           q.genvarprefix,
 		runtime:                q.runtime,
 		indexing:               q.indexing,
 		earlyExit:              q.earlyExit,
 		builtinErrors:          &builtinErrors{},
-		printHook:              q.printHook,
+		printHook:              printHook,
 		tracingOpts:            q.tracingOpts,
 		strictObjects:          q.strictObjects,
+		workers:                workers,
+		limits:                 newEvalLimits(q.limits),
+		httpSendFixtures:       q.httpSendFixtures,
+		ruleCache:              q.ruleCache,
 	}
 	e.caller = e
 	q.metrics.Timer(metrics.RegoQueryEval).Start()
+	var n int
 	err := e.Run(func(e *eval) error {
+		if n++; q.limits.MaxResults > 0 && n > q.limits.MaxResults {
+			return resultLimitErr(q.limits.MaxResults)
+		}
 		qr := QueryResult{}
 		_ = e.bindings.Iter(nil, func(k, v *ast.Term) error {
 			qr[k.Value.(ast.Var)] = v
@@ -0,0 +1,451 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/uuid"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/topdown/cache"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// RuleCache caches the values of complete rules across queries. A rule is
+// cached if it is annotated with 'cache: true' and it is deterministic, i.e.,
+// neither the rule nor the rules and functions it depends on call
+// non-deterministic built-in functions or replace functions with the 'with'
+// keyword. The values are keyed on the values of the input paths read by the
+// rule and its dependencies, and stored in an inter-query cache so that they
+// count towards the size limit of that cache.
+//
+// The values of a rule are invalidated when data read by the rule or its
+// dependencies changes. For this, OnCommit must be registered as a trigger on
+// the store (see storage.TriggerConfig). All values are invalidated when the
+// policies change or the cache is used with another compiler.
+//
+// The keys include an ID of the RuleCache, so values stored in a cache backend
+// shared by several OPA instances, or surviving restarts, are only reused by
+// the RuleCache that stored them.
+type RuleCache struct {
+	cache    cache.InterQueryCache
+	id       string
+	mtx      sync.Mutex
+	compiler *ast.Compiler
+	epoch    uint64
+	rules    map[string]*cachedRule // rule path -> analysis
+}
+
+// cachedRule holds what the values of a rule depend on.
+type cachedRule struct {
+	enabled    bool
+	wholeInput bool      // the rule reads input paths that are not ground
+	input      []ast.Ref // ground input paths read by the rule
+	data       []ast.Ref // data paths read by the rule
+	generation uint64    // incremented when data read by the rule changes
+}
+
+// NewRuleCache returns a RuleCache that stores the values of rules in c.
+func NewRuleCache(c cache.InterQueryCache) *RuleCache {
+	id, err := uuid.New(rand.Reader)
+	if err != nil {
+		id = fmt.Sprint(time.Now().UnixNano())
+	}
+	return &RuleCache{
+		cache: c,
+		id:    id,
+		rules: map[string]*cachedRule{},
+	}
+}
+
+// OnCommit invalidates the cached values of the rules that read the data
+// changed by the committed transaction. OnCommit has the signature of the
+// storage.TriggerConfig callback.
+func (c *RuleCache) OnCommit(_ context.Context, _ storage.Transaction, event storage.TriggerEvent) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if event.PolicyChanged() {
+		c.reset(nil)
+		return
+	}
+
+	for _, evt := range event.Data {
+		path := evt.Path.Ref(ast.DefaultRootDocument)
+		for _, rule := range c.rules {
+			if rule.reads(path) {
+				rule.generation++
+			}
+		}
+	}
+}
+
+func (c *RuleCache) reset(compiler *ast.Compiler) {
+	c.compiler = compiler
+	c.rules = map[string]*cachedRule{}
+	c.epoch++
+}
+
+// key returns the cache key for the value of the rule at path, or false if
+// the rule is not cached.
+func (c *RuleCache) key(compiler *ast.Compiler, builtins map[string]*Builtin, path ast.Ref, input *ast.Term) (ast.Value, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if compiler != c.compiler {
+		c.reset(compiler)
+	}
+
+	name := path.String()
+	rule, ok := c.rules[name]
+	if !ok {
+		rule = analyzeCachedRule(compiler, builtins, path)
+		c.rules[name] = rule
+	}
+
+	if !rule.enabled {
+		return nil, false
+	}
+
+	var values []*ast.Term
+	if rule.wholeInput {
+		values = append(values, optionalTerm(input))
+	} else {
+		for _, ref := range rule.input {
+			var value *ast.Term
+			if input != nil {
+				if v, err := input.Value.Find(ref[1:]); err == nil {
+					value = ast.NewTerm(v)
+				}
+			}
+			values = append(values, optionalTerm(value))
+		}
+	}
+
+	return ast.NewArray(
+		ast.StringTerm(name),
+		ast.StringTerm(c.id),
+		ast.UIntNumberTerm(c.epoch),
+		ast.UIntNumberTerm(rule.generation),
+		ast.ArrayTerm(values...),
+	), true
+}
+
+// optionalTerm returns an array holding t, or an empty array if t is
+// undefined.
+func optionalTerm(t *ast.Term) *ast.Term {
+	if t == nil {
+		return ast.ArrayTerm()
+	}
+	return ast.ArrayTerm(t)
+}
+
+// Get returns the value cached for key. The value is nil if the rule was
+// undefined.
+func (c *RuleCache) Get(key ast.Value) (*ast.Term, bool) {
+	v, ok := c.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	switch v := v.(type) {
+	case ruleCacheValue:
+		return v.term, true
+	case *cache.SerializedValue:
+		// read from a cache backend
+		var t ast.Term
+		if err := util.UnmarshalJSON(v.Data, &t); err != nil {
+			return nil, false
+		}
+		a, ok := t.Value.(*ast.Array)
+		if !ok || a.Len() > 1 {
+			return nil, false
+		}
+		if a.Len() == 0 {
+			return nil, true
+		}
+		return a.Elem(0), true
+	}
+
+	return nil, false
+}
+
+// Put caches the value of a rule for key. The value is nil if the rule was
+// undefined.
+func (c *RuleCache) Put(key ast.Value, value *ast.Term) {
+	c.cache.Insert(key, ruleCacheValue{term: value})
+}
+
+type ruleCacheValue struct {
+	term *ast.Term
+}
+
+func (v ruleCacheValue) SizeInBytes() int64 {
+	return termSizeBytes(v.term)
+}
+
+// Serialize returns the JSON encoding of the term, which, unlike the JSON
+// encoding of its value, preserves sets. Undefined values are serialized as
+// empty arrays (see optionalTerm).
+func (v ruleCacheValue) Serialize() ([]byte, error) {
+	return json.Marshal(optionalTerm(v.term))
+}
+
+// reads returns true if the rule reads the data at path.
+func (r *cachedRule) reads(path ast.Ref) bool {
+	for _, ref := range r.data {
+		if ref.HasPrefix(path) || path.HasPrefix(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+func analyzeCachedRule(compiler *ast.Compiler, builtins map[string]*Builtin, path ast.Ref) *cachedRule {
+	result := &cachedRule{}
+
+	rules := compiler.GetRulesExact(path)
+	if len(rules) == 0 || !cacheEnabled(compiler, rules) {
+		return result
+	}
+
+	purity := newPurity(compiler, builtins)
+	inputs := map[string]ast.Ref{}
+	data := map[string]ast.Ref{}
+
+	for _, rule := range ruleDependencies(compiler, rules) {
+		if !purity.isPureRule(rule) {
+			return result
+		}
+
+		ast.WalkRefs(rule, func(ref ast.Ref) bool {
+			switch {
+			case ref.HasPrefix(ast.InputRootRef):
+				prefix := ref.GroundPrefix()
+				if len(prefix) == 1 {
+					result.wholeInput = true
+				}
+				inputs[prefix.String()] = prefix
+			case ref.HasPrefix(ast.DefaultRootRef):
+				prefix := storagePathPrefix(ref)
+				data[prefix.String()] = prefix
+			}
+			return false
+		})
+	}
+
+	result.enabled = true
+	result.input = minimalRefs(inputs)
+	result.data = minimalRefs(data)
+	return result
+}
+
+// cacheEnabled returns true if caching is enabled for all rules. The
+// annotation closest to a rule takes precedence.
+func cacheEnabled(compiler *ast.Compiler, rules []*ast.Rule) bool {
+	as := compiler.GetAnnotationSet()
+	if as == nil {
+		return false
+	}
+
+	for _, rule := range rules {
+		if len(rule.Head.Args) > 0 {
+			return false
+		}
+		enabled := false
+		for _, ref := range as.Chain(rule) {
+			if ref.Annotations != nil && ref.Annotations.Cache != nil {
+				enabled = *ref.Annotations.Cache
+				break
+			}
+		}
+		if !enabled {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ruleDependencies returns the rules, their else branches and the rules
+// they depend on.
+func ruleDependencies(compiler *ast.Compiler, rules []*ast.Rule) []*ast.Rule {
+	var result []*ast.Rule
+	seen := map[*ast.Rule]struct{}{}
+
+	var visit func(rule *ast.Rule)
+	visit = func(rule *ast.Rule) {
+		for ; rule != nil; rule = rule.Else {
+			if _, ok := seen[rule]; ok {
+				return
+			}
+			seen[rule] = struct{}{}
+			result = append(result, rule)
+			if compiler.Graph != nil {
+				for dep := range compiler.Graph.Dependencies(rule) {
+					visit(dep.(*ast.Rule))
+				}
+			}
+		}
+	}
+
+	for _, rule := range rules {
+		visit(rule)
+	}
+
+	return result
+}
+
+// storagePathPrefix returns the prefix of ref that corresponds to a storage
+// path, i.e., the ground prefix up to the first term that is not a string.
+func storagePathPrefix(ref ast.Ref) ast.Ref {
+	for i := 1; i < len(ref); i++ {
+		if _, ok := ref[i].Value.(ast.String); !ok {
+			return ref[:i]
+		}
+	}
+	return ref
+}
+
+// minimalRefs returns the sorted refs that are not prefixed by other refs.
+func minimalRefs(refs map[string]ast.Ref) []ast.Ref {
+	var result []ast.Ref
+	for _, ref := range refs {
+		prefixed := false
+		for _, other := range refs {
+			if len(other) < len(ref) && ref.HasPrefix(other) {
+				prefixed = true
+				break
+			}
+		}
+		if !prefixed {
+			result = append(result, ref)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Compare(result[j]) < 0
+	})
+	return result
+}
+
+// ruleCacheKey returns the key of the value of the rule at path in the rule
+// cache, or false if the value must not be cached. Values are not cached
+// during partial evaluation and while data or functions are replaced with the
+// 'with' keyword.
+func (e *eval) ruleCacheKey(path ast.Ref) (ast.Value, bool) {
+	if e.ruleCache == nil || e.partial() || e.data != nil || !e.functionMocks.Empty() {
+		return nil, false
+	}
+	return e.ruleCache.key(e.compiler, e.builtins, path, e.input)
+}
+
+// purity determines whether rules are pure, i.e., their values only depend
+// on the input, data and function arguments. Rules are impure if they call
+// non-deterministic built-in functions (e.g., http.send or rand.intn),
+// directly or through functions, or replace functions with the 'with'
+// keyword.
+type purity struct {
+	compiler *ast.Compiler
+	builtins map[string]*Builtin
+	pure     map[string]bool // function path -> pure
+}
+
+func newPurity(compiler *ast.Compiler, builtins map[string]*Builtin) *purity {
+	return &purity{
+		compiler: compiler,
+		builtins: builtins,
+		pure:     map[string]bool{},
+	}
+}
+
+func (p *purity) isPure(path ast.Ref, rules []*ast.Rule) bool {
+	key := path.String()
+	if result, ok := p.pure[key]; ok {
+		return result
+	}
+
+	// Recursion is rejected by the compiler but the function is assumed to
+	// be pure while it is being checked so that the check terminates anyway.
+	p.pure[key] = true
+
+	result := true
+	for _, rule := range rules {
+		if !p.isPureRule(rule) {
+			result = false
+			break
+		}
+	}
+
+	p.pure[key] = result
+	return result
+}
+
+func (p *purity) isPureRule(rule *ast.Rule) bool {
+	pure := true
+
+	ast.WalkExprs(rule, func(expr *ast.Expr) bool {
+		if !pure {
+			return true
+		}
+
+		for _, w := range expr.With {
+			if ref, ok := w.Target.Value.(ast.Ref); ok && !ref.HasPrefix(ast.InputRootRef) && !ref.HasPrefix(ast.DefaultRootRef) {
+				// replaces a function, which makes the result depend on the caller
+				pure = false
+				return true
+			}
+		}
+
+		if expr.IsCall() {
+			pure = p.isPureCall(expr.Operator())
+		}
+
+		if pure {
+			ast.WalkTerms(expr, func(t *ast.Term) bool {
+				if call, ok := t.Value.(ast.Call); ok && pure {
+					if ref, ok := call[0].Value.(ast.Ref); ok {
+						pure = p.isPureCall(ref)
+					}
+				}
+				return !pure
+			})
+		}
+
+		return !pure
+	})
+
+	return pure
+}
+
+func (p *purity) isPureCall(op ast.Ref) bool {
+	if op.HasPrefix(ast.DefaultRootRef) {
+		rules := p.compiler.GetRulesExact(op)
+		if len(rules) == 0 {
+			return false
+		}
+		return p.isPure(op, rules)
+	}
+
+	name := op.String()
+
+	if b, ok := p.builtins[name]; ok {
+		return b.Decl == nil || !b.Decl.Nondeterministic
+	}
+
+	if b, ok := ast.BuiltinMap[name]; ok {
+		return !b.Nondeterministic
+	}
+
+	// Unknown operators are, e.g., local variables holding functions in
+	// queries. Be conservative.
+	return false
+}
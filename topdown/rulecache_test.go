@@ -0,0 +1,306 @@
+// Copyright 2023 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/topdown/cache"
+	"github.com/open-policy-agent/opa/util"
+)
+
+func TestRuleCache(t *testing.T) {
+	ctx := context.Background()
+
+	module := `package p
+
+# METADATA
+# cache: true
+users := [u.name | u := data.users[_]; u.tenant == input.tenant]
+
+# METADATA
+# cache: true
+count_users := count(users)
+
+# METADATA
+# cache: true
+now := time.now_ns()
+
+# METADATA
+# cache: true
+whole := input[x]
+
+# METADATA
+# cache: true
+undefined {
+	startswith(input.tenant, "c")
+}
+
+uncached := input.tenant`
+
+	parsed, err := ast.ParseModuleWithOpts("test.rego", module, ast.ParserOptions{ProcessAnnotation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiler := ast.NewCompiler()
+	if compiler.Compile(map[string]*ast.Module{"test.rego": parsed}); compiler.Failed() {
+		t.Fatal(compiler.Errors)
+	}
+
+	store := inmem.NewFromObject(map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"name": "alice", "tenant": "a"},
+			map[string]interface{}{"name": "bob", "tenant": "b"},
+		},
+		"other": "x",
+	})
+
+	rc := NewRuleCache(cache.NewInterQueryCache(nil))
+
+	txn := storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+	if _, err := store.Register(ctx, txn, storage.TriggerConfig{OnCommit: rc.OnCommit}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(ctx, txn); err != nil {
+		t.Fatal(err)
+	}
+
+	eval := func(query, input string) (interface{}, uint64, uint64) {
+		t.Helper()
+		m := metrics.New()
+		var result interface{}
+		err := storage.Txn(ctx, store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+			rs, err := NewQuery(ast.MustParseBody(query)).
+				WithCompiler(compiler).
+				WithStore(store).
+				WithTransaction(txn).
+				WithInput(ast.MustParseTerm(input)).
+				WithInstrumentation(NewInstrumentation(m)).
+				WithRuleCache(rc).
+				Run(ctx)
+			if err != nil {
+				return err
+			}
+			if len(rs) > 0 {
+				result, err = ast.JSON(rs[0][ast.Var("x")].Value)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		counters := m.All()
+		hits, _ := counters["counter_"+evalOpRuleCacheHit].(uint64)
+		misses, _ := counters["counter_"+evalOpRuleCacheMiss].(uint64)
+		return result, hits, misses
+	}
+
+	write := func(path string, value interface{}) {
+		t.Helper()
+		if err := storage.WriteOne(ctx, store, storage.ReplaceOp, storage.MustParsePath(path), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		note         string
+		query        string
+		input        string
+		write        func()
+		exp          interface{}
+		hits, misses uint64
+	}{
+		{
+			note:   "first evaluation",
+			query:  `data.p.users = x`,
+			input:  `{"tenant": "a"}`,
+			exp:    []interface{}{"alice"},
+			misses: 1,
+		},
+		{
+			note:  "same input",
+			query: `data.p.users = x`,
+			input: `{"tenant": "a"}`,
+			exp:   []interface{}{"alice"},
+			hits:  1,
+		},
+		{
+			note:  "unread input changed",
+			query: `data.p.users = x`,
+			input: `{"tenant": "a", "user": "bob"}`,
+			exp:   []interface{}{"alice"},
+			hits:  1,
+		},
+		{
+			note:   "read input changed",
+			query:  `data.p.users = x`,
+			input:  `{"tenant": "b"}`,
+			exp:    []interface{}{"bob"},
+			misses: 1,
+		},
+		{
+			note:   "dependent rule",
+			query:  `data.p.count_users = x`,
+			input:  `{"tenant": "a"}`,
+			exp:    json.Number("1"),
+			hits:   1, // users
+			misses: 1, // count_users
+		},
+		{
+			note:  "unread data changed",
+			query: `data.p.count_users = x`,
+			input: `{"tenant": "a"}`,
+			write: func() { write("/other", "y") },
+			exp:   json.Number("1"),
+			hits:  1,
+		},
+		{
+			note:  "read data changed",
+			query: `data.p.count_users = x`,
+			input: `{"tenant": "a"}`,
+			write: func() {
+				write("/users/1/tenant", "a")
+			},
+			exp:    json.Number("2"),
+			misses: 2,
+		},
+		{
+			note:  "data replaced with 'with'",
+			query: `data.p.users = x with data.users as []`,
+			input: `{"tenant": "a"}`,
+			exp:   []interface{}{},
+		},
+		{
+			note:  "non-deterministic built-in function",
+			query: `data.p.now = _; x = 1`,
+			input: `{}`,
+			exp:   json.Number("1"),
+		},
+		{
+			note:   "non-ground input ref",
+			query:  `data.p.whole = x`,
+			input:  `{"tenant": "a"}`,
+			exp:    "a",
+			misses: 1,
+		},
+		{
+			note:   "non-ground input ref, other input",
+			query:  `data.p.whole = x`,
+			input:  `{"tenant": "b"}`,
+			exp:    "b",
+			misses: 1,
+		},
+		{
+			note:   "undefined",
+			query:  `data.p.undefined = x`,
+			input:  `{"tenant": "a"}`,
+			misses: 1,
+		},
+		{
+			note:  "undefined cached",
+			query: `data.p.undefined = x`,
+			input: `{"tenant": "a"}`,
+			hits:  1,
+		},
+		{
+			note:  "not annotated",
+			query: `data.p.uncached = x`,
+			input: `{"tenant": "a"}`,
+			exp:   "a",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			if tc.write != nil {
+				tc.write()
+			}
+			result, hits, misses := eval(tc.query, tc.input)
+			if util.Compare(result, tc.exp) != 0 {
+				t.Fatalf("expected %v but got %v", tc.exp, result)
+			}
+			if hits != tc.hits || misses != tc.misses {
+				t.Fatalf("expected %d hits and %d misses but got %d and %d", tc.hits, tc.misses, hits, misses)
+			}
+		})
+	}
+
+	// Values cached for a compiler are not used with other compilers.
+	rc.key(ast.NewCompiler(), nil, ast.MustParseRef("data.p.users"), nil)
+	if _, hits, misses := eval(`data.p.users = x`, `{"tenant": "a"}`); hits != 0 || misses != 1 {
+		t.Fatalf("expected miss after compiler change but got %d hits and %d misses", hits, misses)
+	}
+}
+
+func TestRuleCacheBackend(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache.RegisterBackend("rule-cache-test", func(context.Context, []byte) (cache.Backend, error) {
+		return cache.NewMemoryBackend(), nil
+	})
+
+	config, err := cache.ParseCachingConfig([]byte(`{"inter_query_builtin_cache": {"backend": {"type": "rule-cache-test"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cache.OpenInterQueryCache(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module := `package p
+
+# METADATA
+# cache: true
+p := input.x`
+
+	parsed, err := ast.ParseModuleWithOpts("test.rego", module, ast.ParserOptions{ProcessAnnotation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiler := ast.NewCompiler()
+	if compiler.Compile(map[string]*ast.Module{"test.rego": parsed}); compiler.Failed() {
+		t.Fatal(compiler.Errors)
+	}
+
+	rc := NewRuleCache(c)
+
+	for _, value := range []*ast.Term{
+		nil,
+		ast.MustParseTerm(`{"a": {1, "x"}, 2: [1.5, null, true]}`),
+	} {
+		input := ast.MustParseTerm(`{"x": 1}`)
+		if value != nil {
+			input = ast.MustParseTerm(`{"x": 2}`)
+		}
+		key, ok := rc.key(compiler, nil, ast.MustParseRef("data.p.p"), input)
+		if !ok {
+			t.Fatal("expected rule to be cached")
+		}
+		rc.Put(key, value)
+		result, ok := rc.Get(key)
+		if !ok {
+			t.Fatalf("expected %v in cache", value)
+		}
+		if (result == nil) != (value == nil) || (value != nil && !result.Equal(value)) {
+			t.Fatalf("expected %v but got %v", value, result)
+		}
+	}
+
+	// Values stored by other rule caches, e.g., of other OPA instances sharing
+	// the backend, are not used.
+	other := NewRuleCache(c)
+	key, _ := other.key(compiler, nil, ast.MustParseRef("data.p.p"), ast.MustParseTerm(`{"x": 2}`))
+	if _, ok := other.Get(key); ok {
+		t.Fatal("unexpected value of other rule cache")
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/gojsonschema"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// schemaCacheMaxSize is the number of compiled schemas kept in schemaCache.
+const schemaCacheMaxSize = 100
+
+var schemaCache = newLRUCache(schemaCacheMaxSize)
+
+// getSchema returns the compiled schema of a JSON string or object operand.
+// Recently used compiled schemas are cached, keyed by their source.
+func getSchema(operand ast.Value, pos int) (*gojsonschema.Schema, error) {
+	var key string
+	var schema interface{}
+	switch v := operand.(type) {
+	case ast.String:
+		key = string(v)
+		if err := util.UnmarshalJSON([]byte(v), &schema); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	case ast.Object:
+		key = v.String()
+		x, err := ast.JSON(v)
+		if err != nil {
+			return nil, err
+		}
+		schema = x
+	default:
+		return nil, builtins.NewOperandTypeErr(pos, operand, "string", "object")
+	}
+
+	if compiled, ok := schemaCache.Get(key); ok {
+		return compiled.(*gojsonschema.Schema), nil
+	}
+	compiled, err := gojsonschema.NewSchemaLoader().Compile(gojsonschema.NewGoLoaderOffline(schema))
+	if err != nil {
+		return nil, err
+	}
+	schemaCache.Put(key, compiled)
+	return compiled, nil
+}
+
+func builtinJSONVerifySchema(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	if _, err := getSchema(operands[0].Value, 1); err != nil {
+		if _, ok := err.(builtins.ErrOperand); ok {
+			return err
+		}
+		return iter(ast.ArrayTerm(ast.BooleanTerm(false), ast.StringTerm(err.Error())))
+	}
+
+	return iter(ast.ArrayTerm(ast.BooleanTerm(true), ast.NullTerm()))
+}
+
+func builtinJSONMatchSchema(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	document, err := ast.JSON(operands[0].Value)
+	if err != nil {
+		return err
+	}
+
+	schema, err := getSchema(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	result, err := schema.Validate(gojsonschema.NewGoLoader(document))
+	if err != nil {
+		return err
+	}
+
+	// Errors are reported in a stable order, as the order in which properties
+	// are validated is not.
+	errs := result.Errors()
+	sort.SliceStable(errs, func(i, j int) bool {
+		if a, b := errs[i].Context().Pointer(), errs[j].Context().Pointer(); a != b {
+			return a < b
+		}
+		return errs[i].String() < errs[j].String()
+	})
+
+	errors := make([]*ast.Term, 0, len(errs))
+	for _, e := range errs {
+		errors = append(errors, ast.ObjectTerm(
+			ast.Item(ast.StringTerm("error"), ast.StringTerm(e.String())),
+			ast.Item(ast.StringTerm("type"), ast.StringTerm(e.Type())),
+			ast.Item(ast.StringTerm("field"), ast.StringTerm(e.Context().Pointer())),
+			ast.Item(ast.StringTerm("desc"), ast.StringTerm(e.Description())),
+		))
+	}
+
+	return iter(ast.ArrayTerm(ast.BooleanTerm(result.Valid()), ast.ArrayTerm(errors...)))
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.JSONVerifySchema.Name, builtinJSONVerifySchema)
+	RegisterBuiltinFunc(ast.JSONMatchSchema.Name, builtinJSONMatchSchema)
+}
@@ -53,7 +53,96 @@ func builtinSemVerIsValid(bctx BuiltinContext, operands []*ast.Term, iter func(*
 	return iter(ast.BooleanTerm(result))
 }
 
+func builtinSemVerParse(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	versionString, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	version, err := semver.NewVersion(string(versionString))
+	if err != nil {
+		return fmt.Errorf("operand 1: string %s is not a valid SemVer", versionString)
+	}
+
+	return iter(ast.ObjectTerm(
+		ast.Item(ast.StringTerm("major"), ast.IntNumberTerm(int(version.Major))),
+		ast.Item(ast.StringTerm("minor"), ast.IntNumberTerm(int(version.Minor))),
+		ast.Item(ast.StringTerm("patch"), ast.IntNumberTerm(int(version.Patch))),
+		ast.Item(ast.StringTerm("prerelease"), ast.StringTerm(string(version.PreRelease))),
+		ast.Item(ast.StringTerm("metadata"), ast.StringTerm(version.Metadata)),
+	))
+}
+
+func builtinSemVerSatisfies(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	versionString, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	constraint, err := semVerConstraintOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	version, err := semver.NewVersion(string(versionString))
+	if err != nil {
+		return fmt.Errorf("operand 1: string %s is not a valid SemVer", versionString)
+	}
+
+	return iter(ast.BooleanTerm(constraint.Check(*version)))
+}
+
+func builtinSemVerMaxSatisfying(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	versionStrings, err := builtins.StringSliceOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	constraint, err := semVerConstraintOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	var max *semver.Version
+	var maxString string
+	for _, s := range versionStrings {
+		version, err := semver.NewVersion(s)
+		if err != nil {
+			return fmt.Errorf("operand 1: string %s is not a valid SemVer", ast.String(s))
+		}
+		if !constraint.Check(*version) {
+			continue
+		}
+		// Versions that only differ in their metadata have the same precedence,
+		// the result must not depend on their order.
+		if max == nil || version.Compare(*max) > 0 || (version.Compare(*max) == 0 && s > maxString) {
+			max, maxString = version, s
+		}
+	}
+
+	if max == nil {
+		return iter(ast.NullTerm())
+	}
+	return iter(ast.StringTerm(maxString))
+}
+
+func semVerConstraintOperand(x ast.Value, pos int) (*semver.Constraint, error) {
+	s, err := builtins.StringOperand(x, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	constraint, err := semver.NewConstraint(string(s))
+	if err != nil {
+		return nil, fmt.Errorf("operand %d: %v", pos, err)
+	}
+	return constraint, nil
+}
+
 func init() {
 	RegisterBuiltinFunc(ast.SemVerCompare.Name, builtinSemVerCompare)
 	RegisterBuiltinFunc(ast.SemVerIsValid.Name, builtinSemVerIsValid)
+	RegisterBuiltinFunc(ast.SemVerParse.Name, builtinSemVerParse)
+	RegisterBuiltinFunc(ast.SemVerSatisfies.Name, builtinSemVerSatisfies)
+	RegisterBuiltinFunc(ast.SemVerMaxSatisfying.Name, builtinSemVerMaxSatisfying)
 }
@@ -10,10 +10,12 @@ import (
 	"math"
 	"math/big"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/cron"
 	"github.com/open-policy-agent/opa/topdown/builtins"
 )
 
@@ -27,11 +29,20 @@ var minDateAllowedForNsConversion = time.Unix(0, math.MinInt64)
 var maxDateAllowedForNsConversion = time.Unix(0, math.MaxInt64)
 
 func toSafeUnixNano(t time.Time, iter func(*ast.Term) error) error {
+	term, err := safeUnixNanoTerm(t)
+	if err != nil {
+		return err
+	}
+
+	return iter(term)
+}
+
+func safeUnixNanoTerm(t time.Time) (*ast.Term, error) {
 	if t.Before(minDateAllowedForNsConversion) || t.After(maxDateAllowedForNsConversion) {
-		return fmt.Errorf("time outside of valid range")
+		return nil, fmt.Errorf("time outside of valid range")
 	}
 
-	return iter(ast.NewTerm(ast.Number(int64ToJSONNumber(t.UnixNano()))))
+	return ast.NewTerm(ast.Number(int64ToJSONNumber(t.UnixNano()))), nil
 }
 
 func builtinTimeNowNanos(bctx BuiltinContext, _ []*ast.Term, iter func(*ast.Term) error) error {
@@ -216,6 +227,409 @@ func builtinDiff(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) er
 		ast.IntNumberTerm(hour), ast.IntNumberTerm(min), ast.IntNumberTerm(sec)))
 }
 
+func builtinTimeTruncate(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	return roundWallClock(operands, iter, time.Time.Truncate)
+}
+
+func builtinTimeRound(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	return roundWallClock(operands, iter, time.Time.Round)
+}
+
+// roundWallClock rounds the wall clock of a time in its timezone with fn.
+// Since wall clock times are rounded relative to the zero time, which is a
+// Monday at midnight, durations that divide a day (or week) are aligned to
+// midnight (or Monday).
+func roundWallClock(operands []*ast.Term, iter func(*ast.Term) error, fn func(time.Time, time.Duration) time.Time) error {
+	t, _, err := tzTime(operands[0].Value)
+	if err != nil {
+		return err
+	}
+
+	duration, err := builtins.StringOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	d, err := time.ParseDuration(string(duration))
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return builtins.NewOperandErr(2, "duration must be positive but got %s", duration)
+	}
+
+	return toSafeUnixNano(inLocationOf(fn(wallClock(t), d), t), iter)
+}
+
+func builtinTimeStartOf(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	t, start, _, err := calendarUnit(operands)
+	if err != nil {
+		return err
+	}
+	return toSafeUnixNano(inLocationOf(start, t), iter)
+}
+
+func builtinTimeEndOf(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	t, _, next, err := calendarUnit(operands)
+	if err != nil {
+		return err
+	}
+	return toSafeUnixNano(inLocationOf(next, t).Add(-1), iter)
+}
+
+// calendarUnit returns the time of the operands, and the wall clock times of
+// the start of its calendar unit and of the next one.
+func calendarUnit(operands []*ast.Term) (t, start, next time.Time, err error) {
+	t, _, err = tzTime(operands[0].Value)
+	if err != nil {
+		return t, start, next, err
+	}
+
+	unit, err := builtins.StringOperand(operands[1].Value, 2)
+	if err != nil {
+		return t, start, next, err
+	}
+
+	year, month, day := t.Date()
+	hour, minute, second := t.Clock()
+
+	switch unit {
+	case "second":
+		start = time.Date(year, month, day, hour, minute, second, 0, time.UTC)
+		next = start.Add(time.Second)
+	case "minute":
+		start = time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+		next = start.Add(time.Minute)
+	case "hour":
+		start = time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+		next = start.Add(time.Hour)
+	case "day":
+		start = time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		next = start.AddDate(0, 0, 1)
+	case "week":
+		// Weeks start on Monday, as in ISO 8601.
+		start = time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, time.UTC)
+		next = start.AddDate(0, 0, 7)
+	case "month":
+		start = time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+		next = start.AddDate(0, 1, 0)
+	case "year":
+		start = time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+		next = start.AddDate(1, 0, 0)
+	default:
+		return t, start, next, builtins.NewOperandErr(2, "unit must be one of {second, minute, hour, day, week, month, year} but got %s", unit)
+	}
+
+	return t, start, next, nil
+}
+
+// wallClock returns the wall clock time of t as a time in UTC.
+func wallClock(t time.Time) time.Time {
+	year, month, day := t.Date()
+	hour, minute, second := t.Clock()
+	return time.Date(year, month, day, hour, minute, second, t.Nanosecond(), time.UTC)
+}
+
+// inLocationOf returns the time with the wall clock time of wall in the
+// timezone of t. Wall clock times that are ambiguous because of daylight
+// saving time transitions resolve to the UTC offset of t, if possible.
+func inLocationOf(wall, t time.Time) time.Time {
+	year, month, day := wall.Date()
+	hour, minute, second := wall.Clock()
+
+	_, offset := t.Zone()
+	result := time.Date(year, month, day, hour, minute, second, wall.Nanosecond(), time.FixedZone("", offset)).In(t.Location())
+	if _, o := result.Zone(); o == offset {
+		return result
+	}
+
+	return time.Date(year, month, day, hour, minute, second, wall.Nanosecond(), t.Location())
+}
+
+func builtinTimeParseISO8601Duration(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	value, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	d, err := parseISO8601Duration(string(value))
+	if err != nil {
+		return err
+	}
+
+	return iter(d.object())
+}
+
+func builtinTimeParseISO8601Interval(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	value, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	start, end, err := parseISO8601Interval(string(value))
+	if err != nil {
+		return err
+	}
+
+	startTerm, err := safeUnixNanoTerm(start)
+	if err != nil {
+		return err
+	}
+	endTerm, err := safeUnixNanoTerm(end)
+	if err != nil {
+		return err
+	}
+
+	return iter(ast.ArrayTerm(startTerm, endTerm))
+}
+
+// iso8601Duration is a duration in the format PnYnMnDTnHnMnS or PnW of ISO
+// 8601. Only seconds may have a fraction.
+type iso8601Duration struct {
+	negative                            bool
+	years, months, days, hours, minutes int64
+	seconds                             int64
+	nanoseconds                         int64
+}
+
+func parseISO8601Duration(s string) (iso8601Duration, error) {
+	var d iso8601Duration
+	invalid := fmt.Errorf("invalid ISO 8601 duration %q", s)
+
+	rest := s
+	if strings.HasPrefix(rest, "-") {
+		d.negative = true
+		rest = rest[1:]
+	}
+	if !strings.HasPrefix(rest, "P") {
+		return d, invalid
+	}
+	rest = rest[1:]
+
+	// Components must appear in this order, and each at most once.
+	units := "YMWD"
+	inTime, components := false, 0
+	for rest != "" {
+		if rest[0] == 'T' {
+			if inTime {
+				return d, invalid
+			}
+			inTime, units = true, "HMS"
+			rest = rest[1:]
+			if rest == "" {
+				return d, invalid
+			}
+			continue
+		}
+
+		i := 0
+		for i < len(rest) && (rest[i] >= '0' && rest[i] <= '9' || rest[i] == '.' || rest[i] == ',') {
+			i++
+		}
+		if i == 0 || i == len(rest) {
+			return d, invalid
+		}
+		number, unit := rest[:i], rest[i]
+		rest = rest[i+1:]
+
+		j := strings.IndexByte(units, unit)
+		if j < 0 {
+			return d, invalid
+		}
+		units = units[j+1:]
+
+		if inTime && unit == 'S' {
+			if err := d.parseSeconds(number); err != nil {
+				return d, invalid
+			}
+			components++
+			continue
+		}
+
+		n, err := strconv.ParseInt(number, 10, 32)
+		if err != nil {
+			return d, invalid
+		}
+		switch {
+		case inTime && unit == 'H':
+			d.hours = n
+		case inTime: // 'M'
+			d.minutes = n
+		case unit == 'Y':
+			d.years = n
+		case unit == 'M':
+			d.months = n
+		case unit == 'W':
+			d.days += 7 * n
+		default: // 'D'
+			d.days += n
+		}
+		components++
+	}
+
+	if components == 0 {
+		return d, invalid
+	}
+	return d, nil
+}
+
+func (d *iso8601Duration) parseSeconds(number string) error {
+	whole, fraction := number, ""
+	if i := strings.IndexAny(number, ".,"); i >= 0 {
+		whole, fraction = number[:i], number[i+1:]
+		if fraction == "" || len(fraction) > 9 || strings.ContainsAny(fraction, ".,") {
+			return fmt.Errorf("invalid fraction")
+		}
+	}
+
+	var err error
+	if d.seconds, err = strconv.ParseInt(whole, 10, 32); err != nil {
+		return err
+	}
+	if fraction != "" {
+		nanos, err := strconv.ParseInt((fraction + "00000000")[:9], 10, 64)
+		if err != nil {
+			return err
+		}
+		d.nanoseconds = nanos
+	}
+	return nil
+}
+
+func (d iso8601Duration) object() *ast.Term {
+	sign := int64(1)
+	if d.negative {
+		sign = -1
+	}
+
+	seconds := ast.NewTerm(ast.Number(int64ToJSONNumber(sign * d.seconds)))
+	if d.nanoseconds != 0 {
+		s := fmt.Sprintf("%d.%09d", d.seconds, d.nanoseconds)
+		if d.negative {
+			s = "-" + s
+		}
+		seconds = ast.NewTerm(ast.Number(strings.TrimRight(s, "0")))
+	}
+
+	return ast.ObjectTerm(
+		ast.Item(ast.StringTerm("years"), ast.NewTerm(ast.Number(int64ToJSONNumber(sign*d.years)))),
+		ast.Item(ast.StringTerm("months"), ast.NewTerm(ast.Number(int64ToJSONNumber(sign*d.months)))),
+		ast.Item(ast.StringTerm("days"), ast.NewTerm(ast.Number(int64ToJSONNumber(sign*d.days)))),
+		ast.Item(ast.StringTerm("hours"), ast.NewTerm(ast.Number(int64ToJSONNumber(sign*d.hours)))),
+		ast.Item(ast.StringTerm("minutes"), ast.NewTerm(ast.Number(int64ToJSONNumber(sign*d.minutes)))),
+		ast.Item(ast.StringTerm("seconds"), seconds),
+	)
+}
+
+// addTo returns t with the duration added, or subtracted if sign is -1.
+// Years, months and days are added to the date in the timezone of t.
+func (d iso8601Duration) addTo(t time.Time, sign int64) time.Time {
+	if d.negative {
+		sign = -sign
+	}
+	t = t.AddDate(int(sign*d.years), int(sign*d.months), int(sign*d.days))
+	seconds := sign * (d.hours*3600 + d.minutes*60 + d.seconds)
+	return time.Unix(t.Unix()+seconds, int64(t.Nanosecond())+sign*d.nanoseconds).In(t.Location())
+}
+
+// parseISO8601Interval parses an interval of two times, a time and a
+// duration, or a duration and a time, separated by a "/". Times are in RFC
+// 3339 format, or dates that stand for midnight UTC.
+func parseISO8601Interval(s string) (start, end time.Time, err error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return start, end, fmt.Errorf("invalid ISO 8601 interval %q", s)
+	}
+
+	isDuration := func(p string) bool {
+		return strings.HasPrefix(p, "P") || strings.HasPrefix(p, "-P")
+	}
+
+	switch {
+	case isDuration(parts[0]) && isDuration(parts[1]):
+		return start, end, fmt.Errorf("invalid ISO 8601 interval %q: expected at least one time", s)
+	case isDuration(parts[1]):
+		if start, err = parseISO8601Time(parts[0]); err != nil {
+			return start, end, err
+		}
+		d, err := parseISO8601Duration(parts[1])
+		if err != nil {
+			return start, end, err
+		}
+		end = d.addTo(start, 1)
+	case isDuration(parts[0]):
+		if end, err = parseISO8601Time(parts[1]); err != nil {
+			return start, end, err
+		}
+		d, err := parseISO8601Duration(parts[0])
+		if err != nil {
+			return start, end, err
+		}
+		start = d.addTo(end, -1)
+	default:
+		if start, err = parseISO8601Time(parts[0]); err != nil {
+			return start, end, err
+		}
+		if end, err = parseISO8601Time(parts[1]); err != nil {
+			return start, end, err
+		}
+	}
+
+	if end.Before(start) {
+		return start, end, fmt.Errorf("invalid ISO 8601 interval %q: end is before start", s)
+	}
+	return start, end, nil
+}
+
+func parseISO8601Time(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return t, fmt.Errorf("invalid ISO 8601 time %q", s)
+	}
+	return t, nil
+}
+
+func builtinTimeCronMatch(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	t, schedule, err := cronOperands(operands)
+	if err != nil {
+		return err
+	}
+	return iter(ast.BooleanTerm(schedule.Match(t)))
+}
+
+func builtinTimeCronNext(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	t, schedule, err := cronOperands(operands)
+	if err != nil {
+		return err
+	}
+	next, ok := schedule.Next(t)
+	if !ok {
+		return nil
+	}
+	return toSafeUnixNano(next, iter)
+}
+
+func cronOperands(operands []*ast.Term) (time.Time, *cron.Schedule, error) {
+	t, _, err := tzTime(operands[0].Value)
+	if err != nil {
+		return t, nil, err
+	}
+
+	expr, err := builtins.StringOperand(operands[1].Value, 2)
+	if err != nil {
+		return t, nil, err
+	}
+
+	schedule, err := cron.Parse(string(expr))
+	if err != nil {
+		return t, nil, err
+	}
+	return t, schedule, nil
+}
+
 func tzTime(a ast.Value) (t time.Time, lay string, err error) {
 	var nVal ast.Value
 	loc := time.UTC
@@ -310,6 +724,14 @@ func init() {
 	RegisterBuiltinFunc(ast.Weekday.Name, builtinWeekday)
 	RegisterBuiltinFunc(ast.AddDate.Name, builtinAddDate)
 	RegisterBuiltinFunc(ast.Diff.Name, builtinDiff)
+	RegisterBuiltinFunc(ast.TimeTruncate.Name, builtinTimeTruncate)
+	RegisterBuiltinFunc(ast.TimeRound.Name, builtinTimeRound)
+	RegisterBuiltinFunc(ast.TimeStartOf.Name, builtinTimeStartOf)
+	RegisterBuiltinFunc(ast.TimeEndOf.Name, builtinTimeEndOf)
+	RegisterBuiltinFunc(ast.TimeParseISO8601Duration.Name, builtinTimeParseISO8601Duration)
+	RegisterBuiltinFunc(ast.TimeParseISO8601Interval.Name, builtinTimeParseISO8601Interval)
+	RegisterBuiltinFunc(ast.TimeCronMatch.Name, builtinTimeCronMatch)
+	RegisterBuiltinFunc(ast.TimeCronNext.Name, builtinTimeCronNext)
 	tzCacheMutex = &sync.Mutex{}
 	tzCache = make(map[string]*time.Location)
 }
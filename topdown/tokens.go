@@ -7,6 +7,7 @@ package topdown
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -279,9 +280,22 @@ func verifyES(publicKey interface{}, digest []byte, signature []byte) (err error
 	return fmt.Errorf("ECDSA signature verification error")
 }
 
+// Implements EdDSA JWT signature verification. EdDSA signs the input itself
+// rather than a digest of it.
+func builtinJWTVerifyEdDSA(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	result, err := builtinJWTVerify(operands[0].Value, operands[1].Value, nil, func(publicKey interface{}, payload []byte, signature []byte) error {
+		return verifyEd25519(publicKey, 0, payload, signature)
+	})
+	if err == nil {
+		return iter(ast.NewTerm(result))
+	}
+	return err
+}
+
 type verificationKey struct {
 	alg string
 	kid string
+	use string
 	key interface{}
 }
 
@@ -314,7 +328,12 @@ func getKeysFromCertOrJWK(certificate string) ([]verificationKey, error) {
 		return nil, fmt.Errorf("failed to extract a Key from the PEM certificate")
 	}
 
-	jwks, err := jwk.ParseString(certificate)
+	return getKeysFromJWK(certificate)
+}
+
+// getKeysFromJWK returns the keys of a JWK key (set).
+func getKeysFromJWK(s string) ([]verificationKey, error) {
+	jwks, err := jwk.ParseString(s)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse a JWK key (set): %w", err)
 	}
@@ -328,6 +347,7 @@ func getKeysFromCertOrJWK(certificate string) ([]verificationKey, error) {
 		keys = append(keys, verificationKey{
 			alg: k.GetAlgorithm().String(),
 			kid: k.GetKeyID(),
+			use: k.GetKeyUsage(),
 			key: key,
 		})
 	}
@@ -335,6 +355,42 @@ func getKeysFromCertOrJWK(certificate string) ([]verificationKey, error) {
 	return keys, nil
 }
 
+// selectJWKSKeys returns the keys of a JWKS that may have signed a token with
+// the given key ID and algorithm. If the token has a key ID, only the keys
+// with that ID are selected. Keys for other algorithms, key types or uses are
+// never selected.
+func selectJWKSKeys(keys []verificationKey, kid, alg string) []verificationKey {
+	var result []verificationKey
+	for _, key := range keys {
+		switch {
+		case kid != "" && key.kid != kid:
+		case key.alg != "" && key.alg != alg:
+		case key.use != "" && key.use != "sig":
+		case !keyMatchesAlgorithm(key.key, alg):
+		default:
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// keyMatchesAlgorithm returns true if key is of the type used by the JWS
+// algorithm alg.
+func keyMatchesAlgorithm(key interface{}, alg string) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		return strings.HasPrefix(alg, "ES")
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	case []byte:
+		return strings.HasPrefix(alg, "HS")
+	default:
+		return false
+	}
+}
+
 func getKeyByKid(kid string, keys []verificationKey) *verificationKey {
 	for _, key := range keys {
 		if key.kid == kid {
@@ -376,12 +432,16 @@ func builtinJWTVerify(a ast.Value, b ast.Value, hasher func() hash.Hash, verify
 	}
 
 	// Validate the JWT signature
+	digest := []byte(token.header + "." + token.payload)
+	if hasher != nil {
+		digest = getInputSHA(digest, hasher)
+	}
 
 	// First, check if there's a matching key ID (`kid`) in both token header and key(s).
 	// If a match is found, verify using only that key. Only applicable when a JWKS was provided.
 	if header.kid != "" {
 		if key := getKeyByKid(header.kid, keys); key != nil {
-			err = verify(key.key, getInputSHA([]byte(token.header+"."+token.payload), hasher), []byte(signature))
+			err = verify(key.key, digest, []byte(signature))
 
 			return ast.Boolean(err == nil), nil
 		}
@@ -393,7 +453,7 @@ func builtinJWTVerify(a ast.Value, b ast.Value, hasher func() hash.Hash, verify
 		if key.alg == "" {
 			// No algorithm provided for the key - this is likely a certificate and not a JWKS, so
 			// we'll need to verify to find out
-			err = verify(key.key, getInputSHA([]byte(token.header+"."+token.payload), hasher), []byte(signature))
+			err = verify(key.key, digest, []byte(signature))
 			if err == nil {
 				return ast.Boolean(true), nil
 			}
@@ -401,7 +461,7 @@ func builtinJWTVerify(a ast.Value, b ast.Value, hasher func() hash.Hash, verify
 			if header.alg != key.alg {
 				continue
 			}
-			err = verify(key.key, getInputSHA([]byte(token.header+"."+token.payload), hasher), []byte(signature))
+			err = verify(key.key, digest, []byte(signature))
 			if err == nil {
 				return ast.Boolean(true), nil
 			}
@@ -508,6 +568,9 @@ type tokenConstraints struct {
 	// The set of asymmetric keys we can verify with.
 	keys []verificationKey
 
+	// The JWKS whose keys matching the token we can verify with.
+	jwks []verificationKey
+
 	// The single symmetric key we will verify with.
 	secret string
 
@@ -534,6 +597,7 @@ type tokenConstraintHandler func(value ast.Value, parameters *tokenConstraints)
 // tokenConstraintTypes maps known JWT verification constraints to handlers.
 var tokenConstraintTypes = map[string]tokenConstraintHandler{
 	"cert": tokenConstraintCert,
+	"jwks": tokenConstraintJWKS,
 	"secret": func(value ast.Value, constraints *tokenConstraints) error {
 		return tokenConstraintString("secret", value, &constraints.secret)
 	},
@@ -565,6 +629,22 @@ func tokenConstraintCert(value ast.Value, constraints *tokenConstraints) error {
 	return nil
 }
 
+// tokenConstraintJWKS handles the `jwks` constraint.
+func tokenConstraintJWKS(value ast.Value, constraints *tokenConstraints) error {
+	s, err := jwkOperand(value, 0)
+	if err != nil {
+		return fmt.Errorf("jwks constraint: must be a string or an object")
+	}
+
+	keys, err := getKeysFromJWK(s)
+	if err != nil {
+		return err
+	}
+
+	constraints.jwks = keys
+	return nil
+}
+
 // tokenConstraintTime handles the `time` constraint.
 func tokenConstraintTime(value ast.Value, constraints *tokenConstraints) error {
 	t, err := timeFromValue(value)
@@ -632,6 +712,9 @@ func (constraints *tokenConstraints) validate() error {
 	if constraints.keys != nil {
 		keys++
 	}
+	if constraints.jwks != nil {
+		keys++
+	}
 	if constraints.secret != "" {
 		keys++
 	}
@@ -655,6 +738,19 @@ func (constraints *tokenConstraints) verify(kid, alg, header, payload, signature
 	if !ok {
 		return fmt.Errorf("unknown JWS algorithm: %s", alg)
 	}
+	// If we're configured with a JWKS then only trust the keys matching the token
+	if constraints.jwks != nil {
+		keys := selectJWKSKeys(constraints.jwks, kid, alg)
+		if len(keys) == 0 {
+			return errNoMatchingKey
+		}
+		for _, key := range keys {
+			if err := a.verify(key.key, a.hash, plaintext, []byte(signature)); err == nil {
+				return nil
+			}
+		}
+		return errSignatureNotVerified
+	}
 	// If we're configured with asymmetric key(s) then only trust that
 	if constraints.keys != nil {
 		if kid != "" {
@@ -740,6 +836,7 @@ var tokenAlgorithms = map[string]tokenAlgorithm{
 	"ES256": {crypto.SHA256, verifyAsymmetric(verifyECDSA)},
 	"ES384": {crypto.SHA384, verifyAsymmetric(verifyECDSA)},
 	"ES512": {crypto.SHA512, verifyAsymmetric(verifyECDSA)},
+	"EdDSA": {0, verifyEd25519},
 	"HS256": {crypto.SHA256, verifyHMAC},
 	"HS384": {crypto.SHA384, verifyHMAC},
 	"HS512": {crypto.SHA512, verifyHMAC},
@@ -748,6 +845,9 @@ var tokenAlgorithms = map[string]tokenAlgorithm{
 // errSignatureNotVerified is returned when a signature cannot be verified.
 var errSignatureNotVerified = errors.New("signature not verified")
 
+// errNoMatchingKey is returned when no key of a JWKS matches a token.
+var errNoMatchingKey = errors.New("no matching key")
+
 func verifyHMAC(key interface{}, hash crypto.Hash, payload []byte, signature []byte) error {
 	macKey, ok := key.([]byte)
 	if !ok {
@@ -793,6 +893,19 @@ func verifyRSAPSS(key interface{}, hash crypto.Hash, digest []byte, signature []
 	return nil
 }
 
+// verifyEd25519 verifies an EdDSA signature, which is computed over the
+// payload itself.
+func verifyEd25519(key interface{}, _ crypto.Hash, payload []byte, signature []byte) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("incorrect public key type")
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return errSignatureNotVerified
+	}
+	return nil
+}
+
 func verifyECDSA(key interface{}, hash crypto.Hash, digest []byte, signature []byte) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -976,72 +1089,104 @@ func builtinJWTEncodeSignRaw(bctx BuiltinContext, operands []*ast.Term, iter fun
 	return commonBuiltinJWTEncodeSign(bctx, string(inputHeaders), string(jwsPayload), string(jwkSrc), iter)
 }
 
-// Implements full JWT decoding, validation and verification.
-func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
-	// io.jwt.decode_verify(string, constraints, [valid, header, payload])
-	//
-	// If valid is true then the signature verifies and all constraints are met.
-	// If valid is false then either the signature did not verify or some constrain
-	// was not met.
-	//
-	// Decoding errors etc are returned as errors.
+// Reasons for which a token fails verification, as reported by
+// io.jwt.decode_verify_details.
+const (
+	tokenReasonHeader    = "header"
+	tokenReasonAlgorithm = "algorithm"
+	tokenReasonKey       = "key"
+	tokenReasonSignature = "signature"
+	tokenReasonIssuer    = "issuer"
+	tokenReasonAudience  = "audience"
+	tokenReasonExpired   = "expired"
+	tokenReasonNotBefore = "not_before"
+)
+
+// tokenVerification is the outcome of a full JWT verification. If the token
+// is valid, reason is empty. The header is the one of the innermost token
+// that was decoded, and the payload is only set if the signatures verify.
+type tokenVerification struct {
+	header  ast.Object
+	payload ast.Object
+	reason  string
+	message string
+}
+
+func (v *tokenVerification) valid() bool {
+	return v.reason == ""
+}
+
+func (v *tokenVerification) fail(reason, format string, a ...interface{}) (*tokenVerification, error) {
+	v.reason = reason
+	v.message = fmt.Sprintf(format, a...)
+	return v, nil
+}
+
+// decodeVerifyJWT implements full JWT decoding, validation and verification
+// for io.jwt.decode_verify and io.jwt.decode_verify_details. Decoding errors
+// etc are returned as errors, tokens that do not meet the constraints are
+// reported in the result.
+func decodeVerifyJWT(bctx BuiltinContext, operands []*ast.Term) (*tokenVerification, error) {
 	a := operands[0].Value
 
 	b, err := builtins.ObjectOperand(operands[1].Value, 2)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	unverified := ast.ArrayTerm(
-		ast.BooleanTerm(false),
-		ast.NewTerm(ast.NewObject()),
-		ast.NewTerm(ast.NewObject()),
-	)
 	constraints, err := parseTokenConstraints(b, bctx.Time)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if err := constraints.validate(); err != nil {
-		return err
+		return nil, err
 	}
+	result := &tokenVerification{header: ast.NewObject(), payload: ast.NewObject()}
 	var token *JSONWebToken
 	var p *ast.Term
 	for {
 		// RFC7519 7.2 #1-2 split into parts
 		if token, err = decodeJWT(a); err != nil {
-			return err
+			return nil, err
 		}
 		// RFC7519 7.2 #3, #4, #6
 		if err := token.decodeHeader(); err != nil {
-			return err
+			return nil, err
 		}
+		result.header = token.decodedHeader
 		// RFC7159 7.2 #5 (and RFC7159 5.2 #5) validate header fields
 		header, err := parseTokenHeader(token)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if !header.valid() {
-			return iter(unverified)
+			return result.fail(tokenReasonHeader, "invalid header: no algorithm or unsupported critical parameters")
 		}
 		// Check constraints that impact signature verification.
 		if constraints.alg != "" && constraints.alg != header.alg {
-			return iter(unverified)
+			return result.fail(tokenReasonAlgorithm, "algorithm %s does not match required algorithm %s", header.alg, constraints.alg)
 		}
 		// RFC7159 7.2 #7 verify the signature
 		signature, err := token.decodeSignature()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if err := constraints.verify(header.kid, header.alg, token.header, token.payload, signature); err != nil {
-			if err == errSignatureNotVerified {
-				return iter(unverified)
+			switch err {
+			case errNoMatchingKey:
+				if header.kid != "" {
+					return result.fail(tokenReasonKey, "no key with ID %s for algorithm %s", header.kid, header.alg)
+				}
+				return result.fail(tokenReasonKey, "no key for algorithm %s", header.alg)
+			case errSignatureNotVerified:
+				return result.fail(tokenReasonSignature, "signature not verified")
 			}
-			return err
+			return nil, err
 		}
 		// RFC7159 7.2 #9-10 decode the payload
 		p, err = getResult(builtinBase64UrlDecode, ast.StringTerm(token.payload))
 		if err != nil {
-			return fmt.Errorf("JWT payload had invalid encoding: %v", err)
+			return nil, fmt.Errorf("JWT payload had invalid encoding: %v", err)
 		}
 		// RFC7159 7.2 #8 and 5.2 cty
 		if strings.ToUpper(header.cty) == headerJwt {
@@ -1055,26 +1200,30 @@ func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func
 	}
 	payload, err := extractJSONObject(string(p.Value.(ast.String)))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	result.payload = payload
 	// Check registered claim names against constraints or environment
 	// RFC7159 4.1.1 iss
 	if constraints.iss != "" {
 		if iss := payload.Get(jwtIssKey); iss != nil {
 			issVal := string(iss.Value.(ast.String))
 			if constraints.iss != issVal {
-				return iter(unverified)
+				return result.fail(tokenReasonIssuer, "issuer %s does not match required issuer %s", issVal, constraints.iss)
 			}
 		}
 	}
 	// RFC7159 4.1.3 aud
 	if aud := payload.Get(jwtAudKey); aud != nil {
 		if !constraints.validAudience(aud.Value) {
-			return iter(unverified)
+			if constraints.aud == "" {
+				return result.fail(tokenReasonAudience, "token has an audience but no audience is required")
+			}
+			return result.fail(tokenReasonAudience, "audience does not contain required audience %s", constraints.aud)
 		}
 	} else {
 		if constraints.aud != "" {
-			return iter(unverified)
+			return result.fail(tokenReasonAudience, "token has no audience but audience %s is required", constraints.aud)
 		}
 	}
 	// RFC7159 4.1.4 exp
@@ -1084,10 +1233,10 @@ func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func
 			// constraints.time is in nanoseconds but exp Value is in seconds
 			compareTime := ast.FloatNumberTerm(constraints.time / 1000000000)
 			if ast.Compare(compareTime, exp.Value.(ast.Number)) != -1 {
-				return iter(unverified)
+				return result.fail(tokenReasonExpired, "token expired at %v", exp.Value)
 			}
 		default:
-			return fmt.Errorf("exp value must be a number")
+			return nil, fmt.Errorf("exp value must be a number")
 		}
 	}
 	// RFC7159 4.1.5 nbf
@@ -1097,19 +1246,65 @@ func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func
 			// constraints.time is in nanoseconds but nbf Value is in seconds
 			compareTime := ast.FloatNumberTerm(constraints.time / 1000000000)
 			if ast.Compare(compareTime, nbf.Value.(ast.Number)) == -1 {
-				return iter(unverified)
+				return result.fail(tokenReasonNotBefore, "token not valid before %v", nbf.Value)
 			}
 		default:
-			return fmt.Errorf("nbf value must be a number")
+			return nil, fmt.Errorf("nbf value must be a number")
 		}
 	}
 
-	verified := ast.ArrayTerm(
+	return result, nil
+}
+
+// Implements full JWT decoding, validation and verification.
+func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	// io.jwt.decode_verify(string, constraints, [valid, header, payload])
+	//
+	// If valid is true then the signature verifies and all constraints are met.
+	// If valid is false then either the signature did not verify or some constrain
+	// was not met.
+	//
+	// Decoding errors etc are returned as errors.
+	result, err := decodeVerifyJWT(bctx, operands)
+	if err != nil {
+		return err
+	}
+
+	if !result.valid() {
+		return iter(ast.ArrayTerm(
+			ast.BooleanTerm(false),
+			ast.NewTerm(ast.NewObject()),
+			ast.NewTerm(ast.NewObject()),
+		))
+	}
+
+	return iter(ast.ArrayTerm(
 		ast.BooleanTerm(true),
-		ast.NewTerm(token.decodedHeader),
-		ast.NewTerm(payload),
+		ast.NewTerm(result.header),
+		ast.NewTerm(result.payload),
+	))
+}
+
+// Implements full JWT decoding, validation and verification, reporting why a
+// token is not valid.
+func builtinJWTDecodeVerifyDetails(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	result, err := decodeVerifyJWT(bctx, operands)
+	if err != nil {
+		return err
+	}
+
+	obj := ast.NewObject(
+		ast.Item(ast.StringTerm("valid"), ast.BooleanTerm(result.valid())),
+		ast.Item(ast.StringTerm("header"), ast.NewTerm(result.header)),
 	)
-	return iter(verified)
+	if result.valid() {
+		obj.Insert(ast.StringTerm("payload"), ast.NewTerm(result.payload))
+	} else {
+		obj.Insert(ast.StringTerm("reason"), ast.StringTerm(result.reason))
+		obj.Insert(ast.StringTerm("message"), ast.StringTerm(result.message))
+	}
+
+	return iter(ast.NewTerm(obj))
 }
 
 // -- Utilities --
@@ -1205,10 +1400,12 @@ func init() {
 	RegisterBuiltinFunc(ast.JWTVerifyES256.Name, builtinJWTVerifyES256)
 	RegisterBuiltinFunc(ast.JWTVerifyES384.Name, builtinJWTVerifyES384)
 	RegisterBuiltinFunc(ast.JWTVerifyES512.Name, builtinJWTVerifyES512)
+	RegisterBuiltinFunc(ast.JWTVerifyEdDSA.Name, builtinJWTVerifyEdDSA)
 	RegisterBuiltinFunc(ast.JWTVerifyHS256.Name, builtinJWTVerifyHS256)
 	RegisterBuiltinFunc(ast.JWTVerifyHS384.Name, builtinJWTVerifyHS384)
 	RegisterBuiltinFunc(ast.JWTVerifyHS512.Name, builtinJWTVerifyHS512)
 	RegisterBuiltinFunc(ast.JWTDecodeVerify.Name, builtinJWTDecodeVerify)
+	RegisterBuiltinFunc(ast.JWTDecodeVerifyDetails.Name, builtinJWTDecodeVerifyDetails)
 	RegisterBuiltinFunc(ast.JWTEncodeSignRaw.Name, builtinJWTEncodeSignRaw)
 	RegisterBuiltinFunc(ast.JWTEncodeSign.Name, builtinJWTEncodeSign)
 }
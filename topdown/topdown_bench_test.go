@@ -711,6 +711,50 @@ func BenchmarkFunctionArgumentIndex(b *testing.B) {
 	}
 }
 
+func BenchmarkParallelPartialRules(b *testing.B) {
+	// Each definition does expensive work: hashing a few hundred strings.
+	var module strings.Builder
+	module.WriteString("package test\n")
+	for i := 0; i < 32; i++ {
+		fmt.Fprintf(&module, "s[x] { x := count({h | n := numbers.range(1, 200)[_]; h := crypto.sha256(sprintf(\"%d-%%d\", [n]))}) }\n", i)
+	}
+
+	runParallelismBenchmark(b, module.String(), "data.test.s")
+}
+
+func BenchmarkParallelComprehension(b *testing.B) {
+	module := `package test
+
+		xs := [h | n := numbers.range(1, 2000)[_]; h := crypto.sha256(sprintf("%d", [n]))]`
+
+	runParallelismBenchmark(b, module, "data.test.xs")
+}
+
+func runParallelismBenchmark(b *testing.B, module, query string) {
+	ctx := context.Background()
+	compiler := ast.MustCompileModules(map[string]string{
+		"test.rego": module,
+	})
+	body := ast.MustParseBody(query)
+
+	for _, n := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				res, err := NewQuery(body).
+					WithCompiler(compiler).
+					WithParallelism(n).
+					Run(ctx)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(res) != 1 {
+					b.Fatalf("Expected one result, got %d", len(res))
+				}
+			}
+		})
+	}
+}
+
 func moduleWithDefs(n int) string {
 	var b strings.Builder
 
@@ -0,0 +1,374 @@
+// Copyright 2022 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+// defaultPorts are the ports removed by URL normalization.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+	"ftp":   "21",
+}
+
+// urlComponents holds the components of a URL. Userinfo, path, query and
+// fragment are percent-encoded.
+type urlComponents struct {
+	scheme   string
+	opaque   string
+	username string
+	password string
+	host     string
+	port     string
+	path     string
+	query    string
+	fragment string
+}
+
+// fields returns pointers to the components, keyed by their names in Rego.
+func (c *urlComponents) fields() map[string]*string {
+	return map[string]*string{
+		"scheme":   &c.scheme,
+		"opaque":   &c.opaque,
+		"username": &c.username,
+		"password": &c.password,
+		"host":     &c.host,
+		"port":     &c.port,
+		"path":     &c.path,
+		"query":    &c.query,
+		"fragment": &c.fragment,
+	}
+}
+
+func (c *urlComponents) object() ast.Object {
+	obj := ast.NewObject()
+	for name, v := range c.fields() {
+		obj.Insert(ast.StringTerm(name), ast.StringTerm(*v))
+	}
+	return obj
+}
+
+// url returns the URL formed by the components.
+func (c *urlComponents) url() (*url.URL, error) {
+	if c.opaque != "" && (c.host != "" || c.username != "" || c.password != "" || c.port != "" || c.path != "") {
+		return nil, fmt.Errorf("opaque URL cannot have userinfo, host, port or path")
+	}
+	if strings.ContainsAny(c.host, "/?#@") {
+		return nil, fmt.Errorf("invalid host %q", c.host)
+	}
+	if c.host == "" && c.port != "" {
+		return nil, fmt.Errorf("port requires a host")
+	}
+	if c.host == "" && (c.username != "" || c.password != "") {
+		return nil, fmt.Errorf("userinfo requires a host")
+	}
+	if c.host != "" && c.path != "" && !strings.HasPrefix(c.path, "/") {
+		return nil, fmt.Errorf("path must start with \"/\" when host is set")
+	}
+	if err := validatePort(c.port); err != nil {
+		return nil, err
+	}
+
+	u := url.URL{
+		Scheme:   c.scheme,
+		Opaque:   c.opaque,
+		Host:     c.host,
+		RawPath:  c.path,
+		RawQuery: c.query,
+	}
+	if strings.Contains(c.host, ":") {
+		u.Host = "[" + c.host + "]"
+	}
+	if c.port != "" {
+		u.Host += ":" + c.port
+	}
+
+	var err error
+	if c.username != "" || c.password != "" {
+		username, err := url.PathUnescape(c.username)
+		if err != nil {
+			return nil, err
+		}
+		if c.password == "" {
+			u.User = url.User(username)
+		} else {
+			password, err := url.PathUnescape(c.password)
+			if err != nil {
+				return nil, err
+			}
+			u.User = url.UserPassword(username, password)
+		}
+	}
+	if u.Path, err = url.PathUnescape(c.path); err != nil {
+		return nil, err
+	}
+	if u.Fragment, err = url.PathUnescape(c.fragment); err != nil {
+		return nil, err
+	}
+	u.RawFragment = c.fragment
+
+	// The URL must parse back, e.g. a scheme must be valid.
+	if _, err := parseURL(u.String()); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func newURLComponents(u *url.URL) *urlComponents {
+	c := urlComponents{
+		scheme:   u.Scheme,
+		opaque:   u.Opaque,
+		host:     u.Hostname(),
+		port:     u.Port(),
+		path:     u.EscapedPath(),
+		query:    u.RawQuery,
+		fragment: u.EscapedFragment(),
+	}
+	if u.User != nil {
+		userinfo := strings.SplitN(u.User.String(), ":", 2)
+		c.username = userinfo[0]
+		if len(userinfo) == 2 {
+			c.password = userinfo[1]
+		}
+	}
+	return &c
+}
+
+func parseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePort(u.Port()); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func validatePort(port string) error {
+	if port == "" {
+		return nil
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// normalizePercentEncoding decodes percent-encoded unreserved characters and
+// uppercases the hexadecimal digits of the remaining percent-encodings, see
+// https://tools.ietf.org/html/rfc3986#section-6.2.2.2
+func normalizePercentEncoding(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("invalid URL escape %q", s[i:])
+		}
+		c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid URL escape %q", s[i:i+3])
+		}
+		if isUnreserved(byte(c)) {
+			b.WriteByte(byte(c))
+		} else {
+			b.WriteByte('%')
+			b.WriteString(strings.ToUpper(s[i+1 : i+3]))
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// removeDotSegments removes "." and ".." segments from an absolute path, see
+// https://tools.ietf.org/html/rfc3986#section-5.2.4
+func removeDotSegments(path string) string {
+	segments := strings.Split(path, "/")[1:]
+	out := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		switch segment {
+		case ".":
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, segment)
+			continue
+		}
+		// A trailing dot-segment leaves a trailing slash.
+		if i == len(segments)-1 {
+			out = append(out, "")
+		}
+	}
+	return "/" + strings.Join(out, "/")
+}
+
+func builtinNetURLParse(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	s, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	u, err := parseURL(string(s))
+	if err != nil {
+		return err
+	}
+
+	return iter(ast.NewTerm(newURLComponents(u).object()))
+}
+
+func builtinNetURLNormalize(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	s, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	u, err := parseURL(string(s))
+	if err != nil {
+		return err
+	}
+
+	c := newURLComponents(u)
+	c.host = strings.ToLower(c.host)
+	for _, v := range []*string{&c.opaque, &c.username, &c.password, &c.path, &c.query, &c.fragment} {
+		if *v, err = normalizePercentEncoding(*v); err != nil {
+			return err
+		}
+	}
+	if strings.HasPrefix(c.path, "/") {
+		c.path = removeDotSegments(c.path)
+	}
+	if port, ok := defaultPorts[c.scheme]; ok {
+		if c.port == port {
+			c.port = ""
+		}
+		if c.host != "" && c.path == "" {
+			c.path = "/"
+		}
+	}
+
+	n, err := c.url()
+	if err != nil {
+		return err
+	}
+	return iter(ast.StringTerm(n.String()))
+}
+
+func builtinNetURLBuild(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	obj, err := builtins.ObjectOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	var c urlComponents
+	fields := c.fields()
+	err = obj.Iter(func(k, v *ast.Term) error {
+		name, ok := k.Value.(ast.String)
+		if !ok {
+			return builtins.NewOperandErr(1, "component names must be strings")
+		}
+		field, ok := fields[string(name)]
+		if !ok {
+			return builtins.NewOperandErr(1, "unknown component %v", name)
+		}
+		*field, err = urlComponent(string(name), v.Value)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	u, err := c.url()
+	if err != nil {
+		return err
+	}
+	return iter(ast.StringTerm(u.String()))
+}
+
+// urlComponent returns the string form of a component given to net.url.build.
+// Besides strings, the port can be given as a number, and the query as an
+// object of parameters, like the objects returned by urlquery.decode_object.
+func urlComponent(name string, v ast.Value) (string, error) {
+	switch v := v.(type) {
+	case ast.String:
+		return string(v), nil
+	case ast.Number:
+		if name == "port" {
+			if port, ok := v.Int(); ok {
+				return strconv.Itoa(port), nil
+			}
+			return "", builtins.NewOperandErr(1, "component port must be an integer")
+		}
+	case ast.Object:
+		if name == "query" {
+			return urlQuery(v)
+		}
+	}
+	switch name {
+	case "port":
+		return "", builtins.NewOperandErr(1, "component port must be a string or a number")
+	case "query":
+		return "", builtins.NewOperandErr(1, "component query must be a string or an object")
+	}
+	return "", builtins.NewOperandErr(1, "component %v must be a string", name)
+}
+
+// urlQuery encodes an object mapping parameter names to strings, or to arrays
+// or sets of strings.
+func urlQuery(obj ast.Object) (string, error) {
+	query := url.Values{}
+	err := obj.Iter(func(k, v *ast.Term) error {
+		name, ok := k.Value.(ast.String)
+		if !ok {
+			return builtins.NewOperandErr(1, "query parameter names must be strings")
+		}
+		add := func(t *ast.Term) error {
+			s, ok := t.Value.(ast.String)
+			if !ok {
+				return builtins.NewOperandErr(1, "query parameter %v must be a string or a collection of strings", name)
+			}
+			query.Add(string(name), string(s))
+			return nil
+		}
+		switch v := v.Value.(type) {
+		case ast.String:
+			return add(ast.NewTerm(v))
+		case *ast.Array:
+			return v.Iter(add)
+		case ast.Set:
+			return v.Iter(add)
+		}
+		return builtins.NewOperandErr(1, "query parameter %v must be a string or a collection of strings", name)
+	})
+	if err != nil {
+		return "", err
+	}
+	return query.Encode(), nil
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.NetURLParse.Name, builtinNetURLParse)
+	RegisterBuiltinFunc(ast.NetURLNormalize.Name, builtinNetURLNormalize)
+	RegisterBuiltinFunc(ast.NetURLBuild.Name, builtinNetURLBuild)
+}
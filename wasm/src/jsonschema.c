@@ -0,0 +1,5769 @@
+#include <string.h>
+
+#include "std.h"
+#include "json.h"
+#include "jsonschema.h"
+#include "malloc.h"
+#include "mpd.h"
+#include "regex.h"
+#include "str.h"
+#include "unicode.h"
+
+// The JSON schema builtins mirror the gojsonschema package used by the
+// topdown implementation: drafts are detected from the $schema keyword
+// (falling back to the hybrid draft), references are resolved within the
+// schema document only, and errors are reported with the same messages and
+// in the same order.
+
+#define JS_DRAFT4 (4)
+#define JS_DRAFT6 (6)
+#define JS_DRAFT7 (7)
+#define JS_HYBRID (0x7fffffff)
+
+// Bounds the recursion of the compiler and the validator, self-referencing
+// and very deeply nested schemas are reported as undefined rather than
+// exhausting the (64 KiB) stack.
+#define JS_MAX_DEPTH (512)
+
+#define JS_UNSET (-1)
+#define JS_FALSE (0)
+#define JS_TRUE (1)
+#define JS_SCHEMA (2)
+
+typedef struct
+{
+    const char *s;
+    size_t len;
+} js_str;
+
+typedef struct
+{
+    char *s;
+    size_t len;
+    size_t cap;
+} js_buf;
+
+typedef struct
+{
+    void **elems;
+    size_t len;
+    size_t cap;
+} js_vec;
+
+static const char __hex_lower[] = "0123456789abcdef";
+static const char __hex_upper[] = "0123456789ABCDEF";
+
+static void __buf_write(js_buf *b, const char *s, size_t len)
+{
+    if (b->len + len + 1 > b->cap)
+    {
+        size_t cap = b->cap > 0 ? b->cap * 2 : 32;
+
+        while (b->len + len + 1 > cap)
+        {
+            cap *= 2;
+        }
+
+        char *p = opa_malloc(cap);
+
+        if (b->s != NULL)
+        {
+            memcpy(p, b->s, b->len);
+            opa_free(b->s);
+        }
+
+        b->s = p;
+        b->cap = cap;
+    }
+
+    if (len > 0)
+    {
+        memcpy(&b->s[b->len], s, len);
+    }
+
+    b->len += len;
+    b->s[b->len] = '\0';
+}
+
+static void __buf_puts(js_buf *b, const char *s)
+{
+    __buf_write(b, s, opa_strlen(s));
+}
+
+static void __buf_putc(js_buf *b, char c)
+{
+    __buf_write(b, &c, 1);
+}
+
+static void __buf_str(js_buf *b, js_str s)
+{
+    __buf_write(b, s.s, s.len);
+}
+
+static void __buf_int(js_buf *b, long long i)
+{
+    char tmp[24];
+    opa_itoa(i, tmp, 10);
+    __buf_puts(b, tmp);
+}
+
+static js_str __buf_get(js_buf *b)
+{
+    js_str s = {.s = b->s != NULL ? b->s : "", .len = b->len};
+    return s;
+}
+
+static void __vec_append(js_vec *v, void *p)
+{
+    if (v->len == v->cap)
+    {
+        size_t cap = v->cap > 0 ? v->cap * 2 : 4;
+        void **elems = opa_malloc(cap * sizeof(void *));
+
+        if (v->elems != NULL)
+        {
+            memcpy(elems, v->elems, v->len * sizeof(void *));
+            opa_free(v->elems);
+        }
+
+        v->elems = elems;
+        v->cap = cap;
+    }
+
+    v->elems[v->len++] = p;
+}
+
+static js_str __str(const char *s)
+{
+    js_str r = {.s = s, .len = opa_strlen(s)};
+    return r;
+}
+
+static js_str __substr(js_str s, size_t start, size_t end)
+{
+    js_str r = {.s = s.s + start, .len = end - start};
+    return r;
+}
+
+static js_str __str_value(opa_value *v)
+{
+    opa_string_t *s = opa_cast_string(v);
+    js_str r = {.s = s->v, .len = s->len};
+    return r;
+}
+
+static bool __str_eq(js_str a, js_str b)
+{
+    return a.len == b.len && (a.len == 0 || memcmp(a.s, b.s, a.len) == 0);
+}
+
+static bool __str_eq_lit(js_str a, const char *b)
+{
+    return __str_eq(a, __str(b));
+}
+
+static bool __str_prefix(js_str s, const char *prefix)
+{
+    size_t n = opa_strlen(prefix);
+    return s.len >= n && memcmp(s.s, prefix, n) == 0;
+}
+
+static int __str_cmp(js_str a, js_str b)
+{
+    size_t n = a.len < b.len ? a.len : b.len;
+    int c = n > 0 ? memcmp(a.s, b.s, n) : 0;
+
+    if (c != 0)
+    {
+        return c;
+    }
+
+    return a.len < b.len ? -1 : (a.len > b.len ? 1 : 0);
+}
+
+static int __str_index(js_str s, char c)
+{
+    for (size_t i = 0; i < s.len; i++)
+    {
+        if (s.s[i] == c)
+        {
+            return i;
+        }
+    }
+
+    return -1;
+}
+
+static int __str_last_index(js_str s, char c)
+{
+    for (size_t i = s.len; i > 0; i--)
+    {
+        if (s.s[i - 1] == c)
+        {
+            return i - 1;
+        }
+    }
+
+    return -1;
+}
+
+static bool __str_contains(js_str s, char c)
+{
+    return __str_index(s, c) >= 0;
+}
+
+static opa_value *__string(js_str s)
+{
+    return opa_string(s.s, s.len);
+}
+
+static opa_value *__get(opa_value *obj, const char *key)
+{
+    opa_string_t k = {.hdr = {OPA_STRING}, .free = 0, .len = opa_strlen(key), .v = key};
+    return opa_value_get(obj, &k.hdr);
+}
+
+static bool __is_alpha(unsigned char c)
+{
+    return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z');
+}
+
+static bool __is_alnum(unsigned char c)
+{
+    return __is_alpha(c) || ('0' <= c && c <= '9');
+}
+
+static bool __is_digit(unsigned char c)
+{
+    return '0' <= c && c <= '9';
+}
+
+// strconv.IsPrint for the runes the builtins may have to quote.
+static bool __is_print(int r)
+{
+    if (r < 0x20 || r == 0x7f)
+    {
+        return false;
+    }
+
+    if (r < 0x7f)
+    {
+        return true;
+    }
+
+    if (r < 0xa1 || r == 0xad || r == 0x1680 || r == 0x3000 || r == 0xfeff)
+    {
+        return false;
+    }
+
+    if ((r >= 0x2000 && r <= 0x200f) || (r >= 0x2028 && r <= 0x202f) ||
+        (r >= 0x205f && r <= 0x206f) || (r >= 0xd800 && r <= 0xf8ff) ||
+        (r >= 0xfff9 && r <= 0xfffb) || r == 0xfffe || r == 0xffff)
+    {
+        return false;
+    }
+
+    return true;
+}
+
+static void __quote_rune(js_buf *b, int r, const char *raw, int n)
+{
+    if (r == '"' || r == '\\')
+    {
+        __buf_putc(b, '\\');
+        __buf_putc(b, r);
+        return;
+    }
+
+    if (__is_print(r))
+    {
+        __buf_write(b, raw, n);
+        return;
+    }
+
+    switch (r)
+    {
+    case '\a':
+        __buf_puts(b, "\\a");
+        return;
+    case '\b':
+        __buf_puts(b, "\\b");
+        return;
+    case '\f':
+        __buf_puts(b, "\\f");
+        return;
+    case '\n':
+        __buf_puts(b, "\\n");
+        return;
+    case '\r':
+        __buf_puts(b, "\\r");
+        return;
+    case '\t':
+        __buf_puts(b, "\\t");
+        return;
+    case '\v':
+        __buf_puts(b, "\\v");
+        return;
+    }
+
+    if (r < ' ' || r == 0x7f)
+    {
+        __buf_puts(b, "\\x");
+        __buf_putc(b, __hex_lower[(r >> 4) & 15]);
+        __buf_putc(b, __hex_lower[r & 15]);
+        return;
+    }
+
+    int digits = r < 0x10000 ? 4 : 8;
+    __buf_puts(b, r < 0x10000 ? "\\u" : "\\U");
+
+    for (int i = digits - 1; i >= 0; i--)
+    {
+        __buf_putc(b, __hex_lower[(r >> (i * 4)) & 15]);
+    }
+}
+
+// strconv.Quote
+static void __quote(js_buf *b, js_str s)
+{
+    __buf_putc(b, '"');
+
+    for (size_t i = 0; i < s.len;)
+    {
+        int n = 0;
+        int r = opa_unicode_decode_utf8(s.s, i, s.len, &n);
+
+        if (r < 0)
+        {
+            unsigned char c = s.s[i];
+            __buf_puts(b, "\\x");
+            __buf_putc(b, __hex_lower[c >> 4]);
+            __buf_putc(b, __hex_lower[c & 15]);
+            i++;
+            continue;
+        }
+
+        __quote_rune(b, r, &s.s[i], n);
+        i += n;
+    }
+
+    __buf_putc(b, '"');
+}
+
+// quoteChar of the encoding/json scanner.
+static void __quote_char(js_buf *b, unsigned char c)
+{
+    if (c == '\'')
+    {
+        __buf_puts(b, "'\\''");
+        return;
+    }
+
+    if (c == '"')
+    {
+        __buf_puts(b, "'\"'");
+        return;
+    }
+
+    char raw[4];
+    int n = opa_unicode_encode_utf8(c, raw);
+
+    __buf_putc(b, '\'');
+    __quote_rune(b, c, raw, n);
+    __buf_putc(b, '\'');
+}
+
+#define JS_SCAN_OK (0)
+#define JS_SCAN_EOF (1)
+#define JS_SCAN_UNEXPECTED_EOF (2)
+#define JS_SCAN_ERROR (3)
+
+#define JS_STATE_BEGIN_VALUE_OR_EMPTY (0)
+#define JS_STATE_BEGIN_VALUE (1)
+#define JS_STATE_BEGIN_STRING_OR_EMPTY (2)
+#define JS_STATE_BEGIN_STRING (3)
+#define JS_STATE_END_VALUE (4)
+#define JS_STATE_IN_STRING (5)
+#define JS_STATE_IN_STRING_ESC (6)
+#define JS_STATE_IN_STRING_ESC_U (7)
+#define JS_STATE_NEG (8)
+#define JS_STATE_1 (9)
+#define JS_STATE_0 (10)
+#define JS_STATE_DOT (11)
+#define JS_STATE_DOT_0 (12)
+#define JS_STATE_E (13)
+#define JS_STATE_E_SIGN (14)
+#define JS_STATE_E_0 (15)
+#define JS_STATE_LITERAL (16)
+
+#define JS_PARSE_OBJECT_KEY (0)
+#define JS_PARSE_OBJECT_VALUE (1)
+#define JS_PARSE_ARRAY_VALUE (2)
+
+#define JS_MAX_NESTING_DEPTH (10000)
+
+static bool __is_space(unsigned char c)
+{
+    return c == ' ' || c == '\t' || c == '\r' || c == '\n';
+}
+
+static int __scan_error(js_buf *err, unsigned char c, const char *context)
+{
+    __buf_puts(err, "invalid character ");
+    __quote_char(err, c);
+    __buf_putc(err, ' ');
+    __buf_puts(err, context);
+    return JS_SCAN_ERROR;
+}
+
+// Scans a single JSON value starting at *pos with the state machine of the
+// encoding/json scanner, so that syntax errors read the same as in topdown.
+// On success *pos points past the end of the value.
+static int __scan_value(js_str in, size_t *pos, js_buf *err)
+{
+    js_buf stack = {0};
+    int state = JS_STATE_BEGIN_VALUE;
+    const char *literal = NULL;
+    int literal_pos = 0;
+    int escapes = 0;
+    bool nonspace = false;
+
+    for (size_t i = *pos; i < in.len; i++)
+    {
+        unsigned char c = in.s[i];
+
+        if (!__is_space(c))
+        {
+            nonspace = true;
+        }
+
+    redo:
+        switch (state)
+        {
+        case JS_STATE_BEGIN_VALUE_OR_EMPTY:
+            if (__is_space(c))
+            {
+                continue;
+            }
+
+            state = c == ']' ? JS_STATE_END_VALUE : JS_STATE_BEGIN_VALUE;
+            goto redo;
+
+        case JS_STATE_BEGIN_VALUE:
+            if (__is_space(c))
+            {
+                continue;
+            }
+
+            switch (c)
+            {
+            case '{':
+            case '[':
+                __buf_putc(&stack, c == '{' ? JS_PARSE_OBJECT_KEY : JS_PARSE_ARRAY_VALUE);
+
+                if (stack.len > JS_MAX_NESTING_DEPTH)
+                {
+                    return __scan_error(err, c, "exceeded max depth");
+                }
+
+                state = c == '{' ? JS_STATE_BEGIN_STRING_OR_EMPTY : JS_STATE_BEGIN_VALUE_OR_EMPTY;
+                continue;
+            case '"':
+                state = JS_STATE_IN_STRING;
+                continue;
+            case '-':
+                state = JS_STATE_NEG;
+                continue;
+            case '0':
+                state = JS_STATE_0;
+                continue;
+            case 't':
+                literal = "true";
+                break;
+            case 'f':
+                literal = "false";
+                break;
+            case 'n':
+                literal = "null";
+                break;
+            default:
+                if ('1' <= c && c <= '9')
+                {
+                    state = JS_STATE_1;
+                    continue;
+                }
+
+                return __scan_error(err, c, "looking for beginning of value");
+            }
+
+            literal_pos = 1;
+            state = JS_STATE_LITERAL;
+            continue;
+
+        case JS_STATE_BEGIN_STRING_OR_EMPTY:
+            if (__is_space(c))
+            {
+                continue;
+            }
+
+            if (c == '}')
+            {
+                stack.s[stack.len - 1] = JS_PARSE_OBJECT_VALUE;
+                state = JS_STATE_END_VALUE;
+                goto redo;
+            }
+
+            state = JS_STATE_BEGIN_STRING;
+            goto redo;
+
+        case JS_STATE_BEGIN_STRING:
+            if (__is_space(c))
+            {
+                continue;
+            }
+
+            if (c == '"')
+            {
+                state = JS_STATE_IN_STRING;
+                continue;
+            }
+
+            return __scan_error(err, c, "looking for beginning of object key string");
+
+        case JS_STATE_END_VALUE:
+            if (stack.len == 0)
+            {
+                *pos = i;
+                return JS_SCAN_OK;
+            }
+
+            if (__is_space(c))
+            {
+                continue;
+            }
+
+            switch (stack.s[stack.len - 1])
+            {
+            case JS_PARSE_OBJECT_KEY:
+                if (c == ':')
+                {
+                    stack.s[stack.len - 1] = JS_PARSE_OBJECT_VALUE;
+                    state = JS_STATE_BEGIN_VALUE;
+                    continue;
+                }
+
+                return __scan_error(err, c, "after object key");
+            case JS_PARSE_OBJECT_VALUE:
+                if (c == ',')
+                {
+                    stack.s[stack.len - 1] = JS_PARSE_OBJECT_KEY;
+                    state = JS_STATE_BEGIN_STRING;
+                    continue;
+                }
+
+                if (c == '}')
+                {
+                    break;
+                }
+
+                return __scan_error(err, c, "after object key:value pair");
+            default:
+                if (c == ',')
+                {
+                    state = JS_STATE_BEGIN_VALUE;
+                    continue;
+                }
+
+                if (c == ']')
+                {
+                    break;
+                }
+
+                return __scan_error(err, c, "after array element");
+            }
+
+            stack.len--;
+
+            if (stack.len == 0)
+            {
+                *pos = i + 1;
+                return JS_SCAN_OK;
+            }
+
+            continue;
+
+        case JS_STATE_IN_STRING:
+            if (c == '"')
+            {
+                state = JS_STATE_END_VALUE;
+                continue;
+            }
+
+            if (c == '\\')
+            {
+                state = JS_STATE_IN_STRING_ESC;
+                continue;
+            }
+
+            if (c < 0x20)
+            {
+                return __scan_error(err, c, "in string literal");
+            }
+
+            continue;
+
+        case JS_STATE_IN_STRING_ESC:
+            switch (c)
+            {
+            case 'b':
+            case 'f':
+            case 'n':
+            case 'r':
+            case 't':
+            case '\\':
+            case '/':
+            case '"':
+                state = JS_STATE_IN_STRING;
+                continue;
+            case 'u':
+                escapes = 0;
+                state = JS_STATE_IN_STRING_ESC_U;
+                continue;
+            }
+
+            return __scan_error(err, c, "in string escape code");
+
+        case JS_STATE_IN_STRING_ESC_U:
+            if (opa_ishex(c))
+            {
+                if (++escapes == 4)
+                {
+                    state = JS_STATE_IN_STRING;
+                }
+
+                continue;
+            }
+
+            return __scan_error(err, c, "in \\u hexadecimal character escape");
+
+        case JS_STATE_NEG:
+            if (c == '0')
+            {
+                state = JS_STATE_0;
+                continue;
+            }
+
+            if ('1' <= c && c <= '9')
+            {
+                state = JS_STATE_1;
+                continue;
+            }
+
+            return __scan_error(err, c, "in numeric literal");
+
+        case JS_STATE_1:
+            if (__is_digit(c))
+            {
+                continue;
+            }
+
+            state = JS_STATE_0;
+            goto redo;
+
+        case JS_STATE_0:
+            if (c == '.')
+            {
+                state = JS_STATE_DOT;
+                continue;
+            }
+
+            if (c == 'e' || c == 'E')
+            {
+                state = JS_STATE_E;
+                continue;
+            }
+
+            state = JS_STATE_END_VALUE;
+            goto redo;
+
+        case JS_STATE_DOT:
+            if (__is_digit(c))
+            {
+                state = JS_STATE_DOT_0;
+                continue;
+            }
+
+            return __scan_error(err, c, "after decimal point in numeric literal");
+
+        case JS_STATE_DOT_0:
+            if (__is_digit(c))
+            {
+                continue;
+            }
+
+            if (c == 'e' || c == 'E')
+            {
+                state = JS_STATE_E;
+                continue;
+            }
+
+            state = JS_STATE_END_VALUE;
+            goto redo;
+
+        case JS_STATE_E:
+            if (c == '+' || c == '-')
+            {
+                state = JS_STATE_E_SIGN;
+                continue;
+            }
+
+            state = JS_STATE_E_SIGN;
+            goto redo;
+
+        case JS_STATE_E_SIGN:
+            if (__is_digit(c))
+            {
+                state = JS_STATE_E_0;
+                continue;
+            }
+
+            return __scan_error(err, c, "in exponent of numeric literal");
+
+        case JS_STATE_E_0:
+            if (__is_digit(c))
+            {
+                continue;
+            }
+
+            state = JS_STATE_END_VALUE;
+            goto redo;
+
+        case JS_STATE_LITERAL:
+            if (c == (unsigned char)literal[literal_pos])
+            {
+                if (literal[++literal_pos] == '\0')
+                {
+                    state = JS_STATE_END_VALUE;
+                }
+
+                continue;
+            }
+
+            char context[48] = "in literal ";
+            size_t n = opa_strlen(context);
+            size_t m = opa_strlen(literal);
+
+            memcpy(&context[n], literal, m);
+            memcpy(&context[n + m], " (expecting '", 13);
+            context[n + m + 13] = literal[literal_pos];
+            memcpy(&context[n + m + 14], "')", 3);
+
+            return __scan_error(err, c, context);
+        }
+    }
+
+    if (stack.len == 0)
+    {
+        switch (state)
+        {
+        case JS_STATE_END_VALUE:
+        case JS_STATE_0:
+        case JS_STATE_1:
+        case JS_STATE_DOT_0:
+        case JS_STATE_E_0:
+            *pos = in.len;
+            return JS_SCAN_OK;
+        }
+    }
+
+    return nonspace ? JS_SCAN_UNEXPECTED_EOF : JS_SCAN_EOF;
+}
+
+// Decodes the JSON document like util.UnmarshalJSON: the first value is
+// decoded and any further token is reported the way the decoder does.
+// The returned value is NULL if the document cannot be decoded, with a
+// description of the problem in err.
+static opa_value *__json_decode(js_str in, js_buf *err)
+{
+    size_t pos = 0;
+
+    switch (__scan_value(in, &pos, err))
+    {
+    case JS_SCAN_EOF:
+        __buf_puts(err, "EOF");
+        return NULL;
+    case JS_SCAN_UNEXPECTED_EOF:
+        __buf_puts(err, "unexpected EOF");
+        return NULL;
+    case JS_SCAN_ERROR:
+        return NULL;
+    }
+
+    size_t end = pos;
+
+    while (pos < in.len && __is_space(in.s[pos]))
+    {
+        pos++;
+    }
+
+    if (pos < in.len)
+    {
+        unsigned char c = in.s[pos];
+
+        switch (c)
+        {
+        case '[':
+        case '{':
+            __buf_puts(err, "error: invalid character '");
+            __buf_putc(err, c);
+            __buf_puts(err, "' after top-level value");
+            return NULL;
+        case ']':
+        case '}':
+        case ':':
+        case ',':
+            __scan_error(err, c, "looking for beginning of value");
+            return NULL;
+        }
+
+        size_t start = pos;
+
+        switch (__scan_value(in, &pos, err))
+        {
+        case JS_SCAN_EOF:
+        case JS_SCAN_UNEXPECTED_EOF:
+            __buf_puts(err, "unexpected EOF");
+            return NULL;
+        case JS_SCAN_ERROR:
+            return NULL;
+        }
+
+        js_str token = __substr(in, start, pos);
+
+        if (!__str_eq_lit(token, "null"))
+        {
+            __buf_puts(err, "error: invalid character '");
+
+            if (token.s[0] == '"')
+            {
+                opa_value *s = opa_json_parse(token.s, token.len);
+
+                if (s == NULL)
+                {
+                    return NULL;
+                }
+
+                __buf_str(err, __str_value(s));
+            }
+            else if (__str_eq_lit(token, "true") || __str_eq_lit(token, "false"))
+            {
+                __buf_puts(err, "%!s(bool=");
+                __buf_str(err, token);
+                __buf_putc(err, ')');
+            }
+            else
+            {
+                __buf_str(err, token);
+            }
+
+            __buf_puts(err, "' after top-level value");
+            return NULL;
+        }
+    }
+
+    return opa_json_parse(in.s, end);
+}
+
+static void __marshal_string(js_buf *b, js_str s)
+{
+    size_t start = 0;
+
+    __buf_putc(b, '"');
+
+    for (size_t i = 0; i < s.len;)
+    {
+        unsigned char c = s.s[i];
+
+        if (c < 0x80)
+        {
+            if (c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&')
+            {
+                i++;
+                continue;
+            }
+
+            __buf_write(b, &s.s[start], i - start);
+
+            switch (c)
+            {
+            case '"':
+            case '\\':
+                __buf_putc(b, '\\');
+                __buf_putc(b, c);
+                break;
+            case '\b':
+                __buf_puts(b, "\\b");
+                break;
+            case '\f':
+                __buf_puts(b, "\\f");
+                break;
+            case '\n':
+                __buf_puts(b, "\\n");
+                break;
+            case '\r':
+                __buf_puts(b, "\\r");
+                break;
+            case '\t':
+                __buf_puts(b, "\\t");
+                break;
+            default:
+                __buf_puts(b, "\\u00");
+                __buf_putc(b, __hex_lower[c >> 4]);
+                __buf_putc(b, __hex_lower[c & 15]);
+            }
+
+            start = ++i;
+            continue;
+        }
+
+        int n = 0;
+        int r = opa_unicode_decode_utf8(s.s, i, s.len, &n);
+
+        if (r < 0)
+        {
+            __buf_write(b, &s.s[start], i - start);
+            __buf_puts(b, "\\ufffd");
+            start = ++i;
+            continue;
+        }
+
+        if (r == 0x2028 || r == 0x2029)
+        {
+            __buf_write(b, &s.s[start], i - start);
+            __buf_puts(b, "\\u202");
+            __buf_putc(b, __hex_lower[r & 15]);
+            i += n;
+            start = i;
+            continue;
+        }
+
+        i += n;
+    }
+
+    __buf_write(b, &s.s[start], s.len - start);
+    __buf_putc(b, '"');
+}
+
+// Returns the literal of the number, tmp has to hold at least 24 bytes.
+static js_str __number_literal(opa_value *v, char *tmp)
+{
+    opa_number_t *n = opa_cast_number(v);
+
+    if (n->repr == OPA_NUMBER_REPR_INT)
+    {
+        opa_itoa(n->v.i, tmp, 10);
+        return __str(tmp);
+    }
+
+    js_str s = {.s = n->v.ref.s, .len = n->v.ref.len};
+    return s;
+}
+
+// Decimal representation of a number: 0.d * 10^dp, without leading or
+// trailing zeros in d. Zero has no digits.
+typedef struct
+{
+    bool neg;
+    char *d;
+    int nd;
+    long long dp;
+} js_decimal;
+
+static void __decimal(js_decimal *x, opa_value *v)
+{
+    char tmp[24];
+    js_str s = __number_literal(v, tmp);
+    long long dp = 0;
+    bool point = false;
+    size_t i = 0;
+
+    x->d = opa_malloc(s.len + 1);
+    x->nd = 0;
+    x->neg = false;
+
+    if (i < s.len && (s.s[i] == '-' || s.s[i] == '+'))
+    {
+        x->neg = s.s[i++] == '-';
+    }
+
+    for (; i < s.len; i++)
+    {
+        char c = s.s[i];
+
+        if (c == '.')
+        {
+            point = true;
+            continue;
+        }
+
+        if (!__is_digit(c))
+        {
+            break;
+        }
+
+        if (!point)
+        {
+            dp++;
+        }
+
+        if (c == '0' && x->nd == 0)
+        {
+            dp--;
+            continue;
+        }
+
+        x->d[x->nd++] = c;
+    }
+
+    if (i < s.len && (s.s[i] == 'e' || s.s[i] == 'E'))
+    {
+        bool neg = false;
+        long long e = 0;
+
+        if (++i < s.len && (s.s[i] == '-' || s.s[i] == '+'))
+        {
+            neg = s.s[i++] == '-';
+        }
+
+        for (; i < s.len && __is_digit(s.s[i]); i++)
+        {
+            if (e < 1000000000)
+            {
+                e = e * 10 + (s.s[i] - '0');
+            }
+        }
+
+        dp += neg ? -e : e;
+    }
+
+    while (x->nd > 0 && x->d[x->nd - 1] == '0')
+    {
+        x->nd--;
+    }
+
+    x->dp = x->nd == 0 ? 0 : dp;
+}
+
+static bool __decimal_is_int(js_decimal *x)
+{
+    return x->nd == 0 || x->dp >= x->nd;
+}
+
+static void __write_fixed(js_buf *b, const char *d, int nd, long long dp)
+{
+    if (dp > 0)
+    {
+        long long m = nd < dp ? nd : dp;
+        __buf_write(b, d, m);
+
+        for (long long k = m; k < dp; k++)
+        {
+            __buf_putc(b, '0');
+        }
+    }
+    else
+    {
+        __buf_putc(b, '0');
+    }
+
+    if (nd > dp)
+    {
+        __buf_putc(b, '.');
+
+        for (long long k = 0; k < nd - dp; k++)
+        {
+            long long j = dp + k;
+            __buf_putc(b, j >= 0 ? d[j] : '0');
+        }
+    }
+}
+
+static void __write_exp(js_buf *b, const char *d, int nd, long long dp, bool pad)
+{
+    long long e = dp - 1;
+
+    __buf_putc(b, d[0]);
+
+    if (nd > 1)
+    {
+        __buf_putc(b, '.');
+        __buf_write(b, &d[1], nd - 1);
+    }
+
+    __buf_putc(b, 'e');
+    __buf_putc(b, e < 0 ? '-' : '+');
+
+    if (e < 0)
+    {
+        e = -e;
+    }
+
+    if (pad && e < 10)
+    {
+        __buf_putc(b, '0');
+    }
+
+    __buf_int(b, e);
+}
+
+// Formats the number like the %v verb of big.Float, which the error
+// descriptions use for the numeric keywords of a schema.
+static void __write_number(js_buf *b, opa_value *v)
+{
+    js_decimal x;
+    __decimal(&x, v);
+
+    if (x.nd == 0)
+    {
+        __buf_putc(b, '0');
+        return;
+    }
+
+    if (x.neg)
+    {
+        __buf_putc(b, '-');
+    }
+
+    if (x.dp - 1 < -4 || x.dp - 1 >= 6)
+    {
+        __write_exp(b, x.d, x.nd, x.dp, true);
+        return;
+    }
+
+    __write_fixed(b, x.d, x.nd, x.dp);
+}
+
+static int __digits_cmp(const char *d, int nd, const char *e)
+{
+    int ne = opa_strlen(e);
+
+    for (int i = 0; i < nd || i < ne; i++)
+    {
+        char a = i < nd ? d[i] : '0';
+        char c = i < ne ? e[i] : '0';
+
+        if (a != c)
+        {
+            return a < c ? -1 : 1;
+        }
+    }
+
+    return 0;
+}
+
+// Formats the number the way encoding/json marshals it once decoded into a
+// float64. Returns false if the number overflows a float64. Numbers with
+// more than 17 significant digits are rounded to 17 digits, which matches
+// the float64 round trip for all but a few edge cases.
+static bool __write_float(js_buf *b, opa_value *v)
+{
+    js_decimal x;
+    __decimal(&x, v);
+
+    if (x.nd > 0 && (x.dp > 309 || (x.dp == 309 && __digits_cmp(x.d, x.nd, "1797693134862315807937") > 0)))
+    {
+        return false;
+    }
+
+    if (x.nd > 17)
+    {
+        bool up = x.d[17] > '5' || (x.d[17] == '5' && (x.nd > 18 || ((x.d[16] - '0') & 1)));
+
+        x.nd = 17;
+
+        if (up)
+        {
+            int k = 16;
+
+            while (k >= 0 && x.d[k] == '9')
+            {
+                k--;
+            }
+
+            if (k < 0)
+            {
+                x.d[0] = '1';
+                x.nd = 1;
+                x.dp++;
+            }
+            else
+            {
+                x.d[k]++;
+                x.nd = k + 1;
+            }
+        }
+
+        while (x.nd > 0 && x.d[x.nd - 1] == '0')
+        {
+            x.nd--;
+        }
+    }
+
+    if (x.nd > 0 && x.dp < -323)
+    {
+        x.nd = 0;
+    }
+
+    if (x.neg)
+    {
+        __buf_putc(b, '-');
+    }
+
+    if (x.nd == 0)
+    {
+        __buf_putc(b, '0');
+        return true;
+    }
+
+    if (x.dp < -5 || x.dp >= 22)
+    {
+        __write_exp(b, x.d, x.nd, x.dp, false);
+        return true;
+    }
+
+    __write_fixed(b, x.d, x.nd, x.dp);
+    return true;
+}
+
+// Marshals the value as JSON. Numbers are written as float64 unless literal
+// is set. Returns the number that overflows a float64, or NULL.
+static opa_value *__marshal(js_buf *b, opa_value *v, bool literal)
+{
+    switch (opa_value_type(v))
+    {
+    case OPA_NULL:
+        __buf_puts(b, "null");
+        break;
+    case OPA_BOOLEAN:
+        __buf_puts(b, opa_cast_boolean(v)->v ? "true" : "false");
+        break;
+    case OPA_NUMBER:
+        if (literal)
+        {
+            char tmp[24];
+            __buf_str(b, __number_literal(v, tmp));
+        }
+        else if (!__write_float(b, v))
+        {
+            return v;
+        }
+        break;
+    case OPA_STRING:
+        __marshal_string(b, __str_value(v));
+        break;
+    case OPA_ARRAY:
+    {
+        opa_array_t *arr = opa_cast_array(v);
+        opa_value *bad = NULL;
+
+        __buf_putc(b, '[');
+
+        for (size_t i = 0; i < arr->len; i++)
+        {
+            if (i > 0)
+            {
+                __buf_putc(b, ',');
+            }
+
+            opa_value *r = __marshal(b, arr->elems[i].v, literal);
+
+            if (bad == NULL)
+            {
+                bad = r;
+            }
+        }
+
+        __buf_putc(b, ']');
+        return bad;
+    }
+    case OPA_OBJECT:
+    {
+        opa_array_t *keys = opa_object_keys(opa_cast_object(v));
+        opa_value *bad = NULL;
+
+        __buf_putc(b, '{');
+
+        for (size_t i = 0; i < keys->len; i++)
+        {
+            if (i > 0)
+            {
+                __buf_putc(b, ',');
+            }
+
+            __marshal_string(b, __str_value(keys->elems[i].v));
+            __buf_putc(b, ':');
+
+            opa_value *r = __marshal(b, opa_value_get(v, keys->elems[i].v), literal);
+
+            if (bad == NULL)
+            {
+                bad = r;
+            }
+        }
+
+        __buf_putc(b, '}');
+        return bad;
+    }
+    }
+
+    return NULL;
+}
+
+// Converts the value to the JSON document the topdown implementation hands
+// to gojsonschema: sets become sorted arrays and non-string keys are
+// replaced by their JSON encoding.
+static opa_value *__normalize(opa_value *v)
+{
+    switch (opa_value_type(v))
+    {
+    case OPA_ARRAY:
+    {
+        opa_array_t *arr = opa_cast_array(v);
+        opa_array_t *r = opa_cast_array(opa_array_with_cap(arr->len));
+
+        for (size_t i = 0; i < arr->len; i++)
+        {
+            opa_array_append(r, __normalize(arr->elems[i].v));
+        }
+
+        return &r->hdr;
+    }
+    case OPA_SET:
+    {
+        opa_array_t *r = opa_cast_array(opa_array_with_cap(opa_value_length(v)));
+
+        for (opa_value *e = opa_value_iter(v, NULL); e != NULL; e = opa_value_iter(v, e))
+        {
+            size_t j = r->len;
+            opa_array_append(r, e);
+
+            while (j > 0 && opa_value_compare(r->elems[j - 1].v, e) > 0)
+            {
+                r->elems[j].v = r->elems[j - 1].v;
+                j--;
+            }
+
+            r->elems[j].v = e;
+        }
+
+        for (size_t i = 0; i < r->len; i++)
+        {
+            r->elems[i].v = __normalize(r->elems[i].v);
+        }
+
+        return &r->hdr;
+    }
+    case OPA_OBJECT:
+    {
+        opa_array_t *keys = opa_object_keys(opa_cast_object(v));
+        opa_object_t *r = opa_cast_object(opa_object());
+
+        for (size_t i = 0; i < keys->len; i++)
+        {
+            opa_value *k = keys->elems[i].v;
+            opa_value *val = opa_value_get(v, k);
+
+            if (opa_value_type(k) != OPA_STRING)
+            {
+                js_buf b = {0};
+                __marshal(&b, __normalize(k), true);
+                k = opa_string_allocated(b.s, b.len);
+            }
+
+            opa_object_insert(r, k, __normalize(val));
+        }
+
+        return &r->hdr;
+    }
+    }
+
+    return v;
+}
+
+#define JS_ENCODE_PATH (1)
+#define JS_ENCODE_HOST (2)
+#define JS_ENCODE_ZONE (3)
+#define JS_ENCODE_USER_PASSWORD (4)
+#define JS_ENCODE_FRAGMENT (5)
+
+// URL as parsed by net/url, the references of a schema are resolved the
+// way gojsonreference does it.
+typedef struct
+{
+    js_str scheme;
+    js_str opaque;
+    bool user;
+    js_str username;
+    js_str password;
+    bool password_set;
+    js_str host;
+    js_str path;
+    js_str raw_path;
+    bool omit_host;
+    bool force_query;
+    js_str raw_query;
+    js_str fragment;
+    js_str raw_fragment;
+} js_url;
+
+static bool __url_should_escape(unsigned char c, int mode)
+{
+    if (__is_alnum(c))
+    {
+        return false;
+    }
+
+    if (mode == JS_ENCODE_HOST || mode == JS_ENCODE_ZONE)
+    {
+        switch (c)
+        {
+        case '!':
+        case '$':
+        case '&':
+        case '\'':
+        case '(':
+        case ')':
+        case '*':
+        case '+':
+        case ',':
+        case ';':
+        case '=':
+        case ':':
+        case '[':
+        case ']':
+        case '<':
+        case '>':
+        case '"':
+            return false;
+        }
+    }
+
+    switch (c)
+    {
+    case '-':
+    case '_':
+    case '.':
+    case '~':
+        return false;
+    case '$':
+    case '&':
+    case '+':
+    case ',':
+    case '/':
+    case ':':
+    case ';':
+    case '=':
+    case '?':
+    case '@':
+        switch (mode)
+        {
+        case JS_ENCODE_PATH:
+            return c == '?';
+        case JS_ENCODE_USER_PASSWORD:
+            return c == '@' || c == '/' || c == '?' || c == ':';
+        case JS_ENCODE_FRAGMENT:
+            return false;
+        }
+    }
+
+    if (mode == JS_ENCODE_FRAGMENT)
+    {
+        switch (c)
+        {
+        case '!':
+        case '(':
+        case ')':
+        case '*':
+            return false;
+        }
+    }
+
+    return true;
+}
+
+static unsigned char __unhex(unsigned char c)
+{
+    return 9 * (c >> 6) + (c & 15);
+}
+
+static bool __url_unescape(js_str s, int mode, js_str *out, js_buf *err)
+{
+    size_t n = 0;
+
+    for (size_t i = 0; i < s.len;)
+    {
+        unsigned char c = s.s[i];
+
+        if (c != '%')
+        {
+            if ((mode == JS_ENCODE_HOST || mode == JS_ENCODE_ZONE) && c < 0x80 && __url_should_escape(c, mode))
+            {
+                __buf_puts(err, "invalid character ");
+                __quote(err, __substr(s, i, i + 1));
+                __buf_puts(err, " in host name");
+                return false;
+            }
+
+            i++;
+            continue;
+        }
+
+        n++;
+
+        if (i + 2 >= s.len || !opa_ishex(s.s[i + 1]) || !opa_ishex(s.s[i + 2]))
+        {
+            __buf_puts(err, "invalid URL escape ");
+            __quote(err, __substr(s, i, s.len - i > 3 ? i + 3 : s.len));
+            return false;
+        }
+
+        js_str escape = __substr(s, i, i + 3);
+        unsigned char v = __unhex(s.s[i + 1]) << 4 | __unhex(s.s[i + 2]);
+
+        if ((mode == JS_ENCODE_HOST && __unhex(s.s[i + 1]) < 8 && !__str_eq_lit(escape, "%25")) ||
+            (mode == JS_ENCODE_ZONE && !__str_eq_lit(escape, "%25") && v != ' ' && __url_should_escape(v, JS_ENCODE_HOST)))
+        {
+            __buf_puts(err, "invalid URL escape ");
+            __quote(err, escape);
+            return false;
+        }
+
+        i += 3;
+    }
+
+    if (n == 0)
+    {
+        *out = s;
+        return true;
+    }
+
+    js_buf b = {0};
+
+    for (size_t i = 0; i < s.len; i++)
+    {
+        if (s.s[i] == '%')
+        {
+            __buf_putc(&b, __unhex(s.s[i + 1]) << 4 | __unhex(s.s[i + 2]));
+            i += 2;
+        }
+        else
+        {
+            __buf_putc(&b, s.s[i]);
+        }
+    }
+
+    *out = __buf_get(&b);
+    return true;
+}
+
+static js_str __url_escape(js_str s, int mode)
+{
+    bool escape = false;
+
+    for (size_t i = 0; i < s.len && !escape; i++)
+    {
+        escape = __url_should_escape(s.s[i], mode);
+    }
+
+    if (!escape)
+    {
+        return s;
+    }
+
+    js_buf b = {0};
+
+    for (size_t i = 0; i < s.len; i++)
+    {
+        unsigned char c = s.s[i];
+
+        if (__url_should_escape(c, mode))
+        {
+            __buf_putc(&b, '%');
+            __buf_putc(&b, __hex_upper[c >> 4]);
+            __buf_putc(&b, __hex_upper[c & 15]);
+        }
+        else
+        {
+            __buf_putc(&b, c);
+        }
+    }
+
+    return __buf_get(&b);
+}
+
+static bool __url_valid_encoded(js_str s, int mode)
+{
+    for (size_t i = 0; i < s.len; i++)
+    {
+        switch (s.s[i])
+        {
+        case '!':
+        case '$':
+        case '&':
+        case '\'':
+        case '(':
+        case ')':
+        case '*':
+        case '+':
+        case ',':
+        case ';':
+        case '=':
+        case ':':
+        case '@':
+        case '[':
+        case ']':
+        case '%':
+            break;
+        default:
+            if (__url_should_escape(s.s[i], mode))
+            {
+                return false;
+            }
+        }
+    }
+
+    return true;
+}
+
+static bool __url_set_path(js_url *u, js_str p, js_buf *err)
+{
+    if (!__url_unescape(p, JS_ENCODE_PATH, &u->path, err))
+    {
+        return false;
+    }
+
+    js_str empty = {0};
+    u->raw_path = __str_eq(__url_escape(u->path, JS_ENCODE_PATH), p) ? empty : p;
+    return true;
+}
+
+static bool __url_set_fragment(js_url *u, js_str f, js_buf *err)
+{
+    if (!__url_unescape(f, JS_ENCODE_FRAGMENT, &u->fragment, err))
+    {
+        return false;
+    }
+
+    js_str empty = {0};
+    u->raw_fragment = __str_eq(__url_escape(u->fragment, JS_ENCODE_FRAGMENT), f) ? empty : f;
+    return true;
+}
+
+static js_str __url_escaped(js_str raw, js_str s, int mode)
+{
+    if (raw.len > 0 && __url_valid_encoded(raw, mode))
+    {
+        js_buf err = {0};
+        js_str p;
+
+        if (__url_unescape(raw, mode, &p, &err) && __str_eq(p, s))
+        {
+            return raw;
+        }
+    }
+
+    if (mode == JS_ENCODE_PATH && __str_eq_lit(s, "*"))
+    {
+        return s;
+    }
+
+    return __url_escape(s, mode);
+}
+
+static js_str __url_escaped_path(js_url *u)
+{
+    return __url_escaped(u->raw_path, u->path, JS_ENCODE_PATH);
+}
+
+static bool __valid_optional_port(js_str port)
+{
+    if (port.len == 0)
+    {
+        return true;
+    }
+
+    if (port.s[0] != ':')
+    {
+        return false;
+    }
+
+    for (size_t i = 1; i < port.len; i++)
+    {
+        if (!__is_digit(port.s[i]))
+        {
+            return false;
+        }
+    }
+
+    return true;
+}
+
+#define JS_IP_INVALID (0)
+#define JS_IP_V4 (4)
+#define JS_IP_V6 (6)
+
+static bool __ip_parse_v4(js_str s, unsigned char *fields, const char **msg)
+{
+    int val = 0;
+    int pos = 0;
+    int digits = 0;
+
+    for (size_t i = 0; i < s.len; i++)
+    {
+        unsigned char c = s.s[i];
+
+        if (__is_digit(c))
+        {
+            if (digits == 1 && val == 0)
+            {
+                *msg = "IPv4 field has octet with leading zero";
+                return false;
+            }
+
+            val = val * 10 + (c - '0');
+            digits++;
+
+            if (val > 255)
+            {
+                *msg = "IPv4 field has value >255";
+                return false;
+            }
+        }
+        else if (c == '.')
+        {
+            if (i == 0 || i == s.len - 1 || s.s[i - 1] == '.')
+            {
+                *msg = "IPv4 field must have at least one digit";
+                return false;
+            }
+
+            if (pos == 3)
+            {
+                *msg = "IPv4 address too long";
+                return false;
+            }
+
+            fields[pos++] = val;
+            val = 0;
+            digits = 0;
+        }
+        else
+        {
+            *msg = "unexpected character";
+            return false;
+        }
+    }
+
+    if (pos < 3)
+    {
+        *msg = "IPv4 address too short";
+        return false;
+    }
+
+    fields[3] = val;
+    return true;
+}
+
+// netip.ParseAddr: returns the kind of the address and its 16 byte form.
+static int __ip_parse(js_str in, unsigned char *ip, bool *zone, const char **msg)
+{
+    *zone = false;
+    memset(ip, 0, 16);
+
+    int kind = JS_IP_INVALID;
+
+    for (size_t i = 0; i < in.len && kind == JS_IP_INVALID; i++)
+    {
+        switch (in.s[i])
+        {
+        case '.':
+            kind = JS_IP_V4;
+            break;
+        case ':':
+            kind = JS_IP_V6;
+            break;
+        case '%':
+            *msg = "missing IPv6 address";
+            return JS_IP_INVALID;
+        }
+    }
+
+    if (kind == JS_IP_INVALID)
+    {
+        *msg = "unable to parse IP";
+        return JS_IP_INVALID;
+    }
+
+    if (kind == JS_IP_V4)
+    {
+        ip[10] = 0xff;
+        ip[11] = 0xff;
+        return __ip_parse_v4(in, &ip[12], msg) ? JS_IP_V4 : JS_IP_INVALID;
+    }
+
+    js_str s = in;
+    int z = __str_index(s, '%');
+
+    if (z >= 0)
+    {
+        if ((size_t)z == s.len - 1)
+        {
+            *msg = "zone must be a non-empty string";
+            return JS_IP_INVALID;
+        }
+
+        s.len = z;
+        *zone = true;
+    }
+
+    int ellipsis = -1;
+    int i = 0;
+
+    if (s.len >= 2 && s.s[0] == ':' && s.s[1] == ':')
+    {
+        ellipsis = 0;
+        s = __substr(s, 2, s.len);
+
+        if (s.len == 0)
+        {
+            return JS_IP_V6;
+        }
+    }
+
+    while (i < 16)
+    {
+        size_t off = 0;
+        unsigned int acc = 0;
+
+        for (; off < s.len; off++)
+        {
+            unsigned char c = s.s[off];
+
+            if (!opa_ishex(c))
+            {
+                break;
+            }
+
+            acc = (acc << 4) + __unhex(c);
+
+            if (off > 3)
+            {
+                *msg = "each group must have 4 or less digits";
+                return JS_IP_INVALID;
+            }
+        }
+
+        if (off == 0)
+        {
+            *msg = "each colon-separated field must have at least one digit";
+            return JS_IP_INVALID;
+        }
+
+        if (off < s.len && s.s[off] == '.')
+        {
+            if (ellipsis < 0 && i != 12)
+            {
+                *msg = "embedded IPv4 address must replace the final 2 fields of the address";
+                return JS_IP_INVALID;
+            }
+
+            if (i + 4 > 16)
+            {
+                *msg = "too many hex fields to fit an embedded IPv4 at the end of the address";
+                return JS_IP_INVALID;
+            }
+
+            if (!__ip_parse_v4(s, &ip[i], msg))
+            {
+                return JS_IP_INVALID;
+            }
+
+            s.len = 0;
+            i += 4;
+            break;
+        }
+
+        ip[i] = acc >> 8;
+        ip[i + 1] = acc;
+        i += 2;
+
+        s = __substr(s, off, s.len);
+
+        if (s.len == 0)
+        {
+            break;
+        }
+
+        if (s.s[0] != ':')
+        {
+            *msg = "unexpected character, want colon";
+            return JS_IP_INVALID;
+        }
+
+        if (s.len == 1)
+        {
+            *msg = "colon must be followed by more characters";
+            return JS_IP_INVALID;
+        }
+
+        s = __substr(s, 1, s.len);
+
+        if (s.s[0] == ':')
+        {
+            if (ellipsis >= 0)
+            {
+                *msg = "multiple :: in address";
+                return JS_IP_INVALID;
+            }
+
+            ellipsis = i;
+            s = __substr(s, 1, s.len);
+
+            if (s.len == 0)
+            {
+                break;
+            }
+        }
+    }
+
+    if (s.len != 0)
+    {
+        *msg = "trailing garbage after address";
+        return JS_IP_INVALID;
+    }
+
+    if (i < 16)
+    {
+        if (ellipsis < 0)
+        {
+            *msg = "address string too short";
+            return JS_IP_INVALID;
+        }
+
+        int n = 16 - i;
+
+        for (int j = i - 1; j >= ellipsis; j--)
+        {
+            ip[j + n] = ip[j];
+        }
+
+        memset(&ip[ellipsis], 0, n);
+    }
+    else if (ellipsis >= 0)
+    {
+        *msg = "the :: must expand to at least one field of zeros";
+        return JS_IP_INVALID;
+    }
+
+    return JS_IP_V6;
+}
+
+// net.ParseIP
+static int __parse_ip(js_str s, unsigned char *ip)
+{
+    const char *msg;
+    bool zone;
+    int kind = __ip_parse(s, ip, &zone, &msg);
+    return zone ? JS_IP_INVALID : kind;
+}
+
+static bool __url_parse_host(js_str host, js_str *out, js_buf *err)
+{
+    int open = __str_last_index(host, '[');
+
+    if (open > 0)
+    {
+        __buf_puts(err, "invalid IP-literal");
+        return false;
+    }
+
+    if (open == 0)
+    {
+        int close = __str_last_index(host, ']');
+
+        if (close < 0)
+        {
+            __buf_puts(err, "missing ']' in host");
+            return false;
+        }
+
+        js_str port = __substr(host, close + 1, host.len);
+
+        if (!__valid_optional_port(port))
+        {
+            __buf_puts(err, "invalid port ");
+            __quote(err, port);
+            __buf_puts(err, " after host");
+            return false;
+        }
+
+        js_str unescaped_port;
+
+        if (!__url_unescape(port, JS_ENCODE_HOST, &unescaped_port, err))
+        {
+            return false;
+        }
+
+        js_str hostname = __substr(host, 1, close);
+        js_str unescaped;
+        int zone = -1;
+
+        for (size_t i = 0; i + 3 <= hostname.len; i++)
+        {
+            if (__str_eq_lit(__substr(hostname, i, i + 3), "%25"))
+            {
+                zone = i;
+                break;
+            }
+        }
+
+        if (zone >= 0)
+        {
+            js_str h, z;
+
+            if (!__url_unescape(__substr(hostname, 0, zone), JS_ENCODE_HOST, &h, err) ||
+                !__url_unescape(__substr(hostname, zone, hostname.len), JS_ENCODE_ZONE, &z, err))
+            {
+                return false;
+            }
+
+            js_buf b = {0};
+            __buf_str(&b, h);
+            __buf_str(&b, z);
+            unescaped = __buf_get(&b);
+        }
+        else if (!__url_unescape(hostname, JS_ENCODE_HOST, &unescaped, err))
+        {
+            return false;
+        }
+
+        unsigned char ip[16];
+        const char *msg;
+        bool has_zone;
+        int kind = __ip_parse(unescaped, ip, &has_zone, &msg);
+
+        if (kind == JS_IP_INVALID)
+        {
+            __buf_puts(err, "invalid host: ParseAddr(");
+            __quote(err, unescaped);
+            __buf_puts(err, "): ");
+            __buf_puts(err, msg);
+            return false;
+        }
+
+        if (kind == JS_IP_V4)
+        {
+            __buf_puts(err, "invalid IP-literal");
+            return false;
+        }
+
+        js_buf b = {0};
+        __buf_putc(&b, '[');
+        __buf_str(&b, unescaped);
+        __buf_putc(&b, ']');
+        __buf_str(&b, unescaped_port);
+        *out = __buf_get(&b);
+        return true;
+    }
+
+    int colon = __str_last_index(host, ':');
+
+    if (colon >= 0)
+    {
+        js_str port = __substr(host, colon, host.len);
+
+        if (!__valid_optional_port(port))
+        {
+            __buf_puts(err, "invalid port ");
+            __quote(err, port);
+            __buf_puts(err, " after host");
+            return false;
+        }
+    }
+
+    return __url_unescape(host, JS_ENCODE_HOST, out, err);
+}
+
+static bool __url_valid_userinfo(js_str s)
+{
+    for (size_t i = 0; i < s.len; i++)
+    {
+        unsigned char c = s.s[i];
+
+        if (__is_alnum(c))
+        {
+            continue;
+        }
+
+        switch (c)
+        {
+        case '-':
+        case '.':
+        case '_':
+        case ':':
+        case '~':
+        case '!':
+        case '$':
+        case '&':
+        case '\'':
+        case '(':
+        case ')':
+        case '*':
+        case '+':
+        case ',':
+        case ';':
+        case '=':
+        case '%':
+        case '@':
+            continue;
+        }
+
+        return false;
+    }
+
+    return true;
+}
+
+static bool __url_parse_authority(js_url *u, js_str authority, js_buf *err)
+{
+    int at = __str_last_index(authority, '@');
+
+    if (!__url_parse_host(__substr(authority, at + 1, authority.len), &u->host, err))
+    {
+        return false;
+    }
+
+    if (at < 0)
+    {
+        return true;
+    }
+
+    js_str userinfo = __substr(authority, 0, at);
+
+    if (!__url_valid_userinfo(userinfo))
+    {
+        __buf_puts(err, "net/url: invalid userinfo");
+        return false;
+    }
+
+    int colon = __str_index(userinfo, ':');
+
+    u->user = true;
+
+    if (colon < 0)
+    {
+        return __url_unescape(userinfo, JS_ENCODE_USER_PASSWORD, &u->username, err);
+    }
+
+    u->password_set = true;
+
+    return __url_unescape(__substr(userinfo, 0, colon), JS_ENCODE_USER_PASSWORD, &u->username, err) &&
+           __url_unescape(__substr(userinfo, colon + 1, userinfo.len), JS_ENCODE_USER_PASSWORD, &u->password, err);
+}
+
+static bool __url_parse_no_fragment(js_str raw, js_url *u, js_buf *err)
+{
+    for (size_t i = 0; i < raw.len; i++)
+    {
+        unsigned char c = raw.s[i];
+
+        if (c < ' ' || c == 0x7f)
+        {
+            __buf_puts(err, "net/url: invalid control character in URL");
+            return false;
+        }
+    }
+
+    if (__str_eq_lit(raw, "*"))
+    {
+        u->path = raw;
+        return true;
+    }
+
+    js_str rest = raw;
+
+    for (size_t i = 0; i < raw.len; i++)
+    {
+        unsigned char c = raw.s[i];
+
+        if (__is_alpha(c))
+        {
+            continue;
+        }
+
+        if (__is_digit(c) || c == '+' || c == '-' || c == '.')
+        {
+            if (i == 0)
+            {
+                break;
+            }
+
+            continue;
+        }
+
+        if (c == ':')
+        {
+            if (i == 0)
+            {
+                __buf_puts(err, "missing protocol scheme");
+                return false;
+            }
+
+            js_buf scheme = {0};
+
+            for (size_t j = 0; j < i; j++)
+            {
+                char l = raw.s[j];
+                __buf_putc(&scheme, 'A' <= l && l <= 'Z' ? l + 32 : l);
+            }
+
+            u->scheme = __buf_get(&scheme);
+            rest = __substr(raw, i + 1, raw.len);
+        }
+
+        break;
+    }
+
+    int query = __str_index(rest, '?');
+
+    if (query >= 0 && (size_t)query == rest.len - 1)
+    {
+        u->force_query = true;
+        rest.len--;
+    }
+    else if (query >= 0)
+    {
+        u->raw_query = __substr(rest, query + 1, rest.len);
+        rest.len = query;
+    }
+
+    if (!__str_prefix(rest, "/"))
+    {
+        if (u->scheme.len > 0)
+        {
+            u->opaque = rest;
+            return true;
+        }
+
+        int slash = __str_index(rest, '/');
+
+        if (__str_contains(__substr(rest, 0, slash >= 0 ? (size_t)slash : rest.len), ':'))
+        {
+            __buf_puts(err, "first path segment in URL cannot contain colon");
+            return false;
+        }
+    }
+
+    if ((u->scheme.len > 0 || !__str_prefix(rest, "///")) && __str_prefix(rest, "//"))
+    {
+        js_str authority = __substr(rest, 2, rest.len);
+        int slash = __str_index(authority, '/');
+
+        rest = __substr(authority, authority.len, authority.len);
+
+        if (slash >= 0)
+        {
+            rest = __substr(authority, slash, authority.len);
+            authority.len = slash;
+        }
+
+        if (!__url_parse_authority(u, authority, err))
+        {
+            return false;
+        }
+    }
+    else if (u->scheme.len > 0 && __str_prefix(rest, "/"))
+    {
+        u->omit_host = true;
+    }
+
+    return __url_set_path(u, rest, err);
+}
+
+// url.Parse
+static bool __url_parse(js_str raw, js_url *u, js_buf *err)
+{
+    int hash = __str_index(raw, '#');
+    js_str rest = hash >= 0 ? __substr(raw, 0, hash) : raw;
+    js_buf e = {0};
+
+    memset(u, 0, sizeof(js_url));
+    u->scheme.s = u->opaque.s = u->username.s = u->password.s = u->host.s = "";
+    u->path.s = u->raw_path.s = u->raw_query.s = u->fragment.s = u->raw_fragment.s = "";
+
+    if (!__url_parse_no_fragment(rest, u, &e))
+    {
+        __buf_puts(err, "parse ");
+        __quote(err, rest);
+        __buf_puts(err, ": ");
+        __buf_str(err, __buf_get(&e));
+        return false;
+    }
+
+    if (hash < 0 || (size_t)hash == raw.len - 1)
+    {
+        return true;
+    }
+
+    if (!__url_set_fragment(u, __substr(raw, hash + 1, raw.len), &e))
+    {
+        __buf_puts(err, "parse ");
+        __quote(err, raw);
+        __buf_puts(err, ": ");
+        __buf_str(err, __buf_get(&e));
+        return false;
+    }
+
+    return true;
+}
+
+// url.URL.String
+static js_str __url_string(js_url *u)
+{
+    js_buf b = {0};
+
+    if (u->scheme.len > 0)
+    {
+        __buf_str(&b, u->scheme);
+        __buf_putc(&b, ':');
+    }
+
+    if (u->opaque.len > 0)
+    {
+        __buf_str(&b, u->opaque);
+    }
+    else
+    {
+        bool authority = u->host.len > 0 || u->user;
+
+        if ((u->scheme.len > 0 || authority) && !(u->omit_host && !authority))
+        {
+            if (authority || u->path.len > 0)
+            {
+                __buf_puts(&b, "//");
+            }
+
+            if (u->user)
+            {
+                __buf_str(&b, __url_escape(u->username, JS_ENCODE_USER_PASSWORD));
+
+                if (u->password_set)
+                {
+                    __buf_putc(&b, ':');
+                    __buf_str(&b, __url_escape(u->password, JS_ENCODE_USER_PASSWORD));
+                }
+
+                __buf_putc(&b, '@');
+            }
+
+            __buf_str(&b, __url_escape(u->host, JS_ENCODE_HOST));
+        }
+
+        js_str path = __url_escaped_path(u);
+
+        if (u->omit_host && !authority && __str_prefix(path, "//"))
+        {
+            __buf_puts(&b, "%2F");
+            path = __substr(path, 1, path.len);
+        }
+
+        if (path.len > 0 && path.s[0] != '/' && u->host.len > 0)
+        {
+            __buf_putc(&b, '/');
+        }
+
+        if (b.len == 0)
+        {
+            int slash = __str_index(path, '/');
+
+            if (__str_contains(__substr(path, 0, slash >= 0 ? (size_t)slash : path.len), ':'))
+            {
+                __buf_puts(&b, "./");
+            }
+        }
+
+        __buf_str(&b, path);
+    }
+
+    if (u->force_query || u->raw_query.len > 0)
+    {
+        __buf_putc(&b, '?');
+        __buf_str(&b, u->raw_query);
+    }
+
+    if (u->fragment.len > 0)
+    {
+        __buf_putc(&b, '#');
+        __buf_str(&b, __url_escaped(u->raw_fragment, u->fragment, JS_ENCODE_FRAGMENT));
+    }
+
+    return __buf_get(&b);
+}
+
+static js_str __url_resolve_path(js_str base, js_str ref)
+{
+    js_buf full = {0};
+
+    if (ref.len == 0)
+    {
+        __buf_str(&full, base);
+    }
+    else if (ref.s[0] != '/')
+    {
+        __buf_str(&full, __substr(base, 0, __str_last_index(base, '/') + 1));
+        __buf_str(&full, ref);
+    }
+    else
+    {
+        __buf_str(&full, ref);
+    }
+
+    if (full.len == 0)
+    {
+        return __buf_get(&full);
+    }
+
+    js_buf dst = {0};
+    js_str remaining = __buf_get(&full);
+    js_str elem = remaining;
+    bool found = true;
+    bool first = true;
+
+    __buf_putc(&dst, '/');
+
+    while (found)
+    {
+        int slash = __str_index(remaining, '/');
+
+        found = slash >= 0;
+        elem = found ? __substr(remaining, 0, slash) : remaining;
+        remaining = found ? __substr(remaining, slash + 1, remaining.len) : __substr(remaining, remaining.len, remaining.len);
+
+        if (__str_eq_lit(elem, "."))
+        {
+            first = false;
+            continue;
+        }
+
+        if (__str_eq_lit(elem, ".."))
+        {
+            int i = __str_last_index(__substr(__buf_get(&dst), 1, dst.len), '/');
+            dst.len = i >= 0 ? i + 1 : 1;
+            first = dst.len == 1;
+            continue;
+        }
+
+        if (!first)
+        {
+            __buf_putc(&dst, '/');
+        }
+
+        __buf_str(&dst, elem);
+        first = false;
+    }
+
+    if (__str_eq_lit(elem, ".") || __str_eq_lit(elem, ".."))
+    {
+        __buf_putc(&dst, '/');
+    }
+
+    js_str r = __buf_get(&dst);
+
+    if (r.len > 1 && r.s[1] == '/')
+    {
+        return __substr(r, 1, r.len);
+    }
+
+    return r;
+}
+
+// url.URL.ResolveReference
+static void __url_resolve(js_url *u, js_url *ref, js_url *out)
+{
+    js_buf err = {0};
+    js_str empty = {0};
+
+    *out = *ref;
+
+    if (ref->scheme.len == 0)
+    {
+        out->scheme = u->scheme;
+    }
+
+    if (ref->scheme.len > 0 || ref->host.len > 0 || ref->user)
+    {
+        __url_set_path(out, __url_resolve_path(__url_escaped_path(ref), empty), &err);
+        return;
+    }
+
+    if (ref->opaque.len > 0)
+    {
+        out->user = false;
+        out->host = empty;
+        out->path = empty;
+        return;
+    }
+
+    if (ref->path.len == 0 && !ref->force_query && ref->raw_query.len == 0)
+    {
+        out->raw_query = u->raw_query;
+
+        if (ref->fragment.len == 0)
+        {
+            out->fragment = u->fragment;
+            out->raw_fragment = u->raw_fragment;
+        }
+    }
+
+    if (ref->path.len == 0 && u->opaque.len > 0)
+    {
+        out->opaque = u->opaque;
+        out->user = false;
+        out->host = empty;
+        out->path = empty;
+        return;
+    }
+
+    out->host = u->host;
+    out->user = u->user;
+    out->username = u->username;
+    out->password = u->password;
+    out->password_set = u->password_set;
+    __url_set_path(out, __url_resolve_path(__url_escaped_path(u), __url_escaped_path(ref)), &err);
+}
+
+// gojsonreference.JsonReference.Inherits
+static bool __ref_inherits(js_url *parent, js_url *child, js_url *out, js_buf *err)
+{
+    js_url p = *parent;
+    js_url r;
+
+    p.fragment.len = 0;
+    __url_resolve(&p, child, &r);
+
+    return __url_parse(__url_string(&r), out, err);
+}
+
+static bool __ref_canonical(js_url *u)
+{
+    if (__str_eq_lit(u->scheme, "file"))
+    {
+        return __str_prefix(u->path, "/");
+    }
+
+    return u->scheme.len > 0 && u->host.len > 0;
+}
+
+// strconv.Atoi
+static bool __atoi(js_str s, long long *out)
+{
+    size_t i = 0;
+    bool neg = false;
+    unsigned long long n = 0;
+
+    if (s.len > 0 && (s.s[0] == '-' || s.s[0] == '+'))
+    {
+        neg = s.s[i++] == '-';
+    }
+
+    if (i == s.len)
+    {
+        return false;
+    }
+
+    for (; i < s.len; i++)
+    {
+        if (!__is_digit(s.s[i]))
+        {
+            return false;
+        }
+
+        n = n * 10 + (s.s[i] - '0');
+
+        if (n > 0x8000000000000000ULL)
+        {
+            return false;
+        }
+    }
+
+    if (!neg && n == 0x8000000000000000ULL)
+    {
+        return false;
+    }
+
+    *out = neg ? -(long long)n : (long long)n;
+    return true;
+}
+
+// gojsonpointer.JsonPointer.Get for the fragment of a reference.
+static opa_value *__pointer_get(opa_value *doc, js_str pointer, js_buf *err)
+{
+    if (pointer.len == 0 || pointer.s[0] != '/')
+    {
+        return doc;
+    }
+
+    opa_value *node = doc;
+    js_str remaining = __substr(pointer, 1, pointer.len);
+    bool found = true;
+
+    while (found)
+    {
+        int slash = __str_index(remaining, '/');
+        js_str token = slash >= 0 ? __substr(remaining, 0, slash) : remaining;
+
+        found = slash >= 0;
+        remaining = found ? __substr(remaining, slash + 1, remaining.len) : remaining;
+
+        switch (opa_value_type(node))
+        {
+        case OPA_OBJECT:
+        {
+            js_buf step = {0};
+            js_buf decoded = {0};
+
+            for (size_t i = 0; i < token.len; i++)
+            {
+                if (token.s[i] == '~' && i + 1 < token.len && token.s[i + 1] == '1')
+                {
+                    __buf_putc(&step, '/');
+                    i++;
+                }
+                else
+                {
+                    __buf_putc(&step, token.s[i]);
+                }
+            }
+
+            for (size_t i = 0; i < step.len; i++)
+            {
+                if (step.s[i] == '~' && i + 1 < step.len && step.s[i + 1] == '0')
+                {
+                    __buf_putc(&decoded, '~');
+                    i++;
+                }
+                else
+                {
+                    __buf_putc(&decoded, step.s[i]);
+                }
+            }
+
+            opa_value *next = opa_value_get(node, __string(__buf_get(&decoded)));
+
+            if (next == NULL)
+            {
+                __buf_puts(err, "Object has no key '");
+                __buf_str(err, __buf_get(&decoded));
+                __buf_putc(err, '\'');
+                return NULL;
+            }
+
+            node = next;
+            break;
+        }
+        case OPA_ARRAY:
+        {
+            opa_array_t *arr = opa_cast_array(node);
+            long long index;
+
+            if (!__atoi(token, &index))
+            {
+                __buf_puts(err, "Invalid array index '");
+                __buf_str(err, token);
+                __buf_putc(err, '\'');
+                return NULL;
+            }
+
+            if (index < 0 || (unsigned long long)index >= arr->len)
+            {
+                __buf_puts(err, "Out of bound array[0,");
+                __buf_int(err, arr->len);
+                __buf_puts(err, "] index '");
+                __buf_int(err, index);
+                __buf_putc(err, '\'');
+                return NULL;
+            }
+
+            node = arr->elems[index].v;
+            break;
+        }
+        default:
+            __buf_puts(err, "Invalid token reference '");
+            __buf_str(err, token);
+            __buf_putc(err, '\'');
+            return NULL;
+        }
+    }
+
+    return node;
+}
+
+typedef struct js_schema js_schema;
+
+// Entry of the document pool (documents by reference) and of the reference
+// pool (compiled schemas by reference).
+typedef struct js_entry js_entry;
+
+struct js_entry
+{
+    js_str key;
+    opa_value *doc;
+    int draft;
+    js_schema *schema;
+    js_entry *next;
+};
+
+typedef struct
+{
+    js_str key;
+    opa_value *keys;
+    js_schema *schema;
+} js_dependency;
+
+struct js_schema
+{
+    int draft;
+    js_schema *parent;
+    js_str property;
+    opa_value *pattern;
+    js_url *id;
+    js_url *ref;
+    js_schema *ref_schema;
+    int pass;
+    int types[8];
+    int ntypes;
+    js_vec properties;
+    int additional_properties;
+    js_schema *additional_properties_schema;
+    js_vec pattern_properties;
+    js_schema *property_names;
+    js_vec dependencies;
+    js_vec items;
+    bool items_single;
+    int additional_items;
+    js_schema *additional_items_schema;
+    opa_value *multiple_of;
+    opa_value *maximum;
+    opa_value *exclusive_maximum;
+    opa_value *minimum;
+    opa_value *exclusive_minimum;
+    long long min_length;
+    long long max_length;
+    long long min_properties;
+    long long max_properties;
+    long long min_items;
+    long long max_items;
+    js_str format;
+    js_vec required;
+    bool unique_items;
+    js_schema *contains;
+    bool has_const;
+    js_str constant;
+    js_vec enums;
+    js_vec one_of;
+    js_vec any_of;
+    js_vec all_of;
+    js_schema *not_schema;
+    js_schema *if_schema;
+    js_schema *then_schema;
+    js_schema *else_schema;
+};
+
+typedef struct
+{
+    js_entry *documents;
+    js_entry *references;
+    js_url root;
+    js_schema *root_schema;
+    js_buf err;
+    int depth;
+    bool abort;
+} js_compiler;
+
+static js_entry *__pool_get(js_entry *pool, js_str key)
+{
+    for (; pool != NULL; pool = pool->next)
+    {
+        if (__str_eq(pool->key, key))
+        {
+            return pool;
+        }
+    }
+
+    return NULL;
+}
+
+static js_entry *__pool_add(js_entry **pool, js_str key)
+{
+    js_entry *e = opa_malloc(sizeof(js_entry));
+    memset(e, 0, sizeof(js_entry));
+    e->key = key;
+    e->next = *pool;
+    *pool = e;
+    return e;
+}
+
+static void __reference_exists(js_compiler *c, js_str key)
+{
+    __buf_puts(&c->err, "Reference already exists: \"");
+    __buf_str(&c->err, key);
+    __buf_putc(&c->err, '"');
+}
+
+static bool __is_schema(opa_value *v)
+{
+    int t = opa_value_type(v);
+    return t == OPA_BOOLEAN || t == OPA_OBJECT;
+}
+
+static bool __parse_schema_url(js_compiler *c, opa_value *doc, int *draft)
+{
+    *draft = 0;
+
+    int t = opa_value_type(doc);
+
+    if (t == OPA_BOOLEAN)
+    {
+        return true;
+    }
+
+    if (t != OPA_OBJECT)
+    {
+        __buf_puts(&c->err, "schema is invalid");
+        return false;
+    }
+
+    opa_value *v = __get(doc, "$schema");
+
+    if (v == NULL)
+    {
+        return true;
+    }
+
+    if (opa_value_type(v) != OPA_STRING)
+    {
+        __buf_puts(&c->err, "$schema must be of type string");
+        return false;
+    }
+
+    js_url u;
+
+    if (!__url_parse(__str_value(v), &u, &c->err))
+    {
+        return false;
+    }
+
+    js_str s = __url_string(&u);
+
+    if (__str_eq_lit(s, "http://json-schema.org/draft-04/schema"))
+    {
+        *draft = JS_DRAFT4;
+    }
+    else if (__str_eq_lit(s, "http://json-schema.org/draft-06/schema"))
+    {
+        *draft = JS_DRAFT6;
+    }
+    else if (__str_eq_lit(s, "http://json-schema.org/draft-07/schema"))
+    {
+        *draft = JS_DRAFT7;
+    }
+
+    return true;
+}
+
+// Registers the document if it is identified by an id, and makes its
+// reference absolute. Returns the base URL of the document's children. Kept
+// out of line so that the recursion does not carry the URLs on the stack.
+__attribute__((noinline))
+static js_url *__parse_ids(js_compiler *c, opa_value *doc, js_url *ref, int draft, bool *ok)
+{
+    js_url *local = ref;
+    js_buf err = {0};
+    js_url u;
+    opa_value *id = __get(doc, "id");
+
+    if (id == NULL)
+    {
+        id = __get(doc, "$id");
+    }
+
+    if (id != NULL && opa_value_type(id) == OPA_STRING && __url_parse(__str_value(id), &u, &err))
+    {
+        js_url *inherited = opa_malloc(sizeof(js_url));
+
+        if (__ref_inherits(ref, &u, inherited, &err))
+        {
+            js_str key = __url_string(inherited);
+
+            if (__pool_get(c->documents, key) != NULL)
+            {
+                __reference_exists(c, key);
+                *ok = false;
+                return ref;
+            }
+
+            js_entry *e = __pool_add(&c->documents, key);
+            e->doc = doc;
+            e->draft = draft;
+            local = inherited;
+        }
+    }
+
+    opa_value *r = __get(doc, "$ref");
+
+    if (r != NULL && opa_value_type(r) == OPA_STRING && __url_parse(__str_value(r), &u, &err))
+    {
+        js_url absolute;
+
+        if (__ref_inherits(local, &u, &absolute, &err))
+        {
+            opa_object_insert(opa_cast_object(doc), opa_string_terminated("$ref"), __string(__url_string(&absolute)));
+        }
+    }
+
+    return local;
+}
+
+// Registers the documents identified by an id, and makes the references of
+// the document absolute.
+static bool __parse_references_recursive(js_compiler *c, opa_value *doc, js_url *ref, int draft)
+{
+    if (++c->depth > JS_MAX_DEPTH)
+    {
+        c->abort = true;
+        return false;
+    }
+
+    bool ok = true;
+
+    switch (opa_value_type(doc))
+    {
+    case OPA_ARRAY:
+    {
+        opa_array_t *arr = opa_cast_array(doc);
+
+        for (size_t i = 0; i < arr->len && ok; i++)
+        {
+            ok = __parse_references_recursive(c, arr->elems[i].v, ref, draft);
+        }
+
+        break;
+    }
+    case OPA_OBJECT:
+    {
+        js_url *local = __parse_ids(c, doc, ref, draft, &ok);
+
+        if (!ok)
+        {
+            break;
+        }
+
+        opa_array_t *keys = opa_object_keys(opa_cast_object(doc));
+
+        for (size_t i = 0; i < keys->len && ok; i++)
+        {
+            js_str k = __str_value(keys->elems[i].v);
+            opa_value *v = opa_value_get(doc, keys->elems[i].v);
+
+            if (__str_eq_lit(k, "const") || __str_eq_lit(k, "enum"))
+            {
+                continue;
+            }
+
+            if (__str_eq_lit(k, "properties") || __str_eq_lit(k, "dependencies") || __str_eq_lit(k, "patternProperties"))
+            {
+                if (opa_value_type(v) == OPA_OBJECT)
+                {
+                    opa_array_t *children = opa_object_keys(opa_cast_object(v));
+
+                    for (size_t j = 0; j < children->len && ok; j++)
+                    {
+                        ok = __parse_references_recursive(c, opa_value_get(v, children->elems[j].v), local, draft);
+                    }
+                }
+
+                continue;
+            }
+
+            ok = __parse_references_recursive(c, v, local, draft);
+        }
+
+        break;
+    }
+    }
+
+    c->depth--;
+    return ok;
+}
+
+static js_schema *__schema_new(js_schema *parent, js_str property)
+{
+    js_schema *s = opa_malloc(sizeof(js_schema));
+    memset(s, 0, sizeof(js_schema));
+    s->parent = parent;
+    s->property = property;
+    s->pass = JS_UNSET;
+    s->additional_properties = JS_UNSET;
+    s->additional_items = JS_UNSET;
+    s->min_length = JS_UNSET;
+    s->max_length = JS_UNSET;
+    s->min_properties = JS_UNSET;
+    s->max_properties = JS_UNSET;
+    s->min_items = JS_UNSET;
+    s->max_items = JS_UNSET;
+
+    if (parent != NULL)
+    {
+        s->ref = parent->ref;
+    }
+
+    return s;
+}
+
+static bool __invalid_type(js_compiler *c, const char *expected, const char *given)
+{
+    __buf_puts(&c->err, "Invalid type. Expected: ");
+    __buf_puts(&c->err, expected);
+    __buf_puts(&c->err, ", given: ");
+    __buf_puts(&c->err, given);
+    return false;
+}
+
+static bool __fail(js_compiler *c, const char *msg)
+{
+    __buf_puts(&c->err, msg);
+    return false;
+}
+
+// Returns 1 if the keyword is a string, 0 if it is absent and -1 otherwise.
+static int __get_string(js_compiler *c, opa_value *doc, const char *key, js_str *out)
+{
+    opa_value *v = __get(doc, key);
+
+    if (v == NULL)
+    {
+        return 0;
+    }
+
+    if (opa_value_type(v) != OPA_STRING)
+    {
+        __invalid_type(c, "string", key);
+        return -1;
+    }
+
+    *out = __str_value(v);
+    return 1;
+}
+
+// Parses a non-negative integer keyword. Returns false on error.
+static bool __get_integer(js_compiler *c, opa_value *doc, const char *key, long long *out)
+{
+    opa_value *v = __get(doc, key);
+
+    if (v == NULL)
+    {
+        return true;
+    }
+
+    long long i;
+    bool ok = opa_value_type(v) == OPA_NUMBER;
+
+    if (ok)
+    {
+        char tmp[24];
+        ok = __atoi(__number_literal(v, tmp), &i);
+    }
+
+    if (!ok)
+    {
+        __buf_puts(&c->err, key);
+        __buf_puts(&c->err, " must be of an integer");
+        return false;
+    }
+
+    if (i < 0)
+    {
+        __buf_puts(&c->err, key);
+        __buf_puts(&c->err, " must be greater than or equal to 0");
+        return false;
+    }
+
+    *out = i;
+    return true;
+}
+
+static bool __check_range(js_compiler *c, long long min, long long max, const char *kmin, const char *kmax)
+{
+    if (min != JS_UNSET && max != JS_UNSET && min > max)
+    {
+        __buf_puts(&c->err, kmin);
+        __buf_puts(&c->err, " cannot be greater than ");
+        __buf_puts(&c->err, kmax);
+        return false;
+    }
+
+    return true;
+}
+
+static bool __positive(opa_value *v)
+{
+    char tmp[24];
+    js_str s = __number_literal(v, tmp);
+
+    if (s.len > 0 && s.s[0] == '-')
+    {
+        return false;
+    }
+
+    for (size_t i = 0; i < s.len; i++)
+    {
+        if (s.s[i] == 'e' || s.s[i] == 'E')
+        {
+            break;
+        }
+
+        if ('1' <= s.s[i] && s.s[i] <= '9')
+        {
+            return true;
+        }
+    }
+
+    return false;
+}
+
+static const char __type_names[7][8] = {"array", "boolean", "integer", "null", "number", "object", "string"};
+
+static const char *__type_name(int t)
+{
+    return __type_names[t];
+}
+
+#define JS_TYPE_ARRAY (0)
+#define JS_TYPE_BOOLEAN (1)
+#define JS_TYPE_INTEGER (2)
+#define JS_TYPE_NULL (3)
+#define JS_TYPE_NUMBER (4)
+#define JS_TYPE_OBJECT (5)
+#define JS_TYPE_STRING (6)
+
+static bool __add_type(js_compiler *c, js_schema *s, js_str name)
+{
+    int t = -1;
+
+    for (int i = JS_TYPE_ARRAY; i <= JS_TYPE_STRING; i++)
+    {
+        if (__str_eq_lit(name, __type_name(i)))
+        {
+            t = i;
+        }
+    }
+
+    if (t < 0)
+    {
+        __buf_puts(&c->err, "has a primitive type that is NOT VALID -- given: /");
+        __buf_str(&c->err, name);
+        __buf_puts(&c->err, "/ Expected valid values are:[array boolean integer number null object string]");
+        return false;
+    }
+
+    for (int i = 0; i < s->ntypes; i++)
+    {
+        if (s->types[i] == t)
+        {
+            __buf_str(&c->err, name);
+            __buf_puts(&c->err, " type is duplicated");
+            return false;
+        }
+    }
+
+    s->types[s->ntypes++] = t;
+    return true;
+}
+
+static bool __parse_schema(js_compiler *c, opa_value *doc, js_schema *s);
+
+static bool __parse_child(js_compiler *c, opa_value *doc, js_schema *parent, const char *property, js_schema **out)
+{
+    *out = __schema_new(parent, __str(property));
+    return __parse_schema(c, doc, *out);
+}
+
+// Parses an optional boolean or schema keyword into *flag and *out.
+static bool __parse_bool_or_schema(js_compiler *c, opa_value *doc, js_schema *s, const char *key, int *flag, js_schema **out, const char *expected, const char *given)
+{
+    opa_value *v = __get(doc, key);
+
+    if (v == NULL)
+    {
+        return true;
+    }
+
+    switch (opa_value_type(v))
+    {
+    case OPA_BOOLEAN:
+        *flag = opa_cast_boolean(v)->v ? JS_TRUE : JS_FALSE;
+        return true;
+    case OPA_OBJECT:
+        *flag = JS_SCHEMA;
+        return __parse_child(c, v, s, key, out);
+    }
+
+    return __invalid_type(c, expected, given);
+}
+
+// Parses an optional keyword that has to be a boolean or a schema.
+static bool __parse_optional_schema(js_compiler *c, opa_value *doc, js_schema *s, const char *key, js_schema **out, const char *msg)
+{
+    opa_value *v = __get(doc, key);
+
+    if (v == NULL)
+    {
+        return true;
+    }
+
+    if (!__is_schema(v))
+    {
+        return __fail(c, msg);
+    }
+
+    return __parse_child(c, v, s, key, out);
+}
+
+static bool __parse_schemas(js_compiler *c, opa_value *doc, js_schema *s, const char *key, js_vec *out)
+{
+    opa_value *v = __get(doc, key);
+
+    if (v == NULL)
+    {
+        return true;
+    }
+
+    if (opa_value_type(v) != OPA_ARRAY)
+    {
+        __buf_puts(&c->err, key);
+        __buf_puts(&c->err, " must be of an array");
+        return false;
+    }
+
+    opa_array_t *arr = opa_cast_array(v);
+
+    for (size_t i = 0; i < arr->len; i++)
+    {
+        js_schema *child;
+
+        if (!__parse_child(c, arr->elems[i].v, s, key, &child))
+        {
+            return false;
+        }
+
+        __vec_append(out, child);
+    }
+
+    return true;
+}
+
+static bool __get_number(js_compiler *c, opa_value *doc, const char *key, opa_value **out)
+{
+    opa_value *v = __get(doc, key);
+
+    if (v == NULL)
+    {
+        return true;
+    }
+
+    if (opa_value_type(v) != OPA_NUMBER)
+    {
+        __buf_puts(&c->err, key);
+        __buf_puts(&c->err, " must be of a Number");
+        return false;
+    }
+
+    *out = v;
+    return true;
+}
+
+static bool __parse_exclusive(js_compiler *c, opa_value *doc, js_schema *s, const char *key, const char *bound, opa_value **inclusive, opa_value **exclusive)
+{
+    opa_value *v = __get(doc, key);
+
+    if (v == NULL)
+    {
+        return true;
+    }
+
+    int t = opa_value_type(v);
+
+    if ((s->draft == JS_DRAFT4 || s->draft == JS_HYBRID) && t == OPA_BOOLEAN)
+    {
+        if (*inclusive == NULL)
+        {
+            __buf_puts(&c->err, key);
+            __buf_puts(&c->err, " cannot be used without ");
+            __buf_puts(&c->err, bound);
+            return false;
+        }
+
+        if (opa_cast_boolean(v)->v)
+        {
+            *exclusive = *inclusive;
+            *inclusive = NULL;
+        }
+
+        return true;
+    }
+
+    if (s->draft != JS_DRAFT4 && t == OPA_NUMBER)
+    {
+        *exclusive = v;
+        return true;
+    }
+
+    switch (s->draft)
+    {
+    case JS_DRAFT4:
+        return __invalid_type(c, "boolean", key);
+    case JS_HYBRID:
+        return __invalid_type(c, "boolean/number", key);
+    }
+
+    return __invalid_type(c, "number", key);
+}
+
+static bool __parse_reference(js_compiler *c, js_schema *s);
+
+// gojsonschema.Schema.parseSchema
+static bool __parse_schema(js_compiler *c, opa_value *doc, js_schema *s)
+{
+    if (++c->depth > JS_MAX_DEPTH)
+    {
+        c->abort = true;
+        return false;
+    }
+
+    bool ok = true;
+
+    if (s->draft == 0)
+    {
+        s->draft = s->parent->draft;
+    }
+
+    if (s->draft >= JS_DRAFT6 && opa_value_type(doc) == OPA_BOOLEAN)
+    {
+        s->pass = opa_cast_boolean(doc)->v ? JS_TRUE : JS_FALSE;
+        c->depth--;
+        return true;
+    }
+
+    if (opa_value_type(doc) != OPA_OBJECT)
+    {
+        c->depth--;
+        return __fail(c, "Expected: Valid Schema, given: Invalid JSON");
+    }
+
+    if (s->parent == NULL)
+    {
+        s->ref = &c->root;
+        s->id = &c->root;
+    }
+
+    if (s->id == NULL && s->parent != NULL)
+    {
+        s->id = s->parent->id;
+    }
+
+#define CHECK(expr)   \
+    if (!(expr))      \
+    {                 \
+        ok = false;   \
+        goto done;    \
+    }
+
+    const char *key_id = "$id";
+
+    if (s->draft == JS_DRAFT4 || (s->draft == JS_HYBRID && __get(doc, "id") != NULL))
+    {
+        key_id = "id";
+    }
+
+    js_str str;
+    int rc = __get_string(c, doc, key_id, &str);
+    CHECK(rc >= 0);
+
+    if (rc > 0)
+    {
+        js_url *id = opa_malloc(sizeof(js_url));
+        CHECK(__url_parse(str, id, &c->err));
+
+        if (s == c->root_schema)
+        {
+            s->id = id;
+        }
+        else
+        {
+            js_url *inherited = opa_malloc(sizeof(js_url));
+            CHECK(__ref_inherits(s->parent->id, id, inherited, &c->err));
+            s->id = inherited;
+        }
+    }
+
+    opa_value *v = __get(doc, "definitions");
+
+    if (v != NULL)
+    {
+        CHECK(opa_value_type(v) == OPA_OBJECT || __invalid_type(c, "Array Of Schemas", "definitions"));
+
+        opa_array_t *keys = opa_object_keys(opa_cast_object(v));
+
+        for (size_t i = 0; i < keys->len; i++)
+        {
+            opa_value *d = opa_value_get(v, keys->elems[i].v);
+            js_schema *child;
+
+            CHECK(__is_schema(d) || __invalid_type(c, "Array Of Schemas", "definitions"));
+            CHECK(__parse_child(c, d, s, "definitions", &child));
+        }
+    }
+
+    CHECK(__get_string(c, doc, "title", &str) >= 0);
+    CHECK(__get_string(c, doc, "description", &str) >= 0);
+
+    rc = __get_string(c, doc, "$ref", &str);
+    CHECK(rc >= 0);
+
+    if (rc > 0)
+    {
+        js_url *ref = opa_malloc(sizeof(js_url));
+        CHECK(__url_parse(str, ref, &c->err));
+        s->ref = ref;
+
+        js_entry *e = __pool_get(c->references, __url_string(ref));
+
+        if (e == NULL)
+        {
+            ok = __parse_reference(c, s);
+            goto done;
+        }
+
+        s->ref_schema = e->schema;
+    }
+
+    v = __get(doc, "type");
+
+    if (v != NULL)
+    {
+        if (opa_value_type(v) == OPA_STRING)
+        {
+            CHECK(__add_type(c, s, __str_value(v)));
+        }
+        else
+        {
+            CHECK(opa_value_type(v) == OPA_ARRAY || __invalid_type(c, "type", "string/Array Of Strings"));
+
+            opa_array_t *arr = opa_cast_array(v);
+
+            for (size_t i = 0; i < arr->len; i++)
+            {
+                CHECK(opa_value_type(arr->elems[i].v) == OPA_STRING || __invalid_type(c, "type", "string/Array Of Strings"));
+                CHECK(__add_type(c, s, __str_value(arr->elems[i].v)));
+            }
+        }
+    }
+
+    v = __get(doc, "properties");
+
+    if (v != NULL)
+    {
+        CHECK(opa_value_type(v) == OPA_OBJECT || __fail(c, "Properties must be of type object"));
+
+        opa_array_t *keys = opa_object_keys(opa_cast_object(v));
+
+        for (size_t i = 0; i < keys->len; i++)
+        {
+            js_schema *child = __schema_new(s, __str_value(keys->elems[i].v));
+            __vec_append(&s->properties, child);
+            CHECK(__parse_schema(c, opa_value_get(v, keys->elems[i].v), child));
+        }
+    }
+
+    CHECK(__parse_bool_or_schema(c, doc, s, "additionalProperties", &s->additional_properties, &s->additional_properties_schema,
+                                 "boolean/Valid Schema", "additionalProperties"));
+
+    v = __get(doc, "patternProperties");
+
+    if (v != NULL)
+    {
+        CHECK(opa_value_type(v) == OPA_OBJECT || __invalid_type(c, "Valid Schema", "patternProperties"));
+
+        opa_array_t *keys = opa_object_keys(opa_cast_object(v));
+
+        for (size_t i = 0; i < keys->len; i++)
+        {
+            opa_value *pattern = keys->elems[i].v;
+            opa_value *valid = opa_regex_is_valid(pattern);
+
+            if (valid == NULL || !opa_cast_boolean(valid)->v)
+            {
+                __buf_puts(&c->err, "Invalid regex pattern '");
+                __buf_str(&c->err, __str_value(pattern));
+                __buf_putc(&c->err, '\'');
+                CHECK(false);
+            }
+
+            js_schema *child = __schema_new(s, __str_value(pattern));
+            child->pattern = pattern;
+            __vec_append(&s->pattern_properties, child);
+            CHECK(__parse_schema(c, opa_value_get(v, pattern), child));
+        }
+    }
+
+    if (s->draft >= JS_DRAFT6)
+    {
+        CHECK(__parse_optional_schema(c, doc, s, "propertyNames", &s->property_names,
+                                      "Invalid type. Expected: Valid Schema, given: patternProperties"));
+    }
+
+    v = __get(doc, "dependencies");
+
+    if (v != NULL)
+    {
+        CHECK(opa_value_type(v) == OPA_OBJECT || __fail(c, "dependencies must be of type object"));
+
+        opa_array_t *keys = opa_object_keys(opa_cast_object(v));
+
+        for (size_t i = 0; i < keys->len; i++)
+        {
+            opa_value *d = opa_value_get(v, keys->elems[i].v);
+            js_dependency *dep = opa_malloc(sizeof(js_dependency));
+
+            dep->key = __str_value(keys->elems[i].v);
+            dep->keys = NULL;
+            dep->schema = NULL;
+
+            if (opa_value_type(d) == OPA_ARRAY)
+            {
+                opa_array_t *arr = opa_cast_array(d);
+
+                for (size_t j = 0; j < arr->len; j++)
+                {
+                    CHECK(opa_value_type(arr->elems[j].v) == OPA_STRING || __fail(c, "Dependency must be of type Schema Or Array Of Strings"));
+                }
+
+                if (arr->len > 0)
+                {
+                    dep->keys = d;
+                    __vec_append(&s->dependencies, dep);
+                }
+
+                continue;
+            }
+
+            CHECK(__is_schema(d) || __fail(c, "Dependency must be of type Schema Or Array Of Strings"));
+
+            dep->schema = __schema_new(s, dep->key);
+            __vec_append(&s->dependencies, dep);
+            CHECK(__parse_schema(c, d, dep->schema));
+        }
+    }
+
+    v = __get(doc, "items");
+
+    if (v != NULL)
+    {
+        if (opa_value_type(v) == OPA_ARRAY)
+        {
+            opa_array_t *arr = opa_cast_array(v);
+
+            for (size_t i = 0; i < arr->len; i++)
+            {
+                js_schema *child;
+
+                CHECK(__is_schema(arr->elems[i].v) || __invalid_type(c, "Valid Schema/Array Of Schemas", "items"));
+                CHECK(__parse_child(c, arr->elems[i].v, s, "items", &child));
+                __vec_append(&s->items, child);
+            }
+        }
+        else
+        {
+            js_schema *child;
+
+            CHECK(__is_schema(v) || __invalid_type(c, "Valid Schema/Array Of Schemas", "items"));
+            CHECK(__parse_child(c, v, s, "items", &child));
+            __vec_append(&s->items, child);
+            s->items_single = true;
+        }
+    }
+
+    CHECK(__parse_bool_or_schema(c, doc, s, "additionalItems", &s->additional_items, &s->additional_items_schema,
+                                 "boolean/Valid Schema", "additionalItems"));
+
+    v = __get(doc, "multipleOf");
+
+    if (v != NULL)
+    {
+        CHECK(opa_value_type(v) == OPA_NUMBER || __invalid_type(c, "Number", "multipleOf"));
+        CHECK(__positive(v) || __fail(c, "multipleOf must be strictly greater than 0"));
+        s->multiple_of = v;
+    }
+
+    CHECK(__get_number(c, doc, "minimum", &s->minimum));
+    CHECK(__parse_exclusive(c, doc, s, "exclusiveMinimum", "minimum", &s->minimum, &s->exclusive_minimum));
+    CHECK(__get_number(c, doc, "maximum", &s->maximum));
+    CHECK(__parse_exclusive(c, doc, s, "exclusiveMaximum", "maximum", &s->maximum, &s->exclusive_maximum));
+
+    CHECK(__get_integer(c, doc, "minLength", &s->min_length));
+    CHECK(__get_integer(c, doc, "maxLength", &s->max_length));
+    CHECK(__check_range(c, s->min_length, s->max_length, "minLength", "maxLength"));
+
+    CHECK(__get_string(c, doc, "pattern", &str) >= 0);
+
+    rc = __get_string(c, doc, "format", &str);
+    CHECK(rc >= 0);
+
+    if (rc > 0)
+    {
+        s->format = str;
+    }
+
+    CHECK(__get_integer(c, doc, "minProperties", &s->min_properties));
+    CHECK(__get_integer(c, doc, "maxProperties", &s->max_properties));
+    CHECK(__check_range(c, s->min_properties, s->max_properties, "minProperties", "maxProperties"));
+
+    v = __get(doc, "required");
+
+    if (v != NULL)
+    {
+        CHECK(opa_value_type(v) == OPA_ARRAY || __fail(c, "required must be of an array"));
+
+        opa_array_t *arr = opa_cast_array(v);
+
+        for (size_t i = 0; i < arr->len; i++)
+        {
+            opa_value *r = arr->elems[i].v;
+
+            CHECK(opa_value_type(r) == OPA_STRING || __invalid_type(c, "string", "required"));
+
+            for (size_t j = 0; j < s->required.len; j++)
+            {
+                CHECK(opa_value_compare(s->required.elems[j], r) != 0 || __fail(c, "required items must be unique"));
+            }
+
+            __vec_append(&s->required, r);
+        }
+    }
+
+    CHECK(__get_integer(c, doc, "minItems", &s->min_items));
+    CHECK(__get_integer(c, doc, "maxItems", &s->max_items));
+
+    v = __get(doc, "uniqueItems");
+
+    if (v != NULL)
+    {
+        CHECK(opa_value_type(v) == OPA_BOOLEAN || __fail(c, "uniqueItems must be of a boolean"));
+        s->unique_items = opa_cast_boolean(v)->v;
+    }
+
+    if (s->draft >= JS_DRAFT6)
+    {
+        v = __get(doc, "contains");
+
+        if (v != NULL)
+        {
+            CHECK(__parse_child(c, v, s, "contains", &s->contains));
+        }
+
+        v = __get(doc, "const");
+
+        if (v != NULL)
+        {
+            js_buf b = {0};
+            opa_value *bad = __marshal(&b, v, false);
+
+            if (bad != NULL)
+            {
+                char tmp[24];
+                __buf_puts(&c->err, "json: cannot unmarshal number ");
+                __buf_str(&c->err, __number_literal(bad, tmp));
+                __buf_puts(&c->err, " into Go value of type float64");
+                CHECK(false);
+            }
+
+            s->has_const = true;
+            s->constant = __buf_get(&b);
+        }
+    }
+
+    v = __get(doc, "enum");
+
+    if (v != NULL)
+    {
+        CHECK(opa_value_type(v) == OPA_ARRAY || __fail(c, "enum must be of an array"));
+
+        opa_array_t *arr = opa_cast_array(v);
+
+        for (size_t i = 0; i < arr->len; i++)
+        {
+            js_buf b = {0};
+            opa_value *bad = __marshal(&b, arr->elems[i].v, false);
+
+            if (bad != NULL)
+            {
+                char tmp[24];
+                __buf_puts(&c->err, "json: cannot unmarshal number ");
+                __buf_str(&c->err, __number_literal(bad, tmp));
+                __buf_puts(&c->err, " into Go value of type float64");
+                CHECK(false);
+            }
+
+            opa_value *e = opa_string_allocated(b.s != NULL ? b.s : "", b.len);
+
+            for (size_t j = 0; j < s->enums.len; j++)
+            {
+                CHECK(opa_value_compare(s->enums.elems[j], e) != 0 || __fail(c, "enum items must be unique"));
+            }
+
+            __vec_append(&s->enums, e);
+        }
+    }
+
+    CHECK(__parse_schemas(c, doc, s, "oneOf", &s->one_of));
+    CHECK(__parse_schemas(c, doc, s, "anyOf", &s->any_of));
+    CHECK(__parse_schemas(c, doc, s, "allOf", &s->all_of));
+
+    CHECK(__parse_optional_schema(c, doc, s, "not", &s->not_schema, "not must be of an object"));
+
+    if (s->draft >= JS_DRAFT7)
+    {
+        CHECK(__parse_optional_schema(c, doc, s, "if", &s->if_schema, "if must be of an object"));
+        CHECK(__parse_optional_schema(c, doc, s, "then", &s->then_schema, "then must be of an object"));
+        CHECK(__parse_optional_schema(c, doc, s, "else", &s->else_schema, "else must be of an object"));
+    }
+
+#undef CHECK
+
+done:
+    c->depth--;
+    return ok;
+}
+
+// Returns whether the reference names one of the metaschemas, which
+// gojsonschema embeds but which are not available here.
+static bool __is_metaschema(js_str key)
+{
+    return __str_eq_lit(key, "http://json-schema.org/draft-04/schema") ||
+           __str_eq_lit(key, "http://json-schema.org/draft-06/schema") ||
+           __str_eq_lit(key, "http://json-schema.org/draft-07/schema");
+}
+
+// gojsonschema.schemaPool.GetDocument, remote references are not loaded.
+static js_entry *__get_document(js_compiler *c, js_url *ref)
+{
+    js_url u;
+
+    if (!__url_parse(__url_string(ref), &u, &c->err))
+    {
+        return NULL;
+    }
+
+    js_entry *e = __pool_get(c->documents, __url_string(&u));
+
+    if (e != NULL)
+    {
+        return e;
+    }
+
+    u.fragment.len = 0;
+    u.raw_fragment.len = 0;
+
+    js_str key = __url_string(&u);
+    e = __pool_get(c->documents, key);
+
+    if (e != NULL)
+    {
+        opa_value *doc = __pointer_get(e->doc, ref->fragment, &c->err);
+
+        if (doc == NULL)
+        {
+            return NULL;
+        }
+
+        int draft = e->draft;
+        e = __pool_add(&c->documents, __url_string(ref));
+        e->doc = doc;
+        e->draft = draft;
+        return e;
+    }
+
+    if (!__ref_canonical(ref))
+    {
+        __buf_puts(&c->err, "Reference ");
+        __buf_str(&c->err, __url_string(ref));
+        __buf_puts(&c->err, " must be canonical");
+        return NULL;
+    }
+
+    if (__is_metaschema(key))
+    {
+        c->abort = true;
+        return NULL;
+    }
+
+    __buf_puts(&c->err, "remote reference loading disabled: ");
+    __buf_str(&c->err, key);
+    return NULL;
+}
+
+// gojsonschema.Schema.parseReference
+static bool __parse_reference(js_compiler *c, js_schema *s)
+{
+    js_schema *r = __schema_new(s, __str("$ref"));
+    js_str key = __url_string(s->ref);
+
+    if (__pool_get(c->references, key) == NULL)
+    {
+        __pool_add(&c->references, key)->schema = r;
+    }
+
+    js_entry *e = __get_document(c, s->ref);
+
+    if (e == NULL)
+    {
+        return false;
+    }
+
+    r->id = s->ref;
+    r->draft = e->draft;
+
+    if (!__is_schema(e->doc))
+    {
+        return __fail(c, "Valid Schema must be of type object");
+    }
+
+    if (!__parse_schema(c, e->doc, r))
+    {
+        return false;
+    }
+
+    s->ref_schema = r;
+    return true;
+}
+
+// gojsonschema.SchemaLoader.Compile, returns NULL with the error set if the
+// schema is invalid, or NULL with abort set if it cannot be compiled here.
+static js_schema *__compile(js_compiler *c, opa_value *doc)
+{
+    int draft;
+
+    if (!__parse_schema_url(c, doc, &draft))
+    {
+        return NULL;
+    }
+
+    bool ok = __parse_references_recursive(c, doc, &c->root, draft);
+
+    js_entry *e = __pool_add(&c->documents, __str(""));
+    e->doc = doc;
+    e->draft = draft;
+
+    if (!ok || !__parse_schema_url(c, doc, &draft))
+    {
+        return NULL;
+    }
+
+    js_schema *root = __schema_new(NULL, __str("(Root)"));
+    root->draft = draft != 0 ? draft : JS_HYBRID;
+    c->root_schema = root;
+
+    if (!__parse_schema(c, doc, root))
+    {
+        return NULL;
+    }
+
+    return root;
+}
+
+typedef struct js_context js_context;
+
+struct js_context
+{
+    js_str head;
+    js_context *tail;
+};
+
+typedef struct
+{
+    const char *type;
+    js_context *context;
+    js_str description;
+} js_error;
+
+typedef struct
+{
+    js_vec errors;
+    int score;
+} js_result;
+
+typedef struct
+{
+    int depth;
+    bool abort;
+} js_validator;
+
+static js_context *__context(js_str head, js_context *tail)
+{
+    js_context *c = opa_malloc(sizeof(js_context));
+    c->head = head;
+    c->tail = tail;
+    return c;
+}
+
+static js_context *__context_index(size_t i, js_context *tail)
+{
+    js_buf b = {0};
+    __buf_int(&b, i);
+    return __context(__buf_get(&b), tail);
+}
+
+static void __context_write(js_buf *b, js_context *c)
+{
+    if (c->tail != NULL)
+    {
+        __context_write(b, c->tail);
+        __buf_putc(b, '.');
+    }
+
+    __buf_str(b, c->head);
+}
+
+// Returns the context without the root prefix.
+static js_str __field(js_context *c)
+{
+    js_buf b = {0};
+    __context_write(&b, c);
+
+    js_str s = __buf_get(&b);
+
+    if (__str_prefix(s, "(Root)."))
+    {
+        s = __substr(s, 7, s.len);
+    }
+
+    return s;
+}
+
+static void __pointer_write(js_buf *b, js_context *c)
+{
+    if (c->tail == NULL)
+    {
+        return;
+    }
+
+    __pointer_write(b, c->tail);
+    __buf_putc(b, '/');
+
+    for (size_t i = 0; i < c->head.len; i++)
+    {
+        switch (c->head.s[i])
+        {
+        case '~':
+            __buf_puts(b, "~0");
+            break;
+        case '/':
+            __buf_puts(b, "~1");
+            break;
+        default:
+            __buf_putc(b, c->head.s[i]);
+        }
+    }
+}
+
+static js_str __pointer(js_context *c)
+{
+    js_buf b = {0};
+    __pointer_write(&b, c);
+    return __buf_get(&b);
+}
+
+static void __add_error(js_result *r, const char *type, js_context *context, js_buf *desc)
+{
+    js_error *e = opa_malloc(sizeof(js_error));
+    e->type = type;
+    e->context = context;
+    e->description = __buf_get(desc);
+    __vec_append(&r->errors, e);
+    r->score -= 2;
+}
+
+static void __add_error_msg(js_result *r, const char *type, js_context *context, const char *msg)
+{
+    js_buf b = {0};
+    __buf_puts(&b, msg);
+    __add_error(r, type, context, &b);
+}
+
+static void __merge(js_result *r, js_result *other)
+{
+    for (size_t i = 0; i < other->errors.len; i++)
+    {
+        __vec_append(&r->errors, other->errors.elems[i]);
+    }
+
+    r->score += other->score;
+}
+
+static bool __valid(js_result *r)
+{
+    return r->errors.len == 0;
+}
+
+static bool __has_type(js_schema *s, int t)
+{
+    for (int i = 0; i < s->ntypes; i++)
+    {
+        if (s->types[i] == t)
+        {
+            return true;
+        }
+    }
+
+    return false;
+}
+
+static void __write_types(js_buf *b, js_schema *s)
+{
+    if (s->ntypes > 1)
+    {
+        __buf_putc(b, '[');
+    }
+
+    for (int i = 0; i < s->ntypes; i++)
+    {
+        if (i > 0)
+        {
+            __buf_putc(b, ',');
+        }
+
+        __buf_puts(b, __type_name(s->types[i]));
+    }
+
+    if (s->ntypes > 1)
+    {
+        __buf_putc(b, ']');
+    }
+}
+
+// Marshals the value the way gojsonschema compares values, numbers are
+// normalized to float64. Aborts the validation if a number overflows.
+static js_str __stringify(js_validator *v, opa_value *node)
+{
+    js_buf b = {0};
+
+    if (__marshal(&b, node, false) != NULL)
+    {
+        v->abort = true;
+    }
+
+    return __buf_get(&b);
+}
+
+static bool __is_format(js_str format, opa_value *node);
+
+static void __validate_recursive(js_validator *v, js_schema *s, opa_value *node, js_result *r, js_context *context);
+
+static js_result *__sub_validate(js_validator *v, js_schema *s, opa_value *node, js_context *context)
+{
+    js_result *r = opa_malloc(sizeof(js_result));
+    memset(r, 0, sizeof(js_result));
+    __validate_recursive(v, s, node, r, context);
+    return r;
+}
+
+static void __validate_schema(js_validator *v, js_schema *s, opa_value *node, js_result *r, js_context *context)
+{
+    if (s->any_of.len > 0)
+    {
+        bool validated = false;
+        js_result *best = NULL;
+
+        for (size_t i = 0; i < s->any_of.len && !validated; i++)
+        {
+            js_result *res = __sub_validate(v, s->any_of.elems[i], node, context);
+            validated = __valid(res);
+
+            if (!validated && (best == NULL || res->score > best->score))
+            {
+                best = res;
+            }
+        }
+
+        if (!validated)
+        {
+            __add_error_msg(r, "number_any_of", context, "Must validate at least one schema (anyOf)");
+
+            if (best != NULL)
+            {
+                __merge(r, best);
+            }
+        }
+    }
+
+    if (s->one_of.len > 0)
+    {
+        size_t n = 0;
+        js_result *best = NULL;
+
+        for (size_t i = 0; i < s->one_of.len; i++)
+        {
+            js_result *res = __sub_validate(v, s->one_of.elems[i], node, context);
+
+            if (__valid(res))
+            {
+                n++;
+            }
+            else if (n == 0 && (best == NULL || res->score > best->score))
+            {
+                best = res;
+            }
+        }
+
+        if (n != 1)
+        {
+            __add_error_msg(r, "number_one_of", context, "Must validate one and only one schema (oneOf)");
+
+            if (n == 0)
+            {
+                __merge(r, best);
+            }
+        }
+    }
+
+    if (s->all_of.len > 0)
+    {
+        size_t n = 0;
+
+        for (size_t i = 0; i < s->all_of.len; i++)
+        {
+            js_result *res = __sub_validate(v, s->all_of.elems[i], node, context);
+
+            if (__valid(res))
+            {
+                n++;
+            }
+
+            __merge(r, res);
+        }
+
+        if (n != s->all_of.len)
+        {
+            __add_error_msg(r, "number_all_of", context, "Must validate all the schemas (allOf)");
+        }
+    }
+
+    if (s->not_schema != NULL && __valid(__sub_validate(v, s->not_schema, node, context)))
+    {
+        __add_error_msg(r, "number_not", context, "Must not validate the schema (not)");
+    }
+
+    if (s->dependencies.len > 0 && opa_value_type(node) == OPA_OBJECT)
+    {
+        opa_array_t *keys = opa_object_keys(opa_cast_object(node));
+
+        for (size_t i = 0; i < keys->len; i++)
+        {
+            js_str key = __str_value(keys->elems[i].v);
+
+            for (size_t j = 0; j < s->dependencies.len; j++)
+            {
+                js_dependency *d = s->dependencies.elems[j];
+
+                if (!__str_eq(d->key, key))
+                {
+                    continue;
+                }
+
+                if (d->schema != NULL)
+                {
+                    __validate_recursive(v, d->schema, node, r, context);
+                    continue;
+                }
+
+                opa_array_t *deps = opa_cast_array(d->keys);
+
+                for (size_t k = 0; k < deps->len; k++)
+                {
+                    if (opa_value_get(node, deps->elems[k].v) == NULL)
+                    {
+                        js_buf b = {0};
+                        __buf_puts(&b, "Has a dependency on ");
+                        __buf_str(&b, __str_value(deps->elems[k].v));
+                        __add_error(r, "missing_dependency", context, &b);
+                    }
+                }
+            }
+        }
+    }
+
+    if (s->if_schema != NULL)
+    {
+        bool valid = __valid(__sub_validate(v, s->if_schema, node, context));
+
+        if (s->then_schema != NULL && valid)
+        {
+            js_result *res = __sub_validate(v, s->then_schema, node, context);
+
+            if (!__valid(res))
+            {
+                __add_error_msg(r, "condition_then", context, "Must validate \"then\" as \"if\" was valid");
+                __merge(r, res);
+            }
+        }
+
+        if (s->else_schema != NULL && !valid)
+        {
+            js_result *res = __sub_validate(v, s->else_schema, node, context);
+
+            if (!__valid(res))
+            {
+                __add_error_msg(r, "condition_else", context, "Must validate \"else\" as \"if\" was not valid");
+                __merge(r, res);
+            }
+        }
+    }
+
+    r->score++;
+}
+
+static void __validate_common(js_validator *v, js_schema *s, opa_value *node, js_result *r, js_context *context)
+{
+    if (s->has_const)
+    {
+        js_str str = __stringify(v, node);
+
+        if (!__str_eq(str, s->constant))
+        {
+            js_buf b = {0};
+            __buf_str(&b, __field(context));
+            __buf_puts(&b, " does not match: ");
+            __buf_str(&b, s->constant);
+            __add_error(r, "const", context, &b);
+        }
+    }
+
+    if (s->enums.len > 0)
+    {
+        js_str str = __stringify(v, node);
+        bool found = false;
+
+        for (size_t i = 0; i < s->enums.len && !found; i++)
+        {
+            found = __str_eq(str, __str_value(s->enums.elems[i]));
+        }
+
+        if (!found)
+        {
+            js_buf b = {0};
+            __buf_str(&b, __field(context));
+            __buf_puts(&b, " must be one of the following: ");
+
+            for (size_t i = 0; i < s->enums.len; i++)
+            {
+                if (i > 0)
+                {
+                    __buf_puts(&b, ", ");
+                }
+
+                __buf_str(&b, __str_value(s->enums.elems[i]));
+            }
+
+            __add_error(r, "enum", context, &b);
+        }
+    }
+
+    if (s->format.len > 0 && !__is_format(s->format, node))
+    {
+        js_buf b = {0};
+        __buf_puts(&b, "Does not match format '");
+        __buf_str(&b, s->format);
+        __buf_putc(&b, '\'');
+        __add_error(r, "format", context, &b);
+    }
+
+    r->score++;
+}
+
+static void __validate_array(js_validator *v, js_schema *s, opa_value *node, js_result *r, js_context *context)
+{
+    opa_array_t *arr = opa_cast_array(node);
+    size_t n = arr->len;
+
+    if (s->items_single)
+    {
+        for (size_t i = 0; i < n; i++)
+        {
+            __merge(r, __sub_validate(v, s->items.elems[0], arr->elems[i].v, __context_index(i, context)));
+        }
+    }
+    else if (s->items.len > 0)
+    {
+        size_t items = s->items.len;
+
+        for (size_t i = 0; i < items && i < n; i++)
+        {
+            __merge(r, __sub_validate(v, s->items.elems[i], arr->elems[i].v, __context_index(i, context)));
+        }
+
+        if (items < n)
+        {
+            if (s->additional_items == JS_FALSE)
+            {
+                __add_error_msg(r, "array_no_additional_items", context, "No additional items allowed on array");
+            }
+            else if (s->additional_items == JS_SCHEMA)
+            {
+                for (size_t i = items; i < n; i++)
+                {
+                    __merge(r, __sub_validate(v, s->additional_items_schema, arr->elems[i].v, __context_index(i, context)));
+                }
+            }
+        }
+    }
+
+    if (s->min_items != JS_UNSET && (long long)n < s->min_items)
+    {
+        js_buf b = {0};
+        __buf_puts(&b, "Array must have at least ");
+        __buf_int(&b, s->min_items);
+        __buf_puts(&b, " items");
+        __add_error(r, "array_min_items", context, &b);
+    }
+
+    if (s->max_items != JS_UNSET && (long long)n > s->max_items)
+    {
+        js_buf b = {0};
+        __buf_puts(&b, "Array must have at most ");
+        __buf_int(&b, s->max_items);
+        __buf_puts(&b, " items");
+        __add_error(r, "array_max_items", context, &b);
+    }
+
+    if (s->unique_items)
+    {
+        js_str *items = opa_malloc(n * sizeof(js_str) + 1);
+
+        for (size_t j = 0; j < n; j++)
+        {
+            items[j] = __stringify(v, arr->elems[j].v);
+
+            for (size_t i = j; i > 0; i--)
+            {
+                if (__str_eq(items[i - 1], items[j]))
+                {
+                    js_buf b = {0};
+                    __buf_puts(&b, "array items[");
+                    __buf_int(&b, i - 1);
+                    __buf_putc(&b, ',');
+                    __buf_int(&b, j);
+                    __buf_puts(&b, "] must be unique");
+                    __add_error(r, "unique", context, &b);
+                    break;
+                }
+            }
+        }
+    }
+
+    if (s->contains != NULL)
+    {
+        bool validated = false;
+        js_result *best = NULL;
+
+        for (size_t i = 0; i < n && !validated; i++)
+        {
+            js_result *res = __sub_validate(v, s->contains, arr->elems[i].v, __context_index(i, context));
+            validated = __valid(res);
+
+            if (!validated && (best == NULL || res->score > best->score))
+            {
+                best = res;
+            }
+        }
+
+        if (!validated)
+        {
+            __add_error_msg(r, "contains", context, "At least one of the items must match");
+
+            if (best != NULL)
+            {
+                __merge(r, best);
+            }
+        }
+    }
+
+    r->score++;
+}
+
+static bool __validate_pattern_property(js_validator *v, js_schema *s, opa_value *key, opa_value *node, js_result *r, js_context *context)
+{
+    bool validated = false;
+
+    for (size_t i = 0; i < s->pattern_properties.len; i++)
+    {
+        js_schema *p = s->pattern_properties.elems[i];
+        opa_value *match = opa_regex_match(p->pattern, key);
+
+        if (match != NULL && opa_value_type(match) == OPA_BOOLEAN && opa_cast_boolean(match)->v)
+        {
+            validated = true;
+            __merge(r, __sub_validate(v, p, node, __context(__str_value(key), context)));
+        }
+    }
+
+    if (!validated)
+    {
+        return false;
+    }
+
+    r->score++;
+    return true;
+}
+
+static void __validate_object(js_validator *v, js_schema *s, opa_value *node, js_result *r, js_context *context)
+{
+    opa_array_t *keys = opa_object_keys(opa_cast_object(node));
+    long long n = keys->len;
+
+    if (s->min_properties != JS_UNSET && n < s->min_properties)
+    {
+        js_buf b = {0};
+        __buf_puts(&b, "Must have at least ");
+        __buf_int(&b, s->min_properties);
+        __buf_puts(&b, " properties");
+        __add_error(r, "array_min_properties", context, &b);
+    }
+
+    if (s->max_properties != JS_UNSET && n > s->max_properties)
+    {
+        js_buf b = {0};
+        __buf_puts(&b, "Must have at most ");
+        __buf_int(&b, s->max_properties);
+        __buf_puts(&b, " properties");
+        __add_error(r, "array_max_properties", context, &b);
+    }
+
+    for (size_t i = 0; i < s->required.len; i++)
+    {
+        if (opa_value_get(node, s->required.elems[i]) != NULL)
+        {
+            r->score++;
+            continue;
+        }
+
+        js_buf b = {0};
+        __buf_str(&b, __str_value(s->required.elems[i]));
+        __buf_puts(&b, " is required");
+        __add_error(r, "required", context, &b);
+    }
+
+    for (size_t i = 0; i < keys->len; i++)
+    {
+        opa_value *key = keys->elems[i].v;
+        opa_value *value = opa_value_get(node, key);
+        bool found = false;
+
+        for (size_t j = 0; j < s->properties.len && !found; j++)
+        {
+            js_schema *p = s->properties.elems[j];
+            found = __str_eq(p->property, __str_value(key));
+        }
+
+        bool matched = __validate_pattern_property(v, s, key, value, r, context);
+
+        if (found || matched)
+        {
+            continue;
+        }
+
+        if (s->additional_properties == JS_FALSE)
+        {
+            js_buf b = {0};
+            __buf_puts(&b, "Additional property ");
+            __buf_str(&b, __str_value(key));
+            __buf_puts(&b, " is not allowed");
+            __add_error(r, "additional_property_not_allowed", context, &b);
+        }
+        else if (s->additional_properties == JS_SCHEMA)
+        {
+            __merge(r, __sub_validate(v, s->additional_properties_schema, value, __context(__str_value(key), context)));
+        }
+    }
+
+    if (s->property_names != NULL)
+    {
+        for (size_t i = 0; i < keys->len; i++)
+        {
+            js_result *res = __sub_validate(v, s->property_names, keys->elems[i].v, context);
+
+            if (!__valid(res))
+            {
+                js_buf b = {0};
+                __buf_puts(&b, "Property name of \"");
+                __buf_str(&b, __str_value(keys->elems[i].v));
+                __buf_puts(&b, "\" does not match");
+                __add_error(r, "invalid_property_name", context, &b);
+                __merge(r, res);
+            }
+        }
+    }
+
+    r->score++;
+}
+
+static void __validate_string(js_schema *s, opa_value *node, js_result *r, js_context *context)
+{
+    js_str str = __str_value(node);
+    long long n = 0;
+
+    for (size_t i = 0; i < str.len; i++)
+    {
+        if ((str.s[i] & 0xc0) != 0x80)
+        {
+            n++;
+        }
+    }
+
+    if (s->min_length != JS_UNSET && n < s->min_length)
+    {
+        js_buf b = {0};
+        __buf_puts(&b, "String length must be greater than or equal to ");
+        __buf_int(&b, s->min_length);
+        __add_error(r, "string_gte", context, &b);
+    }
+
+    if (s->max_length != JS_UNSET && n > s->max_length)
+    {
+        js_buf b = {0};
+        __buf_puts(&b, "String length must be less than or equal to ");
+        __buf_int(&b, s->max_length);
+        __add_error(r, "string_lte", context, &b);
+    }
+
+    r->score++;
+}
+
+static void __number_error(js_result *r, const char *type, js_context *context, const char *msg, opa_value *bound)
+{
+    js_buf b = {0};
+    __buf_puts(&b, msg);
+    __write_number(&b, bound);
+    __add_error(r, type, context, &b);
+}
+
+static bool __is_multiple(opa_value *node, opa_value *multiple)
+{
+    mpd_t *x = opa_number_to_bf(node);
+    mpd_t *y = opa_number_to_bf(multiple);
+    mpd_t *rem = mpd_qnew();
+    uint32_t status = 0;
+
+    mpd_qrem(rem, x, y, mpd_max_ctx(), &status);
+
+    bool ok = !(status & MPD_Errors) && mpd_iszero(rem);
+
+    mpd_del(x);
+    mpd_del(y);
+    mpd_del(rem);
+
+    return ok;
+}
+
+// Compares the numbers exactly, opa_value_compare truncates the integers
+// that overflow an int64.
+static int __number_cmp(opa_value *a, opa_value *b)
+{
+    mpd_t *x = opa_number_to_bf(a);
+    mpd_t *y = opa_number_to_bf(b);
+    uint32_t status = 0;
+    int c = mpd_qcmp(x, y, &status);
+
+    mpd_del(x);
+    mpd_del(y);
+
+    return c;
+}
+
+static void __validate_number(js_schema *s, opa_value *node, js_result *r, js_context *context)
+{
+    if (s->multiple_of != NULL && !__is_multiple(node, s->multiple_of))
+    {
+        __number_error(r, "multiple_of", context, "Must be a multiple of ", s->multiple_of);
+    }
+
+    if (s->maximum != NULL && __number_cmp(node, s->maximum) > 0)
+    {
+        __number_error(r, "number_lte", context, "Must be less than or equal to ", s->maximum);
+    }
+
+    if (s->exclusive_maximum != NULL && __number_cmp(node, s->exclusive_maximum) >= 0)
+    {
+        __number_error(r, "number_lt", context, "Must be less than ", s->exclusive_maximum);
+    }
+
+    if (s->minimum != NULL && __number_cmp(node, s->minimum) < 0)
+    {
+        __number_error(r, "number_gte", context, "Must be greater than or equal to ", s->minimum);
+    }
+
+    if (s->exclusive_minimum != NULL && __number_cmp(node, s->exclusive_minimum) <= 0)
+    {
+        __number_error(r, "number_gt", context, "Must be greater than ", s->exclusive_minimum);
+    }
+
+    r->score++;
+}
+
+// gojsonschema.SubSchema.validateRecursive
+static void __validate_recursive(js_validator *v, js_schema *s, opa_value *node, js_result *r, js_context *context)
+{
+    if (v->abort || ++v->depth > JS_MAX_DEPTH)
+    {
+        v->abort = true;
+        return;
+    }
+
+    if (s->pass != JS_UNSET)
+    {
+        if (s->pass == JS_FALSE)
+        {
+            __add_error_msg(r, "false", context, "False always fails validation");
+        }
+
+        v->depth--;
+        return;
+    }
+
+    if (s->ref_schema != NULL)
+    {
+        __validate_recursive(v, s->ref_schema, node, r, context);
+        v->depth--;
+        return;
+    }
+
+    int t = opa_value_type(node);
+    int given;
+    bool valid;
+
+    switch (t)
+    {
+    case OPA_NULL:
+        given = JS_TYPE_NULL;
+        valid = __has_type(s, given);
+        break;
+    case OPA_BOOLEAN:
+        given = JS_TYPE_BOOLEAN;
+        valid = __has_type(s, given);
+        break;
+    case OPA_NUMBER:
+    {
+        js_decimal x;
+        __decimal(&x, node);
+        given = __decimal_is_int(&x) ? JS_TYPE_INTEGER : JS_TYPE_NUMBER;
+        valid = __has_type(s, JS_TYPE_NUMBER) || (given == JS_TYPE_INTEGER && __has_type(s, JS_TYPE_INTEGER));
+        break;
+    }
+    case OPA_STRING:
+        given = JS_TYPE_STRING;
+        valid = __has_type(s, given);
+        break;
+    case OPA_ARRAY:
+        given = JS_TYPE_ARRAY;
+        valid = __has_type(s, given);
+        break;
+    default:
+        given = JS_TYPE_OBJECT;
+        valid = __has_type(s, given);
+        break;
+    }
+
+    if (s->ntypes > 0 && !valid)
+    {
+        js_buf b = {0};
+        __buf_puts(&b, "Invalid type. Expected: ");
+        __write_types(&b, s);
+        __buf_puts(&b, ", given: ");
+        __buf_puts(&b, __type_name(given));
+        __add_error(r, "invalid_type", context, &b);
+        v->depth--;
+        return;
+    }
+
+    __validate_schema(v, s, node, r, context);
+
+    switch (t)
+    {
+    case OPA_NUMBER:
+        __validate_number(s, node, r, context);
+        break;
+    case OPA_ARRAY:
+        __validate_array(v, s, node, r, context);
+        break;
+    case OPA_OBJECT:
+        __validate_object(v, s, node, r, context);
+        break;
+    }
+
+    __validate_common(v, s, node, r, context);
+
+    if (t == OPA_STRING)
+    {
+        __validate_string(s, node, r, context);
+    }
+
+    if (t == OPA_OBJECT)
+    {
+        for (size_t i = 0; i < s->properties.len; i++)
+        {
+            js_schema *p = s->properties.elems[i];
+            opa_value *next = opa_value_get(node, __string(p->property));
+
+            if (next != NULL)
+            {
+                __validate_recursive(v, p, next, r, __context(p->property, context));
+            }
+        }
+    }
+
+    r->score++;
+    v->depth--;
+}
+
+// Parses the value with a time.Parse layout: Y is a four digit year, M a two
+// digit month, D a two digit day, h a one or two digit hour, m and s two
+// digit minutes and seconds (the latter with optional fractional seconds),
+// and Z a Z07:00 time zone. Other characters match themselves.
+static bool __time_parse(js_str s, const char *layout)
+{
+    size_t i = 0;
+    int year = 0, month = 1, day = 1;
+
+    for (; *layout != '\0'; layout++)
+    {
+        int n = 0;
+
+        switch (*layout)
+        {
+        case 'Y':
+            if (i + 4 > s.len)
+            {
+                return false;
+            }
+
+            for (int j = 0; j < 4; j++)
+            {
+                if (!__is_digit(s.s[i]))
+                {
+                    return false;
+                }
+
+                year = year * 10 + (s.s[i++] - '0');
+            }
+
+            break;
+        case 'M':
+        case 'D':
+        case 'h':
+        case 'm':
+        case 's':
+            if (i >= s.len || !__is_digit(s.s[i]))
+            {
+                return false;
+            }
+
+            n = s.s[i++] - '0';
+
+            if (i < s.len && __is_digit(s.s[i]))
+            {
+                n = n * 10 + (s.s[i++] - '0');
+            }
+            else if (*layout != 'h')
+            {
+                return false;
+            }
+
+            if (*layout == 'M')
+            {
+                if (n < 1 || n > 12)
+                {
+                    return false;
+                }
+
+                month = n;
+            }
+            else if (*layout == 'D')
+            {
+                day = n;
+            }
+            else if (n >= (*layout == 'h' ? 24 : 60))
+            {
+                return false;
+            }
+
+            if (*layout == 's' && i + 1 < s.len && (s.s[i] == '.' || s.s[i] == ',') && __is_digit(s.s[i + 1]))
+            {
+                for (i += 2; i < s.len && __is_digit(s.s[i]); i++)
+                {
+                }
+            }
+
+            break;
+        case 'Z':
+            if (i < s.len && s.s[i] == 'Z')
+            {
+                i++;
+                break;
+            }
+
+            if (i + 6 > s.len || s.s[i + 3] != ':' || (s.s[i] != '+' && s.s[i] != '-'))
+            {
+                return false;
+            }
+
+            for (int j = 1; j < 6; j++)
+            {
+                if (j != 3 && !__is_digit(s.s[i + j]))
+                {
+                    return false;
+                }
+            }
+
+            if ((s.s[i + 1] - '0') * 10 + (s.s[i + 2] - '0') > 24 || (s.s[i + 4] - '0') * 10 + (s.s[i + 5] - '0') > 60)
+            {
+                return false;
+            }
+
+            i += 6;
+            break;
+        default:
+            if (i >= s.len || s.s[i] != *layout)
+            {
+                return false;
+            }
+
+            i++;
+        }
+    }
+
+    if (i != s.len)
+    {
+        return false;
+    }
+
+    int days = 31;
+
+    if (month == 2)
+    {
+        bool leap = year % 4 == 0 && (year % 100 != 0 || year % 400 == 0);
+        days = leap ? 29 : 28;
+    }
+    else if (month == 4 || month == 6 || month == 9 || month == 11)
+    {
+        days = 30;
+    }
+
+    return day >= 1 && day <= days;
+}
+
+static bool __match(const char *pattern, js_str s)
+{
+    opa_value *r = opa_regex_match(opa_string_terminated(pattern), __string(s));
+    return r != NULL && opa_value_type(r) == OPA_BOOLEAN && opa_cast_boolean(r)->v;
+}
+
+// Address parser of net/mail, only reporting whether parsing succeeds.
+typedef struct
+{
+    js_str s;
+} js_mail;
+
+static bool __mail_empty(js_mail *p)
+{
+    return p->s.len == 0;
+}
+
+static char __mail_peek(js_mail *p)
+{
+    return p->s.s[0];
+}
+
+static bool __mail_consume(js_mail *p, char c)
+{
+    if (__mail_empty(p) || __mail_peek(p) != c)
+    {
+        return false;
+    }
+
+    p->s = __substr(p->s, 1, p->s.len);
+    return true;
+}
+
+static void __mail_skip_space(js_mail *p)
+{
+    while (!__mail_empty(p) && (__mail_peek(p) == ' ' || __mail_peek(p) == '\t'))
+    {
+        p->s = __substr(p->s, 1, p->s.len);
+    }
+}
+
+// Decodes the rune at the start of s, returns its size or 0 if s is empty.
+// Invalid encodings are reported as -1 with a size of 1.
+static int __mail_rune(js_str s, int *r)
+{
+    if (s.len == 0)
+    {
+        return 0;
+    }
+
+    int n = 0;
+    *r = opa_unicode_decode_utf8(s.s, 0, s.len, &n);
+
+    if (*r == -1)
+    {
+        return 1;
+    }
+
+    return n;
+}
+
+static bool __mail_is_vchar(int r)
+{
+    return ('!' <= r && r <= '~') || r >= 0x80;
+}
+
+static bool __mail_is_atext(int r, bool dot)
+{
+    switch (r)
+    {
+    case '.':
+        return dot;
+    case '(':
+    case ')':
+    case '<':
+    case '>':
+    case '[':
+    case ']':
+    case ':':
+    case ';':
+    case '@':
+    case '\\':
+    case ',':
+    case '"':
+        return false;
+    }
+
+    return __mail_is_vchar(r);
+}
+
+static bool __mail_quoted_string(js_mail *p, js_str *out)
+{
+    size_t i = 1;
+    bool escaped = false;
+    js_buf b = {0};
+
+    for (;;)
+    {
+        int r;
+        int size = __mail_rune(__substr(p->s, i, p->s.len), &r);
+
+        if (size == 0 || r == -1)
+        {
+            return false;
+        }
+
+        if (escaped)
+        {
+            if (!__mail_is_vchar(r) && r != ' ' && r != '\t')
+            {
+                return false;
+            }
+
+            __buf_write(&b, &p->s.s[i], size);
+            escaped = false;
+        }
+        else if ((__mail_is_vchar(r) && r != '\\' && r != '"') || r == ' ' || r == '\t')
+        {
+            __buf_write(&b, &p->s.s[i], size);
+        }
+        else if (r == '"')
+        {
+            break;
+        }
+        else if (r == '\\')
+        {
+            escaped = true;
+        }
+        else
+        {
+            return false;
+        }
+
+        i += size;
+    }
+
+    p->s = __substr(p->s, i + 1, p->s.len);
+    *out = __buf_get(&b);
+    return true;
+}
+
+static bool __mail_atom(js_mail *p, bool permissive, js_str *out)
+{
+    size_t i = 0;
+
+    for (;;)
+    {
+        int r;
+        int size = __mail_rune(__substr(p->s, i, p->s.len), &r);
+
+        if (size == 1 && r == -1)
+        {
+            return false;
+        }
+
+        if (size == 0 || !__mail_is_atext(r, true))
+        {
+            break;
+        }
+
+        i += size;
+    }
+
+    if (i == 0)
+    {
+        return false;
+    }
+
+    js_str atom = __substr(p->s, 0, i);
+    p->s = __substr(p->s, i, p->s.len);
+    *out = atom;
+
+    if (permissive)
+    {
+        return true;
+    }
+
+    for (size_t j = 0; j + 1 < atom.len; j++)
+    {
+        if (atom.s[j] == '.' && atom.s[j + 1] == '.')
+        {
+            return false;
+        }
+    }
+
+    return atom.s[0] != '.' && atom.s[atom.len - 1] != '.';
+}
+
+static bool __mail_domain_literal(js_mail *p)
+{
+    if (!__mail_consume(p, '['))
+    {
+        return false;
+    }
+
+    js_str dtext = p->s;
+    size_t n = 0;
+
+    for (;;)
+    {
+        if (__mail_empty(p))
+        {
+            return false;
+        }
+
+        if (__mail_peek(p) == ']')
+        {
+            break;
+        }
+
+        int r;
+        int size = __mail_rune(p->s, &r);
+
+        if (r == -1 || !__mail_is_vchar(r) || r == '[' || r == '\\')
+        {
+            return false;
+        }
+
+        n += size;
+        p->s = __substr(p->s, size, p->s.len);
+    }
+
+    dtext.len = n;
+    __mail_consume(p, ']');
+
+    unsigned char ip[16];
+
+    if (__str_prefix(dtext, "IPv6:"))
+    {
+        return __parse_ip(__substr(dtext, 5, dtext.len), ip) != JS_IP_INVALID;
+    }
+
+    if (__parse_ip(dtext, ip) == JS_IP_INVALID)
+    {
+        return false;
+    }
+
+    for (int i = 0; i < 10; i++)
+    {
+        if (ip[i] != 0)
+        {
+            return false;
+        }
+    }
+
+    return ip[10] == 0xff && ip[11] == 0xff;
+}
+
+static bool __mail_comment(js_mail *p, js_buf *comment)
+{
+    int depth = 1;
+
+    while (!__mail_empty(p) && depth > 0)
+    {
+        if (__mail_peek(p) == '\\' && p->s.len > 1)
+        {
+            p->s = __substr(p->s, 1, p->s.len);
+        }
+        else if (__mail_peek(p) == '(')
+        {
+            depth++;
+        }
+        else if (__mail_peek(p) == ')')
+        {
+            depth--;
+        }
+
+        if (depth > 0)
+        {
+            __buf_putc(comment, __mail_peek(p));
+        }
+
+        p->s = __substr(p->s, 1, p->s.len);
+    }
+
+    return depth == 0;
+}
+
+static bool __mail_skip_cfws(js_mail *p)
+{
+    __mail_skip_space(p);
+
+    while (__mail_consume(p, '('))
+    {
+        js_buf comment = {0};
+
+        if (!__mail_comment(p, &comment))
+        {
+            return false;
+        }
+
+        __mail_skip_space(p);
+    }
+
+    return true;
+}
+
+static bool __is_base64(js_str s)
+{
+    if (s.len % 4 != 0)
+    {
+        return false;
+    }
+
+    for (size_t i = 0; i < s.len; i++)
+    {
+        char c = s.s[i];
+
+        if (c == '=' && i + 2 >= s.len && (i % 4) >= 2)
+        {
+            if (i + 1 < s.len && s.s[i + 1] != '=')
+            {
+                return false;
+            }
+
+            return true;
+        }
+
+        if (!__is_alnum(c) && c != '+' && c != '/')
+        {
+            return false;
+        }
+    }
+
+    return true;
+}
+
+static bool __is_qencoded(js_str s)
+{
+    for (size_t i = 0; i < s.len; i++)
+    {
+        char c = s.s[i];
+
+        if (c == '=')
+        {
+            if (i + 2 >= s.len || !opa_ishex(s.s[i + 1]) || !opa_ishex(s.s[i + 2]))
+            {
+                return false;
+            }
+
+            i += 2;
+        }
+        else if (c != '_' && !(' ' <= c && c <= '~') && c != '\n' && c != '\r' && c != '\t')
+        {
+            return false;
+        }
+    }
+
+    return true;
+}
+
+static bool __equal_fold(js_str s, const char *lit)
+{
+    size_t n = opa_strlen(lit);
+
+    if (s.len != n)
+    {
+        return false;
+    }
+
+    for (size_t i = 0; i < n; i++)
+    {
+        char c = s.s[i];
+
+        if ('A' <= c && c <= 'Z')
+        {
+            c += 'a' - 'A';
+        }
+
+        if (c != lit[i])
+        {
+            return false;
+        }
+    }
+
+    return true;
+}
+
+// Returns false if the word is an encoded-word in an unsupported charset,
+// which is the only error mime.WordDecoder reports to net/mail.
+static bool __mail_decode_word(js_str w)
+{
+    size_t q = 0;
+
+    for (size_t i = 0; i < w.len; i++)
+    {
+        q += w.s[i] == '?';
+    }
+
+    if (w.len < 8 || !__str_prefix(w, "=?") || w.s[w.len - 2] != '?' || w.s[w.len - 1] != '=' || q != 4)
+    {
+        return true;
+    }
+
+    js_str word = __substr(w, 2, w.len - 2);
+    int i = __str_index(word, '?');
+    js_str charset = __substr(word, 0, i);
+    js_str rest = __substr(word, i + 1, word.len);
+    int j = __str_index(rest, '?');
+    js_str encoding = __substr(rest, 0, j);
+    js_str text = __substr(rest, j + 1, rest.len);
+
+    if (charset.len == 0 || encoding.len != 1)
+    {
+        return true;
+    }
+
+    switch (encoding.s[0])
+    {
+    case 'B':
+    case 'b':
+        if (!__is_base64(text))
+        {
+            return true;
+        }
+        break;
+    case 'Q':
+    case 'q':
+        if (!__is_qencoded(text))
+        {
+            return true;
+        }
+        break;
+    default:
+        return true;
+    }
+
+    return __equal_fold(charset, "utf-8") || __equal_fold(charset, "iso-8859-1") || __equal_fold(charset, "us-ascii");
+}
+
+static bool __mail_display_name_comment(js_mail *p)
+{
+    if (!__mail_consume(p, '('))
+    {
+        return false;
+    }
+
+    js_buf b = {0};
+
+    if (!__mail_comment(p, &b))
+    {
+        return false;
+    }
+
+    js_str comment = __buf_get(&b);
+    size_t start = 0;
+
+    for (size_t i = 0; i <= comment.len; i++)
+    {
+        if (i == comment.len || comment.s[i] == ' ' || comment.s[i] == '\t')
+        {
+            if (i > start && !__mail_decode_word(__substr(comment, start, i)))
+            {
+                return false;
+            }
+
+            start = i + 1;
+        }
+    }
+
+    return true;
+}
+
+static bool __mail_addr_spec(js_mail *p)
+{
+    js_mail orig = *p;
+    js_str local;
+
+    __mail_skip_space(p);
+
+    bool ok = !__mail_empty(p);
+
+    if (ok && __mail_peek(p) == '"')
+    {
+        ok = __mail_quoted_string(p, &local) && local.len > 0;
+    }
+    else if (ok)
+    {
+        ok = __mail_atom(p, false, &local);
+    }
+
+    ok = ok && __mail_consume(p, '@');
+
+    if (ok)
+    {
+        __mail_skip_space(p);
+        ok = !__mail_empty(p);
+    }
+
+    if (ok && __mail_peek(p) == '[')
+    {
+        ok = __mail_domain_literal(p);
+    }
+    else if (ok)
+    {
+        js_str domain;
+        ok = __mail_atom(p, false, &domain);
+    }
+
+    if (!ok)
+    {
+        *p = orig;
+    }
+
+    return ok;
+}
+
+static bool __mail_phrase(js_mail *p)
+{
+    bool words = false;
+    bool failed = false;
+
+    for (;;)
+    {
+        if (words && !__mail_skip_cfws(p))
+        {
+            return false;
+        }
+
+        __mail_skip_space(p);
+
+        if (__mail_empty(p))
+        {
+            break;
+        }
+
+        js_str word;
+
+        if (__mail_peek(p) == '"')
+        {
+            failed = !__mail_quoted_string(p, &word);
+        }
+        else
+        {
+            failed = !__mail_atom(p, true, &word) || !__mail_decode_word(word);
+        }
+
+        if (failed)
+        {
+            break;
+        }
+
+        words = true;
+    }
+
+    return !failed || words;
+}
+
+static size_t __mail_address(js_mail *p, bool group, bool *ok);
+
+static size_t __mail_group_list(js_mail *p, bool *ok)
+{
+    size_t n = 0;
+
+    __mail_skip_space(p);
+
+    if (__mail_consume(p, ';'))
+    {
+        *ok = __mail_skip_cfws(p);
+        return 0;
+    }
+
+    for (;;)
+    {
+        __mail_skip_space(p);
+        n += __mail_address(p, false, ok);
+
+        if (!*ok || !__mail_skip_cfws(p))
+        {
+            *ok = false;
+            return n;
+        }
+
+        if (__mail_consume(p, ';'))
+        {
+            *ok = __mail_skip_cfws(p);
+            return n;
+        }
+
+        if (!__mail_consume(p, ','))
+        {
+            *ok = false;
+            return n;
+        }
+    }
+}
+
+// Returns the number of addresses parsed.
+static size_t __mail_address(js_mail *p, bool group, bool *ok)
+{
+    *ok = false;
+    __mail_skip_space(p);
+
+    if (__mail_empty(p))
+    {
+        return 0;
+    }
+
+    if (__mail_addr_spec(p))
+    {
+        __mail_skip_space(p);
+        *ok = __mail_empty(p) || __mail_peek(p) != '(' || __mail_display_name_comment(p);
+        return 1;
+    }
+
+    if (__mail_peek(p) != '<' && !__mail_phrase(p))
+    {
+        return 0;
+    }
+
+    __mail_skip_space(p);
+
+    if (group && __mail_consume(p, ':'))
+    {
+        *ok = true;
+        return __mail_group_list(p, ok);
+    }
+
+    *ok = __mail_consume(p, '<') && __mail_addr_spec(p) && __mail_consume(p, '>');
+    return 1;
+}
+
+// net/mail.ParseAddress
+static bool __is_email(js_str s)
+{
+    js_mail p = {.s = s};
+    bool ok;
+    size_t n = __mail_address(&p, true, &ok);
+    return ok && __mail_skip_cfws(&p) && __mail_empty(&p) && n == 1;
+}
+
+static bool __is_format(js_str format, opa_value *node)
+{
+    if (opa_value_type(node) != OPA_STRING)
+    {
+        return true;
+    }
+
+    js_str s = __str_value(node);
+    unsigned char ip[16];
+    js_url u;
+    js_buf err = {0};
+
+    if (__str_eq_lit(format, "date"))
+    {
+        return __time_parse(s, "Y-M-D");
+    }
+
+    if (__str_eq_lit(format, "time"))
+    {
+        return __time_parse(s, "h:m:sZ") || __time_parse(s, "h:m:s");
+    }
+
+    if (__str_eq_lit(format, "date-time"))
+    {
+        return __time_parse(s, "h:m:s") || __time_parse(s, "h:m:sZ") || __time_parse(s, "Y-M-D") ||
+               __time_parse(s, "Y-M-DTh:m:sZ");
+    }
+
+    if (__str_eq_lit(format, "hostname"))
+    {
+        return s.len < 256 && __match("^([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\\-]{0,61}[a-zA-Z0-9])(\\.([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\\-]{0,61}[a-zA-Z0-9]))*$", s);
+    }
+
+    if (__str_eq_lit(format, "email") || __str_eq_lit(format, "idn-email"))
+    {
+        return __is_email(s);
+    }
+
+    if (__str_eq_lit(format, "ipv4"))
+    {
+        return __parse_ip(s, ip) != JS_IP_INVALID && __str_contains(s, '.');
+    }
+
+    if (__str_eq_lit(format, "ipv6"))
+    {
+        return __parse_ip(s, ip) != JS_IP_INVALID && __str_contains(s, ':');
+    }
+
+    if (__str_eq_lit(format, "uri") || __str_eq_lit(format, "iri"))
+    {
+        return __url_parse(s, &u, &err) && u.scheme.len > 0 && !__str_contains(s, '\\');
+    }
+
+    if (__str_eq_lit(format, "uri-reference") || __str_eq_lit(format, "iri-reference"))
+    {
+        return __url_parse(s, &u, &err) && !__str_contains(s, '\\');
+    }
+
+    if (__str_eq_lit(format, "uri-template"))
+    {
+        return __url_parse(s, &u, &err) && !__str_contains(s, '\\') && __match("^([^{]*({[^}]*})?)*$", u.path);
+    }
+
+    if (__str_eq_lit(format, "uuid"))
+    {
+        return __match("^(?i)[a-f0-9]{8}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{12}$", s);
+    }
+
+    if (__str_eq_lit(format, "regex"))
+    {
+        opa_value *valid = opa_regex_is_valid(node);
+        return s.len == 0 || (opa_value_type(valid) == OPA_BOOLEAN && opa_cast_boolean(valid)->v);
+    }
+
+    if (__str_eq_lit(format, "json-pointer"))
+    {
+        return __match("^(?:/(?:[^~/]|~0|~1)*)*$", s);
+    }
+
+    if (__str_eq_lit(format, "relative-json-pointer"))
+    {
+        return __match("^(?:0|[1-9][0-9]*)(?:#|(?:/(?:[^~/]|~0|~1)*)*)$", s);
+    }
+
+    return true;
+}
+
+typedef struct
+{
+    js_error *error;
+    js_str pointer;
+    js_str str;
+} js_sorted_error;
+
+static int __error_cmp(js_sorted_error *a, js_sorted_error *b)
+{
+    int c = __str_cmp(a->pointer, b->pointer);
+    return c != 0 ? c : __str_cmp(a->str, b->str);
+}
+
+// Stable merge sort of the errors by their pointer and their string.
+static void __sort_errors(js_sorted_error *errs, js_sorted_error *tmp, size_t n)
+{
+    if (n < 2)
+    {
+        return;
+    }
+
+    size_t mid = n / 2;
+
+    __sort_errors(errs, tmp, mid);
+    __sort_errors(&errs[mid], tmp, n - mid);
+
+    size_t i = 0, j = mid, k = 0;
+
+    while (i < mid && j < n)
+    {
+        tmp[k++] = __error_cmp(&errs[j], &errs[i]) < 0 ? errs[j++] : errs[i++];
+    }
+
+    while (i < mid)
+    {
+        tmp[k++] = errs[i++];
+    }
+
+    while (j < n)
+    {
+        tmp[k++] = errs[j++];
+    }
+
+    memcpy(errs, tmp, n * sizeof(js_sorted_error));
+}
+
+// Returns the schema document of the operand, or NULL with the error set
+// (or empty if the operand is not a schema).
+static opa_value *__schema_document(opa_value *schema, js_buf *err)
+{
+    switch (opa_value_type(schema))
+    {
+    case OPA_STRING:
+    {
+        js_buf e = {0};
+        opa_value *doc = __json_decode(__str_value(schema), &e);
+
+        if (doc == NULL && e.len > 0)
+        {
+            __buf_puts(err, "invalid JSON: ");
+            __buf_str(err, __buf_get(&e));
+        }
+
+        return doc;
+    }
+    case OPA_OBJECT:
+        return __normalize(schema);
+    }
+
+    return NULL;
+}
+
+OPA_BUILTIN
+opa_value *builtin_json_verify_schema(opa_value *schema)
+{
+    js_compiler c;
+    memset(&c, 0, sizeof(js_compiler));
+
+    opa_value *doc = __schema_document(schema, &c.err);
+
+    if (doc != NULL && __compile(&c, doc) != NULL)
+    {
+        opa_array_t *r = opa_cast_array(opa_array_with_cap(2));
+        opa_array_append(r, opa_boolean(true));
+        opa_array_append(r, opa_null());
+        return &r->hdr;
+    }
+
+    if (c.abort || c.err.len == 0)
+    {
+        return NULL;
+    }
+
+    opa_array_t *r = opa_cast_array(opa_array_with_cap(2));
+    opa_array_append(r, opa_boolean(false));
+    opa_array_append(r, __string(__buf_get(&c.err)));
+    return &r->hdr;
+}
+
+OPA_BUILTIN
+opa_value *builtin_json_match_schema(opa_value *document, opa_value *schema)
+{
+    js_compiler c;
+    memset(&c, 0, sizeof(js_compiler));
+
+    opa_value *doc = __schema_document(schema, &c.err);
+
+    if (doc == NULL)
+    {
+        return NULL;
+    }
+
+    js_schema *root = __compile(&c, doc);
+
+    if (root == NULL)
+    {
+        return NULL;
+    }
+
+    js_validator v = {0};
+    js_result result = {0};
+
+    __validate_recursive(&v, root, __normalize(document), &result, __context(__str("(Root)"), NULL));
+
+    if (v.abort)
+    {
+        return NULL;
+    }
+
+    size_t n = result.errors.len;
+    js_sorted_error *errs = opa_malloc(n * sizeof(js_sorted_error) + 1);
+    js_sorted_error *tmp = opa_malloc(n * sizeof(js_sorted_error) + 1);
+
+    for (size_t i = 0; i < n; i++)
+    {
+        js_error *e = result.errors.elems[i];
+        js_buf b = {0};
+
+        __buf_str(&b, __field(e->context));
+        __buf_puts(&b, ": ");
+        __buf_str(&b, e->description);
+
+        errs[i].error = e;
+        errs[i].pointer = __pointer(e->context);
+        errs[i].str = __buf_get(&b);
+    }
+
+    __sort_errors(errs, tmp, n);
+
+    opa_array_t *arr = opa_cast_array(opa_array_with_cap(n));
+
+    for (size_t i = 0; i < n; i++)
+    {
+        opa_object_t *obj = opa_cast_object(opa_object());
+        opa_object_insert(obj, opa_string_terminated("error"), __string(errs[i].str));
+        opa_object_insert(obj, opa_string_terminated("type"), opa_string_terminated(errs[i].error->type));
+        opa_object_insert(obj, opa_string_terminated("field"), __string(errs[i].pointer));
+        opa_object_insert(obj, opa_string_terminated("desc"), __string(errs[i].error->description));
+        opa_array_append(arr, &obj->hdr);
+    }
+
+    opa_free(tmp);
+    opa_free(errs);
+
+    opa_array_t *r = opa_cast_array(opa_array_with_cap(2));
+    opa_array_append(r, opa_boolean(n == 0));
+    opa_array_append(r, &arr->hdr);
+    return &r->hdr;
+}
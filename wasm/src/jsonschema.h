@@ -0,0 +1,9 @@
+#ifndef OPA_JSONSCHEMA_H
+#define OPA_JSONSCHEMA_H
+
+#include "value.h"
+
+opa_value *builtin_json_verify_schema(opa_value *schema);
+opa_value *builtin_json_match_schema(opa_value *document, opa_value *schema);
+
+#endif
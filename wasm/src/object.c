@@ -1,5 +1,9 @@
+#include <string.h>
+
 #include "std.h"
+#include "malloc.h"
 #include "object.h"
+#include "str.h"
 
 static opa_value *__merge(opa_value *a, opa_value *b);
 static opa_value *__merge_with_overwrite(opa_value *a, opa_value *b);
@@ -621,3 +625,341 @@ opa_value *builtin_json_filter(opa_value *obj, opa_value *paths)
 
     return r;
 }
+
+static bool __has_string_keys(opa_value *obj)
+{
+    for (opa_value *key = opa_value_iter(obj, NULL); key != NULL;
+         key = opa_value_iter(obj, key))
+    {
+        if (opa_value_type(key) != OPA_STRING)
+        {
+            return false;
+        }
+    }
+
+    return true;
+}
+
+// Returns the JSON pointer of the key below the pointer path, escaping '~'
+// and '/' in the key.
+static char *__json_pointer_append(const char *path, const char *key, size_t key_len)
+{
+    size_t path_len = opa_strlen(path);
+    size_t len = path_len + 1 + key_len;
+
+    for (size_t i = 0; i < key_len; i++)
+    {
+        if (key[i] == '~' || key[i] == '/')
+        {
+            len++;
+        }
+    }
+
+    char *result = opa_malloc(len + 1);
+    memcpy(result, path, path_len);
+
+    char *p = result + path_len;
+    *p++ = '/';
+
+    for (size_t i = 0; i < key_len; i++)
+    {
+        switch (key[i])
+        {
+        case '~':
+            *p++ = '~';
+            *p++ = '0';
+            break;
+        case '/':
+            *p++ = '~';
+            *p++ = '1';
+            break;
+        default:
+            *p++ = key[i];
+        }
+    }
+
+    *p = '\0';
+    return result;
+}
+
+static char *__json_pointer_append_index(const char *path, size_t idx)
+{
+    char buf[32];
+    opa_itoa(idx, buf, 10);
+    return __json_pointer_append(path, buf, opa_strlen(buf));
+}
+
+// Appends a JSON-Patch operation to patch, taking ownership of path. The
+// value is omitted if NULL.
+static void __json_patch_op(opa_array_t *patch, const char *op, char *path, opa_value *value)
+{
+    opa_object_t *obj = opa_cast_object(opa_object());
+    opa_object_insert(obj, opa_string_terminated("op"), opa_string_terminated(op));
+    opa_object_insert(obj, opa_string_terminated("path"), opa_string_allocated(path, opa_strlen(path)));
+
+    if (value != NULL)
+    {
+        opa_object_insert(obj, opa_string_terminated("value"), value);
+    }
+
+    opa_array_append(patch, &obj->hdr);
+}
+
+static char *__json_pointer_copy(const char *path)
+{
+    size_t len = opa_strlen(path);
+    char *result = opa_malloc(len + 1);
+    memcpy(result, path, len + 1);
+    return result;
+}
+
+static void __json_diff(opa_array_t *patch, const char *path, opa_value *a, opa_value *b);
+
+static void __json_diff_objects(opa_array_t *patch, const char *path, opa_value *a, opa_value *b)
+{
+    opa_array_t *keys = opa_object_keys(opa_cast_object(a));
+
+    for (size_t i = 0; i < keys->len; i++)
+    {
+        opa_string_t *key = opa_cast_string(keys->elems[i].v);
+        char *child = __json_pointer_append(path, key->v, key->len);
+        opa_value *other = opa_value_get(b, &key->hdr);
+
+        if (other == NULL)
+        {
+            __json_patch_op(patch, "remove", child, NULL);
+        }
+        else
+        {
+            __json_diff(patch, child, opa_value_get(a, &key->hdr), other);
+            opa_free(child);
+        }
+    }
+
+    keys = opa_object_keys(opa_cast_object(b));
+
+    for (size_t i = 0; i < keys->len; i++)
+    {
+        opa_string_t *key = opa_cast_string(keys->elems[i].v);
+
+        if (opa_value_get(a, &key->hdr) == NULL)
+        {
+            char *child = __json_pointer_append(path, key->v, key->len);
+            __json_patch_op(patch, "add", child, opa_value_get(b, &key->hdr));
+        }
+    }
+}
+
+// Bounds the size of the table of the longest common subsequence of two
+// arrays. Larger arrays are compared element by element.
+#define JSON_DIFF_MAX_LCS (1 << 20)
+
+// Keeps the longest common subsequence of the elements of a and b. The
+// elements removed and added between two kept elements are diffed pairwise,
+// and the surplus ones are removed or added.
+static void __json_diff_arrays(opa_array_t *patch, const char *path, opa_array_t *a, opa_array_t *b)
+{
+    // Common prefixes and suffixes are kept.
+    size_t prefix = 0;
+
+    while (prefix < a->len && prefix < b->len &&
+           opa_value_compare(a->elems[prefix].v, b->elems[prefix].v) == 0)
+    {
+        prefix++;
+    }
+
+    size_t suffix = 0;
+
+    while (suffix < a->len - prefix && suffix < b->len - prefix &&
+           opa_value_compare(a->elems[a->len - 1 - suffix].v, b->elems[b->len - 1 - suffix].v) == 0)
+    {
+        suffix++;
+    }
+
+    size_t n = a->len - prefix - suffix;
+    size_t m = b->len - prefix - suffix;
+    opa_array_elem_t *as = a->elems + prefix;
+    opa_array_elem_t *bs = b->elems + prefix;
+
+    // Pairs of indices of kept elements, in order, followed by (n, m).
+    size_t *keep = opa_malloc(sizeof(size_t) * 2 * ((n < m ? n : m) + 1));
+    size_t kept = 0;
+
+    if (n * m <= JSON_DIFF_MAX_LCS)
+    {
+        // lcs[i*(m+1)+j] is the length of the longest common subsequence of
+        // the elements from i and j on.
+        size_t *lcs = opa_malloc(sizeof(size_t) * (n + 1) * (m + 1));
+
+        for (size_t i = 0; i <= n; i++)
+        {
+            lcs[i * (m + 1) + m] = 0;
+        }
+
+        for (size_t j = 0; j <= m; j++)
+        {
+            lcs[n * (m + 1) + j] = 0;
+        }
+
+        for (size_t i = n; i-- > 0;)
+        {
+            for (size_t j = m; j-- > 0;)
+            {
+                size_t down = lcs[(i + 1) * (m + 1) + j];
+                size_t right = lcs[i * (m + 1) + j + 1];
+
+                if (opa_value_compare(as[i].v, bs[j].v) == 0)
+                {
+                    lcs[i * (m + 1) + j] = lcs[(i + 1) * (m + 1) + j + 1] + 1;
+                }
+                else
+                {
+                    lcs[i * (m + 1) + j] = down >= right ? down : right;
+                }
+            }
+        }
+
+        for (size_t i = 0, j = 0; i < n && j < m;)
+        {
+            if (opa_value_compare(as[i].v, bs[j].v) == 0)
+            {
+                keep[2 * kept] = i++;
+                keep[2 * kept + 1] = j++;
+                kept++;
+            }
+            else if (lcs[(i + 1) * (m + 1) + j] >= lcs[i * (m + 1) + j + 1])
+            {
+                i++;
+            }
+            else
+            {
+                j++;
+            }
+        }
+
+        opa_free(lcs);
+    }
+
+    keep[2 * kept] = n;
+    keep[2 * kept + 1] = m;
+    kept++;
+
+    // idx is the index in the patched array of the next element of a.
+    size_t idx = prefix;
+    size_t i = 0, j = 0;
+
+    for (size_t k = 0; k < kept; k++)
+    {
+        for (; i < keep[2 * k] && j < keep[2 * k + 1]; i++, j++)
+        {
+            char *child = __json_pointer_append_index(path, idx++);
+            __json_diff(patch, child, as[i].v, bs[j].v);
+            opa_free(child);
+        }
+
+        for (; i < keep[2 * k]; i++)
+        {
+            __json_patch_op(patch, "remove", __json_pointer_append_index(path, idx), NULL);
+        }
+
+        for (; j < keep[2 * k + 1]; j++)
+        {
+            __json_patch_op(patch, "add", __json_pointer_append_index(path, idx++), bs[j].v);
+        }
+
+        // Skip the kept element.
+        i++;
+        j++;
+        idx++;
+    }
+
+    opa_free(keep);
+}
+
+// Appends the JSON-Patch operations that turn a into b to patch. Sets, and
+// objects with non-string keys, cannot be addressed by JSON pointers, and are
+// replaced as a whole.
+static void __json_diff(opa_array_t *patch, const char *path, opa_value *a, opa_value *b)
+{
+    if (opa_value_compare(a, b) == 0)
+    {
+        return;
+    }
+
+    if (opa_value_type(a) == OPA_OBJECT && opa_value_type(b) == OPA_OBJECT &&
+        __has_string_keys(a) && __has_string_keys(b))
+    {
+        __json_diff_objects(patch, path, a, b);
+        return;
+    }
+
+    if (opa_value_type(a) == OPA_ARRAY && opa_value_type(b) == OPA_ARRAY)
+    {
+        __json_diff_arrays(patch, path, opa_cast_array(a), opa_cast_array(b));
+        return;
+    }
+
+    __json_patch_op(patch, "replace", __json_pointer_copy(path), b);
+}
+
+OPA_BUILTIN
+opa_value *builtin_json_diff(opa_value *a, opa_value *b)
+{
+    opa_array_t *patch = opa_cast_array(opa_array());
+    __json_diff(patch, "", a, b);
+    return &patch->hdr;
+}
+
+// Applies a JSON Merge Patch (RFC 7386) to target: members of patch objects
+// are merged recursively into target objects, and null members remove the
+// target members. Other patches replace the target.
+static opa_value *__json_merge_patch(opa_value *target, opa_value *patch)
+{
+    if (opa_value_type(patch) != OPA_OBJECT)
+    {
+        return patch;
+    }
+
+    opa_object_t *result = opa_cast_object(opa_object());
+    bool merge = opa_value_type(target) == OPA_OBJECT;
+
+    if (merge)
+    {
+        for (opa_value *key = opa_value_iter(target, NULL); key != NULL;
+             key = opa_value_iter(target, key))
+        {
+            if (opa_value_get(patch, key) == NULL)
+            {
+                opa_object_insert(result, key, opa_value_get(target, key));
+            }
+        }
+    }
+
+    for (opa_value *key = opa_value_iter(patch, NULL); key != NULL;
+         key = opa_value_iter(patch, key))
+    {
+        opa_value *v = opa_value_get(patch, key);
+
+        if (opa_value_type(v) == OPA_NULL)
+        {
+            continue;
+        }
+
+        opa_value *existing = merge ? opa_value_get(target, key) : NULL;
+
+        if (existing == NULL)
+        {
+            existing = opa_null();
+        }
+
+        opa_object_insert(result, key, __json_merge_patch(existing, v));
+    }
+
+    return &result->hdr;
+}
+
+OPA_BUILTIN
+opa_value *builtin_json_merge_patch(opa_value *doc, opa_value *patch)
+{
+    return __json_merge_patch(doc, patch);
+}
@@ -11,5 +11,7 @@ opa_value *builtin_object_remove(opa_value *obj, opa_value *keys);
 opa_value *builtin_object_union(opa_value *a, opa_value *b);
 opa_value *builtin_json_remove(opa_value *obj, opa_value *paths);
 opa_value *builtin_json_filter(opa_value *obj, opa_value *paths);
+opa_value *builtin_json_diff(opa_value *a, opa_value *b);
+opa_value *builtin_json_merge_patch(opa_value *doc, opa_value *patch);
 
 #endif
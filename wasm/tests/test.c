@@ -2550,6 +2550,74 @@ void test_json_filter(void)
     test("jsonfilter/error (invalid second operand - object)", opa_value_compare(builtin_json_filter(opa_object(), opa_object()), NULL) == 0);
 }
 
+static opa_value *json(const char *s)
+{
+    return opa_json_parse(s, opa_strlen(s));
+}
+
+WASM_EXPORT(test_json_diff)
+void test_json_diff(void)
+{
+    test("jsondiff/equal", opa_value_compare(builtin_json_diff(json("{\"a\":[1,{\"b\":2}]}"), json("{\"a\":[1,{\"b\":2}]}")), json("[]")) == 0);
+    test("jsondiff/objects", opa_value_compare(builtin_json_diff(json("{\"a\":1,\"b\":{\"c\":2,\"d\":3},\"e\":4}"), json("{\"b\":{\"c\":2,\"d\":5},\"e\":4,\"f\":6}")),
+                                               json("[{\"op\":\"remove\",\"path\":\"/a\"},{\"op\":\"replace\",\"path\":\"/b/d\",\"value\":5},{\"op\":\"add\",\"path\":\"/f\",\"value\":6}]")) == 0);
+    test("jsondiff/escaped keys", opa_value_compare(builtin_json_diff(json("{\"a/b\":{\"c~d\":1}}"), json("{\"a/b\":{\"c~d\":2}}")),
+                                                    json("[{\"op\":\"replace\",\"path\":\"/a~1b/c~0d\",\"value\":2}]")) == 0);
+    test("jsondiff/root", opa_value_compare(builtin_json_diff(json("{\"a\":1}"), json("[1]")),
+                                            json("[{\"op\":\"replace\",\"path\":\"\",\"value\":[1]}]")) == 0);
+    test("jsondiff/arrays insert and remove", opa_value_compare(builtin_json_diff(json("[1,2,3,4,5]"), json("[0,1,3,4,6,5]")),
+                                                                json("[{\"op\":\"add\",\"path\":\"/0\",\"value\":0},{\"op\":\"remove\",\"path\":\"/2\"},{\"op\":\"add\",\"path\":\"/4\",\"value\":6}]")) == 0);
+    test("jsondiff/arrays replace and append", opa_value_compare(builtin_json_diff(json("[{\"name\":\"a\",\"image\":\"a:1\"},{\"name\":\"b\"}]"), json("[{\"name\":\"a\",\"image\":\"a:2\"},{\"name\":\"b\"},{\"name\":\"c\"}]")),
+                                                                 json("[{\"op\":\"replace\",\"path\":\"/0/image\",\"value\":\"a:2\"},{\"op\":\"add\",\"path\":\"/2\",\"value\":{\"name\":\"c\"}}]")) == 0);
+    test("jsondiff/arrays remove all", opa_value_compare(builtin_json_diff(json("[1,2]"), json("[]")),
+                                                         json("[{\"op\":\"remove\",\"path\":\"/0\"},{\"op\":\"remove\",\"path\":\"/0\"}]")) == 0);
+
+    opa_set_t *s1 = opa_cast_set(opa_set());
+    opa_set_add(s1, opa_number_int(1));
+    opa_set_t *s2 = opa_cast_set(opa_set());
+    opa_set_add(s2, opa_number_int(2));
+    opa_object_t *a = opa_cast_object(opa_object());
+    opa_object_insert(a, opa_string_terminated("s"), &s1->hdr);
+    opa_object_t *b = opa_cast_object(opa_object());
+    opa_object_insert(b, opa_string_terminated("s"), &s2->hdr);
+    opa_object_t *op = opa_cast_object(opa_object());
+    opa_object_insert(op, opa_string_terminated("op"), opa_string_terminated("replace"));
+    opa_object_insert(op, opa_string_terminated("path"), opa_string_terminated("/s"));
+    opa_object_insert(op, opa_string_terminated("value"), &s2->hdr);
+    opa_array_t *expected = opa_cast_array(opa_array());
+    opa_array_append(expected, &op->hdr);
+    test("jsondiff/sets are replaced", opa_value_compare(builtin_json_diff(&a->hdr, &b->hdr), &expected->hdr) == 0);
+}
+
+WASM_EXPORT(test_json_merge_patch)
+void test_json_merge_patch(void)
+{
+    // The examples of RFC 7386, Appendix A.
+    const char *cases[][3] = {
+        {"{\"a\":\"b\"}", "{\"a\":\"c\"}", "{\"a\":\"c\"}"},
+        {"{\"a\":\"b\"}", "{\"b\":\"c\"}", "{\"a\":\"b\",\"b\":\"c\"}"},
+        {"{\"a\":\"b\"}", "{\"a\":null}", "{}"},
+        {"{\"a\":\"b\",\"b\":\"c\"}", "{\"a\":null}", "{\"b\":\"c\"}"},
+        {"{\"a\":[\"b\"]}", "{\"a\":\"c\"}", "{\"a\":\"c\"}"},
+        {"{\"a\":\"c\"}", "{\"a\":[\"b\"]}", "{\"a\":[\"b\"]}"},
+        {"{\"a\":{\"b\":\"c\"}}", "{\"a\":{\"b\":\"d\",\"c\":null}}", "{\"a\":{\"b\":\"d\"}}"},
+        {"{\"a\":[{\"b\":\"c\"}]}", "{\"a\":[1]}", "{\"a\":[1]}"},
+        {"[\"a\",\"b\"]", "[\"c\",\"d\"]", "[\"c\",\"d\"]"},
+        {"{\"a\":\"b\"}", "[\"c\"]", "[\"c\"]"},
+        {"{\"a\":\"foo\"}", "null", "null"},
+        {"{\"a\":\"foo\"}", "\"bar\"", "\"bar\""},
+        {"{\"e\":null}", "{\"a\":1}", "{\"e\":null,\"a\":1}"},
+        {"[1,2]", "{\"a\":\"b\",\"c\":null}", "{\"a\":\"b\"}"},
+        {"{}", "{\"a\":{\"bb\":{\"ccc\":null}}}", "{\"a\":{\"bb\":{}}}"},
+    };
+
+    for (int i = 0; i < sizeof(cases) / sizeof(cases[0]); i++)
+    {
+        opa_value *result = builtin_json_merge_patch(json(cases[i][0]), json(cases[i][1]));
+        test(cases[i][1], opa_value_compare(result, json(cases[i][2])) == 0);
+    }
+}
+
 WASM_EXPORT(test_builtin_graph_reachable)
 void test_builtin_graph_reachable(void)
 {